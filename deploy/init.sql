@@ -42,7 +42,9 @@ CREATE TABLE public.appointment_schedules (
     day smallint NOT NULL,
     duration bigint NOT NULL,
     padding bigint NOT NULL,
-    schedule text NOT NULL
+    schedule text NOT NULL,
+    signup_cutoff bigint DEFAULT 0 NOT NULL,
+    signups_open bigint DEFAULT 0 NOT NULL
 );
 
 
@@ -64,12 +66,250 @@ CREATE TABLE public.appointment_slots (
     location text,
     description text,
     map_x real,
-    map_y real
+    map_y real,
+    updated_at timestamp with time zone DEFAULT now() NOT NULL,
+    meeting_link text,
+    anonymous_to_peers boolean DEFAULT false NOT NULL,
+    slot_span integer DEFAULT 1 NOT NULL,
+    resolved boolean
 );
 
 
 ALTER TABLE public.appointment_slots OWNER TO queue;
 
+--
+-- Name: pending_schedule_changes; Type: TABLE; Schema: public; Owner: queue
+--
+
+CREATE TABLE public.pending_schedule_changes (
+    queue character(27) NOT NULL COLLATE pg_catalog."C",
+    day smallint NOT NULL,
+    duration bigint NOT NULL,
+    padding bigint NOT NULL,
+    schedule text NOT NULL,
+    signup_cutoff bigint NOT NULL,
+    signups_open bigint NOT NULL,
+    proposed_by text NOT NULL,
+    created_at timestamp with time zone DEFAULT now() NOT NULL
+);
+
+
+ALTER TABLE public.pending_schedule_changes OWNER TO queue;
+
+--
+-- Name: pending_approval_removals; Type: TABLE; Schema: public; Owner: queue
+--
+
+CREATE TABLE public.pending_approval_removals (
+    queue character(27) NOT NULL COLLATE pg_catalog."C",
+    proposed_by text NOT NULL,
+    created_at timestamp with time zone DEFAULT now() NOT NULL
+);
+
+
+ALTER TABLE public.pending_approval_removals OWNER TO queue;
+
+--
+-- Name: appointment_timeslot_notes; Type: TABLE; Schema: public; Owner: queue
+--
+
+CREATE TABLE public.appointment_timeslot_notes (
+    queue character(27) NOT NULL COLLATE pg_catalog."C",
+    day smallint NOT NULL,
+    timeslot integer NOT NULL,
+    note text NOT NULL
+);
+
+
+ALTER TABLE public.appointment_timeslot_notes OWNER TO queue;
+
+--
+-- Name: appointment_calendar_events; Type: TABLE; Schema: public; Owner: queue
+--
+
+CREATE TABLE public.appointment_calendar_events (
+    appointment character(27) NOT NULL COLLATE pg_catalog."C",
+    email text NOT NULL,
+    event_id text NOT NULL
+);
+
+
+ALTER TABLE public.appointment_calendar_events OWNER TO queue;
+
+--
+-- Name: google_calendar_tokens; Type: TABLE; Schema: public; Owner: queue
+--
+
+CREATE TABLE public.google_calendar_tokens (
+    email text NOT NULL,
+    access_token text NOT NULL,
+    refresh_token text NOT NULL,
+    token_type text NOT NULL,
+    expiry timestamp with time zone NOT NULL
+);
+
+
+ALTER TABLE public.google_calendar_tokens OWNER TO queue;
+
+--
+-- Name: appointment_timeslot_assignments; Type: TABLE; Schema: public; Owner: queue
+--
+
+CREATE TABLE public.appointment_timeslot_assignments (
+    queue character(27) NOT NULL COLLATE pg_catalog."C",
+    day smallint NOT NULL,
+    timeslot integer NOT NULL,
+    email text NOT NULL
+);
+
+
+ALTER TABLE public.appointment_timeslot_assignments OWNER TO queue;
+
+--
+-- Name: appointment_custom_field_definitions; Type: TABLE; Schema: public; Owner: queue
+--
+
+CREATE TABLE public.appointment_custom_field_definitions (
+    queue character(27) NOT NULL COLLATE pg_catalog."C",
+    name text NOT NULL,
+    type text NOT NULL,
+    required boolean DEFAULT false NOT NULL,
+    "position" integer NOT NULL
+);
+
+
+ALTER TABLE public.appointment_custom_field_definitions OWNER TO queue;
+
+--
+-- Name: appointment_custom_fields; Type: TABLE; Schema: public; Owner: queue
+--
+
+CREATE TABLE public.appointment_custom_fields (
+    appointment character(27) NOT NULL COLLATE pg_catalog."C",
+    name text NOT NULL,
+    value text NOT NULL
+);
+
+
+ALTER TABLE public.appointment_custom_fields OWNER TO queue;
+
+--
+-- Name: appointment_timeslot_locations; Type: TABLE; Schema: public; Owner: queue
+--
+
+CREATE TABLE public.appointment_timeslot_locations (
+    queue character(27) NOT NULL COLLATE pg_catalog."C",
+    day smallint NOT NULL,
+    timeslot integer NOT NULL,
+    location text NOT NULL
+);
+
+
+ALTER TABLE public.appointment_timeslot_locations OWNER TO queue;
+
+--
+-- Name: appointment_map_regions; Type: TABLE; Schema: public; Owner: queue
+--
+
+CREATE TABLE public.appointment_map_regions (
+    queue character(27) NOT NULL COLLATE pg_catalog."C",
+    name text NOT NULL,
+    points text NOT NULL,
+    "position" integer NOT NULL
+);
+
+
+ALTER TABLE public.appointment_map_regions OWNER TO queue;
+
+--
+-- Name: appointment_schedule_audit; Type: TABLE; Schema: public; Owner: queue
+--
+
+CREATE TABLE public.appointment_schedule_audit (
+    id character(27) NOT NULL COLLATE pg_catalog."C",
+    queue character(27) NOT NULL COLLATE pg_catalog."C",
+    day integer NOT NULL,
+    email text NOT NULL,
+    before text NOT NULL,
+    after text NOT NULL,
+    created_at timestamp with time zone DEFAULT now() NOT NULL
+);
+
+
+ALTER TABLE public.appointment_schedule_audit OWNER TO queue;
+
+--
+-- Name: appointment_waitlist; Type: TABLE; Schema: public; Owner: queue
+--
+
+CREATE TABLE public.appointment_waitlist (
+    id character(27) NOT NULL COLLATE pg_catalog."C",
+    queue character(27) NOT NULL COLLATE pg_catalog."C",
+    scheduled_time timestamp with time zone NOT NULL,
+    timeslot integer NOT NULL,
+    email text NOT NULL
+);
+
+
+ALTER TABLE public.appointment_waitlist OWNER TO queue;
+
+--
+-- Name: appointment_claim_events; Type: TABLE; Schema: public; Owner: queue
+--
+
+CREATE TABLE public.appointment_claim_events (
+    id character(27) NOT NULL COLLATE pg_catalog."C",
+    queue character(27) NOT NULL COLLATE pg_catalog."C",
+    appointment character(27) NOT NULL COLLATE pg_catalog."C",
+    scheduled_time timestamp with time zone NOT NULL,
+    timeslot integer NOT NULL,
+    action text NOT NULL,
+    email text NOT NULL,
+    staff_email text NOT NULL,
+    created_at timestamp with time zone DEFAULT now() NOT NULL
+);
+
+
+ALTER TABLE public.appointment_claim_events OWNER TO queue;
+
+--
+-- Name: appointment_labels; Type: TABLE; Schema: public; Owner: queue
+--
+
+CREATE TABLE public.appointment_labels (
+    appointment character(27) NOT NULL COLLATE pg_catalog."C",
+    label text NOT NULL
+);
+
+
+ALTER TABLE public.appointment_labels OWNER TO queue;
+
+--
+-- Name: appointment_partners; Type: TABLE; Schema: public; Owner: queue
+--
+
+CREATE TABLE public.appointment_partners (
+    appointment character(27) NOT NULL COLLATE pg_catalog."C",
+    email text NOT NULL
+);
+
+
+ALTER TABLE public.appointment_partners OWNER TO queue;
+
+--
+-- Name: appointment_tombstones; Type: TABLE; Schema: public; Owner: queue
+--
+
+CREATE TABLE public.appointment_tombstones (
+    id character(27) NOT NULL COLLATE pg_catalog."C",
+    queue character(27) NOT NULL COLLATE pg_catalog."C",
+    scheduled_time timestamp with time zone NOT NULL,
+    removed_at timestamp with time zone DEFAULT now() NOT NULL
+);
+
+
+ALTER TABLE public.appointment_tombstones OWNER TO queue;
+
 --
 -- Name: course_admins; Type: TABLE; Schema: public; Owner: queue
 --
@@ -173,7 +413,26 @@ CREATE TABLE public.queues (
     scheduled boolean DEFAULT false NOT NULL,
     manual_open boolean DEFAULT false NOT NULL,
     type text NOT NULL,
-    name text NOT NULL
+    name text NOT NULL,
+    future_appointment_scope text DEFAULT 'queue'::text NOT NULL,
+    appointment_location_type text DEFAULT 'in_person'::text NOT NULL,
+    availability_display text DEFAULT 'exact'::text NOT NULL,
+    max_concurrent_appointments integer DEFAULT 0 NOT NULL,
+    show_timeslot_members boolean DEFAULT false NOT NULL,
+    disallow_same_day_reschedule boolean DEFAULT false NOT NULL,
+    optional_appointment_description boolean DEFAULT false NOT NULL,
+    optional_appointment_location boolean DEFAULT false NOT NULL,
+    require_schedule_approval boolean DEFAULT false NOT NULL,
+    max_appointment_field_length integer DEFAULT 0 NOT NULL,
+    min_appointment_description_length integer DEFAULT 0 NOT NULL,
+    claim_grace_minutes integer DEFAULT 0 NOT NULL,
+    description_template text DEFAULT ''::text NOT NULL,
+    require_description_template boolean DEFAULT false NOT NULL,
+    signups_frozen boolean DEFAULT false NOT NULL,
+    require_signup_time_confirmation boolean DEFAULT false NOT NULL,
+    fully_booked_message text DEFAULT ''::text NOT NULL,
+    waitlist boolean DEFAULT false NOT NULL,
+    auto_waitlist boolean DEFAULT false NOT NULL
 );
 
 
@@ -250,6 +509,142 @@ ALTER TABLE ONLY public.appointment_slots
     ADD CONSTRAINT appointment_slots_pkey PRIMARY KEY (id);
 
 
+--
+-- Name: pending_schedule_changes pending_schedule_changes_pkey; Type: CONSTRAINT; Schema: public; Owner: queue
+--
+
+ALTER TABLE ONLY public.pending_schedule_changes
+    ADD CONSTRAINT pending_schedule_changes_pkey PRIMARY KEY (queue, day);
+
+
+--
+-- Name: pending_approval_removals pending_approval_removals_pkey; Type: CONSTRAINT; Schema: public; Owner: queue
+--
+
+ALTER TABLE ONLY public.pending_approval_removals
+    ADD CONSTRAINT pending_approval_removals_pkey PRIMARY KEY (queue);
+
+
+--
+-- Name: appointment_timeslot_notes appointment_timeslot_notes_pkey; Type: CONSTRAINT; Schema: public; Owner: queue
+--
+
+ALTER TABLE ONLY public.appointment_timeslot_notes
+    ADD CONSTRAINT appointment_timeslot_notes_pkey PRIMARY KEY (queue, day, timeslot);
+
+
+--
+-- Name: appointment_calendar_events appointment_calendar_events_pkey; Type: CONSTRAINT; Schema: public; Owner: queue
+--
+
+ALTER TABLE ONLY public.appointment_calendar_events
+    ADD CONSTRAINT appointment_calendar_events_pkey PRIMARY KEY (appointment, email);
+
+
+--
+-- Name: google_calendar_tokens google_calendar_tokens_pkey; Type: CONSTRAINT; Schema: public; Owner: queue
+--
+
+ALTER TABLE ONLY public.google_calendar_tokens
+    ADD CONSTRAINT google_calendar_tokens_pkey PRIMARY KEY (email);
+
+
+--
+-- Name: appointment_timeslot_assignments appointment_timeslot_assignments_pkey; Type: CONSTRAINT; Schema: public; Owner: queue
+--
+
+ALTER TABLE ONLY public.appointment_timeslot_assignments
+    ADD CONSTRAINT appointment_timeslot_assignments_pkey PRIMARY KEY (queue, day, timeslot);
+
+
+--
+-- Name: appointment_custom_field_definitions appointment_custom_field_definitions_pkey; Type: CONSTRAINT; Schema: public; Owner: queue
+--
+
+ALTER TABLE ONLY public.appointment_custom_field_definitions
+    ADD CONSTRAINT appointment_custom_field_definitions_pkey PRIMARY KEY (queue, name);
+
+
+--
+-- Name: appointment_custom_fields appointment_custom_fields_pkey; Type: CONSTRAINT; Schema: public; Owner: queue
+--
+
+ALTER TABLE ONLY public.appointment_custom_fields
+    ADD CONSTRAINT appointment_custom_fields_pkey PRIMARY KEY (appointment, name);
+
+
+--
+-- Name: appointment_timeslot_locations appointment_timeslot_locations_pkey; Type: CONSTRAINT; Schema: public; Owner: queue
+--
+
+ALTER TABLE ONLY public.appointment_timeslot_locations
+    ADD CONSTRAINT appointment_timeslot_locations_pkey PRIMARY KEY (queue, day, timeslot);
+
+
+--
+-- Name: appointment_map_regions appointment_map_regions_pkey; Type: CONSTRAINT; Schema: public; Owner: queue
+--
+
+ALTER TABLE ONLY public.appointment_map_regions
+    ADD CONSTRAINT appointment_map_regions_pkey PRIMARY KEY (queue, name);
+
+
+--
+-- Name: appointment_schedule_audit appointment_schedule_audit_pkey; Type: CONSTRAINT; Schema: public; Owner: queue
+--
+
+ALTER TABLE ONLY public.appointment_schedule_audit
+    ADD CONSTRAINT appointment_schedule_audit_pkey PRIMARY KEY (id);
+
+
+--
+-- Name: appointment_waitlist appointment_waitlist_pkey; Type: CONSTRAINT; Schema: public; Owner: queue
+--
+
+ALTER TABLE ONLY public.appointment_waitlist
+    ADD CONSTRAINT appointment_waitlist_pkey PRIMARY KEY (id);
+
+
+--
+-- Name: appointment_waitlist appointment_waitlist_queue_scheduled_time_email_key; Type: CONSTRAINT; Schema: public; Owner: queue
+--
+
+ALTER TABLE ONLY public.appointment_waitlist
+    ADD CONSTRAINT appointment_waitlist_queue_scheduled_time_email_key UNIQUE (queue, scheduled_time, email);
+
+
+--
+-- Name: appointment_claim_events appointment_claim_events_pkey; Type: CONSTRAINT; Schema: public; Owner: queue
+--
+
+ALTER TABLE ONLY public.appointment_claim_events
+    ADD CONSTRAINT appointment_claim_events_pkey PRIMARY KEY (id);
+
+
+--
+-- Name: appointment_labels appointment_labels_pkey; Type: CONSTRAINT; Schema: public; Owner: queue
+--
+
+ALTER TABLE ONLY public.appointment_labels
+    ADD CONSTRAINT appointment_labels_pkey PRIMARY KEY (appointment, label);
+
+
+--
+-- Name: appointment_partners appointment_partners_pkey; Type: CONSTRAINT; Schema: public; Owner: queue
+--
+
+ALTER TABLE ONLY public.appointment_partners
+    ADD CONSTRAINT appointment_partners_pkey PRIMARY KEY (appointment, email);
+
+
+--
+-- Name: appointment_tombstones appointment_tombstones_pkey; Type: CONSTRAINT; Schema: public; Owner: queue
+--
+
+ALTER TABLE ONLY public.appointment_tombstones
+    ADD CONSTRAINT appointment_tombstones_pkey PRIMARY KEY (id);
+
+
 --
 -- Name: course_admins course_admins_course_email_key; Type: CONSTRAINT; Schema: public; Owner: queue
 --
@@ -322,6 +717,34 @@ ALTER TABLE ONLY public.site_admins
     ADD CONSTRAINT site_admins_pkey PRIMARY KEY (email);
 
 
+--
+-- Name: appointment_slots_queue_updated_at_idx; Type: INDEX; Schema: public; Owner: queue
+--
+
+CREATE INDEX appointment_slots_queue_updated_at_idx ON public.appointment_slots USING btree (queue, updated_at);
+
+
+--
+-- Name: appointment_claim_events_queue_scheduled_time_idx; Type: INDEX; Schema: public; Owner: queue
+--
+
+CREATE INDEX appointment_claim_events_queue_scheduled_time_idx ON public.appointment_claim_events USING btree (queue, scheduled_time);
+
+
+--
+-- Name: appointment_partners_email_idx; Type: INDEX; Schema: public; Owner: queue
+--
+
+CREATE INDEX appointment_partners_email_idx ON public.appointment_partners USING btree (email);
+
+
+--
+-- Name: appointment_tombstones_queue_removed_at_idx; Type: INDEX; Schema: public; Owner: queue
+--
+
+CREATE INDEX appointment_tombstones_queue_removed_at_idx ON public.appointment_tombstones USING btree (queue, removed_at);
+
+
 --
 -- Name: queue_entries_queue_idx; Type: INDEX; Schema: public; Owner: queue
 --
@@ -367,6 +790,126 @@ ALTER TABLE ONLY public.appointment_slots
     ADD CONSTRAINT appointment_slots_queue_fkey FOREIGN KEY (queue) REFERENCES public.queues(id) ON DELETE CASCADE;
 
 
+--
+-- Name: pending_schedule_changes pending_schedule_changes_queue_fkey; Type: FK CONSTRAINT; Schema: public; Owner: queue
+--
+
+ALTER TABLE ONLY public.pending_schedule_changes
+    ADD CONSTRAINT pending_schedule_changes_queue_fkey FOREIGN KEY (queue) REFERENCES public.queues(id) ON DELETE CASCADE;
+
+
+--
+-- Name: pending_approval_removals pending_approval_removals_queue_fkey; Type: FK CONSTRAINT; Schema: public; Owner: queue
+--
+
+ALTER TABLE ONLY public.pending_approval_removals
+    ADD CONSTRAINT pending_approval_removals_queue_fkey FOREIGN KEY (queue) REFERENCES public.queues(id) ON DELETE CASCADE;
+
+
+--
+-- Name: appointment_timeslot_notes appointment_timeslot_notes_queue_fkey; Type: FK CONSTRAINT; Schema: public; Owner: queue
+--
+
+ALTER TABLE ONLY public.appointment_timeslot_notes
+    ADD CONSTRAINT appointment_timeslot_notes_queue_fkey FOREIGN KEY (queue) REFERENCES public.queues(id) ON DELETE CASCADE;
+
+
+--
+-- Name: appointment_calendar_events appointment_calendar_events_appointment_fkey; Type: FK CONSTRAINT; Schema: public; Owner: queue
+--
+
+ALTER TABLE ONLY public.appointment_calendar_events
+    ADD CONSTRAINT appointment_calendar_events_appointment_fkey FOREIGN KEY (appointment) REFERENCES public.appointment_slots(id) ON DELETE CASCADE;
+
+
+--
+-- Name: appointment_timeslot_assignments appointment_timeslot_assignments_queue_fkey; Type: FK CONSTRAINT; Schema: public; Owner: queue
+--
+
+ALTER TABLE ONLY public.appointment_timeslot_assignments
+    ADD CONSTRAINT appointment_timeslot_assignments_queue_fkey FOREIGN KEY (queue) REFERENCES public.queues(id) ON DELETE CASCADE;
+
+
+--
+-- Name: appointment_custom_field_definitions appointment_custom_field_definitions_queue_fkey; Type: FK CONSTRAINT; Schema: public; Owner: queue
+--
+
+ALTER TABLE ONLY public.appointment_custom_field_definitions
+    ADD CONSTRAINT appointment_custom_field_definitions_queue_fkey FOREIGN KEY (queue) REFERENCES public.queues(id) ON DELETE CASCADE;
+
+
+--
+-- Name: appointment_custom_fields appointment_custom_fields_appointment_fkey; Type: FK CONSTRAINT; Schema: public; Owner: queue
+--
+
+ALTER TABLE ONLY public.appointment_custom_fields
+    ADD CONSTRAINT appointment_custom_fields_appointment_fkey FOREIGN KEY (appointment) REFERENCES public.appointment_slots(id) ON DELETE CASCADE;
+
+
+--
+-- Name: appointment_timeslot_locations appointment_timeslot_locations_queue_fkey; Type: FK CONSTRAINT; Schema: public; Owner: queue
+--
+
+ALTER TABLE ONLY public.appointment_timeslot_locations
+    ADD CONSTRAINT appointment_timeslot_locations_queue_fkey FOREIGN KEY (queue) REFERENCES public.queues(id) ON DELETE CASCADE;
+
+
+--
+-- Name: appointment_map_regions appointment_map_regions_queue_fkey; Type: FK CONSTRAINT; Schema: public; Owner: queue
+--
+
+ALTER TABLE ONLY public.appointment_map_regions
+    ADD CONSTRAINT appointment_map_regions_queue_fkey FOREIGN KEY (queue) REFERENCES public.queues(id) ON DELETE CASCADE;
+
+
+--
+-- Name: appointment_schedule_audit appointment_schedule_audit_queue_fkey; Type: FK CONSTRAINT; Schema: public; Owner: queue
+--
+
+ALTER TABLE ONLY public.appointment_schedule_audit
+    ADD CONSTRAINT appointment_schedule_audit_queue_fkey FOREIGN KEY (queue) REFERENCES public.queues(id) ON DELETE CASCADE;
+
+
+--
+-- Name: appointment_waitlist appointment_waitlist_queue_fkey; Type: FK CONSTRAINT; Schema: public; Owner: queue
+--
+
+ALTER TABLE ONLY public.appointment_waitlist
+    ADD CONSTRAINT appointment_waitlist_queue_fkey FOREIGN KEY (queue) REFERENCES public.queues(id) ON DELETE CASCADE;
+
+
+--
+-- Name: appointment_claim_events appointment_claim_events_queue_fkey; Type: FK CONSTRAINT; Schema: public; Owner: queue
+--
+
+ALTER TABLE ONLY public.appointment_claim_events
+    ADD CONSTRAINT appointment_claim_events_queue_fkey FOREIGN KEY (queue) REFERENCES public.queues(id) ON DELETE CASCADE;
+
+
+--
+-- Name: appointment_labels appointment_labels_appointment_fkey; Type: FK CONSTRAINT; Schema: public; Owner: queue
+--
+
+ALTER TABLE ONLY public.appointment_labels
+    ADD CONSTRAINT appointment_labels_appointment_fkey FOREIGN KEY (appointment) REFERENCES public.appointment_slots(id) ON DELETE CASCADE;
+
+
+--
+-- Name: appointment_partners appointment_partners_appointment_fkey; Type: FK CONSTRAINT; Schema: public; Owner: queue
+--
+
+ALTER TABLE ONLY public.appointment_partners
+    ADD CONSTRAINT appointment_partners_appointment_fkey FOREIGN KEY (appointment) REFERENCES public.appointment_slots(id) ON DELETE CASCADE;
+
+
+--
+-- Name: appointment_tombstones appointment_tombstones_queue_fkey; Type: FK CONSTRAINT; Schema: public; Owner: queue
+--
+
+ALTER TABLE ONLY public.appointment_tombstones
+    ADD CONSTRAINT appointment_tombstones_queue_fkey FOREIGN KEY (queue) REFERENCES public.queues(id) ON DELETE CASCADE;
+
+
 --
 -- Name: course_admins course_admins_course_fkey; Type: FK CONSTRAINT; Schema: public; Owner: queue
 --
@@ -1,21 +1,30 @@
 package api
 
 import (
+	"bytes"
 	"context"
+	"database/sql"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io/ioutil"
 	"net/http"
+	"sort"
 	"strconv"
+	"strings"
 	"time"
+	"unicode/utf8"
 
 	"github.com/go-chi/chi"
 	"github.com/segmentio/ksuid"
+	"go.uber.org/zap"
 )
 
 const (
 	appointmentDayContextKey      = "appointment_day"
 	appointmentTimeslotContextKey = "appointment_timeslot"
 	appointmentContextKey         = "appointment"
+	flexibleSignupContextKey      = "flexible_signup"
 )
 
 func (s *Server) AppointmentDayMiddleware(next http.Handler) http.Handler {
@@ -63,10 +72,63 @@ func (s *Server) AppointmentTimeslotMiddleware(next http.Handler) http.Handler {
 	})
 }
 
+// FlexibleTimeslotMiddleware lets students sign up for whichever of a
+// list of timeslots is open first. The timeslots come in the request body
+// alongside the appointment, in order of preference; the signup handler
+// works through them, checking each one's capacity as it goes, so a
+// timeslot filling up partway through just moves on to the next one.
+func (s *Server) FlexibleTimeslotMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		l := s.logger.With(
+			RequestIDContextKey, r.Context().Value(RequestIDContextKey),
+		)
+
+		// The signup handler needs to read the body too.
+		body, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, s.maxBodySize))
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			l.Warnw("request body too large")
+			s.errorMessage(errBodyTooLarge.status, errBodyTooLarge.message, w, r)
+			return
+		}
+		if err != nil {
+			l.Warnw("failed to read request body", "err", err)
+			s.errorMessage(
+				http.StatusBadRequest,
+				"We couldn't read your appointment in the request body.",
+				w, r,
+			)
+			return
+		}
+		r.Body = ioutil.NopCloser(bytes.NewReader(body))
+
+		var preferences struct {
+			Timeslots []int `json:"timeslots"`
+		}
+		err = json.Unmarshal(body, &preferences)
+		if err != nil || len(preferences.Timeslots) == 0 {
+			l.Warnw("failed to decode timeslot preferences", "err", err)
+			s.errorMessage(
+				http.StatusBadRequest,
+				"Let us know which timeslots work for you.",
+				w, r,
+			)
+			return
+		}
+
+		ctx := context.WithValue(r.Context(), flexibleSignupContextKey, preferences.Timeslots)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
 type getAppointment interface {
 	GetAppointment(ctx context.Context, appointment ksuid.KSUID) (*AppointmentSlot, error)
 }
 
+// appointmentNotFound is the code for requests about an appointment that
+// doesn't exist, whether or not the ID looked like one.
+const appointmentNotFound = "APPOINTMENT_NOT_FOUND"
+
 func (s *Server) AppointmentIDMiddleware(ga getAppointment) func(http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -79,9 +141,10 @@ func (s *Server) AppointmentIDMiddleware(ga getAppointment) func(http.Handler) h
 					"appointment_id", id,
 					"err", err,
 				)
-				s.errorMessage(
+				s.errorCode(
 					http.StatusNotFound,
-					"I called for help, but I couldn't find that appointment anywhere.",
+					appointmentNotFound,
+					"We couldn't find that appointment.",
 					w, r,
 				)
 				return
@@ -94,9 +157,34 @@ func (s *Server) AppointmentIDMiddleware(ga getAppointment) func(http.Handler) h
 					"appointment_id", id,
 					"err", err,
 				)
-				s.errorMessage(
+				s.errorCode(
+					http.StatusNotFound,
+					appointmentNotFound,
+					"We couldn't find that appointment. It may have just been canceled.",
+					w, r,
+				)
+				return
+			}
+
+			// Appointment IDs are global, so without this a queue's
+			// routes would work on any queue's appointments.
+			q, ok := r.Context().Value(queueContextKey).(*Queue)
+			if !ok {
+				s.missingContextValue(r, queueContextKey)
+				s.internalServerError(w, r)
+				return
+			}
+			if appointment.Queue != q.ID {
+				s.logger.Warnw("attempted to get appointment from other queue",
+					RequestIDContextKey, r.Context().Value(RequestIDContextKey),
+					"queue_id", q.ID,
+					"appointment_id", id,
+					"appointment_queue", appointment.Queue,
+				)
+				s.errorCode(
 					http.StatusNotFound,
-					"I called for help, but I couldn't find that appointment anywhere. Was it just deleted?",
+					appointmentNotFound,
+					"We couldn't find that appointment.",
 					w, r,
 				)
 				return
@@ -114,14 +202,55 @@ type getAppointmentsInTimeFrame interface {
 
 type getAppointments interface {
 	getAppointmentsInTimeFrame
+	getQueueConfiguration
 	GetAppointmentsWithStudent(ctx context.Context, queue ksuid.KSUID, from, to time.Time) ([]*AppointmentSlot, error)
+	GetAppointmentLabelsInTimeFrame(ctx context.Context, queue ksuid.KSUID, from, to time.Time) (map[ksuid.KSUID][]string, error)
+	getAppointmentCustomFieldsInTimeFrame
+}
+
+// hasLabels reports whether an appointment has every one of labels.
+func hasLabels(a *AppointmentSlot, labels []string) bool {
+	for _, want := range labels {
+		found := false
+		for _, l := range a.Labels {
+			if l == want {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+// atLocation reports whether an appointment is at location, ignoring case
+// and surrounding whitespace.
+func atLocation(a *AppointmentSlot, location string) bool {
+	return a.Location != nil && strings.EqualFold(strings.TrimSpace(*a.Location), location)
+}
+
+// needsCoverage reports whether an appointment has a student signed up but
+// no staff member to meet them, and hasn't happened yet.
+func needsCoverage(a *AppointmentSlot, now time.Time) bool {
+	return a.StudentEmail != nil && a.StaffEmail == nil && a.ScheduledTime.After(now)
 }
 
 func (s *Server) GetAppointments(ga getAppointments) E {
 	return func(w http.ResponseWriter, r *http.Request) error {
-		q := r.Context().Value(queueContextKey).(*Queue)
-		admin := r.Context().Value(courseAdminContextKey).(bool)
-		day := r.Context().Value(appointmentDayContextKey).(int)
+		q, ok := r.Context().Value(queueContextKey).(*Queue)
+		if !ok {
+			return s.missingContextValue(r, queueContextKey)
+		}
+		admin, ok := r.Context().Value(courseAdminContextKey).(bool)
+		if !ok {
+			return s.missingContextValue(r, courseAdminContextKey)
+		}
+		day, ok := r.Context().Value(appointmentDayContextKey).(int)
+		if !ok {
+			return s.missingContextValue(r, appointmentDayContextKey)
+		}
 
 		var appointments []*AppointmentSlot
 		var err error
@@ -129,6 +258,22 @@ func (s *Server) GetAppointments(ga getAppointments) E {
 		if admin {
 			appointments, err = ga.GetAppointments(r.Context(), q.ID, start, end)
 		} else {
+			config, err := ga.GetQueueConfiguration(r.Context(), q.ID)
+			if err != nil {
+				s.logger.Errorw("failed to get queue configuration",
+					RequestIDContextKey, r.Context().Value(RequestIDContextKey),
+					"err", err,
+				)
+				return err
+			}
+
+			// One row per booking would give away the exact counts that
+			// coarse availability is hiding. Students still get their own
+			// appointments from /@me.
+			if config.AvailabilityDisplay == AvailabilityDisplayCoarse {
+				return s.sendAppointmentResponse(http.StatusOK, []*AppointmentSlot{}, w, r)
+			}
+
 			appointments, err = ga.GetAppointmentsWithStudent(r.Context(), q.ID, start, end)
 		}
 
@@ -140,268 +285,4252 @@ func (s *Server) GetAppointments(ga getAppointments) E {
 			return err
 		}
 
-		return s.sendResponse(http.StatusOK, appointments, w, r)
-	}
-}
-
-type getAppointmentsForUser interface {
-	GetAppointmentsForUser(ctx context.Context, queue ksuid.KSUID, from, to time.Time, email string) ([]*AppointmentSlot, error)
-}
+		// Labels are for staff only. Filtering by more than one label
+		// only keeps appointments that have all of them. Staff can also
+		// ask for just the upcoming sign-ups nobody has claimed yet, or
+		// just the ones in one room.
+		if admin {
+			labels, err := ga.GetAppointmentLabelsInTimeFrame(r.Context(), q.ID, start, end)
+			if err != nil {
+				s.logger.Errorw("failed to get appointment labels",
+					RequestIDContextKey, r.Context().Value(RequestIDContextKey),
+					"err", err,
+				)
+				return err
+			}
 
-func (s *Server) GetAppointmentsForCurrentUser(ga getAppointmentsForUser) E {
-	return func(w http.ResponseWriter, r *http.Request) error {
-		q := r.Context().Value(queueContextKey).(*Queue)
-		email := r.Context().Value(emailContextKey).(string)
-		day := r.Context().Value(appointmentDayContextKey).(int)
+			fields, err := ga.GetAppointmentCustomFieldsInTimeFrame(r.Context(), q.ID, start, end)
+			if err != nil {
+				s.logger.Errorw("failed to get appointment custom fields",
+					RequestIDContextKey, r.Context().Value(RequestIDContextKey),
+					"err", err,
+				)
+				return err
+			}
 
-		start, end := WeekdayBounds(day)
-		appointments, err := ga.GetAppointmentsForUser(r.Context(), q.ID, start, end, email)
-		if err != nil {
-			s.logger.Errorw("failed to get appointments for user",
-				RequestIDContextKey, r.Context().Value(RequestIDContextKey),
-				"queue_id", q.ID,
-				"email", email,
-				"day", day,
-			)
-			return err
+			filter := r.URL.Query()["label"]
+			unclaimed := r.URL.Query().Get("unclaimed") == "true"
+			location := strings.TrimSpace(r.URL.Query().Get("location"))
+			now := time.Now()
+			filtered := make([]*AppointmentSlot, 0, len(appointments))
+			for _, a := range appointments {
+				a.Labels = labels[a.ID]
+				a.CustomFields = fields[a.ID]
+				if unclaimed && !needsCoverage(a, now) {
+					continue
+				}
+				if location != "" && !atLocation(a, location) {
+					continue
+				}
+				if hasLabels(a, filter) {
+					filtered = append(filtered, a)
+				}
+			}
+			appointments = filtered
 		}
 
-		return s.sendResponse(http.StatusOK, appointments, w, r)
+		return s.sendAppointmentResponse(http.StatusOK, appointments, w, r)
 	}
 }
 
-type getAppointmentSchedule interface {
-	GetAppointmentSchedule(ctx context.Context, queue ksuid.KSUID) ([]*AppointmentSchedule, error)
-}
+// appointmentAvailability works out how many slots are open at each
+// timeslot of a day's schedule. appointments needs to include the student
+// emails, since claimed slots without a student don't take up room.
+func appointmentAvailability(day int, schedule *AppointmentSchedule, appointments []*AppointmentSlot) []*TimeslotAvailability {
+	taken := make(map[int]int)
+	for _, a := range appointments {
+		if a.StudentEmail == nil {
+			continue
+		}
+		for t := a.Timeslot; t < a.Timeslot+a.Span(); t++ {
+			taken[t]++
+		}
+	}
 
-func (s *Server) GetAppointmentSchedule(gs getAppointmentSchedule) E {
-	return func(w http.ResponseWriter, r *http.Request) error {
-		q := r.Context().Value(queueContextKey).(*Queue)
+	dayStart, _ := WeekdayBounds(day)
 
-		schedules, err := gs.GetAppointmentSchedule(r.Context(), q.ID)
-		if err != nil {
-			s.logger.Errorw("failed to get appointment schedule",
-				RequestIDContextKey, r.Context().Value(RequestIDContextKey),
-				"queue_id", q.ID,
-				"err", err,
-			)
-			return err
+	availability := make([]*TimeslotAvailability, 0, len(schedule.Schedule))
+	for i, n := range schedule.Schedule {
+		capacity := int(n - '0')
+		open := capacity - taken[i]
+		if open < 0 {
+			open = 0
 		}
 
-		return s.sendResponse(http.StatusOK, schedules, w, r)
+		// Limited means half or less of the timeslot is left.
+		status := AvailabilityOpen
+		if open == 0 {
+			status = AvailabilityFull
+		} else if open*2 <= capacity {
+			status = AvailabilityLimited
+		}
+
+		t := taken[i]
+		availability = append(availability, &TimeslotAvailability{
+			Timeslot:      i,
+			ScheduledTime: SlotStart(dayStart, i, schedule),
+			Status:        status,
+			Capacity:      &capacity,
+			Taken:         &t,
+			Open:          &open,
+			Note:          schedule.Notes[i],
+		})
 	}
-}
 
-type getAppointmentScheduleForDay interface {
-	GetAppointmentScheduleForDay(ctx context.Context, queue ksuid.KSUID, day int) (*AppointmentSchedule, error)
+	return availability
 }
 
-func (s *Server) GetAppointmentScheduleForDay(gs getAppointmentScheduleForDay) E {
-	return func(w http.ResponseWriter, r *http.Request) error {
-		q := r.Context().Value(queueContextKey).(*Queue)
-		day := r.Context().Value(appointmentDayContextKey).(int)
-
-		schedule, err := gs.GetAppointmentScheduleForDay(r.Context(), q.ID, day)
-		if err != nil {
-			s.logger.Errorw("failed to get appointment schedule",
-				RequestIDContextKey, r.Context().Value(RequestIDContextKey),
-				"queue_id", q.ID,
-				"day", day,
-				"err", err,
-			)
-			return err
+// concurrentAppointments counts the booked appointments (other than
+// except) that would overlap one of duration minutes starting at t.
+func concurrentAppointments(appointments []*AppointmentSlot, t time.Time, duration int, except ksuid.KSUID) int {
+	end := t.Add(time.Duration(duration) * time.Minute)
+	var n int
+	for _, a := range appointments {
+		if a.StudentEmail == nil || a.ID == except {
+			continue
 		}
 
-		return s.sendResponse(http.StatusOK, schedule, w, r)
+		aEnd := a.ScheduledTime.Add(time.Duration(a.Duration) * time.Minute)
+		if a.ScheduledTime.Before(end) && aEnd.After(t) {
+			n++
+		}
 	}
+	return n
 }
 
-type claimTimeslot interface {
-	ClaimTimeslot(ctx context.Context, queue ksuid.KSUID, day, timeslot int, email string) (*AppointmentSlot, error)
+// hasOpenTimeslot reports whether any timeslot in availability that
+// hasn't started by now still has room.
+func hasOpenTimeslot(availability []*TimeslotAvailability, now time.Time) bool {
+	for _, t := range availability {
+		if *t.Open > 0 && now.Before(t.ScheduledTime) {
+			return true
+		}
+	}
+	return false
 }
 
-func (s *Server) ClaimTimeslot(cs claimTimeslot) E {
-	return func(w http.ResponseWriter, r *http.Request) error {
-		q := r.Context().Value(queueContextKey).(*Queue)
-		email := r.Context().Value(emailContextKey).(string)
-		day := r.Context().Value(appointmentDayContextKey).(int)
-		timeslot := r.Context().Value(appointmentTimeslotContextKey).(int)
-		l := s.logger.With(
-			RequestIDContextKey, r.Context().Value(RequestIDContextKey),
-			"queue_id", q.ID,
-			"day", day,
-			"timeslot", timeslot,
-			"email", email,
-		)
-
-		appointment, err := cs.ClaimTimeslot(r.Context(), q.ID, day, timeslot, email)
-		if err != nil {
-			l.Errorw("failed to claim timeslot", "err", err)
-			return StatusError{
-				http.StatusBadRequest,
-				"Failed to claim timeslot. Perhaps it has already been claimed? error: " + err.Error(),
-			}
+// fullyBooked reports whether a day has timeslots still to come, but none
+// of them have room. Days that are over or have no slots at all aren't
+// booked up, just closed.
+func fullyBooked(availability []*TimeslotAvailability, now time.Time) bool {
+	upcoming := false
+	for _, t := range availability {
+		if *t.Capacity > 0 && now.Before(t.ScheduledTime) {
+			upcoming = true
 		}
-
-		l.Infow("appointment claimed")
-
-		s.ps.Pub(WS("APPOINTMENT_CREATE", appointment), QueueTopicAdmin(q.ID))
-
-		return s.sendResponse(http.StatusCreated, nil, w, r)
 	}
+	return upcoming && !hasOpenTimeslot(availability, now)
 }
 
-type unclaimAppointment interface {
-	UnclaimAppointment(ctx context.Context, appointment ksuid.KSUID) (deleted bool, err error)
+type getAppointmentDay interface {
+	getAppointmentsInTimeFrame
+	getAppointmentSchedule
+	getAppointmentScheduleForDay
+	getQueueConfiguration
 }
 
-func (s *Server) UnclaimAppointment(us unclaimAppointment) E {
-	return func(w http.ResponseWriter, r *http.Request) error {
-		q := r.Context().Value(queueContextKey).(*Queue)
-		appointment := r.Context().Value(appointmentContextKey).(*AppointmentSlot)
+// nextAvailableDay finds the soonest day in the coming week, other than
+// day, with a timeslot that still has room.
+func nextAvailableDay(ctx context.Context, gd getAppointmentDay, q *Queue, day int, now time.Time) (*time.Weekday, error) {
+	schedules, err := gd.GetAppointmentSchedule(ctx, q.ID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get appointment schedule: %w", err)
+	}
 
-		deleted, err := us.UnclaimAppointment(r.Context(), appointment.ID)
-		if err != nil {
-			s.logger.Errorw("failed to remove appointment claim",
-				RequestIDContextKey, r.Context().Value(RequestIDContextKey),
-				"appointment_id", appointment.ID,
-				"err", err,
-			)
-			return err
-		}
+	today := int(now.Local().Weekday())
+	start, _ := WeekdayBounds(today)
+	_, end := WeekdayBounds((today + 6) % 7)
+	appointments, err := gd.GetAppointments(ctx, q.ID, start, end)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get appointments for week: %w", err)
+	}
 
-		s.logger.Infow("removed appointment claim",
-			RequestIDContextKey, r.Context().Value(RequestIDContextKey),
-			"appointment_id", appointment.ID,
-			"email", r.Context().Value(emailContextKey),
-		)
+	byDay := make(map[time.Weekday][]*AppointmentSlot)
+	for _, a := range appointments {
+		d := a.ScheduledTime.In(time.Local).Weekday()
+		byDay[d] = append(byDay[d], a)
+	}
 
-		if deleted {
-			s.ps.Pub(WS("APPOINTMENT_REMOVE", appointment), QueueTopicAdmin(q.ID))
-		} else {
-			appointment.StaffEmail = nil
-			s.ps.Pub(WS("APPOINTMENT_UPDATE", appointment), QueueTopicAdmin(q.ID))
+	schedulesByDay := make(map[time.Weekday]*AppointmentSchedule)
+	for _, schedule := range schedules {
+		schedulesByDay[schedule.Day] = schedule
+	}
+
+	for i := 0; i < 7; i++ {
+		d := time.Weekday((today + i) % 7)
+		schedule := schedulesByDay[d]
+		if int(d) == day || schedule == nil {
+			continue
 		}
 
-		return s.sendResponse(http.StatusNoContent, nil, w, r)
+		if hasOpenTimeslot(appointmentAvailability(int(d), schedule, byDay[d]), now) {
+			return &d, nil
+		}
 	}
-}
 
-type updateAppointmentSchedule interface {
-	getAppointmentsInTimeFrame
-	getAppointmentScheduleForDay
-	getAppointmentsByTimeslot
-	UpdateAppointmentSchedule(ctx context.Context, queue ksuid.KSUID, day int, schedule *AppointmentSchedule) error
+	return nil, nil
 }
 
-func (s *Server) UpdateAppointmentSchedule(us updateAppointmentSchedule) E {
+// GetAppointmentDay returns a day's schedule, appointments, and
+// availability in one go. Non-admins only see the details of their own
+// appointments. If the rest of the day is booked up, it also says so and
+// points to the soonest day with room.
+func (s *Server) GetAppointmentDay(gd getAppointmentDay) E {
 	return func(w http.ResponseWriter, r *http.Request) error {
-		q := r.Context().Value(queueContextKey).(*Queue)
-		email := r.Context().Value(emailContextKey).(string)
-		day := r.Context().Value(appointmentDayContextKey).(int)
+		q, ok := r.Context().Value(queueContextKey).(*Queue)
+		if !ok {
+			return s.missingContextValue(r, queueContextKey)
+		}
+		admin, ok := r.Context().Value(courseAdminContextKey).(bool)
+		if !ok {
+			return s.missingContextValue(r, courseAdminContextKey)
+		}
+		day, ok := r.Context().Value(appointmentDayContextKey).(int)
+		if !ok {
+			return s.missingContextValue(r, appointmentDayContextKey)
+		}
+		// Okay if this fails; logged-out users just don't have any
+		// appointments of their own to see
+		email, _ := r.Context().Value(emailContextKey).(string)
 		l := s.logger.With(
 			RequestIDContextKey, r.Context().Value(RequestIDContextKey),
 			"queue_id", q.ID,
 			"day", day,
-			"email", email,
 		)
 
-		currentSchedule, err := us.GetAppointmentScheduleForDay(r.Context(), q.ID, day)
+		schedule, err := gd.GetAppointmentScheduleForDay(r.Context(), q.ID, day)
 		if err != nil {
-			l.Errorw("failed to get existing appointment schedule", "err", err)
+			l.Errorw("failed to get appointment schedule", "err", err)
 			return err
 		}
 
-		var schedule AppointmentSchedule
-		err = json.NewDecoder(r.Body).Decode(&schedule)
+		// We always need the full appointments to work out availability;
+		// they get stripped down below for non-admins.
+		start, end := WeekdayBounds(day)
+		appointments, err := gd.GetAppointments(r.Context(), q.ID, start, end)
 		if err != nil {
-			l.Warnw("failed to decode schedule from body", "err", err)
-			return StatusError{
-				http.StatusBadRequest,
-				"We couldn't read the schedule in the request body.",
-			}
+			l.Errorw("failed to get appointments", "err", err)
+			return err
 		}
 
-		from, to := WeekdayBounds(day)
-		appointments, err := us.GetAppointments(r.Context(), q.ID, from, to)
+		config, err := gd.GetQueueConfiguration(r.Context(), q.ID)
 		if err != nil {
-			l.Errorw("failed to get appointments", "err", err)
+			l.Errorw("failed to get queue configuration", "err", err)
 			return err
 		}
+		coarse := !admin && config.AvailabilityDisplay == AvailabilityDisplayCoarse
 
-		if len(appointments) > 0 && currentSchedule.Duration != schedule.Duration {
-			l.Warnw("appointment schedule duration update attempted with existing appointments")
-			return StatusError{
-				http.StatusConflict,
-				"You can't change the appointment duration with active or past appointments on this day.",
-			}
+		response := AppointmentDay{
+			Schedule:     schedule,
+			Appointments: appointments,
+			Availability: appointmentAvailability(day, schedule, appointments),
 		}
 
-		for i, n := range schedule.Schedule {
-			currentTimeslotUsage, err := us.GetAppointmentsByTimeslot(r.Context(), q.ID, from, to, i)
+		now := time.Now()
+		if fullyBooked(response.Availability, now) {
+			response.FullyBooked = true
+			response.FullyBookedMessage = config.FullyBookedMessage
+			response.NextAvailableDay, err = nextAvailableDay(r.Context(), gd, q, day, now)
 			if err != nil {
-				l.Errorw("failed to check appointments for timeslot", "err", err, "timeslot", i)
+				l.Errorw("failed to find next available day", "err", err)
 				return err
 			}
+		}
 
-			newTimeslotAvailability := int(n - '0')
-			if newTimeslotAvailability < len(currentTimeslotUsage) {
-				l.Warnw("tried to change appointment schedule to one without room",
-					"conflicting_timeslot", i,
-					"current_appointments", len(currentTimeslotUsage),
-					"new_slots", newTimeslotAvailability,
-				)
-				return StatusError{
-					http.StatusConflict,
-					fmt.Sprintf("Setting that appointment schedule would remove an existing appointment. There are %d appointments at timeslot %d, but the new schedule only has %d slots at that time.",
-						len(currentTimeslotUsage), i, newTimeslotAvailability),
+		if !admin {
+			response.Appointments = make([]*AppointmentSlot, 0, len(appointments))
+			for _, a := range appointments {
+				if a.StudentEmail == nil {
+					continue
+				}
+
+				if email != "" && *a.StudentEmail == email {
+					response.Appointments = append(response.Appointments, a.NoStaffEmail())
+				} else if !coarse {
+					// With coarse availability, other students' appointments
+					// would give the counts away.
+					response.Appointments = append(response.Appointments, a.Anonymized())
 				}
 			}
 		}
 
-		err = us.UpdateAppointmentSchedule(r.Context(), q.ID, day, &schedule)
-		if err != nil {
-			l.Errorw("failed to update appointment schedule", "err", err)
-			return err
+		if coarse {
+			for i, t := range response.Availability {
+				response.Availability[i] = t.Coarse()
+			}
 		}
 
-		l.Infow("updated appointment schedule")
-
-		s.ps.Pub(WS("REFRESH", nil), QueueTopicGeneric(q.ID))
-
-		return s.sendResponse(http.StatusNoContent, nil, w, r)
+		return s.sendResponse(http.StatusOK, response, w, r)
 	}
 }
 
-type getAppointmentsByTimeslot interface {
-	GetAppointmentsByTimeslot(ctx context.Context, queue ksuid.KSUID, from, to time.Time, timeslot int) ([]*AppointmentSlot, error)
-}
-
-type signupForAppointment interface {
-	getQueueConfiguration
-	getAppointmentScheduleForDay
-	getAppointmentsForUser
+type getTimeslotDetail interface {
 	getAppointmentsByTimeslot
-	UserInQueueRoster(ctx context.Context, queue ksuid.KSUID, email string) (bool, error)
-	TeammateHasAppointment(ctx context.Context, queue ksuid.KSUID, from, to time.Time, email string) (bool, error)
-	SignupForAppointment(ctx context.Context, queue ksuid.KSUID, appointment *AppointmentSlot) (*AppointmentSlot, error)
+	getAppointmentScheduleForDay
+	getQueueConfiguration
+}
+
+// GetTimeslotDetail returns the availability and claims for a single
+// timeslot, along with its appointments for admins.
+func (s *Server) GetTimeslotDetail(gt getTimeslotDetail) E {
+	return func(w http.ResponseWriter, r *http.Request) error {
+		q, ok := r.Context().Value(queueContextKey).(*Queue)
+		if !ok {
+			return s.missingContextValue(r, queueContextKey)
+		}
+		admin, ok := r.Context().Value(courseAdminContextKey).(bool)
+		if !ok {
+			return s.missingContextValue(r, courseAdminContextKey)
+		}
+		day, ok := r.Context().Value(appointmentDayContextKey).(int)
+		if !ok {
+			return s.missingContextValue(r, appointmentDayContextKey)
+		}
+		timeslot, ok := r.Context().Value(appointmentTimeslotContextKey).(int)
+		if !ok {
+			return s.missingContextValue(r, appointmentTimeslotContextKey)
+		}
+		l := s.logger.With(
+			RequestIDContextKey, r.Context().Value(RequestIDContextKey),
+			"queue_id", q.ID,
+			"day", day,
+			"timeslot", timeslot,
+		)
+
+		schedule, err := gt.GetAppointmentScheduleForDay(r.Context(), q.ID, day)
+		if err != nil {
+			l.Errorw("failed to get appointment schedule", "err", err)
+			return err
+		}
+
+		if timeslot >= len(schedule.Schedule) {
+			l.Warnw("attempted to get non-existent timeslot", "num_slots", len(schedule.Schedule))
+			return StatusError{
+				http.StatusNotFound,
+				"I don't think that timeslot exists, as much as I'd like it to.",
+			}
+		}
+
+		start, end := WeekdayBounds(day)
+		appointments, err := gt.GetAppointmentsByTimeslot(r.Context(), q.ID, start, end, timeslot)
+		if err != nil {
+			l.Errorw("failed to get appointments for timeslot", "err", err)
+			return err
+		}
+
+		config, err := gt.GetQueueConfiguration(r.Context(), q.ID)
+		if err != nil {
+			l.Errorw("failed to get queue configuration", "err", err)
+			return err
+		}
+
+		detail := TimeslotDetail{
+			TimeslotAvailability: appointmentAvailability(day, schedule, appointments)[timeslot],
+		}
+		for _, a := range appointments {
+			if a.StaffEmail != nil {
+				detail.Claims++
+			}
+		}
+
+		if admin {
+			detail.Appointments = appointments
+		} else if config.AvailabilityDisplay == AvailabilityDisplayCoarse {
+			detail.TimeslotAvailability = detail.TimeslotAvailability.Coarse()
+		}
+
+		return s.sendResponse(http.StatusOK, detail, w, r)
+	}
+}
+
+type getWeeklyDemand interface {
+	getAppointmentsInTimeFrame
+	getAppointmentSchedule
+}
+
+// GetWeeklyDemand returns signups against capacity for every timeslot of
+// the coming week (today through six days from now), in two queries no
+// matter how many appointments there are.
+func (s *Server) GetWeeklyDemand(gw getWeeklyDemand) E {
+	return func(w http.ResponseWriter, r *http.Request) error {
+		q, ok := r.Context().Value(queueContextKey).(*Queue)
+		if !ok {
+			return s.missingContextValue(r, queueContextKey)
+		}
+		l := s.logger.With(
+			RequestIDContextKey, r.Context().Value(RequestIDContextKey),
+			"queue_id", q.ID,
+		)
+
+		schedules, err := gw.GetAppointmentSchedule(r.Context(), q.ID)
+		if err != nil {
+			l.Errorw("failed to get appointment schedule", "err", err)
+			return err
+		}
+
+		today := int(time.Now().Local().Weekday())
+		start, _ := WeekdayBounds(today)
+		_, end := WeekdayBounds((today + 6) % 7)
+		appointments, err := gw.GetAppointments(r.Context(), q.ID, start, end)
+		if err != nil {
+			l.Errorw("failed to get appointments for week", "err", err)
+			return err
+		}
+
+		byDay := make(map[time.Weekday][]*AppointmentSlot)
+		for _, a := range appointments {
+			day := a.ScheduledTime.In(time.Local).Weekday()
+			byDay[day] = append(byDay[day], a)
+		}
+
+		demand := make([]*DayDemand, 0, len(schedules))
+		for _, schedule := range schedules {
+			demand = append(demand, &DayDemand{
+				Day:       schedule.Day,
+				Timeslots: appointmentAvailability(int(schedule.Day), schedule, byDay[schedule.Day]),
+			})
+		}
+
+		return s.sendResponse(http.StatusOK, demand, w, r)
+	}
+}
+
+type moveAppointment interface {
+	MoveAppointment(ctx context.Context, appointment ksuid.KSUID, scheduledTime time.Time, timeslot, duration int) (*AppointmentSlot, error)
+}
+
+type shiftDayAppointments interface {
+	getAppointmentsInTimeFrame
+	getAppointmentScheduleForDay
+	moveAppointment
+	sendMessage
+	syncCalendarEvents
+}
+
+// ShiftDayAppointments moves every booked appointment on a day to the
+// same timeslot on another day, for when a whole day of office hours gets
+// moved. Either everything moves or nothing does.
+func (s *Server) ShiftDayAppointments(sd shiftDayAppointments) E {
+	return func(w http.ResponseWriter, r *http.Request) error {
+		q, ok := r.Context().Value(queueContextKey).(*Queue)
+		if !ok {
+			return s.missingContextValue(r, queueContextKey)
+		}
+		email, ok := r.Context().Value(emailContextKey).(string)
+		if !ok {
+			return s.missingContextValue(r, emailContextKey)
+		}
+		day, ok := r.Context().Value(appointmentDayContextKey).(int)
+		if !ok {
+			return s.missingContextValue(r, appointmentDayContextKey)
+		}
+		l := s.logger.With(
+			RequestIDContextKey, r.Context().Value(RequestIDContextKey),
+			"queue_id", q.ID,
+			"day", day,
+			"email", email,
+		)
+
+		var body struct {
+			TargetDay int `json:"target_day"`
+		}
+		err := json.NewDecoder(r.Body).Decode(&body)
+		if err != nil {
+			l.Warnw("failed to decode target day from body", "err", err)
+			return StatusError{
+				http.StatusBadRequest,
+				"We couldn't read the target day from the request body.",
+			}
+		}
+		l = l.With("target_day", body.TargetDay)
+
+		if body.TargetDay < 0 || body.TargetDay > 6 || body.TargetDay == day {
+			l.Warnw("got invalid target day")
+			return StatusError{
+				http.StatusBadRequest,
+				"The target day needs to be a different day of the week.",
+			}
+		}
+
+		sourceSchedule, err := sd.GetAppointmentScheduleForDay(r.Context(), q.ID, day)
+		if err != nil {
+			l.Errorw("failed to get source appointment schedule", "err", err)
+			return err
+		}
+
+		targetSchedule, err := sd.GetAppointmentScheduleForDay(r.Context(), q.ID, body.TargetDay)
+		if err != nil {
+			l.Errorw("failed to get target appointment schedule", "err", err)
+			return err
+		}
+
+		// Timeslots only line up if they're the same length.
+		if sourceSchedule.Duration != targetSchedule.Duration {
+			l.Warnw("attempted to shift appointments between days with different durations",
+				"source_duration", sourceSchedule.Duration,
+				"target_duration", targetSchedule.Duration,
+			)
+			return StatusError{
+				http.StatusConflict,
+				"Appointments on both days need to be the same length to move them over.",
+			}
+		}
+
+		start, end := WeekdayBounds(day)
+		appointments, err := sd.GetAppointments(r.Context(), q.ID, start, end)
+		if err != nil {
+			l.Errorw("failed to get source appointments", "err", err)
+			return err
+		}
+
+		targetStart, targetEnd := WeekdayBounds(body.TargetDay)
+		targetAppointments, err := sd.GetAppointments(r.Context(), q.ID, targetStart, targetEnd)
+		if err != nil {
+			l.Errorw("failed to get target appointments", "err", err)
+			return err
+		}
+
+		taken := make(map[int]int)
+		for _, a := range targetAppointments {
+			if a.StudentEmail == nil {
+				continue
+			}
+			for t := a.Timeslot; t < a.Timeslot+a.Span(); t++ {
+				taken[t]++
+			}
+		}
+
+		// Check everything before moving anything. The whole request is one
+		// transaction anyway, but there's no point starting if it's going
+		// to fail.
+		var toMove []*AppointmentSlot
+		for _, a := range appointments {
+			// Bare staff claims stay where they are; only appointments
+			// with students in them move.
+			if a.StudentEmail == nil {
+				continue
+			}
+
+			newTime := SlotStart(targetStart, a.Timeslot, targetSchedule)
+			if time.Now().After(newTime) {
+				l.Warnw("attempted to shift appointment into the past", "appointment_id", a.ID, "new_time", newTime)
+				return StatusError{
+					http.StatusConflict,
+					"Some of those appointments would be moved into the past.",
+				}
+			}
+
+			for t := a.Timeslot; t < a.Timeslot+a.Span(); t++ {
+				taken[t]++
+				if t >= len(targetSchedule.Schedule) || taken[t] > int(targetSchedule.Schedule[t]-'0') {
+					l.Warnw("not enough room to shift appointments", "timeslot", t)
+					return StatusError{
+						http.StatusConflict,
+						fmt.Sprintf("There isn't enough room at timeslot %d on the target day for all of the appointments.", t),
+					}
+				}
+			}
+
+			toMove = append(toMove, a)
+		}
+
+		moved := make([]*AppointmentSlot, len(toMove))
+		calendarEvents := make([][]*CalendarEventLink, len(toMove))
+		for i, a := range toMove {
+			calendarEvents[i], err = sd.GetCalendarEvents(r.Context(), a.ID)
+			if err != nil {
+				l.Errorw("failed to get calendar events for appointment", "appointment_id", a.ID, "err", err)
+				return err
+			}
+
+			newTime := SlotStart(targetStart, a.Timeslot, targetSchedule)
+			moved[i], err = sd.MoveAppointment(r.Context(), a.ID, newTime, a.Timeslot, targetSchedule.Duration*a.Span())
+			if err != nil {
+				l.Errorw("failed to move appointment", "appointment_id", a.ID, "err", err)
+				return err
+			}
+		}
+
+		// One message per student, even if they had several appointments.
+		messages := make(map[string]*Message)
+		for i, a := range toMove {
+			if _, ok := messages[*a.StudentEmail]; ok {
+				continue
+			}
+
+			content := fmt.Sprintf("Heads up: office hours got moved, so your appointment at %s is now at %s.",
+				a.ScheduledTime.In(time.Local).Format("Monday 3:04 PM"),
+				moved[i].ScheduledTime.In(time.Local).Format("Monday 3:04 PM"),
+			)
+
+			messages[*a.StudentEmail], err = sd.SendMessage(r.Context(), q.ID, content, email, *a.StudentEmail)
+			if err != nil {
+				l.Errorw("failed to send message about moved appointment", "receiver", *a.StudentEmail, "err", err)
+				return err
+			}
+		}
+
+		for i, a := range toMove {
+			err = s.syncCalendarEvents(r.Context(), l, sd, q, calendarEvents[i], a, moved[i])
+			if err != nil {
+				return err
+			}
+		}
+
+		// Only tell anyone once nothing else can fail.
+		for i, a := range toMove {
+			s.ps.Pub(WS("APPOINTMENT_REMOVE", a.Anonymized()), QueueTopicGeneric(q.ID))
+			s.ps.Pub(WS("APPOINTMENT_CREATE", moved[i]), QueueTopicAdmin(q.ID))
+			s.ps.Pub(WS("APPOINTMENT_CREATE", moved[i].Anonymized()), QueueTopicNonPrivileged(q.ID))
+			s.ps.Pub(WS("APPOINTMENT_UPDATE", moved[i].NoStaffEmail()), QueueTopicEmail(q.ID, *a.StudentEmail))
+			s.publishAppointmentEvent(r.Context(), l, AppointmentReschedule, q.ID, moved[i], a)
+		}
+
+		for student, message := range messages {
+			s.ps.Pub(WS("MESSAGE_CREATE", message), QueueTopicEmail(q.ID, student))
+		}
+
+		l.Infow("shifted appointments to another day", "num_appointments", len(toMove))
+
+		return s.sendResponse(http.StatusNoContent, nil, w, r)
+	}
+}
+
+type getClaimEvents interface {
+	GetClaimEvents(ctx context.Context, queue ksuid.KSUID, from, to time.Time) ([]*ClaimEvent, error)
+}
+
+// GetClaimHistory returns every claim and unclaim of appointments on a
+// day, oldest first.
+func (s *Server) GetClaimHistory(gc getClaimEvents) E {
+	return func(w http.ResponseWriter, r *http.Request) error {
+		q, ok := r.Context().Value(queueContextKey).(*Queue)
+		if !ok {
+			return s.missingContextValue(r, queueContextKey)
+		}
+		day, ok := r.Context().Value(appointmentDayContextKey).(int)
+		if !ok {
+			return s.missingContextValue(r, appointmentDayContextKey)
+		}
+
+		start, end := WeekdayBounds(day)
+		events, err := gc.GetClaimEvents(r.Context(), q.ID, start, end)
+		if err != nil {
+			s.logger.Errorw("failed to get claim events",
+				RequestIDContextKey, r.Context().Value(RequestIDContextKey),
+				"queue_id", q.ID,
+				"day", day,
+				"err", err,
+			)
+			return err
+		}
+
+		return s.sendResponse(http.StatusOK, events, w, r)
+	}
+}
+
+type getTimeslotAssignments interface {
+	GetTimeslotAssignments(ctx context.Context, queue ksuid.KSUID, day int) (map[int]string, error)
+}
+
+// GetTimeslotAssignments returns who's assigned to each timeslot on a day,
+// by timeslot. Timeslots nobody's assigned to are left out.
+func (s *Server) GetTimeslotAssignments(ga getTimeslotAssignments) E {
+	return func(w http.ResponseWriter, r *http.Request) error {
+		q, ok := r.Context().Value(queueContextKey).(*Queue)
+		if !ok {
+			return s.missingContextValue(r, queueContextKey)
+		}
+		day, ok := r.Context().Value(appointmentDayContextKey).(int)
+		if !ok {
+			return s.missingContextValue(r, appointmentDayContextKey)
+		}
+
+		assignments, err := ga.GetTimeslotAssignments(r.Context(), q.ID, day)
+		if err != nil {
+			s.logger.Errorw("failed to get timeslot assignments",
+				RequestIDContextKey, r.Context().Value(RequestIDContextKey),
+				"queue_id", q.ID,
+				"day", day,
+				"err", err,
+			)
+			return err
+		}
+
+		return s.sendResponse(http.StatusOK, assignments, w, r)
+	}
+}
+
+type getCoverageGaps interface {
+	getAppointmentsInTimeFrame
+	getAppointmentScheduleForDay
+	getTimeslotAssignments
+}
+
+// GetCoverageGaps returns the timeslots on a day that students can sign
+// up for but that no staff member has claimed any appointments in, along
+// with who was assigned to them, if anyone.
+func (s *Server) GetCoverageGaps(gc getCoverageGaps) E {
+	return func(w http.ResponseWriter, r *http.Request) error {
+		q, ok := r.Context().Value(queueContextKey).(*Queue)
+		if !ok {
+			return s.missingContextValue(r, queueContextKey)
+		}
+		day, ok := r.Context().Value(appointmentDayContextKey).(int)
+		if !ok {
+			return s.missingContextValue(r, appointmentDayContextKey)
+		}
+		l := s.logger.With(
+			RequestIDContextKey, r.Context().Value(RequestIDContextKey),
+			"queue_id", q.ID,
+			"day", day,
+		)
+
+		schedule, err := gc.GetAppointmentScheduleForDay(r.Context(), q.ID, day)
+		if err != nil {
+			l.Errorw("failed to get appointment schedule", "err", err)
+			return err
+		}
+
+		start, end := WeekdayBounds(day)
+		appointments, err := gc.GetAppointments(r.Context(), q.ID, start, end)
+		if err != nil {
+			l.Errorw("failed to get appointments", "err", err)
+			return err
+		}
+
+		assignments, err := gc.GetTimeslotAssignments(r.Context(), q.ID, day)
+		if err != nil {
+			l.Errorw("failed to get timeslot assignments", "err", err)
+			return err
+		}
+
+		return s.sendResponse(http.StatusOK, coverageGaps(day, schedule, appointments, assignments), w, r)
+	}
+}
+
+func coverageGaps(day int, schedule *AppointmentSchedule, appointments []*AppointmentSlot, assignments map[int]string) []*CoverageGap {
+	claimed := make(map[int]bool)
+	for _, a := range appointments {
+		if a.StaffEmail == nil {
+			continue
+		}
+		for t := a.Timeslot; t < a.Timeslot+a.Span(); t++ {
+			claimed[t] = true
+		}
+	}
+
+	dayStart, _ := WeekdayBounds(day)
+	gaps := make([]*CoverageGap, 0)
+	for i, n := range schedule.Schedule {
+		capacity := int(n - '0')
+		if capacity > 0 && !claimed[i] {
+			gaps = append(gaps, &CoverageGap{
+				Timeslot:      i,
+				ScheduledTime: SlotStart(dayStart, i, schedule),
+				Capacity:      capacity,
+				AssignedTo:    assignments[i],
+			})
+		}
+	}
+
+	return gaps
+}
+
+// GetAppointmentDashboard returns what staff need to see about today's
+// appointments in one go: the schedule, how full each timeslot is, and
+// which timeslots nobody's covering.
+func (s *Server) GetAppointmentDashboard(gc getCoverageGaps) E {
+	return func(w http.ResponseWriter, r *http.Request) error {
+		q, ok := r.Context().Value(queueContextKey).(*Queue)
+		if !ok {
+			return s.missingContextValue(r, queueContextKey)
+		}
+		day := int(time.Now().Local().Weekday())
+		l := s.logger.With(
+			RequestIDContextKey, r.Context().Value(RequestIDContextKey),
+			"queue_id", q.ID,
+			"day", day,
+		)
+
+		schedule, err := gc.GetAppointmentScheduleForDay(r.Context(), q.ID, day)
+		if err != nil {
+			l.Errorw("failed to get appointment schedule", "err", err)
+			return err
+		}
+
+		start, end := WeekdayBounds(day)
+		appointments, err := gc.GetAppointments(r.Context(), q.ID, start, end)
+		if err != nil {
+			l.Errorw("failed to get appointments", "err", err)
+			return err
+		}
+
+		assignments, err := gc.GetTimeslotAssignments(r.Context(), q.ID, day)
+		if err != nil {
+			l.Errorw("failed to get timeslot assignments", "err", err)
+			return err
+		}
+
+		return s.sendResponse(http.StatusOK, AppointmentDashboard{
+			Day:          day,
+			Schedule:     schedule,
+			Availability: appointmentAvailability(day, schedule, appointments),
+			CoverageGaps: coverageGaps(day, schedule, appointments, assignments),
+		}, w, r)
+	}
+}
+
+type notifyAppointmentStudents interface {
+	getAppointmentsInTimeFrame
+	sendMessage
+}
+
+// NotifyAppointmentStudentsForDay sends a message to every student with an
+// appointment on a day (once per student, even if they have several).
+func (s *Server) NotifyAppointmentStudentsForDay(ns notifyAppointmentStudents) E {
+	return func(w http.ResponseWriter, r *http.Request) error {
+		q, ok := r.Context().Value(queueContextKey).(*Queue)
+		if !ok {
+			return s.missingContextValue(r, queueContextKey)
+		}
+		email, ok := r.Context().Value(emailContextKey).(string)
+		if !ok {
+			return s.missingContextValue(r, emailContextKey)
+		}
+		day, ok := r.Context().Value(appointmentDayContextKey).(int)
+		if !ok {
+			return s.missingContextValue(r, appointmentDayContextKey)
+		}
+		l := s.logger.With(
+			RequestIDContextKey, r.Context().Value(RequestIDContextKey),
+			"queue_id", q.ID,
+			"day", day,
+			"email", email,
+		)
+
+		var message Message
+		err := json.NewDecoder(r.Body).Decode(&message)
+		if err != nil {
+			l.Warnw("failed to decode message from body", "err", err)
+			return StatusError{
+				http.StatusBadRequest,
+				"We couldn't read the message from the request body.",
+			}
+		}
+
+		if message.Content == "" {
+			l.Warnw("got empty message")
+			return StatusError{
+				http.StatusBadRequest,
+				"It looks like you forgot to write a message.",
+			}
+		}
+
+		start, end := WeekdayBounds(day)
+		appointments, err := ns.GetAppointments(r.Context(), q.ID, start, end)
+		if err != nil {
+			l.Errorw("failed to get appointments", "err", err)
+			return err
+		}
+
+		recipients := make([]string, 0)
+		seen := make(map[string]bool)
+		for _, a := range appointments {
+			// Slots without a student are either unclaimed or cancelled;
+			// nobody to tell.
+			if a.StudentEmail == nil || seen[*a.StudentEmail] {
+				continue
+			}
+			seen[*a.StudentEmail] = true
+
+			newMessage, err := ns.SendMessage(r.Context(), q.ID, message.Content, email, *a.StudentEmail)
+			if err != nil {
+				l.Errorw("failed to create message", "receiver", *a.StudentEmail, "err", err)
+				return err
+			}
+
+			s.ps.Pub(WS("MESSAGE_CREATE", newMessage), QueueTopicEmail(q.ID, *a.StudentEmail))
+			recipients = append(recipients, *a.StudentEmail)
+		}
+
+		l.Infow("notified appointment students for day", "num_recipients", len(recipients))
+
+		return s.sendResponse(http.StatusCreated, struct {
+			Recipients []string `json:"recipients"`
+		}{recipients}, w, r)
+	}
+}
+
+type getAppointmentsSince interface {
+	getQueueConfiguration
+	GetAppointmentsSince(ctx context.Context, queue ksuid.KSUID, since time.Time) ([]*AppointmentSlot, error)
+	GetAppointmentTombstonesSince(ctx context.Context, queue ksuid.KSUID, since time.Time) ([]*AppointmentTombstone, error)
+}
+
+// How far back as_of is set from when changes were fetched. updated_at is
+// stamped with when a change's transaction started, not when it
+// committed, so a change still in flight while we query can land with an
+// updated_at from before we looked. This needs to outlast any request's
+// transaction.
+const appointmentSyncOverlap = time.Minute
+
+// GetAppointmentsSince returns everything that changed about the queue's
+// appointments after the since query parameter (an RFC 3339 timestamp), so
+// that clients with a local copy don't need to re-fetch whole days. The
+// returned as_of should be passed as since on the next request. Syncs
+// overlap a bit (see appointmentSyncOverlap), so clients can get the same
+// change more than once and should apply them by ID.
+func (s *Server) GetAppointmentsSince(ga getAppointmentsSince) E {
+	return func(w http.ResponseWriter, r *http.Request) error {
+		q, ok := r.Context().Value(queueContextKey).(*Queue)
+		if !ok {
+			return s.missingContextValue(r, queueContextKey)
+		}
+		admin, ok := r.Context().Value(courseAdminContextKey).(bool)
+		if !ok {
+			return s.missingContextValue(r, courseAdminContextKey)
+		}
+		l := s.logger.With(
+			RequestIDContextKey, r.Context().Value(RequestIDContextKey),
+			"queue_id", q.ID,
+		)
+
+		since, err := time.Parse(time.RFC3339Nano, r.URL.Query().Get("since"))
+		if err != nil {
+			l.Warnw("failed to parse since timestamp", "since", r.URL.Query().Get("since"), "err", err)
+			return StatusError{
+				http.StatusBadRequest,
+				"We couldn't read the timestamp in the `since` query parameter. Make sure it's in RFC 3339 format.",
+			}
+		}
+
+		// Grab this before querying, and back it off by the overlap, so
+		// nothing that commits while we're working (or just after) gets
+		// missed on the next sync.
+		asOf := time.Now().Add(-appointmentSyncOverlap)
+
+		appointments, err := ga.GetAppointmentsSince(r.Context(), q.ID, since)
+		if err != nil {
+			l.Errorw("failed to get appointments since timestamp", "since", since, "err", err)
+			return err
+		}
+
+		removed, err := ga.GetAppointmentTombstonesSince(r.Context(), q.ID, since)
+		if err != nil {
+			l.Errorw("failed to get appointment tombstones since timestamp", "since", since, "err", err)
+			return err
+		}
+
+		// Non-admins only ever see slots with a student in them, so a slot
+		// that's lost its student (but stuck around because staff claimed
+		// it) is a removal as far as they're concerned. With coarse
+		// availability, they don't see other students' bookings at all
+		// (just like in GetAppointments), so there's nothing to sync.
+		if !admin {
+			config, err := ga.GetQueueConfiguration(r.Context(), q.ID)
+			if err != nil {
+				l.Errorw("failed to get queue configuration", "err", err)
+				return err
+			}
+
+			if config.AvailabilityDisplay == AvailabilityDisplayCoarse {
+				appointments = appointments[:0]
+				removed = removed[:0]
+			}
+
+			updated := make([]*AppointmentSlot, 0, len(appointments))
+			for _, a := range appointments {
+				if a.StudentEmail == nil {
+					removed = append(removed, &AppointmentTombstone{
+						ID:            a.ID,
+						Queue:         a.Queue,
+						ScheduledTime: a.ScheduledTime,
+						RemovedAt:     a.UpdatedAt,
+					})
+					continue
+				}
+				updated = append(updated, a.Anonymized())
+			}
+			appointments = updated
+		}
+
+		return s.sendResponse(http.StatusOK, struct {
+			Appointments []*AppointmentSlot      `json:"appointments"`
+			Removed      []*AppointmentTombstone `json:"removed"`
+			AsOf         time.Time               `json:"as_of"`
+		}{appointments, removed, asOf}, w, r)
+	}
+}
+
+type getAppointmentsForUser interface {
+	GetAppointmentsForUser(ctx context.Context, queue ksuid.KSUID, from, to time.Time, email string) ([]*AppointmentSlot, error)
+}
+
+type getAppointmentsForCurrentUser interface {
+	getAppointmentsForUser
+	getAppointmentsInTimeFrame
+	getQueueConfiguration
+	getAppointmentCustomFieldsInTimeFrame
+}
+
+func (s *Server) GetAppointmentsForCurrentUser(ga getAppointmentsForCurrentUser) E {
+	return func(w http.ResponseWriter, r *http.Request) error {
+		q, ok := r.Context().Value(queueContextKey).(*Queue)
+		if !ok {
+			return s.missingContextValue(r, queueContextKey)
+		}
+		email, ok := r.Context().Value(emailContextKey).(string)
+		if !ok {
+			return s.missingContextValue(r, emailContextKey)
+		}
+		day, ok := r.Context().Value(appointmentDayContextKey).(int)
+		if !ok {
+			return s.missingContextValue(r, appointmentDayContextKey)
+		}
+
+		start, end := WeekdayBounds(day)
+		appointments, err := ga.GetAppointmentsForUser(r.Context(), q.ID, start, end, email)
+		if err != nil {
+			s.logger.Errorw("failed to get appointments for user",
+				RequestIDContextKey, r.Context().Value(RequestIDContextKey),
+				"queue_id", q.ID,
+				"email", email,
+				"day", day,
+			)
+			return err
+		}
+
+		if len(appointments) == 0 {
+			return s.sendAppointmentResponse(http.StatusOK, appointments, w, r)
+		}
+
+		config, err := ga.GetQueueConfiguration(r.Context(), q.ID)
+		if err != nil {
+			s.logger.Errorw("failed to get queue configuration",
+				RequestIDContextKey, r.Context().Value(RequestIDContextKey),
+				"queue_id", q.ID,
+				"err", err,
+			)
+			return err
+		}
+
+		dayAppointments, err := ga.GetAppointments(r.Context(), q.ID, start, end)
+		if err != nil {
+			s.logger.Errorw("failed to get appointments",
+				RequestIDContextKey, r.Context().Value(RequestIDContextKey),
+				"queue_id", q.ID,
+				"day", day,
+				"err", err,
+			)
+			return err
+		}
+
+		fields, err := ga.GetAppointmentCustomFieldsInTimeFrame(r.Context(), q.ID, start, end)
+		if err != nil {
+			s.logger.Errorw("failed to get appointment custom fields",
+				RequestIDContextKey, r.Context().Value(RequestIDContextKey),
+				"queue_id", q.ID,
+				"day", day,
+				"err", err,
+			)
+			return err
+		}
+
+		for _, a := range appointments {
+			a.Group = timeslotGroup(a, dayAppointments, config.ShowTimeslotMembers)
+			a.CustomFields = fields[a.ID]
+		}
+
+		return s.sendAppointmentResponse(http.StatusOK, appointments, w, r)
+	}
+}
+
+// GetAppointmentByID gets one appointment, for the student who booked it,
+// their partners, or staff. It's what the self link on HAL responses
+// points at.
+func (s *Server) GetAppointmentByID(gp getAppointmentPartners) E {
+	return func(w http.ResponseWriter, r *http.Request) error {
+		q, ok := r.Context().Value(queueContextKey).(*Queue)
+		if !ok {
+			return s.missingContextValue(r, queueContextKey)
+		}
+		a, ok := r.Context().Value(appointmentContextKey).(*AppointmentSlot)
+		if !ok {
+			return s.missingContextValue(r, appointmentContextKey)
+		}
+		email, ok := r.Context().Value(emailContextKey).(string)
+		if !ok {
+			return s.missingContextValue(r, emailContextKey)
+		}
+		admin, ok := r.Context().Value(courseAdminContextKey).(bool)
+		if !ok {
+			return s.missingContextValue(r, courseAdminContextKey)
+		}
+		l := s.logger.With(
+			RequestIDContextKey, r.Context().Value(RequestIDContextKey),
+			"queue_id", q.ID,
+			"appointment_id", a.ID,
+			"email", email,
+		)
+
+		// Slots without a student are only staff claims, which students
+		// don't get to see.
+		if a.StudentEmail == nil && !admin {
+			l.Warnw("attempted to get appointment without student")
+			return StatusError{
+				http.StatusNotFound,
+				"This appointment doesn't exist. Perhaps it was already deleted?",
+			}
+		}
+
+		partners, err := gp.GetAppointmentPartners(r.Context(), a.ID)
+		if err != nil {
+			l.Errorw("failed to get appointment partners", "err", err)
+			return err
+		}
+		a.Partners = partners
+
+		if !admin {
+			mine := *a.StudentEmail == email
+			for _, p := range partners {
+				mine = mine || p == email
+			}
+
+			if !mine {
+				l.Warnw("user attempted to get appointment with other email",
+					"expected_email", *a.StudentEmail,
+				)
+				return StatusError{
+					http.StatusForbidden,
+					"You can't look at someone else's appointment!",
+				}
+			}
+
+			a = a.NoStaffEmail()
+		}
+
+		return s.sendAppointmentResponse(http.StatusOK, a, w, r)
+	}
+}
+
+// What students who asked to stay anonymous show up as to other students.
+const anonymousPeerName = "Anonymous"
+
+// timeslotGroup works out who else is booked at the same time as a. The
+// appointments come back ordered by time and then ID, which (being KSUIDs)
+// is the order they were created in, so that doubles as the join order.
+func timeslotGroup(a *AppointmentSlot, appointments []*AppointmentSlot, showMembers bool) *TimeslotGroup {
+	var group TimeslotGroup
+	for _, other := range appointments {
+		if other.StudentEmail == nil || !other.ScheduledTime.Equal(a.ScheduledTime) {
+			continue
+		}
+
+		group.Size++
+		if other.ID == a.ID {
+			group.Position = group.Size
+		} else if showMembers && other.AnonymousToPeers {
+			group.Members = append(group.Members, anonymousPeerName)
+		} else if showMembers && other.Name != nil {
+			group.Members = append(group.Members, *other.Name)
+		}
+	}
+	return &group
+}
+
+type getAppointmentSchedule interface {
+	GetAppointmentSchedule(ctx context.Context, queue ksuid.KSUID) ([]*AppointmentSchedule, error)
+}
+
+func (s *Server) GetAppointmentSchedule(gs getAppointmentSchedule) E {
+	return func(w http.ResponseWriter, r *http.Request) error {
+		q, ok := r.Context().Value(queueContextKey).(*Queue)
+		if !ok {
+			return s.missingContextValue(r, queueContextKey)
+		}
+
+		schedules, err := gs.GetAppointmentSchedule(r.Context(), q.ID)
+		if err != nil {
+			s.logger.Errorw("failed to get appointment schedule",
+				RequestIDContextKey, r.Context().Value(RequestIDContextKey),
+				"queue_id", q.ID,
+				"err", err,
+			)
+			return err
+		}
+
+		return s.sendResponse(http.StatusOK, schedules, w, r)
+	}
+}
+
+// GetWeeklyTimeBounds returns the earliest time any day's first open
+// timeslot starts and the latest time any day's last one ends, for laying
+// out a calendar that fits the whole week.
+func (s *Server) GetWeeklyTimeBounds(gs getAppointmentSchedule) E {
+	return func(w http.ResponseWriter, r *http.Request) error {
+		q, ok := r.Context().Value(queueContextKey).(*Queue)
+		if !ok {
+			return s.missingContextValue(r, queueContextKey)
+		}
+
+		schedules, err := gs.GetAppointmentSchedule(r.Context(), q.ID)
+		if err != nil {
+			s.logger.Errorw("failed to get appointment schedule",
+				RequestIDContextKey, r.Context().Value(RequestIDContextKey),
+				"queue_id", q.ID,
+				"err", err,
+			)
+			return err
+		}
+
+		var bounds WeeklyTimeBounds
+		for _, schedule := range schedules {
+			first, last := schedule.FirstOpenTimeslot(), schedule.LastOpenTimeslot()
+			if first < 0 {
+				continue
+			}
+
+			start, end := first*schedule.Duration, (last+1)*schedule.Duration
+			if bounds.Start == nil || start < *bounds.Start {
+				bounds.Start = &start
+			}
+			if bounds.End == nil || end > *bounds.End {
+				bounds.End = &end
+			}
+		}
+
+		return s.sendResponse(http.StatusOK, bounds, w, r)
+	}
+}
+
+type getAppointmentScheduleForDay interface {
+	GetAppointmentScheduleForDay(ctx context.Context, queue ksuid.KSUID, day int) (*AppointmentSchedule, error)
+}
+
+// GetAppointmentScheduleForDay returns a day's schedule. With
+// ?decode=true, the schedule string is also spelled out timeslot by
+// timeslot, with each one's time and capacity.
+func (s *Server) GetAppointmentScheduleForDay(gs getAppointmentScheduleForDay) E {
+	return func(w http.ResponseWriter, r *http.Request) error {
+		q, ok := r.Context().Value(queueContextKey).(*Queue)
+		if !ok {
+			return s.missingContextValue(r, queueContextKey)
+		}
+		day, ok := r.Context().Value(appointmentDayContextKey).(int)
+		if !ok {
+			return s.missingContextValue(r, appointmentDayContextKey)
+		}
+
+		schedule, err := gs.GetAppointmentScheduleForDay(r.Context(), q.ID, day)
+		if err != nil {
+			s.logger.Errorw("failed to get appointment schedule",
+				RequestIDContextKey, r.Context().Value(RequestIDContextKey),
+				"queue_id", q.ID,
+				"day", day,
+				"err", err,
+			)
+			return err
+		}
+
+		if decode, _ := strconv.ParseBool(r.URL.Query().Get("decode")); decode {
+			return s.sendResponse(http.StatusOK, struct {
+				*AppointmentSchedule
+				Decoded []*DecodedTimeslot `json:"decoded"`
+			}{schedule, decodeSchedule(day, schedule)}, w, r)
+		}
+
+		return s.sendResponse(http.StatusOK, schedule, w, r)
+	}
+}
+
+// decodeSchedule spells out a day's schedule string timeslot by timeslot.
+func decodeSchedule(day int, schedule *AppointmentSchedule) []*DecodedTimeslot {
+	dayStart, _ := WeekdayBounds(day)
+	decoded := make([]*DecodedTimeslot, 0, len(schedule.Schedule))
+	for i, n := range schedule.Schedule {
+		decoded = append(decoded, &DecodedTimeslot{
+			Timeslot:      i,
+			ScheduledTime: SlotStart(dayStart, i, schedule),
+			Capacity:      int(n - '0'),
+		})
+	}
+	return decoded
+}
+
+// NormalizeAppointmentSchedule checks the schedule in the body the same
+// way UpdateAppointmentSchedule would and sends it back spelled out,
+// without saving anything. Schedules that don't pass get the same error
+// an update would, with an INVALID_SCHEDULE code saying what's wrong.
+func (s *Server) NormalizeAppointmentSchedule() E {
+	return func(w http.ResponseWriter, r *http.Request) error {
+		q, ok := r.Context().Value(queueContextKey).(*Queue)
+		if !ok {
+			return s.missingContextValue(r, queueContextKey)
+		}
+		day, ok := r.Context().Value(appointmentDayContextKey).(int)
+		if !ok {
+			return s.missingContextValue(r, appointmentDayContextKey)
+		}
+		l := s.logger.With(
+			RequestIDContextKey, r.Context().Value(RequestIDContextKey),
+			"queue_id", q.ID,
+			"day", day,
+			"email", r.Context().Value(emailContextKey),
+		)
+
+		var schedule AppointmentSchedule
+		err := s.decodeLimitedBody(w, r, &schedule)
+		if errors.Is(err, errBodyTooLarge) {
+			l.Warnw("schedule request body too large")
+			return err
+		}
+		if err != nil {
+			l.Warnw("failed to decode schedule from body", "err", err)
+			return StatusError{
+				http.StatusBadRequest,
+				"We couldn't read the schedule in the request body.",
+			}
+		}
+
+		err = validateAppointmentSchedule(&schedule)
+		if err != nil {
+			l.Warnw("got invalid appointment schedule", "schedule", schedule, "err", err)
+			return err
+		}
+
+		schedule.Queue = q.ID
+		schedule.Day = time.Weekday(day)
+		decoded := decodeSchedule(day, &schedule)
+		open := 0
+		for _, t := range decoded {
+			open += t.Capacity
+		}
+
+		return s.sendResponse(http.StatusOK, struct {
+			*AppointmentSchedule
+			Timeslots int                `json:"timeslots"`
+			Slots     int                `json:"slots"`
+			Decoded   []*DecodedTimeslot `json:"decoded"`
+		}{&schedule, len(decoded), open, decoded}, w, r)
+	}
+}
+
+type addClaimEvent interface {
+	AddClaimEvent(ctx context.Context, event *ClaimEvent) error
+}
+
+type claimTimeslot interface {
+	addClaimEvent
+	getAppointmentScheduleForDay
+	getQueueConfiguration
+	ClaimTimeslot(ctx context.Context, queue ksuid.KSUID, day, timeslot int, email string) (*AppointmentSlot, error)
+}
+
+func (s *Server) ClaimTimeslot(cs claimTimeslot) E {
+	return func(w http.ResponseWriter, r *http.Request) error {
+		q, ok := r.Context().Value(queueContextKey).(*Queue)
+		if !ok {
+			return s.missingContextValue(r, queueContextKey)
+		}
+		email, ok := r.Context().Value(emailContextKey).(string)
+		if !ok {
+			return s.missingContextValue(r, emailContextKey)
+		}
+		day, ok := r.Context().Value(appointmentDayContextKey).(int)
+		if !ok {
+			return s.missingContextValue(r, appointmentDayContextKey)
+		}
+		timeslot, ok := r.Context().Value(appointmentTimeslotContextKey).(int)
+		if !ok {
+			return s.missingContextValue(r, appointmentTimeslotContextKey)
+		}
+		l := s.logger.With(
+			RequestIDContextKey, r.Context().Value(RequestIDContextKey),
+			"queue_id", q.ID,
+			"day", day,
+			"timeslot", timeslot,
+			"email", email,
+		)
+
+		// The claim would fail anyway, but this way it's a 404 rather
+		// than looking like someone else got there first.
+		notFound := StatusError{
+			http.StatusNotFound,
+			"I don't think that timeslot exists, as much as I'd like it to.",
+		}
+		schedule, err := cs.GetAppointmentScheduleForDay(r.Context(), q.ID, day)
+		if errors.Is(err, sql.ErrNoRows) {
+			l.Warnw("attempted to claim timeslot on day with no schedule")
+			return notFound
+		} else if err != nil {
+			l.Errorw("failed to get appointment schedule", "err", err)
+			return err
+		}
+
+		if timeslot >= len(schedule.Schedule) {
+			l.Warnw("attempted to claim non-existent timeslot", "num_slots", len(schedule.Schedule))
+			return notFound
+		}
+
+		config, err := cs.GetQueueConfiguration(r.Context(), q.ID)
+		if err != nil {
+			l.Errorw("failed to get queue configuration", "err", err)
+			return err
+		}
+
+		// Staff running a little late can still claim the slot they're
+		// walking into, but not ones that are well and truly over.
+		dayStart, _ := WeekdayBounds(day)
+		start := SlotStart(dayStart, timeslot, schedule)
+		grace := time.Duration(config.ClaimGraceMinutes) * time.Minute
+		if time.Now().After(start.Add(grace)) {
+			l.Warnw("attempted to claim timeslot in the past",
+				"scheduled_time", start,
+				"claim_grace_minutes", config.ClaimGraceMinutes,
+			)
+			return StatusError{
+				http.StatusBadRequest,
+				"That timeslot has already started, so it's too late to claim it.",
+			}
+		}
+
+		appointment, err := cs.ClaimTimeslot(r.Context(), q.ID, day, timeslot, email)
+		if err != nil {
+			l.Errorw("failed to claim timeslot", "err", err)
+			return StatusError{
+				http.StatusBadRequest,
+				"Failed to claim timeslot. Perhaps it has already been claimed? error: " + err.Error(),
+			}
+		}
+
+		err = cs.AddClaimEvent(r.Context(), &ClaimEvent{
+			Queue:         q.ID,
+			Appointment:   appointment.ID,
+			ScheduledTime: appointment.ScheduledTime,
+			Timeslot:      appointment.Timeslot,
+			Action:        ClaimActionClaim,
+			Email:         email,
+			StaffEmail:    email,
+		})
+		if err != nil {
+			l.Errorw("failed to record claim event", "err", err)
+			return err
+		}
+
+		l.Infow("appointment claimed")
+
+		s.ps.Pub(WS("APPOINTMENT_CREATE", appointment), QueueTopicAdmin(q.ID))
+		s.publishAppointmentEvent(r.Context(), l, AppointmentClaim, q.ID, appointment, nil)
+
+		return s.sendResponse(http.StatusCreated, nil, w, r)
+	}
+}
+
+type claimTimeslotsByPattern interface {
+	claimTimeslot
+	getAppointmentsByTimeslot
+}
+
+// ClaimTimeslotsByPattern claims every stride-th timeslot on a day,
+// starting at offset, for staff who cover alternating slots. Timeslots that
+// are breaks, have already started, are already claimed by the staff
+// member, or have no room for another claim are skipped; the response
+// says what happened to each one the pattern matched.
+func (s *Server) ClaimTimeslotsByPattern(cs claimTimeslotsByPattern) E {
+	return func(w http.ResponseWriter, r *http.Request) error {
+		q, ok := r.Context().Value(queueContextKey).(*Queue)
+		if !ok {
+			return s.missingContextValue(r, queueContextKey)
+		}
+		email, ok := r.Context().Value(emailContextKey).(string)
+		if !ok {
+			return s.missingContextValue(r, emailContextKey)
+		}
+		day, ok := r.Context().Value(appointmentDayContextKey).(int)
+		if !ok {
+			return s.missingContextValue(r, appointmentDayContextKey)
+		}
+		l := s.logger.With(
+			RequestIDContextKey, r.Context().Value(RequestIDContextKey),
+			"queue_id", q.ID,
+			"day", day,
+			"email", email,
+		)
+
+		var body struct {
+			Stride int `json:"stride"`
+			Offset int `json:"offset"`
+		}
+		err := s.decodeLimitedBody(w, r, &body)
+		if errors.Is(err, errBodyTooLarge) {
+			l.Warnw("claim pattern request body too large")
+			return err
+		}
+		if err != nil {
+			l.Warnw("failed to decode claim pattern from body", "err", err)
+			return StatusError{
+				http.StatusBadRequest,
+				"We couldn't read the claim pattern in the request body.",
+			}
+		}
+		l = l.With("stride", body.Stride, "offset", body.Offset)
+
+		if body.Stride < 1 || body.Offset < 0 {
+			l.Warnw("got invalid claim pattern")
+			return StatusError{
+				http.StatusBadRequest,
+				"The stride needs to be at least 1, and the offset can't be negative.",
+			}
+		}
+
+		schedule, err := cs.GetAppointmentScheduleForDay(r.Context(), q.ID, day)
+		if errors.Is(err, sql.ErrNoRows) {
+			l.Warnw("attempted to claim timeslots on day with no schedule")
+			return StatusError{
+				http.StatusNotFound,
+				"There's no schedule on that day to claim anything from.",
+			}
+		} else if err != nil {
+			l.Errorw("failed to get appointment schedule", "err", err)
+			return err
+		}
+
+		config, err := cs.GetQueueConfiguration(r.Context(), q.ID)
+		if err != nil {
+			l.Errorw("failed to get queue configuration", "err", err)
+			return err
+		}
+
+		dayStart, dayEnd := WeekdayBounds(day)
+		grace := time.Duration(config.ClaimGraceMinutes) * time.Minute
+		results := make([]*PatternClaimResult, 0)
+		var claimed []*AppointmentSlot
+		for timeslot := body.Offset; timeslot < len(schedule.Schedule); timeslot += body.Stride {
+			result := &PatternClaimResult{Timeslot: timeslot}
+			results = append(results, result)
+
+			if schedule.Schedule[timeslot] == '0' {
+				result.Reason = PatternClaimSkippedBreak
+				continue
+			}
+
+			if time.Now().After(SlotStart(dayStart, timeslot, schedule).Add(grace)) {
+				result.Reason = PatternClaimSkippedPast
+				continue
+			}
+
+			slots, err := cs.GetAppointmentsByTimeslot(r.Context(), q.ID, dayStart, dayEnd, timeslot)
+			if err != nil {
+				l.Errorw("failed to get appointments for timeslot", "timeslot", timeslot, "err", err)
+				return err
+			}
+
+			// Mirrors what ClaimTimeslot in the database will accept, so
+			// that anything it turns down here is a real error.
+			unclaimed := false
+			for _, slot := range slots {
+				if slot.StaffEmail != nil && *slot.StaffEmail == email {
+					result.Reason = PatternClaimSkippedMine
+				}
+				if slot.StaffEmail == nil {
+					unclaimed = true
+				}
+			}
+			if result.Reason != "" {
+				continue
+			}
+			if !unclaimed && int(schedule.Schedule[timeslot]-'0')-len(slots) < 1 {
+				result.Reason = PatternClaimSkippedNoRoom
+				continue
+			}
+
+			appointment, err := cs.ClaimTimeslot(r.Context(), q.ID, day, timeslot, email)
+			if err != nil {
+				l.Errorw("failed to claim timeslot", "timeslot", timeslot, "err", err)
+				return err
+			}
+
+			err = cs.AddClaimEvent(r.Context(), &ClaimEvent{
+				Queue:         q.ID,
+				Appointment:   appointment.ID,
+				ScheduledTime: appointment.ScheduledTime,
+				Timeslot:      appointment.Timeslot,
+				Action:        ClaimActionClaim,
+				Email:         email,
+				StaffEmail:    email,
+			})
+			if err != nil {
+				l.Errorw("failed to record claim event", "timeslot", timeslot, "err", err)
+				return err
+			}
+
+			result.Claimed = true
+			result.Appointment = appointment
+			claimed = append(claimed, appointment)
+		}
+
+		l.Infow("claimed timeslots by pattern", "claimed", len(claimed), "matched", len(results))
+
+		for _, appointment := range claimed {
+			s.ps.Pub(WS("APPOINTMENT_CREATE", appointment), QueueTopicAdmin(q.ID))
+			s.publishAppointmentEvent(r.Context(), l, AppointmentClaim, q.ID, appointment, nil)
+		}
+
+		return s.sendResponse(http.StatusOK, results, w, r)
+	}
+}
+
+type unclaimAppointment interface {
+	addClaimEvent
+	UnclaimAppointment(ctx context.Context, appointment ksuid.KSUID) (deleted bool, err error)
+}
+
+func (s *Server) UnclaimAppointment(us unclaimAppointment) E {
+	return func(w http.ResponseWriter, r *http.Request) error {
+		q, ok := r.Context().Value(queueContextKey).(*Queue)
+		if !ok {
+			return s.missingContextValue(r, queueContextKey)
+		}
+		appointment, ok := r.Context().Value(appointmentContextKey).(*AppointmentSlot)
+		if !ok {
+			return s.missingContextValue(r, appointmentContextKey)
+		}
+		email, ok := r.Context().Value(emailContextKey).(string)
+		if !ok {
+			return s.missingContextValue(r, emailContextKey)
+		}
+
+		deleted, err := us.UnclaimAppointment(r.Context(), appointment.ID)
+		if err != nil {
+			s.logger.Errorw("failed to remove appointment claim",
+				RequestIDContextKey, r.Context().Value(RequestIDContextKey),
+				"appointment_id", appointment.ID,
+				"err", err,
+			)
+			return err
+		}
+
+		// Unclaiming an appointment nobody had claimed doesn't change
+		// anything, so there's nothing to record.
+		if appointment.StaffEmail != nil {
+			err = us.AddClaimEvent(r.Context(), &ClaimEvent{
+				Queue:         q.ID,
+				Appointment:   appointment.ID,
+				ScheduledTime: appointment.ScheduledTime,
+				Timeslot:      appointment.Timeslot,
+				Action:        ClaimActionUnclaim,
+				Email:         email,
+				StaffEmail:    *appointment.StaffEmail,
+			})
+			if err != nil {
+				s.logger.Errorw("failed to record unclaim event",
+					RequestIDContextKey, r.Context().Value(RequestIDContextKey),
+					"appointment_id", appointment.ID,
+					"err", err,
+				)
+				return err
+			}
+		}
+
+		s.logger.Infow("removed appointment claim",
+			RequestIDContextKey, r.Context().Value(RequestIDContextKey),
+			"appointment_id", appointment.ID,
+			"email", r.Context().Value(emailContextKey),
+		)
+
+		if deleted {
+			s.ps.Pub(WS("APPOINTMENT_REMOVE", appointment), QueueTopicAdmin(q.ID))
+		} else {
+			appointment.StaffEmail = nil
+			s.ps.Pub(WS("APPOINTMENT_UPDATE", appointment), QueueTopicAdmin(q.ID))
+		}
+
+		return s.sendResponse(http.StatusNoContent, nil, w, r)
+	}
+}
+
+type cloneAppointmentSchedules interface {
+	getQueue
+	courseAdmin
+	getAppointmentsInTimeFrame
+	getAppointmentSchedule
+	getAppointmentScheduleForDay
+	getQueueConfiguration
+	pendingScheduleChanges
+	addScheduleAuditEntry
+	UpdateAppointmentSchedule(ctx context.Context, queue ksuid.KSUID, day int, schedule *AppointmentSchedule) error
+}
+
+// CloneAppointmentSchedules copies every day's appointment schedule from
+// another queue onto this one, for setting up a new section like an
+// existing one. The admin needs to be an admin of both queues' courses.
+// On queues that need schedule changes approved, each day's copy waits
+// for approval like any other change.
+func (s *Server) CloneAppointmentSchedules(cs cloneAppointmentSchedules) E {
+	return func(w http.ResponseWriter, r *http.Request) error {
+		q, ok := r.Context().Value(queueContextKey).(*Queue)
+		if !ok {
+			return s.missingContextValue(r, queueContextKey)
+		}
+		email, ok := r.Context().Value(emailContextKey).(string)
+		if !ok {
+			return s.missingContextValue(r, emailContextKey)
+		}
+		l := s.logger.With(
+			RequestIDContextKey, r.Context().Value(RequestIDContextKey),
+			"queue_id", q.ID,
+			"email", email,
+		)
+
+		var body struct {
+			Source ksuid.KSUID `json:"source"`
+		}
+		err := s.decodeLimitedBody(w, r, &body)
+		if errors.Is(err, errBodyTooLarge) {
+			l.Warnw("clone request body too large")
+			return err
+		}
+		if err != nil {
+			l.Warnw("failed to decode clone source from body", "err", err)
+			return StatusError{
+				http.StatusBadRequest,
+				"We couldn't read the queue to copy from in the request body.",
+			}
+		}
+		l = l.With("source_queue_id", body.Source)
+
+		if body.Source == q.ID {
+			l.Warnw("attempted to clone appointment schedule onto itself")
+			return StatusError{
+				http.StatusBadRequest,
+				"This queue already has its own schedule!",
+			}
+		}
+
+		if q.Type != Appointments {
+			l.Warnw("attempted to clone appointment schedule onto non-appointments queue")
+			return StatusError{
+				http.StatusBadRequest,
+				"Only appointments queues have appointment schedules.",
+			}
+		}
+
+		source, err := cs.GetQueue(r.Context(), body.Source)
+		if errors.Is(err, sql.ErrNoRows) {
+			l.Warnw("attempted to clone appointment schedule from non-existent queue")
+			return StatusError{
+				http.StatusNotFound,
+				"We couldn't find the queue to copy from.",
+			}
+		} else if err != nil {
+			l.Errorw("failed to get source queue", "err", err)
+			return err
+		}
+
+		if source.Type != Appointments {
+			l.Warnw("attempted to clone appointment schedule from non-appointments queue")
+			return StatusError{
+				http.StatusBadRequest,
+				"The queue to copy from isn't an appointments queue.",
+			}
+		}
+
+		admin, err := cs.CourseAdmin(r.Context(), source.Course, email)
+		if err != nil {
+			l.Errorw("failed to check course admin status on source queue", "err", err)
+			return err
+		}
+
+		if !admin {
+			l.Warnw("non-admin attempted to clone appointment schedule from queue")
+			return StatusError{
+				http.StatusForbidden,
+				"You need to be an admin of the queue you're copying from.",
+			}
+		}
+
+		// Replacing the whole schedule would leave anything already booked
+		// without a timeslot, so this is only for queues nobody's using yet.
+		upcoming, err := cs.GetAppointments(r.Context(), q.ID, time.Now(), BigTime())
+		if err != nil {
+			l.Errorw("failed to get upcoming appointments", "err", err)
+			return err
+		}
+
+		if len(upcoming) > 0 {
+			l.Warnw("attempted to clone appointment schedule onto queue with upcoming appointments",
+				"appointments", len(upcoming),
+			)
+			return StatusError{
+				http.StatusConflict,
+				"This queue already has upcoming appointments, so its schedule can't be replaced wholesale.",
+			}
+		}
+
+		schedules, err := cs.GetAppointmentSchedule(r.Context(), source.ID)
+		if err != nil {
+			l.Errorw("failed to get source appointment schedule", "err", err)
+			return err
+		}
+
+		config, err := cs.GetQueueConfiguration(r.Context(), q.ID)
+		if err != nil {
+			l.Errorw("failed to get queue configuration", "err", err)
+			return err
+		}
+
+		if config.RequireScheduleApproval {
+			pending := make([]*PendingScheduleChange, 0, len(schedules))
+			for _, schedule := range schedules {
+				day := int(schedule.Day)
+				schedule.Queue = q.ID
+				err = cs.SetPendingScheduleChange(r.Context(), q.ID, day, schedule, email)
+				if err != nil {
+					l.Errorw("failed to set pending schedule change", "day", day, "err", err)
+					return err
+				}
+
+				change, err := cs.GetPendingScheduleChange(r.Context(), q.ID, day)
+				if err != nil {
+					l.Errorw("failed to get pending schedule change", "day", day, "err", err)
+					return err
+				}
+				pending = append(pending, change)
+			}
+
+			l.Infow("proposed cloned appointment schedule")
+
+			for _, change := range pending {
+				s.ps.Pub(WS("SCHEDULE_CHANGE_PENDING", change), QueueTopicAdmin(q.ID))
+			}
+
+			return s.sendResponse(http.StatusAccepted, pending, w, r)
+		}
+
+		for _, schedule := range schedules {
+			day := int(schedule.Day)
+			current, err := cs.GetAppointmentScheduleForDay(r.Context(), q.ID, day)
+			if err != nil {
+				l.Errorw("failed to get existing appointment schedule", "day", day, "err", err)
+				return err
+			}
+
+			err = cs.UpdateAppointmentSchedule(r.Context(), q.ID, day, schedule)
+			if err != nil {
+				l.Errorw("failed to update appointment schedule", "day", day, "err", err)
+				return err
+			}
+			schedule.Queue = q.ID
+
+			err = cs.AddScheduleAuditEntry(r.Context(), &ScheduleAuditEntry{
+				Queue:  q.ID,
+				Day:    day,
+				Email:  email,
+				Before: current,
+				After:  schedule,
+			})
+			if err != nil {
+				l.Errorw("failed to record schedule audit entry", "day", day, "err", err)
+				return err
+			}
+		}
+
+		l.Infow("cloned appointment schedule")
+
+		s.ps.Pub(WS("REFRESH", nil), QueueTopicGeneric(q.ID))
+
+		return s.sendResponse(http.StatusOK, schedules, w, r)
+	}
+}
+
+type updateAppointmentSchedule interface {
+	checkAppointmentScheduleChange
+	getAppointmentScheduleForDay
+	getQueueConfiguration
+	pendingScheduleChanges
+	addScheduleAuditEntry
+	releaseOrphanedClaims
+	UpdateAppointmentSchedule(ctx context.Context, queue ksuid.KSUID, day int, schedule *AppointmentSchedule) error
+}
+
+func (s *Server) UpdateAppointmentSchedule(us updateAppointmentSchedule) E {
+	return func(w http.ResponseWriter, r *http.Request) error {
+		q, ok := r.Context().Value(queueContextKey).(*Queue)
+		if !ok {
+			return s.missingContextValue(r, queueContextKey)
+		}
+		email, ok := r.Context().Value(emailContextKey).(string)
+		if !ok {
+			return s.missingContextValue(r, emailContextKey)
+		}
+		day, ok := r.Context().Value(appointmentDayContextKey).(int)
+		if !ok {
+			return s.missingContextValue(r, appointmentDayContextKey)
+		}
+		l := s.logger.With(
+			RequestIDContextKey, r.Context().Value(RequestIDContextKey),
+			"queue_id", q.ID,
+			"day", day,
+			"email", email,
+		)
+
+		currentSchedule, err := us.GetAppointmentScheduleForDay(r.Context(), q.ID, day)
+		if err != nil {
+			l.Errorw("failed to get existing appointment schedule", "err", err)
+			return err
+		}
+
+		var schedule AppointmentSchedule
+		err = s.decodeLimitedBody(w, r, &schedule)
+		if errors.Is(err, errBodyTooLarge) {
+			l.Warnw("schedule request body too large")
+			return err
+		}
+		if err != nil {
+			l.Warnw("failed to decode schedule from body", "err", err)
+			return StatusError{
+				http.StatusBadRequest,
+				"We couldn't read the schedule in the request body.",
+			}
+		}
+
+		err = validateAppointmentSchedule(&schedule)
+		if err != nil {
+			l.Warnw("got invalid appointment schedule", "schedule", schedule, "err", err)
+			return err
+		}
+
+		config, err := us.GetQueueConfiguration(r.Context(), q.ID)
+		if err != nil {
+			l.Errorw("failed to get queue configuration", "err", err)
+			return err
+		}
+
+		// With ?release_claims=true, staff claims that the new schedule
+		// has no room for are let go of rather than holding the change
+		// up. Releasing them for a change that might never be approved
+		// would leave staff without their claims for nothing, so queues
+		// that need approval can't do this.
+		var released []*AppointmentSlot
+		var releaseMessages []*Message
+		if release, _ := strconv.ParseBool(r.URL.Query().Get("release_claims")); release {
+			if config.RequireScheduleApproval {
+				l.Warnw("attempted to release claims for schedule change needing approval")
+				return StatusError{
+					http.StatusBadRequest,
+					"Claims can't be released for schedule changes that need approval. Ask staff to unclaim those timeslots first.",
+				}
+			}
+
+			released, releaseMessages, err = s.releaseOrphanedClaims(r.Context(), l, us, q, day, currentSchedule, &schedule, email)
+			if err != nil {
+				return err
+			}
+		}
+
+		// Checked now so that nobody gets asked to approve a change that
+		// could never apply; it's checked again on approval in case
+		// appointments have come in since.
+		err = s.checkAppointmentScheduleChange(r.Context(), l, us, q.ID, day, currentSchedule, &schedule, !config.RequireScheduleApproval)
+		if err != nil {
+			return err
+		}
+
+		if config.RequireScheduleApproval {
+			err = us.SetPendingScheduleChange(r.Context(), q.ID, day, &schedule, email)
+			if err != nil {
+				l.Errorw("failed to set pending schedule change", "err", err)
+				return err
+			}
+
+			pending, err := us.GetPendingScheduleChange(r.Context(), q.ID, day)
+			if err != nil {
+				l.Errorw("failed to get pending schedule change", "err", err)
+				return err
+			}
+
+			l.Infow("proposed appointment schedule change")
+
+			s.ps.Pub(WS("SCHEDULE_CHANGE_PENDING", pending), QueueTopicAdmin(q.ID))
+
+			return s.sendResponse(http.StatusAccepted, pending, w, r)
+		}
+
+		err = us.UpdateAppointmentSchedule(r.Context(), q.ID, day, &schedule)
+		if err != nil {
+			l.Errorw("failed to update appointment schedule", "err", err)
+			return err
+		}
+
+		err = us.AddScheduleAuditEntry(r.Context(), &ScheduleAuditEntry{
+			Queue:  q.ID,
+			Day:    day,
+			Email:  email,
+			Before: currentSchedule,
+			After:  &schedule,
+		})
+		if err != nil {
+			l.Errorw("failed to record schedule audit entry", "err", err)
+			return err
+		}
+
+		l.Infow("updated appointment schedule")
+
+		for _, a := range released {
+			s.ps.Pub(WS("APPOINTMENT_REMOVE", a), QueueTopicAdmin(q.ID))
+		}
+		for _, message := range releaseMessages {
+			s.ps.Pub(WS("MESSAGE_CREATE", message), QueueTopicEmail(q.ID, message.Receiver))
+		}
+		s.ps.Pub(WS("REFRESH", nil), QueueTopicGeneric(q.ID))
+
+		return s.sendResponse(http.StatusNoContent, nil, w, r)
+	}
+}
+
+// scheduleBatchFailed is the code for a batch of schedule changes where
+// at least one day couldn't be changed, so none of them were.
+const scheduleBatchFailed = "SCHEDULE_BATCH_FAILED"
+
+// scheduleBatchFailure is why one day in a batch couldn't be changed.
+type scheduleBatchFailure struct {
+	Day     int    `json:"day"`
+	Message string `json:"message"`
+}
+
+// UpdateAppointmentSchedulesBatch sets the schedules for several days at
+// once, from a body mapping days to schedules. Every day is checked the
+// same way UpdateAppointmentSchedule checks one before anything is
+// written, and if any of them fails the whole batch is turned down with
+// the days that failed and why.
+func (s *Server) UpdateAppointmentSchedulesBatch(us updateAppointmentSchedule) E {
+	return func(w http.ResponseWriter, r *http.Request) error {
+		q, ok := r.Context().Value(queueContextKey).(*Queue)
+		if !ok {
+			return s.missingContextValue(r, queueContextKey)
+		}
+		email, ok := r.Context().Value(emailContextKey).(string)
+		if !ok {
+			return s.missingContextValue(r, emailContextKey)
+		}
+		l := s.logger.With(
+			RequestIDContextKey, r.Context().Value(RequestIDContextKey),
+			"queue_id", q.ID,
+			"email", email,
+		)
+
+		var schedules map[int]*AppointmentSchedule
+		err := s.decodeLimitedBody(w, r, &schedules)
+		if errors.Is(err, errBodyTooLarge) {
+			l.Warnw("schedule batch request body too large")
+			return err
+		}
+		if err != nil {
+			l.Warnw("failed to decode schedule batch from body", "err", err)
+			return StatusError{
+				http.StatusBadRequest,
+				"We couldn't read the schedules in the request body.",
+			}
+		}
+
+		if len(schedules) == 0 {
+			l.Warnw("got empty schedule batch")
+			return StatusError{
+				http.StatusBadRequest,
+				"There aren't any schedules in the request body.",
+			}
+		}
+
+		days := make([]int, 0, len(schedules))
+		for day := range schedules {
+			days = append(days, day)
+		}
+		sort.Ints(days)
+
+		failures := make([]*scheduleBatchFailure, 0)
+		currents := make(map[int]*AppointmentSchedule)
+
+		// recordFailure notes why a day failed if err is a StatusError, and
+		// passes anything else (like a database error) back up.
+		recordFailure := func(day int, err error) error {
+			var se StatusError
+			if !errors.As(err, &se) {
+				return err
+			}
+			failures = append(failures, &scheduleBatchFailure{Day: day, Message: se.message})
+			return nil
+		}
+
+		for _, day := range days {
+			dl := l.With("day", day)
+			schedule := schedules[day]
+			if schedule == nil {
+				failures = append(failures, &scheduleBatchFailure{Day: day, Message: "There's no schedule for this day."})
+				continue
+			}
+
+			current, err := us.GetAppointmentScheduleForDay(r.Context(), q.ID, day)
+			if errors.Is(err, sql.ErrNoRows) {
+				dl.Warnw("got schedule batch with non-existent day")
+				failures = append(failures, &scheduleBatchFailure{Day: day, Message: "Are you sure that's a day?"})
+				continue
+			} else if err != nil {
+				dl.Errorw("failed to get existing appointment schedule", "err", err)
+				return err
+			}
+			currents[day] = current
+
+			err = validateAppointmentSchedule(schedule)
+			if err != nil {
+				dl.Warnw("got invalid appointment schedule", "schedule", schedule, "err", err)
+				if err = recordFailure(day, err); err != nil {
+					return err
+				}
+				continue
+			}
+
+			// Batches don't release claims.
+			err = s.checkAppointmentScheduleChange(r.Context(), dl, us, q.ID, day, current, schedule, false)
+			if err = recordFailure(day, err); err != nil {
+				return err
+			}
+		}
+
+		if len(failures) > 0 {
+			l.Warnw("rejected schedule batch", "failures", len(failures))
+			return DetailedError{
+				StatusError{
+					http.StatusConflict,
+					"Some of those days' schedules couldn't be set, so none of them were.",
+				},
+				scheduleBatchFailed,
+				failures,
+			}
+		}
+
+		config, err := us.GetQueueConfiguration(r.Context(), q.ID)
+		if err != nil {
+			l.Errorw("failed to get queue configuration", "err", err)
+			return err
+		}
+
+		// Any error from here on rolls back the request's transaction, so
+		// a batch never ends up half written.
+		if config.RequireScheduleApproval {
+			pending := make([]*PendingScheduleChange, 0, len(days))
+			for _, day := range days {
+				err = us.SetPendingScheduleChange(r.Context(), q.ID, day, schedules[day], email)
+				if err != nil {
+					l.Errorw("failed to set pending schedule change", "day", day, "err", err)
+					return err
+				}
+
+				change, err := us.GetPendingScheduleChange(r.Context(), q.ID, day)
+				if err != nil {
+					l.Errorw("failed to get pending schedule change", "day", day, "err", err)
+					return err
+				}
+				pending = append(pending, change)
+			}
+
+			l.Infow("proposed appointment schedule changes", "days", days)
+
+			for _, change := range pending {
+				s.ps.Pub(WS("SCHEDULE_CHANGE_PENDING", change), QueueTopicAdmin(q.ID))
+			}
+
+			return s.sendResponse(http.StatusAccepted, pending, w, r)
+		}
+
+		for _, day := range days {
+			err = us.UpdateAppointmentSchedule(r.Context(), q.ID, day, schedules[day])
+			if err != nil {
+				l.Errorw("failed to update appointment schedule", "day", day, "err", err)
+				return err
+			}
+
+			err = us.AddScheduleAuditEntry(r.Context(), &ScheduleAuditEntry{
+				Queue:  q.ID,
+				Day:    day,
+				Email:  email,
+				Before: currents[day],
+				After:  schedules[day],
+			})
+			if err != nil {
+				l.Errorw("failed to record schedule audit entry", "day", day, "err", err)
+				return err
+			}
+		}
+
+		l.Infow("updated appointment schedules", "days", days)
+
+		s.ps.Pub(WS("REFRESH", nil), QueueTopicGeneric(q.ID))
+
+		return s.sendResponse(http.StatusNoContent, nil, w, r)
+	}
+}
+
+type previewScheduleChange interface {
+	getAppointmentScheduleForDay
+	getAppointmentsInTimeFrame
+}
+
+// PreviewScheduleChange shows what would happen if the day's schedule were
+// replaced with the one in the body, without changing anything.
+func (s *Server) PreviewScheduleChange(ps previewScheduleChange) E {
+	return func(w http.ResponseWriter, r *http.Request) error {
+		q, ok := r.Context().Value(queueContextKey).(*Queue)
+		if !ok {
+			return s.missingContextValue(r, queueContextKey)
+		}
+		day, ok := r.Context().Value(appointmentDayContextKey).(int)
+		if !ok {
+			return s.missingContextValue(r, appointmentDayContextKey)
+		}
+		l := s.logger.With(
+			RequestIDContextKey, r.Context().Value(RequestIDContextKey),
+			"queue_id", q.ID,
+			"day", day,
+			"email", r.Context().Value(emailContextKey),
+		)
+
+		current, err := ps.GetAppointmentScheduleForDay(r.Context(), q.ID, day)
+		if err != nil {
+			l.Errorw("failed to get existing appointment schedule", "err", err)
+			return err
+		}
+
+		var schedule AppointmentSchedule
+		err = s.decodeLimitedBody(w, r, &schedule)
+		if errors.Is(err, errBodyTooLarge) {
+			l.Warnw("schedule request body too large")
+			return err
+		}
+		if err != nil {
+			l.Warnw("failed to decode schedule from body", "err", err)
+			return StatusError{
+				http.StatusBadRequest,
+				"We couldn't read the schedule in the request body.",
+			}
+		}
+
+		err = validateAppointmentSchedule(&schedule)
+		if err != nil {
+			l.Warnw("got invalid appointment schedule", "schedule", schedule, "err", err)
+			return err
+		}
+
+		start, end := WeekdayBounds(day)
+		appointments, err := ps.GetAppointments(r.Context(), q.ID, start, end)
+		if err != nil {
+			l.Errorw("failed to get appointments", "err", err)
+			return err
+		}
+
+		return s.sendResponse(http.StatusOK, scheduleDiff(day, current, &schedule, appointments), w, r)
+	}
+}
+
+// scheduleDiff compares two schedules for a day timeslot by timeslot,
+// using the same rules as checkAppointmentScheduleChange to decide what
+// would conflict with the appointments already on the day.
+func scheduleDiff(day int, current, proposed *AppointmentSchedule, appointments []*AppointmentSlot) *ScheduleDiff {
+	booked := make(map[int]int)
+	for _, a := range appointments {
+		for t := a.Timeslot; t < a.Timeslot+a.Span(); t++ {
+			booked[t]++
+		}
+	}
+
+	diff := &ScheduleDiff{
+		CurrentDuration:  current.Duration,
+		ProposedDuration: proposed.Duration,
+		Timeslots:        make([]*TimeslotChange, 0),
+		CanApply:         true,
+	}
+	durationChanged := current.Duration != proposed.Duration
+	dayStart, _ := WeekdayBounds(day)
+
+	n := len(current.Schedule)
+	if len(proposed.Schedule) > n {
+		n = len(proposed.Schedule)
+	}
+	for i := 0; i < n; i++ {
+		change := &TimeslotChange{
+			Timeslot:     i,
+			Appointments: booked[i],
+		}
+		if i < len(current.Schedule) {
+			change.CurrentCapacity = int(current.Schedule[i] - '0')
+		}
+		if i < len(proposed.Schedule) {
+			change.ProposedCapacity = int(proposed.Schedule[i] - '0')
+			scheduled := SlotStart(dayStart, i, proposed)
+			change.ScheduledTime = &scheduled
+		}
+
+		// With a new duration, every appointment would end up at a
+		// different time than it was booked for.
+		change.Conflict = change.Appointments > 0 &&
+			(durationChanged || change.ProposedCapacity < change.Appointments)
+		if change.Conflict {
+			diff.CanApply = false
+		}
+
+		if change.CurrentCapacity == change.ProposedCapacity && !change.Conflict && !durationChanged {
+			continue
+		}
+		diff.Timeslots = append(diff.Timeslots, change)
+	}
+
+	return diff
+}
+
+type setTimeslotNote interface {
+	getAppointmentScheduleForDay
+	SetTimeslotNote(ctx context.Context, queue ksuid.KSUID, day, timeslot int, note string) error
+}
+
+const maxTimeslotNoteLength = 500
+
+// SetTimeslotNote sets (or, if it's empty, clears) the note students see
+// when booking a timeslot.
+func (s *Server) SetTimeslotNote(sn setTimeslotNote) E {
+	return func(w http.ResponseWriter, r *http.Request) error {
+		q, ok := r.Context().Value(queueContextKey).(*Queue)
+		if !ok {
+			return s.missingContextValue(r, queueContextKey)
+		}
+		email, ok := r.Context().Value(emailContextKey).(string)
+		if !ok {
+			return s.missingContextValue(r, emailContextKey)
+		}
+		day, ok := r.Context().Value(appointmentDayContextKey).(int)
+		if !ok {
+			return s.missingContextValue(r, appointmentDayContextKey)
+		}
+		timeslot, ok := r.Context().Value(appointmentTimeslotContextKey).(int)
+		if !ok {
+			return s.missingContextValue(r, appointmentTimeslotContextKey)
+		}
+		l := s.logger.With(
+			RequestIDContextKey, r.Context().Value(RequestIDContextKey),
+			"queue_id", q.ID,
+			"day", day,
+			"timeslot", timeslot,
+			"email", email,
+		)
+
+		var body struct {
+			Note string `json:"note"`
+		}
+		err := s.decodeLimitedBody(w, r, &body)
+		if errors.Is(err, errBodyTooLarge) {
+			l.Warnw("timeslot note request body too large")
+			return err
+		}
+		if err != nil {
+			l.Warnw("failed to decode timeslot note from body", "err", err)
+			return StatusError{
+				http.StatusBadRequest,
+				"We couldn't read the note in the request body.",
+			}
+		}
+
+		note := strings.TrimSpace(body.Note)
+		if utf8.RuneCountInString(note) > maxTimeslotNoteLength {
+			l.Warnw("got timeslot note over max length", "length", utf8.RuneCountInString(note))
+			return StatusError{
+				http.StatusBadRequest,
+				fmt.Sprintf("Timeslot notes can be at most %d characters.", maxTimeslotNoteLength),
+			}
+		}
+
+		schedule, err := sn.GetAppointmentScheduleForDay(r.Context(), q.ID, day)
+		if err != nil {
+			l.Errorw("failed to get appointment schedule", "err", err)
+			return err
+		}
+
+		if timeslot >= len(schedule.Schedule) {
+			l.Warnw("attempted to set note on non-existent timeslot", "num_slots", len(schedule.Schedule))
+			return StatusError{
+				http.StatusNotFound,
+				"I don't think that timeslot exists, as much as I'd like it to.",
+			}
+		}
+
+		err = sn.SetTimeslotNote(r.Context(), q.ID, day, timeslot, note)
+		if err != nil {
+			l.Errorw("failed to set timeslot note", "err", err)
+			return err
+		}
+
+		l.Infow("set timeslot note", "note", note)
+
+		s.ps.Pub(WS("REFRESH", nil), QueueTopicGeneric(q.ID))
+
+		return s.sendResponse(http.StatusNoContent, nil, w, r)
+	}
+}
+
+type setTimeslotLocation interface {
+	getAppointmentScheduleForDay
+	SetTimeslotLocation(ctx context.Context, queue ksuid.KSUID, day, timeslot int, location string) error
+}
+
+const maxTimeslotLocationLength = 200
+
+// SetTimeslotLocation sets (or, if it's empty, clears) where appointments
+// at a timeslot happen when students leave the location blank.
+func (s *Server) SetTimeslotLocation(sl setTimeslotLocation) E {
+	return func(w http.ResponseWriter, r *http.Request) error {
+		q, ok := r.Context().Value(queueContextKey).(*Queue)
+		if !ok {
+			return s.missingContextValue(r, queueContextKey)
+		}
+		email, ok := r.Context().Value(emailContextKey).(string)
+		if !ok {
+			return s.missingContextValue(r, emailContextKey)
+		}
+		day, ok := r.Context().Value(appointmentDayContextKey).(int)
+		if !ok {
+			return s.missingContextValue(r, appointmentDayContextKey)
+		}
+		timeslot, ok := r.Context().Value(appointmentTimeslotContextKey).(int)
+		if !ok {
+			return s.missingContextValue(r, appointmentTimeslotContextKey)
+		}
+		l := s.logger.With(
+			RequestIDContextKey, r.Context().Value(RequestIDContextKey),
+			"queue_id", q.ID,
+			"day", day,
+			"timeslot", timeslot,
+			"email", email,
+		)
+
+		var body struct {
+			Location string `json:"location"`
+		}
+		err := s.decodeLimitedBody(w, r, &body)
+		if errors.Is(err, errBodyTooLarge) {
+			l.Warnw("timeslot location request body too large")
+			return err
+		}
+		if err != nil {
+			l.Warnw("failed to decode timeslot location from body", "err", err)
+			return StatusError{
+				http.StatusBadRequest,
+				"We couldn't read the location in the request body.",
+			}
+		}
+
+		location := strings.TrimSpace(body.Location)
+		if utf8.RuneCountInString(location) > maxTimeslotLocationLength {
+			l.Warnw("got timeslot location over max length", "length", utf8.RuneCountInString(location))
+			return StatusError{
+				http.StatusBadRequest,
+				fmt.Sprintf("Timeslot locations can be at most %d characters.", maxTimeslotLocationLength),
+			}
+		}
+
+		schedule, err := sl.GetAppointmentScheduleForDay(r.Context(), q.ID, day)
+		if err != nil {
+			l.Errorw("failed to get appointment schedule", "err", err)
+			return err
+		}
+
+		if timeslot >= len(schedule.Schedule) {
+			l.Warnw("attempted to set location on non-existent timeslot", "num_slots", len(schedule.Schedule))
+			return StatusError{
+				http.StatusNotFound,
+				"I don't think that timeslot exists, as much as I'd like it to.",
+			}
+		}
+
+		err = sl.SetTimeslotLocation(r.Context(), q.ID, day, timeslot, location)
+		if err != nil {
+			l.Errorw("failed to set timeslot location", "err", err)
+			return err
+		}
+
+		l.Infow("set timeslot location", "location", location)
+
+		s.ps.Pub(WS("REFRESH", nil), QueueTopicGeneric(q.ID))
+
+		return s.sendResponse(http.StatusNoContent, nil, w, r)
+	}
+}
+
+type assignTimeslot interface {
+	courseAdmin
+	getAppointmentScheduleForDay
+	SetTimeslotAssignment(ctx context.Context, queue ksuid.KSUID, day, timeslot int, email string) error
+}
+
+// AssignTAToTimeslot sets (or, if the email is empty, clears) the staff
+// member expected to cover a timeslot. It's separate from claims: it's
+// planned ahead of time, and whoever's assigned hears about sign-ups in
+// the timeslot whether or not they've claimed it yet.
+func (s *Server) AssignTAToTimeslot(at assignTimeslot) E {
+	return func(w http.ResponseWriter, r *http.Request) error {
+		q, ok := r.Context().Value(queueContextKey).(*Queue)
+		if !ok {
+			return s.missingContextValue(r, queueContextKey)
+		}
+		email, ok := r.Context().Value(emailContextKey).(string)
+		if !ok {
+			return s.missingContextValue(r, emailContextKey)
+		}
+		day, ok := r.Context().Value(appointmentDayContextKey).(int)
+		if !ok {
+			return s.missingContextValue(r, appointmentDayContextKey)
+		}
+		timeslot, ok := r.Context().Value(appointmentTimeslotContextKey).(int)
+		if !ok {
+			return s.missingContextValue(r, appointmentTimeslotContextKey)
+		}
+		l := s.logger.With(
+			RequestIDContextKey, r.Context().Value(RequestIDContextKey),
+			"queue_id", q.ID,
+			"day", day,
+			"timeslot", timeslot,
+			"email", email,
+		)
+
+		var body struct {
+			Email string `json:"email"`
+		}
+		err := s.decodeLimitedBody(w, r, &body)
+		if errors.Is(err, errBodyTooLarge) {
+			l.Warnw("timeslot assignment request body too large")
+			return err
+		}
+		if err != nil {
+			l.Warnw("failed to decode timeslot assignment from body", "err", err)
+			return StatusError{
+				http.StatusBadRequest,
+				"We couldn't read the assignment in the request body.",
+			}
+		}
+
+		assignee := strings.TrimSpace(body.Email)
+		if assignee != "" {
+			admin, err := at.CourseAdmin(r.Context(), q.Course, assignee)
+			if err != nil {
+				l.Errorw("failed to check course admin status of assignee", "assignee", assignee, "err", err)
+				return err
+			}
+
+			if !admin {
+				l.Warnw("attempted to assign non-staff member to timeslot", "assignee", assignee)
+				return StatusError{
+					http.StatusBadRequest,
+					fmt.Sprintf("%s isn't on the course staff, so they can't be assigned to a timeslot.", assignee),
+				}
+			}
+		}
+
+		schedule, err := at.GetAppointmentScheduleForDay(r.Context(), q.ID, day)
+		if err != nil {
+			l.Errorw("failed to get appointment schedule", "err", err)
+			return err
+		}
+
+		if timeslot >= len(schedule.Schedule) {
+			l.Warnw("attempted to assign non-existent timeslot", "num_slots", len(schedule.Schedule))
+			return StatusError{
+				http.StatusNotFound,
+				"I don't think that timeslot exists, as much as I'd like it to.",
+			}
+		}
+
+		err = at.SetTimeslotAssignment(r.Context(), q.ID, day, timeslot, assignee)
+		if err != nil {
+			l.Errorw("failed to set timeslot assignment", "err", err)
+			return err
+		}
+
+		l.Infow("set timeslot assignment", "assignee", assignee)
+
+		return s.sendResponse(http.StatusNoContent, nil, w, r)
+	}
+}
+
+// orphanedClaims is the code for a schedule change that's only held up by
+// staff claims on timeslots that would lose their room.
+const orphanedClaims = "ORPHANED_CLAIMS"
+
+// orphanedClaimsDetail is the timeslot a schedule change would orphan
+// claims at, and whose claims they are.
+type orphanedClaimsDetail struct {
+	Timeslot int      `json:"timeslot"`
+	Claims   []string `json:"claims"`
+}
+
+type releaseOrphanedClaims interface {
+	getAppointmentsInTimeFrame
+	getAppointmentsByTimeslot
+	unclaimAppointment
+	sendMessage
+}
+
+// releaseOrphanedClaims lets go of the staff claims without a student that
+// switching a day from current to schedule would leave without a place:
+// every one still to come if the duration is changing, and otherwise just
+// enough at each timeslot losing room to fit. Appointments students have
+// booked are left for checkAppointmentScheduleChange to turn the change
+// down over. Each staff member who loses a claim gets a message; the
+// released claims and messages are returned for publishing once the
+// change goes through.
+func (s *Server) releaseOrphanedClaims(ctx context.Context, l *zap.SugaredLogger, rc releaseOrphanedClaims, q *Queue, day int, current, schedule *AppointmentSchedule, email string) ([]*AppointmentSlot, []*Message, error) {
+	from, to := WeekdayBounds(day)
+
+	var orphaned []*AppointmentSlot
+	if current.Duration != schedule.Duration {
+		appointments, err := rc.GetAppointments(ctx, q.ID, from, to)
+		if err != nil {
+			l.Errorw("failed to get appointments", "err", err)
+			return nil, nil, err
+		}
+
+		// Claims that have already started are part of what happened,
+		// so they stay put.
+		now := time.Now()
+		for _, a := range appointments {
+			if a.StudentEmail == nil && a.StaffEmail != nil && a.ScheduledTime.After(now) {
+				orphaned = append(orphaned, a)
+			}
+		}
+	} else {
+		for i, n := range current.Schedule {
+			var capacity int
+			if i < len(schedule.Schedule) {
+				capacity = int(schedule.Schedule[i] - '0')
+			}
+
+			if capacity >= int(n-'0') {
+				continue
+			}
+
+			usage, err := rc.GetAppointmentsByTimeslot(ctx, q.ID, from, to, i)
+			if err != nil {
+				l.Errorw("failed to check appointments for timeslot", "err", err, "timeslot", i)
+				return nil, nil, err
+			}
+
+			excess := len(usage) - capacity
+			for _, a := range usage {
+				if excess <= 0 {
+					break
+				}
+				if a.StudentEmail == nil && a.StaffEmail != nil {
+					orphaned = append(orphaned, a)
+					excess--
+				}
+			}
+		}
+	}
+
+	released := make([]*AppointmentSlot, 0, len(orphaned))
+	times := make(map[string][]string)
+	var staff []string
+	for _, a := range orphaned {
+		_, err := rc.UnclaimAppointment(ctx, a.ID)
+		if err != nil {
+			l.Errorw("failed to release orphaned claim", "appointment_id", a.ID, "err", err)
+			return nil, nil, err
+		}
+
+		err = rc.AddClaimEvent(ctx, &ClaimEvent{
+			Queue:         q.ID,
+			Appointment:   a.ID,
+			ScheduledTime: a.ScheduledTime,
+			Timeslot:      a.Timeslot,
+			Action:        ClaimActionUnclaim,
+			Email:         email,
+			StaffEmail:    *a.StaffEmail,
+		})
+		if err != nil {
+			l.Errorw("failed to record unclaim event", "appointment_id", a.ID, "err", err)
+			return nil, nil, err
+		}
+
+		if _, ok := times[*a.StaffEmail]; !ok {
+			staff = append(staff, *a.StaffEmail)
+		}
+		times[*a.StaffEmail] = append(times[*a.StaffEmail], a.ScheduledTime.In(time.Local).Format("3:04 PM"))
+		released = append(released, a)
+	}
+
+	// One message per staff member, even if they lost several claims.
+	messages := make([]*Message, 0, len(staff))
+	for _, receiver := range staff {
+		content := fmt.Sprintf("Heads up: the appointment schedule for %s changed, so your claims at %s were released.",
+			time.Weekday(day), strings.Join(times[receiver], ", "),
+		)
+
+		message, err := rc.SendMessage(ctx, q.ID, content, email, receiver)
+		if err != nil {
+			l.Errorw("failed to send message about released claims", "receiver", receiver, "err", err)
+			return nil, nil, err
+		}
+		messages = append(messages, message)
+	}
+
+	if len(released) > 0 {
+		l.Infow("released orphaned claims", "num_claims", len(released))
+	}
+
+	return released, messages, nil
+}
+
+type checkAppointmentScheduleChange interface {
+	getAppointmentsInTimeFrame
+	getAppointmentsByTimeslot
+}
+
+// checkAppointmentScheduleChange makes sure that switching a day from
+// current to schedule won't leave any existing appointments without a
+// place to go. releasable is whether the caller could let go of staff
+// claims in the way (see releaseOrphanedClaims), which changes what
+// they're told to do about them.
+func (s *Server) checkAppointmentScheduleChange(ctx context.Context, l *zap.SugaredLogger, cs checkAppointmentScheduleChange, queue ksuid.KSUID, day int, current, schedule *AppointmentSchedule, releasable bool) error {
+	from, to := WeekdayBounds(day)
+
+	// Changing the duration moves every timeslot, so we only need to
+	// look at the day's appointments if that's what's happening.
+	if current.Duration != schedule.Duration {
+		appointments, err := cs.GetAppointments(ctx, queue, from, to)
+		if err != nil {
+			l.Errorw("failed to get appointments", "err", err)
+			return err
+		}
+
+		if len(appointments) > 0 {
+			l.Warnw("appointment schedule duration update attempted with existing appointments")
+			return StatusError{
+				http.StatusConflict,
+				"You can't change the appointment duration with active or past appointments on this day.",
+			}
+		}
+	}
+
+	// Only timeslots that are losing capacity (including ones that
+	// are being cut off the end of the schedule) can push out an
+	// existing appointment, so those are the only ones we check.
+	for i, n := range current.Schedule {
+		var newTimeslotAvailability int
+		if i < len(schedule.Schedule) {
+			newTimeslotAvailability = int(schedule.Schedule[i] - '0')
+		}
+
+		if newTimeslotAvailability >= int(n-'0') {
+			continue
+		}
+
+		currentTimeslotUsage, err := cs.GetAppointmentsByTimeslot(ctx, queue, from, to, i)
+		if err != nil {
+			l.Errorw("failed to check appointments for timeslot", "err", err, "timeslot", i)
+			return err
+		}
+
+		if newTimeslotAvailability < len(currentTimeslotUsage) {
+			l.Warnw("tried to change appointment schedule to one without room",
+				"conflicting_timeslot", i,
+				"current_appointments", len(currentTimeslotUsage),
+				"new_slots", newTimeslotAvailability,
+			)
+
+			// If it's only staff claims without a student that don't fit,
+			// say so; those can be let go of instead.
+			var booked int
+			var claims []string
+			for _, a := range currentTimeslotUsage {
+				if a.StudentEmail != nil {
+					booked++
+				} else if a.StaffEmail != nil {
+					claims = append(claims, *a.StaffEmail)
+				}
+			}
+			if booked <= newTimeslotAvailability {
+				message := fmt.Sprintf("Setting that appointment schedule would leave staff claims at timeslot %d without a place. Release them to go ahead anyway.", i)
+				if !releasable {
+					message = fmt.Sprintf("Setting that appointment schedule would leave staff claims at timeslot %d without a place. Ask staff to unclaim those timeslots first.", i)
+				}
+				return DetailedError{
+					StatusError{
+						http.StatusConflict,
+						message,
+					},
+					orphanedClaims,
+					orphanedClaimsDetail{Timeslot: i, Claims: claims},
+				}
+			}
+
+			return StatusError{
+				http.StatusConflict,
+				fmt.Sprintf("Setting that appointment schedule would remove an existing appointment. There are %d appointments at timeslot %d, but the new schedule only has %d slots at that time.",
+					len(currentTimeslotUsage), i, newTimeslotAvailability),
+			}
+		}
+	}
+
+	return nil
+}
+
+type pendingScheduleChanges interface {
+	GetPendingScheduleChange(ctx context.Context, queue ksuid.KSUID, day int) (*PendingScheduleChange, error)
+	SetPendingScheduleChange(ctx context.Context, queue ksuid.KSUID, day int, schedule *AppointmentSchedule, email string) error
+	RemovePendingScheduleChange(ctx context.Context, queue ksuid.KSUID, day int) error
+}
+
+var errNoPendingScheduleChange = StatusError{
+	http.StatusNotFound,
+	"There's no schedule change waiting for approval on that day.",
+}
+
+func (s *Server) GetPendingScheduleChange(ps pendingScheduleChanges) E {
+	return func(w http.ResponseWriter, r *http.Request) error {
+		q, ok := r.Context().Value(queueContextKey).(*Queue)
+		if !ok {
+			return s.missingContextValue(r, queueContextKey)
+		}
+		day, ok := r.Context().Value(appointmentDayContextKey).(int)
+		if !ok {
+			return s.missingContextValue(r, appointmentDayContextKey)
+		}
+
+		pending, err := ps.GetPendingScheduleChange(r.Context(), q.ID, day)
+		if errors.Is(err, sql.ErrNoRows) {
+			return errNoPendingScheduleChange
+		} else if err != nil {
+			s.logger.Errorw("failed to get pending schedule change",
+				RequestIDContextKey, r.Context().Value(RequestIDContextKey),
+				"queue_id", q.ID,
+				"day", day,
+				"err", err,
+			)
+			return err
+		}
+
+		return s.sendResponse(http.StatusOK, pending, w, r)
+	}
+}
+
+type addScheduleAuditEntry interface {
+	AddScheduleAuditEntry(ctx context.Context, entry *ScheduleAuditEntry) error
+}
+
+type getScheduleAuditLog interface {
+	GetScheduleAuditLog(ctx context.Context, queue ksuid.KSUID) ([]*ScheduleAuditEntry, error)
+}
+
+// GetScheduleAuditLog returns every change made to the queue's appointment
+// schedules, oldest first, with who made it and the day's schedule before
+// and after.
+func (s *Server) GetScheduleAuditLog(gl getScheduleAuditLog) E {
+	return func(w http.ResponseWriter, r *http.Request) error {
+		q, ok := r.Context().Value(queueContextKey).(*Queue)
+		if !ok {
+			return s.missingContextValue(r, queueContextKey)
+		}
+
+		entries, err := gl.GetScheduleAuditLog(r.Context(), q.ID)
+		if err != nil {
+			s.logger.Errorw("failed to get schedule audit log",
+				RequestIDContextKey, r.Context().Value(RequestIDContextKey),
+				"queue_id", q.ID,
+				"err", err,
+			)
+			return err
+		}
+
+		return s.sendResponse(http.StatusOK, entries, w, r)
+	}
+}
+
+type approveScheduleChange interface {
+	checkAppointmentScheduleChange
+	getAppointmentScheduleForDay
+	pendingScheduleChanges
+	addScheduleAuditEntry
+	UpdateAppointmentSchedule(ctx context.Context, queue ksuid.KSUID, day int, schedule *AppointmentSchedule) error
+}
+
+// ApproveScheduleChange applies a day's pending schedule change. The admin
+// approving it has to be someone other than whoever proposed it.
+func (s *Server) ApproveScheduleChange(as approveScheduleChange) E {
+	return func(w http.ResponseWriter, r *http.Request) error {
+		q, ok := r.Context().Value(queueContextKey).(*Queue)
+		if !ok {
+			return s.missingContextValue(r, queueContextKey)
+		}
+		email, ok := r.Context().Value(emailContextKey).(string)
+		if !ok {
+			return s.missingContextValue(r, emailContextKey)
+		}
+		day, ok := r.Context().Value(appointmentDayContextKey).(int)
+		if !ok {
+			return s.missingContextValue(r, appointmentDayContextKey)
+		}
+		l := s.logger.With(
+			RequestIDContextKey, r.Context().Value(RequestIDContextKey),
+			"queue_id", q.ID,
+			"day", day,
+			"email", email,
+		)
+
+		pending, err := as.GetPendingScheduleChange(r.Context(), q.ID, day)
+		if errors.Is(err, sql.ErrNoRows) {
+			l.Warnw("attempted to approve non-existent schedule change")
+			return errNoPendingScheduleChange
+		} else if err != nil {
+			l.Errorw("failed to get pending schedule change", "err", err)
+			return err
+		}
+		l = l.With("proposed_by", pending.ProposedBy)
+
+		if pending.ProposedBy == email {
+			l.Warnw("admin attempted to approve own schedule change")
+			return StatusError{
+				http.StatusForbidden,
+				"Someone else needs to approve your schedule change.",
+			}
+		}
+
+		currentSchedule, err := as.GetAppointmentScheduleForDay(r.Context(), q.ID, day)
+		if err != nil {
+			l.Errorw("failed to get existing appointment schedule", "err", err)
+			return err
+		}
+
+		err = s.checkAppointmentScheduleChange(r.Context(), l, as, q.ID, day, currentSchedule, &pending.AppointmentSchedule, false)
+		if err != nil {
+			return err
+		}
+
+		err = as.UpdateAppointmentSchedule(r.Context(), q.ID, day, &pending.AppointmentSchedule)
+		if err != nil {
+			l.Errorw("failed to update appointment schedule", "err", err)
+			return err
+		}
+
+		err = as.AddScheduleAuditEntry(r.Context(), &ScheduleAuditEntry{
+			Queue:  q.ID,
+			Day:    day,
+			Email:  email,
+			Before: currentSchedule,
+			After:  &pending.AppointmentSchedule,
+		})
+		if err != nil {
+			l.Errorw("failed to record schedule audit entry", "err", err)
+			return err
+		}
+
+		err = as.RemovePendingScheduleChange(r.Context(), q.ID, day)
+		if err != nil {
+			l.Errorw("failed to remove pending schedule change", "err", err)
+			return err
+		}
+
+		l.Infow("approved appointment schedule change")
+
+		s.ps.Pub(WS("REFRESH", nil), QueueTopicGeneric(q.ID))
+
+		return s.sendResponse(http.StatusNoContent, nil, w, r)
+	}
+}
+
+// RemovePendingScheduleChange throws out a day's pending schedule change,
+// whether it's being rejected or withdrawn.
+func (s *Server) RemovePendingScheduleChange(ps pendingScheduleChanges) E {
+	return func(w http.ResponseWriter, r *http.Request) error {
+		q, ok := r.Context().Value(queueContextKey).(*Queue)
+		if !ok {
+			return s.missingContextValue(r, queueContextKey)
+		}
+		email, ok := r.Context().Value(emailContextKey).(string)
+		if !ok {
+			return s.missingContextValue(r, emailContextKey)
+		}
+		day, ok := r.Context().Value(appointmentDayContextKey).(int)
+		if !ok {
+			return s.missingContextValue(r, appointmentDayContextKey)
+		}
+		l := s.logger.With(
+			RequestIDContextKey, r.Context().Value(RequestIDContextKey),
+			"queue_id", q.ID,
+			"day", day,
+			"email", email,
+		)
+
+		err := ps.RemovePendingScheduleChange(r.Context(), q.ID, day)
+		if errors.Is(err, sql.ErrNoRows) {
+			l.Warnw("attempted to remove non-existent schedule change")
+			return errNoPendingScheduleChange
+		} else if err != nil {
+			l.Errorw("failed to remove pending schedule change", "err", err)
+			return err
+		}
+
+		l.Infow("removed pending schedule change")
+
+		s.ps.Pub(WS("SCHEDULE_CHANGE_REMOVE", day), QueueTopicAdmin(q.ID))
+
+		return s.sendResponse(http.StatusNoContent, nil, w, r)
+	}
+}
+
+var errNoPendingApprovalRemoval = StatusError{
+	http.StatusNotFound,
+	"Nobody has asked to stop requiring approval for schedule changes.",
+}
+
+func (s *Server) GetPendingApprovalRemoval(pr pendingApprovalRemovals) E {
+	return func(w http.ResponseWriter, r *http.Request) error {
+		q, ok := r.Context().Value(queueContextKey).(*Queue)
+		if !ok {
+			return s.missingContextValue(r, queueContextKey)
+		}
+
+		pending, err := pr.GetPendingApprovalRemoval(r.Context(), q.ID)
+		if errors.Is(err, sql.ErrNoRows) {
+			return errNoPendingApprovalRemoval
+		} else if err != nil {
+			s.logger.Errorw("failed to get pending approval removal",
+				RequestIDContextKey, r.Context().Value(RequestIDContextKey),
+				"queue_id", q.ID,
+				"err", err,
+			)
+			return err
+		}
+
+		return s.sendResponse(http.StatusOK, pending, w, r)
+	}
+}
+
+type approveApprovalRemoval interface {
+	getQueueConfiguration
+	pendingApprovalRemovals
+	updateQueueConfiguration
+}
+
+// ApproveApprovalRemoval stops requiring approval for schedule changes,
+// as proposed by some other admin.
+func (s *Server) ApproveApprovalRemoval(ar approveApprovalRemoval) E {
+	return func(w http.ResponseWriter, r *http.Request) error {
+		q, ok := r.Context().Value(queueContextKey).(*Queue)
+		if !ok {
+			return s.missingContextValue(r, queueContextKey)
+		}
+		email, ok := r.Context().Value(emailContextKey).(string)
+		if !ok {
+			return s.missingContextValue(r, emailContextKey)
+		}
+		l := s.logger.With(
+			RequestIDContextKey, r.Context().Value(RequestIDContextKey),
+			"queue_id", q.ID,
+			"email", email,
+		)
+
+		pending, err := ar.GetPendingApprovalRemoval(r.Context(), q.ID)
+		if errors.Is(err, sql.ErrNoRows) {
+			l.Warnw("attempted to approve non-existent approval removal")
+			return errNoPendingApprovalRemoval
+		} else if err != nil {
+			l.Errorw("failed to get pending approval removal", "err", err)
+			return err
+		}
+		l = l.With("proposed_by", pending.ProposedBy)
+
+		if pending.ProposedBy == email {
+			l.Warnw("admin attempted to approve own approval removal")
+			return StatusError{
+				http.StatusForbidden,
+				"Someone else needs to approve turning off schedule approval.",
+			}
+		}
+
+		config, err := ar.GetQueueConfiguration(r.Context(), q.ID)
+		if err != nil {
+			l.Errorw("failed to get queue configuration", "err", err)
+			return err
+		}
+
+		config.RequireScheduleApproval = false
+		err = ar.UpdateQueueConfiguration(r.Context(), q.ID, config)
+		if err != nil {
+			l.Errorw("failed to update queue configuration", "err", err)
+			return err
+		}
+
+		err = ar.RemovePendingApprovalRemoval(r.Context(), q.ID)
+		if err != nil {
+			l.Errorw("failed to remove pending approval removal", "err", err)
+			return err
+		}
+
+		l.Infow("approved removing schedule approval")
+
+		s.ps.Pub(WS("REFRESH", nil), QueueTopicGeneric(q.ID))
+
+		return s.sendResponse(http.StatusNoContent, nil, w, r)
+	}
+}
+
+// RemovePendingApprovalRemoval throws out a request to stop requiring
+// approval, whether it's being rejected or withdrawn.
+func (s *Server) RemovePendingApprovalRemoval(pr pendingApprovalRemovals) E {
+	return func(w http.ResponseWriter, r *http.Request) error {
+		q, ok := r.Context().Value(queueContextKey).(*Queue)
+		if !ok {
+			return s.missingContextValue(r, queueContextKey)
+		}
+		email, ok := r.Context().Value(emailContextKey).(string)
+		if !ok {
+			return s.missingContextValue(r, emailContextKey)
+		}
+		l := s.logger.With(
+			RequestIDContextKey, r.Context().Value(RequestIDContextKey),
+			"queue_id", q.ID,
+			"email", email,
+		)
+
+		err := pr.RemovePendingApprovalRemoval(r.Context(), q.ID)
+		if errors.Is(err, sql.ErrNoRows) {
+			l.Warnw("attempted to remove non-existent approval removal")
+			return errNoPendingApprovalRemoval
+		} else if err != nil {
+			l.Errorw("failed to remove pending approval removal", "err", err)
+			return err
+		}
+
+		l.Infow("removed pending approval removal")
+
+		s.ps.Pub(WS("APPROVAL_REMOVAL_REMOVE", nil), QueueTopicAdmin(q.ID))
+
+		return s.sendResponse(http.StatusNoContent, nil, w, r)
+	}
+}
+
+const minutesInDay = 24 * 60
+
+// invalidSchedule is the code for a schedule that fails validation; the
+// details are a scheduleProblem saying which part of it.
+const invalidSchedule = "INVALID_SCHEDULE"
+
+// scheduleProblem is the part of a schedule that failed validation: one of
+// its fields, and for the schedule string, which timeslot.
+type scheduleProblem struct {
+	Field    string `json:"field"`
+	Timeslot *int   `json:"timeslot,omitempty"`
+}
+
+func invalidScheduleError(field string, message string) error {
+	return DetailedError{
+		StatusError{http.StatusBadRequest, message},
+		invalidSchedule,
+		scheduleProblem{Field: field},
+	}
+}
+
+// validateAppointmentSchedule makes sure every timeslot in a schedule
+// starts and ends within the day, which keeps the timeslot arithmetic
+// everywhere else from overflowing on absurd values.
+func validateAppointmentSchedule(schedule *AppointmentSchedule) error {
+	if schedule.Duration < 1 || schedule.Duration > minutesInDay {
+		return invalidScheduleError("duration",
+			fmt.Sprintf("Appointments need to be between 1 and %d minutes long.", minutesInDay),
+		)
+	}
+
+	if schedule.Padding < 0 || schedule.Padding > minutesInDay {
+		return invalidScheduleError("padding",
+			fmt.Sprintf("Appointment padding needs to be between 0 and %d minutes.", minutesInDay),
+		)
+	}
+
+	if len(schedule.Schedule) > minutesInDay/schedule.Duration {
+		return invalidScheduleError("schedule",
+			fmt.Sprintf("That schedule doesn't fit in a day! With %d-minute appointments, there's only room for %d timeslots.",
+				schedule.Duration, minutesInDay/schedule.Duration),
+		)
+	}
+
+	if schedule.SignupCutoff < 0 || schedule.SignupCutoff > minutesInDay {
+		return invalidScheduleError("signup_cutoff",
+			fmt.Sprintf("The signup cutoff needs to be between 0 and %d minutes.", minutesInDay),
+		)
+	}
+
+	if schedule.SignupsOpen < 0 || schedule.SignupsOpen > 7*minutesInDay {
+		return invalidScheduleError("signups_open",
+			fmt.Sprintf("Signups need to open between 0 and %d minutes before the day starts.", 7*minutesInDay),
+		)
+	}
+
+	for i, n := range schedule.Schedule {
+		if n < '0' || n > '9' {
+			timeslot := i
+			return DetailedError{
+				StatusError{
+					http.StatusBadRequest,
+					fmt.Sprintf("Timeslot %d has an invalid number of slots; each timeslot needs a digit from 0 to 9.", i),
+				},
+				invalidSchedule,
+				scheduleProblem{Field: "schedule", Timeslot: &timeslot},
+			}
+		}
+	}
+
+	return nil
+}
+
+type getAppointmentsByTimeslot interface {
+	GetAppointmentsByTimeslot(ctx context.Context, queue ksuid.KSUID, from, to time.Time, timeslot int) ([]*AppointmentSlot, error)
+}
+
+type setAppointmentMeetingLink interface {
+	SetAppointmentMeetingLink(ctx context.Context, appointment ksuid.KSUID, link *string) error
+}
+
+type attachMeetingLink interface {
+	// AttachMeetingLink runs after the request's transaction is gone, so
+	// it has its own. It only sets the link if student still has the
+	// appointment and it doesn't have one, reporting whether it did.
+	AttachMeetingLink(ctx context.Context, appointment ksuid.KSUID, student, link string) (bool, error)
+}
+
+type appointmentLabels interface {
+	GetAppointmentLabels(ctx context.Context, appointment ksuid.KSUID) ([]string, error)
+	SetAppointmentLabels(ctx context.Context, appointment ksuid.KSUID, labels []string) error
+}
+
+const (
+	maxAppointmentLabels      = 10
+	maxAppointmentLabelLength = 32
+)
+
+// SetAppointmentLabels replaces the labels on an appointment.
+func (s *Server) SetAppointmentLabels(sl appointmentLabels) E {
+	return func(w http.ResponseWriter, r *http.Request) error {
+		q, ok := r.Context().Value(queueContextKey).(*Queue)
+		if !ok {
+			return s.missingContextValue(r, queueContextKey)
+		}
+		a, ok := r.Context().Value(appointmentContextKey).(*AppointmentSlot)
+		if !ok {
+			return s.missingContextValue(r, appointmentContextKey)
+		}
+		email, ok := r.Context().Value(emailContextKey).(string)
+		if !ok {
+			return s.missingContextValue(r, emailContextKey)
+		}
+		l := s.logger.With(
+			RequestIDContextKey, r.Context().Value(RequestIDContextKey),
+			"queue_id", q.ID,
+			"appointment_id", a.ID,
+			"email", email,
+		)
+
+		var body struct {
+			Labels []string `json:"labels"`
+		}
+		err := json.NewDecoder(r.Body).Decode(&body)
+		if err != nil {
+			l.Warnw("failed to decode labels from body", "err", err)
+			return StatusError{
+				http.StatusBadRequest,
+				"We couldn't read the labels from the request body.",
+			}
+		}
+
+		if len(body.Labels) > maxAppointmentLabels {
+			l.Warnw("got too many labels", "num_labels", len(body.Labels))
+			return StatusError{
+				http.StatusBadRequest,
+				fmt.Sprintf("Appointments can have at most %d labels.", maxAppointmentLabels),
+			}
+		}
+
+		labels := make([]string, 0, len(body.Labels))
+		seen := make(map[string]bool)
+		for _, label := range body.Labels {
+			label = strings.ToLower(strings.TrimSpace(label))
+			if label == "" || len(label) > maxAppointmentLabelLength {
+				l.Warnw("got invalid label", "label", label)
+				return StatusError{
+					http.StatusBadRequest,
+					fmt.Sprintf("Labels need to be between 1 and %d characters long.", maxAppointmentLabelLength),
+				}
+			}
+
+			if !seen[label] {
+				seen[label] = true
+				labels = append(labels, label)
+			}
+		}
+
+		err = sl.SetAppointmentLabels(r.Context(), a.ID, labels)
+		if err != nil {
+			l.Errorw("failed to set appointment labels", "err", err)
+			return err
+		}
+
+		l.Infow("set appointment labels", "labels", labels)
+
+		a.Labels = labels
+		s.ps.Pub(WS("APPOINTMENT_UPDATE", a), QueueTopicAdmin(q.ID))
+
+		return s.sendAppointmentResponse(http.StatusOK, a, w, r)
+	}
+}
+
+type setAppointmentResolved interface {
+	SetAppointmentResolved(ctx context.Context, appointment ksuid.KSUID, resolved *bool) error
+}
+
+// SetAppointmentResolved marks whether the student's question got sorted
+// out during the appointment, so instructors can follow up on the ones
+// that didn't. It can only be set once the appointment has started; null
+// clears it.
+func (s *Server) SetAppointmentResolved(sr setAppointmentResolved) E {
+	return func(w http.ResponseWriter, r *http.Request) error {
+		q, ok := r.Context().Value(queueContextKey).(*Queue)
+		if !ok {
+			return s.missingContextValue(r, queueContextKey)
+		}
+		a, ok := r.Context().Value(appointmentContextKey).(*AppointmentSlot)
+		if !ok {
+			return s.missingContextValue(r, appointmentContextKey)
+		}
+		email, ok := r.Context().Value(emailContextKey).(string)
+		if !ok {
+			return s.missingContextValue(r, emailContextKey)
+		}
+		l := s.logger.With(
+			RequestIDContextKey, r.Context().Value(RequestIDContextKey),
+			"queue_id", q.ID,
+			"appointment_id", a.ID,
+			"email", email,
+		)
+
+		if a.StudentEmail == nil {
+			l.Warnw("attempted to resolve appointment without student")
+			return StatusError{
+				http.StatusBadRequest,
+				"Nobody signed up for that appointment, so there's nothing to resolve.",
+			}
+		}
+
+		if time.Now().Before(a.ScheduledTime) {
+			l.Warnw("attempted to resolve appointment that hasn't started", "scheduled_time", a.ScheduledTime)
+			return StatusError{
+				http.StatusBadRequest,
+				"That appointment hasn't happened yet!",
+			}
+		}
+
+		var body struct {
+			Resolved *bool `json:"resolved"`
+		}
+		err := s.decodeLimitedBody(w, r, &body)
+		if errors.Is(err, errBodyTooLarge) {
+			l.Warnw("resolved request body too large")
+			return err
+		}
+		if err != nil {
+			l.Warnw("failed to decode resolved from body", "err", err)
+			return StatusError{
+				http.StatusBadRequest,
+				"We couldn't read whether the appointment was resolved from the request body.",
+			}
+		}
+
+		err = sr.SetAppointmentResolved(r.Context(), a.ID, body.Resolved)
+		if err != nil {
+			l.Errorw("failed to set appointment resolved", "err", err)
+			return err
+		}
+
+		l.Infow("set appointment resolved", "resolved", body.Resolved)
+
+		a.Resolved = body.Resolved
+		s.ps.Pub(WS("APPOINTMENT_UPDATE", a), QueueTopicAdmin(q.ID))
+
+		return s.sendAppointmentResponse(http.StatusOK, a, w, r)
+	}
+}
+
+type getUnresolvedAppointments interface {
+	GetUnresolvedAppointments(ctx context.Context, queue ksuid.KSUID) ([]*AppointmentSlot, error)
+}
+
+// GetUnresolvedAppointments returns the appointments staff marked as not
+// resolved, oldest first, for instructors to follow up on.
+func (s *Server) GetUnresolvedAppointments(gu getUnresolvedAppointments) E {
+	return func(w http.ResponseWriter, r *http.Request) error {
+		q, ok := r.Context().Value(queueContextKey).(*Queue)
+		if !ok {
+			return s.missingContextValue(r, queueContextKey)
+		}
+
+		appointments, err := gu.GetUnresolvedAppointments(r.Context(), q.ID)
+		if err != nil {
+			s.logger.Errorw("failed to get unresolved appointments",
+				RequestIDContextKey, r.Context().Value(RequestIDContextKey),
+				"queue_id", q.ID,
+				"err", err,
+			)
+			return err
+		}
+
+		return s.sendAppointmentResponse(http.StatusOK, appointments, w, r)
+	}
+}
+
+type getAppointmentPartners interface {
+	GetAppointmentPartners(ctx context.Context, appointment ksuid.KSUID) ([]string, error)
+}
+
+type appointmentPartners interface {
+	getAppointmentPartners
+	AddAppointmentPartners(ctx context.Context, appointment ksuid.KSUID, partners []string) error
+}
+
+type leaveAppointment interface {
+	getAppointmentPartners
+	RemoveAppointmentPartner(ctx context.Context, appointment ksuid.KSUID, email string) (bool, error)
+}
+
+// LeaveAppointment takes the current user off of an appointment someone
+// else signed them up for as a partner. Partners aren't asked before being
+// added, so this is how they get out of it (and free themselves up to
+// book on their own).
+func (s *Server) LeaveAppointment(la leaveAppointment) E {
+	return func(w http.ResponseWriter, r *http.Request) error {
+		q, ok := r.Context().Value(queueContextKey).(*Queue)
+		if !ok {
+			return s.missingContextValue(r, queueContextKey)
+		}
+		a, ok := r.Context().Value(appointmentContextKey).(*AppointmentSlot)
+		if !ok {
+			return s.missingContextValue(r, appointmentContextKey)
+		}
+		email, ok := r.Context().Value(emailContextKey).(string)
+		if !ok {
+			return s.missingContextValue(r, emailContextKey)
+		}
+		l := s.logger.With(
+			RequestIDContextKey, r.Context().Value(RequestIDContextKey),
+			"queue_id", q.ID,
+			"appointment_id", a.ID,
+			"email", email,
+		)
+
+		if time.Now().After(a.ScheduledTime) {
+			l.Warnw("user attempted to leave appointment in the past")
+			return StatusError{
+				http.StatusBadRequest,
+				"You can't leave an appointment that already happened!",
+			}
+		}
+
+		removed, err := la.RemoveAppointmentPartner(r.Context(), a.ID, email)
+		if err != nil {
+			l.Errorw("failed to remove appointment partner", "err", err)
+			return err
+		}
+
+		if !removed {
+			l.Warnw("user attempted to leave appointment they aren't a partner on")
+			return StatusError{
+				http.StatusNotFound,
+				"You aren't a partner on that appointment.",
+			}
+		}
+
+		a.Partners, err = la.GetAppointmentPartners(r.Context(), a.ID)
+		if err != nil {
+			l.Errorw("failed to get appointment partners", "err", err)
+			return err
+		}
+
+		l.Infow("partner left appointment")
+
+		s.ps.Pub(WS("APPOINTMENT_UPDATE", a), QueueTopicAdmin(q.ID))
+		s.ps.Pub(WS("APPOINTMENT_REMOVE", a.NoStaffEmail()), QueueTopicEmail(q.ID, email))
+		if a.StudentEmail != nil {
+			s.ps.Pub(WS("APPOINTMENT_UPDATE", a.NoStaffEmail()), QueueTopicEmail(q.ID, *a.StudentEmail))
+		}
+		for _, p := range a.Partners {
+			s.ps.Pub(WS("APPOINTMENT_UPDATE", a.NoStaffEmail()), QueueTopicEmail(q.ID, p))
+		}
+
+		return s.sendResponse(http.StatusNoContent, nil, w, r)
+	}
+}
+
+// hasRequiredAppointmentFields checks that an appointment has everything
+// the queue asks students for. Fields the queue makes optional are set
+// to empty if they were left out. The name always comes from the
+// student's login, so it's always required. Surrounding whitespace is
+// trimmed off first, so a field of just spaces counts as empty.
+func hasRequiredAppointmentFields(a *AppointmentSlot, config *QueueConfiguration) bool {
+	a.Name = trimmed(a.Name)
+	a.Description = trimmed(a.Description)
+	a.Location = trimmed(a.Location)
+
+	var empty string
+	if a.Description == nil {
+		a.Description = &empty
+	}
+	if a.Location == nil {
+		a.Location = &empty
+	}
+
+	if a.Name == nil || *a.Name == "" {
+		return false
+	}
+	if !config.OptionalAppointmentDescription && *a.Description == "" {
+		return false
+	}
+	if !config.OptionalAppointmentLocation && *a.Location == "" {
+		return false
+	}
+	return true
+}
+
+// appointmentFieldTooLong returns the name of the first of an appointment's
+// text fields that's over the queue's maximum length, if any. It's run
+// after hasRequiredAppointmentFields, so the fields aren't nil.
+func appointmentFieldTooLong(a *AppointmentSlot, config *QueueConfiguration) string {
+	if config.MaxAppointmentFieldLength == 0 {
+		return ""
+	}
+
+	fields := []struct {
+		name  string
+		value string
+	}{
+		{"name", *a.Name},
+		{"description", *a.Description},
+		{"location", *a.Location},
+	}
+	for _, f := range fields {
+		if utf8.RuneCountInString(f.value) > config.MaxAppointmentFieldLength {
+			return f.name
+		}
+	}
+	return ""
+}
+
+// appointmentDescriptionTooShort reports whether an appointment's
+// description is under the queue's minimum length. Leaving out an
+// optional description is still fine.
+func appointmentDescriptionTooShort(a *AppointmentSlot, config *QueueConfiguration) bool {
+	n := utf8.RuneCountInString(*a.Description)
+	if n == 0 && config.OptionalAppointmentDescription {
+		return false
+	}
+	return n < config.MinAppointmentDescriptionLength
+}
+
+// templateSections returns the section headings in a description
+// template: every line that ends with a colon, like "What have you tried:".
+func templateSections(template string) []string {
+	var sections []string
+	for _, line := range strings.Split(template, "\n") {
+		line = strings.TrimSpace(line)
+		if len(line) > 1 && strings.HasSuffix(line, ":") {
+			sections = append(sections, line)
+		}
+	}
+	return sections
+}
+
+// shortestTemplateFill returns the length of the shortest description that
+// fills in every one of sections: each heading on its own line, followed by
+// a one-character answer.
+func shortestTemplateFill(sections []string) int {
+	if len(sections) == 0 {
+		return 0
+	}
+	return utf8.RuneCountInString(strings.Join(sections, "\n")) + len(sections)
+}
+
+// hasSectionPrefix reports whether line starts with section, ignoring case.
+func hasSectionPrefix(line, section string) bool {
+	return len(line) >= len(section) && strings.EqualFold(line[:len(section)], section)
+}
+
+// missingTemplateSection returns the first section of the queue's
+// description template that an appointment's description leaves out or
+// leaves empty, or "" if every section was filled in. The answer to a
+// section can go on the same line as its heading or on the lines under it.
+// Leaving out an optional description is still fine.
+func missingTemplateSection(a *AppointmentSlot, config *QueueConfiguration) string {
+	if !config.RequireDescriptionTemplate {
+		return ""
+	}
+
+	if *a.Description == "" && config.OptionalAppointmentDescription {
+		return ""
+	}
+
+	sections := templateSections(config.DescriptionTemplate)
+	isHeading := func(line string) bool {
+		for _, section := range sections {
+			if hasSectionPrefix(line, section) {
+				return true
+			}
+		}
+		return false
+	}
+
+	lines := strings.Split(*a.Description, "\n")
+	for _, section := range sections {
+		filled := false
+		for i, line := range lines {
+			line = strings.TrimSpace(line)
+			if !hasSectionPrefix(line, section) {
+				continue
+			}
+
+			if strings.TrimSpace(line[len(section):]) != "" {
+				filled = true
+				break
+			}
+
+			for _, next := range lines[i+1:] {
+				next = strings.TrimSpace(next)
+				if isHeading(next) {
+					break
+				}
+				if next != "" {
+					filled = true
+					break
+				}
+			}
+			break
+		}
+
+		if !filled {
+			return section
+		}
+	}
+
+	return ""
+}
+
+// trimmed returns a copy of s without surrounding whitespace.
+func trimmed(s *string) *string {
+	if s == nil {
+		return nil
+	}
+	t := strings.TrimSpace(*s)
+	return &t
+}
+
+type getSignupEligibility interface {
+	getQueueConfiguration
+	getAppointmentSchedule
+	getAppointmentsForUser
+	UserInQueueRoster(ctx context.Context, queue ksuid.KSUID, email string) (bool, error)
+	TeammateHasAppointment(ctx context.Context, queue ksuid.KSUID, from, to time.Time, email string) (bool, error)
+}
+
+// GetSignupEligibility tells staff whether the student in the email query
+// parameter could sign up for an appointment right now, going through the
+// same checks about the student that SignupForAppointment does. Queues that
+// only allow one appointment per day can't have that checked without a
+// day, so it's left out for them.
+func (s *Server) GetSignupEligibility(ge getSignupEligibility) E {
+	return func(w http.ResponseWriter, r *http.Request) error {
+		q, ok := r.Context().Value(queueContextKey).(*Queue)
+		if !ok {
+			return s.missingContextValue(r, queueContextKey)
+		}
+		email := strings.TrimSpace(r.URL.Query().Get("email"))
+		l := s.logger.With(
+			RequestIDContextKey, r.Context().Value(RequestIDContextKey),
+			"queue_id", q.ID,
+			"email", r.Context().Value(emailContextKey),
+			"student_email", email,
+		)
+
+		if email == "" {
+			l.Warnw("got signup eligibility request without email")
+			return StatusError{
+				http.StatusBadRequest,
+				"Which student should we check? Put their email in the email query parameter.",
+			}
+		}
+
+		eligibility := &SignupEligibility{Email: email}
+
+		config, err := ge.GetQueueConfiguration(r.Context(), q.ID)
+		if err != nil {
+			l.Errorw("failed to get queue configuration", "err", err)
+			return err
+		}
+
+		if config.SignupsFrozen {
+			eligibility.Reason = SignupBlockedFrozen
+			return s.sendResponse(http.StatusOK, eligibility, w, r)
+		}
+
+		if config.PreventUnregistered {
+			inRoster, err := ge.UserInQueueRoster(r.Context(), q.ID, email)
+			if err != nil {
+				l.Errorw("failed to get queue roster", "err", err)
+				return err
+			}
+
+			if !inRoster {
+				eligibility.Reason = SignupBlockedNotInRoster
+				return s.sendResponse(http.StatusOK, eligibility, w, r)
+			}
+		}
+
+		// Ongoing appointments count too, and without a day to go on
+		// we don't know how long those are, so look back by the
+		// longest duration on any day.
+		schedules, err := ge.GetAppointmentSchedule(r.Context(), q.ID)
+		if err != nil {
+			l.Errorw("failed to get appointment schedule", "err", err)
+			return err
+		}
+
+		var duration int
+		for _, schedule := range schedules {
+			if schedule.Duration > duration {
+				duration = schedule.Duration
+			}
+		}
+		from := time.Now().Add(-time.Duration(duration) * time.Minute)
+
+		if config.PreventGroups {
+			teammateHasAppointment, err := ge.TeammateHasAppointment(r.Context(), q.ID, from, BigTime(), email)
+			if err != nil {
+				l.Errorw("failed to get teammate appointments", "err", err)
+				return err
+			}
+
+			if teammateHasAppointment {
+				eligibility.Reason = SignupBlockedTeammate
+				return s.sendResponse(http.StatusOK, eligibility, w, r)
+			}
+		}
+
+		if config.FutureAppointmentScope != FutureAppointmentScopeDay {
+			appointments, err := ge.GetAppointmentsForUser(r.Context(), q.ID, from, BigTime(), email)
+			if err != nil {
+				l.Errorw("failed to get future appointments for user", "err", err)
+				return err
+			}
+
+			if len(appointments) > 0 {
+				eligibility.Reason = SignupBlockedFutureAppointment
+				eligibility.Conflict = &AppointmentConflict{
+					AppointmentID: appointments[0].ID,
+					ScheduledTime: appointments[0].ScheduledTime.In(time.Local),
+				}
+				return s.sendResponse(http.StatusOK, eligibility, w, r)
+			}
+		}
+
+		eligibility.Eligible = true
+		return s.sendResponse(http.StatusOK, eligibility, w, r)
+	}
+}
+
+// GetStudentTotalTime adds up the time the student in the email query
+// parameter has spent in appointments on the queue, including ones they
+// were a partner on. Canceled appointments are gone by now, so they don't
+// count, and neither do ones that haven't finished yet.
+func (s *Server) GetStudentTotalTime(ga getAppointmentsForUser) E {
+	return func(w http.ResponseWriter, r *http.Request) error {
+		q, ok := r.Context().Value(queueContextKey).(*Queue)
+		if !ok {
+			return s.missingContextValue(r, queueContextKey)
+		}
+		email := strings.TrimSpace(r.URL.Query().Get("email"))
+		l := s.logger.With(
+			RequestIDContextKey, r.Context().Value(RequestIDContextKey),
+			"queue_id", q.ID,
+			"email", r.Context().Value(emailContextKey),
+			"student_email", email,
+		)
+
+		if email == "" {
+			l.Warnw("got student total time request without email")
+			return StatusError{
+				http.StatusBadRequest,
+				"Which student should we check? Put their email in the email query parameter.",
+			}
+		}
+
+		now := time.Now()
+		appointments, err := ga.GetAppointmentsForUser(r.Context(), q.ID, time.Time{}, now, email)
+		if err != nil {
+			l.Errorw("failed to get appointments for user", "err", err)
+			return err
+		}
+
+		total := StudentTotalTime{Email: email}
+		for _, a := range appointments {
+			if a.ScheduledTime.Add(time.Duration(a.Duration) * time.Minute).After(now) {
+				continue
+			}
+			total.Appointments++
+			total.TotalMinutes += a.Duration
+		}
+
+		return s.sendResponse(http.StatusOK, total, w, r)
+	}
+}
+
+type getActiveAppointmentCount interface {
+	GetActiveAppointmentCount(ctx context.Context, queue ksuid.KSUID, now time.Time) (*ActiveAppointmentCount, error)
+}
+
+// GetActiveAppointmentCount returns how many booked appointments are going
+// on in the queue right now and how many are still to come, for capacity
+// dashboards.
+func (s *Server) GetActiveAppointmentCount(gc getActiveAppointmentCount) E {
+	return func(w http.ResponseWriter, r *http.Request) error {
+		q, ok := r.Context().Value(queueContextKey).(*Queue)
+		if !ok {
+			return s.missingContextValue(r, queueContextKey)
+		}
+
+		count, err := gc.GetActiveAppointmentCount(r.Context(), q.ID, time.Now())
+		if err != nil {
+			s.logger.Errorw("failed to get active appointment count",
+				RequestIDContextKey, r.Context().Value(RequestIDContextKey),
+				"queue_id", q.ID,
+				"err", err,
+			)
+			return err
+		}
+
+		return s.sendResponse(http.StatusOK, count, w, r)
+	}
+}
+
+type transferStudentAppointments interface {
+	getQueue
+	courseAdmin
+	getQueueConfiguration
+	getAppointmentScheduleForDay
+	getAppointmentsForUser
+	checkSignupCapacity
+	appointmentPartners
+	appointmentLabels
+	removeAppointmentSignup
+	syncCalendarEvents
+	UserInQueueRoster(ctx context.Context, queue ksuid.KSUID, email string) (bool, error)
+	LockAppointmentSignups(ctx context.Context, queue ksuid.KSUID, email string) error
+	SignupForAppointment(ctx context.Context, queue ksuid.KSUID, appointment *AppointmentSlot) (*AppointmentSlot, error)
+}
+
+// transferConflict is the error code given when one of a student's
+// appointments has nowhere to go in the queue they're moving to.
+const transferConflict = "TRANSFER_CONFLICT"
+
+// TransferStudentAppointments moves a student's upcoming appointments from
+// this queue to the same times in another one, for when they switch
+// sections. Each appointment needs a timeslot starting at the same time
+// and of the same length in the other queue, with room in it; if any one
+// of them doesn't fit, none of them move. Partners and labels come along,
+// but custom field answers stay behind since the other queue might ask
+// for different things. The admin needs to be an admin of both queues'
+// courses. The student has to be able to sign up for the other queue
+// themselves, so its roster and limits on future appointments apply too.
+func (s *Server) TransferStudentAppointments(ts transferStudentAppointments) E {
+	return func(w http.ResponseWriter, r *http.Request) error {
+		q, ok := r.Context().Value(queueContextKey).(*Queue)
+		if !ok {
+			return s.missingContextValue(r, queueContextKey)
+		}
+		email, ok := r.Context().Value(emailContextKey).(string)
+		if !ok {
+			return s.missingContextValue(r, emailContextKey)
+		}
+		l := s.logger.With(
+			RequestIDContextKey, r.Context().Value(RequestIDContextKey),
+			"queue_id", q.ID,
+			"email", email,
+		)
+
+		var body struct {
+			Target       ksuid.KSUID `json:"target"`
+			StudentEmail string      `json:"student_email"`
+		}
+		err := s.decodeLimitedBody(w, r, &body)
+		if errors.Is(err, errBodyTooLarge) {
+			l.Warnw("transfer request body too large")
+			return err
+		}
+		if err != nil {
+			l.Warnw("failed to decode transfer from body", "err", err)
+			return StatusError{
+				http.StatusBadRequest,
+				"We couldn't read the transfer in the request body.",
+			}
+		}
+		body.StudentEmail = strings.TrimSpace(body.StudentEmail)
+		l = l.With("target_queue_id", body.Target, "student_email", body.StudentEmail)
+
+		if body.StudentEmail == "" {
+			l.Warnw("attempted to transfer appointments without student email")
+			return StatusError{
+				http.StatusBadRequest,
+				"Tell us whose appointments to move!",
+			}
+		}
+
+		if body.Target == q.ID {
+			l.Warnw("attempted to transfer appointments to same queue")
+			return StatusError{
+				http.StatusBadRequest,
+				"Those appointments are already in this queue!",
+			}
+		}
+
+		target, err := ts.GetQueue(r.Context(), body.Target)
+		if errors.Is(err, sql.ErrNoRows) {
+			l.Warnw("attempted to transfer appointments to non-existent queue")
+			return StatusError{
+				http.StatusNotFound,
+				"We couldn't find the queue to move the appointments to.",
+			}
+		} else if err != nil {
+			l.Errorw("failed to get target queue", "err", err)
+			return err
+		}
+
+		if target.Type != Appointments {
+			l.Warnw("attempted to transfer appointments to non-appointments queue")
+			return StatusError{
+				http.StatusBadRequest,
+				"The queue to move the appointments to isn't an appointments queue.",
+			}
+		}
+
+		admin, err := ts.CourseAdmin(r.Context(), target.Course, email)
+		if err != nil {
+			l.Errorw("failed to check course admin status on target queue", "err", err)
+			return err
+		}
+
+		if !admin {
+			l.Warnw("non-admin attempted to transfer appointments to queue")
+			return StatusError{
+				http.StatusForbidden,
+				"You need to be an admin of the queue you're moving the appointments to.",
+			}
+		}
+
+		targetConfig, err := ts.GetQueueConfiguration(r.Context(), target.ID)
+		if err != nil {
+			l.Errorw("failed to get target queue configuration", "err", err)
+			return err
+		}
+
+		if targetConfig.PreventUnregistered {
+			inRoster, err := ts.UserInQueueRoster(r.Context(), target.ID, body.StudentEmail)
+			if err != nil {
+				l.Errorw("failed to get target queue roster", "err", err)
+				return err
+			}
+
+			if !inRoster {
+				l.Warnw("attempted to transfer appointments of student not in target queue roster")
+				return StatusError{
+					http.StatusForbidden,
+					fmt.Sprintf("%s isn't in the roster for the other queue, so they can't have appointments there.", body.StudentEmail),
+				}
+			}
+		}
+
+		// Same as signing up: held until commit so the student can't
+		// sign up in the other queue while their appointments move in.
+		err = ts.LockAppointmentSignups(r.Context(), target.ID, body.StudentEmail)
+		if err != nil {
+			l.Errorw("failed to lock target queue appointment sign-ups", "err", err)
+			return err
+		}
+
+		appointments, err := ts.GetAppointmentsForUser(r.Context(), q.ID, time.Now(), BigTime(), body.StudentEmail)
+		if err != nil {
+			l.Errorw("failed to get student appointments", "err", err)
+			return err
+		}
+
+		type transfer struct {
+			previous, moved *AppointmentSlot
+			deleted         bool
+			newSlot         *AppointmentSlot
+			calendarEvents  []*CalendarEventLink
+		}
+
+		// Nothing gets published until every appointment has moved, since
+		// a conflict partway through rolls the earlier ones back.
+		var transfers []*transfer
+		schedules := make(map[int]*AppointmentSchedule)
+		for _, a := range appointments {
+			// Appointments the student is only a partner on belong to
+			// someone else, who isn't changing sections.
+			if a.StudentEmail == nil || *a.StudentEmail != body.StudentEmail {
+				continue
+			}
+
+			al := l.With("appointment_id", a.ID)
+			scheduled := a.ScheduledTime.In(time.Local)
+			day := int(scheduled.Weekday())
+			schedule, ok := schedules[day]
+			if !ok {
+				schedule, err = ts.GetAppointmentScheduleForDay(r.Context(), target.ID, day)
+				if err != nil {
+					al.Errorw("failed to get target appointment schedule", "day", day, "err", err)
+					return err
+				}
+				schedules[day] = schedule
+			}
+
+			start, _ := WeekdayBounds(day)
+			timeslot := -1
+			for t := range schedule.Schedule {
+				if SlotStart(start, t, schedule).Equal(a.ScheduledTime) {
+					timeslot = t
+					break
+				}
+			}
+
+			if timeslot < 0 || schedule.Duration*a.Span() != a.Duration || timeslot+a.Span() > len(schedule.Schedule) {
+				al.Warnw("no matching timeslot in target queue for appointment",
+					"scheduled_time", a.ScheduledTime,
+					"duration", a.Duration,
+				)
+				return DetailedError{
+					StatusError{
+						http.StatusConflict,
+						fmt.Sprintf("The other queue doesn't have a %d minute timeslot at %s.", a.Duration, scheduled.Format("Monday 3:04 PM")),
+					},
+					transferConflict,
+					a,
+				}
+			}
+
+			// The same check as signing up, against the other queue. This
+			// sees the appointments already moved over too, so a queue
+			// allowing only one future appointment takes only one.
+			startFutureCheck := time.Now().Add(-time.Duration(schedule.Duration) * time.Minute)
+			endFutureCheck := BigTime()
+			if targetConfig.FutureAppointmentScope == FutureAppointmentScopeDay {
+				dayStart, dayEnd := WeekdayBounds(day)
+				if dayStart.After(startFutureCheck) {
+					startFutureCheck = dayStart
+				}
+				endFutureCheck = dayEnd
+			}
+
+			existing, err := ts.GetAppointmentsForUser(r.Context(), target.ID, startFutureCheck, endFutureCheck, body.StudentEmail)
+			if err != nil {
+				al.Errorw("failed to get student appointments in target queue", "err", err)
+				return err
+			}
+
+			if len(existing) > 0 {
+				al.Warnw("student already has appointment in target queue",
+					"future_appointment_scope", targetConfig.FutureAppointmentScope,
+					"conflicting_appointment_id", existing[0].ID,
+				)
+				return DetailedError{
+					StatusError{
+						http.StatusConflict,
+						fmt.Sprintf("The appointment at %s doesn't fit in the other queue: %s already has an appointment there at %s.", scheduled.Format("Monday 3:04 PM"), body.StudentEmail, existing[0].ScheduledTime.In(time.Local).Format("Monday 3:04 PM")),
+					},
+					transferConflict,
+					a,
+				}
+			}
+
+			err = s.checkSignupCapacity(r.Context(), al, ts, target, targetConfig, schedule, day, timeslot, a.Span())
+			var se StatusError
+			if errors.As(err, &se) {
+				return DetailedError{
+					StatusError{
+						http.StatusConflict,
+						fmt.Sprintf("The appointment at %s doesn't fit in the other queue: %s", scheduled.Format("Monday 3:04 PM"), se.message),
+					},
+					transferConflict,
+					a,
+				}
+			} else if err != nil {
+				return err
+			}
+
+			newAppointment := *a
+			newAppointment.Queue = target.ID
+			newAppointment.Timeslot = timeslot
+			newAppointment.StaffEmail = nil
+			createdAppointment, err := ts.SignupForAppointment(r.Context(), target.ID, &newAppointment)
+			if err != nil {
+				al.Errorw("failed to create appointment in target queue", "err", err)
+				return err
+			}
+
+			partners, err := ts.GetAppointmentPartners(r.Context(), a.ID)
+			if err != nil {
+				al.Errorw("failed to get appointment partners", "err", err)
+				return err
+			}
+
+			if len(partners) > 0 {
+				err = ts.AddAppointmentPartners(r.Context(), createdAppointment.ID, partners)
+				if err != nil {
+					al.Errorw("failed to move partners to new appointment", "err", err)
+					return err
+				}
+				createdAppointment.Partners = partners
+			}
+
+			labels, err := ts.GetAppointmentLabels(r.Context(), a.ID)
+			if err != nil {
+				al.Errorw("failed to get appointment labels", "err", err)
+				return err
+			}
+
+			if len(labels) > 0 {
+				err = ts.SetAppointmentLabels(r.Context(), createdAppointment.ID, labels)
+				if err != nil {
+					al.Errorw("failed to move labels to new appointment", "err", err)
+					return err
+				}
+			}
+
+			calendarEvents, err := ts.GetCalendarEvents(r.Context(), a.ID)
+			if err != nil {
+				al.Errorw("failed to get calendar events for appointment", "err", err)
+				return err
+			}
+
+			deleted, newSlot, err := ts.RemoveAppointmentSignup(r.Context(), a.ID)
+			if err != nil {
+				al.Errorw("failed to remove transferred appointment", "err", err)
+				return err
+			}
+
+			transfers = append(transfers, &transfer{a, createdAppointment, deleted, newSlot, calendarEvents})
+		}
+
+		for _, t := range transfers {
+			err = s.syncCalendarEvents(r.Context(), l, ts, target, t.calendarEvents, t.previous, t.moved)
+			if err != nil {
+				return err
+			}
+		}
+
+		l.Infow("transferred student appointments", "appointments", len(transfers))
+
+		moved := make([]*AppointmentSlot, 0, len(transfers))
+		for _, t := range transfers {
+			if t.deleted {
+				s.ps.Pub(WS("APPOINTMENT_REMOVE", t.previous.Anonymized()), QueueTopicGeneric(q.ID))
+			} else {
+				s.ps.Pub(WS("APPOINTMENT_UPDATE", t.newSlot), QueueTopicAdmin(q.ID))
+				s.ps.Pub(WS("APPOINTMENT_REMOVE", t.previous.Anonymized()), QueueTopicNonPrivileged(q.ID))
+			}
+
+			s.ps.Pub(WS("APPOINTMENT_CREATE", t.moved), QueueTopicAdmin(target.ID))
+			s.ps.Pub(WS("APPOINTMENT_CREATE", t.moved.Anonymized()), QueueTopicNonPrivileged(target.ID))
+			s.ps.Pub(WS("APPOINTMENT_UPDATE", t.moved.NoStaffEmail()), QueueTopicEmail(target.ID, body.StudentEmail))
+			moved = append(moved, t.moved)
+		}
+
+		return s.sendResponse(http.StatusOK, moved, w, r)
+	}
+}
+
+// errSignupsFrozen is for students signing up or rescheduling while the
+// queue's staff have frozen signups (say, over an exam). Canceling still
+// works.
+var errSignupsFrozen = StatusError{
+	http.StatusForbidden,
+	"Signups for this queue are frozen right now, so appointments can't be booked or moved. You can still cancel!",
+}
+
+// maxAppointmentSlotSpan is the most consecutive timeslots one
+// appointment can take up.
+const maxAppointmentSlotSpan = 4
+
+// maxAppointmentPartners is the most partners a student can bring along
+// to one appointment.
+const maxAppointmentPartners = 3
+
+type signupForAppointment interface {
+	getQueueConfiguration
+	appointmentPartners
+	appointmentLabels
+	appointmentCustomFields
+	getMapRegions
+	getAppointmentsInTimeFrame
+	getAppointmentScheduleForDay
+	getAppointmentsForUser
+	getAppointmentsByTimeslot
+	attachMeetingLink
+	getTimeslotAssignments
+	sendMessage
+	appointmentWaitlist
+	UserInQueueRoster(ctx context.Context, queue ksuid.KSUID, email string) (bool, error)
+	LockAppointmentSignups(ctx context.Context, queue ksuid.KSUID, email string) error
+	TeammateHasAppointment(ctx context.Context, queue ksuid.KSUID, from, to time.Time, email string) (bool, error)
+	SignupForAppointment(ctx context.Context, queue ksuid.KSUID, appointment *AppointmentSlot) (*AppointmentSlot, error)
 }
 
 func (s *Server) SignupForAppointment(sa signupForAppointment) E {
 	return func(w http.ResponseWriter, r *http.Request) error {
-		q := r.Context().Value(queueContextKey).(*Queue)
-		day := r.Context().Value(appointmentDayContextKey).(int)
-		timeslot := r.Context().Value(appointmentTimeslotContextKey).(int)
-		email := r.Context().Value(emailContextKey).(string)
-		name := r.Context().Value(nameContextKey).(string)
-		admin := r.Context().Value(courseAdminContextKey).(bool)
+		q, ok := r.Context().Value(queueContextKey).(*Queue)
+		if !ok {
+			return s.missingContextValue(r, queueContextKey)
+		}
+		day, ok := r.Context().Value(appointmentDayContextKey).(int)
+		if !ok {
+			return s.missingContextValue(r, appointmentDayContextKey)
+		}
+		// Flexible signups come with a list of timeslots instead, and
+		// pick one below once there's an appointment to fit in it.
+		preferences, flexible := r.Context().Value(flexibleSignupContextKey).([]int)
+		timeslot, ok := r.Context().Value(appointmentTimeslotContextKey).(int)
+		if !ok && !flexible {
+			return s.missingContextValue(r, appointmentTimeslotContextKey)
+		}
+		email, ok := r.Context().Value(emailContextKey).(string)
+		if !ok {
+			return s.missingContextValue(r, emailContextKey)
+		}
+		name, ok := r.Context().Value(nameContextKey).(string)
+		if !ok {
+			return s.missingContextValue(r, nameContextKey)
+		}
+		admin, ok := r.Context().Value(courseAdminContextKey).(bool)
+		if !ok {
+			return s.missingContextValue(r, courseAdminContextKey)
+		}
 		l := s.logger.With(
 			RequestIDContextKey, r.Context().Value(RequestIDContextKey),
 			"queue_id", q.ID,
 			"day", day,
-			"timeslot", timeslot,
 			"email", email,
 		)
+		if !flexible {
+			l = l.With("timeslot", timeslot)
+		}
 
 		config, err := sa.GetQueueConfiguration(r.Context(), q.ID)
 		if err != nil {
@@ -409,6 +4538,11 @@ func (s *Server) SignupForAppointment(sa signupForAppointment) E {
 			return err
 		}
 
+		if config.SignupsFrozen && !admin {
+			l.Warnw("attempted to sign up while signups are frozen")
+			return errSignupsFrozen
+		}
+
 		if config.PreventUnregistered {
 			inRoster, err := sa.UserInQueueRoster(r.Context(), q.ID, email)
 			if err != nil {
@@ -425,67 +4559,540 @@ func (s *Server) SignupForAppointment(sa signupForAppointment) E {
 			}
 		}
 
-		schedule, err := sa.GetAppointmentScheduleForDay(r.Context(), q.ID, day)
+		schedule, err := sa.GetAppointmentScheduleForDay(r.Context(), q.ID, day)
+		if err != nil {
+			l.Errorw("failed to get appointment schedule", "err", err)
+			return err
+		}
+
+		if config.PreventGroups {
+			// Check if a group member has a future or ongoing appointment
+			teammateHasAppointment, err := sa.TeammateHasAppointment(r.Context(), q.ID, time.Now().Add(-time.Minute*time.Duration(schedule.Duration)), BigTime(), email)
+			if err != nil {
+				l.Errorw("failed to get teammate appointments", "err", err)
+				return err
+			}
+
+			if teammateHasAppointment {
+				l.Warnw("student attempted to sign up for appointment with teammate on queue")
+				return StatusError{
+					http.StatusConflict,
+					"It looks like one of your group members already has an appointment!",
+				}
+			}
+		}
+
+		var appointment AppointmentSlot
+		err = s.decodeLimitedBody(w, r, &appointment)
+		if errors.Is(err, errBodyTooLarge) {
+			l.Warnw("appointment request body too large")
+			return err
+		}
+		if err != nil {
+			l.Warnw("failed to decode appointment", "err", err)
+			return StatusError{
+				http.StatusBadRequest,
+				"We couldn't read your appointment in the request body.",
+			}
+		}
+		appointment.Name = &name
+
+		if flexible {
+			timeslot, err = s.pickFlexibleTimeslot(r.Context(), l, sa, q, config, schedule, day, appointment.SlotSpan, preferences)
+			if err != nil {
+				return err
+			}
+			l = l.With("timeslot", timeslot)
+		}
+
+		// Students who leave the location blank get the timeslot's
+		// default, if it has one; anything they type in wins.
+		if loc := trimmed(appointment.Location); (loc == nil || *loc == "") && schedule.Locations[timeslot] != "" {
+			location := schedule.Locations[timeslot]
+			appointment.Location = &location
+		}
+
+		if !hasRequiredAppointmentFields(&appointment, config) {
+			l.Warnw("got incomplete appointment", "appointment", appointment)
+			return StatusError{
+				http.StatusBadRequest,
+				"It looks like you left out some fields in the appointment.",
+			}
+		}
+
+		if field := appointmentFieldTooLong(&appointment, config); field != "" {
+			l.Warnw("got appointment field over max length",
+				"field", field,
+				"max_appointment_field_length", config.MaxAppointmentFieldLength,
+			)
+			return StatusError{
+				http.StatusBadRequest,
+				fmt.Sprintf("The %s on your appointment is too long; it can be at most %d characters.", field, config.MaxAppointmentFieldLength),
+			}
+		}
+
+		if appointmentDescriptionTooShort(&appointment, config) {
+			l.Warnw("got appointment description under min length",
+				"length", utf8.RuneCountInString(*appointment.Description),
+				"min_appointment_description_length", config.MinAppointmentDescriptionLength,
+			)
+			return StatusError{
+				http.StatusBadRequest,
+				fmt.Sprintf("Tell us a bit more about what you need help with! Descriptions need to be at least %d characters.", config.MinAppointmentDescriptionLength),
+			}
+		}
+
+		if section := missingTemplateSection(&appointment, config); section != "" {
+			l.Warnw("got appointment description missing template section", "section", section)
+			return StatusError{
+				http.StatusBadRequest,
+				fmt.Sprintf(`Your description needs something under "%s".`, section),
+			}
+		}
+
+		definitions, err := sa.GetCustomFieldDefinitions(r.Context(), q.ID)
+		if err != nil {
+			l.Errorw("failed to get custom field definitions", "err", err)
+			return err
+		}
+
+		err = validateCustomFields(definitions, appointment.CustomFields)
+		if err != nil {
+			l.Warnw("got invalid custom fields", "custom_fields", appointment.CustomFields, "err", err)
+			return err
+		}
+
+		regions, err := sa.GetMapRegions(r.Context(), q.ID)
+		if err != nil {
+			l.Errorw("failed to get map regions", "err", err)
+			return err
+		}
+
+		err = validateMapPin(regions, &appointment)
+		if err != nil {
+			l.Warnw("got map pin outside map regions", "map_x", appointment.MapX, "map_y", appointment.MapY)
+			return err
+		}
+
+		// Partners share the appointment (and its one slot), so each of
+		// them has to be someone who could have signed up on their own.
+		// Partners don't get asked first (they can leave with
+		// LeaveAppointment), so groups are kept small.
+		if len(appointment.Partners) > maxAppointmentPartners {
+			l.Warnw("got too many partners", "num_partners", len(appointment.Partners))
+			return StatusError{
+				http.StatusBadRequest,
+				fmt.Sprintf("You can bring at most %d partners to an appointment.", maxAppointmentPartners),
+			}
+		}
+
+		seenPartners := map[string]bool{email: true}
+		for _, p := range appointment.Partners {
+			if p == "" || seenPartners[p] {
+				l.Warnw("got invalid partner list", "partners", appointment.Partners)
+				return StatusError{
+					http.StatusBadRequest,
+					"Each partner needs to be listed once, and you can't be your own partner!",
+				}
+			}
+			seenPartners[p] = true
+
+			if config.PreventUnregistered {
+				inRoster, err := sa.UserInQueueRoster(r.Context(), q.ID, p)
+				if err != nil {
+					l.Errorw("failed to get queue roster", "err", err)
+					return err
+				}
+
+				if !inRoster {
+					l.Warnw("student attempted to sign up with partner not in queue roster", "partner", p)
+					return StatusError{
+						http.StatusForbidden,
+						fmt.Sprintf("It doesn't look like %s is in the roster for this queue.", p),
+					}
+				}
+			}
+
+			if config.PreventGroups {
+				teammateHasAppointment, err := sa.TeammateHasAppointment(r.Context(), q.ID, time.Now().Add(-time.Minute*time.Duration(schedule.Duration)), BigTime(), p)
+				if err != nil {
+					l.Errorw("failed to get partner's teammate appointments", "partner", p, "err", err)
+					return err
+				}
+
+				if teammateHasAppointment {
+					l.Warnw("student attempted to sign up with partner whose teammate has appointment", "partner", p)
+					return StatusError{
+						http.StatusConflict,
+						fmt.Sprintf("It looks like one of %s's group members already has an appointment!", p),
+					}
+				}
+			}
+		}
+
+		if timeslot >= len(schedule.Schedule) {
+			l.Warnw("attempted to sign up for non-existent timeslot", "num_slots", len(schedule.Schedule))
+			return StatusError{
+				http.StatusNotFound,
+				"That timeslot doesn't exist!",
+			}
+		}
+
+		if appointment.SlotSpan == 0 {
+			appointment.SlotSpan = 1
+		}
+
+		if appointment.SlotSpan < 1 || appointment.SlotSpan > maxAppointmentSlotSpan || timeslot+appointment.SlotSpan > len(schedule.Schedule) {
+			l.Warnw("got invalid appointment slot span",
+				"slot_span", appointment.SlotSpan,
+				"num_slots", len(schedule.Schedule),
+			)
+			return StatusError{
+				http.StatusBadRequest,
+				fmt.Sprintf("Appointments can take up between 1 and %d timeslots, and can't run past the end of the day.", maxAppointmentSlotSpan),
+			}
+		}
+
+		if schedule.SignupsOpen > 0 {
+			dayStart, _ := WeekdayBounds(day)
+			opensAt := dayStart.Add(-time.Duration(schedule.SignupsOpen) * time.Minute)
+			if s.now().Before(opensAt) {
+				l.Warnw("attempted to sign up before signups opened", "opens_at", opensAt)
+				return StatusError{
+					http.StatusForbidden,
+					fmt.Sprintf("Signups for this day aren't open yet. They open %s.", opensAt.In(time.Local).Format("Monday at 3:04 PM")),
+				}
+			}
+		}
+
+		if schedule.SignupCutoff > 0 {
+			if first := schedule.FirstOpenTimeslot(); first >= 0 {
+				dayStart, _ := WeekdayBounds(day)
+				cutoff := SlotStart(dayStart, first, schedule).Add(time.Duration(schedule.SignupCutoff) * time.Minute)
+				if s.now().After(cutoff) {
+					l.Warnw("attempted to sign up after day's signup cutoff", "cutoff", cutoff)
+					return StatusError{
+						http.StatusForbidden,
+						fmt.Sprintf("Signups for %s closed at %s.", time.Weekday(day), cutoff.In(time.Local).Format("3:04 PM")),
+					}
+				}
+			}
+		}
+
+		// Timeslots with no slots at all are breaks in the schedule, not
+		// full; tell the student so they don't keep checking back.
+		span := appointment.SlotSpan
+		for t := timeslot; t < timeslot+span; t++ {
+			if schedule.Schedule[t] == '0' {
+				l.Warnw("attempted to sign up during break in schedule", "break_timeslot", t)
+				return DetailedError{errTimeslotBreak, timeslotBreak, nil}
+			}
+		}
+
+		start, end := WeekdayBounds(day)
+
+		// Queues that want signups confirmed need the time the student
+		// saw to still be the timeslot's time, in case the schedule
+		// changed under them. Flexible signups don't know their timeslot
+		// ahead of time, so there's nothing for them to confirm.
+		if config.RequireSignupTimeConfirmation && !admin && !flexible {
+			scheduledTime := SlotStart(start, timeslot, schedule)
+			if !appointment.ScheduledTime.Equal(scheduledTime) {
+				l.Warnw("got signup with stale scheduled time",
+					"confirmed_time", appointment.ScheduledTime,
+					"scheduled_time", scheduledTime,
+				)
+				localTime := scheduledTime.In(time.Local)
+				return DetailedError{
+					StatusError{
+						http.StatusConflict,
+						"That timeslot's time has changed since you picked it. Take a look at the new time and confirm again!",
+					},
+					signupSlotChanged,
+					rescheduleTarget{Timeslot: timeslot, ScheduledTime: &localTime},
+				}
+			}
+		}
+
+		// Flexible signups already checked capacity while picking.
+		if !flexible {
+			err = s.checkSignupCapacity(r.Context(), l, sa, q, config, schedule, day, timeslot, span)
+			var se StatusError
+			if errors.As(err, &se) && config.AutoWaitlist && span == 1 && !admin {
+				// Rather than turning them away, put them in line for
+				// the timeslot.
+				start, _ := WeekdayBounds(day)
+				position, err := s.joinWaitlist(r.Context(), l, sa, q, SlotStart(start, timeslot, schedule), timeslot, email)
+				if err != nil {
+					return err
+				}
+				return s.sendResponse(http.StatusAccepted, position, w, r)
+			} else if err != nil {
+				return err
+			}
+		}
+
+		// Everyone who'd end up with this appointment is locked (in the
+		// same order every time, so two sign-ups sharing partners can't
+		// deadlock) until the new appointment is committed; otherwise a
+		// second sign-up at the same moment would see no appointment below.
+		locked := append([]string{email}, appointment.Partners...)
+		sort.Strings(locked)
+		for _, e := range locked {
+			err = sa.LockAppointmentSignups(r.Context(), q.ID, e)
+			if err != nil {
+				l.Errorw("failed to lock appointment sign-ups", "locked_email", e, "err", err)
+				return err
+			}
+		}
+
+		// Check if the user has an appointment starting in the future
+		// (or in the previous duration minutes, meaning they have an ongoing appointment)
+		startFutureCheck := time.Now().Add(-time.Duration(schedule.Duration) * time.Minute)
+		endFutureCheck := BigTime()
+		conflictMessage := "You already have an appointment in the future!"
+		if config.FutureAppointmentScope == FutureAppointmentScopeDay {
+			// Only the day being signed up for counts.
+			if start.After(startFutureCheck) {
+				startFutureCheck = start
+			}
+			endFutureCheck = end
+			conflictMessage = "You already have an appointment on that day!"
+		}
+
+		appointments, err := sa.GetAppointmentsForUser(r.Context(), q.ID, startFutureCheck, endFutureCheck, email)
+		if err != nil {
+			l.Errorw("failed to get future appointments for user", "err", err)
+			return err
+		}
+
+		if len(appointments) > 0 {
+			l.Warnw("user attempted to sign up for appointment with one in future",
+				"future_appointment_scope", config.FutureAppointmentScope,
+				"conflicting_appointment_id", appointments[0].ID,
+			)
+			return DetailedError{
+				StatusError{
+					http.StatusConflict,
+					conflictMessage,
+				},
+				"appointment_conflict",
+				AppointmentConflict{
+					AppointmentID: appointments[0].ID,
+					ScheduledTime: appointments[0].ScheduledTime.In(time.Local),
+				},
+			}
+		}
+
+		for _, p := range appointment.Partners {
+			appointments, err := sa.GetAppointmentsForUser(r.Context(), q.ID, startFutureCheck, endFutureCheck, p)
+			if err != nil {
+				l.Errorw("failed to get future appointments for partner", "partner", p, "err", err)
+				return err
+			}
+
+			if len(appointments) > 0 {
+				l.Warnw("user attempted to sign up for appointment with partner with one in future",
+					"partner", p,
+					"future_appointment_scope", config.FutureAppointmentScope,
+				)
+				return StatusError{
+					http.StatusConflict,
+					fmt.Sprintf("It looks like your partner %s already has an appointment that conflicts with this one.", p),
+				}
+			}
+		}
+
+		// Force some values that were previously validated by middleware
+		appointment.Queue = q.ID
+		appointment.Timeslot = timeslot
+		appointment.ScheduledTime = SlotStart(start, timeslot, schedule)
+		appointment.Duration = schedule.Duration * span
+		appointment.StudentEmail = &email
+		appointment.MeetingLink = nil
+
+		var zero float32
+		if appointment.MapX == nil {
+			appointment.MapX = &zero
+		}
+		if appointment.MapY == nil {
+			appointment.MapY = &zero
+		}
+
+		ctx, sp := startSpan(r.Context(), "SignupForAppointment")
+		newAppointment, err := sa.SignupForAppointment(ctx, q.ID, &appointment)
+		endSpan(sp, err)
 		if err != nil {
-			l.Errorw("failed to get appointment schedule", "err", err)
+			l.Errorw("failed to sign up for appointment", "err", err)
 			return err
 		}
 
-		if config.PreventGroups {
-			// Check if a group member has a future or ongoing appointment
-			teammateHasAppointment, err := sa.TeammateHasAppointment(r.Context(), q.ID, time.Now().Add(-time.Minute*time.Duration(schedule.Duration)), BigTime(), email)
+		// Someone who got in doesn't need to keep waiting.
+		_, err = sa.RemoveFromWaitlist(r.Context(), q.ID, newAppointment.ScheduledTime, email)
+		if err != nil {
+			l.Errorw("failed to remove student from waitlist", "err", err)
+			return err
+		}
+
+		if len(appointment.Partners) > 0 {
+			err = sa.AddAppointmentPartners(r.Context(), newAppointment.ID, appointment.Partners)
 			if err != nil {
-				l.Errorw("failed to get teammate appointments", "err", err)
+				l.Errorw("failed to add appointment partners", "err", err)
 				return err
 			}
+			newAppointment.Partners = appointment.Partners
+		}
 
-			if teammateHasAppointment {
-				l.Warnw("student attempted to sign up for appointment with teammate on queue")
-				return StatusError{
-					http.StatusConflict,
-					"It looks like one of your group members already has an appointment!",
-				}
+		if len(appointment.CustomFields) > 0 {
+			err = sa.SetAppointmentCustomFields(r.Context(), newAppointment.ID, appointment.CustomFields)
+			if err != nil {
+				l.Errorw("failed to set appointment custom fields", "err", err)
+				return err
 			}
+			newAppointment.CustomFields = appointment.CustomFields
 		}
 
-		var appointment AppointmentSlot
-		err = json.NewDecoder(r.Body).Decode(&appointment)
+		l.Infow("new appointment sign up",
+			"appointment_id", newAppointment.ID,
+			"scheduled_time", appointment.ScheduledTime,
+		)
+
+		err = s.notifyAssignee(r.Context(), l, sa, q, day, newAppointment, email,
+			fmt.Sprintf("%s signed up for an appointment at %s, which you're assigned to.",
+				name,
+				newAppointment.ScheduledTime.In(time.Local).Format("Monday 3:04 PM"),
+			),
+		)
 		if err != nil {
-			l.Warnw("failed to decode appointment", "err", err)
-			return StatusError{
-				http.StatusBadRequest,
-				"We couldn't read your appointment in the request body.",
-			}
+			return err
 		}
-		appointment.Name = &name
 
-		if appointment.Description == nil || appointment.Name == nil || appointment.Location == nil ||
-			*appointment.Description == "" || *appointment.Name == "" || *appointment.Location == "" {
-			l.Warnw("got incomplete appointment", "appointment", appointment)
-			return StatusError{
-				http.StatusBadRequest,
-				"It looks like you left out some fields in the appointment.",
-			}
+		s.ps.Pub(WS("APPOINTMENT_CREATE", newAppointment), QueueTopicAdmin(q.ID))
+		s.ps.Pub(WS("APPOINTMENT_CREATE", newAppointment.Anonymized()), QueueTopicNonPrivileged(q.ID))
+		if !admin {
+			s.ps.Pub(WS("APPOINTMENT_UPDATE", newAppointment.NoStaffEmail()), QueueTopicEmail(q.ID, email))
+		}
+		for _, p := range newAppointment.Partners {
+			s.ps.Pub(WS("APPOINTMENT_UPDATE", newAppointment.NoStaffEmail()), QueueTopicEmail(q.ID, p))
 		}
+		s.publishAppointmentEvent(r.Context(), l, AppointmentSignup, q.ID, newAppointment, nil)
 
-		if timeslot > len(schedule.Schedule) {
-			l.Warnw("attempted to sign up for non-existent timeslot", "num_slots", len(schedule.Schedule))
-			return StatusError{
-				http.StatusNotFound,
-				"That timeslot doesn't exist!",
-			}
+		// The meeting isn't set up until the signup commits, so a signup
+		// that falls through doesn't leave one behind. Its link shows up
+		// on the appointment a moment after it's booked.
+		if config.AppointmentLocationType == AppointmentLocationRemote {
+			booked := *newAppointment
+			afterCommit(r.Context(), func() {
+				s.provisionMeetingLinkAfterCommit(l, sa, q, &booked)
+			})
 		}
 
-		start, end := WeekdayBounds(day)
+		return s.sendAppointmentResponse(http.StatusCreated, newAppointment, w, r)
+	}
+}
+
+// systemSender is who messages the queue sends on its own come from,
+// rather than the student whose action set them off.
+const systemSender = "<system>"
+
+type assigneeNotifier interface {
+	getTimeslotAssignments
+	sendMessage
+}
+
+// notifyAssignee sends content to whoever's assigned to a's timeslot,
+// unless that's the student themself. The message is saved along with
+// the rest of the request, and only goes out once that commits.
+func (s *Server) notifyAssignee(ctx context.Context, l *zap.SugaredLogger, an assigneeNotifier, q *Queue, day int, a *AppointmentSlot, email, content string) error {
+	assignments, err := an.GetTimeslotAssignments(ctx, q.ID, day)
+	if err != nil {
+		l.Errorw("failed to get timeslot assignments", "err", err)
+		return err
+	}
+
+	assignee, ok := assignments[a.Timeslot]
+	if !ok || assignee == email {
+		return nil
+	}
+
+	message, err := an.SendMessage(ctx, q.ID, content, systemSender, assignee)
+	if err != nil {
+		l.Errorw("failed to send message to assigned staff member", "assignee", assignee, "err", err)
+		return err
+	}
+
+	afterCommit(ctx, func() {
+		s.ps.Pub(WS("MESSAGE_CREATE", message), QueueTopicEmail(q.ID, message.Receiver))
+	})
+	return nil
+}
+
+// pickFlexibleTimeslot finds the first of the student's preferred
+// timeslots that an appointment span timeslots long fits in right now.
+// Timeslots that are full, in the past, or on a break are skipped.
+func (s *Server) pickFlexibleTimeslot(ctx context.Context, l *zap.SugaredLogger, cs checkSignupCapacity, q *Queue, config *QueueConfiguration, schedule *AppointmentSchedule, day, span int, preferences []int) (int, error) {
+	if span < 1 {
+		span = 1
+	}
+
+	start, _ := WeekdayBounds(day)
+	for _, timeslot := range preferences {
+		if timeslot < 0 || timeslot+span > len(schedule.Schedule) {
+			continue
+		}
+		if time.Now().After(SlotStart(start, timeslot, schedule)) {
+			continue
+		}
+		if strings.Contains(schedule.Schedule[timeslot:timeslot+span], "0") {
+			continue
+		}
+
+		err := s.checkSignupCapacity(ctx, l, cs, q, config, schedule, day, timeslot, span)
+		var se StatusError
+		if errors.As(err, &se) {
+			continue
+		} else if err != nil {
+			return 0, err
+		}
+
+		l.Infow("picked timeslot from preferences",
+			"timeslot", timeslot,
+			"preferences", preferences,
+		)
+		return timeslot, nil
+	}
 
-		// First: check if there are any slots open at this timeslot
-		timeslotAppointments, err := sa.GetAppointmentsByTimeslot(r.Context(), q.ID, start, end, timeslot)
+	l.Warnw("no preferred timeslots open", "preferences", preferences)
+	return 0, StatusError{
+		http.StatusConflict,
+		"None of those timeslots have any slots open!",
+	}
+}
+
+type checkSignupCapacity interface {
+	getAppointmentsInTimeFrame
+	getAppointmentsByTimeslot
+}
+
+// checkSignupCapacity makes sure there's room for a new appointment span
+// timeslots long starting at timeslot, both in each of those timeslots
+// and under the queue's cap on concurrent appointments.
+func (s *Server) checkSignupCapacity(ctx context.Context, l *zap.SugaredLogger, cs checkSignupCapacity, q *Queue, config *QueueConfiguration, schedule *AppointmentSchedule, day, timeslot, span int) (err error) {
+	ctx, sp := startSpan(ctx, "checkSignupCapacity")
+	defer func() { endSpan(sp, err) }()
+
+	start, end := WeekdayBounds(day)
+
+	// First: check if there are any slots open at every timeslot the
+	// appointment takes up
+	for t := timeslot; t < timeslot+span; t++ {
+		timeslotAppointments, err := cs.GetAppointmentsByTimeslot(ctx, q.ID, start, end, t)
 		if err != nil {
-			l.Errorw("failed to get appointments for timeslot", "err", err)
+			l.Errorw("failed to get appointments for timeslot", "checked_timeslot", t, "err", err)
 			return err
 		}
 
-		open := int(schedule.Schedule[timeslot] - '0')
+		open := int(schedule.Schedule[t] - '0')
 		for _, a := range timeslotAppointments {
 			if a.StudentEmail != nil {
 				open--
@@ -493,63 +5100,228 @@ func (s *Server) SignupForAppointment(sa signupForAppointment) E {
 		}
 
 		if open < 1 {
-			l.Warnw("no appointment slots available at timeslot")
+			l.Warnw("no appointment slots available at timeslot", "full_timeslot", t)
+			if t != timeslot {
+				return StatusError{
+					http.StatusConflict,
+					"One of the later timeslots this appointment would take up is full.",
+				}
+			}
 			return StatusError{
 				http.StatusConflict,
 				"There are no slots open at that time!",
 			}
 		}
+	}
 
-		// Check if the user has an appointment starting in the future
-		// (or in the previous duration minutes, meaning they have an ongoing appointment)
-		startFutureCheck := time.Now().Add(-time.Duration(schedule.Duration) * time.Minute)
-		appointments, err := sa.GetAppointmentsForUser(r.Context(), q.ID, startFutureCheck, BigTime(), email)
+	// Then: check the queue-wide cap, which counts every appointment
+	// going on at the same time, whatever timeslot it was booked in.
+	if config.MaxConcurrentAppointments > 0 {
+		dayAppointments, err := cs.GetAppointments(ctx, q.ID, start, end)
 		if err != nil {
-			l.Errorw("failed to get future appointments for user", "err", err)
+			l.Errorw("failed to get appointments for day", "err", err)
 			return err
 		}
 
-		if len(appointments) > 0 {
-			l.Warn("user attempted to sign up for appointment with one in future")
+		scheduledTime := SlotStart(start, timeslot, schedule)
+		if concurrentAppointments(dayAppointments, scheduledTime, schedule.Duration*span, ksuid.Nil) >= config.MaxConcurrentAppointments {
+			l.Warnw("queue at maximum concurrent appointments",
+				"max_concurrent_appointments", config.MaxConcurrentAppointments,
+			)
 			return StatusError{
 				http.StatusConflict,
-				"You already have an appointment in the future!",
+				"There are no slots open at that time!",
 			}
 		}
+	}
 
-		// Force some values that were previously validated by middleware
-		appointment.Queue = q.ID
-		appointment.Timeslot = timeslot
-		appointment.ScheduledTime = TimeslotToTime(day, timeslot, schedule.Duration)
-		appointment.Duration = schedule.Duration
-		appointment.StudentEmail = &email
+	return nil
+}
 
-		var zero float32
-		if appointment.MapX == nil {
-			appointment.MapX = &zero
+// provisionMeetingLink sets up a meeting for an appointment that's
+// missing one and stores the link on it, in the request's transaction.
+// If anything goes wrong, the appointment is left without a link.
+func (s *Server) provisionMeetingLink(ctx context.Context, l *zap.SugaredLogger, sm setAppointmentMeetingLink, a *AppointmentSlot) {
+	link, err := s.meetings.CreateMeeting(ctx, a)
+	if err != nil {
+		l.Errorw("failed to create meeting for appointment", "appointment_id", a.ID, "err", err)
+		return
+	}
+
+	if link == "" {
+		return
+	}
+
+	err = sm.SetAppointmentMeetingLink(ctx, a.ID, &link)
+	if err != nil {
+		l.Errorw("failed to store meeting link for appointment", "appointment_id", a.ID, "err", err)
+		s.meetings.DeleteMeeting(ctx, link)
+		return
+	}
+
+	a.MeetingLink = &link
+	l.Infow("created meeting for appointment", "appointment_id", a.ID, "meeting_link", link)
+}
+
+// provisionMeetingLinkAfterCommit sets up a meeting for an appointment
+// whose signup has committed and attaches the link to it. If the student
+// cancelled in the meantime, the meeting is torn down again. Anything
+// that goes wrong is logged; staff can retry appointments left without a
+// link (see RetryProvisionLink).
+func (s *Server) provisionMeetingLinkAfterCommit(l *zap.SugaredLogger, am attachMeetingLink, q *Queue, a *AppointmentSlot) {
+	ctx, cancel := context.WithTimeout(context.Background(), meetingTimeout)
+	defer cancel()
+
+	link, err := s.meetings.CreateMeeting(ctx, a)
+	if err != nil {
+		l.Errorw("failed to create meeting for appointment", "appointment_id", a.ID, "err", err)
+		return
+	}
+
+	if link == "" {
+		return
+	}
+
+	attached, err := am.AttachMeetingLink(ctx, a.ID, *a.StudentEmail, link)
+	if err != nil || !attached {
+		if err != nil {
+			l.Errorw("failed to store meeting link for appointment", "appointment_id", a.ID, "err", err)
+		} else {
+			l.Warnw("appointment changed before meeting link was stored", "appointment_id", a.ID)
 		}
-		if appointment.MapY == nil {
-			appointment.MapY = &zero
+		err = s.meetings.DeleteMeeting(ctx, link)
+		if err != nil {
+			l.Errorw("failed to delete unused meeting", "meeting_link", link, "err", err)
+		}
+		return
+	}
+
+	a.MeetingLink = &link
+	l.Infow("created meeting for appointment", "appointment_id", a.ID, "meeting_link", link)
+
+	s.ps.Pub(WS("APPOINTMENT_UPDATE", a), QueueTopicAdmin(q.ID))
+	s.ps.Pub(WS("APPOINTMENT_UPDATE", a.NoStaffEmail()), QueueTopicEmail(q.ID, *a.StudentEmail))
+	for _, p := range a.Partners {
+		s.ps.Pub(WS("APPOINTMENT_UPDATE", a.NoStaffEmail()), QueueTopicEmail(q.ID, p))
+	}
+}
+
+type getAppointmentsMissingLink interface {
+	getQueueConfiguration
+	getAppointmentsInTimeFrame
+}
+
+// GetAppointmentsMissingLink lists the upcoming appointments on a remote
+// queue that someone signed up for but that don't have a meeting link,
+// usually because setting up the meeting failed, so staff can retry.
+func (s *Server) GetAppointmentsMissingLink(ga getAppointmentsMissingLink) E {
+	return func(w http.ResponseWriter, r *http.Request) error {
+		q, ok := r.Context().Value(queueContextKey).(*Queue)
+		if !ok {
+			return s.missingContextValue(r, queueContextKey)
+		}
+		l := s.logger.With(
+			RequestIDContextKey, r.Context().Value(RequestIDContextKey),
+			"queue_id", q.ID,
+		)
+
+		config, err := ga.GetQueueConfiguration(r.Context(), q.ID)
+		if err != nil {
+			l.Errorw("failed to get queue configuration", "err", err)
+			return err
+		}
+
+		missing := make([]*AppointmentSlot, 0)
+		if config.AppointmentLocationType != AppointmentLocationRemote {
+			return s.sendAppointmentResponse(http.StatusOK, missing, w, r)
 		}
 
-		newAppointment, err := sa.SignupForAppointment(r.Context(), q.ID, &appointment)
+		appointments, err := ga.GetAppointments(r.Context(), q.ID, time.Now(), BigTime())
 		if err != nil {
-			l.Errorw("failed to sign up for appointment", "err", err)
+			l.Errorw("failed to get upcoming appointments", "err", err)
 			return err
 		}
 
-		l.Infow("new appointment sign up",
-			"appointment_id", newAppointment.ID,
-			"scheduled_time", appointment.ScheduledTime,
+		for _, a := range appointments {
+			if a.StudentEmail != nil && a.MeetingLink == nil {
+				missing = append(missing, a)
+			}
+		}
+
+		return s.sendAppointmentResponse(http.StatusOK, missing, w, r)
+	}
+}
+
+type retryProvisionLink interface {
+	getQueueConfiguration
+	setAppointmentMeetingLink
+}
+
+// RetryProvisionLink tries setting up a meeting again for an appointment
+// that doesn't have a link.
+func (s *Server) RetryProvisionLink(rp retryProvisionLink) E {
+	return func(w http.ResponseWriter, r *http.Request) error {
+		q, ok := r.Context().Value(queueContextKey).(*Queue)
+		if !ok {
+			return s.missingContextValue(r, queueContextKey)
+		}
+		a, ok := r.Context().Value(appointmentContextKey).(*AppointmentSlot)
+		if !ok {
+			return s.missingContextValue(r, appointmentContextKey)
+		}
+		l := s.logger.With(
+			RequestIDContextKey, r.Context().Value(RequestIDContextKey),
+			"queue_id", q.ID,
+			"appointment_id", a.ID,
+			"email", r.Context().Value(emailContextKey),
 		)
 
-		s.ps.Pub(WS("APPOINTMENT_CREATE", newAppointment), QueueTopicAdmin(q.ID))
-		s.ps.Pub(WS("APPOINTMENT_CREATE", newAppointment.Anonymized()), QueueTopicNonPrivileged(q.ID))
-		if !admin {
-			s.ps.Pub(WS("APPOINTMENT_UPDATE", newAppointment.NoStaffEmail()), QueueTopicEmail(q.ID, email))
+		if a.StudentEmail == nil {
+			l.Warnw("attempted to provision meeting link for appointment without student")
+			return StatusError{
+				http.StatusNotFound,
+				"Nobody's signed up for that appointment, so it doesn't need a meeting.",
+			}
+		}
+
+		if a.MeetingLink != nil {
+			return s.sendAppointmentResponse(http.StatusOK, a, w, r)
+		}
+
+		config, err := rp.GetQueueConfiguration(r.Context(), q.ID)
+		if err != nil {
+			l.Errorw("failed to get queue configuration", "err", err)
+			return err
+		}
+
+		if config.AppointmentLocationType != AppointmentLocationRemote {
+			l.Warnw("attempted to provision meeting link on in-person queue")
+			return StatusError{
+				http.StatusBadRequest,
+				"This queue's appointments are in person, so they don't get meeting links.",
+			}
+		}
+
+		if time.Now().After(a.ScheduledTime.Add(time.Duration(a.Duration) * time.Minute)) {
+			l.Warnw("attempted to provision meeting link for past appointment")
+			return StatusError{
+				http.StatusBadRequest,
+				"That appointment is already over.",
+			}
+		}
+
+		s.provisionMeetingLink(r.Context(), l, rp, a)
+		if a.MeetingLink == nil {
+			return StatusError{
+				http.StatusBadGateway,
+				"We still couldn't set up a meeting for that appointment. Try again in a bit!",
+			}
 		}
 
-		return s.sendResponse(http.StatusCreated, newAppointment, w, r)
+		s.ps.Pub(WS("APPOINTMENT_UPDATE", a), QueueTopicAdmin(q.ID))
+		s.ps.Pub(WS("APPOINTMENT_UPDATE", a.NoStaffEmail()), QueueTopicEmail(q.ID, *a.StudentEmail))
+
+		return s.sendAppointmentResponse(http.StatusOK, a, w, r)
 	}
 }
 
@@ -557,21 +5329,68 @@ type removeAppointmentSignup interface {
 	RemoveAppointmentSignup(ctx context.Context, appointment ksuid.KSUID) (deleted bool, newAppointment *AppointmentSlot, err error)
 }
 
+// Codes telling the frontend why an appointment couldn't be moved to the
+// timeslot it asked for.
+const (
+	rescheduleSlotFull    = "TARGET_SLOT_FULL"
+	rescheduleTimePast    = "TARGET_TIME_PAST"
+	rescheduleSlotMissing = "TARGET_SLOT_MISSING"
+)
+
+// signupSlotChanged is the code for a signup whose confirmed time doesn't
+// match the timeslot anymore.
+const signupSlotChanged = "SLOT_CHANGED"
+
+// timeslotBreak is the code for signing up or rescheduling into a timeslot
+// the schedule has no slots at all in.
+const timeslotBreak = "TIMESLOT_BREAK"
+
+var errTimeslotBreak = StatusError{
+	http.StatusConflict,
+	"That time is a break in the schedule, so there aren't any appointments then.",
+}
+
+// rescheduleTarget is the details of a failed reschedule: where the
+// appointment was headed.
+type rescheduleTarget struct {
+	Timeslot int `json:"timeslot"`
+	// Missing when the timeslot doesn't exist, so it has no time.
+	ScheduledTime *time.Time `json:"scheduled_time,omitempty"`
+}
+
 type updateAppointment interface {
 	getAppointmentsByTimeslot
 	getAppointmentScheduleForDay
 	signupForAppointment
 	removeAppointmentSignup
+	syncCalendarEvents
+	appointmentCustomFields
+	waitlistNotifier
 	UpdateAppointment(ctx context.Context, appointment ksuid.KSUID, newAppointment *AppointmentSlot) error
 }
 
 func (s *Server) UpdateAppointment(ua updateAppointment) E {
 	return func(w http.ResponseWriter, r *http.Request) error {
-		q := r.Context().Value(queueContextKey).(*Queue)
-		a := r.Context().Value(appointmentContextKey).(*AppointmentSlot)
-		email := r.Context().Value(emailContextKey).(string)
-		name := r.Context().Value(nameContextKey).(string)
-		admin := r.Context().Value(courseAdminContextKey).(bool)
+		q, ok := r.Context().Value(queueContextKey).(*Queue)
+		if !ok {
+			return s.missingContextValue(r, queueContextKey)
+		}
+		a, ok := r.Context().Value(appointmentContextKey).(*AppointmentSlot)
+		if !ok {
+			return s.missingContextValue(r, appointmentContextKey)
+		}
+		email, ok := r.Context().Value(emailContextKey).(string)
+		if !ok {
+			return s.missingContextValue(r, emailContextKey)
+		}
+		name, ok := r.Context().Value(nameContextKey).(string)
+		if !ok {
+			return s.missingContextValue(r, nameContextKey)
+		}
+		admin, ok := r.Context().Value(courseAdminContextKey).(bool)
+		if !ok {
+			return s.missingContextValue(r, courseAdminContextKey)
+		}
 		l := s.logger.With(
 			RequestIDContextKey, r.Context().Value(RequestIDContextKey),
 			"appointment_id", a.ID,
@@ -597,7 +5416,11 @@ func (s *Server) UpdateAppointment(ua updateAppointment) E {
 		}
 
 		var newAppointment AppointmentSlot
-		err := json.NewDecoder(r.Body).Decode(&newAppointment)
+		err := s.decodeLimitedBody(w, r, &newAppointment)
+		if errors.Is(err, errBodyTooLarge) {
+			l.Warnw("appointment request body too large")
+			return err
+		}
 		if err != nil {
 			l.Warnw("failed to decode appointment", "err", err)
 			return StatusError{
@@ -607,8 +5430,13 @@ func (s *Server) UpdateAppointment(ua updateAppointment) E {
 		}
 		newAppointment.Name = &name
 
-		if newAppointment.Description == nil || newAppointment.Name == nil || newAppointment.Location == nil ||
-			*newAppointment.Description == "" || *newAppointment.Name == "" || *newAppointment.Location == "" {
+		config, err := ua.GetQueueConfiguration(r.Context(), q.ID)
+		if err != nil {
+			l.Errorw("failed to get queue configuration", "err", err)
+			return err
+		}
+
+		if !hasRequiredAppointmentFields(&newAppointment, config) {
 			l.Warnw("got incomplete appointment", "appointment", newAppointment)
 			return StatusError{
 				http.StatusBadRequest,
@@ -616,12 +5444,82 @@ func (s *Server) UpdateAppointment(ua updateAppointment) E {
 			}
 		}
 
+		if field := appointmentFieldTooLong(&newAppointment, config); field != "" {
+			l.Warnw("got appointment field over max length",
+				"field", field,
+				"max_appointment_field_length", config.MaxAppointmentFieldLength,
+			)
+			return StatusError{
+				http.StatusBadRequest,
+				fmt.Sprintf("The %s on your appointment is too long; it can be at most %d characters.", field, config.MaxAppointmentFieldLength),
+			}
+		}
+
+		if appointmentDescriptionTooShort(&newAppointment, config) {
+			l.Warnw("got appointment description under min length",
+				"length", utf8.RuneCountInString(*newAppointment.Description),
+				"min_appointment_description_length", config.MinAppointmentDescriptionLength,
+			)
+			return StatusError{
+				http.StatusBadRequest,
+				fmt.Sprintf("Tell us a bit more about what you need help with! Descriptions need to be at least %d characters.", config.MinAppointmentDescriptionLength),
+			}
+		}
+
+		if section := missingTemplateSection(&newAppointment, config); section != "" {
+			l.Warnw("got appointment description missing template section", "section", section)
+			return StatusError{
+				http.StatusBadRequest,
+				fmt.Sprintf(`Your description needs something under "%s".`, section),
+			}
+		}
+
+		definitions, err := ua.GetCustomFieldDefinitions(r.Context(), q.ID)
+		if err != nil {
+			l.Errorw("failed to get custom field definitions", "err", err)
+			return err
+		}
+
+		err = validateCustomFields(definitions, newAppointment.CustomFields)
+		if err != nil {
+			l.Warnw("got invalid custom fields", "custom_fields", newAppointment.CustomFields, "err", err)
+			return err
+		}
+
+		regions, err := ua.GetMapRegions(r.Context(), q.ID)
+		if err != nil {
+			l.Errorw("failed to get map regions", "err", err)
+			return err
+		}
+
+		err = validateMapPin(regions, &newAppointment)
+		if err != nil {
+			l.Warnw("got map pin outside map regions", "map_x", newAppointment.MapX, "map_y", newAppointment.MapY)
+			return err
+		}
+
+		// Anything the server works out itself comes from the stored
+		// appointment, whatever the client sent. In particular, the time
+		// always matches the timeslot: it stays put here, and only gets
+		// recomputed from the grid if the timeslot changes below.
 		newAppointment.ID = a.ID
 		newAppointment.Queue = a.Queue
 		newAppointment.Duration = a.Duration
+		newAppointment.SlotSpan = a.SlotSpan
 		newAppointment.ScheduledTime = a.ScheduledTime
+		newAppointment.UpdatedAt = a.UpdatedAt
+		newAppointment.Group = nil
+		newAppointment.Relative = ""
+		newAppointment.StartsInSeconds = nil
 		newAppointment.StudentEmail = &email
 		newAppointment.StaffEmail = a.StaffEmail
+		// The meeting link (if any) follows the appointment around,
+		// even if it's rescheduled.
+		newAppointment.MeetingLink = a.MeetingLink
+		// Partners can't be changed after signing up, and labels are
+		// only for staff to set.
+		newAppointment.Partners = nil
+		newAppointment.Labels = nil
 
 		var zero float32
 		if newAppointment.MapX == nil {
@@ -638,6 +5536,12 @@ func (s *Server) UpdateAppointment(ua updateAppointment) E {
 				l.Errorw("failed to update appointment", "err", err)
 				return err
 			}
+
+			err = ua.SetAppointmentCustomFields(r.Context(), a.ID, newAppointment.CustomFields)
+			if err != nil {
+				l.Errorw("failed to set appointment custom fields", "err", err)
+				return err
+			}
 			l.Infow("updated appointment")
 
 			s.ps.Pub(WS("APPOINTMENT_UPDATE", &newAppointment), QueueTopicAdmin(q.ID))
@@ -649,35 +5553,82 @@ func (s *Server) UpdateAppointment(ua updateAppointment) E {
 		}
 
 		// We're changing the appointment time. Not so simple.
-		day := int(time.Now().Local().Weekday())
+		if config.SignupsFrozen && !admin {
+			l.Warnw("attempted to reschedule appointment while signups are frozen")
+			return errSignupsFrozen
+		}
+
+		if a.Span() > 1 {
+			l.Warnw("attempted to reschedule appointment spanning several timeslots", "slot_span", a.SlotSpan)
+			return StatusError{
+				http.StatusConflict,
+				"Appointments that take up more than one timeslot can't be moved. Cancel it and sign up again instead!",
+			}
+		}
+
+		if config.DisallowSameDayReschedule {
+			now := time.Now().Local()
+			scheduled := a.ScheduledTime.Local()
+			if now.YearDay() == scheduled.YearDay() && now.Year() == scheduled.Year() {
+				l.Warnw("user attempted to reschedule appointment on same day")
+				return StatusError{
+					http.StatusForbidden,
+					"Appointments can't be moved on the day they're happening.",
+				}
+			}
+		}
+
+		// Appointments move within their own day, which isn't
+		// necessarily today.
+		day := int(a.ScheduledTime.In(time.Local).Weekday())
 		schedule, err := ua.GetAppointmentScheduleForDay(r.Context(), a.Queue, day)
 		if err != nil {
 			l.Errorw("failed to get appointment schedule", "err", err)
 			return err
 		}
 
+		// Check this before doing anything with the timeslot, since it
+		// comes straight from the request body.
+		if newAppointment.Timeslot < 0 || newAppointment.Timeslot >= len(schedule.Schedule) {
+			l.Warnw("attempted to change appointment to non-existent timeslot",
+				"timeslot", newAppointment.Timeslot,
+				"num_slots", len(schedule.Schedule),
+			)
+			return DetailedError{
+				StatusError{
+					http.StatusNotFound,
+					"That timeslot doesn't exist!",
+				},
+				rescheduleSlotMissing,
+				rescheduleTarget{Timeslot: newAppointment.Timeslot},
+			}
+		}
+
 		start, end := WeekdayBounds(day)
-		newTime := TimeslotToTime(day, newAppointment.Timeslot, schedule.Duration)
+		newTime := SlotStart(start, newAppointment.Timeslot, schedule)
 		newAppointment.ScheduledTime = newTime
+		// Only single timeslots get moved, so the moved appointment is as
+		// long as a timeslot is now, whatever it was when it was booked.
+		newAppointment.Duration = schedule.Duration
+		localTime := newTime.In(time.Local)
+		target := rescheduleTarget{Timeslot: newAppointment.Timeslot, ScheduledTime: &localTime}
 
 		// If the new time is in the past, stop.
 		if time.Now().After(newTime) {
 			l.Warnw("user attempted to change appointment to past", "new_time", newTime)
-			return StatusError{
-				http.StatusBadRequest,
-				"You can't change your appointment to the past! Let us know if you have a time machine.",
+			return DetailedError{
+				StatusError{
+					http.StatusBadRequest,
+					"You can't change your appointment to the past! Let us know if you have a time machine.",
+				},
+				rescheduleTimePast,
+				target,
 			}
 		}
 
-		if newAppointment.Timeslot > len(schedule.Schedule) {
-			l.Warnw("attempted to change appointment to non-existent timeslot",
-				"timeslot", newAppointment.Timeslot,
-				"num_slots", len(schedule.Schedule),
-			)
-			return StatusError{
-				http.StatusNotFound,
-				"That timeslot doesn't exist!",
-			}
+		if schedule.Schedule[newAppointment.Timeslot] == '0' {
+			l.Warnw("attempted to change appointment to break in schedule", "timeslot", newAppointment.Timeslot)
+			return DetailedError{errTimeslotBreak, timeslotBreak, target}
 		}
 
 		timeslotAppointments, err := ua.GetAppointmentsByTimeslot(r.Context(), a.Queue, start, end, newAppointment.Timeslot)
@@ -695,9 +5646,37 @@ func (s *Server) UpdateAppointment(ua updateAppointment) E {
 
 		if open < 1 {
 			l.Warnw("no appointment slots available at timeslot", "timeslot", newAppointment.Timeslot)
-			return StatusError{
-				http.StatusConflict,
-				"There are no slots open at that time!",
+			return DetailedError{
+				StatusError{
+					http.StatusConflict,
+					"There are no slots open at that time!",
+				},
+				rescheduleSlotFull,
+				target,
+			}
+		}
+
+		if config.MaxConcurrentAppointments > 0 {
+			dayAppointments, err := ua.GetAppointments(r.Context(), a.Queue, start, end)
+			if err != nil {
+				l.Errorw("failed to get appointments for day", "err", err)
+				return err
+			}
+
+			// The appointment being moved doesn't count against itself.
+			if concurrentAppointments(dayAppointments, newTime, schedule.Duration, a.ID) >= config.MaxConcurrentAppointments {
+				l.Warnw("queue at maximum concurrent appointments",
+					"timeslot", newAppointment.Timeslot,
+					"max_concurrent_appointments", config.MaxConcurrentAppointments,
+				)
+				return DetailedError{
+					StatusError{
+						http.StatusConflict,
+						"There are no slots open at that time!",
+					},
+					rescheduleSlotFull,
+					target,
+				}
 			}
 		}
 
@@ -709,6 +5688,50 @@ func (s *Server) UpdateAppointment(ua updateAppointment) E {
 		}
 		l.Infow("created appointment for update", "new_appointment_id", createdAppointment.ID)
 
+		partners, err := ua.GetAppointmentPartners(r.Context(), a.ID)
+		if err != nil {
+			l.Errorw("failed to get appointment partners", "err", err)
+			return err
+		}
+
+		if len(partners) > 0 {
+			err = ua.AddAppointmentPartners(r.Context(), createdAppointment.ID, partners)
+			if err != nil {
+				l.Errorw("failed to move partners to new appointment", "err", err)
+				return err
+			}
+			createdAppointment.Partners = partners
+		}
+
+		labels, err := ua.GetAppointmentLabels(r.Context(), a.ID)
+		if err != nil {
+			l.Errorw("failed to get appointment labels", "err", err)
+			return err
+		}
+
+		if len(labels) > 0 {
+			err = ua.SetAppointmentLabels(r.Context(), createdAppointment.ID, labels)
+			if err != nil {
+				l.Errorw("failed to move labels to new appointment", "err", err)
+				return err
+			}
+		}
+
+		if len(newAppointment.CustomFields) > 0 {
+			err = ua.SetAppointmentCustomFields(r.Context(), createdAppointment.ID, newAppointment.CustomFields)
+			if err != nil {
+				l.Errorw("failed to set custom fields on new appointment", "err", err)
+				return err
+			}
+			createdAppointment.CustomFields = newAppointment.CustomFields
+		}
+
+		calendarEvents, err := ua.GetCalendarEvents(r.Context(), a.ID)
+		if err != nil {
+			l.Errorw("failed to get calendar events for appointment", "err", err)
+			return err
+		}
+
 		// If adding the new appointment succeeded, ditch the old one.
 		deleted, newSlot, err := ua.RemoveAppointmentSignup(r.Context(), a.ID)
 		if err != nil {
@@ -717,6 +5740,32 @@ func (s *Server) UpdateAppointment(ua updateAppointment) E {
 		}
 		l.Infow("removed appointment for update")
 
+		err = s.syncCalendarEvents(r.Context(), l, ua, q, calendarEvents, a, createdAppointment)
+		if err != nil {
+			return err
+		}
+
+		err = s.notifyAssignee(r.Context(), l, ua, q, day, createdAppointment, email,
+			fmt.Sprintf("%s moved their appointment to %s, which you're assigned to.",
+				name,
+				createdAppointment.ScheduledTime.In(time.Local).Format("Monday 3:04 PM"),
+			),
+		)
+		if err != nil {
+			return err
+		}
+
+		_, err = ua.RemoveFromWaitlist(r.Context(), q.ID, createdAppointment.ScheduledTime, email)
+		if err != nil {
+			l.Errorw("failed to remove student from waitlist", "err", err)
+			return err
+		}
+
+		err = s.notifyWaitlist(r.Context(), l, ua, q, a)
+		if err != nil {
+			return err
+		}
+
 		if deleted {
 			s.ps.Pub(WS("APPOINTMENT_REMOVE", a.Anonymized()), QueueTopicGeneric(q.ID))
 		} else {
@@ -729,16 +5778,32 @@ func (s *Server) UpdateAppointment(ua updateAppointment) E {
 		if !admin {
 			s.ps.Pub(WS("APPOINTMENT_UPDATE", createdAppointment.NoStaffEmail()), QueueTopicEmail(q.ID, email))
 		}
+		s.publishAppointmentEvent(r.Context(), l, AppointmentReschedule, q.ID, createdAppointment, a)
 
-		return s.sendResponse(http.StatusCreated, createdAppointment, w, r)
+		return s.sendAppointmentResponse(http.StatusCreated, createdAppointment, w, r)
 	}
 }
 
-func (s *Server) RemoveAppointmentSignup(rs removeAppointmentSignup) E {
+type cancelAppointment interface {
+	removeAppointmentSignup
+	syncCalendarEvents
+	waitlistNotifier
+}
+
+func (s *Server) RemoveAppointmentSignup(rs cancelAppointment) E {
 	return func(w http.ResponseWriter, r *http.Request) error {
-		q := r.Context().Value(queueContextKey).(*Queue)
-		a := r.Context().Value(appointmentContextKey).(*AppointmentSlot)
-		email := r.Context().Value(emailContextKey).(string)
+		q, ok := r.Context().Value(queueContextKey).(*Queue)
+		if !ok {
+			return s.missingContextValue(r, queueContextKey)
+		}
+		a, ok := r.Context().Value(appointmentContextKey).(*AppointmentSlot)
+		if !ok {
+			return s.missingContextValue(r, appointmentContextKey)
+		}
+		email, ok := r.Context().Value(emailContextKey).(string)
+		if !ok {
+			return s.missingContextValue(r, emailContextKey)
+		}
 		l := s.logger.With(
 			RequestIDContextKey, r.Context().Value(RequestIDContextKey),
 			"appointment_id", a.ID,
@@ -763,30 +5828,352 @@ func (s *Server) RemoveAppointmentSignup(rs removeAppointmentSignup) E {
 			}
 		}
 
-		// If an appointment happened, it happened. How did people do this in Spring D:
-		if time.Now().After(a.ScheduledTime) {
-			l.Warnw("user attempted to delete appointment in the past")
+		removed, err := s.cancelAppointment(r.Context(), l, rs, q, a)
+		if err != nil {
+			return err
+		}
+
+		if !removed {
+			// The appointment went away between us loading it and removing
+			// the signup, most likely from a retried request. Same deal as
+			// above: the end result is what the user asked for.
+			w.WriteHeader(http.StatusOK)
+			return nil
+		}
+
+		return s.sendResponse(http.StatusNoContent, nil, w, r)
+	}
+}
+
+// cancelAppointment takes the student out of a, along with everything
+// that hangs off of their signup, and lets everyone know. It returns false
+// if the appointment had already gone away by the time we got to it.
+func (s *Server) cancelAppointment(ctx context.Context, l *zap.SugaredLogger, rs cancelAppointment, q *Queue, a *AppointmentSlot) (bool, error) {
+	// If an appointment happened, it happened. How did people do this in Spring D:
+	if time.Now().After(a.ScheduledTime) {
+		l.Warnw("user attempted to delete appointment in the past")
+		return false, StatusError{
+			http.StatusBadRequest,
+			"You can't delete an appointment that already happened! Let's try not to cause a paradox here.",
+		}
+	}
+
+	calendarEvents, err := rs.GetCalendarEvents(ctx, a.ID)
+	if err != nil {
+		l.Errorw("failed to get calendar events for appointment", "err", err)
+		return false, err
+	}
+
+	deleted, newSlot, err := rs.RemoveAppointmentSignup(ctx, a.ID)
+	if errors.Is(err, sql.ErrNoRows) {
+		l.Warnw("appointment disappeared while removing signup")
+		return false, nil
+	}
+	if err != nil {
+		l.Errorw("failed to remove signup for appointment", "err", err)
+		return false, err
+	}
+
+	l.Infow("removed signup for appointment")
+
+	err = s.syncCalendarEvents(ctx, l, rs, q, calendarEvents, a, nil)
+	if err != nil {
+		return false, err
+	}
+
+	err = s.notifyWaitlist(ctx, l, rs, q, a)
+	if err != nil {
+		return false, err
+	}
+
+	// The meeting only goes once the cancellation commits, so one that
+	// gets rolled back still has somewhere to meet.
+	if a.MeetingLink != nil {
+		link := *a.MeetingLink
+		afterCommit(ctx, func() {
+			ctx, cancel := context.WithTimeout(context.Background(), meetingTimeout)
+			defer cancel()
+
+			err := s.meetings.DeleteMeeting(ctx, link)
+			if err != nil {
+				// The appointment's gone either way; a meeting left lying
+				// around isn't worth more than a log line.
+				l.Errorw("failed to delete meeting for appointment", "meeting_link", link, "err", err)
+			}
+		})
+	}
+
+	if deleted {
+		s.ps.Pub(WS("APPOINTMENT_REMOVE", a.Anonymized()), QueueTopicGeneric(q.ID))
+	} else {
+		s.ps.Pub(WS("APPOINTMENT_UPDATE", newSlot), QueueTopicAdmin(q.ID))
+		s.ps.Pub(WS("APPOINTMENT_REMOVE", a.Anonymized()), QueueTopicNonPrivileged(q.ID))
+	}
+	s.publishAppointmentEvent(ctx, l, AppointmentCancel, q.ID, a, nil)
+
+	return true, nil
+}
+
+const cancelTokenPurpose = "appointment_cancel"
+
+// CreateAppointmentCancelLink makes a link that cancels the appointment
+// without logging in, for putting in emails about it. Like share links,
+// it's bound to the student so that it can't cancel whoever takes the
+// slot next, and it stops working once the appointment starts, since
+// that's when it can't be canceled anymore.
+func (s *Server) CreateAppointmentCancelLink() E {
+	return func(w http.ResponseWriter, r *http.Request) error {
+		a, ok := r.Context().Value(appointmentContextKey).(*AppointmentSlot)
+		if !ok {
+			return s.missingContextValue(r, appointmentContextKey)
+		}
+		email, ok := r.Context().Value(emailContextKey).(string)
+		if !ok {
+			return s.missingContextValue(r, emailContextKey)
+		}
+		l := s.logger.With(
+			RequestIDContextKey, r.Context().Value(RequestIDContextKey),
+			"appointment_id", a.ID,
+			"email", email,
+		)
+
+		if a.StudentEmail == nil {
+			l.Warnw("attempted to create cancel link for deleted appointment")
+			return StatusError{
+				http.StatusNotFound,
+				"This appointment doesn't exist. Perhaps it was already deleted?",
+			}
+		}
+
+		if *a.StudentEmail != email {
+			l.Warnw("user attempted to create cancel link for appointment with other email",
+				"expected_email", *a.StudentEmail,
+			)
+			return StatusError{
+				http.StatusForbidden,
+				"You can't cancel someone else's appointment!",
+			}
+		}
+
+		expires := a.ScheduledTime
+		if time.Now().After(expires) {
+			l.Warnw("user attempted to create cancel link for appointment in the past")
 			return StatusError{
 				http.StatusBadRequest,
-				"You can't delete an appointment that already happened! Let's try not to cause a paradox here.",
+				"That appointment already happened, so it can't be canceled.",
 			}
 		}
 
-		deleted, newSlot, err := rs.RemoveAppointmentSignup(r.Context(), a.ID)
+		token := s.newSignedToken(cancelTokenPurpose, a.ID.String(), expires, email)
+
+		l.Infow("created appointment cancel link", "expires", expires)
+
+		return s.sendResponse(http.StatusCreated, struct {
+			Link    string    `json:"link"`
+			Expires time.Time `json:"expires"`
+		}{s.baseURL + "api/appointments/cancel/" + token, expires.In(time.Local)}, w, r)
+	}
+}
+
+type cancelAppointmentByToken interface {
+	getQueue
+	getAppointment
+	cancelAppointment
+}
+
+// CancelAppointmentByToken cancels an appointment from a link made by
+// CreateAppointmentCancelLink. It's a POST rather than a GET so that
+// mail scanners following links don't cancel appointments on their own.
+func (s *Server) CancelAppointmentByToken(cs cancelAppointmentByToken) E {
+	return func(w http.ResponseWriter, r *http.Request) error {
+		l := s.logger.With(RequestIDContextKey, r.Context().Value(RequestIDContextKey))
+		notFound := StatusError{
+			http.StatusNotFound,
+			"That link doesn't seem to point to an appointment. Make sure you copied the whole thing!",
+		}
+
+		token, err := parseSignedToken(chi.URLParam(r, "token"))
+		if err != nil {
+			l.Warnw("failed to parse cancel token", "err", err)
+			return notFound
+		}
+
+		id, err := ksuid.Parse(token.Subject)
 		if err != nil {
-			l.Errorw("failed to remove signup for appointment", "err", err)
+			l.Warnw("failed to parse appointment ID in cancel token", "appointment_id", token.Subject, "err", err)
+			return notFound
+		}
+		l = l.With("appointment_id", id)
+
+		// The token is bound to the student it was made for, so it can
+		// only be checked against the appointment it names. Until it
+		// checks out, every failure (a forged token, or one whose
+		// appointment is gone or has no student left in it) gets the same
+		// 404, so the response says nothing about the appointment.
+		a, err := cs.GetAppointment(r.Context(), id)
+		if errors.Is(err, sql.ErrNoRows) {
+			l.Warnw("cancel token for non-existent appointment")
+			return notFound
+		} else if err != nil {
+			l.Errorw("failed to get appointment", "err", err)
 			return err
 		}
 
-		l.Infow("removed signup for appointment")
+		if a.StudentEmail == nil {
+			l.Warnw("cancel token for appointment without student")
+			return notFound
+		}
+		student := *a.StudentEmail
 
-		if deleted {
-			s.ps.Pub(WS("APPOINTMENT_REMOVE", a.Anonymized()), QueueTopicGeneric(q.ID))
-		} else {
-			s.ps.Pub(WS("APPOINTMENT_UPDATE", newSlot), QueueTopicAdmin(q.ID))
-			s.ps.Pub(WS("APPOINTMENT_REMOVE", a.Anonymized()), QueueTopicNonPrivileged(q.ID))
+		err = s.verifySignedToken(token, cancelTokenPurpose, student)
+		if errors.Is(err, errTokenExpired) {
+			l.Infow("got expired cancel token")
+			return StatusError{
+				http.StatusGone,
+				"That link has expired, since the appointment already started.",
+			}
+		} else if err != nil {
+			l.Warnw("got cancel token with invalid signature", "err", err)
+			return notFound
+		}
+		l = l.With("email", student)
+
+		q, err := cs.GetQueue(r.Context(), a.Queue)
+		if err != nil {
+			l.Errorw("failed to get queue", "queue_id", a.Queue, "err", err)
+			return err
+		}
+
+		removed, err := s.cancelAppointment(r.Context(), l, cs, q, a)
+		if err != nil {
+			return err
+		}
+
+		if !removed {
+			w.WriteHeader(http.StatusOK)
+			return nil
 		}
 
 		return s.sendResponse(http.StatusNoContent, nil, w, r)
 	}
 }
+
+const shareTokenPurpose = "appointment_share"
+
+func (s *Server) CreateAppointmentShareLink() E {
+	return func(w http.ResponseWriter, r *http.Request) error {
+		a, ok := r.Context().Value(appointmentContextKey).(*AppointmentSlot)
+		if !ok {
+			return s.missingContextValue(r, appointmentContextKey)
+		}
+		email, ok := r.Context().Value(emailContextKey).(string)
+		if !ok {
+			return s.missingContextValue(r, emailContextKey)
+		}
+		l := s.logger.With(
+			RequestIDContextKey, r.Context().Value(RequestIDContextKey),
+			"appointment_id", a.ID,
+			"email", email,
+		)
+
+		if a.StudentEmail == nil {
+			l.Warnw("attempted to share deleted appointment")
+			return StatusError{
+				http.StatusNotFound,
+				"This appointment doesn't exist. Perhaps it was already deleted?",
+			}
+		}
+
+		if *a.StudentEmail != email {
+			l.Warnw("user attempted to share appointment with other email",
+				"expected_email", *a.StudentEmail,
+			)
+			return StatusError{
+				http.StatusForbidden,
+				"You can't share someone else's appointment!",
+			}
+		}
+
+		// Links stop working once the appointment is over; there's
+		// nothing left to coordinate at that point.
+		expires := a.ScheduledTime.Add(time.Duration(a.Duration) * time.Minute)
+		if time.Now().After(expires) {
+			l.Warnw("user attempted to share appointment in the past")
+			return StatusError{
+				http.StatusBadRequest,
+				"That appointment already happened, so there's nothing to share!",
+			}
+		}
+
+		// The token is bound to the student's email so that it stops
+		// working if they cancel and someone else ends up in the same slot.
+		token := s.newSignedToken(shareTokenPurpose, a.ID.String(), expires, email)
+
+		l.Infow("created appointment share link", "expires", expires)
+
+		return s.sendResponse(http.StatusCreated, struct {
+			Link    string    `json:"link"`
+			Expires time.Time `json:"expires"`
+		}{s.baseURL + "api/appointments/shared/" + token, expires.In(time.Local)}, w, r)
+	}
+}
+
+func (s *Server) GetSharedAppointment(ga getAppointment) E {
+	return func(w http.ResponseWriter, r *http.Request) error {
+		l := s.logger.With(RequestIDContextKey, r.Context().Value(RequestIDContextKey))
+		notFound := StatusError{
+			http.StatusNotFound,
+			"That link doesn't seem to point to an appointment. Make sure you copied the whole thing!",
+		}
+
+		token, err := parseSignedToken(chi.URLParam(r, "token"))
+		if err != nil {
+			l.Warnw("failed to parse share token", "err", err)
+			return notFound
+		}
+
+		id, err := ksuid.Parse(token.Subject)
+		if err != nil {
+			l.Warnw("failed to parse appointment ID in share token", "appointment_id", token.Subject, "err", err)
+			return notFound
+		}
+		l = l.With("appointment_id", id)
+
+		a, err := ga.GetAppointment(r.Context(), id)
+		if errors.Is(err, sql.ErrNoRows) {
+			l.Warnw("share token for non-existent appointment")
+			return notFound
+		} else if err != nil {
+			l.Errorw("failed to get appointment", "err", err)
+			return err
+		}
+
+		var student string
+		if a.StudentEmail != nil {
+			student = *a.StudentEmail
+		}
+
+		err = s.verifySignedToken(token, shareTokenPurpose, student)
+		if errors.Is(err, errTokenExpired) {
+			l.Infow("got expired share token")
+			return StatusError{
+				http.StatusGone,
+				"That link has expired.",
+			}
+		} else if err != nil {
+			l.Warnw("got share token with invalid signature", "err", err)
+			return notFound
+		}
+
+		shared := SharedAppointment{
+			Queue:         a.Queue,
+			ScheduledTime: a.ScheduledTime.In(time.Local),
+			Duration:      a.Duration,
+		}
+		if a.Location != nil {
+			shared.Location = *a.Location
+		}
+
+		return s.sendResponse(http.StatusOK, shared, w, r)
+	}
+}
@@ -0,0 +1,4612 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/segmentio/ksuid"
+)
+
+// rescheduleRequest runs UpdateAppointment for the appointment's student,
+// moving it to timeslot.
+func rescheduleRequest(t *testing.T, s *Server, store *fakeStore, q *Queue, a *AppointmentSlot, timeslot int) *httptest.ResponseRecorder {
+	t.Helper()
+	body, err := json.Marshal(map[string]interface{}{
+		"timeslot":    timeslot,
+		"location":    *a.Location,
+		"description": *a.Description,
+	})
+	if err != nil {
+		t.Fatalf("failed to encode reschedule: %v", err)
+	}
+
+	r, _ := newTestRequest(http.MethodPut, "/", strings.NewReader(string(body)), map[string]interface{}{
+		queueContextKey:       q,
+		appointmentContextKey: a,
+		emailContextKey:       *a.StudentEmail,
+		nameContextKey:        *a.Name,
+		courseAdminContextKey: false,
+	})
+	w := httptest.NewRecorder()
+	s.UpdateAppointment(store).ServeHTTP(w, r)
+	return w
+}
+
+func TestRescheduleSameDayBlocked(t *testing.T) {
+	s := newTestServer()
+	store := newFakeStore()
+	q := store.addQueue(&QueueConfiguration{AppointmentSettings: AppointmentSettings{DisallowSameDayReschedule: true}})
+
+	today := int(time.Now().Local().Weekday())
+	a := store.book(q, today, 23, "student@example.com")
+
+	w := rescheduleRequest(t, s, store, q, a, 22)
+	if w.Code != http.StatusForbidden {
+		t.Errorf("got status %d, want %d", w.Code, http.StatusForbidden)
+	}
+	if len(store.appointments) != 1 || store.appointments[0].ID != a.ID {
+		t.Error("appointment changed after blocked reschedule")
+	}
+}
+
+func TestRescheduleFutureDay(t *testing.T) {
+	s := newTestServer()
+	store := newFakeStore()
+	q := store.addQueue(&QueueConfiguration{AppointmentSettings: AppointmentSettings{DisallowSameDayReschedule: true}})
+
+	// Today is all breaks, so rescheduling against today's schedule
+	// instead of the appointment's would be refused.
+	tomorrow := int(time.Now().Local().Add(24 * time.Hour).Weekday())
+	today := int(time.Now().Local().Weekday())
+	store.schedules[q.ID][today].Schedule = strings.Repeat("0", 24)
+	a := store.book(q, tomorrow, 10, "student@example.com")
+
+	w := rescheduleRequest(t, s, store, q, a, 20)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("got status %d, want %d: %s", w.Code, http.StatusCreated, w.Body.String())
+	}
+
+	var moved AppointmentSlot
+	err := json.NewDecoder(w.Body).Decode(&moved)
+	if err != nil {
+		t.Fatalf("failed to decode response: %v", err)
+	}
+
+	start, _ := WeekdayBounds(tomorrow)
+	want := SlotStart(start, 20, store.schedules[q.ID][tomorrow])
+	if !moved.ScheduledTime.Equal(want) {
+		t.Errorf("got scheduled time %v, want %v", moved.ScheduledTime, want)
+	}
+	if len(store.appointments) != 1 || store.appointments[0].ID != moved.ID {
+		t.Errorf("got %d stored appointments, want just the moved one", len(store.appointments))
+	}
+}
+
+func TestSlotStartAfterDurationChange(t *testing.T) {
+	s := newTestServer()
+	store := newFakeStore()
+	q := store.addQueue(&QueueConfiguration{})
+
+	// Booked when timeslots were an hour long, which they aren't anymore.
+	tomorrow := int(time.Now().Local().Add(24 * time.Hour).Weekday())
+	a := store.book(q, tomorrow, 10, "student@example.com")
+	schedule := store.schedules[q.ID][tomorrow]
+	schedule.Duration = 30
+	schedule.Schedule = strings.Repeat("2", 48)
+	start, _ := WeekdayBounds(tomorrow)
+
+	// Sign-ups and reschedules both go by the schedule's duration, not
+	// the appointment's.
+	w := signupRequest(s, store, q, tomorrow, "other@example.com", 21, 1)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("got status %d signing up, want %d: %s", w.Code, http.StatusCreated, w.Body.String())
+	}
+	var signedUp AppointmentSlot
+	err := json.NewDecoder(w.Body).Decode(&signedUp)
+	if err != nil {
+		t.Fatalf("failed to decode response: %v", err)
+	}
+
+	w = rescheduleRequest(t, s, store, q, a, 21)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("got status %d rescheduling, want %d: %s", w.Code, http.StatusCreated, w.Body.String())
+	}
+	var moved AppointmentSlot
+	err = json.NewDecoder(w.Body).Decode(&moved)
+	if err != nil {
+		t.Fatalf("failed to decode response: %v", err)
+	}
+
+	want := SlotStart(start, 21, schedule)
+	if want.Hour() != 10 || want.Minute() != 30 {
+		t.Fatalf("got timeslot 21 starting at %s, want 10:30", want.Format("15:04"))
+	}
+	if !signedUp.ScheduledTime.Equal(want) {
+		t.Errorf("got signed up time %v, want %v", signedUp.ScheduledTime, want)
+	}
+	if !moved.ScheduledTime.Equal(want) {
+		t.Errorf("got rescheduled time %v, want %v", moved.ScheduledTime, want)
+	}
+	if moved.Duration != 30 {
+		t.Errorf("got rescheduled duration %d, want 30", moved.Duration)
+	}
+}
+
+func TestRescheduleFailureCodes(t *testing.T) {
+	if time.Now().Hour() == 23 {
+		t.Skip("today's last timeslot has already started")
+	}
+
+	today := int(time.Now().Local().Weekday())
+	tomorrow := int(time.Now().Local().Add(24 * time.Hour).Weekday())
+	tests := []struct {
+		name     string
+		day      int
+		timeslot int
+		status   int
+		code     string
+	}{
+		{"missing", tomorrow, 24, http.StatusNotFound, rescheduleSlotMissing},
+		{"negative", tomorrow, -1, http.StatusNotFound, rescheduleSlotMissing},
+		{"past", today, 0, http.StatusBadRequest, rescheduleTimePast},
+		{"break", tomorrow, 13, http.StatusConflict, timeslotBreak},
+		{"full", tomorrow, 12, http.StatusConflict, rescheduleSlotFull},
+	}
+	for _, test := range tests {
+		s := newTestServer()
+		store := newFakeStore()
+		q := store.addQueue(&QueueConfiguration{})
+		store.schedules[q.ID][tomorrow].Schedule = strings.Repeat("1", 13) + "0" + strings.Repeat("1", 10)
+		store.book(q, tomorrow, 12, "other@example.com")
+		a := store.book(q, test.day, 23, "student@example.com")
+		before := a.ScheduledTime
+
+		w := rescheduleRequest(t, s, store, q, a, test.timeslot)
+		if w.Code != test.status {
+			t.Errorf("%s: got status %d, want %d: %s", test.name, w.Code, test.status, w.Body.String())
+			continue
+		}
+
+		var body struct {
+			Code    string           `json:"code"`
+			Details rescheduleTarget `json:"details"`
+		}
+		err := json.NewDecoder(w.Body).Decode(&body)
+		if err != nil {
+			t.Fatalf("%s: failed to decode error response: %v", test.name, err)
+		}
+		if body.Code != test.code {
+			t.Errorf("%s: got code %q, want %q", test.name, body.Code, test.code)
+		}
+		if body.Details.Timeslot != test.timeslot {
+			t.Errorf("%s: got target timeslot %d, want %d", test.name, body.Details.Timeslot, test.timeslot)
+		}
+		if (body.Details.ScheduledTime == nil) != (test.code == rescheduleSlotMissing) {
+			t.Errorf("%s: got target time %v", test.name, body.Details.ScheduledTime)
+		}
+
+		moved := store.appointment(a.ID)
+		if moved == nil || !moved.ScheduledTime.Equal(before) {
+			t.Errorf("%s: appointment changed after failed reschedule", test.name)
+		}
+	}
+}
+
+// flexibleSignupRequest runs a flexible signup through the middleware
+// and handler, like the router would.
+func flexibleSignupRequest(s *Server, sa signupForAppointment, q *Queue, day int, email string, timeslots []int) *httptest.ResponseRecorder {
+	encoded, _ := json.Marshal(map[string]interface{}{
+		"timeslots":   timeslots,
+		"location":    "Here",
+		"description": "Help",
+	})
+	r, _ := newTestRequest(http.MethodPost, "/", strings.NewReader(string(encoded)), map[string]interface{}{
+		queueContextKey:          q,
+		appointmentDayContextKey: day,
+		emailContextKey:          email,
+		nameContextKey:           "Student",
+		courseAdminContextKey:    false,
+	})
+	w := httptest.NewRecorder()
+	s.FlexibleTimeslotMiddleware(s.SignupForAppointment(sa)).ServeHTTP(w, r)
+	return w
+}
+
+func TestFlexibleSignupSkipsFullTimeslots(t *testing.T) {
+	s := newTestServer()
+	store := newFakeStore()
+	q := store.addQueue(&QueueConfiguration{})
+
+	tomorrow := int(time.Now().Local().Add(24 * time.Hour).Weekday())
+	store.book(q, tomorrow, 10, "first@example.com")
+	store.book(q, tomorrow, 11, "second@example.com")
+
+	w := flexibleSignupRequest(s, store, q, tomorrow, "student@example.com", []int{10, 11, 12, 13})
+	if w.Code != http.StatusCreated {
+		t.Fatalf("got status %d, want %d: %s", w.Code, http.StatusCreated, w.Body.String())
+	}
+
+	var a AppointmentSlot
+	err := json.NewDecoder(w.Body).Decode(&a)
+	if err != nil {
+		t.Fatalf("failed to decode response: %v", err)
+	}
+	if a.Timeslot != 12 {
+		t.Errorf("got timeslot %d, want 12", a.Timeslot)
+	}
+}
+
+func TestFlexibleSignupAllFull(t *testing.T) {
+	s := newTestServer()
+	store := newFakeStore()
+	q := store.addQueue(&QueueConfiguration{})
+
+	tomorrow := int(time.Now().Local().Add(24 * time.Hour).Weekday())
+	store.book(q, tomorrow, 10, "first@example.com")
+	store.book(q, tomorrow, 11, "second@example.com")
+
+	w := flexibleSignupRequest(s, store, q, tomorrow, "student@example.com", []int{10, 11})
+	if w.Code != http.StatusConflict {
+		t.Errorf("got status %d, want %d", w.Code, http.StatusConflict)
+	}
+	if len(store.appointments) != 2 {
+		t.Errorf("got %d stored appointments, want 2", len(store.appointments))
+	}
+}
+
+// racingStore fills a timeslot the first time its capacity is checked,
+// like another student signing up between picking and booking.
+type racingStore struct {
+	*fakeStore
+	q        *Queue
+	day      int
+	timeslot int
+	raced    bool
+}
+
+func (r *racingStore) GetAppointmentsByTimeslot(ctx context.Context, queue ksuid.KSUID, from, to time.Time, timeslot int) ([]*AppointmentSlot, error) {
+	if timeslot == r.timeslot && !r.raced {
+		r.raced = true
+		r.book(r.q, r.day, timeslot, "racer@example.com")
+	}
+	return r.fakeStore.GetAppointmentsByTimeslot(ctx, queue, from, to, timeslot)
+}
+
+func TestFlexibleSignupTimeslotFillsWhilePicking(t *testing.T) {
+	s := newTestServer()
+	store := newFakeStore()
+	q := store.addQueue(&QueueConfiguration{})
+
+	tomorrow := int(time.Now().Local().Add(24 * time.Hour).Weekday())
+	racing := &racingStore{fakeStore: store, q: q, day: tomorrow, timeslot: 10}
+
+	w := flexibleSignupRequest(s, racing, q, tomorrow, "student@example.com", []int{10, 11})
+	if w.Code != http.StatusCreated {
+		t.Fatalf("got status %d, want %d: %s", w.Code, http.StatusCreated, w.Body.String())
+	}
+
+	var a AppointmentSlot
+	err := json.NewDecoder(w.Body).Decode(&a)
+	if err != nil {
+		t.Fatalf("failed to decode response: %v", err)
+	}
+	if a.Timeslot != 11 {
+		t.Errorf("got timeslot %d, want 11", a.Timeslot)
+	}
+}
+
+func cloneRequest(s *Server, store *fakeStore, q, source *Queue) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	s.CloneAppointmentSchedules(store).ServeHTTP(w, adminRequest(http.MethodPost, map[string]interface{}{"source": source.ID}, q, "admin@example.com"))
+	return w
+}
+
+func TestCloneSchedule(t *testing.T) {
+	s := newTestServer()
+	store := newFakeStore()
+	store.admins["admin@example.com"] = true
+	source := store.addQueue(&QueueConfiguration{})
+	for day := 0; day < 7; day++ {
+		store.schedules[source.ID][day].Schedule = strings.Repeat(strconv.Itoa(day), 24)
+		store.schedules[source.ID][day].Duration = 15
+	}
+	q := store.addQueue(&QueueConfiguration{})
+
+	// Appointments that have already happened don't hold it up.
+	yesterday := store.book(q, 0, 10, "student@example.com")
+	yesterday.ScheduledTime = time.Now().Add(-24 * time.Hour)
+
+	w := cloneRequest(s, store, q, source)
+	if w.Code != http.StatusOK {
+		t.Fatalf("got status %d, want %d: %s", w.Code, http.StatusOK, w.Body.String())
+	}
+	for day := 0; day < 7; day++ {
+		got, want := store.schedules[q.ID][day], store.schedules[source.ID][day]
+		if got.Schedule != want.Schedule || got.Duration != want.Duration {
+			t.Errorf("day %d: got schedule %s every %d minutes, want %s every %d minutes", day, got.Schedule, got.Duration, want.Schedule, want.Duration)
+		}
+		if got.Queue != q.ID {
+			t.Errorf("day %d: got schedule for queue %s, want %s", day, got.Queue, q.ID)
+		}
+	}
+	if len(store.auditLog) != 7 {
+		t.Errorf("got %d audit entries, want one per day", len(store.auditLog))
+	}
+}
+
+func TestCloneScheduleBlocked(t *testing.T) {
+	s := newTestServer()
+	store := newFakeStore()
+	store.admins["admin@example.com"] = true
+	source := store.addQueue(&QueueConfiguration{})
+	store.schedules[source.ID][1].Schedule = strings.Repeat("2", 24)
+	q := store.addQueue(&QueueConfiguration{})
+	before := store.schedules[q.ID][1].Schedule
+
+	tomorrow := int(time.Now().Local().Add(24 * time.Hour).Weekday())
+	store.book(q, tomorrow, 10, "student@example.com")
+
+	w := cloneRequest(s, store, q, source)
+	if w.Code != http.StatusConflict {
+		t.Errorf("got status %d with upcoming appointments, want %d", w.Code, http.StatusConflict)
+	}
+	if store.schedules[q.ID][1].Schedule != before {
+		t.Error("schedule replaced despite upcoming appointments")
+	}
+
+	// It takes being an admin of both courses.
+	store.appointments = nil
+	store.admins["admin@example.com"] = false
+	w = cloneRequest(s, store, q, source)
+	if w.Code != http.StatusForbidden {
+		t.Errorf("got status %d without admin on source, want %d", w.Code, http.StatusForbidden)
+	}
+	if store.schedules[q.ID][1].Schedule != before {
+		t.Error("schedule replaced from a queue the admin doesn't run")
+	}
+}
+
+func TestCloneScheduleNeedsApproval(t *testing.T) {
+	s := newTestServer()
+	store := newFakeStore()
+	store.admins["admin@example.com"] = true
+	source := store.addQueue(&QueueConfiguration{})
+	store.schedules[source.ID][1].Schedule = strings.Repeat("2", 24)
+	q := store.addQueue(&QueueConfiguration{AppointmentSettings: AppointmentSettings{RequireScheduleApproval: true}})
+
+	w := httptest.NewRecorder()
+	s.CloneAppointmentSchedules(store).ServeHTTP(w, adminRequest(http.MethodPost, map[string]interface{}{"source": source.ID}, q, "admin@example.com"))
+	if w.Code != http.StatusAccepted {
+		t.Fatalf("got status %d, want %d: %s", w.Code, http.StatusAccepted, w.Body.String())
+	}
+
+	if store.schedules[q.ID][1].Schedule == store.schedules[source.ID][1].Schedule {
+		t.Error("cloned schedule applied without approval")
+	}
+	if len(store.auditLog) != 0 {
+		t.Errorf("got %d audit entries, want none", len(store.auditLog))
+	}
+	if len(store.pendingChanges[q.ID]) != 7 {
+		t.Fatalf("got %d pending changes, want one per day", len(store.pendingChanges[q.ID]))
+	}
+
+	pending := store.pendingChanges[q.ID][1]
+	if pending.Schedule != store.schedules[source.ID][1].Schedule {
+		t.Errorf("got pending schedule %s, want source's", pending.Schedule)
+	}
+	if pending.Queue != q.ID {
+		t.Errorf("got pending change for queue %s, want %s", pending.Queue, q.ID)
+	}
+	if pending.ProposedBy != "admin@example.com" {
+		t.Errorf("got proposer %s, want admin@example.com", pending.ProposedBy)
+	}
+}
+
+// signupRequest runs a signup for span timeslots starting at timeslot on
+// day, like the router would.
+func signupRequest(s *Server, sa signupForAppointment, q *Queue, day int, email string, timeslot, span int) *httptest.ResponseRecorder {
+	return signupBodyRequest(s, sa, q, day, email, timeslot, map[string]interface{}{
+		"slot_span":   span,
+		"location":    "Here",
+		"description": "Help",
+	})
+}
+
+// signupBodyRequest runs SignupForAppointment for email with body as the
+// requested appointment.
+func signupBodyRequest(s *Server, sa signupForAppointment, q *Queue, day int, email string, timeslot int, body map[string]interface{}) *httptest.ResponseRecorder {
+	encoded, _ := json.Marshal(body)
+	r, _ := newTestRequest(http.MethodPost, "/", strings.NewReader(string(encoded)), map[string]interface{}{
+		queueContextKey:               q,
+		appointmentDayContextKey:      day,
+		appointmentTimeslotContextKey: timeslot,
+		emailContextKey:               email,
+		nameContextKey:                "Student",
+		courseAdminContextKey:         false,
+	})
+	w := httptest.NewRecorder()
+	s.SignupForAppointment(sa).ServeHTTP(w, r)
+	return w
+}
+
+func TestGetTimeslotAssignments(t *testing.T) {
+	s := newTestServer()
+	store := newFakeStore()
+	q := store.addQueue(&QueueConfiguration{})
+	store.assignments[q.ID] = map[int]map[int]string{
+		2: {10: "ta@example.com", 11: "other@example.com"},
+	}
+
+	r, _ := newTestRequest(http.MethodGet, "/", nil, map[string]interface{}{
+		queueContextKey:          q,
+		appointmentDayContextKey: 2,
+	})
+	w := httptest.NewRecorder()
+	s.GetTimeslotAssignments(store).ServeHTTP(w, r)
+	if w.Code != http.StatusOK {
+		t.Fatalf("got status %d, want %d: %s", w.Code, http.StatusOK, w.Body.String())
+	}
+
+	var assignments map[int]string
+	err := json.NewDecoder(w.Body).Decode(&assignments)
+	if err != nil {
+		t.Fatalf("failed to decode response: %v", err)
+	}
+	if len(assignments) != 2 || assignments[10] != "ta@example.com" || assignments[11] != "other@example.com" {
+		t.Errorf("got assignments %v, want day 2's", assignments)
+	}
+}
+
+func TestSignupNotifiesAssigneeFromSystem(t *testing.T) {
+	s := newTestServer()
+	store := newFakeStore()
+	q := store.addQueue(&QueueConfiguration{})
+
+	tomorrow := int(time.Now().Local().Add(24 * time.Hour).Weekday())
+	store.assignments[q.ID] = map[int]map[int]string{
+		tomorrow: {10: "ta@example.com"},
+	}
+
+	w := signupRequest(s, store, q, tomorrow, "student@example.com", 10, 1)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("got status %d, want %d: %s", w.Code, http.StatusCreated, w.Body.String())
+	}
+
+	if len(store.messages) != 1 {
+		t.Fatalf("got %d messages, want 1", len(store.messages))
+	}
+	m := store.messages[0]
+	if m.Receiver != "ta@example.com" {
+		t.Errorf("got receiver %s, want ta@example.com", m.Receiver)
+	}
+	if m.Sender != systemSender {
+		t.Errorf("got sender %s, want %s", m.Sender, systemSender)
+	}
+}
+
+func TestRescheduleNotifiesAssignee(t *testing.T) {
+	s := newTestServer()
+	store := newFakeStore()
+	q := store.addQueue(&QueueConfiguration{})
+
+	tomorrow := int(time.Now().Local().Add(24 * time.Hour).Weekday())
+	store.assignments[q.ID] = map[int]map[int]string{
+		tomorrow: {12: "ta@example.com"},
+	}
+	a := store.book(q, tomorrow, 10, "student@example.com")
+	messages := s.ps.Sub(QueueTopicEmail(q.ID, "ta@example.com"))
+	defer s.ps.Unsub(messages)
+
+	// Moving somewhere nobody's assigned doesn't bother anyone.
+	w := rescheduleRequest(t, s, store, q, a, 11)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("got status %d, want %d: %s", w.Code, http.StatusCreated, w.Body.String())
+	}
+	if len(store.messages) != 0 {
+		t.Fatalf("got %d messages after moving to an unassigned timeslot, want 0", len(store.messages))
+	}
+
+	var moved AppointmentSlot
+	json.NewDecoder(w.Body).Decode(&moved)
+	for _, b := range store.appointments {
+		if b.ID == moved.ID {
+			a = b
+		}
+	}
+
+	body, _ := json.Marshal(map[string]interface{}{
+		"timeslot":    12,
+		"location":    *a.Location,
+		"description": *a.Description,
+	})
+	var hooks []func()
+	r, _ := newTestRequest(http.MethodPut, "/", strings.NewReader(string(body)), map[string]interface{}{
+		queueContextKey:       q,
+		appointmentContextKey: a,
+		emailContextKey:       *a.StudentEmail,
+		nameContextKey:        *a.Name,
+		courseAdminContextKey: false,
+		afterCommitContextKey: &hooks,
+	})
+	w = httptest.NewRecorder()
+	s.UpdateAppointment(store).ServeHTTP(w, r)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("got status %d, want %d: %s", w.Code, http.StatusCreated, w.Body.String())
+	}
+
+	if len(store.messages) != 1 {
+		t.Fatalf("got %d messages, want 1", len(store.messages))
+	}
+	m := store.messages[0]
+	if m.Receiver != "ta@example.com" || m.Sender != systemSender {
+		t.Errorf("got message from %s to %s, want from %s to ta@example.com", m.Sender, m.Receiver, systemSender)
+	}
+	if !strings.Contains(m.Content, "moved their appointment") {
+		t.Errorf("got message %q, want one about the move", m.Content)
+	}
+
+	// Staff only hear about it once the move commits.
+	select {
+	case <-messages:
+		t.Fatal("got a message before the move committed")
+	case <-time.After(10 * time.Millisecond):
+	}
+	for _, f := range hooks {
+		f()
+	}
+	select {
+	case got := <-messages:
+		if ws, ok := got.(*WSMessage); !ok || ws.Event != "MESSAGE_CREATE" {
+			t.Errorf("got %+v, want a MESSAGE_CREATE", got)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("got no message after the move committed")
+	}
+}
+
+func TestSpanSignup(t *testing.T) {
+	s := newTestServer()
+	store := newFakeStore()
+	q := store.addQueue(&QueueConfiguration{})
+
+	tomorrow := int(time.Now().Local().Add(24 * time.Hour).Weekday())
+	schedule := store.schedules[q.ID][tomorrow]
+	w := signupRequest(s, store, q, tomorrow, "student@example.com", 10, 2)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("got status %d, want %d: %s", w.Code, http.StatusCreated, w.Body.String())
+	}
+	if len(store.appointments) != 1 {
+		t.Fatalf("got %d stored appointments, want 1", len(store.appointments))
+	}
+	a := store.appointments[0]
+	if a.SlotSpan != 2 || a.Duration != 2*schedule.Duration {
+		t.Errorf("got span %d and duration %d, want 2 and %d", a.SlotSpan, a.Duration, 2*schedule.Duration)
+	}
+
+	// Both timeslots are taken up.
+	for _, timeslot := range []int{10, 11} {
+		w = signupRequest(s, store, q, tomorrow, strconv.Itoa(timeslot)+"@example.com", timeslot, 1)
+		if w.Code != http.StatusConflict {
+			t.Errorf("got status %d signing up at %d, want %d", w.Code, timeslot, http.StatusConflict)
+		}
+	}
+}
+
+func TestSpanSignupLaterSlotFull(t *testing.T) {
+	s := newTestServer()
+	store := newFakeStore()
+	q := store.addQueue(&QueueConfiguration{})
+
+	tomorrow := int(time.Now().Local().Add(24 * time.Hour).Weekday())
+	store.book(q, tomorrow, 11, "first@example.com")
+
+	w := signupRequest(s, store, q, tomorrow, "student@example.com", 10, 2)
+	if w.Code != http.StatusConflict {
+		t.Errorf("got status %d, want %d", w.Code, http.StatusConflict)
+	}
+	if len(store.appointments) != 1 {
+		t.Errorf("got %d stored appointments, want 1", len(store.appointments))
+	}
+}
+
+func TestSignupInsideExistingSpan(t *testing.T) {
+	s := newTestServer()
+	store := newFakeStore()
+	q := store.addQueue(&QueueConfiguration{})
+
+	// Booked at 9 but running through 10, so 10 is full even though
+	// nothing starts there.
+	tomorrow := int(time.Now().Local().Add(24 * time.Hour).Weekday())
+	store.book(q, tomorrow, 9, "first@example.com").SlotSpan = 2
+
+	w := signupRequest(s, store, q, tomorrow, "student@example.com", 10, 1)
+	if w.Code != http.StatusConflict {
+		t.Errorf("got status %d, want %d", w.Code, http.StatusConflict)
+	}
+
+	w = signupRequest(s, store, q, tomorrow, "student@example.com", 11, 1)
+	if w.Code != http.StatusCreated {
+		t.Errorf("got status %d, want %d: %s", w.Code, http.StatusCreated, w.Body.String())
+	}
+}
+
+// appointmentIDRequest runs a request for appointment id in q through
+// AppointmentIDMiddleware, reporting whether it reached next.
+func appointmentIDRequest(s *Server, store *fakeStore, q *Queue, id string) (*httptest.ResponseRecorder, bool) {
+	r, _ := newTestRequest(http.MethodPost, "/", nil, map[string]interface{}{
+		queueContextKey: q,
+	})
+	r = withURLParam(r, "appointment_id", id)
+	w := httptest.NewRecorder()
+	called := false
+	s.AppointmentIDMiddleware(store)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		called = true
+	})).ServeHTTP(w, r)
+	return w, called
+}
+
+func TestAppointmentFromOtherQueueNotFound(t *testing.T) {
+	s := newTestServer()
+	store := newFakeStore()
+	q := store.addQueue(&QueueConfiguration{})
+	other := store.addQueue(&QueueConfiguration{})
+
+	tomorrow := int(time.Now().Local().Add(24 * time.Hour).Weekday())
+	a := store.book(other, tomorrow, 10, "student@example.com")
+
+	w, called := appointmentIDRequest(s, store, q, a.ID.String())
+	if called {
+		t.Error("next handler ran for appointment from other queue")
+	}
+	if w.Code != http.StatusNotFound {
+		t.Errorf("got status %d, want %d", w.Code, http.StatusNotFound)
+	}
+
+	var body ErrorMessage
+	err := json.NewDecoder(w.Body).Decode(&body)
+	if err != nil {
+		t.Fatalf("failed to decode error response: %v", err)
+	}
+	if body.Code != appointmentNotFound {
+		t.Errorf("got code %s, want %s", body.Code, appointmentNotFound)
+	}
+
+	_, called = appointmentIDRequest(s, store, other, a.ID.String())
+	if !called {
+		t.Error("next handler didn't run for appointment from its own queue")
+	}
+}
+
+func TestAppointmentNotFoundCode(t *testing.T) {
+	s := newTestServer()
+	store := newFakeStore()
+	q := store.addQueue(&QueueConfiguration{})
+
+	ids := map[string]string{
+		"bad ksuid":           "not-a-ksuid",
+		"missing appointment": ksuid.New().String(),
+	}
+	for name, id := range ids {
+		w, called := appointmentIDRequest(s, store, q, id)
+		if called {
+			t.Errorf("%s: next handler ran", name)
+		}
+		if w.Code != http.StatusNotFound {
+			t.Errorf("%s: got status %d, want %d", name, w.Code, http.StatusNotFound)
+		}
+
+		var body ErrorMessage
+		err := json.NewDecoder(w.Body).Decode(&body)
+		if err != nil {
+			t.Fatalf("%s: failed to decode error response: %v", name, err)
+		}
+		if body.Code != appointmentNotFound {
+			t.Errorf("%s: got code %s, want %s", name, body.Code, appointmentNotFound)
+		}
+		if body.Message == "" {
+			t.Errorf("%s: got empty error message", name)
+		}
+	}
+}
+
+// transferRequest has an admin of both queues move student's appointments
+// from q to target.
+func transferRequest(s *Server, store *fakeStore, q, target *Queue, student string) *httptest.ResponseRecorder {
+	store.admins["admin@example.com"] = true
+	w := httptest.NewRecorder()
+	s.TransferStudentAppointments(store).ServeHTTP(w, adminRequest(http.MethodPost, map[string]interface{}{
+		"target":        target.ID,
+		"student_email": student,
+	}, q, "admin@example.com"))
+	return w
+}
+
+// checkTransferConflict checks that w is a transfer conflict.
+func checkTransferConflict(t *testing.T, w *httptest.ResponseRecorder) {
+	t.Helper()
+	if w.Code != http.StatusConflict {
+		t.Fatalf("got status %d, want %d: %s", w.Code, http.StatusConflict, w.Body.String())
+	}
+	var body ErrorMessage
+	err := json.NewDecoder(w.Body).Decode(&body)
+	if err != nil {
+		t.Fatalf("failed to decode error response: %v", err)
+	}
+	if body.Code != transferConflict {
+		t.Errorf("got code %s, want %s", body.Code, transferConflict)
+	}
+}
+
+func TestTransferStudentAppointments(t *testing.T) {
+	s := newTestServer()
+	store := newFakeStore()
+	q := store.addQueue(&QueueConfiguration{AppointmentSettings: AppointmentSettings{FutureAppointmentScope: FutureAppointmentScopeDay}})
+	target := store.addQueue(&QueueConfiguration{AppointmentSettings: AppointmentSettings{FutureAppointmentScope: FutureAppointmentScopeDay}})
+	tomorrow := int(time.Now().Local().Add(24 * time.Hour).Weekday())
+	later := int(time.Now().Local().Add(48 * time.Hour).Weekday())
+
+	first := store.book(q, tomorrow, 10, "student@example.com")
+	store.partners[first.ID] = []string{"partner@example.com"}
+	store.labels[first.ID] = []string{"urgent"}
+	second := store.book(q, later, 14, "student@example.com")
+	other := store.book(q, tomorrow, 11, "other@example.com")
+
+	w := transferRequest(s, store, q, target, "student@example.com")
+	if w.Code != http.StatusOK {
+		t.Fatalf("got status %d, want %d: %s", w.Code, http.StatusOK, w.Body.String())
+	}
+	var moved []*AppointmentSlot
+	err := json.NewDecoder(w.Body).Decode(&moved)
+	if err != nil {
+		t.Fatalf("failed to decode response: %v", err)
+	}
+	if len(moved) != 2 {
+		t.Fatalf("got %d moved appointments, want 2", len(moved))
+	}
+
+	// Each lands at the same time in the target queue, bringing its
+	// partners and labels along.
+	for i, a := range []*AppointmentSlot{first, second} {
+		m := moved[i]
+		if m.Queue != target.ID || m.Timeslot != a.Timeslot || !m.ScheduledTime.Equal(a.ScheduledTime) {
+			t.Errorf("got appointment moved to queue %s at %d (%s), want %s at %d (%s)",
+				m.Queue, m.Timeslot, m.ScheduledTime, target.ID, a.Timeslot, a.ScheduledTime)
+		}
+		if store.appointment(a.ID) != nil {
+			t.Errorf("appointment %s still in the old queue", a.ID)
+		}
+	}
+	if !reflect.DeepEqual(store.partners[moved[0].ID], []string{"partner@example.com"}) {
+		t.Errorf("got partners %v, want partner@example.com", store.partners[moved[0].ID])
+	}
+	if !reflect.DeepEqual(store.labels[moved[0].ID], []string{"urgent"}) {
+		t.Errorf("got labels %v, want urgent", store.labels[moved[0].ID])
+	}
+
+	// Other students stay put.
+	if stayed := store.appointment(other.ID); stayed == nil || stayed.Queue != q.ID {
+		t.Error("other student's appointment moved")
+	}
+}
+
+func TestTransferConflictRollsBack(t *testing.T) {
+	s := newTestServer()
+	store := newFakeStore()
+	q := store.addQueue(&QueueConfiguration{AppointmentSettings: AppointmentSettings{FutureAppointmentScope: FutureAppointmentScopeDay}})
+	target := store.addQueue(&QueueConfiguration{AppointmentSettings: AppointmentSettings{FutureAppointmentScope: FutureAppointmentScopeDay}})
+	store.admins["admin@example.com"] = true
+	tomorrow := int(time.Now().Local().Add(24 * time.Hour).Weekday())
+	later := int(time.Now().Local().Add(48 * time.Hour).Weekday())
+
+	// Tomorrow's fits, but later's timeslot is already full in the target.
+	store.book(q, tomorrow, 10, "student@example.com")
+	store.book(q, later, 10, "student@example.com")
+	store.book(target, later, 10, "other@example.com")
+
+	encoded, _ := json.Marshal(map[string]interface{}{
+		"target":        target.ID,
+		"student_email": "student@example.com",
+	})
+	r, reqErr := newTestRequest(http.MethodPost, "/", strings.NewReader(string(encoded)), map[string]interface{}{
+		queueContextKey:       q,
+		emailContextKey:       "admin@example.com",
+		courseAdminContextKey: true,
+	})
+	w := httptest.NewRecorder()
+	s.TransferStudentAppointments(store).ServeHTTP(w, r)
+	checkTransferConflict(t, w)
+
+	// The request failing is what has the transaction middleware roll
+	// back tomorrow's move, which already went through by then.
+	if *reqErr == nil {
+		t.Error("got no request error, so the transaction would commit")
+	}
+}
+
+func TestTransferStudentNotInTargetRoster(t *testing.T) {
+	s := newTestServer()
+	store := newFakeStore()
+	q := store.addQueue(&QueueConfiguration{})
+	target := store.addQueue(&QueueConfiguration{PreventUnregistered: true})
+	store.rosters[target.ID] = map[string]bool{"other@example.com": true}
+
+	tomorrow := int(time.Now().Local().Add(24 * time.Hour).Weekday())
+	a := store.book(q, tomorrow, 10, "student@example.com")
+
+	w := transferRequest(s, store, q, target, "student@example.com")
+	if w.Code != http.StatusForbidden {
+		t.Errorf("got status %d, want %d: %s", w.Code, http.StatusForbidden, w.Body.String())
+	}
+	if len(store.appointments) != 1 || store.appointments[0].ID != a.ID {
+		t.Error("appointment moved to queue the student isn't in the roster for")
+	}
+}
+
+func TestTransferStudentHasTargetAppointment(t *testing.T) {
+	s := newTestServer()
+	store := newFakeStore()
+	q := store.addQueue(&QueueConfiguration{})
+	target := store.addQueue(&QueueConfiguration{})
+
+	tomorrow := int(time.Now().Local().Add(24 * time.Hour).Weekday())
+	store.book(q, tomorrow, 10, "student@example.com")
+	store.book(target, tomorrow, 15, "student@example.com")
+
+	w := transferRequest(s, store, q, target, "student@example.com")
+	checkTransferConflict(t, w)
+	if len(store.appointments) != 2 {
+		t.Errorf("got %d stored appointments, want 2", len(store.appointments))
+	}
+}
+
+func TestTransferFutureAppointmentScope(t *testing.T) {
+	tomorrow := int(time.Now().Local().Add(24 * time.Hour).Weekday())
+	later := int(time.Now().Local().Add(48 * time.Hour).Weekday())
+
+	// The source queue allows an appointment a day, but the target only
+	// one at a time, so the second one has nowhere to go.
+	s := newTestServer()
+	store := newFakeStore()
+	q := store.addQueue(&QueueConfiguration{AppointmentSettings: AppointmentSettings{FutureAppointmentScope: FutureAppointmentScopeDay}})
+	target := store.addQueue(&QueueConfiguration{})
+	store.book(q, tomorrow, 10, "student@example.com")
+	store.book(q, later, 10, "student@example.com")
+
+	w := transferRequest(s, store, q, target, "student@example.com")
+	checkTransferConflict(t, w)
+
+	// With an appointment a day allowed in the target too, both move.
+	store = newFakeStore()
+	q = store.addQueue(&QueueConfiguration{AppointmentSettings: AppointmentSettings{FutureAppointmentScope: FutureAppointmentScopeDay}})
+	target = store.addQueue(&QueueConfiguration{AppointmentSettings: AppointmentSettings{FutureAppointmentScope: FutureAppointmentScopeDay}})
+	store.book(q, tomorrow, 10, "student@example.com")
+	store.book(q, later, 10, "student@example.com")
+
+	w = transferRequest(s, store, q, target, "student@example.com")
+	if w.Code != http.StatusOK {
+		t.Fatalf("got status %d, want %d: %s", w.Code, http.StatusOK, w.Body.String())
+	}
+	for _, a := range store.appointments {
+		if a.Queue != target.ID {
+			t.Errorf("appointment %s left in queue %s", a.ID, a.Queue)
+		}
+	}
+}
+
+func TestStudentDoesntSeeResolved(t *testing.T) {
+	s := newTestServer()
+	store := newFakeStore()
+	q := store.addQueue(&QueueConfiguration{})
+
+	tomorrow := int(time.Now().Local().Add(24 * time.Hour).Weekday())
+	a := store.book(q, tomorrow, 10, "student@example.com")
+	resolved := false
+	a.Resolved = &resolved
+
+	for _, admin := range []bool{false, true} {
+		r, _ := newTestRequest(http.MethodGet, "/", nil, map[string]interface{}{
+			queueContextKey:       q,
+			appointmentContextKey: a,
+			emailContextKey:       "student@example.com",
+			courseAdminContextKey: admin,
+		})
+		w := httptest.NewRecorder()
+		s.GetAppointmentByID(store).ServeHTTP(w, r)
+		if w.Code != http.StatusOK {
+			t.Fatalf("got status %d, want %d: %s", w.Code, http.StatusOK, w.Body.String())
+		}
+
+		var body map[string]interface{}
+		err := json.NewDecoder(w.Body).Decode(&body)
+		if err != nil {
+			t.Fatalf("failed to decode response: %v", err)
+		}
+		if _, ok := body["resolved"]; ok != admin {
+			t.Errorf("admin %t: got resolved in response %t, want %t", admin, ok, admin)
+		}
+	}
+}
+
+func TestReleaseOrphanedClaimsKeepsStartedClaims(t *testing.T) {
+	now := time.Now().Local()
+	if now.Hour() == 23 {
+		t.Skip("no timeslot left today that hasn't started")
+	}
+
+	s := newTestServer()
+	store := newFakeStore()
+	q := store.addQueue(&QueueConfiguration{})
+
+	today := int(now.Weekday())
+	started := store.claim(q, today, 0, "early@example.com")
+	upcoming := store.claim(q, today, 23, "late@example.com")
+
+	current := store.schedules[q.ID][today]
+	schedule := &AppointmentSchedule{Queue: q.ID, Day: current.Day, Duration: 30, Schedule: strings.Repeat("1", 48)}
+	released, messages, err := s.releaseOrphanedClaims(context.Background(), s.logger, store, q, today, current, schedule, "admin@example.com")
+	if err != nil {
+		t.Fatalf("failed to release claims: %v", err)
+	}
+
+	if len(released) != 1 || released[0].ID != upcoming.ID {
+		t.Errorf("got %d released claims, want just the upcoming one", len(released))
+	}
+	if store.appointment(started.ID) == nil {
+		t.Error("claim that already started was released")
+	}
+	if len(messages) != 1 || messages[0].Receiver != "late@example.com" {
+		t.Errorf("got messages %v, want one to late@example.com", messages)
+	}
+}
+
+func TestOrphanedClaimsMessage(t *testing.T) {
+	for _, approval := range []bool{false, true} {
+		s := newTestServer()
+		store := newFakeStore()
+		q := store.addQueue(&QueueConfiguration{AppointmentSettings: AppointmentSettings{RequireScheduleApproval: approval}})
+
+		tomorrow := int(time.Now().Local().Add(24 * time.Hour).Weekday())
+		store.claim(q, tomorrow, 10, "staff@example.com")
+
+		schedule := *store.schedules[q.ID][tomorrow]
+		schedule.Schedule = strings.Repeat("1", 10) + "0" + strings.Repeat("1", 13)
+		w := scheduleRequest(s, store, q, tomorrow, &schedule)
+		if w.Code != http.StatusConflict {
+			t.Fatalf("approval %t: got status %d, want %d: %s", approval, w.Code, http.StatusConflict, w.Body.String())
+		}
+
+		var body ErrorMessage
+		err := json.NewDecoder(w.Body).Decode(&body)
+		if err != nil {
+			t.Fatalf("failed to decode error response: %v", err)
+		}
+		if body.Code != orphanedClaims {
+			t.Errorf("approval %t: got code %s, want %s", approval, body.Code, orphanedClaims)
+		}
+		// Queues needing approval refuse to release claims, so they
+		// shouldn't be told to.
+		if offered := strings.Contains(body.Message, "Release them"); offered == approval {
+			t.Errorf("approval %t: got message %q", approval, body.Message)
+		}
+	}
+}
+
+func TestOrphanedClaims(t *testing.T) {
+	s := newTestServer()
+	store := newFakeStore()
+	q := store.addQueue(&QueueConfiguration{})
+	tomorrow := int(time.Now().Local().Add(24 * time.Hour).Weekday())
+
+	kept := store.claim(q, tomorrow, 5, "first@example.com")
+	orphaned := []*AppointmentSlot{
+		store.claim(q, tomorrow, 14, "first@example.com"),
+		store.claim(q, tomorrow, 15, "first@example.com"),
+		store.claim(q, tomorrow, 16, "second@example.com"),
+	}
+
+	// Cutting the afternoon off the day leaves the afternoon claims with
+	// nowhere to go.
+	shortened := &AppointmentSchedule{Duration: 60, Schedule: strings.Repeat("1", 12) + strings.Repeat("0", 12)}
+	w := scheduleRequest(s, store, q, tomorrow, shortened)
+	if w.Code != http.StatusConflict {
+		t.Fatalf("got status %d without release, want %d: %s", w.Code, http.StatusConflict, w.Body.String())
+	}
+	var body struct {
+		Code    string               `json:"code"`
+		Details orphanedClaimsDetail `json:"details"`
+	}
+	err := json.NewDecoder(w.Body).Decode(&body)
+	if err != nil {
+		t.Fatalf("failed to decode error response: %v", err)
+	}
+	if body.Code != orphanedClaims {
+		t.Errorf("got code %q, want %q", body.Code, orphanedClaims)
+	}
+	if body.Details.Timeslot != 14 || len(body.Details.Claims) != 1 || body.Details.Claims[0] != "first@example.com" {
+		t.Errorf("got details %+v, want first@example.com's claim at timeslot 14", body.Details)
+	}
+	if store.schedules[q.ID][tomorrow].Schedule == shortened.Schedule {
+		t.Error("schedule changed despite orphaned claims")
+	}
+	if len(store.appointments) != 4 {
+		t.Errorf("got %d claims after refused change, want 4", len(store.appointments))
+	}
+
+	// Releasing them lets the change go ahead, and tells their staff.
+	encoded, _ := json.Marshal(shortened)
+	r, _ := newTestRequest(http.MethodPut, "/?release_claims=true", strings.NewReader(string(encoded)), map[string]interface{}{
+		queueContextKey:          q,
+		appointmentDayContextKey: tomorrow,
+		emailContextKey:          "admin@example.com",
+		courseAdminContextKey:    true,
+	})
+	w = httptest.NewRecorder()
+	s.UpdateAppointmentSchedule(store).ServeHTTP(w, r)
+	if w.Code != http.StatusNoContent {
+		t.Fatalf("got status %d with release, want %d: %s", w.Code, http.StatusNoContent, w.Body.String())
+	}
+	if store.schedules[q.ID][tomorrow].Schedule != shortened.Schedule {
+		t.Error("schedule didn't change after releasing claims")
+	}
+	for _, a := range orphaned {
+		if store.appointment(a.ID) != nil {
+			t.Errorf("claim at timeslot %d wasn't released", a.Timeslot)
+		}
+	}
+	if store.appointment(kept.ID) == nil {
+		t.Error("claim that still fits was released")
+	}
+	if len(store.claimEvents) != 3 {
+		t.Errorf("got %d claim events, want one per released claim", len(store.claimEvents))
+	}
+
+	received := make(map[string]int)
+	for _, m := range store.messages {
+		received[m.Receiver]++
+		if m.Sender != "admin@example.com" {
+			t.Errorf("got message from %s, want the admin who changed the schedule", m.Sender)
+		}
+	}
+	if len(store.messages) != 2 || received["first@example.com"] != 1 || received["second@example.com"] != 1 {
+		t.Errorf("got messages to %v, want one to each staff member", received)
+	}
+}
+
+// sharedAppointmentRequest looks up the appointment shared by token.
+func sharedAppointmentRequest(s *Server, store *fakeStore, token string) *httptest.ResponseRecorder {
+	r, _ := newTestRequest(http.MethodGet, "/", nil, nil)
+	r = withURLParam(r, "token", token)
+	w := httptest.NewRecorder()
+	s.GetSharedAppointment(store).ServeHTTP(w, r)
+	return w
+}
+
+func TestShareLink(t *testing.T) {
+	s := newTestServer()
+	store := newFakeStore()
+	q := store.addQueue(&QueueConfiguration{})
+
+	tomorrow := int(time.Now().Local().Add(24 * time.Hour).Weekday())
+	a := store.book(q, tomorrow, 10, "student@example.com")
+
+	r, _ := newTestRequest(http.MethodPost, "/", nil, map[string]interface{}{
+		appointmentContextKey: a,
+		emailContextKey:       "student@example.com",
+	})
+	w := httptest.NewRecorder()
+	s.CreateAppointmentShareLink().ServeHTTP(w, r)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("got status %d, want %d: %s", w.Code, http.StatusCreated, w.Body.String())
+	}
+
+	var link struct {
+		Link string `json:"link"`
+	}
+	err := json.NewDecoder(w.Body).Decode(&link)
+	if err != nil {
+		t.Fatalf("failed to decode response: %v", err)
+	}
+	token := link.Link[strings.LastIndex(link.Link, "/")+1:]
+
+	w = sharedAppointmentRequest(s, store, token)
+	if w.Code != http.StatusOK {
+		t.Fatalf("got status %d, want %d: %s", w.Code, http.StatusOK, w.Body.String())
+	}
+	var shared map[string]interface{}
+	err = json.NewDecoder(w.Body).Decode(&shared)
+	if err != nil {
+		t.Fatalf("failed to decode response: %v", err)
+	}
+	if shared["location"] != *a.Location {
+		t.Errorf("got location %v, want %s", shared["location"], *a.Location)
+	}
+	for _, field := range []string{"student_email", "name", "description"} {
+		if _, ok := shared[field]; ok {
+			t.Errorf("shared appointment has %s", field)
+		}
+	}
+}
+
+func TestShareLinkExpired(t *testing.T) {
+	s := newTestServer()
+	store := newFakeStore()
+	q := store.addQueue(&QueueConfiguration{})
+
+	tomorrow := int(time.Now().Local().Add(24 * time.Hour).Weekday())
+	a := store.book(q, tomorrow, 10, "student@example.com")
+
+	token := s.newSignedToken(shareTokenPurpose, a.ID.String(), time.Now().Add(-time.Minute), "student@example.com")
+	w := sharedAppointmentRequest(s, store, token)
+	if w.Code != http.StatusGone {
+		t.Errorf("got status %d, want %d", w.Code, http.StatusGone)
+	}
+}
+
+func TestShareLinkTampered(t *testing.T) {
+	s := newTestServer()
+	store := newFakeStore()
+	q := store.addQueue(&QueueConfiguration{})
+
+	tomorrow := int(time.Now().Local().Add(24 * time.Hour).Weekday())
+	a := store.book(q, tomorrow, 10, "student@example.com")
+	other := store.book(q, tomorrow, 11, "other@example.com")
+
+	expires := time.Now().Add(-time.Minute)
+	token := s.newSignedToken(shareTokenPurpose, a.ID.String(), expires, "student@example.com")
+	parts := strings.Split(token, ".")
+
+	tokens := map[string]string{
+		"pushed back expiry": parts[0] + "." + strconv.FormatInt(time.Now().Add(time.Hour).Unix(), 10) + "." + parts[2],
+		"other appointment":  other.ID.String() + "." + parts[1] + "." + parts[2],
+		"cancel token":       s.newSignedToken(cancelTokenPurpose, a.ID.String(), time.Now().Add(time.Hour), "student@example.com"),
+		"other student":      s.newSignedToken(shareTokenPurpose, a.ID.String(), time.Now().Add(time.Hour), "other@example.com"),
+		"garbage":            "not-a-token",
+	}
+	for name, token := range tokens {
+		w := sharedAppointmentRequest(s, store, token)
+		if w.Code != http.StatusNotFound {
+			t.Errorf("%s: got status %d, want %d", name, w.Code, http.StatusNotFound)
+		}
+	}
+}
+
+func cancelByTokenRequest(s *Server, store *fakeStore, token string) *httptest.ResponseRecorder {
+	r, _ := newTestRequest(http.MethodPost, "/", nil, nil)
+	r = withURLParam(r, "token", token)
+	w := httptest.NewRecorder()
+	s.CancelAppointmentByToken(store).ServeHTTP(w, r)
+	return w
+}
+
+func TestCancelLink(t *testing.T) {
+	s := newTestServer()
+	store := newFakeStore()
+	q := store.addQueue(&QueueConfiguration{})
+
+	tomorrow := int(time.Now().Local().Add(24 * time.Hour).Weekday())
+	a := store.book(q, tomorrow, 10, "student@example.com")
+
+	r, _ := newTestRequest(http.MethodPost, "/", nil, map[string]interface{}{
+		appointmentContextKey: a,
+		emailContextKey:       "student@example.com",
+	})
+	w := httptest.NewRecorder()
+	s.CreateAppointmentCancelLink().ServeHTTP(w, r)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("got status %d, want %d: %s", w.Code, http.StatusCreated, w.Body.String())
+	}
+
+	var link struct {
+		Link string `json:"link"`
+	}
+	err := json.NewDecoder(w.Body).Decode(&link)
+	if err != nil {
+		t.Fatalf("failed to decode response: %v", err)
+	}
+	token := link.Link[strings.LastIndex(link.Link, "/")+1:]
+
+	w = cancelByTokenRequest(s, store, token)
+	if w.Code != http.StatusNoContent {
+		t.Fatalf("got status %d, want %d", w.Code, http.StatusNoContent)
+	}
+	if store.appointment(a.ID) != nil {
+		t.Error("appointment still there after canceling")
+	}
+
+	// There's nothing left for the link to be checked against.
+	w = cancelByTokenRequest(s, store, token)
+	if w.Code != http.StatusNotFound {
+		t.Errorf("got status %d canceling again, want %d", w.Code, http.StatusNotFound)
+	}
+}
+
+func TestCancelLinkExpired(t *testing.T) {
+	s := newTestServer()
+	store := newFakeStore()
+	q := store.addQueue(&QueueConfiguration{})
+
+	tomorrow := int(time.Now().Local().Add(24 * time.Hour).Weekday())
+	a := store.book(q, tomorrow, 10, "student@example.com")
+
+	token := s.newSignedToken(cancelTokenPurpose, a.ID.String(), time.Now().Add(-time.Minute), "student@example.com")
+	w := cancelByTokenRequest(s, store, token)
+	if w.Code != http.StatusGone {
+		t.Errorf("got status %d, want %d", w.Code, http.StatusGone)
+	}
+	if store.appointment(a.ID) == nil {
+		t.Error("appointment canceled with expired link")
+	}
+}
+
+func TestCancelLinkTampered(t *testing.T) {
+	s := newTestServer()
+	store := newFakeStore()
+	q := store.addQueue(&QueueConfiguration{})
+
+	tomorrow := int(time.Now().Local().Add(24 * time.Hour).Weekday())
+	a := store.book(q, tomorrow, 10, "student@example.com")
+	other := store.book(q, tomorrow, 11, "other@example.com")
+	claimed := store.claim(q, tomorrow, 12, "staff@example.com")
+
+	expires := time.Now().Add(-time.Minute)
+	token := s.newSignedToken(cancelTokenPurpose, a.ID.String(), expires, "student@example.com")
+	parts := strings.Split(token, ".")
+	later := strconv.FormatInt(time.Now().Add(time.Hour).Unix(), 10)
+
+	tokens := map[string]string{
+		"pushed back expiry": parts[0] + "." + later + "." + parts[2],
+		"other appointment":  other.ID.String() + "." + parts[1] + "." + parts[2],
+		"share token":        s.newSignedToken(shareTokenPurpose, a.ID.String(), time.Now().Add(time.Hour), "student@example.com"),
+		"other student":      s.newSignedToken(cancelTokenPurpose, a.ID.String(), time.Now().Add(time.Hour), "other@example.com"),
+		"garbage":            "not-a-token",
+		// Tokens that don't check out get the same answer whatever's
+		// (or isn't) at the appointment they name.
+		"empty appointment":   claimed.ID.String() + "." + later + "." + parts[2],
+		"missing appointment": ksuid.New().String() + "." + later + "." + parts[2],
+	}
+	for name, token := range tokens {
+		w := cancelByTokenRequest(s, store, token)
+		if w.Code != http.StatusNotFound {
+			t.Errorf("%s: got status %d, want %d", name, w.Code, http.StatusNotFound)
+		}
+	}
+	if len(store.appointments) != 3 {
+		t.Errorf("got %d stored appointments, want 3", len(store.appointments))
+	}
+	if claimed.StaffEmail == nil || *claimed.StaffEmail != "staff@example.com" {
+		t.Error("claim changed by a tampered cancel link")
+	}
+}
+
+// scheduleRequest has an admin set the appointment schedule for day.
+func scheduleRequest(s *Server, us updateAppointmentSchedule, q *Queue, day int, schedule *AppointmentSchedule) *httptest.ResponseRecorder {
+	encoded, _ := json.Marshal(schedule)
+	r, _ := newTestRequest(http.MethodPut, "/", strings.NewReader(string(encoded)), map[string]interface{}{
+		queueContextKey:          q,
+		appointmentDayContextKey: day,
+		emailContextKey:          "admin@example.com",
+		courseAdminContextKey:    true,
+	})
+	w := httptest.NewRecorder()
+	s.UpdateAppointmentSchedule(us).ServeHTTP(w, r)
+	return w
+}
+
+// timeslotCountingStore records which timeslots had their appointments
+// looked up.
+type timeslotCountingStore struct {
+	*fakeStore
+	checked []int
+}
+
+func (c *timeslotCountingStore) GetAppointmentsByTimeslot(ctx context.Context, queue ksuid.KSUID, from, to time.Time, timeslot int) ([]*AppointmentSlot, error) {
+	c.checked = append(c.checked, timeslot)
+	return c.fakeStore.GetAppointmentsByTimeslot(ctx, queue, from, to, timeslot)
+}
+
+func TestScheduleCapacityIncrease(t *testing.T) {
+	s := newTestServer()
+	store := newFakeStore()
+	q := store.addQueue(&QueueConfiguration{})
+
+	tomorrow := int(time.Now().Local().Add(24 * time.Hour).Weekday())
+	store.book(q, tomorrow, 10, "student@example.com")
+
+	counting := &timeslotCountingStore{fakeStore: store}
+	schedule := *store.schedules[q.ID][tomorrow]
+	schedule.Schedule = strings.Repeat("2", 24)
+	w := scheduleRequest(s, counting, q, tomorrow, &schedule)
+	if w.Code != http.StatusNoContent {
+		t.Fatalf("got status %d, want %d: %s", w.Code, http.StatusNoContent, w.Body.String())
+	}
+	if store.schedules[q.ID][tomorrow].Schedule != schedule.Schedule {
+		t.Errorf("got schedule %s, want %s", store.schedules[q.ID][tomorrow].Schedule, schedule.Schedule)
+	}
+	if len(counting.checked) != 0 {
+		t.Errorf("checked appointments at timeslots %v, which are all gaining room", counting.checked)
+	}
+}
+
+func TestScheduleCapacityDecrease(t *testing.T) {
+	s := newTestServer()
+	store := newFakeStore()
+	q := store.addQueue(&QueueConfiguration{})
+
+	tomorrow := int(time.Now().Local().Add(24 * time.Hour).Weekday())
+	store.book(q, tomorrow, 10, "student@example.com")
+	before := store.schedules[q.ID][tomorrow].Schedule
+
+	counting := &timeslotCountingStore{fakeStore: store}
+	schedule := *store.schedules[q.ID][tomorrow]
+	schedule.Schedule = strings.Repeat("2", 10) + "0" + strings.Repeat("2", 13)
+	w := scheduleRequest(s, counting, q, tomorrow, &schedule)
+	if w.Code != http.StatusConflict {
+		t.Fatalf("got status %d, want %d: %s", w.Code, http.StatusConflict, w.Body.String())
+	}
+	if store.schedules[q.ID][tomorrow].Schedule != before {
+		t.Error("schedule changed despite removing a booked timeslot")
+	}
+	if len(counting.checked) != 1 || counting.checked[0] != 10 {
+		t.Errorf("checked appointments at timeslots %v, want just 10", counting.checked)
+	}
+}
+
+func scheduleBatchRequest(s *Server, store *fakeStore, q *Queue, schedules map[int]*AppointmentSchedule) *httptest.ResponseRecorder {
+	encoded, _ := json.Marshal(schedules)
+	r, _ := newTestRequest(http.MethodPut, "/", strings.NewReader(string(encoded)), map[string]interface{}{
+		queueContextKey:       q,
+		emailContextKey:       "admin@example.com",
+		courseAdminContextKey: true,
+	})
+	w := httptest.NewRecorder()
+	s.UpdateAppointmentSchedulesBatch(store).ServeHTTP(w, r)
+	return w
+}
+
+func TestScheduleBatch(t *testing.T) {
+	s := newTestServer()
+	store := newFakeStore()
+	q := store.addQueue(&QueueConfiguration{})
+
+	schedules := make(map[int]*AppointmentSchedule)
+	for _, day := range []int{1, 3} {
+		schedule := *store.schedules[q.ID][day]
+		schedule.Schedule = strings.Repeat("2", 24)
+		schedules[day] = &schedule
+	}
+	w := scheduleBatchRequest(s, store, q, schedules)
+	if w.Code != http.StatusNoContent {
+		t.Fatalf("got status %d, want %d", w.Code, http.StatusNoContent)
+	}
+	for day := 0; day < 7; day++ {
+		want := strings.Repeat("1", 24)
+		if schedules[day] != nil {
+			want = schedules[day].Schedule
+		}
+		if got := store.schedules[q.ID][day].Schedule; got != want {
+			t.Errorf("got schedule %s for day %d, want %s", got, day, want)
+		}
+	}
+}
+
+func TestScheduleBatchPartialConflict(t *testing.T) {
+	s := newTestServer()
+	store := newFakeStore()
+	q := store.addQueue(&QueueConfiguration{})
+	tomorrow := int(time.Now().Local().Add(24 * time.Hour).Weekday())
+	later := int(time.Now().Local().Add(48 * time.Hour).Weekday())
+	store.book(q, tomorrow, 10, "student@example.com")
+
+	// Later's change is fine on its own, but tomorrow's drops a booked
+	// timeslot, so neither goes through.
+	fine := *store.schedules[q.ID][later]
+	fine.Schedule = strings.Repeat("2", 24)
+	conflicting := *store.schedules[q.ID][tomorrow]
+	conflicting.Schedule = strings.Repeat("1", 10) + "0" + strings.Repeat("1", 13)
+	w := scheduleBatchRequest(s, store, q, map[int]*AppointmentSchedule{
+		later:    &fine,
+		tomorrow: &conflicting,
+	})
+	if w.Code != http.StatusConflict {
+		t.Fatalf("got status %d, want %d: %s", w.Code, http.StatusConflict, w.Body.String())
+	}
+
+	var body struct {
+		Code    string                  `json:"code"`
+		Details []*scheduleBatchFailure `json:"details"`
+	}
+	err := json.NewDecoder(w.Body).Decode(&body)
+	if err != nil {
+		t.Fatalf("failed to decode error response: %v", err)
+	}
+	if body.Code != scheduleBatchFailed {
+		t.Errorf("got code %q, want %q", body.Code, scheduleBatchFailed)
+	}
+	if len(body.Details) != 1 || body.Details[0].Day != tomorrow {
+		t.Errorf("got failures %+v, want just day %d", body.Details, tomorrow)
+	}
+
+	for _, day := range []int{tomorrow, later} {
+		if got := store.schedules[q.ID][day].Schedule; got != strings.Repeat("1", 24) {
+			t.Errorf("schedule for day %d changed to %s despite the conflict", day, got)
+		}
+	}
+}
+
+// appointmentsSinceResponse is what GetAppointmentsSince sends.
+type appointmentsSinceResponse struct {
+	Appointments []*AppointmentSlot      `json:"appointments"`
+	Removed      []*AppointmentTombstone `json:"removed"`
+	AsOf         time.Time               `json:"as_of"`
+}
+
+func appointmentsSinceRequest(t *testing.T, s *Server, store *fakeStore, q *Queue, since time.Time, admin bool) *appointmentsSinceResponse {
+	t.Helper()
+	r, _ := newTestRequest(http.MethodGet, "/?since="+url.QueryEscape(since.Format(time.RFC3339Nano)), nil, map[string]interface{}{
+		queueContextKey:       q,
+		courseAdminContextKey: admin,
+	})
+	w := httptest.NewRecorder()
+	s.GetAppointmentsSince(store).ServeHTTP(w, r)
+	if w.Code != http.StatusOK {
+		t.Fatalf("got status %d, want %d: %s", w.Code, http.StatusOK, w.Body.String())
+	}
+
+	var response appointmentsSinceResponse
+	err := json.NewDecoder(w.Body).Decode(&response)
+	if err != nil {
+		t.Fatalf("failed to decode response: %v", err)
+	}
+	return &response
+}
+
+func TestAppointmentsSince(t *testing.T) {
+	s := newTestServer()
+	store := newFakeStore()
+	q := store.addQueue(&QueueConfiguration{})
+
+	tomorrow := int(time.Now().Local().Add(24 * time.Hour).Weekday())
+	updated := store.book(q, tomorrow, 10, "updated@example.com")
+	canceled := store.book(q, tomorrow, 11, "canceled@example.com")
+	store.book(q, tomorrow, 12, "untouched@example.com")
+
+	since := time.Now()
+
+	changed := *updated
+	description := "Something else"
+	changed.Description = &description
+	w := rescheduleRequest(t, s, store, q, &changed, changed.Timeslot)
+	if w.Code >= 300 {
+		t.Fatalf("failed to update appointment: %d %s", w.Code, w.Body.String())
+	}
+
+	w = signupRequest(s, store, q, tomorrow, "new@example.com", 13, 1)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("failed to sign up: %d %s", w.Code, w.Body.String())
+	}
+
+	r, _ := newTestRequest(http.MethodDelete, "/", nil, map[string]interface{}{
+		queueContextKey:       q,
+		appointmentContextKey: canceled,
+		emailContextKey:       "canceled@example.com",
+	})
+	w = httptest.NewRecorder()
+	s.RemoveAppointmentSignup(store).ServeHTTP(w, r)
+	if w.Code != http.StatusNoContent {
+		t.Fatalf("failed to cancel: %d %s", w.Code, w.Body.String())
+	}
+
+	response := appointmentsSinceRequest(t, s, store, q, since, true)
+	students := make(map[string]bool)
+	for _, a := range response.Appointments {
+		students[*a.StudentEmail] = true
+	}
+	if len(students) != 2 || !students["updated@example.com"] || !students["new@example.com"] {
+		t.Errorf("got changed appointments for %v, want updated and new", students)
+	}
+	if len(response.Removed) != 1 || response.Removed[0].ID != canceled.ID {
+		t.Errorf("got removed %v, want just the canceled appointment", response.Removed)
+	}
+	if !response.AsOf.Before(time.Now().Add(-appointmentSyncOverlap + time.Second)) {
+		t.Errorf("got as_of %v, which doesn't leave room for changes still committing", response.AsOf)
+	}
+
+	// Students get the same changes, without who they belong to.
+	response = appointmentsSinceRequest(t, s, store, q, since, false)
+	if len(response.Appointments) != 2 || len(response.Removed) != 1 {
+		t.Errorf("got %d changed and %d removed for a student, want 2 and 1", len(response.Appointments), len(response.Removed))
+	}
+	for _, a := range response.Appointments {
+		if a.StudentEmail != nil {
+			t.Errorf("student got email %s in changes", *a.StudentEmail)
+		}
+	}
+}
+
+func TestValidateAppointmentScheduleOverflow(t *testing.T) {
+	schedules := map[string]*AppointmentSchedule{
+		"huge duration":      {Duration: math.MaxInt32, Schedule: "1"},
+		"zero duration":      {Duration: 0, Schedule: "1"},
+		"huge padding":       {Duration: 60, Padding: math.MaxInt32, Schedule: "1"},
+		"too many timeslots": {Duration: 60, Schedule: strings.Repeat("1", 25)},
+		"non-digit":          {Duration: 60, Schedule: "1x1"},
+		"huge signups open":  {Duration: 60, SignupsOpen: math.MaxInt32, Schedule: "1"},
+	}
+	for name, schedule := range schedules {
+		err := validateAppointmentSchedule(schedule)
+		var se StatusError
+		if !errors.As(err, &se) || se.status != http.StatusBadRequest {
+			t.Errorf("%s: got %v, want a bad request", name, err)
+		}
+	}
+
+	err := validateAppointmentSchedule(&AppointmentSchedule{Duration: 1, Schedule: strings.Repeat("9", minutesInDay)})
+	if err != nil {
+		t.Errorf("got %v for a schedule filling the whole day", err)
+	}
+}
+
+func TestTimeslotOutOfRange(t *testing.T) {
+	s := newTestServer()
+	store := newFakeStore()
+	q := store.addQueue(&QueueConfiguration{})
+
+	tomorrow := int(time.Now().Local().Add(24 * time.Hour).Weekday())
+	for _, timeslot := range []int{24, math.MaxInt32} {
+		w := signupRequest(s, store, q, tomorrow, "student@example.com", timeslot, 1)
+		if w.Code != http.StatusNotFound {
+			t.Errorf("signup at %d: got status %d, want %d", timeslot, w.Code, http.StatusNotFound)
+		}
+	}
+
+	a := store.book(q, tomorrow, 10, "student@example.com")
+	for _, timeslot := range []int{-1, 24, math.MaxInt32} {
+		w := rescheduleRequest(t, s, store, q, a, timeslot)
+		if w.Code != http.StatusNotFound {
+			t.Errorf("reschedule to %d: got status %d, want %d", timeslot, w.Code, http.StatusNotFound)
+		}
+	}
+	if store.appointment(a.ID).Timeslot != 10 {
+		t.Error("appointment moved to a timeslot that doesn't exist")
+	}
+}
+
+func TestFutureAppointmentScope(t *testing.T) {
+	tomorrow := int(time.Now().Local().Add(24 * time.Hour).Weekday())
+	later := int(time.Now().Local().Add(48 * time.Hour).Weekday())
+
+	scopes := map[FutureAppointmentScope]int{
+		FutureAppointmentScopeQueue: http.StatusConflict,
+		FutureAppointmentScopeDay:   http.StatusCreated,
+	}
+	for scope, want := range scopes {
+		s := newTestServer()
+		store := newFakeStore()
+		q := store.addQueue(&QueueConfiguration{AppointmentSettings: AppointmentSettings{FutureAppointmentScope: scope}})
+		store.book(q, tomorrow, 10, "student@example.com")
+
+		w := signupRequest(s, store, q, later, "student@example.com", 10, 1)
+		if w.Code != want {
+			t.Errorf("%s scope, other day: got status %d, want %d: %s", scope, w.Code, want, w.Body.String())
+		}
+
+		// Either way, a second appointment on the same day is too many.
+		w = signupRequest(s, store, q, tomorrow, "student@example.com", 12, 1)
+		if w.Code != http.StatusConflict {
+			t.Errorf("%s scope, same day: got status %d, want %d", scope, w.Code, http.StatusConflict)
+		}
+	}
+}
+
+func TestCancelThenRebook(t *testing.T) {
+	tomorrow := int(time.Now().Local().Add(24 * time.Hour).Weekday())
+
+	for _, scope := range []FutureAppointmentScope{FutureAppointmentScopeQueue, FutureAppointmentScopeDay} {
+		// Whether the slot goes away or a staff member's claim keeps it
+		// around, canceling frees the student up straight away.
+		for _, claimed := range []bool{false, true} {
+			s := newTestServer()
+			store := newFakeStore()
+			q := store.addQueue(&QueueConfiguration{AppointmentSettings: AppointmentSettings{FutureAppointmentScope: scope}})
+
+			w := signupRequest(s, store, q, tomorrow, "student@example.com", 10, 1)
+			if w.Code != http.StatusCreated {
+				t.Fatalf("%s scope: got status %d signing up, want %d: %s", scope, w.Code, http.StatusCreated, w.Body.String())
+			}
+			a := store.appointments[0]
+			if claimed {
+				staff := "staff@example.com"
+				a.StaffEmail = &staff
+			}
+
+			// One appointment is all they get.
+			w = signupRequest(s, store, q, tomorrow, "student@example.com", 12, 1)
+			if w.Code != http.StatusConflict {
+				t.Fatalf("%s scope: got status %d signing up again, want %d", scope, w.Code, http.StatusConflict)
+			}
+
+			r, _ := newTestRequest(http.MethodDelete, "/", nil, map[string]interface{}{
+				queueContextKey:       q,
+				appointmentContextKey: a,
+				emailContextKey:       "student@example.com",
+			})
+			w = httptest.NewRecorder()
+			s.RemoveAppointmentSignup(store).ServeHTTP(w, r)
+			if w.Code != http.StatusNoContent {
+				t.Fatalf("%s scope: got status %d canceling, want %d: %s", scope, w.Code, http.StatusNoContent, w.Body.String())
+			}
+
+			w = signupRequest(s, store, q, tomorrow, "student@example.com", 12, 1)
+			if w.Code != http.StatusCreated {
+				t.Errorf("%s scope, claimed %v: got status %d rebooking after canceling, want %d: %s", scope, claimed, w.Code, http.StatusCreated, w.Body.String())
+			}
+		}
+	}
+}
+
+// appointmentDayRequest gets the combined view of day, as email.
+func appointmentDayRequest(t *testing.T, s *Server, store *fakeStore, q *Queue, day int, email string, admin bool) *AppointmentDay {
+	t.Helper()
+	r, _ := newTestRequest(http.MethodGet, "/", nil, map[string]interface{}{
+		queueContextKey:          q,
+		appointmentDayContextKey: day,
+		emailContextKey:          email,
+		courseAdminContextKey:    admin,
+	})
+	w := httptest.NewRecorder()
+	s.GetAppointmentDay(store).ServeHTTP(w, r)
+	if w.Code != http.StatusOK {
+		t.Fatalf("got status %d, want %d: %s", w.Code, http.StatusOK, w.Body.String())
+	}
+
+	var response AppointmentDay
+	err := json.NewDecoder(w.Body).Decode(&response)
+	if err != nil {
+		t.Fatalf("failed to decode response: %v", err)
+	}
+	return &response
+}
+
+func TestAppointmentDay(t *testing.T) {
+	s := newTestServer()
+	store := newFakeStore()
+	q := store.addQueue(&QueueConfiguration{})
+
+	tomorrow := int(time.Now().Local().Add(24 * time.Hour).Weekday())
+	store.schedules[q.ID][tomorrow].Schedule = strings.Repeat("2", 24)
+	mine := store.book(q, tomorrow, 10, "student@example.com")
+	store.book(q, tomorrow, 10, "other@example.com")
+	store.claim(q, tomorrow, 11, "staff@example.com")
+
+	day := appointmentDayRequest(t, s, store, q, tomorrow, "admin@example.com", true)
+	if day.Schedule == nil || day.Schedule.Schedule != store.schedules[q.ID][tomorrow].Schedule {
+		t.Errorf("got schedule %v, want the day's", day.Schedule)
+	}
+	if len(day.Appointments) != 3 {
+		t.Errorf("admin got %d appointments, want 3", len(day.Appointments))
+	}
+	if len(day.Availability) != 24 {
+		t.Fatalf("got availability for %d timeslots, want 24", len(day.Availability))
+	}
+	if a := day.Availability[10]; a.Status != AvailabilityFull || *a.Taken != 2 || *a.Open != 0 {
+		t.Errorf("got timeslot 10 %s with %d taken, want full with 2", a.Status, *a.Taken)
+	}
+	if a := day.Availability[11]; a.Status != AvailabilityOpen || *a.Taken != 0 {
+		t.Errorf("got timeslot 11 %s with %d taken, want staff claims not to count", a.Status, *a.Taken)
+	}
+
+	day = appointmentDayRequest(t, s, store, q, tomorrow, "student@example.com", false)
+	if len(day.Appointments) != 2 {
+		t.Fatalf("student got %d appointments, want their own and the other student's", len(day.Appointments))
+	}
+	for _, a := range day.Appointments {
+		if a.ID == mine.ID {
+			if a.StudentEmail == nil || *a.StudentEmail != "student@example.com" {
+				t.Error("student's own appointment is missing their details")
+			}
+			continue
+		}
+		if a.StudentEmail != nil || a.Name != nil || a.Description != nil || a.Location != nil {
+			t.Error("student got another student's details")
+		}
+	}
+}
+
+func TestNotifyAppointmentStudentsForDay(t *testing.T) {
+	s := newTestServer()
+	store := newFakeStore()
+	q := store.addQueue(&QueueConfiguration{})
+	tomorrow := int(time.Now().Local().Add(24 * time.Hour).Weekday())
+
+	// Two slots for one student, one for another, and a claimed slot
+	// whose student cancelled.
+	store.book(q, tomorrow, 9, "twice@example.com")
+	store.book(q, tomorrow, 10, "twice@example.com")
+	store.book(q, tomorrow, 11, "once@example.com")
+	cancelled := store.book(q, tomorrow, 12, "cancelled@example.com")
+	staff := "staff@example.com"
+	cancelled.StaffEmail = &staff
+	_, _, err := store.RemoveAppointmentSignup(context.Background(), cancelled.ID)
+	if err != nil {
+		t.Fatalf("failed to cancel appointment: %v", err)
+	}
+
+	r, _ := newTestRequest(http.MethodPost, "/", strings.NewReader(`{"content":"We moved rooms."}`), map[string]interface{}{
+		queueContextKey:          q,
+		appointmentDayContextKey: tomorrow,
+		emailContextKey:          "admin@example.com",
+	})
+	w := httptest.NewRecorder()
+	s.NotifyAppointmentStudentsForDay(store).ServeHTTP(w, r)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("got status %d, want %d: %s", w.Code, http.StatusCreated, w.Body.String())
+	}
+
+	var response struct {
+		Recipients []string `json:"recipients"`
+	}
+	err = json.NewDecoder(w.Body).Decode(&response)
+	if err != nil {
+		t.Fatalf("failed to decode response: %v", err)
+	}
+	sort.Strings(response.Recipients)
+	want := []string{"once@example.com", "twice@example.com"}
+	if !reflect.DeepEqual(response.Recipients, want) {
+		t.Errorf("got recipients %v, want %v", response.Recipients, want)
+	}
+
+	if len(store.messages) != 2 {
+		t.Fatalf("sent %d messages, want 2", len(store.messages))
+	}
+	for _, m := range store.messages {
+		if m.Sender != "admin@example.com" || m.Content != "We moved rooms." {
+			t.Errorf("got message %+v, want the admin's", m)
+		}
+	}
+}
+
+func TestCancelAppointmentGoneMidRemoval(t *testing.T) {
+	s := newTestServer()
+	store := newFakeStore()
+	q := store.addQueue(&QueueConfiguration{})
+	tomorrow := int(time.Now().Local().Add(24 * time.Hour).Weekday())
+	a := store.book(q, tomorrow, 10, "student@example.com")
+
+	// The middleware loaded a, then a retried request removed it before
+	// this one got to.
+	loaded := *a
+	store.deleteAppointment(a.ID)
+
+	r, reqErr := newTestRequest(http.MethodDelete, "/", nil, map[string]interface{}{
+		queueContextKey:       q,
+		appointmentContextKey: &loaded,
+		emailContextKey:       "student@example.com",
+	})
+	w := httptest.NewRecorder()
+	s.RemoveAppointmentSignup(store).ServeHTTP(w, r)
+	if w.Code != http.StatusOK {
+		t.Fatalf("got status %d, want %d: %s", w.Code, http.StatusOK, w.Body.String())
+	}
+	if *reqErr != nil {
+		t.Errorf("got request error %v", *reqErr)
+	}
+	if len(store.tombstones) != 1 {
+		t.Errorf("got %d tombstones, want just the first removal's", len(store.tombstones))
+	}
+}
+
+// availabilityAt finds the availability for timeslot in day.
+func availabilityAt(t *testing.T, day *AppointmentDay, timeslot int) *TimeslotAvailability {
+	t.Helper()
+	for _, a := range day.Availability {
+		if a.Timeslot == timeslot {
+			return a
+		}
+	}
+	t.Fatalf("got no availability for timeslot %d", timeslot)
+	return nil
+}
+
+func TestAvailabilityDisplayExact(t *testing.T) {
+	s := newTestServer()
+	store := newFakeStore()
+	q := store.addQueue(&QueueConfiguration{AppointmentSettings: AppointmentSettings{AvailabilityDisplay: AvailabilityDisplayExact}})
+	tomorrow := int(time.Now().Local().Add(24 * time.Hour).Weekday())
+	store.book(q, tomorrow, 10, "other@example.com")
+
+	day := appointmentDayRequest(t, s, store, q, tomorrow, "student@example.com", false)
+	full := availabilityAt(t, day, 10)
+	if full.Status != AvailabilityFull {
+		t.Errorf("got status %s, want %s", full.Status, AvailabilityFull)
+	}
+	if full.Capacity == nil || full.Taken == nil || full.Open == nil {
+		t.Fatalf("got availability %+v, want exact counts", full)
+	}
+	if *full.Capacity != 1 || *full.Taken != 1 || *full.Open != 0 {
+		t.Errorf("got capacity %d, taken %d, open %d; want 1, 1, 0", *full.Capacity, *full.Taken, *full.Open)
+	}
+	if len(day.Appointments) != 1 {
+		t.Errorf("got %d appointments, want the other student's", len(day.Appointments))
+	}
+}
+
+func TestAvailabilityDisplayCoarse(t *testing.T) {
+	s := newTestServer()
+	store := newFakeStore()
+	q := store.addQueue(&QueueConfiguration{AppointmentSettings: AppointmentSettings{AvailabilityDisplay: AvailabilityDisplayCoarse}})
+	tomorrow := int(time.Now().Local().Add(24 * time.Hour).Weekday())
+	store.book(q, tomorrow, 10, "other@example.com")
+	store.book(q, tomorrow, 11, "student@example.com")
+
+	day := appointmentDayRequest(t, s, store, q, tomorrow, "student@example.com", false)
+	for timeslot, want := range map[int]AvailabilityStatus{10: AvailabilityFull, 12: AvailabilityOpen} {
+		a := availabilityAt(t, day, timeslot)
+		if a.Status != want {
+			t.Errorf("got status %s at timeslot %d, want %s", a.Status, timeslot, want)
+		}
+		if a.Capacity != nil || a.Taken != nil || a.Open != nil {
+			t.Errorf("got counts at timeslot %d under coarse availability", timeslot)
+		}
+	}
+	// Other students' bookings would give the counts away, but the
+	// student's own still shows up.
+	if len(day.Appointments) != 1 || day.Appointments[0].Timeslot != 11 {
+		t.Errorf("got appointments %v, want just the student's own", day.Appointments)
+	}
+
+	// Staff still get the exact picture.
+	day = appointmentDayRequest(t, s, store, q, tomorrow, "admin@example.com", true)
+	full := availabilityAt(t, day, 10)
+	if full.Taken == nil || *full.Taken != 1 {
+		t.Errorf("admin got availability %+v, want exact counts", full)
+	}
+	if len(day.Appointments) != 2 {
+		t.Errorf("admin got %d appointments, want 2", len(day.Appointments))
+	}
+}
+
+// partnerSignupRequest signs email up for timeslot tomorrow along with
+// partners.
+func partnerSignupRequest(s *Server, store *fakeStore, q *Queue, email string, timeslot int, partners ...string) *httptest.ResponseRecorder {
+	tomorrow := int(time.Now().Local().Add(24 * time.Hour).Weekday())
+	return signupBodyRequest(s, store, q, tomorrow, email, timeslot, map[string]interface{}{
+		"location":    "Here",
+		"description": "Help",
+		"partners":    partners,
+	})
+}
+
+func TestPartnerSignup(t *testing.T) {
+	s := newTestServer()
+	store := newFakeStore()
+	q := store.addQueue(&QueueConfiguration{})
+
+	w := partnerSignupRequest(s, store, q, "student@example.com", 10, "partner@example.com")
+	if w.Code != http.StatusCreated {
+		t.Fatalf("got status %d, want %d: %s", w.Code, http.StatusCreated, w.Body.String())
+	}
+	var a AppointmentSlot
+	err := json.NewDecoder(w.Body).Decode(&a)
+	if err != nil {
+		t.Fatalf("failed to decode response: %v", err)
+	}
+
+	// The pair shares the one slot.
+	if len(store.appointments) != 1 {
+		t.Fatalf("got %d appointments, want 1", len(store.appointments))
+	}
+	want := []string{"partner@example.com"}
+	if !reflect.DeepEqual(store.partners[a.ID], want) {
+		t.Errorf("got stored partners %v, want %v", store.partners[a.ID], want)
+	}
+	if !reflect.DeepEqual(a.Partners, want) {
+		t.Errorf("got partners %v in response, want %v", a.Partners, want)
+	}
+
+	// The partner has an appointment now, so they can't book another.
+	tomorrow := int(time.Now().Local().Add(24 * time.Hour).Weekday())
+	w = signupRequest(s, store, q, tomorrow, "partner@example.com", 12, 1)
+	if w.Code != http.StatusConflict {
+		t.Errorf("got status %d for the partner's own signup, want %d", w.Code, http.StatusConflict)
+	}
+}
+
+func TestPartnerSignupConflictingPartner(t *testing.T) {
+	s := newTestServer()
+	store := newFakeStore()
+	q := store.addQueue(&QueueConfiguration{})
+	tomorrow := int(time.Now().Local().Add(24 * time.Hour).Weekday())
+	store.book(q, tomorrow, 14, "partner@example.com")
+
+	w := partnerSignupRequest(s, store, q, "student@example.com", 10, "free@example.com", "partner@example.com")
+	if w.Code != http.StatusConflict {
+		t.Fatalf("got status %d, want %d: %s", w.Code, http.StatusConflict, w.Body.String())
+	}
+	var body ErrorMessage
+	err := json.NewDecoder(w.Body).Decode(&body)
+	if err != nil {
+		t.Fatalf("failed to decode error response: %v", err)
+	}
+	if !strings.Contains(body.Message, "partner@example.com") {
+		t.Errorf("got message %q, want it to name the conflicting partner", body.Message)
+	}
+	if len(store.appointments) != 1 {
+		t.Errorf("got %d appointments, want just the partner's existing one", len(store.appointments))
+	}
+}
+
+func TestMaxConcurrentAppointments(t *testing.T) {
+	s := newTestServer()
+	store := newFakeStore()
+	q := store.addQueue(&QueueConfiguration{AppointmentSettings: AppointmentSettings{MaxConcurrentAppointments: 2}})
+	tomorrow := int(time.Now().Local().Add(24 * time.Hour).Weekday())
+	// Plenty of room in every timeslot as far as the schedule goes.
+	store.schedules[q.ID][tomorrow].Schedule = strings.Repeat("5", 24)
+	store.book(q, tomorrow, 10, "first@example.com")
+	store.book(q, tomorrow, 10, "second@example.com")
+
+	w := signupRequest(s, store, q, tomorrow, "student@example.com", 10, 1)
+	if w.Code != http.StatusConflict {
+		t.Fatalf("got status %d, want %d: %s", w.Code, http.StatusConflict, w.Body.String())
+	}
+	if len(store.appointments) != 2 {
+		t.Errorf("got %d appointments, want 2", len(store.appointments))
+	}
+
+	// The next hour doesn't overlap, so there's room.
+	w = signupRequest(s, store, q, tomorrow, "student@example.com", 11, 1)
+	if w.Code != http.StatusCreated {
+		t.Errorf("got status %d, want %d: %s", w.Code, http.StatusCreated, w.Body.String())
+	}
+}
+
+func TestMaxConcurrentAppointmentsOnReschedule(t *testing.T) {
+	s := newTestServer()
+	store := newFakeStore()
+	q := store.addQueue(&QueueConfiguration{AppointmentSettings: AppointmentSettings{MaxConcurrentAppointments: 1}})
+	tomorrow := int(time.Now().Local().Add(24 * time.Hour).Weekday())
+	store.schedules[q.ID][tomorrow].Schedule = strings.Repeat("5", 24)
+	store.book(q, tomorrow, 10, "other@example.com")
+	a := store.book(q, tomorrow, 12, "student@example.com")
+
+	w := rescheduleRequest(t, s, store, q, a, 10)
+	if w.Code != http.StatusConflict {
+		t.Fatalf("got status %d, want %d: %s", w.Code, http.StatusConflict, w.Body.String())
+	}
+	if store.appointment(a.ID) == nil {
+		t.Error("lost the student's appointment")
+	}
+
+	w = rescheduleRequest(t, s, store, q, a, 13)
+	if w.Code != http.StatusCreated {
+		t.Errorf("got status %d, want %d: %s", w.Code, http.StatusCreated, w.Body.String())
+	}
+}
+
+func TestFutureAppointmentConflictDetails(t *testing.T) {
+	s := newTestServer()
+	store := newFakeStore()
+	q := store.addQueue(&QueueConfiguration{})
+	tomorrow := int(time.Now().Local().Add(24 * time.Hour).Weekday())
+	existing := store.book(q, tomorrow, 14, "student@example.com")
+
+	w := signupRequest(s, store, q, tomorrow, "student@example.com", 10, 1)
+	if w.Code != http.StatusConflict {
+		t.Fatalf("got status %d, want %d: %s", w.Code, http.StatusConflict, w.Body.String())
+	}
+
+	var body struct {
+		Message string              `json:"message"`
+		Code    string              `json:"code"`
+		Details AppointmentConflict `json:"details"`
+	}
+	err := json.NewDecoder(w.Body).Decode(&body)
+	if err != nil {
+		t.Fatalf("failed to decode error response: %v", err)
+	}
+	if body.Code != "appointment_conflict" {
+		t.Errorf("got code %q, want appointment_conflict", body.Code)
+	}
+	if body.Details.AppointmentID != existing.ID {
+		t.Errorf("got conflicting appointment %s, want %s", body.Details.AppointmentID, existing.ID)
+	}
+	if !body.Details.ScheduledTime.Equal(existing.ScheduledTime) {
+		t.Errorf("got conflicting time %s, want %s", body.Details.ScheduledTime, existing.ScheduledTime)
+	}
+}
+
+// appointmentsRequest lists the appointments on day, with query added to
+// the URL.
+func appointmentsRequest(t *testing.T, s *Server, store *fakeStore, q *Queue, day int, admin bool, query string) []*AppointmentSlot {
+	t.Helper()
+	r, _ := newTestRequest(http.MethodGet, "/?"+query, nil, map[string]interface{}{
+		queueContextKey:          q,
+		appointmentDayContextKey: day,
+		courseAdminContextKey:    admin,
+	})
+	w := httptest.NewRecorder()
+	s.GetAppointments(store).ServeHTTP(w, r)
+	if w.Code != http.StatusOK {
+		t.Fatalf("got status %d, want %d: %s", w.Code, http.StatusOK, w.Body.String())
+	}
+
+	var appointments []*AppointmentSlot
+	err := json.NewDecoder(w.Body).Decode(&appointments)
+	if err != nil {
+		t.Fatalf("failed to decode response: %v", err)
+	}
+	return appointments
+}
+
+// appointmentIDs gets the IDs of appointments, sorted.
+func appointmentIDs(appointments []*AppointmentSlot) []ksuid.KSUID {
+	ids := make([]ksuid.KSUID, len(appointments))
+	for i, a := range appointments {
+		ids[i] = a.ID
+	}
+	return sortedIDs(ids...)
+}
+
+func sortedIDs(ids ...ksuid.KSUID) []ksuid.KSUID {
+	sort.Slice(ids, func(i, j int) bool { return ids[i].String() < ids[j].String() })
+	return ids
+}
+
+// labelsRequest sets the labels on a as staff.
+func labelsRequest(s *Server, store *fakeStore, q *Queue, a *AppointmentSlot, labels ...string) *httptest.ResponseRecorder {
+	encoded, _ := json.Marshal(map[string]interface{}{"labels": labels})
+	r, _ := newTestRequest(http.MethodPut, "/", strings.NewReader(string(encoded)), map[string]interface{}{
+		queueContextKey:       q,
+		appointmentContextKey: a,
+		emailContextKey:       "staff@example.com",
+	})
+	w := httptest.NewRecorder()
+	s.SetAppointmentLabels(store).ServeHTTP(w, r)
+	return w
+}
+
+func TestSetAppointmentLabels(t *testing.T) {
+	s := newTestServer()
+	store := newFakeStore()
+	q := store.addQueue(&QueueConfiguration{})
+	tomorrow := int(time.Now().Local().Add(24 * time.Hour).Weekday())
+	a := store.book(q, tomorrow, 10, "student@example.com")
+
+	w := labelsRequest(s, store, q, a, " Urgent ", "regrade", "urgent")
+	if w.Code != http.StatusOK {
+		t.Fatalf("got status %d, want %d: %s", w.Code, http.StatusOK, w.Body.String())
+	}
+	want := []string{"urgent", "regrade"}
+	if !reflect.DeepEqual(store.labels[a.ID], want) {
+		t.Errorf("got labels %v, want %v", store.labels[a.ID], want)
+	}
+
+	w = labelsRequest(s, store, q, a, strings.Repeat("x", maxAppointmentLabelLength+1))
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("got status %d for an overlong label, want %d", w.Code, http.StatusBadRequest)
+	}
+	if !reflect.DeepEqual(store.labels[a.ID], want) {
+		t.Errorf("got labels %v after a bad request, want %v", store.labels[a.ID], want)
+	}
+}
+
+func TestFilterAppointmentsByLabel(t *testing.T) {
+	s := newTestServer()
+	store := newFakeStore()
+	q := store.addQueue(&QueueConfiguration{})
+	tomorrow := int(time.Now().Local().Add(24 * time.Hour).Weekday())
+	both := store.book(q, tomorrow, 9, "both@example.com")
+	urgent := store.book(q, tomorrow, 10, "urgent@example.com")
+	store.book(q, tomorrow, 11, "neither@example.com")
+	store.labels[both.ID] = []string{"urgent", "regrade"}
+	store.labels[urgent.ID] = []string{"urgent"}
+
+	got := appointmentsRequest(t, s, store, q, tomorrow, true, "")
+	if len(got) != 3 {
+		t.Errorf("got %d appointments without a filter, want 3", len(got))
+	}
+
+	got = appointmentsRequest(t, s, store, q, tomorrow, true, "label=urgent")
+	if want := sortedIDs(both.ID, urgent.ID); !reflect.DeepEqual(appointmentIDs(got), want) {
+		t.Errorf("got %v for label=urgent, want %v", appointmentIDs(got), want)
+	}
+
+	// More than one label only matches appointments with all of them.
+	got = appointmentsRequest(t, s, store, q, tomorrow, true, "label=urgent&label=regrade")
+	if want := sortedIDs(both.ID); !reflect.DeepEqual(appointmentIDs(got), want) {
+		t.Errorf("got %v for both labels, want %v", appointmentIDs(got), want)
+	}
+	if len(got) == 1 && !reflect.DeepEqual(got[0].Labels, []string{"urgent", "regrade"}) {
+		t.Errorf("got labels %v, want the appointment's", got[0].Labels)
+	}
+
+	// Students don't get to filter on (or see) labels.
+	got = appointmentsRequest(t, s, store, q, tomorrow, false, "label=regrade")
+	if len(got) != 3 {
+		t.Errorf("student got %d appointments, want all 3", len(got))
+	}
+	for _, a := range got {
+		if len(a.Labels) != 0 {
+			t.Errorf("student got labels %v", a.Labels)
+		}
+	}
+}
+
+func TestFilterUnclaimedAppointments(t *testing.T) {
+	s := newTestServer()
+	store := newFakeStore()
+	q := store.addQueue(&QueueConfiguration{})
+	tomorrow := int(time.Now().Local().Add(24 * time.Hour).Weekday())
+	staff := "staff@example.com"
+	covered := store.book(q, tomorrow, 9, "covered@example.com")
+	covered.StaffEmail = &staff
+	uncovered := store.book(q, tomorrow, 10, "uncovered@example.com")
+	store.claim(q, tomorrow, 11, staff)
+
+	got := appointmentsRequest(t, s, store, q, tomorrow, true, "")
+	if len(got) != 3 {
+		t.Errorf("got %d appointments without a filter, want 3", len(got))
+	}
+
+	// Only the student nobody's claimed needs coverage.
+	got = appointmentsRequest(t, s, store, q, tomorrow, true, "unclaimed=true")
+	if want := sortedIDs(uncovered.ID); !reflect.DeepEqual(appointmentIDs(got), want) {
+		t.Errorf("got %v for unclaimed=true, want %v", appointmentIDs(got), want)
+	}
+}
+
+func TestAppointmentsSorted(t *testing.T) {
+	s := newTestServer()
+	store := newFakeStore()
+	q := store.addQueue(&QueueConfiguration{})
+	tomorrow := int(time.Now().Local().Add(24 * time.Hour).Weekday())
+	email := "student@example.com"
+	late := store.book(q, tomorrow, 14, email)
+	early := store.book(q, tomorrow, 10, email)
+	middle := store.book(q, tomorrow, 12, email)
+	second := store.book(q, tomorrow, 11, "second@example.com")
+	first := store.book(q, tomorrow, 11, email)
+	// Booked in the same second, so which ID sorts first is down to chance.
+	ordered := sortedIDs(second.ID, first.ID)
+	first.ID, second.ID = ordered[0], ordered[1]
+
+	ids := func(appointments []*AppointmentSlot) []ksuid.KSUID {
+		ids := make([]ksuid.KSUID, len(appointments))
+		for i, a := range appointments {
+			ids[i] = a.ID
+		}
+		return ids
+	}
+
+	// Appointments at the same time are in ID order.
+	want := []ksuid.KSUID{early.ID, first.ID, second.ID, middle.ID, late.ID}
+	for _, admin := range []bool{true, false} {
+		got := appointmentsRequest(t, s, store, q, tomorrow, admin, "")
+		if !reflect.DeepEqual(ids(got), want) {
+			t.Errorf("got %v with admin %v, want %v", ids(got), admin, want)
+		}
+	}
+
+	want = []ksuid.KSUID{early.ID, first.ID, middle.ID, late.ID}
+	got := myAppointmentsRequest(t, s, store, q, tomorrow, email)
+	if !reflect.DeepEqual(ids(got), want) {
+		t.Errorf("got %v for the student's own appointments, want %v", ids(got), want)
+	}
+}
+
+func TestFilterAppointmentsByLocation(t *testing.T) {
+	s := newTestServer()
+	store := newFakeStore()
+	q := store.addQueue(&QueueConfiguration{})
+	tomorrow := int(time.Now().Local().Add(24 * time.Hour).Weekday())
+	room := "Room 2"
+	padded := "  room 2 "
+	elsewhere := "Room 3"
+	first := store.book(q, tomorrow, 9, "first@example.com")
+	first.Location = &room
+	second := store.book(q, tomorrow, 10, "second@example.com")
+	second.Location = &padded
+	store.book(q, tomorrow, 11, "third@example.com").Location = &elsewhere
+
+	got := appointmentsRequest(t, s, store, q, tomorrow, true, "location=Room+2")
+	if want := sortedIDs(first.ID, second.ID); !reflect.DeepEqual(appointmentIDs(got), want) {
+		t.Errorf("got %v for location=Room 2, want %v", appointmentIDs(got), want)
+	}
+
+	got = appointmentsRequest(t, s, store, q, tomorrow, true, "location=Room+4")
+	if len(got) != 0 {
+		t.Errorf("got %d appointments at a location nobody's at, want 0", len(got))
+	}
+
+	// A blank filter is no filter.
+	got = appointmentsRequest(t, s, store, q, tomorrow, true, "location=+")
+	if len(got) != 3 {
+		t.Errorf("got %d appointments with a blank location filter, want 3", len(got))
+	}
+}
+
+// coverageGapsRequest gets the timeslots nobody's covering on day.
+func coverageGapsRequest(t *testing.T, s *Server, store *fakeStore, q *Queue, day int) []*CoverageGap {
+	t.Helper()
+	r, _ := newTestRequest(http.MethodGet, "/", nil, map[string]interface{}{
+		queueContextKey:          q,
+		appointmentDayContextKey: day,
+	})
+	w := httptest.NewRecorder()
+	s.GetCoverageGaps(store).ServeHTTP(w, r)
+	if w.Code != http.StatusOK {
+		t.Fatalf("got status %d, want %d: %s", w.Code, http.StatusOK, w.Body.String())
+	}
+
+	var gaps []*CoverageGap
+	err := json.NewDecoder(w.Body).Decode(&gaps)
+	if err != nil {
+		t.Fatalf("failed to decode response: %v", err)
+	}
+	return gaps
+}
+
+func TestCoverageGaps(t *testing.T) {
+	s := newTestServer()
+	store := newFakeStore()
+	q := store.addQueue(&QueueConfiguration{})
+	tomorrow := int(time.Now().Local().Add(24 * time.Hour).Weekday())
+	store.schedules[q.ID][tomorrow].Schedule = "000000000222200000000000"
+
+	// Uncovered: every open timeslot is a gap.
+	store.assignments[q.ID] = map[int]map[int]string{tomorrow: {10: "ta@example.com"}}
+	gaps := coverageGapsRequest(t, s, store, q, tomorrow)
+	var timeslots []int
+	for _, g := range gaps {
+		timeslots = append(timeslots, g.Timeslot)
+	}
+	if !reflect.DeepEqual(timeslots, []int{9, 10, 11, 12}) {
+		t.Fatalf("got gaps at %v, want 9 through 12", timeslots)
+	}
+	if gaps[1].AssignedTo != "ta@example.com" || gaps[1].Capacity != 2 {
+		t.Errorf("got gap %+v, want capacity 2 assigned to ta@example.com", gaps[1])
+	}
+
+	// Partially covered: a claim covers its timeslot, and a student
+	// booking doesn't count as coverage.
+	store.claim(q, tomorrow, 9, "ta@example.com")
+	store.book(q, tomorrow, 11, "student@example.com")
+	timeslots = nil
+	for _, g := range coverageGapsRequest(t, s, store, q, tomorrow) {
+		timeslots = append(timeslots, g.Timeslot)
+	}
+	if !reflect.DeepEqual(timeslots, []int{10, 11, 12}) {
+		t.Fatalf("got gaps at %v, want 10 through 12", timeslots)
+	}
+
+	// Fully covered, with one claim spanning two timeslots.
+	store.claim(q, tomorrow, 10, "ta@example.com")
+	span := store.claim(q, tomorrow, 11, "ta@example.com")
+	span.SlotSpan = 2
+	gaps = coverageGapsRequest(t, s, store, q, tomorrow)
+	if len(gaps) != 0 {
+		t.Errorf("got %d gaps on a covered day, want none", len(gaps))
+	}
+}
+
+func TestSignupCutoff(t *testing.T) {
+	now := time.Now().Local()
+	if now.Hour() == 0 && now.Minute() < 2 {
+		t.Skip("today's cutoff hasn't passed yet")
+	}
+
+	s := newTestServer()
+	store := newFakeStore()
+	q := store.addQueue(&QueueConfiguration{})
+	today := int(now.Weekday())
+	tomorrow := int(now.Add(24 * time.Hour).Weekday())
+	store.schedules[q.ID][today].SignupCutoff = 1
+	store.schedules[q.ID][tomorrow].SignupCutoff = 1
+
+	// Today's first timeslot started at midnight, so signups closed a
+	// minute after that, even for timeslots still to come.
+	w := signupRequest(s, store, q, today, "student@example.com", 23, 1)
+	if w.Code != http.StatusForbidden {
+		t.Fatalf("got status %d after the cutoff, want %d: %s", w.Code, http.StatusForbidden, w.Body.String())
+	}
+	var body ErrorMessage
+	err := json.NewDecoder(w.Body).Decode(&body)
+	if err != nil {
+		t.Fatalf("failed to decode error response: %v", err)
+	}
+	if want := "Signups for " + time.Weekday(today).String() + " closed at 12:01 AM."; body.Message != want {
+		t.Errorf("got message %q, want %q", body.Message, want)
+	}
+
+	// Tomorrow hasn't started, so it's still before the cutoff.
+	w = signupRequest(s, store, q, tomorrow, "student@example.com", 10, 1)
+	if w.Code != http.StatusCreated {
+		t.Errorf("got status %d before the cutoff, want %d: %s", w.Code, http.StatusCreated, w.Body.String())
+	}
+}
+
+// shiftDayRequest moves everything on day over to target as staff.
+func shiftDayRequest(s *Server, store *fakeStore, q *Queue, day, target int) *httptest.ResponseRecorder {
+	encoded, _ := json.Marshal(map[string]interface{}{"target_day": target})
+	r, _ := newTestRequest(http.MethodPost, "/", strings.NewReader(string(encoded)), map[string]interface{}{
+		queueContextKey:          q,
+		appointmentDayContextKey: day,
+		emailContextKey:          "admin@example.com",
+	})
+	w := httptest.NewRecorder()
+	s.ShiftDayAppointments(store).ServeHTTP(w, r)
+	return w
+}
+
+func TestShiftDayAppointments(t *testing.T) {
+	s := newTestServer()
+	store := newFakeStore()
+	q := store.addQueue(&QueueConfiguration{})
+	tomorrow := int(time.Now().Local().Add(24 * time.Hour).Weekday())
+	later := int(time.Now().Local().Add(48 * time.Hour).Weekday())
+	targetStart, _ := WeekdayBounds(later)
+
+	first := store.book(q, tomorrow, 10, "student@example.com")
+	second := store.book(q, tomorrow, 11, "student@example.com")
+	other := store.book(q, tomorrow, 12, "other@example.com")
+	claim := store.claim(q, tomorrow, 13, "ta@example.com")
+	claimTime := claim.ScheduledTime
+
+	w := shiftDayRequest(s, store, q, tomorrow, later)
+	if w.Code != http.StatusNoContent {
+		t.Fatalf("got status %d, want %d: %s", w.Code, http.StatusNoContent, w.Body.String())
+	}
+
+	for _, a := range []*AppointmentSlot{first, second, other} {
+		want := SlotStart(targetStart, a.Timeslot, store.schedules[q.ID][later])
+		if !a.ScheduledTime.Equal(want) {
+			t.Errorf("appointment at timeslot %d is at %s, want %s", a.Timeslot, a.ScheduledTime, want)
+		}
+	}
+	if !claim.ScheduledTime.Equal(claimTime) {
+		t.Errorf("bare claim moved to %s, want it left at %s", claim.ScheduledTime, claimTime)
+	}
+
+	// One message per student, however many appointments they had.
+	if len(store.messages) != 2 {
+		t.Errorf("sent %d messages, want 2", len(store.messages))
+	}
+}
+
+func TestShiftDayAppointmentsConflict(t *testing.T) {
+	s := newTestServer()
+	store := newFakeStore()
+	q := store.addQueue(&QueueConfiguration{})
+	tomorrow := int(time.Now().Local().Add(24 * time.Hour).Weekday())
+	later := int(time.Now().Local().Add(48 * time.Hour).Weekday())
+
+	fits := store.book(q, tomorrow, 9, "fits@example.com")
+	clashes := store.book(q, tomorrow, 10, "student@example.com")
+	store.book(q, later, 10, "already@example.com")
+	fitsTime, clashesTime := fits.ScheduledTime, clashes.ScheduledTime
+
+	w := shiftDayRequest(s, store, q, tomorrow, later)
+	if w.Code != http.StatusConflict {
+		t.Fatalf("got status %d, want %d: %s", w.Code, http.StatusConflict, w.Body.String())
+	}
+
+	// Nothing moves if anything can't.
+	if !fits.ScheduledTime.Equal(fitsTime) || !clashes.ScheduledTime.Equal(clashesTime) {
+		t.Error("appointments moved even though the shift failed")
+	}
+	if len(store.messages) != 0 {
+		t.Errorf("sent %d messages for a failed shift", len(store.messages))
+	}
+}
+
+// myAppointmentsRequest gets email's own appointments on day.
+func myAppointmentsRequest(t *testing.T, s *Server, store *fakeStore, q *Queue, day int, email string) []*AppointmentSlot {
+	t.Helper()
+	r, _ := newTestRequest(http.MethodGet, "/", nil, map[string]interface{}{
+		queueContextKey:          q,
+		appointmentDayContextKey: day,
+		emailContextKey:          email,
+	})
+	w := httptest.NewRecorder()
+	s.GetAppointmentsForCurrentUser(store).ServeHTTP(w, r)
+	if w.Code != http.StatusOK {
+		t.Fatalf("got status %d, want %d: %s", w.Code, http.StatusOK, w.Body.String())
+	}
+
+	var appointments []*AppointmentSlot
+	err := json.NewDecoder(w.Body).Decode(&appointments)
+	if err != nil {
+		t.Fatalf("failed to decode response: %v", err)
+	}
+	return appointments
+}
+
+func TestTimeslotGroup(t *testing.T) {
+	for _, show := range []bool{false, true} {
+		s := newTestServer()
+		store := newFakeStore()
+		q := store.addQueue(&QueueConfiguration{AppointmentSettings: AppointmentSettings{ShowTimeslotMembers: show}})
+		tomorrow := int(time.Now().Local().Add(24 * time.Hour).Weekday())
+		store.schedules[q.ID][tomorrow].Schedule = strings.Repeat("4", 24)
+
+		first := store.book(q, tomorrow, 10, "first@example.com")
+		name := "First"
+		first.Name = &name
+		store.book(q, tomorrow, 10, "student@example.com")
+		anonymous := store.book(q, tomorrow, 10, "anonymous@example.com")
+		anonymous.AnonymousToPeers = true
+		store.book(q, tomorrow, 11, "elsewhere@example.com")
+
+		// KSUIDs only go down to the second, so make sure they sort in
+		// the order the appointments were booked.
+		for i, a := range store.appointments {
+			a.ID, _ = ksuid.NewRandomWithTime(time.Now().Add(time.Duration(i) * time.Second))
+		}
+
+		appointments := myAppointmentsRequest(t, s, store, q, tomorrow, "student@example.com")
+		if len(appointments) != 1 || appointments[0].Group == nil {
+			t.Fatalf("got appointments %v, want the student's one with a group", appointments)
+		}
+		group := appointments[0].Group
+		if group.Size != 3 || group.Position != 2 {
+			t.Errorf("got size %d and position %d, want 3 and 2", group.Size, group.Position)
+		}
+
+		var want []string
+		if show {
+			want = []string{"First", anonymousPeerName}
+		}
+		if !reflect.DeepEqual(group.Members, want) {
+			t.Errorf("got members %v with show_timeslot_members %t, want %v", group.Members, show, want)
+		}
+	}
+}
+
+func TestAppointmentDashboard(t *testing.T) {
+	s := newTestServer()
+	store := newFakeStore()
+	q := store.addQueue(&QueueConfiguration{})
+	today := int(time.Now().Local().Weekday())
+	store.schedules[q.ID][today].Schedule = "000000000022000000000000"
+	store.book(q, today, 10, "student@example.com")
+	store.claim(q, today, 10, "ta@example.com")
+	store.assignments[q.ID] = map[int]map[int]string{today: {11: "ta@example.com"}}
+
+	r, _ := newTestRequest(http.MethodGet, "/", nil, map[string]interface{}{
+		queueContextKey: q,
+	})
+	w := httptest.NewRecorder()
+	s.GetAppointmentDashboard(store).ServeHTTP(w, r)
+	if w.Code != http.StatusOK {
+		t.Fatalf("got status %d, want %d: %s", w.Code, http.StatusOK, w.Body.String())
+	}
+
+	var dashboard AppointmentDashboard
+	err := json.NewDecoder(w.Body).Decode(&dashboard)
+	if err != nil {
+		t.Fatalf("failed to decode response: %v", err)
+	}
+
+	if dashboard.Day != today {
+		t.Errorf("got day %d, want %d", dashboard.Day, today)
+	}
+	if dashboard.Schedule == nil || dashboard.Schedule.Schedule != store.schedules[q.ID][today].Schedule {
+		t.Errorf("got schedule %+v, want today's", dashboard.Schedule)
+	}
+
+	open := make(map[int]int)
+	for _, a := range dashboard.Availability {
+		if a.Open == nil {
+			t.Fatalf("got availability %+v without counts", a)
+		}
+		if *a.Open > 0 {
+			open[a.Timeslot] = *a.Open
+		}
+	}
+	if want := map[int]int{10: 1, 11: 2}; !reflect.DeepEqual(open, want) {
+		t.Errorf("got open slots %v, want %v", open, want)
+	}
+
+	if len(dashboard.CoverageGaps) != 1 || dashboard.CoverageGaps[0].Timeslot != 11 {
+		t.Fatalf("got coverage gaps %v, want just timeslot 11", dashboard.CoverageGaps)
+	}
+	if dashboard.CoverageGaps[0].AssignedTo != "ta@example.com" {
+		t.Errorf("got gap assigned to %q, want ta@example.com", dashboard.CoverageGaps[0].AssignedTo)
+	}
+}
+
+func TestSignupsOpen(t *testing.T) {
+	s := newTestServer()
+	store := newFakeStore()
+	q := store.addQueue(&QueueConfiguration{})
+	tomorrow := int(time.Now().Local().Add(24 * time.Hour).Weekday())
+
+	// Signups for tomorrow open at noon today.
+	store.schedules[q.ID][tomorrow].SignupsOpen = 12 * 60
+	dayStart, _ := WeekdayBounds(tomorrow)
+	opensAt := dayStart.Add(-12 * time.Hour)
+
+	s.now = func() time.Time { return opensAt.Add(-time.Second) }
+	w := signupRequest(s, store, q, tomorrow, "student@example.com", 10, 1)
+	if w.Code != http.StatusForbidden {
+		t.Fatalf("got status %d just before signups opened, want %d: %s", w.Code, http.StatusForbidden, w.Body.String())
+	}
+	var body ErrorMessage
+	err := json.NewDecoder(w.Body).Decode(&body)
+	if err != nil {
+		t.Fatalf("failed to decode error response: %v", err)
+	}
+	if !strings.Contains(body.Message, opensAt.Format("Monday at 3:04 PM")) {
+		t.Errorf("got message %q, want it to say signups open %s", body.Message, opensAt.Format("Monday at 3:04 PM"))
+	}
+	if len(store.appointments) != 0 {
+		t.Fatalf("got %d stored appointments before signups opened, want 0", len(store.appointments))
+	}
+
+	s.now = func() time.Time { return opensAt.Add(time.Second) }
+	w = signupRequest(s, store, q, tomorrow, "student@example.com", 10, 1)
+	if w.Code != http.StatusCreated {
+		t.Errorf("got status %d just after signups opened, want %d: %s", w.Code, http.StatusCreated, w.Body.String())
+	}
+}
+
+// claimRequest has email claim timeslot on day.
+func claimRequest(s *Server, store *fakeStore, q *Queue, day, timeslot int, email string) *httptest.ResponseRecorder {
+	r, _ := newTestRequest(http.MethodPut, "/", nil, map[string]interface{}{
+		queueContextKey:               q,
+		appointmentDayContextKey:      day,
+		appointmentTimeslotContextKey: timeslot,
+		emailContextKey:               email,
+	})
+	w := httptest.NewRecorder()
+	s.ClaimTimeslot(store).ServeHTTP(w, r)
+	return w
+}
+
+func TestClaimHistory(t *testing.T) {
+	s := newTestServer()
+	store := newFakeStore()
+	q := store.addQueue(&QueueConfiguration{})
+	tomorrow := int(time.Now().Local().Add(24 * time.Hour).Weekday())
+	booked := store.book(q, tomorrow, 11, "student@example.com")
+
+	for _, timeslot := range []int{10, 11} {
+		w := claimRequest(s, store, q, tomorrow, timeslot, "ta@example.com")
+		if w.Code != http.StatusCreated {
+			t.Fatalf("got status %d claiming timeslot %d, want %d: %s", w.Code, timeslot, http.StatusCreated, w.Body.String())
+		}
+	}
+
+	// Another staff member takes the student's appointment off the TA.
+	loaded := *store.appointment(booked.ID)
+	r, _ := newTestRequest(http.MethodDelete, "/", nil, map[string]interface{}{
+		queueContextKey:       q,
+		appointmentContextKey: &loaded,
+		emailContextKey:       "head@example.com",
+	})
+	w := httptest.NewRecorder()
+	s.UnclaimAppointment(store).ServeHTTP(w, r)
+	if w.Code >= 300 {
+		t.Fatalf("got status %d unclaiming, want success: %s", w.Code, w.Body.String())
+	}
+
+	r, _ = newTestRequest(http.MethodGet, "/", nil, map[string]interface{}{
+		queueContextKey:          q,
+		appointmentDayContextKey: tomorrow,
+	})
+	w = httptest.NewRecorder()
+	s.GetClaimHistory(store).ServeHTTP(w, r)
+	if w.Code != http.StatusOK {
+		t.Fatalf("got status %d, want %d: %s", w.Code, http.StatusOK, w.Body.String())
+	}
+	var events []*ClaimEvent
+	err := json.NewDecoder(w.Body).Decode(&events)
+	if err != nil {
+		t.Fatalf("failed to decode response: %v", err)
+	}
+
+	type event struct {
+		timeslot          int
+		action            ClaimAction
+		email, staffEmail string
+	}
+	want := []event{
+		{10, ClaimActionClaim, "ta@example.com", "ta@example.com"},
+		{11, ClaimActionClaim, "ta@example.com", "ta@example.com"},
+		{11, ClaimActionUnclaim, "head@example.com", "ta@example.com"},
+	}
+	var got []event
+	for _, e := range events {
+		got = append(got, event{e.Timeslot, e.Action, e.Email, e.StaffEmail})
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got events %v, want %v", got, want)
+	}
+	if store.appointment(booked.ID).StaffEmail != nil {
+		t.Error("student's appointment still claimed after unclaiming")
+	}
+	if len(events) == 3 && events[2].Appointment != booked.ID {
+		t.Errorf("got unclaim of %s, want %s", events[2].Appointment, booked.ID)
+	}
+}
+
+func TestRequestBodyTooLarge(t *testing.T) {
+	s := newTestServer()
+	s.maxBodySize = 1024
+	store := newFakeStore()
+	q := store.addQueue(&QueueConfiguration{})
+	tomorrow := int(time.Now().Local().Add(24 * time.Hour).Weekday())
+	huge := strings.Repeat("a", 2048)
+
+	checkTooLarge := func(name string, w *httptest.ResponseRecorder) {
+		t.Helper()
+		if w.Code != http.StatusRequestEntityTooLarge {
+			t.Errorf("%s: got status %d, want %d: %s", name, w.Code, http.StatusRequestEntityTooLarge, w.Body.String())
+		}
+	}
+
+	checkTooLarge("signup", signupBodyRequest(s, store, q, tomorrow, "student@example.com", 10, map[string]interface{}{
+		"location":    "Here",
+		"description": huge,
+	}))
+	if len(store.appointments) != 0 {
+		t.Errorf("got %d appointments after an over-limit signup, want none", len(store.appointments))
+	}
+
+	a := store.book(q, tomorrow, 10, "student@example.com")
+	loaded := *a
+	loaded.Description = &huge
+	checkTooLarge("update", rescheduleRequest(t, s, store, q, &loaded, 11))
+	if a.Timeslot != 10 {
+		t.Errorf("appointment moved to timeslot %d after an over-limit update", a.Timeslot)
+	}
+
+	schedule := *store.schedules[q.ID][tomorrow]
+	schedule.Schedule = huge
+	checkTooLarge("schedule", scheduleRequest(s, store, q, tomorrow, &schedule))
+	if store.schedules[q.ID][tomorrow].Schedule == huge {
+		t.Error("schedule changed after an over-limit update")
+	}
+
+	// Under the limit is fine.
+	w := rescheduleRequest(t, s, store, q, a, 11)
+	if w.Code != http.StatusCreated {
+		t.Errorf("got status %d for a normal update, want %d: %s", w.Code, http.StatusCreated, w.Body.String())
+	}
+}
+
+func TestOptionalAppointmentLocation(t *testing.T) {
+	s := newTestServer()
+	store := newFakeStore()
+	required := store.addQueue(&QueueConfiguration{})
+	optional := store.addQueue(&QueueConfiguration{AppointmentSettings: AppointmentSettings{OptionalAppointmentLocation: true}})
+	tomorrow := int(time.Now().Local().Add(24 * time.Hour).Weekday())
+	noLocation := map[string]interface{}{"description": "Help"}
+
+	w := signupBodyRequest(s, store, required, tomorrow, "student@example.com", 10, noLocation)
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("got status %d without a location by default, want %d: %s", w.Code, http.StatusBadRequest, w.Body.String())
+	}
+
+	w = signupBodyRequest(s, store, optional, tomorrow, "student@example.com", 10, noLocation)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("got status %d without an optional location, want %d: %s", w.Code, http.StatusCreated, w.Body.String())
+	}
+	if len(store.appointments) != 1 || store.appointments[0].Location == nil || *store.appointments[0].Location != "" {
+		t.Fatalf("got appointments %v, want one with an empty location", store.appointments)
+	}
+
+	// Still needs a description, though.
+	w = signupBodyRequest(s, store, optional, tomorrow, "other@example.com", 11, map[string]interface{}{"location": "Here"})
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("got status %d without a description, want %d: %s", w.Code, http.StatusBadRequest, w.Body.String())
+	}
+
+	// Updating keeps the location optional.
+	a := *store.appointments[0]
+	w = rescheduleRequest(t, s, store, optional, &a, 12)
+	if w.Code != http.StatusCreated {
+		t.Errorf("got status %d updating without a location, want %d: %s", w.Code, http.StatusCreated, w.Body.String())
+	}
+}
+
+// approveRequest has email approve the pending schedule change for day.
+func approveRequest(s *Server, store *fakeStore, q *Queue, day int, email string) *httptest.ResponseRecorder {
+	r, _ := newTestRequest(http.MethodPost, "/", nil, map[string]interface{}{
+		queueContextKey:          q,
+		appointmentDayContextKey: day,
+		emailContextKey:          email,
+		courseAdminContextKey:    true,
+	})
+	w := httptest.NewRecorder()
+	s.ApproveScheduleChange(store).ServeHTTP(w, r)
+	return w
+}
+
+func TestScheduleChangeNeedsApproval(t *testing.T) {
+	s := newTestServer()
+	store := newFakeStore()
+	q := store.addQueue(&QueueConfiguration{AppointmentSettings: AppointmentSettings{RequireScheduleApproval: true}})
+
+	tomorrow := int(time.Now().Local().Add(24 * time.Hour).Weekday())
+	before := store.schedules[q.ID][tomorrow].Schedule
+	schedule := *store.schedules[q.ID][tomorrow]
+	schedule.Schedule = strings.Repeat("2", 24)
+
+	w := scheduleRequest(s, store, q, tomorrow, &schedule)
+	if w.Code != http.StatusAccepted {
+		t.Fatalf("got status %d, want %d: %s", w.Code, http.StatusAccepted, w.Body.String())
+	}
+	var pending PendingScheduleChange
+	err := json.NewDecoder(w.Body).Decode(&pending)
+	if err != nil {
+		t.Fatalf("failed to decode response: %v", err)
+	}
+	if pending.Schedule != schedule.Schedule {
+		t.Errorf("got pending schedule %s, want %s", pending.Schedule, schedule.Schedule)
+	}
+	if pending.ProposedBy != "admin@example.com" {
+		t.Errorf("got proposer %s, want admin@example.com", pending.ProposedBy)
+	}
+	if store.schedules[q.ID][tomorrow].Schedule != before {
+		t.Error("schedule changed without approval")
+	}
+	if len(store.auditLog) != 0 {
+		t.Errorf("got %d audit entries, want none", len(store.auditLog))
+	}
+
+	// Whoever proposed it can't approve it.
+	w = approveRequest(s, store, q, tomorrow, "admin@example.com")
+	if w.Code != http.StatusForbidden {
+		t.Errorf("got status %d for self-approval, want %d", w.Code, http.StatusForbidden)
+	}
+	if store.schedules[q.ID][tomorrow].Schedule != before {
+		t.Error("schedule changed on self-approval")
+	}
+	if _, ok := store.pendingChanges[q.ID][tomorrow]; !ok {
+		t.Fatal("pending change removed on self-approval")
+	}
+
+	w = approveRequest(s, store, q, tomorrow, "second@example.com")
+	if w.Code != http.StatusNoContent {
+		t.Fatalf("got status %d, want %d: %s", w.Code, http.StatusNoContent, w.Body.String())
+	}
+	if store.schedules[q.ID][tomorrow].Schedule != schedule.Schedule {
+		t.Errorf("got schedule %s after approval, want %s", store.schedules[q.ID][tomorrow].Schedule, schedule.Schedule)
+	}
+	if _, ok := store.pendingChanges[q.ID][tomorrow]; ok {
+		t.Error("pending change left behind after approval")
+	}
+	if len(store.auditLog) != 1 {
+		t.Fatalf("got %d audit entries, want 1", len(store.auditLog))
+	}
+	if store.auditLog[0].Email != "second@example.com" {
+		t.Errorf("got audit entry by %s, want the approver", store.auditLog[0].Email)
+	}
+
+	// Nothing's left to approve.
+	w = approveRequest(s, store, q, tomorrow, "second@example.com")
+	if w.Code != http.StatusNotFound {
+		t.Errorf("got status %d approving twice, want %d", w.Code, http.StatusNotFound)
+	}
+}
+
+func TestScheduleChangeApprovalRechecksAppointments(t *testing.T) {
+	s := newTestServer()
+	store := newFakeStore()
+	q := store.addQueue(&QueueConfiguration{AppointmentSettings: AppointmentSettings{RequireScheduleApproval: true}})
+
+	tomorrow := int(time.Now().Local().Add(24 * time.Hour).Weekday())
+	before := store.schedules[q.ID][tomorrow].Schedule
+	schedule := *store.schedules[q.ID][tomorrow]
+	schedule.Schedule = strings.Repeat("1", 10) + "0" + strings.Repeat("1", 13)
+
+	w := scheduleRequest(s, store, q, tomorrow, &schedule)
+	if w.Code != http.StatusAccepted {
+		t.Fatalf("got status %d, want %d: %s", w.Code, http.StatusAccepted, w.Body.String())
+	}
+
+	// Someone books the timeslot the change would close while it waits.
+	store.book(q, tomorrow, 10, "student@example.com")
+	w = approveRequest(s, store, q, tomorrow, "second@example.com")
+	if w.Code != http.StatusConflict {
+		t.Errorf("got status %d, want %d", w.Code, http.StatusConflict)
+	}
+	if store.schedules[q.ID][tomorrow].Schedule != before {
+		t.Error("schedule changed despite removing a booked timeslot")
+	}
+}
+
+func TestAnonymousToPeers(t *testing.T) {
+	s := newTestServer()
+	store := newFakeStore()
+	q := store.addQueue(&QueueConfiguration{AppointmentSettings: AppointmentSettings{ShowTimeslotMembers: true}})
+	tomorrow := int(time.Now().Local().Add(24 * time.Hour).Weekday())
+	store.schedules[q.ID][tomorrow].Schedule = strings.Repeat("2", 24)
+
+	w := signupBodyRequest(s, store, q, tomorrow, "anonymous@example.com", 10, map[string]interface{}{
+		"slot_span":          1,
+		"location":           "Here",
+		"description":        "Help",
+		"anonymous_to_peers": true,
+	})
+	if w.Code != http.StatusCreated {
+		t.Fatalf("got status %d, want %d: %s", w.Code, http.StatusCreated, w.Body.String())
+	}
+	store.book(q, tomorrow, 10, "student@example.com")
+
+	// Other students see who else is there, but not who asked to stay
+	// anonymous.
+	appointments := myAppointmentsRequest(t, s, store, q, tomorrow, "student@example.com")
+	if len(appointments) != 1 || appointments[0].Group == nil {
+		t.Fatalf("got appointments %v, want the student's one with a group", appointments)
+	}
+	if want := []string{anonymousPeerName}; !reflect.DeepEqual(appointments[0].Group.Members, want) {
+		t.Errorf("got members %v for peer, want %v", appointments[0].Group.Members, want)
+	}
+
+	// Staff still see who it is.
+	var found bool
+	for _, a := range appointmentsRequest(t, s, store, q, tomorrow, true, "") {
+		if a.StudentEmail == nil || *a.StudentEmail != "anonymous@example.com" {
+			continue
+		}
+		found = true
+		if !a.AnonymousToPeers {
+			t.Error("got appointment without anonymous_to_peers for admin")
+		}
+		if a.Name == nil || *a.Name != "Student" {
+			t.Errorf("got name %v for admin, want Student", a.Name)
+		}
+	}
+	if !found {
+		t.Error("anonymous student's appointment missing for admin")
+	}
+}
+
+func TestSignupBreakVersusFull(t *testing.T) {
+	s := newTestServer()
+	store := newFakeStore()
+	q := store.addQueue(&QueueConfiguration{})
+	tomorrow := int(time.Now().Local().Add(24 * time.Hour).Weekday())
+	store.schedules[q.ID][tomorrow].Schedule = strings.Repeat("1", 12) + "0" + strings.Repeat("1", 11)
+	store.book(q, tomorrow, 10, "other@example.com")
+
+	tests := []struct {
+		name     string
+		timeslot int
+		span     int
+		code     string
+	}{
+		{"break", 12, 1, timeslotBreak},
+		{"span into break", 11, 2, timeslotBreak},
+		{"full", 10, 1, ""},
+	}
+	for _, test := range tests {
+		w := signupRequest(s, store, q, tomorrow, "student@example.com", test.timeslot, test.span)
+		if w.Code != http.StatusConflict {
+			t.Errorf("%s: got status %d, want %d", test.name, w.Code, http.StatusConflict)
+			continue
+		}
+
+		var body ErrorMessage
+		err := json.NewDecoder(w.Body).Decode(&body)
+		if err != nil {
+			t.Fatalf("%s: failed to decode error response: %v", test.name, err)
+		}
+		if body.Code != test.code {
+			t.Errorf("%s: got code %q, want %q", test.name, body.Code, test.code)
+		}
+	}
+	if len(store.appointments) != 1 {
+		t.Errorf("got %d stored appointments, want 1", len(store.appointments))
+	}
+}
+
+func timeslotDetailRequest(t *testing.T, s *Server, store *fakeStore, q *Queue, day, timeslot int, admin bool) *TimeslotDetail {
+	t.Helper()
+	r, _ := newTestRequest(http.MethodGet, "/", nil, map[string]interface{}{
+		queueContextKey:               q,
+		appointmentDayContextKey:      day,
+		appointmentTimeslotContextKey: timeslot,
+		courseAdminContextKey:         admin,
+	})
+	w := httptest.NewRecorder()
+	s.GetTimeslotDetail(store).ServeHTTP(w, r)
+	if w.Code != http.StatusOK {
+		t.Fatalf("got status %d, want %d: %s", w.Code, http.StatusOK, w.Body.String())
+	}
+
+	var detail TimeslotDetail
+	err := json.NewDecoder(w.Body).Decode(&detail)
+	if err != nil {
+		t.Fatalf("failed to decode response: %v", err)
+	}
+	return &detail
+}
+
+func TestTimeslotDetail(t *testing.T) {
+	s := newTestServer()
+	store := newFakeStore()
+	q := store.addQueue(&QueueConfiguration{})
+	tomorrow := int(time.Now().Local().Add(24 * time.Hour).Weekday())
+	store.schedules[q.ID][tomorrow].Schedule = strings.Repeat("3", 24)
+	claimed := store.book(q, tomorrow, 10, "student@example.com")
+	staff := "ta@example.com"
+	claimed.StaffEmail = &staff
+	store.book(q, tomorrow, 10, "other@example.com")
+	store.claim(q, tomorrow, 10, "other-ta@example.com")
+	store.book(q, tomorrow, 11, "elsewhere@example.com")
+
+	for _, admin := range []bool{false, true} {
+		detail := timeslotDetailRequest(t, s, store, q, tomorrow, 10, admin)
+		if detail.TimeslotAvailability == nil || detail.Timeslot != 10 {
+			t.Fatalf("got availability %+v, want timeslot 10's", detail.TimeslotAvailability)
+		}
+		if detail.Capacity == nil || *detail.Capacity != 3 || detail.Taken == nil || *detail.Taken != 2 {
+			t.Errorf("got capacity %v and taken %v with admin %t, want 3 and 2", detail.Capacity, detail.Taken, admin)
+		}
+		if detail.Claims != 2 {
+			t.Errorf("got %d claims with admin %t, want 2", detail.Claims, admin)
+		}
+
+		// Only staff get to see who's signed up.
+		if !admin && detail.Appointments != nil {
+			t.Errorf("got appointments %v for student, want none", detail.Appointments)
+		}
+		if admin && len(detail.Appointments) != 3 {
+			t.Errorf("got %d appointments for admin, want 3", len(detail.Appointments))
+		}
+	}
+
+	r, _ := newTestRequest(http.MethodGet, "/", nil, map[string]interface{}{
+		queueContextKey:               q,
+		appointmentDayContextKey:      tomorrow,
+		appointmentTimeslotContextKey: 24,
+		courseAdminContextKey:         false,
+	})
+	w := httptest.NewRecorder()
+	s.GetTimeslotDetail(store).ServeHTTP(w, r)
+	if w.Code != http.StatusNotFound {
+		t.Errorf("got status %d for non-existent timeslot, want %d", w.Code, http.StatusNotFound)
+	}
+}
+
+func TestWhitespaceAppointmentFields(t *testing.T) {
+	s := newTestServer()
+	store := newFakeStore()
+	q := store.addQueue(&QueueConfiguration{})
+	tomorrow := int(time.Now().Local().Add(24 * time.Hour).Weekday())
+
+	for _, field := range []string{"location", "description"} {
+		body := map[string]interface{}{
+			"slot_span":   1,
+			"location":    "Here",
+			"description": "Help",
+		}
+		body[field] = " \t "
+		w := signupBodyRequest(s, store, q, tomorrow, "student@example.com", 10, body)
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("got status %d with blank %s, want %d", w.Code, field, http.StatusBadRequest)
+		}
+	}
+
+	// The name comes from the login rather than the body.
+	encoded, _ := json.Marshal(map[string]interface{}{"slot_span": 1, "location": "Here", "description": "Help"})
+	r, _ := newTestRequest(http.MethodPost, "/", strings.NewReader(string(encoded)), map[string]interface{}{
+		queueContextKey:               q,
+		appointmentDayContextKey:      tomorrow,
+		appointmentTimeslotContextKey: 10,
+		emailContextKey:               "student@example.com",
+		nameContextKey:                "   ",
+		courseAdminContextKey:         false,
+	})
+	w := httptest.NewRecorder()
+	s.SignupForAppointment(store).ServeHTTP(w, r)
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("got status %d with blank name, want %d", w.Code, http.StatusBadRequest)
+	}
+	if len(store.appointments) != 0 {
+		t.Fatalf("got %d stored appointments, want none", len(store.appointments))
+	}
+
+	// Anything else just loses the whitespace around it.
+	w = signupBodyRequest(s, store, q, tomorrow, "student@example.com", 10, map[string]interface{}{
+		"slot_span":   1,
+		"location":    "  Here ",
+		"description": "Help\n",
+	})
+	if w.Code != http.StatusCreated {
+		t.Fatalf("got status %d, want %d: %s", w.Code, http.StatusCreated, w.Body.String())
+	}
+	if len(store.appointments) != 1 {
+		t.Fatalf("got %d stored appointments, want 1", len(store.appointments))
+	}
+	a := store.appointments[0]
+	if *a.Location != "Here" || *a.Description != "Help" {
+		t.Errorf("got location %q and description %q, want them trimmed", *a.Location, *a.Description)
+	}
+
+	// Updates are held to the same rules.
+	blank := *a
+	spaces := "  "
+	blank.Description = &spaces
+	w = rescheduleRequest(t, s, store, q, &blank, 11)
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("got status %d updating to blank description, want %d", w.Code, http.StatusBadRequest)
+	}
+	if moved := store.appointment(a.ID); moved == nil || moved.Timeslot != 10 {
+		t.Error("appointment changed after update with blank description")
+	}
+}
+
+func TestMaxAppointmentFieldLength(t *testing.T) {
+	s := newTestServer()
+	store := newFakeStore()
+	q := store.addQueue(&QueueConfiguration{AppointmentSettings: AppointmentSettings{MaxAppointmentFieldLength: 10}})
+	tomorrow := int(time.Now().Local().Add(24 * time.Hour).Weekday())
+
+	tests := []struct {
+		name        string
+		location    string
+		description string
+		status      int
+	}{
+		{"over on description", "Here", strings.Repeat("a", 11), http.StatusBadRequest},
+		{"over on location", strings.Repeat("a", 11), "Help", http.StatusBadRequest},
+		// Characters count, not bytes.
+		{"at limit", strings.Repeat("é", 10), strings.Repeat("a", 10), http.StatusCreated},
+	}
+	for _, test := range tests {
+		w := signupBodyRequest(s, store, q, tomorrow, "student@example.com", 10, map[string]interface{}{
+			"slot_span":   1,
+			"location":    test.location,
+			"description": test.description,
+		})
+		if w.Code != test.status {
+			t.Errorf("%s: got status %d, want %d: %s", test.name, w.Code, test.status, w.Body.String())
+		}
+	}
+	if len(store.appointments) != 1 {
+		t.Fatalf("got %d stored appointments, want 1", len(store.appointments))
+	}
+
+	// Updates have the same limit.
+	a := store.appointments[0]
+	long := *a
+	description := strings.Repeat("a", 11)
+	long.Description = &description
+	w := rescheduleRequest(t, s, store, q, &long, 11)
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("got status %d updating to long description, want %d", w.Code, http.StatusBadRequest)
+	}
+	if moved := store.appointment(a.ID); moved == nil || moved.Timeslot != 10 {
+		t.Error("appointment changed after update with long description")
+	}
+}
+
+// appointmentQueryCountingStore counts how many times a time frame's
+// appointments were looked up.
+type appointmentQueryCountingStore struct {
+	*fakeStore
+	queries int
+}
+
+func (c *appointmentQueryCountingStore) GetAppointments(ctx context.Context, queue ksuid.KSUID, from, to time.Time) ([]*AppointmentSlot, error) {
+	c.queries++
+	return c.fakeStore.GetAppointments(ctx, queue, from, to)
+}
+
+func TestWeeklyDemand(t *testing.T) {
+	s := newTestServer()
+	store := newFakeStore()
+	q := store.addQueue(&QueueConfiguration{})
+	for day := 0; day < 7; day++ {
+		store.schedules[q.ID][day].Schedule = strings.Repeat("2", 24)
+	}
+	tomorrow := int(time.Now().Local().Add(24 * time.Hour).Weekday())
+	later := int(time.Now().Local().Add(48 * time.Hour).Weekday())
+	store.book(q, tomorrow, 10, "first@example.com")
+	store.book(q, tomorrow, 10, "second@example.com")
+	store.book(q, later, 10, "third@example.com")
+	long := store.book(q, later, 14, "fourth@example.com")
+	long.SlotSpan = 2
+	store.claim(q, later, 20, "ta@example.com")
+
+	counting := &appointmentQueryCountingStore{fakeStore: store}
+	r, _ := newTestRequest(http.MethodGet, "/", nil, map[string]interface{}{
+		queueContextKey: q,
+	})
+	w := httptest.NewRecorder()
+	s.GetWeeklyDemand(counting).ServeHTTP(w, r)
+	if w.Code != http.StatusOK {
+		t.Fatalf("got status %d, want %d: %s", w.Code, http.StatusOK, w.Body.String())
+	}
+	if counting.queries != 1 {
+		t.Errorf("looked up appointments %d times, want once for the whole week", counting.queries)
+	}
+
+	var demand []*DayDemand
+	err := json.NewDecoder(w.Body).Decode(&demand)
+	if err != nil {
+		t.Fatalf("failed to decode response: %v", err)
+	}
+	if len(demand) != 7 {
+		t.Fatalf("got %d days, want 7", len(demand))
+	}
+
+	want := map[time.Weekday]map[int]int{
+		time.Weekday(tomorrow): {10: 2},
+		time.Weekday(later):    {10: 1, 14: 1, 15: 1},
+	}
+	for _, d := range demand {
+		if len(d.Timeslots) != 24 {
+			t.Errorf("got %d timeslots on day %d, want 24", len(d.Timeslots), d.Day)
+			continue
+		}
+		for _, ts := range d.Timeslots {
+			if *ts.Capacity != 2 {
+				t.Errorf("day %d timeslot %d: got capacity %d, want 2", d.Day, ts.Timeslot, *ts.Capacity)
+			}
+			if *ts.Taken != want[d.Day][ts.Timeslot] {
+				t.Errorf("day %d timeslot %d: got %d taken, want %d", d.Day, ts.Timeslot, *ts.Taken, want[d.Day][ts.Timeslot])
+			}
+		}
+	}
+}
+
+func TestClaimTimeslotOutOfRange(t *testing.T) {
+	s := newTestServer()
+	store := newFakeStore()
+	q := store.addQueue(&QueueConfiguration{})
+	tomorrow := int(time.Now().Local().Add(24 * time.Hour).Weekday())
+	later := int(time.Now().Local().Add(48 * time.Hour).Weekday())
+	delete(store.schedules[q.ID], later)
+
+	w := claimRequest(s, store, q, tomorrow, 23, "ta@example.com")
+	if w.Code != http.StatusCreated {
+		t.Fatalf("got status %d claiming last timeslot, want %d: %s", w.Code, http.StatusCreated, w.Body.String())
+	}
+
+	w = claimRequest(s, store, q, tomorrow, 24, "ta@example.com")
+	if w.Code != http.StatusNotFound {
+		t.Errorf("got status %d claiming past the end of the day, want %d", w.Code, http.StatusNotFound)
+	}
+	w = claimRequest(s, store, q, later, 10, "ta@example.com")
+	if w.Code != http.StatusNotFound {
+		t.Errorf("got status %d claiming on a day with no schedule, want %d", w.Code, http.StatusNotFound)
+	}
+	if len(store.appointments) != 1 {
+		t.Errorf("got %d stored appointments, want just the valid claim", len(store.appointments))
+	}
+}
+
+func timeslotNoteRequest(s *Server, store *fakeStore, q *Queue, day, timeslot int, note string) *httptest.ResponseRecorder {
+	encoded, _ := json.Marshal(map[string]string{"note": note})
+	r, _ := newTestRequest(http.MethodPut, "/", strings.NewReader(string(encoded)), map[string]interface{}{
+		queueContextKey:               q,
+		appointmentDayContextKey:      day,
+		appointmentTimeslotContextKey: timeslot,
+		emailContextKey:               "admin@example.com",
+		courseAdminContextKey:         true,
+	})
+	w := httptest.NewRecorder()
+	s.SetTimeslotNote(store).ServeHTTP(w, r)
+	return w
+}
+
+func TestTimeslotNotes(t *testing.T) {
+	s := newTestServer()
+	store := newFakeStore()
+	q := store.addQueue(&QueueConfiguration{})
+	tomorrow := int(time.Now().Local().Add(24 * time.Hour).Weekday())
+
+	for _, timeslot := range []int{10, 11} {
+		w := timeslotNoteRequest(s, store, q, tomorrow, timeslot, "  Bring your laptop ")
+		if w.Code != http.StatusNoContent {
+			t.Fatalf("got status %d, want %d: %s", w.Code, http.StatusNoContent, w.Body.String())
+		}
+	}
+
+	// Students see it while booking.
+	day := appointmentDayRequest(t, s, store, q, tomorrow, "student@example.com", false)
+	if note := availabilityAt(t, day, 10).Note; note != "Bring your laptop" {
+		t.Errorf("got note %q on timeslot 10, want it trimmed", note)
+	}
+	if note := availabilityAt(t, day, 12).Note; note != "" {
+		t.Errorf("got note %q on timeslot 12, want none", note)
+	}
+
+	// An empty note clears it.
+	w := timeslotNoteRequest(s, store, q, tomorrow, 11, "")
+	if w.Code != http.StatusNoContent {
+		t.Fatalf("got status %d, want %d: %s", w.Code, http.StatusNoContent, w.Body.String())
+	}
+	day = appointmentDayRequest(t, s, store, q, tomorrow, "student@example.com", false)
+	if note := availabilityAt(t, day, 11).Note; note != "" {
+		t.Errorf("got note %q on timeslot 11 after clearing, want none", note)
+	}
+
+	w = timeslotNoteRequest(s, store, q, tomorrow, 24, "Nobody's here")
+	if w.Code != http.StatusNotFound {
+		t.Errorf("got status %d for non-existent timeslot, want %d", w.Code, http.StatusNotFound)
+	}
+	w = timeslotNoteRequest(s, store, q, tomorrow, 12, strings.Repeat("a", maxTimeslotNoteLength+1))
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("got status %d for long note, want %d", w.Code, http.StatusBadRequest)
+	}
+	if want := map[int]string{10: "Bring your laptop"}; !reflect.DeepEqual(store.schedules[q.ID][tomorrow].Notes, want) {
+		t.Errorf("got notes %v, want %v", store.schedules[q.ID][tomorrow].Notes, want)
+	}
+}
+
+func TestMinAppointmentDescriptionLength(t *testing.T) {
+	s := newTestServer()
+	store := newFakeStore()
+	q := store.addQueue(&QueueConfiguration{AppointmentSettings: AppointmentSettings{MinAppointmentDescriptionLength: 10}})
+	tomorrow := int(time.Now().Local().Add(24 * time.Hour).Weekday())
+
+	tests := []struct {
+		name        string
+		description string
+		status      int
+	}{
+		{"below", "help", http.StatusBadRequest},
+		// Padding doesn't count toward it.
+		{"padded", "   help    ", http.StatusBadRequest},
+		{"at", strings.Repeat("a", 10), http.StatusCreated},
+		{"above", "I'm stuck on the second part of the project", http.StatusCreated},
+	}
+	for i, test := range tests {
+		w := signupBodyRequest(s, store, q, tomorrow, strconv.Itoa(i)+"@example.com", 10+i, map[string]interface{}{
+			"slot_span":   1,
+			"location":    "Here",
+			"description": test.description,
+		})
+		if w.Code != test.status {
+			t.Errorf("%s: got status %d, want %d: %s", test.name, w.Code, test.status, w.Body.String())
+		}
+	}
+	if len(store.appointments) != 2 {
+		t.Fatalf("got %d stored appointments, want 2", len(store.appointments))
+	}
+
+	// Updates have the same minimum.
+	a := store.appointments[0]
+	short := *a
+	description := "help"
+	short.Description = &description
+	w := rescheduleRequest(t, s, store, q, &short, 20)
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("got status %d updating to short description, want %d", w.Code, http.StatusBadRequest)
+	}
+	if moved := store.appointment(a.ID); moved == nil || moved.Timeslot != a.Timeslot {
+		t.Error("appointment changed after update with short description")
+	}
+}
+
+func TestMinAppointmentDescriptionLengthOptional(t *testing.T) {
+	s := newTestServer()
+	store := newFakeStore()
+	q := store.addQueue(&QueueConfiguration{AppointmentSettings: AppointmentSettings{
+		MinAppointmentDescriptionLength: 10,
+		OptionalAppointmentDescription:  true,
+	}})
+	tomorrow := int(time.Now().Local().Add(24 * time.Hour).Weekday())
+
+	// Leaving it out is fine, but a description that's there still has
+	// to be long enough.
+	w := signupBodyRequest(s, store, q, tomorrow, "student@example.com", 10, map[string]interface{}{
+		"slot_span": 1,
+		"location":  "Here",
+	})
+	if w.Code != http.StatusCreated {
+		t.Errorf("got status %d without description, want %d: %s", w.Code, http.StatusCreated, w.Body.String())
+	}
+	w = signupBodyRequest(s, store, q, tomorrow, "other@example.com", 11, map[string]interface{}{
+		"slot_span":   1,
+		"location":    "Here",
+		"description": "help",
+	})
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("got status %d with short description, want %d", w.Code, http.StatusBadRequest)
+	}
+}
+
+func TestDescriptionTemplate(t *testing.T) {
+	s := newTestServer()
+	store := newFakeStore()
+	template := "What are you working on:\nWhat have you tried:\n"
+	q := store.addQueue(&QueueConfiguration{AppointmentSettings: AppointmentSettings{
+		DescriptionTemplate:        template,
+		RequireDescriptionTemplate: true,
+	}})
+	tomorrow := int(time.Now().Local().Add(24 * time.Hour).Weekday())
+
+	// Students get the template with the queue configuration, to prefill.
+	r, _ := newTestRequest(http.MethodGet, "/", nil, map[string]interface{}{queueContextKey: q})
+	w := httptest.NewRecorder()
+	s.GetQueueConfiguration(store).ServeHTTP(w, r)
+	var config QueueConfiguration
+	err := json.NewDecoder(w.Body).Decode(&config)
+	if err != nil {
+		t.Fatalf("failed to decode configuration: %v", err)
+	}
+	if config.DescriptionTemplate != template || !config.RequireDescriptionTemplate {
+		t.Errorf("got template %q (required %t), want %q (required)", config.DescriptionTemplate, config.RequireDescriptionTemplate, template)
+	}
+
+	tests := []struct {
+		name        string
+		description string
+		status      int
+	}{
+		{"untouched", template, http.StatusBadRequest},
+		{"one section", "What are you working on:\nProject 2\nWhat have you tried:\n", http.StatusBadRequest},
+		{"section left out", "What are you working on: Project 2", http.StatusBadRequest},
+		{"under headings", "What are you working on:\nProject 2\nWhat have you tried:\nPrinting things", http.StatusCreated},
+		{"same line", "what are you working on: Project 2\nWhat have you tried: printing things", http.StatusCreated},
+	}
+	for i, test := range tests {
+		w := signupBodyRequest(s, store, q, tomorrow, strconv.Itoa(i)+"@example.com", 10+i, map[string]interface{}{
+			"slot_span":   1,
+			"location":    "Here",
+			"description": test.description,
+		})
+		if w.Code != test.status {
+			t.Errorf("%s: got status %d, want %d: %s", test.name, w.Code, test.status, w.Body.String())
+		}
+	}
+	if len(store.appointments) != 2 {
+		t.Fatalf("got %d stored appointments, want 2", len(store.appointments))
+	}
+
+	// Updates have to fill it in too.
+	a := store.appointments[0]
+	emptied := *a
+	emptied.Description = &template
+	w = rescheduleRequest(t, s, store, q, &emptied, a.Timeslot)
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("got status %d updating to unfilled template, want %d", w.Code, http.StatusBadRequest)
+	}
+	if stored := store.appointment(a.ID); *stored.Description == template {
+		t.Error("appointment changed after update with unfilled template")
+	}
+}
+
+// updateBodyRequest runs UpdateAppointment for the appointment's student
+// with body as sent.
+func updateBodyRequest(s *Server, store *fakeStore, q *Queue, a *AppointmentSlot, body map[string]interface{}) *httptest.ResponseRecorder {
+	encoded, _ := json.Marshal(body)
+	r, _ := newTestRequest(http.MethodPut, "/", strings.NewReader(string(encoded)), map[string]interface{}{
+		queueContextKey:       q,
+		appointmentContextKey: a,
+		emailContextKey:       *a.StudentEmail,
+		nameContextKey:        *a.Name,
+		courseAdminContextKey: false,
+	})
+	w := httptest.NewRecorder()
+	s.UpdateAppointment(store).ServeHTTP(w, r)
+	return w
+}
+
+func TestUpdateIgnoresMismatchedTime(t *testing.T) {
+	s := newTestServer()
+	store := newFakeStore()
+	q := store.addQueue(&QueueConfiguration{})
+	tomorrow := int(time.Now().Local().Add(24 * time.Hour).Weekday())
+	a := store.book(q, tomorrow, 10, "student@example.com")
+	start, _ := WeekdayBounds(tomorrow)
+	grid := func(timeslot int) time.Time {
+		return SlotStart(start, timeslot, store.schedules[q.ID][tomorrow])
+	}
+	body := func(a *AppointmentSlot, timeslot int) map[string]interface{} {
+		return map[string]interface{}{
+			"timeslot":       timeslot,
+			"location":       "There",
+			"description":    "Help",
+			"scheduled_time": a.ScheduledTime.Add(3*time.Hour + 17*time.Minute),
+		}
+	}
+
+	// Staying in the same timeslot keeps the stored time.
+	loaded := *store.appointment(a.ID)
+	w := updateBodyRequest(s, store, q, &loaded, body(&loaded, 10))
+	if w.Code != http.StatusNoContent {
+		t.Fatalf("got status %d, want %d: %s", w.Code, http.StatusNoContent, w.Body.String())
+	}
+	if stored := store.appointment(a.ID); *stored.Location != "There" || !stored.ScheduledTime.Equal(grid(10)) {
+		t.Errorf("got %s at %s after update, want There at %s", *stored.Location, stored.ScheduledTime, grid(10))
+	}
+
+	// Moving gets the new timeslot's time from the grid.
+	loaded = *store.appointment(a.ID)
+	w = updateBodyRequest(s, store, q, &loaded, body(&loaded, 12))
+	if w.Code != http.StatusCreated {
+		t.Fatalf("got status %d, want %d: %s", w.Code, http.StatusCreated, w.Body.String())
+	}
+	var moved AppointmentSlot
+	err := json.NewDecoder(w.Body).Decode(&moved)
+	if err != nil {
+		t.Fatalf("failed to decode response: %v", err)
+	}
+	if moved.Timeslot != 12 || !moved.ScheduledTime.Equal(grid(12)) {
+		t.Errorf("got timeslot %d at %s, want timeslot 12 at %s", moved.Timeslot, moved.ScheduledTime, grid(12))
+	}
+	if stored := store.appointment(moved.ID); stored == nil || !stored.ScheduledTime.Equal(grid(12)) {
+		t.Errorf("stored appointment isn't at %s", grid(12))
+	}
+}
+
+func TestClaimGraceWindow(t *testing.T) {
+	now := time.Now().Local()
+	minutes := now.Hour()*60 + now.Minute()
+	if minutes < 15 || (minutes%5 == 4 && now.Second() >= 58) {
+		t.Skip("too close to midnight or the next timeslot")
+	}
+
+	s := newTestServer()
+	store := newFakeStore()
+	q := store.addQueue(&QueueConfiguration{AppointmentSettings: AppointmentSettings{ClaimGraceMinutes: 10}})
+	today := int(now.Weekday())
+	store.schedules[q.ID][today].Duration = 5
+	store.schedules[q.ID][today].Schedule = strings.Repeat("1", 24*60/5)
+	current := minutes / 5
+
+	tests := []struct {
+		name     string
+		timeslot int
+		status   int
+	}{
+		{"upcoming", current + 1, http.StatusCreated},
+		{"started", current, http.StatusCreated},
+		// Started 5 to 10 minutes ago.
+		{"inside grace", current - 1, http.StatusCreated},
+		// Started 10 to 15 minutes ago.
+		{"outside grace", current - 2, http.StatusBadRequest},
+	}
+	for _, test := range tests {
+		w := claimRequest(s, store, q, today, test.timeslot, "ta@example.com")
+		if w.Code != test.status {
+			t.Errorf("%s: got status %d, want %d: %s", test.name, w.Code, test.status, w.Body.String())
+		}
+	}
+
+	// Without any grace, a timeslot that's started is too late.
+	store.configs[q.ID].ClaimGraceMinutes = 0
+	w := claimRequest(s, store, q, today, current, "other-ta@example.com")
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("got status %d claiming started timeslot without grace, want %d", w.Code, http.StatusBadRequest)
+	}
+}
+
+func previewRequest(t *testing.T, s *Server, store *fakeStore, q *Queue, day int, schedule *AppointmentSchedule) *ScheduleDiff {
+	t.Helper()
+	encoded, _ := json.Marshal(schedule)
+	r, _ := newTestRequest(http.MethodPost, "/", strings.NewReader(string(encoded)), map[string]interface{}{
+		queueContextKey:          q,
+		appointmentDayContextKey: day,
+		emailContextKey:          "admin@example.com",
+		courseAdminContextKey:    true,
+	})
+	w := httptest.NewRecorder()
+	s.PreviewScheduleChange(store).ServeHTTP(w, r)
+	if w.Code != http.StatusOK {
+		t.Fatalf("got status %d, want %d: %s", w.Code, http.StatusOK, w.Body.String())
+	}
+
+	var diff ScheduleDiff
+	err := json.NewDecoder(w.Body).Decode(&diff)
+	if err != nil {
+		t.Fatalf("failed to decode response: %v", err)
+	}
+	return &diff
+}
+
+func TestPreviewScheduleChange(t *testing.T) {
+	tomorrow := int(time.Now().Local().Add(24 * time.Hour).Weekday())
+	start, _ := WeekdayBounds(tomorrow)
+	tests := []struct {
+		name     string
+		schedule string
+		duration int
+		// Timeslot to whether it conflicts.
+		changes  map[int]bool
+		canApply bool
+	}{
+		{"increase", strings.Repeat("1", 10) + "3" + strings.Repeat("1", 11) + "22", 60, map[int]bool{10: false, 22: false, 23: false}, true},
+		{"decrease", strings.Repeat("1", 10) + "0" + strings.Repeat("1", 12) + "0", 60, map[int]bool{10: true, 23: false}, false},
+		{"duration", strings.Repeat("1", 48), 30, nil, false},
+	}
+	for _, test := range tests {
+		s := newTestServer()
+		store := newFakeStore()
+		q := store.addQueue(&QueueConfiguration{})
+		before := *store.schedules[q.ID][tomorrow]
+		store.book(q, tomorrow, 10, "student@example.com")
+
+		diff := previewRequest(t, s, store, q, tomorrow, &AppointmentSchedule{Duration: test.duration, Schedule: test.schedule})
+		if diff.CanApply != test.canApply {
+			t.Errorf("%s: got can apply %t, want %t", test.name, diff.CanApply, test.canApply)
+		}
+		if diff.CurrentDuration != 60 || diff.ProposedDuration != test.duration {
+			t.Errorf("%s: got durations %d and %d, want 60 and %d", test.name, diff.CurrentDuration, diff.ProposedDuration, test.duration)
+		}
+
+		// A new duration moves every timeslot, and the one that's booked
+		// can't come along.
+		if test.changes == nil {
+			if len(diff.Timeslots) != 48 {
+				t.Errorf("%s: got %d changed timeslots, want all 48", test.name, len(diff.Timeslots))
+			}
+			for _, c := range diff.Timeslots {
+				if c.Conflict != (c.Timeslot == 10) {
+					t.Errorf("%s: got conflict %t at timeslot %d", test.name, c.Conflict, c.Timeslot)
+				}
+			}
+		} else {
+			got := make(map[int]bool)
+			for _, c := range diff.Timeslots {
+				got[c.Timeslot] = c.Conflict
+				if c.CurrentCapacity != 1 || c.ProposedCapacity != int(test.schedule[c.Timeslot]-'0') {
+					t.Errorf("%s: got capacity %d to %d at timeslot %d", test.name, c.CurrentCapacity, c.ProposedCapacity, c.Timeslot)
+				}
+				if want := SlotStart(start, c.Timeslot, &before); c.ScheduledTime == nil || !c.ScheduledTime.Equal(want) {
+					t.Errorf("%s: got time %v at timeslot %d, want %s", test.name, c.ScheduledTime, c.Timeslot, want)
+				}
+				if c.Timeslot == 10 && c.Appointments != 1 {
+					t.Errorf("%s: got %d appointments at timeslot 10, want 1", test.name, c.Appointments)
+				}
+			}
+			if !reflect.DeepEqual(got, test.changes) {
+				t.Errorf("%s: got changes %v, want %v", test.name, got, test.changes)
+			}
+		}
+
+		if store.schedules[q.ID][tomorrow].Schedule != before.Schedule {
+			t.Errorf("%s: previewing changed the schedule", test.name)
+		}
+	}
+}
+
+func TestCustomFields(t *testing.T) {
+	s := newTestServer()
+	store := newFakeStore()
+	q := store.addQueue(&QueueConfiguration{})
+	store.fieldDefinitions[q.ID] = []*CustomFieldDefinition{
+		{Name: "Assignment", Type: CustomFieldNumber, Required: true},
+		{Name: "Language", Type: CustomFieldText},
+		{Name: "Tried office hours", Type: CustomFieldBoolean},
+	}
+	tomorrow := int(time.Now().Local().Add(24 * time.Hour).Weekday())
+
+	tests := []struct {
+		name   string
+		fields map[string]interface{}
+		status int
+	}{
+		{"required missing", map[string]interface{}{"Language": "Go"}, http.StatusBadRequest},
+		{"required blank", map[string]interface{}{"Assignment": "  "}, http.StatusBadRequest},
+		{"number as text", map[string]interface{}{"Assignment": "3"}, http.StatusBadRequest},
+		{"text as number", map[string]interface{}{"Assignment": 3, "Language": 5}, http.StatusBadRequest},
+		{"boolean as text", map[string]interface{}{"Assignment": 3, "Tried office hours": "yes"}, http.StatusBadRequest},
+		{"undefined", map[string]interface{}{"Assignment": 3, "Favorite color": "blue"}, http.StatusBadRequest},
+		{"valid", map[string]interface{}{"Assignment": 3, "Language": "Go", "Tried office hours": true}, http.StatusCreated},
+	}
+	for _, test := range tests {
+		w := signupBodyRequest(s, store, q, tomorrow, "student@example.com", 10, map[string]interface{}{
+			"slot_span":     1,
+			"location":      "Here",
+			"description":   "Help",
+			"custom_fields": test.fields,
+		})
+		if w.Code != test.status {
+			t.Errorf("%s: got status %d, want %d: %s", test.name, w.Code, test.status, w.Body.String())
+		}
+	}
+	if len(store.appointments) != 1 {
+		t.Fatalf("got %d stored appointments, want 1", len(store.appointments))
+	}
+
+	a := store.appointments[0]
+	want := map[string]interface{}{"Assignment": float64(3), "Language": "Go", "Tried office hours": true}
+	if !reflect.DeepEqual(store.customFields[a.ID], want) {
+		t.Errorf("got custom fields %v, want %v", store.customFields[a.ID], want)
+	}
+
+	// Updates still need the required ones.
+	w := updateBodyRequest(s, store, q, a, map[string]interface{}{
+		"timeslot":      10,
+		"location":      "Here",
+		"description":   "Help",
+		"custom_fields": map[string]interface{}{"Language": "Go"},
+	})
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("got status %d updating without required field, want %d", w.Code, http.StatusBadRequest)
+	}
+	if !reflect.DeepEqual(store.customFields[a.ID], want) {
+		t.Errorf("got custom fields %v after failed update, want %v", store.customFields[a.ID], want)
+	}
+}
+
+// signupLocks are row locks shared between transactions, like the
+// advisory locks LockAppointmentSignups takes in the database.
+type signupLocks struct {
+	mu    sync.Mutex
+	locks map[string]*sync.Mutex
+}
+
+func (l *signupLocks) get(key string) *sync.Mutex {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	m, ok := l.locks[key]
+	if !ok {
+		m = &sync.Mutex{}
+		l.locks[key] = m
+	}
+	return m
+}
+
+// barrier holds up each request that reaches it until n have, or until
+// it's waited long enough to be sure the rest are stuck somewhere else.
+type barrier struct {
+	mu      sync.Mutex
+	n       int
+	arrived chan struct{}
+}
+
+func (b *barrier) wait() {
+	b.mu.Lock()
+	b.n--
+	if b.n == 0 {
+		close(b.arrived)
+	}
+	b.mu.Unlock()
+
+	select {
+	case <-b.arrived:
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+// transactionStore is one request's transaction on a shared fakeStore.
+// Its signup locks are held until commit. After looking up a student's
+// appointments, it waits at a barrier, so that requests which aren't kept
+// apart by the locks both look before either has booked.
+type transactionStore struct {
+	*fakeStore
+	locks   *signupLocks
+	barrier *barrier
+	held    []*sync.Mutex
+}
+
+func (t *transactionStore) LockAppointmentSignups(ctx context.Context, queue ksuid.KSUID, email string) error {
+	m := t.locks.get(queue.String() + email)
+	m.Lock()
+	t.held = append(t.held, m)
+	return nil
+}
+
+func (t *transactionStore) GetAppointmentsForUser(ctx context.Context, queue ksuid.KSUID, from, to time.Time, email string) ([]*AppointmentSlot, error) {
+	appointments, err := t.fakeStore.GetAppointmentsForUser(ctx, queue, from, to, email)
+	t.barrier.wait()
+	return appointments, err
+}
+
+func (t *transactionStore) commit() {
+	for _, m := range t.held {
+		m.Unlock()
+	}
+	t.held = nil
+}
+
+func TestConcurrentSignupsSameStudent(t *testing.T) {
+	s := newTestServer()
+	store := newFakeStore()
+	q := store.addQueue(&QueueConfiguration{})
+	tomorrow := int(time.Now().Local().Add(24 * time.Hour).Weekday())
+
+	locks := &signupLocks{locks: make(map[string]*sync.Mutex)}
+	b := &barrier{n: 2, arrived: make(chan struct{})}
+	codes := make(chan int, 2)
+	for _, timeslot := range []int{10, 11} {
+		tx := &transactionStore{fakeStore: store, locks: locks, barrier: b}
+		go func(timeslot int) {
+			w := signupRequest(s, tx, q, tomorrow, "student@example.com", timeslot, 1)
+			tx.commit()
+			codes <- w.Code
+		}(timeslot)
+	}
+
+	got := make(map[int]int)
+	for i := 0; i < 2; i++ {
+		select {
+		case code := <-codes:
+			got[code]++
+		case <-time.After(5 * time.Second):
+			t.Fatal("sign-ups never finished")
+		}
+	}
+	if got[http.StatusCreated] != 1 || got[http.StatusConflict] != 1 {
+		t.Errorf("got statuses %v, want one %d and one %d", got, http.StatusCreated, http.StatusConflict)
+	}
+	if len(store.appointments) != 1 {
+		t.Errorf("got %d stored appointments, want 1", len(store.appointments))
+	}
+}
+
+// teammateStore has the students in booked count as having a teammate
+// with an appointment.
+type teammateStore struct {
+	*fakeStore
+	booked map[string]bool
+}
+
+func (t *teammateStore) TeammateHasAppointment(ctx context.Context, queue ksuid.KSUID, from, to time.Time, email string) (bool, error) {
+	return t.booked[email], nil
+}
+
+func eligibilityRequest(t *testing.T, s *Server, ge getSignupEligibility, q *Queue, email string) *SignupEligibility {
+	t.Helper()
+	r, _ := newTestRequest(http.MethodGet, "/?email="+email, nil, map[string]interface{}{
+		queueContextKey:       q,
+		emailContextKey:       "staff@example.com",
+		courseAdminContextKey: true,
+	})
+	w := httptest.NewRecorder()
+	s.GetSignupEligibility(ge).ServeHTTP(w, r)
+	if w.Code != http.StatusOK {
+		t.Fatalf("got status %d, want %d: %s", w.Code, http.StatusOK, w.Body.String())
+	}
+
+	var eligibility SignupEligibility
+	err := json.NewDecoder(w.Body).Decode(&eligibility)
+	if err != nil {
+		t.Fatalf("failed to decode response: %v", err)
+	}
+	return &eligibility
+}
+
+func TestSignupEligibility(t *testing.T) {
+	s := newTestServer()
+	store := newFakeStore()
+	q := store.addQueue(&QueueConfiguration{PreventUnregistered: true, PreventGroups: true})
+	store.rosters[q.ID] = map[string]bool{
+		"eligible@example.com": true,
+		"booked@example.com":   true,
+		"teammate@example.com": true,
+	}
+	tomorrow := int(time.Now().Local().Add(24 * time.Hour).Weekday())
+	existing := store.book(q, tomorrow, 10, "booked@example.com")
+	ge := &teammateStore{fakeStore: store, booked: map[string]bool{"teammate@example.com": true}}
+
+	tests := []struct {
+		email  string
+		reason string
+	}{
+		{"eligible@example.com", ""},
+		{"stranger@example.com", SignupBlockedNotInRoster},
+		{"teammate@example.com", SignupBlockedTeammate},
+		{"booked@example.com", SignupBlockedFutureAppointment},
+	}
+	for _, test := range tests {
+		got := eligibilityRequest(t, s, ge, q, test.email)
+		if got.Email != test.email {
+			t.Errorf("got email %q, want %q", got.Email, test.email)
+		}
+		if got.Eligible != (test.reason == "") || got.Reason != test.reason {
+			t.Errorf("%s: got eligible %t with reason %q, want reason %q", test.email, got.Eligible, got.Reason, test.reason)
+		}
+	}
+
+	// It says which appointment is in the way.
+	got := eligibilityRequest(t, s, ge, q, "booked@example.com")
+	if got.Conflict == nil || got.Conflict.AppointmentID != existing.ID {
+		t.Errorf("got conflict %+v, want appointment %s", got.Conflict, existing.ID)
+	}
+
+	// Without a day to go on, one appointment per day can't be checked.
+	store.configs[q.ID].FutureAppointmentScope = FutureAppointmentScopeDay
+	got = eligibilityRequest(t, s, ge, q, "booked@example.com")
+	if !got.Eligible {
+		t.Errorf("got reason %q with one appointment per day, want eligible", got.Reason)
+	}
+}
+
+func TestSignupEligibilityWithoutEmail(t *testing.T) {
+	s := newTestServer()
+	store := newFakeStore()
+	q := store.addQueue(&QueueConfiguration{})
+
+	r, _ := newTestRequest(http.MethodGet, "/?email=%20", nil, map[string]interface{}{
+		queueContextKey:       q,
+		emailContextKey:       "staff@example.com",
+		courseAdminContextKey: true,
+	})
+	w := httptest.NewRecorder()
+	s.GetSignupEligibility(store).ServeHTTP(w, r)
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("got status %d, want %d", w.Code, http.StatusBadRequest)
+	}
+}
+
+func TestStudentTotalTime(t *testing.T) {
+	s := newTestServer()
+	store := newFakeStore()
+	q := store.addQueue(&QueueConfiguration{})
+	today := int(time.Now().Local().Weekday())
+	now := time.Now()
+
+	// finished books an appointment for email that started hoursAgo.
+	finished := func(hoursAgo int, email string, duration int) *AppointmentSlot {
+		a := store.book(q, today, hoursAgo, email)
+		a.ScheduledTime = now.Add(-time.Duration(hoursAgo) * time.Hour)
+		a.Duration = duration
+		return a
+	}
+	finished(1, "student@example.com", 60)
+	finished(2, "student@example.com", 30)
+	partnered := finished(3, "partner@example.com", 15)
+	store.partners[partnered.ID] = []string{"student@example.com"}
+	finished(4, "other@example.com", 60)
+
+	// Neither an appointment that's still going nor one that was
+	// canceled counts.
+	ongoing := store.book(q, today, 5, "student@example.com")
+	ongoing.ScheduledTime = now.Add(-10 * time.Minute)
+	canceled := finished(6, "student@example.com", 60)
+	store.deleteAppointment(canceled.ID)
+
+	r, _ := newTestRequest(http.MethodGet, "/?email=student@example.com", nil, map[string]interface{}{
+		queueContextKey:       q,
+		emailContextKey:       "staff@example.com",
+		courseAdminContextKey: true,
+	})
+	w := httptest.NewRecorder()
+	s.GetStudentTotalTime(store).ServeHTTP(w, r)
+	if w.Code != http.StatusOK {
+		t.Fatalf("got status %d, want %d: %s", w.Code, http.StatusOK, w.Body.String())
+	}
+
+	var total StudentTotalTime
+	err := json.NewDecoder(w.Body).Decode(&total)
+	if err != nil {
+		t.Fatalf("failed to decode response: %v", err)
+	}
+	want := StudentTotalTime{Email: "student@example.com", Appointments: 3, TotalMinutes: 105}
+	if total != want {
+		t.Errorf("got %+v, want %+v", total, want)
+	}
+}
+
+func TestSignupsFrozen(t *testing.T) {
+	s := newTestServer()
+	store := newFakeStore()
+	q := store.addQueue(&QueueConfiguration{AppointmentSettings: AppointmentSettings{SignupsFrozen: true}})
+	tomorrow := int(time.Now().Local().Add(24 * time.Hour).Weekday())
+	a := store.book(q, tomorrow, 10, "student@example.com")
+
+	w := signupRequest(s, store, q, tomorrow, "other@example.com", 11, 1)
+	if w.Code != http.StatusForbidden {
+		t.Errorf("got status %d signing up, want %d", w.Code, http.StatusForbidden)
+	}
+	w = rescheduleRequest(t, s, store, q, a, 12)
+	if w.Code != http.StatusForbidden {
+		t.Errorf("got status %d rescheduling, want %d", w.Code, http.StatusForbidden)
+	}
+	if got := eligibilityRequest(t, s, store, q, "other@example.com"); got.Reason != SignupBlockedFrozen {
+		t.Errorf("got eligibility reason %q, want %q", got.Reason, SignupBlockedFrozen)
+	}
+
+	// Course admins can still sign up.
+	encoded, _ := json.Marshal(map[string]interface{}{"slot_span": 1, "location": "Here", "description": "Help"})
+	r, _ := newTestRequest(http.MethodPost, "/", strings.NewReader(string(encoded)), map[string]interface{}{
+		queueContextKey:               q,
+		appointmentDayContextKey:      tomorrow,
+		appointmentTimeslotContextKey: 13,
+		emailContextKey:               "staff@example.com",
+		nameContextKey:                "Staff",
+		courseAdminContextKey:         true,
+	})
+	w = httptest.NewRecorder()
+	s.SignupForAppointment(store).ServeHTTP(w, r)
+	if w.Code != http.StatusCreated {
+		t.Errorf("got status %d for course admin, want %d: %s", w.Code, http.StatusCreated, w.Body.String())
+	}
+
+	// Canceling still works.
+	r, _ = newTestRequest(http.MethodDelete, "/", nil, map[string]interface{}{
+		queueContextKey:       q,
+		appointmentContextKey: a,
+		emailContextKey:       "student@example.com",
+	})
+	w = httptest.NewRecorder()
+	s.RemoveAppointmentSignup(store).ServeHTTP(w, r)
+	if w.Code != http.StatusNoContent {
+		t.Errorf("got status %d canceling, want %d", w.Code, http.StatusNoContent)
+	}
+	if store.appointment(a.ID) != nil {
+		t.Error("appointment still there after canceling")
+	}
+}
+
+func timeslotLocationRequest(s *Server, store *fakeStore, q *Queue, day, timeslot int, location string) *httptest.ResponseRecorder {
+	encoded, _ := json.Marshal(map[string]string{"location": location})
+	r, _ := newTestRequest(http.MethodPut, "/", strings.NewReader(string(encoded)), map[string]interface{}{
+		queueContextKey:               q,
+		appointmentDayContextKey:      day,
+		appointmentTimeslotContextKey: timeslot,
+		emailContextKey:               "admin@example.com",
+		courseAdminContextKey:         true,
+	})
+	w := httptest.NewRecorder()
+	s.SetTimeslotLocation(store).ServeHTTP(w, r)
+	return w
+}
+
+func TestTimeslotDefaultLocation(t *testing.T) {
+	s := newTestServer()
+	store := newFakeStore()
+	q := store.addQueue(&QueueConfiguration{})
+	tomorrow := int(time.Now().Local().Add(24 * time.Hour).Weekday())
+	store.schedules[q.ID][tomorrow].Schedule = strings.Repeat("2", 24)
+
+	w := timeslotLocationRequest(s, store, q, tomorrow, 10, "  Room 2  ")
+	if w.Code != http.StatusNoContent {
+		t.Fatalf("got status %d, want %d", w.Code, http.StatusNoContent)
+	}
+	if got := store.schedules[q.ID][tomorrow].Locations[10]; got != "Room 2" {
+		t.Errorf("got location %q, want Room 2", got)
+	}
+	w = timeslotLocationRequest(s, store, q, tomorrow, 24, "Room 2")
+	if w.Code != http.StatusNotFound {
+		t.Errorf("got status %d past the end of the day, want %d", w.Code, http.StatusNotFound)
+	}
+
+	tests := []struct {
+		name     string
+		timeslot int
+		location string
+		want     string
+		status   int
+	}{
+		{"blank takes the default", 10, "  ", "Room 2", http.StatusCreated},
+		{"student's location wins", 10, "Room 3", "Room 3", http.StatusCreated},
+		// Without a default it's still required.
+		{"no default", 11, "", "", http.StatusBadRequest},
+	}
+	for i, test := range tests {
+		w := signupBodyRequest(s, store, q, tomorrow, strconv.Itoa(i)+"@example.com", test.timeslot, map[string]interface{}{
+			"location":    test.location,
+			"description": "Help",
+		})
+		if w.Code != test.status {
+			t.Errorf("%s: got status %d, want %d: %s", test.name, w.Code, test.status, w.Body.String())
+			continue
+		}
+		if test.status != http.StatusCreated {
+			continue
+		}
+
+		var a AppointmentSlot
+		err := json.NewDecoder(w.Body).Decode(&a)
+		if err != nil {
+			t.Fatalf("failed to decode response: %v", err)
+		}
+		if a.Location == nil || *a.Location != test.want {
+			t.Errorf("%s: got location %v, want %q", test.name, a.Location, test.want)
+		}
+	}
+}
+
+func TestDecodedSchedule(t *testing.T) {
+	s := newTestServer()
+	store := newFakeStore()
+	q := store.addQueue(&QueueConfiguration{})
+	tomorrow := int(time.Now().Local().Add(24 * time.Hour).Weekday())
+	schedule := store.schedules[q.ID][tomorrow]
+	schedule.Duration = 30
+	schedule.Schedule = strings.Repeat("0", 18) + "2031" + strings.Repeat("0", 26)
+
+	request := func(query string) *httptest.ResponseRecorder {
+		r, _ := newTestRequest(http.MethodGet, "/?"+query, nil, map[string]interface{}{
+			queueContextKey:          q,
+			appointmentDayContextKey: tomorrow,
+		})
+		w := httptest.NewRecorder()
+		s.GetAppointmentScheduleForDay(store).ServeHTTP(w, r)
+		if w.Code != http.StatusOK {
+			t.Fatalf("got status %d, want %d: %s", w.Code, http.StatusOK, w.Body.String())
+		}
+		return w
+	}
+
+	var body struct {
+		Schedule string             `json:"schedule"`
+		Decoded  []*DecodedTimeslot `json:"decoded"`
+	}
+	err := json.NewDecoder(request("decode=true").Body).Decode(&body)
+	if err != nil {
+		t.Fatalf("failed to decode response: %v", err)
+	}
+	if body.Schedule != schedule.Schedule {
+		t.Errorf("got raw schedule %s, want %s", body.Schedule, schedule.Schedule)
+	}
+	if len(body.Decoded) != len(schedule.Schedule) {
+		t.Fatalf("got %d decoded timeslots, want %d", len(body.Decoded), len(schedule.Schedule))
+	}
+
+	// 9:00 through 10:30, half an hour each.
+	start, _ := WeekdayBounds(tomorrow)
+	for i, capacity := range []int{2, 0, 3, 1} {
+		got := body.Decoded[18+i]
+		want := time.Date(start.Year(), start.Month(), start.Day(), 9, 30*i, 0, 0, time.Local)
+		if got.Timeslot != 18+i || got.Capacity != capacity || !got.ScheduledTime.Equal(want) {
+			t.Errorf("got timeslot %d with capacity %d at %s, want %d with %d at %s",
+				got.Timeslot, got.Capacity, got.ScheduledTime, 18+i, capacity, want)
+		}
+	}
+
+	// It's only there when asked for.
+	var plain map[string]interface{}
+	err = json.NewDecoder(request("").Body).Decode(&plain)
+	if err != nil {
+		t.Fatalf("failed to decode response: %v", err)
+	}
+	if _, ok := plain["decoded"]; ok {
+		t.Error("got decoded timeslots without decode=true")
+	}
+}
+
+func weeklyTimeBoundsRequest(t *testing.T, s *Server, store *fakeStore, q *Queue) *WeeklyTimeBounds {
+	t.Helper()
+	r, _ := newTestRequest(http.MethodGet, "/", nil, map[string]interface{}{
+		queueContextKey: q,
+	})
+	w := httptest.NewRecorder()
+	s.GetWeeklyTimeBounds(store).ServeHTTP(w, r)
+	if w.Code != http.StatusOK {
+		t.Fatalf("got status %d, want %d: %s", w.Code, http.StatusOK, w.Body.String())
+	}
+
+	var bounds WeeklyTimeBounds
+	err := json.NewDecoder(w.Body).Decode(&bounds)
+	if err != nil {
+		t.Fatalf("failed to decode response: %v", err)
+	}
+	return &bounds
+}
+
+func TestWeeklyTimeBounds(t *testing.T) {
+	s := newTestServer()
+	store := newFakeStore()
+	q := store.addQueue(&QueueConfiguration{})
+	for day := 0; day < 7; day++ {
+		store.schedules[q.ID][day].Schedule = strings.Repeat("0", 24)
+	}
+
+	bounds := weeklyTimeBoundsRequest(t, s, store, q)
+	if bounds.Start != nil || bounds.End != nil {
+		t.Errorf("got bounds %v to %v with nothing open, want neither", bounds.Start, bounds.End)
+	}
+
+	// Monday opens earliest, at 9:00 with hour-long timeslots, but
+	// Wednesday's half-hour timeslots run latest, to 17:30.
+	store.schedules[q.ID][1].Schedule = strings.Repeat("0", 9) + "11" + strings.Repeat("0", 13)
+	wednesday := store.schedules[q.ID][3]
+	wednesday.Duration = 30
+	wednesday.Schedule = strings.Repeat("0", 24) + "1001" + strings.Repeat("0", 6) + "1" + strings.Repeat("0", 13)
+	store.schedules[q.ID][5].Schedule = strings.Repeat("0", 12) + "111" + strings.Repeat("0", 9)
+
+	bounds = weeklyTimeBoundsRequest(t, s, store, q)
+	if bounds.Start == nil || *bounds.Start != 9*60 {
+		t.Errorf("got start %v, want %d", bounds.Start, 9*60)
+	}
+	if bounds.End == nil || *bounds.End != 17*60+30 {
+		t.Errorf("got end %v, want %d", bounds.End, 17*60+30)
+	}
+}
+
+func claimPatternRequest(t *testing.T, s *Server, store *fakeStore, q *Queue, day, stride, offset int) []*PatternClaimResult {
+	t.Helper()
+	encoded, _ := json.Marshal(map[string]int{"stride": stride, "offset": offset})
+	r, _ := newTestRequest(http.MethodPost, "/", strings.NewReader(string(encoded)), map[string]interface{}{
+		queueContextKey:          q,
+		appointmentDayContextKey: day,
+		emailContextKey:          "ta@example.com",
+	})
+	w := httptest.NewRecorder()
+	s.ClaimTimeslotsByPattern(store).ServeHTTP(w, r)
+	if w.Code != http.StatusOK {
+		t.Fatalf("got status %d, want %d: %s", w.Code, http.StatusOK, w.Body.String())
+	}
+
+	var results []*PatternClaimResult
+	err := json.NewDecoder(w.Body).Decode(&results)
+	if err != nil {
+		t.Fatalf("failed to decode response: %v", err)
+	}
+	return results
+}
+
+func TestClaimTimeslotsByPattern(t *testing.T) {
+	s := newTestServer()
+	store := newFakeStore()
+	q := store.addQueue(&QueueConfiguration{})
+	tomorrow := int(time.Now().Local().Add(24 * time.Hour).Weekday())
+	store.schedules[q.ID][tomorrow].Schedule = strings.Repeat("1", 9) + "0" + strings.Repeat("1", 14)
+
+	store.claim(q, tomorrow, 5, "ta@example.com")
+	store.claim(q, tomorrow, 7, "other@example.com")
+	student := store.book(q, tomorrow, 11, "student@example.com")
+
+	// Every other timeslot, starting at 1.
+	results := claimPatternRequest(t, s, store, q, tomorrow, 2, 1)
+	if len(results) != 12 {
+		t.Fatalf("got %d results, want 12", len(results))
+	}
+	skipped := map[int]string{
+		5: PatternClaimSkippedMine,
+		7: PatternClaimSkippedNoRoom,
+		9: PatternClaimSkippedBreak,
+	}
+	for i, result := range results {
+		if want := 1 + 2*i; result.Timeslot != want {
+			t.Errorf("got timeslot %d, want %d", result.Timeslot, want)
+		}
+		if reason, ok := skipped[result.Timeslot]; ok {
+			if result.Claimed || result.Reason != reason {
+				t.Errorf("got timeslot %d claimed %t with reason %q, want skipped for %q", result.Timeslot, result.Claimed, result.Reason, reason)
+			}
+			continue
+		}
+		if !result.Claimed || result.Appointment == nil || *result.Appointment.StaffEmail != "ta@example.com" {
+			t.Errorf("timeslot %d wasn't claimed: %+v", result.Timeslot, result)
+		}
+	}
+
+	// Claiming a timeslot with a student in it takes over that
+	// appointment, and nothing else in the pattern is touched.
+	if got := store.appointment(student.ID); got.StaffEmail == nil || *got.StaffEmail != "ta@example.com" {
+		t.Error("student's appointment wasn't claimed")
+	}
+	// The three from before, plus a claim for each of the other eight.
+	if got := len(store.appointments); got != 11 {
+		t.Errorf("got %d stored appointments, want 11", got)
+	}
+	for _, a := range store.appointments {
+		if a.Timeslot%2 == 0 {
+			t.Errorf("timeslot %d claimed outside the pattern", a.Timeslot)
+		}
+	}
+}
+
+func TestClaimTimeslotsByPatternInvalid(t *testing.T) {
+	s := newTestServer()
+	store := newFakeStore()
+	q := store.addQueue(&QueueConfiguration{})
+	tomorrow := int(time.Now().Local().Add(24 * time.Hour).Weekday())
+
+	for _, pattern := range []map[string]int{{"stride": 0}, {"stride": 2, "offset": -1}} {
+		encoded, _ := json.Marshal(pattern)
+		r, _ := newTestRequest(http.MethodPost, "/", strings.NewReader(string(encoded)), map[string]interface{}{
+			queueContextKey:          q,
+			appointmentDayContextKey: tomorrow,
+			emailContextKey:          "ta@example.com",
+		})
+		w := httptest.NewRecorder()
+		s.ClaimTimeslotsByPattern(store).ServeHTTP(w, r)
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("got status %d for %v, want %d", w.Code, pattern, http.StatusBadRequest)
+		}
+	}
+	if len(store.appointments) != 0 {
+		t.Errorf("got %d stored appointments, want 0", len(store.appointments))
+	}
+}
+
+func normalizeRequest(s *Server, q *Queue, day int, schedule interface{}) *httptest.ResponseRecorder {
+	encoded, _ := json.Marshal(schedule)
+	r, _ := newTestRequest(http.MethodPost, "/", strings.NewReader(string(encoded)), map[string]interface{}{
+		queueContextKey:          q,
+		appointmentDayContextKey: day,
+		emailContextKey:          "admin@example.com",
+		courseAdminContextKey:    true,
+	})
+	w := httptest.NewRecorder()
+	s.NormalizeAppointmentSchedule().ServeHTTP(w, r)
+	return w
+}
+
+func TestNormalizeAppointmentSchedule(t *testing.T) {
+	s := newTestServer()
+	store := newFakeStore()
+	q := store.addQueue(&QueueConfiguration{})
+	tomorrow := int(time.Now().Local().Add(24 * time.Hour).Weekday())
+	before := *store.schedules[q.ID][tomorrow]
+
+	w := normalizeRequest(s, q, tomorrow, &AppointmentSchedule{Duration: 120, Schedule: "0000120300"})
+	if w.Code != http.StatusOK {
+		t.Fatalf("got status %d, want %d: %s", w.Code, http.StatusOK, w.Body.String())
+	}
+	var body struct {
+		Queue     string             `json:"queue"`
+		Day       int                `json:"day"`
+		Timeslots int                `json:"timeslots"`
+		Slots     int                `json:"slots"`
+		Decoded   []*DecodedTimeslot `json:"decoded"`
+	}
+	err := json.NewDecoder(w.Body).Decode(&body)
+	if err != nil {
+		t.Fatalf("failed to decode response: %v", err)
+	}
+	if body.Queue != q.ID.String() || body.Day != tomorrow {
+		t.Errorf("got queue %s and day %d, want %s and %d", body.Queue, body.Day, q.ID, tomorrow)
+	}
+	if body.Timeslots != 10 || body.Slots != 6 || len(body.Decoded) != 10 {
+		t.Errorf("got %d timeslots (%d decoded) with %d slots, want 10 with 6", body.Timeslots, len(body.Decoded), body.Slots)
+	}
+	start, _ := WeekdayBounds(tomorrow)
+	if len(body.Decoded) == 10 {
+		got := body.Decoded[7]
+		want := time.Date(start.Year(), start.Month(), start.Day(), 14, 0, 0, 0, time.Local)
+		if got.Capacity != 3 || !got.ScheduledTime.Equal(want) {
+			t.Errorf("got capacity %d at %s for timeslot 7, want 3 at %s", got.Capacity, got.ScheduledTime, want)
+		}
+	}
+
+	// It's only a preview.
+	if !reflect.DeepEqual(*store.schedules[q.ID][tomorrow], before) {
+		t.Error("schedule changed by normalizing")
+	}
+}
+
+func TestNormalizeAppointmentScheduleErrors(t *testing.T) {
+	s := newTestServer()
+	store := newFakeStore()
+	q := store.addQueue(&QueueConfiguration{})
+	tomorrow := int(time.Now().Local().Add(24 * time.Hour).Weekday())
+	third := 2
+
+	tests := []struct {
+		name     string
+		schedule *AppointmentSchedule
+		field    string
+		timeslot *int
+	}{
+		{"zero duration", &AppointmentSchedule{Schedule: "1"}, "duration", nil},
+		{"too long", &AppointmentSchedule{Duration: 60, Schedule: strings.Repeat("1", 25)}, "schedule", nil},
+		{"non-digit", &AppointmentSchedule{Duration: 60, Schedule: "11x"}, "schedule", &third},
+	}
+	for _, test := range tests {
+		w := normalizeRequest(s, q, tomorrow, test.schedule)
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("%s: got status %d, want %d", test.name, w.Code, http.StatusBadRequest)
+			continue
+		}
+
+		var body struct {
+			Code    string          `json:"code"`
+			Details scheduleProblem `json:"details"`
+		}
+		err := json.NewDecoder(w.Body).Decode(&body)
+		if err != nil {
+			t.Fatalf("%s: failed to decode error response: %v", test.name, err)
+		}
+		if body.Code != invalidSchedule || body.Details.Field != test.field {
+			t.Errorf("%s: got code %s on %s, want %s on %s", test.name, body.Code, body.Details.Field, invalidSchedule, test.field)
+		}
+		if !reflect.DeepEqual(body.Details.Timeslot, test.timeslot) {
+			t.Errorf("%s: got timeslot %v, want %v", test.name, body.Details.Timeslot, test.timeslot)
+		}
+	}
+
+	w := normalizeRequest(s, q, tomorrow, "not a schedule")
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("got status %d for an unreadable schedule, want %d", w.Code, http.StatusBadRequest)
+	}
+}
+
+func TestActiveAppointmentCount(t *testing.T) {
+	s := newTestServer()
+	store := newFakeStore()
+	q := store.addQueue(&QueueConfiguration{})
+	other := store.addQueue(&QueueConfiguration{})
+	now := time.Now()
+
+	// book puts appointments on a day of the week; move them to around now
+	// instead.
+	at := func(q *Queue, email string, offset time.Duration) *AppointmentSlot {
+		a := store.book(q, 0, 0, email)
+		a.ScheduledTime = now.Add(offset)
+		return a
+	}
+	at(q, "past@example.com", -2*time.Hour)
+	at(q, "current@example.com", -30*time.Minute)
+	at(q, "next@example.com", time.Hour)
+	at(q, "later@example.com", 48*time.Hour)
+	at(other, "elsewhere@example.com", time.Hour)
+	claim := at(q, "", 2*time.Hour)
+	claim.StudentEmail = nil
+
+	r, _ := newTestRequest(http.MethodGet, "/", nil, map[string]interface{}{queueContextKey: q})
+	w := httptest.NewRecorder()
+	s.GetActiveAppointmentCount(store).ServeHTTP(w, r)
+	if w.Code != http.StatusOK {
+		t.Fatalf("got status %d, want %d", w.Code, http.StatusOK)
+	}
+	var count ActiveAppointmentCount
+	err := json.NewDecoder(w.Body).Decode(&count)
+	if err != nil {
+		t.Fatalf("failed to decode response: %v", err)
+	}
+	if count.Current != 1 || count.Upcoming != 2 {
+		t.Errorf("got %d current and %d upcoming, want 1 and 2", count.Current, count.Upcoming)
+	}
+}
+
+func TestSignupTimeConfirmation(t *testing.T) {
+	s := newTestServer()
+	store := newFakeStore()
+	q := store.addQueue(&QueueConfiguration{AppointmentSettings: AppointmentSettings{RequireSignupTimeConfirmation: true}})
+	tomorrow := int(time.Now().Local().Add(24 * time.Hour).Weekday())
+	start, _ := WeekdayBounds(tomorrow)
+
+	signup := func(email string, scheduledTime time.Time) *httptest.ResponseRecorder {
+		return signupBodyRequest(s, store, q, tomorrow, email, 10, map[string]interface{}{
+			"scheduled_time": scheduledTime,
+			"location":       "Here",
+			"description":    "Help",
+		})
+	}
+
+	// The student saw the schedule with hour-long timeslots, but it's
+	// since been split into half hours, moving timeslot 10 earlier.
+	seen := SlotStart(start, 10, store.schedules[q.ID][tomorrow])
+	store.schedules[q.ID][tomorrow].Duration = 30
+	store.schedules[q.ID][tomorrow].Schedule = strings.Repeat("1", 48)
+	current := SlotStart(start, 10, store.schedules[q.ID][tomorrow])
+
+	w := signup("stale@example.com", seen)
+	if w.Code != http.StatusConflict {
+		t.Fatalf("got status %d with stale time, want %d", w.Code, http.StatusConflict)
+	}
+	var body struct {
+		Code    string           `json:"code"`
+		Details rescheduleTarget `json:"details"`
+	}
+	err := json.NewDecoder(w.Body).Decode(&body)
+	if err != nil {
+		t.Fatalf("failed to decode error response: %v", err)
+	}
+	if body.Code != signupSlotChanged {
+		t.Errorf("got code %q, want %q", body.Code, signupSlotChanged)
+	}
+	if body.Details.Timeslot != 10 || body.Details.ScheduledTime == nil || !body.Details.ScheduledTime.Equal(current) {
+		t.Errorf("got target %+v, want timeslot 10 at %v", body.Details, current)
+	}
+	if len(store.appointments) != 0 {
+		t.Fatalf("got %d stored appointments after stale signup, want 0", len(store.appointments))
+	}
+
+	w = signup("current@example.com", current)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("got status %d with current time, want %d: %s", w.Code, http.StatusCreated, w.Body.String())
+	}
+	if len(store.appointments) != 1 || !store.appointments[0].ScheduledTime.Equal(current) {
+		t.Errorf("got appointments %+v, want one at %v", store.appointments, current)
+	}
+}
+
+func resolvedRequest(s *Server, store *fakeStore, q *Queue, a *AppointmentSlot, resolved interface{}) *httptest.ResponseRecorder {
+	encoded, _ := json.Marshal(map[string]interface{}{"resolved": resolved})
+	r, _ := newTestRequest(http.MethodPut, "/", strings.NewReader(string(encoded)), map[string]interface{}{
+		queueContextKey:       q,
+		appointmentContextKey: a,
+		emailContextKey:       "ta@example.com",
+		courseAdminContextKey: true,
+	})
+	w := httptest.NewRecorder()
+	s.SetAppointmentResolved(store).ServeHTTP(w, r)
+	return w
+}
+
+func TestAppointmentResolved(t *testing.T) {
+	s := newTestServer()
+	store := newFakeStore()
+	q := store.addQueue(&QueueConfiguration{})
+	now := time.Now()
+
+	at := func(email string, offset time.Duration) *AppointmentSlot {
+		a := store.book(q, 0, 0, email)
+		a.ScheduledTime = now.Add(offset)
+		return a
+	}
+	resolved := at("resolved@example.com", -3*time.Hour)
+	later := at("later@example.com", -time.Hour)
+	earlier := at("earlier@example.com", -2*time.Hour)
+	unmarked := at("unmarked@example.com", -4*time.Hour)
+	upcoming := at("upcoming@example.com", time.Hour)
+	claim := at("", -time.Hour)
+	claim.StudentEmail = nil
+
+	for _, test := range []struct {
+		a        *AppointmentSlot
+		resolved bool
+	}{{resolved, true}, {later, false}, {earlier, false}} {
+		w := resolvedRequest(s, store, q, test.a, test.resolved)
+		if w.Code != http.StatusOK {
+			t.Fatalf("got status %d marking %s, want %d: %s", w.Code, *test.a.StudentEmail, http.StatusOK, w.Body.String())
+		}
+		if got := store.appointment(test.a.ID).Resolved; got == nil || *got != test.resolved {
+			t.Errorf("got resolved %v for %s, want %t", got, *test.a.StudentEmail, test.resolved)
+		}
+	}
+
+	// Appointments that haven't started or never had a student can't be
+	// marked.
+	for _, a := range []*AppointmentSlot{upcoming, claim} {
+		w := resolvedRequest(s, store, q, a, false)
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("got status %d, want %d", w.Code, http.StatusBadRequest)
+		}
+		if store.appointment(a.ID).Resolved != nil {
+			t.Error("marked appointment that can't be resolved")
+		}
+	}
+
+	// Only the ones marked unresolved are listed, oldest first.
+	r, _ := newTestRequest(http.MethodGet, "/", nil, map[string]interface{}{
+		queueContextKey:       q,
+		courseAdminContextKey: true,
+	})
+	w := httptest.NewRecorder()
+	s.GetUnresolvedAppointments(store).ServeHTTP(w, r)
+	if w.Code != http.StatusOK {
+		t.Fatalf("got status %d, want %d", w.Code, http.StatusOK)
+	}
+	var unresolved []*AppointmentSlot
+	err := json.NewDecoder(w.Body).Decode(&unresolved)
+	if err != nil {
+		t.Fatalf("failed to decode response: %v", err)
+	}
+	if len(unresolved) != 2 || unresolved[0].ID != earlier.ID || unresolved[1].ID != later.ID {
+		t.Errorf("got unresolved %v, want %s and %s", appointmentIDs(unresolved), earlier.ID, later.ID)
+	}
+	if unmarked.Resolved != nil {
+		t.Error("unmarked appointment got marked")
+	}
+
+	// Clearing the mark takes it off the list.
+	w = resolvedRequest(s, store, q, later, nil)
+	if w.Code != http.StatusOK {
+		t.Fatalf("got status %d clearing mark, want %d", w.Code, http.StatusOK)
+	}
+	if store.appointment(later.ID).Resolved != nil {
+		t.Error("mark wasn't cleared")
+	}
+	unresolved, _ = store.GetUnresolvedAppointments(context.Background(), q.ID)
+	if len(unresolved) != 1 || unresolved[0].ID != earlier.ID {
+		t.Errorf("got unresolved %v after clearing, want just %s", appointmentIDs(unresolved), earlier.ID)
+	}
+}
+
+func TestFullyBookedDay(t *testing.T) {
+	s := newTestServer()
+	store := newFakeStore()
+	q := store.addQueue(&QueueConfiguration{AppointmentSettings: AppointmentSettings{
+		FullyBookedMessage: "Try the drop-in queue!",
+	}})
+	tomorrow := int(time.Now().Local().Add(24 * time.Hour).Weekday())
+	later := int(time.Now().Local().Add(48 * time.Hour).Weekday())
+
+	// Tomorrow has two timeslots and the day after has the only other
+	// ones in the week.
+	for day, schedule := range store.schedules[q.ID] {
+		if day != later {
+			schedule.Schedule = strings.Repeat("0", 24)
+		}
+	}
+	store.schedules[q.ID][tomorrow].Schedule = "000000000011000000000000"
+	store.book(q, tomorrow, 10, "first@example.com")
+
+	day := appointmentDayRequest(t, s, store, q, tomorrow, "student@example.com", false)
+	if day.FullyBooked || day.FullyBookedMessage != "" || day.NextAvailableDay != nil {
+		t.Errorf("got fully booked %t with message %q and next day %v for a day with room, want none", day.FullyBooked, day.FullyBookedMessage, day.NextAvailableDay)
+	}
+
+	store.book(q, tomorrow, 11, "second@example.com")
+	day = appointmentDayRequest(t, s, store, q, tomorrow, "student@example.com", false)
+	if !day.FullyBooked {
+		t.Fatal("day with every timeslot taken isn't fully booked")
+	}
+	if day.FullyBookedMessage != "Try the drop-in queue!" {
+		t.Errorf("got message %q, want the queue's", day.FullyBookedMessage)
+	}
+	if day.NextAvailableDay == nil || int(*day.NextAvailableDay) != later {
+		t.Errorf("got next available day %v, want %s", day.NextAvailableDay, time.Weekday(later))
+	}
+
+	// A day with no timeslots at all is closed, not booked up.
+	closed := int(time.Now().Local().Add(72 * time.Hour).Weekday())
+	day = appointmentDayRequest(t, s, store, q, closed, "student@example.com", false)
+	if day.FullyBooked || day.NextAvailableDay != nil {
+		t.Errorf("got fully booked %t with next day %v for a closed day, want neither", day.FullyBooked, day.NextAvailableDay)
+	}
+}
+
+func TestScheduleAuditLog(t *testing.T) {
+	s := newTestServer()
+	store := newFakeStore()
+	q := store.addQueue(&QueueConfiguration{})
+	tomorrow := int(time.Now().Local().Add(24 * time.Hour).Weekday())
+	store.book(q, tomorrow, 10, "student@example.com")
+
+	first := strings.Repeat("1", 12) + strings.Repeat("0", 12)
+	w := scheduleRequest(s, store, q, tomorrow, &AppointmentSchedule{Duration: 60, Schedule: first})
+	if w.Code != http.StatusNoContent {
+		t.Fatalf("got status %d, want %d: %s", w.Code, http.StatusNoContent, w.Body.String())
+	}
+
+	// Changes that don't go through leave nothing behind.
+	w = scheduleRequest(s, store, q, tomorrow, &AppointmentSchedule{Duration: 60, Schedule: strings.Repeat("0", 24)})
+	if w.Code != http.StatusConflict {
+		t.Fatalf("got status %d removing booked timeslot, want %d", w.Code, http.StatusConflict)
+	}
+	w = scheduleRequest(s, store, q, tomorrow, &AppointmentSchedule{Duration: 0, Schedule: first})
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("got status %d with no duration, want %d", w.Code, http.StatusBadRequest)
+	}
+
+	second := strings.Repeat("2", 12) + strings.Repeat("0", 12)
+	w = scheduleRequest(s, store, q, tomorrow, &AppointmentSchedule{Duration: 60, Schedule: second})
+	if w.Code != http.StatusNoContent {
+		t.Fatalf("got status %d, want %d: %s", w.Code, http.StatusNoContent, w.Body.String())
+	}
+
+	r, _ := newTestRequest(http.MethodGet, "/", nil, map[string]interface{}{
+		queueContextKey:       q,
+		courseAdminContextKey: true,
+	})
+	w = httptest.NewRecorder()
+	s.GetScheduleAuditLog(store).ServeHTTP(w, r)
+	if w.Code != http.StatusOK {
+		t.Fatalf("got status %d, want %d", w.Code, http.StatusOK)
+	}
+	var entries []*ScheduleAuditEntry
+	err := json.NewDecoder(w.Body).Decode(&entries)
+	if err != nil {
+		t.Fatalf("failed to decode response: %v", err)
+	}
+	if len(entries) != 2 {
+		t.Fatalf("got %d audit entries, want 2", len(entries))
+	}
+
+	for i, want := range []struct{ before, after string }{
+		{strings.Repeat("1", 24), first},
+		{first, second},
+	} {
+		e := entries[i]
+		if e.Email != "admin@example.com" || e.Day != tomorrow || e.CreatedAt.IsZero() {
+			t.Errorf("entry %d: got change by %q on day %d at %v, want admin@example.com on day %d", i, e.Email, e.Day, e.CreatedAt, tomorrow)
+		}
+		if e.Before == nil || e.Before.Schedule != want.before {
+			t.Errorf("entry %d: got before %+v, want %s", i, e.Before, want.before)
+		}
+		if e.After == nil || e.After.Schedule != want.after {
+			t.Errorf("entry %d: got after %+v, want %s", i, e.After, want.after)
+		}
+	}
+}
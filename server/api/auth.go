@@ -231,7 +231,10 @@ type getUserInfo interface {
 
 func (s *Server) GetCurrentUserInfo(gi getUserInfo) E {
 	return func(w http.ResponseWriter, r *http.Request) error {
-		email := r.Context().Value(emailContextKey).(string)
+		email, ok := r.Context().Value(emailContextKey).(string)
+		if !ok {
+			return s.missingContextValue(r, emailContextKey)
+		}
 
 		admin, err := gi.SiteAdmin(r.Context(), email)
 		if err != nil {
@@ -0,0 +1,404 @@
+package api
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/segmentio/ksuid"
+)
+
+const calendarTokenPurpose = "calendar_feed"
+
+// Calendar apps hold on to subscription links indefinitely, so feed links
+// last a long time; students can grab a new one if theirs runs out.
+const calendarTokenLifetime = 365 * 24 * time.Hour
+
+// How far back the feed goes, so today's earlier appointments don't
+// vanish from calendars the moment they start.
+const calendarFeedLookback = 24 * time.Hour
+
+type getUpcomingAppointmentsForUser interface {
+	GetUpcomingAppointmentsForUser(ctx context.Context, email string, from time.Time) ([]*AppointmentSlot, error)
+}
+
+type getCalendarFeed interface {
+	getUpcomingAppointmentsForUser
+	getQueue
+}
+
+// CreateCalendarFeedLink hands out a subscribable calendar link for the
+// current user's appointments.
+func (s *Server) CreateCalendarFeedLink() E {
+	return func(w http.ResponseWriter, r *http.Request) error {
+		email, ok := r.Context().Value(emailContextKey).(string)
+		if !ok {
+			return s.missingContextValue(r, emailContextKey)
+		}
+
+		// Emails have dots in them, which would get mixed up with the
+		// token's separators.
+		subject := base64.RawURLEncoding.EncodeToString([]byte(email))
+		expires := time.Now().Add(calendarTokenLifetime)
+		token := s.newSignedToken(calendarTokenPurpose, subject, expires, "")
+
+		s.logger.Infow("created calendar feed link",
+			RequestIDContextKey, r.Context().Value(RequestIDContextKey),
+			"email", email,
+			"expires", expires,
+		)
+
+		return s.sendResponse(http.StatusCreated, struct {
+			Link    string    `json:"link"`
+			Expires time.Time `json:"expires"`
+		}{s.baseURL + "api/appointments.ics?token=" + token, expires.In(time.Local)}, w, r)
+	}
+}
+
+func (s *Server) GetCalendarFeed(gf getCalendarFeed) E {
+	return func(w http.ResponseWriter, r *http.Request) error {
+		l := s.logger.With(RequestIDContextKey, r.Context().Value(RequestIDContextKey))
+		notFound := StatusError{
+			http.StatusNotFound,
+			"That link doesn't seem to point to a calendar. Make sure you copied the whole thing!",
+		}
+
+		token, err := parseSignedToken(r.URL.Query().Get("token"))
+		if err != nil {
+			l.Warnw("failed to parse calendar token", "err", err)
+			return notFound
+		}
+
+		err = s.verifySignedToken(token, calendarTokenPurpose, "")
+		if errors.Is(err, errTokenExpired) {
+			l.Infow("got expired calendar token")
+			return StatusError{
+				http.StatusGone,
+				"That link has expired. Grab a new one from the queue!",
+			}
+		} else if err != nil {
+			l.Warnw("got calendar token with invalid signature", "err", err)
+			return notFound
+		}
+
+		email, err := base64.RawURLEncoding.DecodeString(token.Subject)
+		if err != nil {
+			l.Warnw("failed to decode email in calendar token", "err", err)
+			return notFound
+		}
+		l = l.With("email", string(email))
+
+		appointments, err := gf.GetUpcomingAppointmentsForUser(r.Context(), string(email), time.Now().Add(-calendarFeedLookback))
+		if err != nil {
+			l.Errorw("failed to get upcoming appointments", "err", err)
+			return err
+		}
+
+		names, err := queueNames(r.Context(), gf, appointments)
+		if err != nil {
+			l.Errorw("failed to get queue names", "err", err)
+			return err
+		}
+
+		// Calendar apps poll this, and we'd rather they didn't hold on
+		// to a stale copy after a cancellation.
+		w.Header().Set("Cache-Control", "no-cache")
+		s.sendCalendar(s.calendarFeed(appointments, names), w, r)
+		return nil
+	}
+}
+
+// queueNames looks up the name of every queue the appointments are in,
+// keyed by queue ID.
+func queueNames(ctx context.Context, gq getQueue, appointments []*AppointmentSlot) (map[string]string, error) {
+	names := make(map[string]string)
+	for _, a := range appointments {
+		if _, ok := names[a.Queue.String()]; ok {
+			continue
+		}
+
+		q, err := gq.GetQueue(ctx, a.Queue)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get queue %s: %w", a.Queue, err)
+		}
+		names[a.Queue.String()] = q.Name
+	}
+	return names, nil
+}
+
+func (s *Server) sendCalendar(calendar string, w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/calendar; charset=utf-8")
+	w.WriteHeader(http.StatusOK)
+	_, err := w.Write([]byte(calendar))
+	if err != nil {
+		s.logger.Warnw("failed to write response to client",
+			RequestIDContextKey, r.Context().Value(RequestIDContextKey),
+			"err", err,
+		)
+	}
+}
+
+// AgendaAppointment is an appointment as it appears on a student's agenda.
+type AgendaAppointment struct {
+	ID          ksuid.KSUID `json:"id"`
+	Queue       ksuid.KSUID `json:"queue"`
+	QueueName   string      `json:"queue_name"`
+	Start       time.Time   `json:"start"`
+	End         time.Time   `json:"end"`
+	Location    string      `json:"location,omitempty"`
+	MeetingLink string      `json:"meeting_link,omitempty"`
+}
+
+// AgendaDay is one day of a student's agenda. Days without appointments
+// are left out.
+type AgendaDay struct {
+	Date         string               `json:"date"`
+	Day          time.Weekday         `json:"day"`
+	Appointments []*AgendaAppointment `json:"appointments"`
+}
+
+// GetStudentAgenda returns the current user's appointments in every queue
+// for the coming week (today through six days from now), grouped by day
+// and in order. With ?format=ics, it's a calendar file instead.
+func (s *Server) GetStudentAgenda(gf getCalendarFeed) E {
+	return func(w http.ResponseWriter, r *http.Request) error {
+		email, ok := r.Context().Value(emailContextKey).(string)
+		if !ok {
+			return s.missingContextValue(r, emailContextKey)
+		}
+		l := s.logger.With(
+			RequestIDContextKey, r.Context().Value(RequestIDContextKey),
+			"email", email,
+		)
+
+		format := r.URL.Query().Get("format")
+		if format != "" && format != "json" && format != "ics" {
+			l.Warnw("got unknown agenda format", "format", format)
+			return StatusError{
+				http.StatusBadRequest,
+				fmt.Sprintf(`I haven't heard of the agenda format "%s"; try "json" or "ics".`, format),
+			}
+		}
+
+		today := int(time.Now().Local().Weekday())
+		start, _ := WeekdayBounds(today)
+		_, end := WeekdayBounds((today + 6) % 7)
+		upcoming, err := gf.GetUpcomingAppointmentsForUser(r.Context(), email, start)
+		if err != nil {
+			l.Errorw("failed to get upcoming appointments", "err", err)
+			return err
+		}
+
+		// They come back in order of scheduled time.
+		appointments := make([]*AppointmentSlot, 0, len(upcoming))
+		for _, a := range upcoming {
+			if a.ScheduledTime.After(end) {
+				break
+			}
+			appointments = append(appointments, a)
+		}
+
+		names, err := queueNames(r.Context(), gf, appointments)
+		if err != nil {
+			l.Errorw("failed to get queue names", "err", err)
+			return err
+		}
+
+		if format == "ics" {
+			w.Header().Set("Content-Disposition", `attachment; filename="agenda.ics"`)
+			s.sendCalendar(s.calendarFeed(appointments, names), w, r)
+			return nil
+		}
+
+		agenda := make([]*AgendaDay, 0)
+		for _, a := range appointments {
+			scheduled := a.ScheduledTime.In(time.Local)
+			date := scheduled.Format("2006-01-02")
+			if len(agenda) == 0 || agenda[len(agenda)-1].Date != date {
+				agenda = append(agenda, &AgendaDay{
+					Date: date,
+					Day:  scheduled.Weekday(),
+				})
+			}
+
+			item := &AgendaAppointment{
+				ID:        a.ID,
+				Queue:     a.Queue,
+				QueueName: names[a.Queue.String()],
+				Start:     scheduled,
+				End:       scheduled.Add(time.Duration(a.Duration) * time.Minute),
+			}
+			if a.Location != nil {
+				item.Location = *a.Location
+			}
+			if a.MeetingLink != nil {
+				item.MeetingLink = *a.MeetingLink
+			}
+
+			day := agenda[len(agenda)-1]
+			day.Appointments = append(day.Appointments, item)
+		}
+
+		return s.sendResponse(http.StatusOK, agenda, w, r)
+	}
+}
+
+const icsTimeFormat = "20060102T150405Z"
+
+// icsCalendar renders an iCalendar (RFC 5545) document called name, with
+// events writing its events a content line at a time.
+func icsCalendar(name string, events func(line func(string))) string {
+	var b strings.Builder
+	line := func(l string) {
+		b.WriteString(foldICSLine(l))
+		b.WriteString("\r\n")
+	}
+
+	line("BEGIN:VCALENDAR")
+	line("VERSION:2.0")
+	line("PRODID:-//Office Hours Queue//Appointments//EN")
+	line("CALSCALE:GREGORIAN")
+	line("METHOD:PUBLISH")
+	line("X-WR-CALNAME:" + escapeICSText(name))
+	events(line)
+	line("END:VCALENDAR")
+	return b.String()
+}
+
+// calendarFeed renders appointments as an iCalendar (RFC 5545) document.
+// Cancelled appointments are left out entirely rather than marked as
+// cancelled, since the feed is regenerated on every poll and clients drop
+// events that disappear from it.
+func (s *Server) calendarFeed(appointments []*AppointmentSlot, queueNames map[string]string) string {
+	return icsCalendar("Office Hours Appointments", func(line func(string)) {
+		now := time.Now().UTC().Format(icsTimeFormat)
+		for _, a := range appointments {
+			end := a.ScheduledTime.Add(time.Duration(a.Duration) * time.Minute)
+
+			line("BEGIN:VEVENT")
+			line("UID:" + a.ID.String() + "@office-hours-queue")
+			line("DTSTAMP:" + now)
+			line("LAST-MODIFIED:" + a.UpdatedAt.UTC().Format(icsTimeFormat))
+			line("DTSTART:" + a.ScheduledTime.UTC().Format(icsTimeFormat))
+			line("DTEND:" + end.UTC().Format(icsTimeFormat))
+			line("SUMMARY:" + escapeICSText(queueNames[a.Queue.String()]+" appointment"))
+			if a.Location != nil && *a.Location != "" {
+				line("LOCATION:" + escapeICSText(*a.Location))
+			}
+
+			description := s.baseURL + "queues/" + a.Queue.String()
+			if a.MeetingLink != nil && *a.MeetingLink != "" {
+				description = "Join: " + *a.MeetingLink + "\n" + description
+				line("URL:" + *a.MeetingLink)
+			}
+			line("DESCRIPTION:" + escapeICSText(description))
+			line("END:VEVENT")
+		}
+	})
+}
+
+// claimedScheduleFeed renders the timeslots a staff member has claimed on
+// a queue as an iCalendar document, one event per claim, at the queue's
+// location. The UIDs differ from the ones in students' feeds, so a staff
+// member who's also booked into the appointment gets both events.
+func (s *Server) claimedScheduleFeed(q *Queue, claims []*AppointmentSlot) string {
+	return icsCalendar(q.Name+" Office Hours", func(line func(string)) {
+		now := time.Now().UTC().Format(icsTimeFormat)
+		for _, a := range claims {
+			end := a.ScheduledTime.Add(time.Duration(a.Duration) * time.Minute)
+
+			line("BEGIN:VEVENT")
+			line("UID:" + a.ID.String() + "-claim@office-hours-queue")
+			line("DTSTAMP:" + now)
+			line("LAST-MODIFIED:" + a.UpdatedAt.UTC().Format(icsTimeFormat))
+			line("DTSTART:" + a.ScheduledTime.UTC().Format(icsTimeFormat))
+			line("DTEND:" + end.UTC().Format(icsTimeFormat))
+			line("SUMMARY:" + escapeICSText(q.Name+" office hours"))
+			if q.Location != "" {
+				line("LOCATION:" + escapeICSText(q.Location))
+			}
+			line("DESCRIPTION:" + escapeICSText(s.baseURL+"queues/"+q.ID.String()))
+			line("END:VEVENT")
+		}
+	})
+}
+
+// ExportTAScheduleICS returns the timeslots the current user has claimed
+// on the queue over the coming week (today through six days from now) as
+// a calendar file.
+func (s *Server) ExportTAScheduleICS(ga getAppointmentsInTimeFrame) E {
+	return func(w http.ResponseWriter, r *http.Request) error {
+		q, ok := r.Context().Value(queueContextKey).(*Queue)
+		if !ok {
+			return s.missingContextValue(r, queueContextKey)
+		}
+		email, ok := r.Context().Value(emailContextKey).(string)
+		if !ok {
+			return s.missingContextValue(r, emailContextKey)
+		}
+
+		today := int(time.Now().Local().Weekday())
+		start, _ := WeekdayBounds(today)
+		_, end := WeekdayBounds((today + 6) % 7)
+		appointments, err := ga.GetAppointments(r.Context(), q.ID, start, end)
+		if err != nil {
+			s.logger.Errorw("failed to get appointments for week",
+				RequestIDContextKey, r.Context().Value(RequestIDContextKey),
+				"queue_id", q.ID,
+				"email", email,
+				"err", err,
+			)
+			return err
+		}
+
+		claims := make([]*AppointmentSlot, 0)
+		for _, a := range appointments {
+			if a.StaffEmail != nil && *a.StaffEmail == email {
+				claims = append(claims, a)
+			}
+		}
+
+		w.Header().Set("Content-Disposition", `attachment; filename="office-hours.ics"`)
+		s.sendCalendar(s.claimedScheduleFeed(q, claims), w, r)
+		return nil
+	}
+}
+
+var icsTextEscaper = strings.NewReplacer(
+	`\`, `\\`,
+	";", `\;`,
+	",", `\,`,
+	"\r\n", `\n`,
+	"\n", `\n`,
+)
+
+func escapeICSText(s string) string {
+	return icsTextEscaper.Replace(s)
+}
+
+// foldICSLine splits content lines longer than the 75 octets RFC 5545
+// allows, without breaking up multi-byte characters.
+func foldICSLine(l string) string {
+	const limit = 75
+	if len(l) <= limit {
+		return l
+	}
+
+	var b strings.Builder
+	n := 0
+	for _, c := range l {
+		size := len(string(c))
+		if n+size > limit {
+			b.WriteString("\r\n ")
+			// The leading space on continuation lines counts.
+			n = 1
+		}
+		b.WriteRune(c)
+		n += size
+	}
+	return b.String()
+}
@@ -0,0 +1,277 @@
+package api
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+// calendarFeedLink gets a feed link for email and pulls the token out of
+// it.
+func calendarFeedLink(t *testing.T, s *Server, email string) string {
+	t.Helper()
+	r, _ := newTestRequest(http.MethodPost, "/", nil, map[string]interface{}{
+		emailContextKey: email,
+	})
+	w := httptest.NewRecorder()
+	s.CreateCalendarFeedLink().ServeHTTP(w, r)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("got status %d, want %d: %s", w.Code, http.StatusCreated, w.Body.String())
+	}
+
+	var body struct {
+		Link string `json:"link"`
+	}
+	err := json.NewDecoder(w.Body).Decode(&body)
+	if err != nil {
+		t.Fatalf("failed to decode response: %v", err)
+	}
+	link, err := url.Parse(body.Link)
+	if err != nil {
+		t.Fatalf("failed to parse link %s: %v", body.Link, err)
+	}
+	return link.Query().Get("token")
+}
+
+func calendarFeedRequest(s *Server, store *fakeStore, token string) *httptest.ResponseRecorder {
+	r, _ := newTestRequest(http.MethodGet, "/appointments.ics?token="+url.QueryEscape(token), nil, nil)
+	w := httptest.NewRecorder()
+	s.GetCalendarFeed(store).ServeHTTP(w, r)
+	return w
+}
+
+func TestCalendarFeed(t *testing.T) {
+	s := newTestServer()
+	store := newFakeStore()
+	q := store.addQueue(&QueueConfiguration{})
+	q.Name = "EECS 281"
+	other := store.addQueue(&QueueConfiguration{})
+	other.Name = "EECS 370"
+	tomorrow := int(time.Now().Local().Add(24 * time.Hour).Weekday())
+
+	mine := store.book(q, tomorrow, 10, "student@example.com")
+	partnered := store.book(other, tomorrow, 12, "friend@example.com")
+	store.partners[partnered.ID] = []string{"student@example.com"}
+	notMine := store.book(q, tomorrow, 11, "other@example.com")
+
+	token := calendarFeedLink(t, s, "student@example.com")
+	w := calendarFeedRequest(s, store, token)
+	if w.Code != http.StatusOK {
+		t.Fatalf("got status %d, want %d: %s", w.Code, http.StatusOK, w.Body.String())
+	}
+	if ct := w.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/calendar") {
+		t.Errorf("got Content-Type %s, want text/calendar", ct)
+	}
+
+	feed := w.Body.String()
+	for _, want := range []string{
+		"UID:" + mine.ID.String() + "@office-hours-queue",
+		"UID:" + partnered.ID.String() + "@office-hours-queue",
+		"SUMMARY:EECS 281 appointment",
+		"SUMMARY:EECS 370 appointment",
+		"DTSTART:" + mine.ScheduledTime.UTC().Format(icsTimeFormat),
+		"LOCATION:Here",
+	} {
+		if !strings.Contains(feed, want+"\r\n") {
+			t.Errorf("feed is missing %q:\n%s", want, feed)
+		}
+	}
+	if strings.Contains(feed, notMine.ID.String()) {
+		t.Error("feed has someone else's appointment")
+	}
+
+	// Cancelling takes it out of the feed on the next poll.
+	_, _, err := store.RemoveAppointmentSignup(nil, mine.ID)
+	if err != nil {
+		t.Fatalf("failed to cancel appointment: %v", err)
+	}
+	feed = calendarFeedRequest(s, store, token).Body.String()
+	if strings.Contains(feed, mine.ID.String()) {
+		t.Error("feed still has the cancelled appointment")
+	}
+	if !strings.Contains(feed, partnered.ID.String()) {
+		t.Error("feed lost the appointment that wasn't cancelled")
+	}
+}
+
+func TestCalendarFeedToken(t *testing.T) {
+	s := newTestServer()
+	store := newFakeStore()
+	subject := base64.RawURLEncoding.EncodeToString([]byte("student@example.com"))
+	valid := s.newSignedToken(calendarTokenPurpose, subject, time.Now().Add(time.Hour), "")
+	parts := strings.Split(valid, ".")
+
+	tests := []struct {
+		name   string
+		token  string
+		status int
+	}{
+		{"missing", "", http.StatusNotFound},
+		{"garbage", "not-a-token", http.StatusNotFound},
+		{"expired", s.newSignedToken(calendarTokenPurpose, subject, time.Now().Add(-time.Hour), ""), http.StatusGone},
+		{"other purpose", s.newSignedToken(cancelTokenPurpose, subject, time.Now().Add(time.Hour), ""), http.StatusNotFound},
+		{"other student", base64.RawURLEncoding.EncodeToString([]byte("other@example.com")) + "." + parts[1] + "." + parts[2], http.StatusNotFound},
+		{"pushed-back expiry", parts[0] + ".9999999999." + parts[2], http.StatusNotFound},
+		{"valid", valid, http.StatusOK},
+	}
+	for _, test := range tests {
+		w := calendarFeedRequest(s, store, test.token)
+		if w.Code != test.status {
+			t.Errorf("%s token: got status %d, want %d", test.name, w.Code, test.status)
+		}
+	}
+}
+
+func agendaRequest(s *Server, store *fakeStore, email, format string) *httptest.ResponseRecorder {
+	r, _ := newTestRequest(http.MethodGet, "/?format="+format, nil, map[string]interface{}{
+		emailContextKey: email,
+	})
+	w := httptest.NewRecorder()
+	s.GetStudentAgenda(store).ServeHTTP(w, r)
+	return w
+}
+
+func TestStudentAgenda(t *testing.T) {
+	s := newTestServer()
+	store := newFakeStore()
+	q := store.addQueue(&QueueConfiguration{})
+	q.Name = "EECS 281"
+	other := store.addQueue(&QueueConfiguration{})
+	other.Name = "EECS 370"
+	tomorrow := int(time.Now().Local().Add(24 * time.Hour).Weekday())
+	later := int(time.Now().Local().Add(48 * time.Hour).Weekday())
+
+	afternoon := store.book(q, tomorrow, 14, "student@example.com")
+	morning := store.book(other, tomorrow, 10, "student@example.com")
+	next := store.book(q, later, 9, "student@example.com")
+	nextWeek := store.book(q, later, 9, "student@example.com")
+	nextWeek.ScheduledTime = nextWeek.ScheduledTime.Add(7 * 24 * time.Hour)
+	store.book(q, tomorrow, 12, "other@example.com")
+
+	w := agendaRequest(s, store, "student@example.com", "")
+	if w.Code != http.StatusOK {
+		t.Fatalf("got status %d, want %d: %s", w.Code, http.StatusOK, w.Body.String())
+	}
+	var agenda []*AgendaDay
+	err := json.NewDecoder(w.Body).Decode(&agenda)
+	if err != nil {
+		t.Fatalf("failed to decode response: %v", err)
+	}
+
+	want := [][]*AppointmentSlot{{morning, afternoon}, {next}}
+	if len(agenda) != len(want) {
+		t.Fatalf("got %d days, want %d", len(agenda), len(want))
+	}
+	for i, day := range agenda {
+		date := want[i][0].ScheduledTime.In(time.Local)
+		if day.Date != date.Format("2006-01-02") || day.Day != date.Weekday() {
+			t.Errorf("got day %s (%s), want %s", day.Date, day.Day, date.Format("2006-01-02"))
+		}
+		if len(day.Appointments) != len(want[i]) {
+			t.Errorf("got %d appointments on %s, want %d", len(day.Appointments), day.Date, len(want[i]))
+			continue
+		}
+		for j, a := range day.Appointments {
+			if a.ID != want[i][j].ID {
+				t.Errorf("got appointment %s at position %d on %s, want %s", a.ID, j, day.Date, want[i][j].ID)
+			}
+			if !a.Start.Equal(want[i][j].ScheduledTime) || a.End.Sub(a.Start) != time.Hour {
+				t.Errorf("got appointment from %s to %s, want an hour from %s", a.Start, a.End, want[i][j].ScheduledTime)
+			}
+			if a.Location != "Here" {
+				t.Errorf("got location %q, want Here", a.Location)
+			}
+		}
+	}
+	if agenda[0].Appointments[0].QueueName != "EECS 370" || agenda[0].Appointments[1].QueueName != "EECS 281" {
+		t.Errorf("got queue names %q and %q, want EECS 370 and EECS 281", agenda[0].Appointments[0].QueueName, agenda[0].Appointments[1].QueueName)
+	}
+
+	w = agendaRequest(s, store, "student@example.com", "ics")
+	if w.Code != http.StatusOK {
+		t.Fatalf("got status %d, want %d: %s", w.Code, http.StatusOK, w.Body.String())
+	}
+	if ct := w.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/calendar") {
+		t.Errorf("got Content-Type %s, want text/calendar", ct)
+	}
+	feed := w.Body.String()
+	for _, a := range []*AppointmentSlot{morning, afternoon, next} {
+		if !strings.Contains(feed, "UID:"+a.ID.String()+"@office-hours-queue\r\n") {
+			t.Errorf("calendar is missing appointment %s", a.ID)
+		}
+	}
+	if strings.Contains(feed, nextWeek.ID.String()) {
+		t.Error("calendar has an appointment from past the coming week")
+	}
+
+	w = agendaRequest(s, store, "student@example.com", "pdf")
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("got status %d for unknown format, want %d", w.Code, http.StatusBadRequest)
+	}
+}
+
+func TestExportTAScheduleICS(t *testing.T) {
+	s := newTestServer()
+	store := newFakeStore()
+	q := store.addQueue(&QueueConfiguration{})
+	q.Name = "EECS 281"
+	q.Location = "BBB 1620, by the windows"
+	tomorrow := int(time.Now().Local().Add(24 * time.Hour).Weekday())
+	later := int(time.Now().Local().Add(48 * time.Hour).Weekday())
+
+	empty := store.claim(q, tomorrow, 10, "ta@example.com")
+	booked := store.book(q, later, 14, "student@example.com")
+	staff := "ta@example.com"
+	booked.StaffEmail = &staff
+	notMine := store.claim(q, tomorrow, 11, "other@example.com")
+	unclaimed := store.book(q, tomorrow, 12, "other-student@example.com")
+
+	r, _ := newTestRequest(http.MethodGet, "/", nil, map[string]interface{}{
+		queueContextKey:       q,
+		emailContextKey:       "ta@example.com",
+		courseAdminContextKey: true,
+	})
+	w := httptest.NewRecorder()
+	s.ExportTAScheduleICS(store).ServeHTTP(w, r)
+	if w.Code != http.StatusOK {
+		t.Fatalf("got status %d, want %d: %s", w.Code, http.StatusOK, w.Body.String())
+	}
+	if ct := w.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/calendar") {
+		t.Errorf("got Content-Type %s, want text/calendar", ct)
+	}
+
+	feed := w.Body.String()
+	if n := strings.Count(feed, "BEGIN:VEVENT\r\n"); n != 2 {
+		t.Errorf("got %d events, want one per claimed timeslot", n)
+	}
+	for _, a := range []*AppointmentSlot{empty, booked} {
+		end := a.ScheduledTime.Add(time.Duration(a.Duration) * time.Minute)
+		for _, want := range []string{
+			"UID:" + a.ID.String() + "-claim@office-hours-queue",
+			"DTSTART:" + a.ScheduledTime.UTC().Format(icsTimeFormat),
+			"DTEND:" + end.UTC().Format(icsTimeFormat),
+		} {
+			if !strings.Contains(feed, want+"\r\n") {
+				t.Errorf("feed is missing %q:\n%s", want, feed)
+			}
+		}
+	}
+	for _, want := range []string{
+		"SUMMARY:EECS 281 office hours",
+		`LOCATION:BBB 1620\, by the windows`,
+	} {
+		if !strings.Contains(feed, want+"\r\n") {
+			t.Errorf("feed is missing %q:\n%s", want, feed)
+		}
+	}
+	for _, a := range []*AppointmentSlot{notMine, unclaimed} {
+		if strings.Contains(feed, a.ID.String()) {
+			t.Errorf("feed has timeslot %d, which isn't claimed by ta@example.com", a.Timeslot)
+		}
+	}
+}
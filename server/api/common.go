@@ -4,6 +4,7 @@ import (
 	"context"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"net/http"
 	"time"
 
@@ -22,6 +23,33 @@ type StatusError struct {
 
 func (s StatusError) Error() string { return s.message }
 
+// A DetailedError is a StatusError that also carries a machine-readable
+// code and details, for errors the frontend needs to do more with than
+// show the message.
+type DetailedError struct {
+	StatusError
+	code    string
+	details interface{}
+}
+
+func (d DetailedError) Unwrap() error { return d.StatusError }
+
+var errMissingContextValue = errors.New("missing request context value")
+
+// missingContextValue is what a handler returns when a value it needs from
+// the request context isn't there, which only happens if its route is
+// missing the middleware that puts it there. The request fails (and its
+// transaction is rolled back) like any other internal error. It's logged
+// here, since the handler doesn't have anything to add.
+func (s *Server) missingContextValue(r *http.Request, key string) error {
+	s.logger.Errorw("handler ran without required context value (is the route missing middleware?)",
+		RequestIDContextKey, r.Context().Value(RequestIDContextKey),
+		"path", r.URL.Path,
+		"key", key,
+	)
+	return fmt.Errorf("%w: %s", errMissingContextValue, key)
+}
+
 // A custom handler wrapper to support the use of error-returning
 // handlers that have their errors serialized automatically.
 func (e E) ServeHTTP(w http.ResponseWriter, r *http.Request) {
@@ -38,7 +66,9 @@ func (e E) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		}
 
 		m := struct {
-			Message string `json:"message"`
+			Message string      `json:"message"`
+			Code    string      `json:"code,omitempty"`
+			Details interface{} `json:"details,omitempty"`
 		}{}
 		var s StatusError
 		if !errors.As(err, &s) {
@@ -46,6 +76,12 @@ func (e E) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		}
 		m.Message = s.message
 
+		var d DetailedError
+		if errors.As(err, &d) {
+			m.Code = d.code
+			m.Details = d.details
+		}
+
 		w.Header().Add("Content-Type", "application/json")
 		w.WriteHeader(s.status)
 		json.NewEncoder(w).Encode(m)
@@ -53,6 +89,28 @@ func (e E) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// The most a handler using decodeLimitedBody reads from a request body,
+// unless QUEUE_MAX_BODY_BYTES says otherwise.
+const defaultMaxBodySize = 64 * 1024
+
+var errBodyTooLarge = StatusError{
+	http.StatusRequestEntityTooLarge,
+	"That request is way too big! Try trimming it down a bit.",
+}
+
+// decodeLimitedBody decodes a JSON request body into v, returning
+// errBodyTooLarge rather than reading past the server's limit.
+func (s *Server) decodeLimitedBody(w http.ResponseWriter, r *http.Request, v interface{}) error {
+	r.Body = http.MaxBytesReader(w, r.Body, s.maxBodySize)
+	err := json.NewDecoder(r.Body).Decode(v)
+
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		return errBodyTooLarge
+	}
+	return err
+}
+
 func internalServerError(r *http.Request) StatusError {
 	return StatusError{status: http.StatusInternalServerError,
 		message: "Oops! Something bad happened on our end. If this is happening consistently, please get in touch with us, and include the following ID: " +
@@ -63,6 +121,8 @@ func internalServerError(r *http.Request) StatusError {
 
 type ErrorMessage struct {
 	Message string `json:"message"`
+	// The same machine-readable codes DetailedErrors carry from handlers.
+	Code string `json:"code,omitempty"`
 }
 
 func (s *Server) errorMessage(status int, message string, w http.ResponseWriter, r *http.Request) {
@@ -73,6 +133,16 @@ func (s *Server) errorMessage(status int, message string, w http.ResponseWriter,
 	)
 }
 
+// errorCode is errorMessage with a code, for middleware errors that
+// clients need to tell apart.
+func (s *Server) errorCode(status int, code, message string, w http.ResponseWriter, r *http.Request) {
+	s.sendResponse(
+		status,
+		ErrorMessage{Message: message, Code: code},
+		w, r,
+	)
+}
+
 func (s *Server) internalServerError(w http.ResponseWriter, r *http.Request) {
 	s.sendResponse(
 		http.StatusInternalServerError,
@@ -84,9 +154,13 @@ func (s *Server) internalServerError(w http.ResponseWriter, r *http.Request) {
 }
 
 func (s *Server) sendResponse(code int, data interface{}, w http.ResponseWriter, r *http.Request) error {
+	return s.sendResponseWithType(code, "application/json", data, w, r)
+}
+
+func (s *Server) sendResponseWithType(code int, contentType string, data interface{}, w http.ResponseWriter, r *http.Request) error {
 	var body []byte
 	if data != nil {
-		w.Header().Add("Content-Type", "application/json")
+		w.Header().Add("Content-Type", contentType)
 		var err error
 		body, err = json.Marshal(data)
 		if err != nil {
@@ -136,12 +210,46 @@ func WeekdayBounds(day int) (start time.Time, end time.Time) {
 	return
 }
 
-// TimeslotToTime converts an appointment timeslot number to its time.
+// SlotStart converts an appointment timeslot number to its time, on the
+// day starting at dayStart (from WeekdayBounds). Timeslots are always
+// the schedule's duration long, whatever the appointments in them are.
 // Takes daylight savings time into account (i.e. it gives the "normal" time,
 // rather than just the index of the timeslot in the day in terms of minutes)
-func TimeslotToTime(day, timeslot, duration int) time.Time {
-	start, _ := WeekdayBounds(day)
-	return time.Date(start.Year(), start.Month(), start.Day(), (timeslot*duration)/60, (timeslot*duration)%60, 0, 0, start.Local().Location())
+func SlotStart(dayStart time.Time, timeslot int, schedule *AppointmentSchedule) time.Time {
+	minutes := timeslot * schedule.Duration
+	return time.Date(dayStart.Year(), dayStart.Month(), dayStart.Day(), minutes/60, minutes%60, 0, 0, dayStart.Local().Location())
+}
+
+// RelativeTime describes how far t is from now, like "in 2 hours" or
+// "5 minutes ago". It rounds toward zero, so an appointment 90 minutes
+// out is "in 1 hour".
+func RelativeTime(t, now time.Time) string {
+	d := t.Sub(now)
+	future := d >= 0
+	if !future {
+		d = -d
+	}
+
+	var n int
+	var unit string
+	switch {
+	case d < time.Minute:
+		return "now"
+	case d < time.Hour:
+		n, unit = int(d/time.Minute), "minute"
+	case d < 24*time.Hour:
+		n, unit = int(d/time.Hour), "hour"
+	default:
+		n, unit = int(d/(24*time.Hour)), "day"
+	}
+
+	if n != 1 {
+		unit += "s"
+	}
+	if future {
+		return fmt.Sprintf("in %d %s", n, unit)
+	}
+	return fmt.Sprintf("%d %s ago", n, unit)
 }
 
 // BigTime returns (roughly) the maximum time representable by PostgreSQL.
@@ -0,0 +1,128 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/cskr/pubsub"
+	"github.com/go-chi/chi"
+	"github.com/segmentio/ksuid"
+	"go.uber.org/zap"
+)
+
+// newTestServer gets a Server with just enough set up for handlers to
+// run outside of New, which needs keys and a database.
+func newTestServer() *Server {
+	return &Server{
+		logger:      zap.NewNop().Sugar(),
+		ps:          pubsub.New(16),
+		events:      NoopEventPublisher{},
+		meetings:    NoopMeetingProvider{},
+		maxBodySize: defaultMaxBodySize,
+		tokenKey:    []byte("test token key"),
+		now:         time.Now,
+	}
+}
+
+// newTestRequest builds a request with the context values that the
+// router's middleware would set up, plus whatever's in values.
+func newTestRequest(method, target string, body io.Reader, values map[string]interface{}) (*http.Request, *error) {
+	r := httptest.NewRequest(method, target, body)
+
+	var err error
+	ctx := context.WithValue(r.Context(), RequestIDContextKey, ksuid.New())
+	ctx = context.WithValue(ctx, RequestErrorContextKey, &err)
+	for k, v := range values {
+		ctx = context.WithValue(ctx, k, v)
+	}
+	return r.WithContext(ctx), &err
+}
+
+// withURLParam sets a route parameter on r, like chi would when matching
+// the route.
+func withURLParam(r *http.Request, key, value string) *http.Request {
+	rctx, ok := r.Context().Value(chi.RouteCtxKey).(*chi.Context)
+	if !ok {
+		rctx = chi.NewRouteContext()
+		r = r.WithContext(context.WithValue(r.Context(), chi.RouteCtxKey, rctx))
+	}
+	rctx.URLParams.Add(key, value)
+	return r
+}
+
+func TestHandlerWithoutMiddleware(t *testing.T) {
+	s := newTestServer()
+
+	// No queue in the context, like if the route were mounted without
+	// the queue middleware.
+	r, reqErr := newTestRequest(http.MethodGet, "/appointments/schedule", nil, nil)
+	w := httptest.NewRecorder()
+	s.GetAppointmentSchedule(nil).ServeHTTP(w, r)
+
+	if w.Code != http.StatusInternalServerError {
+		t.Errorf("got status %d, want %d", w.Code, http.StatusInternalServerError)
+	}
+	if !errors.Is(*reqErr, errMissingContextValue) {
+		t.Errorf("got request error %v, want %v", *reqErr, errMissingContextValue)
+	}
+
+	var body ErrorMessage
+	err := json.NewDecoder(w.Body).Decode(&body)
+	if err != nil {
+		t.Fatalf("failed to decode error response: %v", err)
+	}
+	if body.Message == "" {
+		t.Error("got empty error message")
+	}
+}
+
+func TestMiddlewareWithoutPrerequisite(t *testing.T) {
+	s := newTestServer()
+
+	// EnsureCourseAdmin needs CheckCourseAdmin (and a login) ahead of it.
+	r, _ := newTestRequest(http.MethodGet, "/", nil, map[string]interface{}{
+		queueContextKey: &Queue{ID: ksuid.New(), Course: ksuid.New()},
+		emailContextKey: "staff@example.com",
+	})
+	w := httptest.NewRecorder()
+	called := false
+	s.EnsureCourseAdmin(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		called = true
+	})).ServeHTTP(w, r)
+
+	if called {
+		t.Error("next handler ran without course admin status")
+	}
+	if w.Code != http.StatusInternalServerError {
+		t.Errorf("got status %d, want %d", w.Code, http.StatusInternalServerError)
+	}
+}
+
+func TestRelativeTime(t *testing.T) {
+	now := time.Date(2021, time.March, 15, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		offset time.Duration
+		want   string
+	}{
+		{30 * time.Second, "now"},
+		{-30 * time.Second, "now"},
+		{time.Minute, "in 1 minute"},
+		{45 * time.Minute, "in 45 minutes"},
+		{90 * time.Minute, "in 1 hour"},
+		{2 * time.Hour, "in 2 hours"},
+		{-150 * time.Minute, "2 hours ago"},
+		{-24 * time.Hour, "1 day ago"},
+		{3*24*time.Hour + time.Hour, "in 3 days"},
+	}
+	for _, test := range tests {
+		if got := RelativeTime(now.Add(test.offset), now); got != test.want {
+			t.Errorf("got %q for %s from now, want %q", got, test.offset, test.want)
+		}
+	}
+}
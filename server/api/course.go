@@ -79,7 +79,12 @@ func (s *Server) CheckCourseAdmin(ca courseAdmin) func(http.Handler) http.Handle
 			if ok {
 				courseID = course.ID
 			} else {
-				q := r.Context().Value(queueContextKey).(*Queue)
+				q, ok := r.Context().Value(queueContextKey).(*Queue)
+				if !ok {
+					s.missingContextValue(r, queueContextKey)
+					s.internalServerError(w, r)
+					return
+				}
 				courseID = q.Course
 			}
 
@@ -115,12 +120,27 @@ func (s *Server) EnsureCourseAdmin(next http.Handler) http.Handler {
 		if ok {
 			courseID = course.ID
 		} else {
-			q := r.Context().Value(queueContextKey).(*Queue)
+			q, ok := r.Context().Value(queueContextKey).(*Queue)
+			if !ok {
+				s.missingContextValue(r, queueContextKey)
+				s.internalServerError(w, r)
+				return
+			}
 			courseID = q.Course
 		}
 
-		email := r.Context().Value(emailContextKey).(string)
-		admin := r.Context().Value(courseAdminContextKey).(bool)
+		email, ok := r.Context().Value(emailContextKey).(string)
+		if !ok {
+			s.missingContextValue(r, emailContextKey)
+			s.internalServerError(w, r)
+			return
+		}
+		admin, ok := r.Context().Value(courseAdminContextKey).(bool)
+		if !ok {
+			s.missingContextValue(r, courseAdminContextKey)
+			s.internalServerError(w, r)
+			return
+		}
 		if !admin {
 			s.logger.Warnw("non-admin attempting to access resource requiring course admin",
 				RequestIDContextKey, r.Context().Value(RequestIDContextKey),
@@ -176,7 +196,10 @@ type getQueues interface {
 
 func (s *Server) GetQueues(gq getQueues) E {
 	return func(w http.ResponseWriter, r *http.Request) error {
-		c := r.Context().Value(courseContextKey).(*Course)
+		c, ok := r.Context().Value(courseContextKey).(*Course)
+		if !ok {
+			return s.missingContextValue(r, courseContextKey)
+		}
 
 		queues, err := gq.GetQueues(r.Context(), c.ID)
 		if err != nil {
@@ -234,7 +257,7 @@ func (s *Server) AddCourse(ac addCourse) E {
 		s.logger.Infow("created course",
 			RequestIDContextKey, r.Context().Value(RequestIDContextKey),
 			"course_id", newCourse.ID,
-			"email", r.Context().Value(emailContextKey).(string),
+			"email", r.Context().Value(emailContextKey),
 		)
 		return s.sendResponse(http.StatusCreated, newCourse, w, r)
 	}
@@ -246,7 +269,10 @@ type updateCourse interface {
 
 func (s *Server) UpdateCourse(uc updateCourse) E {
 	return func(w http.ResponseWriter, r *http.Request) error {
-		course := r.Context().Value(courseContextKey).(*Course)
+		course, ok := r.Context().Value(courseContextKey).(*Course)
+		if !ok {
+			return s.missingContextValue(r, courseContextKey)
+		}
 
 		var bodyCourse Course
 		err := json.NewDecoder(r.Body).Decode(&bodyCourse)
@@ -284,7 +310,7 @@ func (s *Server) UpdateCourse(uc updateCourse) E {
 		s.logger.Infow("updated course",
 			RequestIDContextKey, r.Context().Value(RequestIDContextKey),
 			"course_id", course.ID,
-			"email", r.Context().Value(emailContextKey).(string),
+			"email", r.Context().Value(emailContextKey),
 		)
 		return s.sendResponse(http.StatusNoContent, nil, w, r)
 	}
@@ -296,13 +322,16 @@ type deleteCourse interface {
 
 func (s *Server) DeleteCourse(dc deleteCourse) E {
 	return func(w http.ResponseWriter, r *http.Request) error {
-		course := r.Context().Value(courseContextKey).(*Course)
+		course, ok := r.Context().Value(courseContextKey).(*Course)
+		if !ok {
+			return s.missingContextValue(r, courseContextKey)
+		}
 
 		err := dc.DeleteCourse(r.Context(), course.ID)
 		if err != nil {
 			s.logger.Errorw("failed to delete course",
 				RequestIDContextKey, r.Context().Value(RequestIDContextKey),
-				"email", r.Context().Value(emailContextKey).(string),
+				"email", r.Context().Value(emailContextKey),
 				"err", err,
 			)
 			return err
@@ -311,7 +340,7 @@ func (s *Server) DeleteCourse(dc deleteCourse) E {
 		s.logger.Infow("deleted course",
 			RequestIDContextKey, r.Context().Value(RequestIDContextKey),
 			"course_id", course.ID,
-			"email", r.Context().Value(emailContextKey).(string),
+			"email", r.Context().Value(emailContextKey),
 		)
 		return s.sendResponse(http.StatusNoContent, nil, w, r)
 	}
@@ -333,7 +362,10 @@ type addQueue interface {
 
 func (s *Server) AddQueue(aq addQueue) E {
 	return func(w http.ResponseWriter, r *http.Request) error {
-		c := r.Context().Value(courseContextKey).(*Course)
+		c, ok := r.Context().Value(courseContextKey).(*Course)
+		if !ok {
+			return s.missingContextValue(r, courseContextKey)
+		}
 		l := s.logger.With(
 			RequestIDContextKey, r.Context().Value(RequestIDContextKey),
 			"course_id", c.ID,
@@ -372,7 +404,7 @@ func (s *Server) AddQueue(aq addQueue) E {
 		}
 		l.Infow("created queue",
 			"queue_id", newQueue.ID,
-			"email", r.Context().Value(emailContextKey).(string),
+			"email", r.Context().Value(emailContextKey),
 		)
 
 		for day := 0; day < 7; day++ {
@@ -407,7 +439,10 @@ type getCourseAdmins interface {
 
 func (s *Server) GetCourseAdmins(ga getCourseAdmins) E {
 	return func(w http.ResponseWriter, r *http.Request) error {
-		c := r.Context().Value(courseContextKey).(*Course)
+		c, ok := r.Context().Value(courseContextKey).(*Course)
+		if !ok {
+			return s.missingContextValue(r, courseContextKey)
+		}
 
 		admins, err := ga.GetCourseAdmins(r.Context(), c.ID)
 		if err != nil {
@@ -429,8 +464,14 @@ type addCourseAdmins interface {
 
 func (s *Server) AddCourseAdmins(aa addCourseAdmins) E {
 	return func(w http.ResponseWriter, r *http.Request) error {
-		c := r.Context().Value(courseContextKey).(*Course)
-		email := r.Context().Value(emailContextKey).(string)
+		c, ok := r.Context().Value(courseContextKey).(*Course)
+		if !ok {
+			return s.missingContextValue(r, courseContextKey)
+		}
+		email, ok := r.Context().Value(emailContextKey).(string)
+		if !ok {
+			return s.missingContextValue(r, emailContextKey)
+		}
 		l := s.logger.With(
 			RequestIDContextKey, r.Context().Value(RequestIDContextKey),
 			"course_id", c.ID,
@@ -467,8 +508,14 @@ func (s *Server) AddCourseAdmins(aa addCourseAdmins) E {
 
 func (s *Server) UpdateCourseAdmins(aa addCourseAdmins) E {
 	return func(w http.ResponseWriter, r *http.Request) error {
-		c := r.Context().Value(courseContextKey).(*Course)
-		email := r.Context().Value(emailContextKey).(string)
+		c, ok := r.Context().Value(courseContextKey).(*Course)
+		if !ok {
+			return s.missingContextValue(r, courseContextKey)
+		}
+		email, ok := r.Context().Value(emailContextKey).(string)
+		if !ok {
+			return s.missingContextValue(r, emailContextKey)
+		}
 		l := s.logger.With(
 			RequestIDContextKey, r.Context().Value(RequestIDContextKey),
 			"course_id", c.ID,
@@ -502,8 +549,14 @@ type removeCourseAdmins interface {
 
 func (s *Server) RemoveCourseAdmins(ra removeCourseAdmins) E {
 	return func(w http.ResponseWriter, r *http.Request) error {
-		c := r.Context().Value(courseContextKey).(*Course)
-		email := r.Context().Value(emailContextKey).(string)
+		c, ok := r.Context().Value(courseContextKey).(*Course)
+		if !ok {
+			return s.missingContextValue(r, courseContextKey)
+		}
+		email, ok := r.Context().Value(emailContextKey).(string)
+		if !ok {
+			return s.missingContextValue(r, emailContextKey)
+		}
 		l := s.logger.With(
 			RequestIDContextKey, r.Context().Value(RequestIDContextKey),
 			"course_id", c.ID,
@@ -0,0 +1,208 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/segmentio/ksuid"
+)
+
+const (
+	maxCustomFields           = 20
+	maxCustomFieldNameLength  = 50
+	maxCustomFieldValueLength = 1000
+)
+
+type getCustomFieldDefinitions interface {
+	GetCustomFieldDefinitions(ctx context.Context, queue ksuid.KSUID) ([]*CustomFieldDefinition, error)
+}
+
+type appointmentCustomFields interface {
+	getCustomFieldDefinitions
+	SetAppointmentCustomFields(ctx context.Context, appointment ksuid.KSUID, fields map[string]interface{}) error
+}
+
+type getAppointmentCustomFieldsInTimeFrame interface {
+	GetAppointmentCustomFieldsInTimeFrame(ctx context.Context, queue ksuid.KSUID, from, to time.Time) (map[ksuid.KSUID]map[string]interface{}, error)
+}
+
+func (s *Server) GetCustomFieldDefinitions(gd getCustomFieldDefinitions) E {
+	return func(w http.ResponseWriter, r *http.Request) error {
+		q, ok := r.Context().Value(queueContextKey).(*Queue)
+		if !ok {
+			return s.missingContextValue(r, queueContextKey)
+		}
+
+		definitions, err := gd.GetCustomFieldDefinitions(r.Context(), q.ID)
+		if err != nil {
+			s.logger.Errorw("failed to get custom field definitions",
+				RequestIDContextKey, r.Context().Value(RequestIDContextKey),
+				"queue_id", q.ID,
+				"err", err,
+			)
+			return err
+		}
+
+		return s.sendResponse(http.StatusOK, definitions, w, r)
+	}
+}
+
+type updateCustomFieldDefinitions interface {
+	SetCustomFieldDefinitions(ctx context.Context, queue ksuid.KSUID, definitions []*CustomFieldDefinition) error
+}
+
+// UpdateCustomFieldDefinitions replaces the custom fields a queue asks for
+// on appointments. Answers already given to fields that go away are kept,
+// but aren't asked for anymore.
+func (s *Server) UpdateCustomFieldDefinitions(ud updateCustomFieldDefinitions) E {
+	return func(w http.ResponseWriter, r *http.Request) error {
+		q, ok := r.Context().Value(queueContextKey).(*Queue)
+		if !ok {
+			return s.missingContextValue(r, queueContextKey)
+		}
+		l := s.logger.With(
+			RequestIDContextKey, r.Context().Value(RequestIDContextKey),
+			"queue_id", q.ID,
+			"email", r.Context().Value(emailContextKey),
+		)
+
+		var definitions []*CustomFieldDefinition
+		err := s.decodeLimitedBody(w, r, &definitions)
+		if errors.Is(err, errBodyTooLarge) {
+			l.Warnw("custom field definitions request body too large")
+			return err
+		}
+		if err != nil {
+			l.Warnw("failed to decode custom field definitions from body", "err", err)
+			return StatusError{
+				http.StatusBadRequest,
+				"We couldn't read the custom fields in the request body.",
+			}
+		}
+
+		if len(definitions) > maxCustomFields {
+			l.Warnw("got too many custom fields", "num_fields", len(definitions))
+			return StatusError{
+				http.StatusBadRequest,
+				fmt.Sprintf("Queues can have at most %d custom fields.", maxCustomFields),
+			}
+		}
+
+		seen := make(map[string]bool)
+		for _, d := range definitions {
+			if d == nil {
+				l.Warnw("got null custom field definition")
+				return StatusError{
+					http.StatusBadRequest,
+					"Every custom field needs a name and a type.",
+				}
+			}
+
+			d.Name = strings.TrimSpace(d.Name)
+			if d.Name == "" || utf8.RuneCountInString(d.Name) > maxCustomFieldNameLength {
+				l.Warnw("got custom field with invalid name", "name", d.Name)
+				return StatusError{
+					http.StatusBadRequest,
+					fmt.Sprintf("Custom field names need to be between 1 and %d characters long.", maxCustomFieldNameLength),
+				}
+			}
+
+			if seen[d.Name] {
+				l.Warnw("got duplicate custom field", "name", d.Name)
+				return StatusError{
+					http.StatusBadRequest,
+					fmt.Sprintf(`There's more than one custom field called "%s".`, d.Name),
+				}
+			}
+			seen[d.Name] = true
+
+			switch d.Type {
+			case CustomFieldText, CustomFieldNumber, CustomFieldBoolean:
+			default:
+				l.Warnw("got custom field with unknown type", "name", d.Name, "type", d.Type)
+				return StatusError{
+					http.StatusBadRequest,
+					fmt.Sprintf(`I haven't heard of the custom field type "%s"; try "text", "number", or "boolean".`, d.Type),
+				}
+			}
+		}
+
+		err = ud.SetCustomFieldDefinitions(r.Context(), q.ID, definitions)
+		if err != nil {
+			l.Errorw("failed to set custom field definitions", "err", err)
+			return err
+		}
+
+		l.Infow("updated custom field definitions", "num_fields", len(definitions))
+
+		s.ps.Pub(WS("REFRESH", nil), QueueTopicGeneric(q.ID))
+
+		return s.sendResponse(http.StatusNoContent, nil, w, r)
+	}
+}
+
+// validateCustomFields checks an appointment's custom fields against the
+// queue's definitions: every required field has to be there, and nothing
+// that isn't defined can be. Empty text counts as leaving the field out.
+func validateCustomFields(definitions []*CustomFieldDefinition, fields map[string]interface{}) error {
+	defined := make(map[string]*CustomFieldDefinition)
+	for _, d := range definitions {
+		defined[d.Name] = d
+	}
+
+	for name, v := range fields {
+		d, ok := defined[name]
+		if !ok {
+			return StatusError{
+				http.StatusBadRequest,
+				fmt.Sprintf(`This queue doesn't ask for "%s".`, name),
+			}
+		}
+
+		var valid bool
+		switch d.Type {
+		case CustomFieldText:
+			var text string
+			text, valid = v.(string)
+			if valid && utf8.RuneCountInString(text) > maxCustomFieldValueLength {
+				return StatusError{
+					http.StatusBadRequest,
+					fmt.Sprintf(`The answer to "%s" is too long; it can be at most %d characters.`, name, maxCustomFieldValueLength),
+				}
+			}
+		case CustomFieldNumber:
+			// JSON numbers always decode as float64.
+			_, valid = v.(float64)
+		case CustomFieldBoolean:
+			_, valid = v.(bool)
+		}
+
+		if !valid {
+			return StatusError{
+				http.StatusBadRequest,
+				fmt.Sprintf(`The answer to "%s" needs to be a %s.`, name, d.Type),
+			}
+		}
+	}
+
+	for _, d := range definitions {
+		if !d.Required {
+			continue
+		}
+
+		v, ok := fields[d.Name]
+		if text, isText := v.(string); !ok || (isText && strings.TrimSpace(text) == "") {
+			return StatusError{
+				http.StatusBadRequest,
+				fmt.Sprintf(`"%s" is required.`, d.Name),
+			}
+		}
+	}
+
+	return nil
+}
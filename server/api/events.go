@@ -0,0 +1,82 @@
+package api
+
+import (
+	"context"
+	"time"
+
+	"github.com/segmentio/ksuid"
+	"go.uber.org/zap"
+)
+
+// AppointmentEventVersion is bumped whenever the shape of
+// AppointmentEvent changes in a way consumers need to know about.
+const AppointmentEventVersion = 1
+
+type AppointmentEventType string
+
+const (
+	AppointmentSignup     AppointmentEventType = "signup"
+	AppointmentCancel     AppointmentEventType = "cancel"
+	AppointmentClaim      AppointmentEventType = "claim"
+	AppointmentReschedule AppointmentEventType = "reschedule"
+)
+
+// AppointmentEvent is what gets sent to systems downstream of the queue
+// when something happens to an appointment. Unlike WebSocket events,
+// these always carry the full appointment.
+type AppointmentEvent struct {
+	ID          ksuid.KSUID          `json:"id"`
+	Version     int                  `json:"version"`
+	Type        AppointmentEventType `json:"type"`
+	Queue       ksuid.KSUID          `json:"queue"`
+	Time        time.Time            `json:"time"`
+	Appointment *AppointmentSlot     `json:"appointment"`
+
+	// For reschedules, the appointment as it was before the move.
+	Previous *AppointmentSlot `json:"previous,omitempty"`
+}
+
+// EventPublisher sends appointment events somewhere durable. See the
+// events package for a NATS implementation.
+type EventPublisher interface {
+	Publish(ctx context.Context, event *AppointmentEvent) error
+}
+
+// NoopEventPublisher drops every event. It's what the server uses when
+// nothing else is configured.
+type NoopEventPublisher struct{}
+
+func (NoopEventPublisher) Publish(context.Context, *AppointmentEvent) error { return nil }
+
+// How long publishing an event can take before it's given up on.
+const eventPublishTimeout = 5 * time.Second
+
+// publishAppointmentEvent sends an event once the request's transaction
+// commits, so changes that get rolled back never go out. It logs rather
+// than failing anything if the publisher can't be reached: the change to
+// the appointment has already happened by then.
+func (s *Server) publishAppointmentEvent(ctx context.Context, l *zap.SugaredLogger, t AppointmentEventType, queue ksuid.KSUID, appointment, previous *AppointmentSlot) {
+	event := &AppointmentEvent{
+		ID:          ksuid.New(),
+		Version:     AppointmentEventVersion,
+		Type:        t,
+		Queue:       queue,
+		Time:        time.Now(),
+		Appointment: appointment,
+		Previous:    previous,
+	}
+
+	afterCommit(ctx, func() {
+		ctx, cancel := context.WithTimeout(context.Background(), eventPublishTimeout)
+		defer cancel()
+
+		err := s.events.Publish(ctx, event)
+		if err != nil {
+			l.Errorw("failed to publish appointment event",
+				"event_id", event.ID,
+				"event_type", t,
+				"err", err,
+			)
+		}
+	})
+}
@@ -0,0 +1,110 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// fakePublisher keeps the events it's given, and whether each came with a
+// deadline.
+type fakePublisher struct {
+	events    []*AppointmentEvent
+	deadlines []bool
+}
+
+func (p *fakePublisher) Publish(ctx context.Context, event *AppointmentEvent) error {
+	_, ok := ctx.Deadline()
+	p.events = append(p.events, event)
+	p.deadlines = append(p.deadlines, ok)
+	return nil
+}
+
+func TestAppointmentEvents(t *testing.T) {
+	s := newTestServer()
+	publisher := &fakePublisher{}
+	s.events = publisher
+	store := newFakeStore()
+	q := store.addQueue(&QueueConfiguration{})
+
+	tomorrow := int(time.Now().Local().Add(24 * time.Hour).Weekday())
+	w := signupRequest(s, store, q, tomorrow, "student@example.com", 10, 1)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("got status %d, want %d: %s", w.Code, http.StatusCreated, w.Body.String())
+	}
+	var signedUp AppointmentSlot
+	err := json.NewDecoder(w.Body).Decode(&signedUp)
+	if err != nil {
+		t.Fatalf("failed to decode response: %v", err)
+	}
+
+	w = rescheduleRequest(t, s, store, q, store.appointment(signedUp.ID), 12)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("got status %d, want %d: %s", w.Code, http.StatusCreated, w.Body.String())
+	}
+	var moved AppointmentSlot
+	err = json.NewDecoder(w.Body).Decode(&moved)
+	if err != nil {
+		t.Fatalf("failed to decode response: %v", err)
+	}
+
+	r, _ := newTestRequest(http.MethodDelete, "/", nil, map[string]interface{}{
+		queueContextKey:       q,
+		appointmentContextKey: store.appointment(moved.ID),
+		emailContextKey:       "student@example.com",
+	})
+	w = httptest.NewRecorder()
+	s.RemoveAppointmentSignup(store).ServeHTTP(w, r)
+	if w.Code != http.StatusNoContent {
+		t.Fatalf("got status %d, want %d: %s", w.Code, http.StatusNoContent, w.Body.String())
+	}
+
+	want := []AppointmentEventType{AppointmentSignup, AppointmentReschedule, AppointmentCancel}
+	if len(publisher.events) != len(want) {
+		t.Fatalf("got %d events, want %d", len(publisher.events), len(want))
+	}
+	for i, e := range publisher.events {
+		if e.Type != want[i] {
+			t.Errorf("event %d: got type %s, want %s", i, e.Type, want[i])
+		}
+		if e.Queue != q.ID || e.Version != AppointmentEventVersion {
+			t.Errorf("event %d: got queue %s and version %d", i, e.Queue, e.Version)
+		}
+		if !publisher.deadlines[i] {
+			t.Errorf("event %d: published without a deadline", i)
+		}
+	}
+
+	reschedule := publisher.events[1]
+	if reschedule.Previous == nil || reschedule.Previous.Timeslot != 10 || reschedule.Appointment.Timeslot != 12 {
+		t.Errorf("got reschedule from %v to timeslot %d, want 10 to 12", reschedule.Previous, reschedule.Appointment.Timeslot)
+	}
+	if publisher.events[2].Appointment.ID != moved.ID {
+		t.Errorf("got cancel for %s, want %s", publisher.events[2].Appointment.ID, moved.ID)
+	}
+}
+
+func TestAppointmentEventsWaitForCommit(t *testing.T) {
+	s := newTestServer()
+	publisher := &fakePublisher{}
+	s.events = publisher
+	store := newFakeStore()
+	q := store.addQueue(&QueueConfiguration{})
+
+	var hooks []func()
+	ctx := context.WithValue(context.Background(), afterCommitContextKey, &hooks)
+	s.publishAppointmentEvent(ctx, s.logger, AppointmentSignup, q.ID, &AppointmentSlot{Queue: q.ID}, nil)
+	if len(publisher.events) != 0 {
+		t.Fatal("event published before commit")
+	}
+
+	for _, f := range hooks {
+		f()
+	}
+	if len(publisher.events) != 1 {
+		t.Errorf("got %d events after commit, want 1", len(publisher.events))
+	}
+}
@@ -0,0 +1,415 @@
+package api
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/dchest/uniuri"
+	"github.com/segmentio/ksuid"
+	"go.uber.org/zap"
+	"golang.org/x/oauth2"
+	"google.golang.org/api/calendar/v3"
+	"google.golang.org/api/googleapi"
+	"google.golang.org/api/option"
+)
+
+// CalendarEvent is what goes on someone's calendar for an appointment.
+type CalendarEvent struct {
+	Summary     string
+	Description string
+	Location    string
+	Start       time.Time
+	End         time.Time
+}
+
+// CalendarClient puts appointments on people's calendars on their behalf,
+// using the token they gave us when connecting their calendar.
+type CalendarClient interface {
+	CreateEvent(ctx context.Context, token *oauth2.Token, event *CalendarEvent) (id string, err error)
+	UpdateEvent(ctx context.Context, token *oauth2.Token, id string, event *CalendarEvent) error
+	DeleteEvent(ctx context.Context, token *oauth2.Token, id string) error
+}
+
+// GoogleCalendarClient puts events on the user's primary Google Calendar.
+// Config is only used to refresh tokens, so it just needs the client
+// credentials.
+type GoogleCalendarClient struct {
+	Config oauth2.Config
+}
+
+func (g GoogleCalendarClient) service(ctx context.Context, token *oauth2.Token) (*calendar.Service, error) {
+	return calendar.NewService(ctx, option.WithTokenSource(g.Config.TokenSource(ctx, token)))
+}
+
+func googleCalendarEvent(event *CalendarEvent) *calendar.Event {
+	return &calendar.Event{
+		Summary:     event.Summary,
+		Description: event.Description,
+		Location:    event.Location,
+		Start:       &calendar.EventDateTime{DateTime: event.Start.Format(time.RFC3339)},
+		End:         &calendar.EventDateTime{DateTime: event.End.Format(time.RFC3339)},
+	}
+}
+
+func (g GoogleCalendarClient) CreateEvent(ctx context.Context, token *oauth2.Token, event *CalendarEvent) (string, error) {
+	service, err := g.service(ctx, token)
+	if err != nil {
+		return "", err
+	}
+
+	created, err := service.Events.Insert("primary", googleCalendarEvent(event)).Context(ctx).Do()
+	if err != nil {
+		return "", err
+	}
+	return created.Id, nil
+}
+
+func (g GoogleCalendarClient) UpdateEvent(ctx context.Context, token *oauth2.Token, id string, event *CalendarEvent) error {
+	service, err := g.service(ctx, token)
+	if err != nil {
+		return err
+	}
+
+	_, err = service.Events.Patch("primary", id, googleCalendarEvent(event)).Context(ctx).Do()
+	return err
+}
+
+func (g GoogleCalendarClient) DeleteEvent(ctx context.Context, token *oauth2.Token, id string) error {
+	service, err := g.service(ctx, token)
+	if err != nil {
+		return err
+	}
+
+	err = service.Events.Delete("primary", id).Context(ctx).Do()
+	// If the user already deleted the event themselves, we're done.
+	var gerr *googleapi.Error
+	if errors.As(err, &gerr) && (gerr.Code == http.StatusNotFound || gerr.Code == http.StatusGone) {
+		return nil
+	}
+	return err
+}
+
+const googleCalendarStateKey = "calendar_state"
+
+// calendarOAuthConfig is the login config, but asking for access to the
+// user's calendar events. It's kept separate so that logging in doesn't
+// ask everyone for calendar access up front.
+func (s *Server) calendarOAuthConfig() *oauth2.Config {
+	config := s.oauthConfig
+	config.Scopes = []string{calendar.CalendarEventsScope}
+	config.RedirectURL = s.baseURL + "api/oauth2callback/google-calendar"
+	return &config
+}
+
+// ConnectGoogleCalendar sends the user to Google to let the queue put
+// appointments on their calendar.
+func (s *Server) ConnectGoogleCalendar() E {
+	return func(w http.ResponseWriter, r *http.Request) error {
+		email, ok := r.Context().Value(emailContextKey).(string)
+		if !ok {
+			return s.missingContextValue(r, emailContextKey)
+		}
+		session, err := s.sessions.Get(r, "session")
+		if err != nil {
+			s.logger.Errorw("failed to get session",
+				RequestIDContextKey, r.Context().Value(RequestIDContextKey),
+				"email", email,
+				"err", err,
+			)
+			return err
+		}
+
+		state := uniuri.NewLen(64)
+		session.Values[googleCalendarStateKey] = state
+		s.sessions.Save(r, w, session)
+
+		// Offline access gets us a refresh token, so appointments can be
+		// kept up to date long after the user has left the page.
+		url := s.calendarOAuthConfig().AuthCodeURL(state,
+			oauth2.AccessTypeOffline,
+			oauth2.SetAuthURLParam("login_hint", email),
+			oauth2.SetAuthURLParam("include_granted_scopes", "true"),
+		)
+
+		http.Redirect(w, r, url, http.StatusTemporaryRedirect)
+		return nil
+	}
+}
+
+type setGoogleCalendarToken interface {
+	SetGoogleCalendarToken(ctx context.Context, email string, token *oauth2.Token) error
+}
+
+func (s *Server) GoogleCalendarCallback(st setGoogleCalendarToken) E {
+	return func(w http.ResponseWriter, r *http.Request) error {
+		email, ok := r.Context().Value(emailContextKey).(string)
+		if !ok {
+			return s.missingContextValue(r, emailContextKey)
+		}
+		l := s.logger.With(
+			RequestIDContextKey, r.Context().Value(RequestIDContextKey),
+			"email", email,
+		)
+
+		session, err := s.sessions.Get(r, "session")
+		if err != nil {
+			l.Errorw("failed to get session", "err", err)
+			return err
+		}
+
+		savedState, ok := session.Values[googleCalendarStateKey].(string)
+		if !ok || r.FormValue("state") != savedState {
+			l.Warnw("calendar state doesn't match stored state", "received", r.FormValue("state"))
+			return StatusError{
+				http.StatusUnauthorized,
+				"Something went really wrong.",
+			}
+		}
+		delete(session.Values, googleCalendarStateKey)
+		s.sessions.Save(r, w, session)
+
+		if r.FormValue("error") != "" {
+			l.Infow("user declined calendar access", "error", r.FormValue("error"))
+			http.Redirect(w, r, s.baseURL, http.StatusTemporaryRedirect)
+			return nil
+		}
+
+		token, err := s.calendarOAuthConfig().Exchange(r.Context(), r.FormValue("code"))
+		if err != nil {
+			l.Errorw("failed to exchange calendar token", "err", err)
+			return err
+		}
+
+		err = st.SetGoogleCalendarToken(r.Context(), email, token)
+		if err != nil {
+			l.Errorw("failed to store calendar token", "err", err)
+			return err
+		}
+
+		l.Infow("connected Google Calendar")
+		http.Redirect(w, r, s.baseURL, http.StatusTemporaryRedirect)
+		return nil
+	}
+}
+
+type getGoogleCalendarToken interface {
+	GetGoogleCalendarToken(ctx context.Context, email string) (*oauth2.Token, error)
+}
+
+type getCalendarEvents interface {
+	GetCalendarEvents(ctx context.Context, appointment ksuid.KSUID) ([]*CalendarEventLink, error)
+}
+
+type setCalendarEvent interface {
+	SetCalendarEvent(ctx context.Context, appointment ksuid.KSUID, email, eventID string) error
+}
+
+type removeCalendarEvents interface {
+	RemoveCalendarEvents(ctx context.Context, appointment ksuid.KSUID) error
+}
+
+type pushAppointmentToGoogleCalendar interface {
+	getGoogleCalendarToken
+	getCalendarEvents
+	setCalendarEvent
+}
+
+// PushAppointmentToGoogleCalendar puts the current user's appointment on
+// their Google Calendar. Pushing the same appointment again brings the
+// existing event up to date rather than adding another.
+func (s *Server) PushAppointmentToGoogleCalendar(pa pushAppointmentToGoogleCalendar) E {
+	return func(w http.ResponseWriter, r *http.Request) error {
+		q, ok := r.Context().Value(queueContextKey).(*Queue)
+		if !ok {
+			return s.missingContextValue(r, queueContextKey)
+		}
+		a, ok := r.Context().Value(appointmentContextKey).(*AppointmentSlot)
+		if !ok {
+			return s.missingContextValue(r, appointmentContextKey)
+		}
+		email, ok := r.Context().Value(emailContextKey).(string)
+		if !ok {
+			return s.missingContextValue(r, emailContextKey)
+		}
+		l := s.logger.With(
+			RequestIDContextKey, r.Context().Value(RequestIDContextKey),
+			"appointment_id", a.ID,
+			"email", email,
+		)
+
+		if a.StudentEmail == nil {
+			l.Warnw("attempted to push deleted appointment to calendar")
+			return StatusError{
+				http.StatusNotFound,
+				"This appointment doesn't exist. Perhaps it was already deleted?",
+			}
+		}
+
+		if *a.StudentEmail != email {
+			l.Warnw("user attempted to push appointment with other email to calendar",
+				"expected_email", *a.StudentEmail,
+			)
+			return StatusError{
+				http.StatusForbidden,
+				"You can't add someone else's appointment to your calendar!",
+			}
+		}
+
+		token, err := pa.GetGoogleCalendarToken(r.Context(), email)
+		if errors.Is(err, sql.ErrNoRows) {
+			l.Warnw("user attempted to push appointment without connecting calendar")
+			return StatusError{
+				http.StatusConflict,
+				"You'll need to connect your Google Calendar first.",
+			}
+		} else if err != nil {
+			l.Errorw("failed to get calendar token", "err", err)
+			return err
+		}
+
+		events, err := pa.GetCalendarEvents(r.Context(), a.ID)
+		if err != nil {
+			l.Errorw("failed to get calendar events for appointment", "err", err)
+			return err
+		}
+
+		event := s.appointmentCalendarEvent(q, a)
+		for _, e := range events {
+			if e.Email != email {
+				continue
+			}
+
+			err = s.calendar.UpdateEvent(r.Context(), token, e.EventID, event)
+			if err != nil {
+				l.Errorw("failed to update calendar event", "event_id", e.EventID, "err", err)
+				return err
+			}
+
+			l.Infow("updated calendar event", "event_id", e.EventID)
+			return s.sendResponse(http.StatusOK, e, w, r)
+		}
+
+		id, err := s.calendar.CreateEvent(r.Context(), token, event)
+		if err != nil {
+			l.Errorw("failed to create calendar event", "err", err)
+			return err
+		}
+
+		err = pa.SetCalendarEvent(r.Context(), a.ID, email, id)
+		if err != nil {
+			l.Errorw("failed to store calendar event", "event_id", id, "err", err)
+			return err
+		}
+
+		l.Infow("created calendar event", "event_id", id)
+		return s.sendResponse(http.StatusCreated, &CalendarEventLink{
+			Appointment: a.ID,
+			Email:       email,
+			EventID:     id,
+		}, w, r)
+	}
+}
+
+func (s *Server) appointmentCalendarEvent(q *Queue, a *AppointmentSlot) *CalendarEvent {
+	event := &CalendarEvent{
+		Summary:     q.Name + " appointment",
+		Description: s.baseURL + "queues/" + q.ID.String(),
+		Start:       a.ScheduledTime,
+		End:         a.ScheduledTime.Add(time.Duration(a.Duration) * time.Minute),
+	}
+	if a.Location != nil {
+		event.Location = *a.Location
+	}
+	if a.MeetingLink != nil && *a.MeetingLink != "" {
+		event.Description = "Join: " + *a.MeetingLink + "\n" + event.Description
+	}
+	return event
+}
+
+type syncCalendarEvents interface {
+	getGoogleCalendarToken
+	getCalendarEvents
+	setCalendarEvent
+	removeCalendarEvents
+}
+
+// How long updating one calendar event can take before it's given up on.
+const calendarSyncTimeout = 10 * time.Second
+
+// syncCalendarEvents brings the calendar events for an appointment in line
+// with what happened to it: moved along with a reschedule to moved, or
+// deleted if moved is nil. events has to be looked up before the old
+// appointment is removed, since removing it takes its events with it.
+// Our records of the events change along with the appointment, so errors
+// there fail the request. The calendars themselves are only updated once
+// the request commits; like publishing events, failing to reach them
+// just gets logged, since the appointment has already changed.
+func (s *Server) syncCalendarEvents(ctx context.Context, l *zap.SugaredLogger, cs syncCalendarEvents, q *Queue, events []*CalendarEventLink, previous, moved *AppointmentSlot) error {
+	// Shifting a day moves appointments in place, so there's nothing to
+	// update on our end.
+	if moved == nil || moved.ID != previous.ID {
+		if moved != nil {
+			for _, e := range events {
+				err := cs.SetCalendarEvent(ctx, moved.ID, e.Email, e.EventID)
+				if err != nil {
+					l.Errorw("failed to move stored calendar event", "event_id", e.EventID, "err", err)
+					return err
+				}
+			}
+		}
+
+		err := cs.RemoveCalendarEvents(ctx, previous.ID)
+		if err != nil {
+			l.Errorw("failed to remove stored calendar events for appointment", "err", err)
+			return err
+		}
+	}
+
+	// Tokens have to be looked up now, while the transaction's still
+	// around.
+	tokens := make([]*oauth2.Token, len(events))
+	for i, e := range events {
+		token, err := cs.GetGoogleCalendarToken(ctx, e.Email)
+		if errors.Is(err, sql.ErrNoRows) {
+			l.Warnw("no calendar token for calendar event", "calendar_email", e.Email, "event_id", e.EventID)
+			continue
+		} else if err != nil {
+			l.Errorw("failed to get calendar token", "calendar_email", e.Email, "err", err)
+			return err
+		}
+		tokens[i] = token
+	}
+
+	var event *CalendarEvent
+	if moved != nil {
+		event = s.appointmentCalendarEvent(q, moved)
+	}
+
+	afterCommit(ctx, func() {
+		for i, e := range events {
+			if tokens[i] == nil {
+				continue
+			}
+
+			ctx, cancel := context.WithTimeout(context.Background(), calendarSyncTimeout)
+			var err error
+			if event != nil {
+				err = s.calendar.UpdateEvent(ctx, tokens[i], e.EventID, event)
+			} else {
+				err = s.calendar.DeleteEvent(ctx, tokens[i], e.EventID)
+			}
+			cancel()
+			if err != nil {
+				l.Errorw("failed to sync calendar event",
+					"calendar_email", e.Email,
+					"event_id", e.EventID,
+					"err", err,
+				)
+			}
+		}
+	})
+	return nil
+}
@@ -0,0 +1,199 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/segmentio/ksuid"
+	"golang.org/x/oauth2"
+)
+
+// fakeCalendar records what would have been done to people's calendars.
+type fakeCalendar struct {
+	mu      sync.Mutex
+	created []*CalendarEvent
+	updated map[string]*CalendarEvent
+	deleted []string
+}
+
+func newFakeCalendar() *fakeCalendar {
+	return &fakeCalendar{updated: make(map[string]*CalendarEvent)}
+}
+
+func (c *fakeCalendar) CreateEvent(ctx context.Context, token *oauth2.Token, event *CalendarEvent) (string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.created = append(c.created, event)
+	return "created-event", nil
+}
+
+func (c *fakeCalendar) UpdateEvent(ctx context.Context, token *oauth2.Token, id string, event *CalendarEvent) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.updated[id] = event
+	return nil
+}
+
+func (c *fakeCalendar) DeleteEvent(ctx context.Context, token *oauth2.Token, id string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.deleted = append(c.deleted, id)
+	return nil
+}
+
+// appointmentOnCalendar books an appointment tomorrow for a student who
+// has already put it on their calendar.
+func appointmentOnCalendar(store *fakeStore, q *Queue) *AppointmentSlot {
+	tomorrow := int(time.Now().Local().Add(24 * time.Hour).Weekday())
+	a := store.book(q, tomorrow, 10, "student@example.com")
+	store.calendarTokens["student@example.com"] = &oauth2.Token{AccessToken: "token"}
+	store.calendarEvents[a.ID] = []*CalendarEventLink{
+		{Appointment: a.ID, Email: "student@example.com", EventID: "event-1"},
+	}
+	return a
+}
+
+func TestPushAppointmentCreatesCalendarEvent(t *testing.T) {
+	s := newTestServer()
+	calendar := newFakeCalendar()
+	s.calendar = calendar
+	store := newFakeStore()
+	q := store.addQueue(&QueueConfiguration{})
+	tomorrow := int(time.Now().Local().Add(24 * time.Hour).Weekday())
+	a := store.book(q, tomorrow, 10, "student@example.com")
+	store.calendarTokens["student@example.com"] = &oauth2.Token{AccessToken: "token"}
+
+	r, _ := newTestRequest(http.MethodPost, "/", nil, map[string]interface{}{
+		queueContextKey:       q,
+		appointmentContextKey: a,
+		emailContextKey:       "student@example.com",
+	})
+	w := httptest.NewRecorder()
+	s.PushAppointmentToGoogleCalendar(store).ServeHTTP(w, r)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("got status %d, want %d: %s", w.Code, http.StatusCreated, w.Body.String())
+	}
+
+	if len(calendar.created) != 1 {
+		t.Fatalf("got %d created events, want 1", len(calendar.created))
+	}
+	if !calendar.created[0].Start.Equal(a.ScheduledTime) {
+		t.Errorf("got event start %v, want %v", calendar.created[0].Start, a.ScheduledTime)
+	}
+	links := store.calendarEvents[a.ID]
+	if len(links) != 1 || links[0].EventID != "created-event" {
+		t.Errorf("got stored events %v, want created-event", links)
+	}
+}
+
+func TestCancelDeletesCalendarEvent(t *testing.T) {
+	s := newTestServer()
+	calendar := newFakeCalendar()
+	s.calendar = calendar
+	store := newFakeStore()
+	q := store.addQueue(&QueueConfiguration{})
+	a := appointmentOnCalendar(store, q)
+
+	r, _ := newTestRequest(http.MethodDelete, "/", nil, map[string]interface{}{
+		queueContextKey:       q,
+		appointmentContextKey: a,
+		emailContextKey:       "student@example.com",
+	})
+	w := httptest.NewRecorder()
+	s.RemoveAppointmentSignup(store).ServeHTTP(w, r)
+	if w.Code >= 300 {
+		t.Fatalf("got status %d: %s", w.Code, w.Body.String())
+	}
+
+	if len(calendar.deleted) != 1 || calendar.deleted[0] != "event-1" {
+		t.Errorf("got deleted events %v, want event-1", calendar.deleted)
+	}
+	if len(store.calendarEvents[a.ID]) != 0 {
+		t.Error("stored calendar event left after cancel")
+	}
+}
+
+func TestRescheduleMovesCalendarEvent(t *testing.T) {
+	s := newTestServer()
+	calendar := newFakeCalendar()
+	s.calendar = calendar
+	store := newFakeStore()
+	q := store.addQueue(&QueueConfiguration{})
+	a := appointmentOnCalendar(store, q)
+
+	w := rescheduleRequest(t, s, store, q, a, 12)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("got status %d, want %d: %s", w.Code, http.StatusCreated, w.Body.String())
+	}
+
+	event, ok := calendar.updated["event-1"]
+	if !ok {
+		t.Fatal("calendar event not updated")
+	}
+	moved := store.appointments[len(store.appointments)-1]
+	if !event.Start.Equal(moved.ScheduledTime) {
+		t.Errorf("got event start %v, want %v", event.Start, moved.ScheduledTime)
+	}
+	if links := store.calendarEvents[moved.ID]; len(links) != 1 || links[0].EventID != "event-1" {
+		t.Errorf("got stored events %v on moved appointment, want event-1", links)
+	}
+}
+
+func TestCalendarSyncWaitsForCommit(t *testing.T) {
+	s := newTestServer()
+	calendar := newFakeCalendar()
+	s.calendar = calendar
+	store := newFakeStore()
+	q := store.addQueue(&QueueConfiguration{})
+	a := appointmentOnCalendar(store, q)
+
+	var hooks []func()
+	ctx := context.WithValue(context.Background(), afterCommitContextKey, &hooks)
+	err := s.syncCalendarEvents(ctx, s.logger, store, q, store.calendarEvents[a.ID], a, nil)
+	if err != nil {
+		t.Fatalf("failed to sync calendar events: %v", err)
+	}
+	if len(calendar.deleted) != 0 {
+		t.Fatal("calendar changed before commit")
+	}
+
+	for _, f := range hooks {
+		f()
+	}
+	if len(calendar.deleted) != 1 {
+		t.Errorf("got %d deleted events after commit, want 1", len(calendar.deleted))
+	}
+}
+
+// brokenCalendarStore can't keep track of calendar events.
+type brokenCalendarStore struct {
+	*fakeStore
+}
+
+var errBrokenStore = errors.New("database is down")
+
+func (brokenCalendarStore) RemoveCalendarEvents(ctx context.Context, appointment ksuid.KSUID) error {
+	return errBrokenStore
+}
+
+func TestCalendarSyncReturnsStoreErrors(t *testing.T) {
+	s := newTestServer()
+	calendar := newFakeCalendar()
+	s.calendar = calendar
+	store := newFakeStore()
+	q := store.addQueue(&QueueConfiguration{})
+	a := appointmentOnCalendar(store, q)
+
+	err := s.syncCalendarEvents(context.Background(), s.logger, brokenCalendarStore{store}, q, store.calendarEvents[a.ID], a, nil)
+	if !errors.Is(err, errBrokenStore) {
+		t.Errorf("got error %v, want %v", err, errBrokenStore)
+	}
+	if len(calendar.deleted) != 0 {
+		t.Error("calendar changed after failing to update stored events")
+	}
+}
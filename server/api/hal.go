@@ -0,0 +1,144 @@
+package api
+
+import (
+	"encoding/json"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const halMediaType = "application/hal+json"
+
+type halLink struct {
+	Href   string `json:"href"`
+	Method string `json:"method,omitempty"`
+}
+
+// halAppointment is an appointment with its _links attached. It can't just
+// embed the appointment, since then AppointmentSlot's MarshalJSON would be
+// promoted and the links would be dropped.
+type halAppointment struct {
+	appointment *AppointmentSlot
+	links       map[string]halLink
+}
+
+func (h halAppointment) MarshalJSON() ([]byte, error) {
+	b, err := json.Marshal(h.appointment)
+	if err != nil {
+		return nil, err
+	}
+
+	var fields map[string]json.RawMessage
+	err = json.Unmarshal(b, &fields)
+	if err != nil {
+		return nil, err
+	}
+
+	fields["_links"], err = json.Marshal(h.links)
+	if err != nil {
+		return nil, err
+	}
+
+	return json.Marshal(fields)
+}
+
+type halAppointmentCollection struct {
+	Links    map[string]halLink `json:"_links"`
+	Embedded struct {
+		Appointments []halAppointment `json:"appointments"`
+	} `json:"_embedded"`
+}
+
+// accepts reports whether the client listed mediaType in its Accept
+// header. Clients that don't ask for anything in particular get plain
+// JSON.
+func accepts(r *http.Request, mediaType string) bool {
+	for _, accept := range strings.Split(r.Header.Get("Accept"), ",") {
+		m, _, err := mime.ParseMediaType(strings.TrimSpace(accept))
+		if err == nil && m == mediaType {
+			return true
+		}
+	}
+	return false
+}
+
+func appointmentLinks(a *AppointmentSlot) map[string]halLink {
+	queue := "/api/queues/" + a.Queue.String()
+	self := queue + "/appointments/" + a.ID.String()
+	day := int(a.ScheduledTime.In(time.Local).Weekday())
+
+	links := map[string]halLink{
+		"self":     {Href: self},
+		"queue":    {Href: queue},
+		"schedule": {Href: queue + "/appointments/schedule/" + strconv.Itoa(day)},
+	}
+
+	// Only appointments someone has signed up for can be cancelled.
+	if a.StudentEmail != nil {
+		links["cancel"] = halLink{Href: self, Method: http.MethodDelete}
+	}
+
+	return links
+}
+
+// addRelativeTimes fills in how far off each appointment is.
+func addRelativeTimes(data interface{}, now time.Time) {
+	var appointments []*AppointmentSlot
+	switch d := data.(type) {
+	case *AppointmentSlot:
+		appointments = []*AppointmentSlot{d}
+	case []*AppointmentSlot:
+		appointments = d
+	}
+
+	for _, a := range appointments {
+		startsIn := int64(a.ScheduledTime.Sub(now) / time.Second)
+		a.Relative = RelativeTime(a.ScheduledTime, now)
+		a.StartsInSeconds = &startsIn
+	}
+}
+
+// sendAppointmentResponse sends one appointment or a list of them, as
+// protobuf or with hypermedia links if the client negotiated either, and
+// with relative times if the client asked for those.
+func (s *Server) sendAppointmentResponse(code int, data interface{}, w http.ResponseWriter, r *http.Request) error {
+	if relative, _ := strconv.ParseBool(r.URL.Query().Get("relative")); relative {
+		addRelativeTimes(data, time.Now())
+	}
+
+	if accepts(r, protobufMediaType) {
+		body, ok, err := marshalAppointmentsProto(data)
+		if err != nil {
+			s.logger.Errorw("failed to encode appointments as protobuf",
+				RequestIDContextKey, r.Context().Value(RequestIDContextKey),
+				"err", err,
+			)
+			return err
+		}
+		if ok {
+			return s.sendProtobufResponse(code, body, w, r)
+		}
+	}
+
+	if !accepts(r, halMediaType) {
+		return s.sendResponse(code, data, w, r)
+	}
+
+	switch d := data.(type) {
+	case *AppointmentSlot:
+		return s.sendResponseWithType(code, halMediaType, halAppointment{d, appointmentLinks(d)}, w, r)
+	case []*AppointmentSlot:
+		collection := halAppointmentCollection{
+			Links: map[string]halLink{"self": {Href: "/api" + r.URL.Path}},
+		}
+		collection.Embedded.Appointments = make([]halAppointment, len(d))
+		for i, a := range d {
+			collection.Embedded.Appointments[i] = halAppointment{a, appointmentLinks(a)}
+		}
+		return s.sendResponseWithType(code, halMediaType, collection, w, r)
+	}
+
+	return s.sendResponse(code, data, w, r)
+}
@@ -0,0 +1,153 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// getAppointmentRequest gets a as its student, asking for accept.
+func getAppointmentRequest(s *Server, store *fakeStore, q *Queue, a *AppointmentSlot, accept string) *httptest.ResponseRecorder {
+	r, _ := newTestRequest(http.MethodGet, "/", nil, map[string]interface{}{
+		queueContextKey:       q,
+		appointmentContextKey: a,
+		emailContextKey:       *a.StudentEmail,
+		courseAdminContextKey: false,
+	})
+	if accept != "" {
+		r.Header.Set("Accept", accept)
+	}
+	w := httptest.NewRecorder()
+	s.GetAppointmentByID(store).ServeHTTP(w, r)
+	return w
+}
+
+func TestHALLinksOnlyWhenNegotiated(t *testing.T) {
+	s := newTestServer()
+	store := newFakeStore()
+	q := store.addQueue(&QueueConfiguration{})
+	tomorrow := int(time.Now().Local().Add(24 * time.Hour).Weekday())
+	a := store.book(q, tomorrow, 10, "student@example.com")
+
+	for _, accept := range []string{"", "application/json", "*/*"} {
+		w := getAppointmentRequest(s, store, q, a, accept)
+		if w.Code != http.StatusOK {
+			t.Fatalf("got status %d with Accept %q, want %d: %s", w.Code, accept, http.StatusOK, w.Body.String())
+		}
+		if ct := w.Header().Get("Content-Type"); ct == halMediaType {
+			t.Errorf("got Content-Type %s with Accept %q", ct, accept)
+		}
+		var fields map[string]json.RawMessage
+		err := json.NewDecoder(w.Body).Decode(&fields)
+		if err != nil {
+			t.Fatalf("failed to decode response: %v", err)
+		}
+		if _, ok := fields["_links"]; ok {
+			t.Errorf("got _links with Accept %q", accept)
+		}
+	}
+
+	w := getAppointmentRequest(s, store, q, a, "application/json;q=0.5, application/hal+json")
+	if w.Code != http.StatusOK {
+		t.Fatalf("got status %d, want %d: %s", w.Code, http.StatusOK, w.Body.String())
+	}
+	if ct := w.Header().Get("Content-Type"); ct != halMediaType {
+		t.Errorf("got Content-Type %s, want %s", ct, halMediaType)
+	}
+	var response struct {
+		ID    string             `json:"id"`
+		Links map[string]halLink `json:"_links"`
+	}
+	err := json.NewDecoder(w.Body).Decode(&response)
+	if err != nil {
+		t.Fatalf("failed to decode response: %v", err)
+	}
+	if response.ID != a.ID.String() {
+		t.Errorf("got appointment %s, want %s", response.ID, a.ID)
+	}
+
+	self := "/api/queues/" + q.ID.String() + "/appointments/" + a.ID.String()
+	want := map[string]halLink{
+		"self":     {Href: self},
+		"queue":    {Href: "/api/queues/" + q.ID.String()},
+		"schedule": {Href: "/api/queues/" + q.ID.String() + "/appointments/schedule/" + strconv.Itoa(tomorrow)},
+		"cancel":   {Href: self, Method: http.MethodDelete},
+	}
+	if !reflect.DeepEqual(response.Links, want) {
+		t.Errorf("got links %v, want %v", response.Links, want)
+	}
+}
+
+func TestHALNoCancelLinkForClaims(t *testing.T) {
+	store := newFakeStore()
+	q := store.addQueue(&QueueConfiguration{})
+	tomorrow := int(time.Now().Local().Add(24 * time.Hour).Weekday())
+	a := store.claim(q, tomorrow, 10, "staff@example.com")
+
+	links := appointmentLinks(a)
+	if _, ok := links["cancel"]; ok {
+		t.Error("got a cancel link for a claim with no student")
+	}
+	if _, ok := links["self"]; !ok {
+		t.Error("got no self link")
+	}
+}
+
+func TestRelativeTimes(t *testing.T) {
+	s := newTestServer()
+	store := newFakeStore()
+	q := store.addQueue(&QueueConfiguration{})
+	tomorrow := int(time.Now().Local().Add(24 * time.Hour).Weekday())
+	future := store.book(q, tomorrow, 10, "student@example.com")
+	past := store.book(q, tomorrow, 11, "student@example.com")
+	past.ScheduledTime = time.Now().Add(-150 * time.Minute)
+
+	get := func(a *AppointmentSlot, target string) *AppointmentSlot {
+		t.Helper()
+		r, _ := newTestRequest(http.MethodGet, target, nil, map[string]interface{}{
+			queueContextKey:       q,
+			appointmentContextKey: a,
+			emailContextKey:       *a.StudentEmail,
+			courseAdminContextKey: false,
+		})
+		w := httptest.NewRecorder()
+		s.GetAppointmentByID(store).ServeHTTP(w, r)
+		if w.Code != http.StatusOK {
+			t.Fatalf("got status %d, want %d: %s", w.Code, http.StatusOK, w.Body.String())
+		}
+
+		var got AppointmentSlot
+		err := json.NewDecoder(w.Body).Decode(&got)
+		if err != nil {
+			t.Fatalf("failed to decode response: %v", err)
+		}
+		return &got
+	}
+
+	// They go stale, so they're only there when asked for.
+	got := get(future, "/")
+	if got.Relative != "" || got.StartsInSeconds != nil {
+		t.Errorf("got relative %q and starts in %v without asking", got.Relative, got.StartsInSeconds)
+	}
+
+	for _, a := range []*AppointmentSlot{future, past} {
+		got := get(a, "/?relative=true")
+		if want := RelativeTime(a.ScheduledTime, time.Now()); got.Relative != want {
+			t.Errorf("got relative %q, want %q", got.Relative, want)
+		}
+		if got.StartsInSeconds == nil {
+			t.Fatal("got no starts_in_seconds")
+		}
+		want := int64(time.Until(a.ScheduledTime) / time.Second)
+		if diff := *got.StartsInSeconds - want; diff < -5 || diff > 5 {
+			t.Errorf("got starts in %d seconds, want about %d", *got.StartsInSeconds, want)
+		}
+	}
+	if got := get(past, "/?relative=true"); got.Relative != "2 hours ago" {
+		t.Errorf("got relative %q for past appointment, want 2 hours ago", got.Relative)
+	}
+}
@@ -0,0 +1,192 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/segmentio/ksuid"
+)
+
+const (
+	maxMapRegions          = 50
+	maxMapRegionNameLength = 50
+	maxMapRegionPoints     = 100
+)
+
+type getMapRegions interface {
+	GetMapRegions(ctx context.Context, queue ksuid.KSUID) ([]*MapRegion, error)
+}
+
+func (s *Server) GetMapRegions(gm getMapRegions) E {
+	return func(w http.ResponseWriter, r *http.Request) error {
+		q, ok := r.Context().Value(queueContextKey).(*Queue)
+		if !ok {
+			return s.missingContextValue(r, queueContextKey)
+		}
+
+		regions, err := gm.GetMapRegions(r.Context(), q.ID)
+		if err != nil {
+			s.logger.Errorw("failed to get map regions",
+				RequestIDContextKey, r.Context().Value(RequestIDContextKey),
+				"queue_id", q.ID,
+				"err", err,
+			)
+			return err
+		}
+
+		return s.sendResponse(http.StatusOK, regions, w, r)
+	}
+}
+
+type updateMapRegions interface {
+	SetMapRegions(ctx context.Context, queue ksuid.KSUID, regions []*MapRegion) error
+}
+
+// UpdateMapRegions replaces the areas of a queue's room map that students
+// can drop their pin in. With no regions, pins can go anywhere. Pins on
+// appointments that already exist aren't checked again.
+func (s *Server) UpdateMapRegions(um updateMapRegions) E {
+	return func(w http.ResponseWriter, r *http.Request) error {
+		q, ok := r.Context().Value(queueContextKey).(*Queue)
+		if !ok {
+			return s.missingContextValue(r, queueContextKey)
+		}
+		l := s.logger.With(
+			RequestIDContextKey, r.Context().Value(RequestIDContextKey),
+			"queue_id", q.ID,
+			"email", r.Context().Value(emailContextKey),
+		)
+
+		var regions []*MapRegion
+		err := s.decodeLimitedBody(w, r, &regions)
+		if errors.Is(err, errBodyTooLarge) {
+			l.Warnw("map regions request body too large")
+			return err
+		}
+		if err != nil {
+			l.Warnw("failed to decode map regions from body", "err", err)
+			return StatusError{
+				http.StatusBadRequest,
+				"We couldn't read the map regions in the request body.",
+			}
+		}
+
+		if len(regions) > maxMapRegions {
+			l.Warnw("got too many map regions", "num_regions", len(regions))
+			return StatusError{
+				http.StatusBadRequest,
+				fmt.Sprintf("Queues can have at most %d map regions.", maxMapRegions),
+			}
+		}
+
+		seen := make(map[string]bool)
+		for _, region := range regions {
+			if region == nil {
+				l.Warnw("got null map region")
+				return StatusError{
+					http.StatusBadRequest,
+					"Every map region needs a name and an outline.",
+				}
+			}
+
+			region.Name = strings.TrimSpace(region.Name)
+			if region.Name == "" || utf8.RuneCountInString(region.Name) > maxMapRegionNameLength {
+				l.Warnw("got map region with invalid name", "name", region.Name)
+				return StatusError{
+					http.StatusBadRequest,
+					fmt.Sprintf("Map region names need to be between 1 and %d characters long.", maxMapRegionNameLength),
+				}
+			}
+
+			if seen[region.Name] {
+				l.Warnw("got duplicate map region", "name", region.Name)
+				return StatusError{
+					http.StatusBadRequest,
+					fmt.Sprintf(`There's more than one map region called "%s".`, region.Name),
+				}
+			}
+			seen[region.Name] = true
+
+			if len(region.Points) < 3 || len(region.Points) > maxMapRegionPoints {
+				l.Warnw("got map region with invalid number of points",
+					"name", region.Name,
+					"num_points", len(region.Points),
+				)
+				return StatusError{
+					http.StatusBadRequest,
+					fmt.Sprintf(`The outline of "%s" needs between 3 and %d points.`, region.Name, maxMapRegionPoints),
+				}
+			}
+
+			for _, p := range region.Points {
+				if p == nil {
+					l.Warnw("got null map region point", "name", region.Name)
+					return StatusError{
+						http.StatusBadRequest,
+						fmt.Sprintf(`Every point in the outline of "%s" needs an x and a y.`, region.Name),
+					}
+				}
+			}
+		}
+
+		err = um.SetMapRegions(r.Context(), q.ID, regions)
+		if err != nil {
+			l.Errorw("failed to set map regions", "err", err)
+			return err
+		}
+
+		l.Infow("updated map regions", "num_regions", len(regions))
+
+		s.ps.Pub(WS("REFRESH", nil), QueueTopicGeneric(q.ID))
+
+		return s.sendResponse(http.StatusNoContent, nil, w, r)
+	}
+}
+
+// Contains reports whether (x, y) is inside the region, by counting how
+// many of its edges a ray from the point crosses.
+func (m *MapRegion) Contains(x, y float32) bool {
+	inside := false
+	for i, j := 0, len(m.Points)-1; i < len(m.Points); j, i = i, i+1 {
+		a, b := m.Points[i], m.Points[j]
+		if (a.Y > y) != (b.Y > y) && x < (b.X-a.X)*(y-a.Y)/(b.Y-a.Y)+a.X {
+			inside = !inside
+		}
+	}
+	return inside
+}
+
+// errPinOutsideMap is returned when an appointment's map pin isn't in any
+// of the queue's map regions.
+var errPinOutsideMap = StatusError{
+	http.StatusBadRequest,
+	"Your map pin needs to be somewhere in the room!",
+}
+
+// validateMapPin checks that an appointment's map pin, if it has one,
+// falls in one of the queue's map regions. Queues without any regions
+// take pins anywhere.
+func validateMapPin(regions []*MapRegion, a *AppointmentSlot) error {
+	if len(regions) == 0 || (a.MapX == nil && a.MapY == nil) {
+		return nil
+	}
+
+	var x, y float32
+	if a.MapX != nil {
+		x = *a.MapX
+	}
+	if a.MapY != nil {
+		y = *a.MapY
+	}
+
+	for _, region := range regions {
+		if region.Contains(x, y) {
+			return nil
+		}
+	}
+	return errPinOutsideMap
+}
@@ -0,0 +1,140 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// squareRegion is a region from (0, 0) to (10, 10).
+var squareRegion = &MapRegion{Name: "Square", Points: []*MapPoint{{0, 0}, {10, 0}, {10, 10}, {0, 10}}}
+
+// ellRegion is an L shape, missing the square from (5, 5) to (10, 10).
+var ellRegion = &MapRegion{Name: "Ell", Points: []*MapPoint{{0, 0}, {10, 0}, {10, 5}, {5, 5}, {5, 10}, {0, 10}}}
+
+func TestMapRegionContains(t *testing.T) {
+	tests := []struct {
+		region *MapRegion
+		x, y   float32
+		want   bool
+	}{
+		{squareRegion, 5, 5, true},
+		{squareRegion, 0.5, 9.5, true},
+		{squareRegion, 11, 5, false},
+		{squareRegion, 5, -1, false},
+		{ellRegion, 2, 8, true},
+		{ellRegion, 8, 2, true},
+		// The notch in the L.
+		{ellRegion, 8, 8, false},
+	}
+	for _, test := range tests {
+		if got := test.region.Contains(test.x, test.y); got != test.want {
+			t.Errorf("got %t for (%g, %g) in %s, want %t", got, test.x, test.y, test.region.Name, test.want)
+		}
+	}
+}
+
+func TestMapPinValidation(t *testing.T) {
+	s := newTestServer()
+	store := newFakeStore()
+	q := store.addQueue(&QueueConfiguration{})
+	store.mapRegions[q.ID] = []*MapRegion{ellRegion}
+	tomorrow := int(time.Now().Local().Add(24 * time.Hour).Weekday())
+
+	pin := func(x, y float32) map[string]interface{} {
+		return map[string]interface{}{
+			"location":    "Here",
+			"description": "Help",
+			"map_x":       x,
+			"map_y":       y,
+		}
+	}
+
+	w := signupBodyRequest(s, store, q, tomorrow, "outside@example.com", 10, pin(8, 8))
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("got status %d with pin outside the room, want %d", w.Code, http.StatusBadRequest)
+	}
+	w = signupBodyRequest(s, store, q, tomorrow, "inside@example.com", 11, pin(2, 8))
+	if w.Code != http.StatusCreated {
+		t.Fatalf("got status %d with pin inside the room, want %d: %s", w.Code, http.StatusCreated, w.Body.String())
+	}
+	if len(store.appointments) != 1 {
+		t.Fatalf("got %d stored appointments, want 1", len(store.appointments))
+	}
+
+	// Moving the pin out of the room is refused too, but moving it
+	// somewhere else inside is fine.
+	a := *store.appointments[0]
+	update := pin(8, 8)
+	update["timeslot"] = a.Timeslot
+	w = updateBodyRequest(s, store, q, &a, update)
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("got status %d moving pin outside the room, want %d", w.Code, http.StatusBadRequest)
+	}
+	if *store.appointment(a.ID).MapX != 2 {
+		t.Error("pin moved outside the room")
+	}
+	update = pin(8, 2)
+	update["timeslot"] = a.Timeslot
+	w = updateBodyRequest(s, store, q, &a, update)
+	if w.Code != http.StatusNoContent {
+		t.Errorf("got status %d moving pin inside the room, want %d", w.Code, http.StatusNoContent)
+	}
+	if *store.appointment(a.ID).MapX != 8 {
+		t.Error("pin didn't move inside the room")
+	}
+}
+
+func mapRegionsRequest(s *Server, store *fakeStore, q *Queue, regions interface{}) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	s.UpdateMapRegions(store).ServeHTTP(w, adminRequest(http.MethodPut, regions, q, "admin@example.com"))
+	return w
+}
+
+func TestUpdateMapRegions(t *testing.T) {
+	s := newTestServer()
+	store := newFakeStore()
+	q := store.addQueue(&QueueConfiguration{})
+
+	w := mapRegionsRequest(s, store, q, []*MapRegion{squareRegion, ellRegion})
+	if w.Code != http.StatusNoContent {
+		t.Fatalf("got status %d, want %d", w.Code, http.StatusNoContent)
+	}
+	if len(store.mapRegions[q.ID]) != 2 {
+		t.Errorf("got %d stored regions, want 2", len(store.mapRegions[q.ID]))
+	}
+
+	tests := map[string]interface{}{
+		"too few points": []*MapRegion{{Name: "Line", Points: []*MapPoint{{0, 0}, {1, 1}}}},
+		"no name":        []*MapRegion{{Name: "  ", Points: squareRegion.Points}},
+		"long name":      []*MapRegion{{Name: strings.Repeat("a", maxMapRegionNameLength+1), Points: squareRegion.Points}},
+		"duplicate name": []*MapRegion{squareRegion, {Name: " Square ", Points: ellRegion.Points}},
+		"null region":    []interface{}{nil},
+		"null point":     []interface{}{map[string]interface{}{"name": "Holey", "points": []interface{}{nil, map[string]int{"x": 1}, map[string]int{"y": 1}}}},
+	}
+	for name, regions := range tests {
+		w := mapRegionsRequest(s, store, q, regions)
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("%s: got status %d, want %d", name, w.Code, http.StatusBadRequest)
+		}
+	}
+	if len(store.mapRegions[q.ID]) != 2 {
+		t.Errorf("got %d stored regions after invalid updates, want 2", len(store.mapRegions[q.ID]))
+	}
+
+	// The regions come back as they were set.
+	r, _ := newTestRequest(http.MethodGet, "/", nil, map[string]interface{}{queueContextKey: q})
+	w = httptest.NewRecorder()
+	s.GetMapRegions(store).ServeHTTP(w, r)
+	var regions []*MapRegion
+	err := json.NewDecoder(w.Body).Decode(&regions)
+	if err != nil {
+		t.Fatalf("failed to decode response: %v", err)
+	}
+	if len(regions) != 2 || regions[0].Name != "Square" || len(regions[1].Points) != len(ellRegion.Points) {
+		t.Errorf("got regions %+v, want Square and Ell", regions)
+	}
+}
@@ -0,0 +1,44 @@
+package api
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/dchest/uniuri"
+)
+
+// MeetingProvider sets up video meetings for appointments on remote
+// appointments queues.
+type MeetingProvider interface {
+	CreateMeeting(ctx context.Context, appointment *AppointmentSlot) (link string, err error)
+	DeleteMeeting(ctx context.Context, link string) error
+}
+
+// meetingTimeout is how long setting up or tearing down a meeting gets,
+// since it happens after the request that asked for it is done.
+const meetingTimeout = 10 * time.Second
+
+// NoopMeetingProvider doesn't create any meetings; appointments on
+// remote queues just won't get a link. It's what the server uses when
+// nothing else is configured.
+type NoopMeetingProvider struct{}
+
+func (NoopMeetingProvider) CreateMeeting(context.Context, *AppointmentSlot) (string, error) {
+	return "", nil
+}
+
+func (NoopMeetingProvider) DeleteMeeting(context.Context, string) error { return nil }
+
+// JitsiMeetingProvider hands out rooms on a Jitsi Meet instance. Jitsi
+// creates rooms the first time someone joins them, so there's nothing to
+// set up or tear down beyond picking a name nobody will guess.
+type JitsiMeetingProvider struct {
+	BaseURL string
+}
+
+func (j JitsiMeetingProvider) CreateMeeting(ctx context.Context, appointment *AppointmentSlot) (string, error) {
+	return strings.TrimSuffix(j.BaseURL, "/") + "/office-hours-" + uniuri.NewLen(24), nil
+}
+
+func (JitsiMeetingProvider) DeleteMeeting(context.Context, string) error { return nil }
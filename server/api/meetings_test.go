@@ -0,0 +1,348 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/segmentio/ksuid"
+)
+
+// fakeMeetings hands out numbered meeting links, or fails to while down
+// is set.
+type fakeMeetings struct {
+	created []string
+	deleted []string
+	down    bool
+}
+
+func (m *fakeMeetings) CreateMeeting(ctx context.Context, appointment *AppointmentSlot) (string, error) {
+	if m.down {
+		return "", errors.New("meeting provider is down")
+	}
+	link := "https://meet.example.com/" + strconv.Itoa(len(m.created)+1)
+	m.created = append(m.created, link)
+	return link, nil
+}
+
+func (m *fakeMeetings) DeleteMeeting(ctx context.Context, link string) error {
+	m.deleted = append(m.deleted, link)
+	return nil
+}
+
+var remoteQueue = &QueueConfiguration{AppointmentSettings: AppointmentSettings{AppointmentLocationType: AppointmentLocationRemote}}
+
+func TestMeetingLinkOnSignupAndCancel(t *testing.T) {
+	s := newTestServer()
+	meetings := &fakeMeetings{}
+	s.meetings = meetings
+	store := newFakeStore()
+	q := store.addQueue(remoteQueue)
+
+	tomorrow := int(time.Now().Local().Add(24 * time.Hour).Weekday())
+	w := signupRequest(s, store, q, tomorrow, "student@example.com", 10, 1)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("got status %d, want %d: %s", w.Code, http.StatusCreated, w.Body.String())
+	}
+	var a AppointmentSlot
+	err := json.NewDecoder(w.Body).Decode(&a)
+	if err != nil {
+		t.Fatalf("failed to decode response: %v", err)
+	}
+
+	if len(meetings.created) != 1 {
+		t.Fatalf("created %d meetings, want 1", len(meetings.created))
+	}
+	// The meeting's only set up once the signup commits, so the link is
+	// on the stored appointment rather than the response.
+	link := meetings.created[0]
+	stored := store.appointment(a.ID)
+	if stored.MeetingLink == nil || *stored.MeetingLink != link {
+		t.Errorf("got stored meeting link %v, want %s", stored.MeetingLink, link)
+	}
+
+	r, _ := newTestRequest(http.MethodDelete, "/", nil, map[string]interface{}{
+		queueContextKey:       q,
+		appointmentContextKey: stored,
+		emailContextKey:       "student@example.com",
+	})
+	w = httptest.NewRecorder()
+	s.RemoveAppointmentSignup(store).ServeHTTP(w, r)
+	if w.Code != http.StatusNoContent {
+		t.Fatalf("got status %d, want %d: %s", w.Code, http.StatusNoContent, w.Body.String())
+	}
+	if len(meetings.deleted) != 1 || meetings.deleted[0] != link {
+		t.Errorf("got deleted meetings %v, want %s", meetings.deleted, link)
+	}
+}
+
+func TestNoMeetingLinkInPerson(t *testing.T) {
+	s := newTestServer()
+	meetings := &fakeMeetings{}
+	s.meetings = meetings
+	store := newFakeStore()
+	q := store.addQueue(&QueueConfiguration{})
+
+	tomorrow := int(time.Now().Local().Add(24 * time.Hour).Weekday())
+	w := signupRequest(s, store, q, tomorrow, "student@example.com", 10, 1)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("got status %d, want %d: %s", w.Code, http.StatusCreated, w.Body.String())
+	}
+	if len(meetings.created) != 0 {
+		t.Errorf("created %d meetings for an in-person queue", len(meetings.created))
+	}
+}
+
+func TestSignupWithMeetingProviderDown(t *testing.T) {
+	s := newTestServer()
+	s.meetings = &fakeMeetings{down: true}
+	store := newFakeStore()
+	q := store.addQueue(remoteQueue)
+
+	tomorrow := int(time.Now().Local().Add(24 * time.Hour).Weekday())
+	w := signupRequest(s, store, q, tomorrow, "student@example.com", 10, 1)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("got status %d, want the signup to go through anyway: %s", w.Code, w.Body.String())
+	}
+	if len(store.appointments) != 1 || store.appointments[0].MeetingLink != nil {
+		t.Error("got a meeting link while the provider was down")
+	}
+}
+
+// brokenMessageStore can't send messages.
+type brokenMessageStore struct {
+	*fakeStore
+}
+
+func (brokenMessageStore) SendMessage(ctx context.Context, queue ksuid.KSUID, content, from, to string) (*Message, error) {
+	return nil, errBrokenStore
+}
+
+// remoteSignupRequest signs email up at timeslot 10 tomorrow, holding on
+// to afterCommit hooks like the transaction middleware would.
+func remoteSignupRequest(s *Server, sa signupForAppointment, q *Queue, email string) (*httptest.ResponseRecorder, *error, []func()) {
+	var hooks []func()
+	encoded, _ := json.Marshal(map[string]interface{}{"location": "Here", "description": "Help"})
+	r, reqErr := newTestRequest(http.MethodPost, "/", strings.NewReader(string(encoded)), map[string]interface{}{
+		queueContextKey:               q,
+		appointmentDayContextKey:      int(time.Now().Local().Add(24 * time.Hour).Weekday()),
+		appointmentTimeslotContextKey: 10,
+		emailContextKey:               email,
+		nameContextKey:                "Student",
+		courseAdminContextKey:         false,
+		afterCommitContextKey:         &hooks,
+	})
+	w := httptest.NewRecorder()
+	s.SignupForAppointment(sa).ServeHTTP(w, r)
+	return w, reqErr, hooks
+}
+
+func TestFailedSignupCreatesNoMeeting(t *testing.T) {
+	s := newTestServer()
+	meetings := &fakeMeetings{}
+	s.meetings = meetings
+	store := newFakeStore()
+	q := store.addQueue(remoteQueue)
+	tomorrow := int(time.Now().Local().Add(24 * time.Hour).Weekday())
+
+	// Letting the assigned staff member know fails after the appointment
+	// is stored, so the request's transaction gets rolled back and its
+	// hooks never run.
+	store.assignments[q.ID] = map[int]map[int]string{tomorrow: {10: "ta@example.com"}}
+	w, reqErr, _ := remoteSignupRequest(s, brokenMessageStore{store}, q, "student@example.com")
+	if w.Code != http.StatusInternalServerError || *reqErr == nil {
+		t.Fatalf("got status %d and request error %v, want a failed signup", w.Code, *reqErr)
+	}
+	if len(meetings.created) != 0 {
+		t.Errorf("created meetings %v for a signup that was rolled back", meetings.created)
+	}
+}
+
+func TestMeetingWaitsForCommit(t *testing.T) {
+	s := newTestServer()
+	meetings := &fakeMeetings{}
+	s.meetings = meetings
+	store := newFakeStore()
+	q := store.addQueue(remoteQueue)
+
+	w, _, hooks := remoteSignupRequest(s, store, q, "student@example.com")
+	if w.Code != http.StatusCreated {
+		t.Fatalf("got status %d, want %d: %s", w.Code, http.StatusCreated, w.Body.String())
+	}
+	if len(meetings.created) != 0 {
+		t.Fatal("meeting created before commit")
+	}
+
+	for _, f := range hooks {
+		f()
+	}
+	if len(meetings.created) != 1 {
+		t.Fatalf("created %d meetings after commit, want 1", len(meetings.created))
+	}
+	a := store.appointments[0]
+	if a.MeetingLink == nil || *a.MeetingLink != meetings.created[0] {
+		t.Errorf("got stored meeting link %v, want %s", a.MeetingLink, meetings.created[0])
+	}
+
+	// Cancelling tears the meeting down once that commits too.
+	var cancelHooks []func()
+	r, _ := newTestRequest(http.MethodDelete, "/", nil, map[string]interface{}{
+		queueContextKey:       q,
+		appointmentContextKey: store.appointment(a.ID),
+		emailContextKey:       "student@example.com",
+		afterCommitContextKey: &cancelHooks,
+	})
+	w = httptest.NewRecorder()
+	s.RemoveAppointmentSignup(store).ServeHTTP(w, r)
+	if w.Code != http.StatusNoContent {
+		t.Fatalf("got status %d cancelling, want %d", w.Code, http.StatusNoContent)
+	}
+	if len(meetings.deleted) != 0 {
+		t.Fatal("meeting deleted before commit")
+	}
+	for _, f := range cancelHooks {
+		f()
+	}
+	if len(meetings.deleted) != 1 || meetings.deleted[0] != meetings.created[0] {
+		t.Errorf("got deleted meetings %v, want %s", meetings.deleted, meetings.created[0])
+	}
+}
+
+func TestMeetingForCancelledSignup(t *testing.T) {
+	s := newTestServer()
+	meetings := &fakeMeetings{}
+	s.meetings = meetings
+	store := newFakeStore()
+	q := store.addQueue(remoteQueue)
+
+	w, _, hooks := remoteSignupRequest(s, store, q, "student@example.com")
+	if w.Code != http.StatusCreated {
+		t.Fatalf("got status %d, want %d: %s", w.Code, http.StatusCreated, w.Body.String())
+	}
+
+	// The student cancels before the meeting gets set up, so there's
+	// nothing to attach it to.
+	_, _, err := store.RemoveAppointmentSignup(context.Background(), store.appointments[0].ID)
+	if err != nil {
+		t.Fatalf("failed to cancel appointment: %v", err)
+	}
+	for _, f := range hooks {
+		f()
+	}
+	if len(meetings.created) != 1 || len(meetings.deleted) != 1 || meetings.deleted[0] != meetings.created[0] {
+		t.Errorf("got created meetings %v and deleted %v, want the one deleted", meetings.created, meetings.deleted)
+	}
+}
+
+func missingLinksRequest(t *testing.T, s *Server, store *fakeStore, q *Queue) []*AppointmentSlot {
+	t.Helper()
+	r, _ := newTestRequest(http.MethodGet, "/", nil, map[string]interface{}{
+		queueContextKey:       q,
+		emailContextKey:       "staff@example.com",
+		courseAdminContextKey: true,
+	})
+	w := httptest.NewRecorder()
+	s.GetAppointmentsMissingLink(store).ServeHTTP(w, r)
+	if w.Code != http.StatusOK {
+		t.Fatalf("got status %d, want %d: %s", w.Code, http.StatusOK, w.Body.String())
+	}
+
+	var appointments []*AppointmentSlot
+	err := json.NewDecoder(w.Body).Decode(&appointments)
+	if err != nil {
+		t.Fatalf("failed to decode response: %v", err)
+	}
+	return appointments
+}
+
+func retryLinkRequest(s *Server, store *fakeStore, q *Queue, a *AppointmentSlot) *httptest.ResponseRecorder {
+	r, _ := newTestRequest(http.MethodPost, "/", nil, map[string]interface{}{
+		queueContextKey:       q,
+		appointmentContextKey: a,
+		emailContextKey:       "staff@example.com",
+		courseAdminContextKey: true,
+	})
+	w := httptest.NewRecorder()
+	s.RetryProvisionLink(store).ServeHTTP(w, r)
+	return w
+}
+
+func TestRetryMissingMeetingLink(t *testing.T) {
+	s := newTestServer()
+	meetings := &fakeMeetings{}
+	s.meetings = meetings
+	store := newFakeStore()
+	q := store.addQueue(remoteQueue)
+	tomorrow := int(time.Now().Local().Add(24 * time.Hour).Weekday())
+
+	w := signupRequest(s, store, q, tomorrow, "linked@example.com", 10, 1)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("got status %d, want %d: %s", w.Code, http.StatusCreated, w.Body.String())
+	}
+	meetings.down = true
+	w = signupRequest(s, store, q, tomorrow, "student@example.com", 11, 1)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("got status %d, want %d: %s", w.Code, http.StatusCreated, w.Body.String())
+	}
+	store.claim(q, tomorrow, 12, "staff@example.com")
+
+	// Only the sign-up that failed to get a meeting is listed; claims
+	// without a student don't need one.
+	missing := missingLinksRequest(t, s, store, q)
+	if len(missing) != 1 || *missing[0].StudentEmail != "student@example.com" {
+		t.Fatalf("got %d appointments missing links, want just student@example.com's", len(missing))
+	}
+	loaded := *store.appointment(missing[0].ID)
+	a := &loaded
+
+	w = retryLinkRequest(s, store, q, a)
+	if w.Code != http.StatusBadGateway {
+		t.Errorf("got status %d retrying while down, want %d", w.Code, http.StatusBadGateway)
+	}
+	if len(missingLinksRequest(t, s, store, q)) != 1 {
+		t.Error("appointment no longer listed after failed retry")
+	}
+
+	meetings.down = false
+	w = retryLinkRequest(s, store, q, a)
+	if w.Code != http.StatusOK {
+		t.Fatalf("got status %d, want %d: %s", w.Code, http.StatusOK, w.Body.String())
+	}
+	var retried AppointmentSlot
+	err := json.NewDecoder(w.Body).Decode(&retried)
+	if err != nil {
+		t.Fatalf("failed to decode response: %v", err)
+	}
+	link := meetings.created[len(meetings.created)-1]
+	if retried.MeetingLink == nil || *retried.MeetingLink != link {
+		t.Errorf("got meeting link %v, want %s", retried.MeetingLink, link)
+	}
+	if stored := store.appointment(a.ID); stored.MeetingLink == nil || *stored.MeetingLink != link {
+		t.Errorf("got stored meeting link %v, want %s", stored.MeetingLink, link)
+	}
+	if missing := missingLinksRequest(t, s, store, q); len(missing) != 0 {
+		t.Errorf("got %d appointments missing links after retry, want 0", len(missing))
+	}
+}
+
+func TestMissingMeetingLinksInPerson(t *testing.T) {
+	s := newTestServer()
+	store := newFakeStore()
+	q := store.addQueue(&QueueConfiguration{})
+	tomorrow := int(time.Now().Local().Add(24 * time.Hour).Weekday())
+	a := store.book(q, tomorrow, 10, "student@example.com")
+
+	if missing := missingLinksRequest(t, s, store, q); len(missing) != 0 {
+		t.Errorf("got %d appointments missing links on an in-person queue, want 0", len(missing))
+	}
+	w := retryLinkRequest(s, store, q, a)
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("got status %d retrying in person, want %d", w.Code, http.StatusBadRequest)
+	}
+}
@@ -107,6 +107,12 @@ func (s *Server) RegisterQueueStats(q queueStats) {
 	prometheus.MustRegister(&queueStatsCollector{s: s, q: q})
 }
 
+// RegisterBackgroundStats reports how backed up the background worker
+// pool is.
+func (s *Server) RegisterBackgroundStats() {
+	prometheus.MustRegister(s.background)
+}
+
 type QueueStats struct {
 	Queue    string    `json:"queue_id"`
 	Course   string    `json:"course_id"`
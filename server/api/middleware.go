@@ -26,8 +26,33 @@ type transactioner interface {
 const (
 	RequestErrorContextKey = "request_error"
 	TransactionContextKey  = "transaction"
+	afterCommitContextKey  = "after_commit"
 )
 
+// afterCommit holds off on f until the request's transaction has
+// committed, for things outside the database (publishing events, calling
+// other services) that shouldn't happen if the request ends up rolled
+// back, and shouldn't keep the transaction open while they wait on the
+// network. They run on the background worker pool once the response is
+// on its way, in the order they were added, with f getting its own
+// context since the request's is gone by then; if the transaction is
+// rolled back, they don't run at all. Without a transaction (like in
+// tests), f runs right away.
+func afterCommit(ctx context.Context, f func()) {
+	hooks, ok := ctx.Value(afterCommitContextKey).(*[]func())
+	if !ok {
+		f()
+		return
+	}
+	*hooks = append(*hooks, f)
+}
+
+// WaitForAfterCommit waits for everything handed to afterCommit by
+// requests that have already finished.
+func (s *Server) WaitForAfterCommit() {
+	s.afterCommitRunning.Wait()
+}
+
 // This function does tie the API package to sqlx to an extent, but it
 // doesn't need to be used in tests (individual handlers can still be
 // unit tested without this middleware, since the transaction is passed
@@ -37,8 +62,21 @@ const (
 func (s *Server) transaction(tr transactioner) func(http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-			tx, err := tr.BeginTx()
+			// Each request runs in one transaction, so a blip partway
+			// through can't be retried without redoing the whole request.
+			// Getting the transaction going in the first place can be,
+			// though, and that's where a dropped connection usually shows up.
+			var tx *sqlx.Tx
+			err := s.dbRetries.do(r.Context(), func() error {
+				var err error
+				tx, err = tr.BeginTx()
+				return err
+			})
 			if err != nil {
+				s.logger.Errorw("failed to begin transaction",
+					RequestIDContextKey, r.Context().Value(RequestIDContextKey),
+					"err", err,
+				)
 				s.internalServerError(w, r)
 				return
 			}
@@ -48,8 +86,10 @@ func (s *Server) transaction(tr transactioner) func(http.Handler) http.Handler {
 			// best pattern, but go-chi doesn't directly support handlers and
 			// middleware returning errors, and this only needs to occur in one
 			// other place (E.ServeHTTP).
+			var hooks []func()
 			ctx := context.WithValue(r.Context(), RequestErrorContextKey, &err)
 			ctx = context.WithValue(ctx, TransactionContextKey, tx)
+			ctx = context.WithValue(ctx, afterCommitContextKey, &hooks)
 			r = r.WithContext(ctx)
 			next.ServeHTTP(w, r)
 
@@ -76,6 +116,17 @@ func (s *Server) transaction(tr transactioner) func(http.Handler) http.Handler {
 					RequestIDContextKey, r.Context().Value(RequestIDContextKey),
 					"err", err,
 				)
+				return
+			}
+
+			if len(hooks) > 0 {
+				s.afterCommitRunning.Add(1)
+				s.background.Submit(func() {
+					defer s.afterCommitRunning.Done()
+					for _, f := range hooks {
+						f()
+					}
+				})
 			}
 		})
 	}
@@ -146,7 +197,12 @@ type siteAdmin interface {
 func (s *Server) EnsureSiteAdmin(sa siteAdmin) func(http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-			email := r.Context().Value(emailContextKey).(string)
+			email, ok := r.Context().Value(emailContextKey).(string)
+			if !ok {
+				s.missingContextValue(r, emailContextKey)
+				s.internalServerError(w, r)
+				return
+			}
 			admin, err := sa.SiteAdmin(r.Context(), email)
 			if err != nil || !admin {
 				s.logger.Warnw("non-admin attempting to access resource requiring site admin",
@@ -0,0 +1,106 @@
+package api
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/CarsonHoffman/office-hours-queue/server/api/queuepb"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/structpb"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+//go:generate protoc --go_out=.. --go_opt=module=github.com/CarsonHoffman/office-hours-queue/server appointment.proto
+
+const protobufMediaType = "application/x-protobuf"
+
+// protoTimestamp converts t to a google.protobuf.Timestamp, leaving zero
+// times out like the rest of proto3's zero values.
+func protoTimestamp(t time.Time) *timestamppb.Timestamp {
+	if t.IsZero() {
+		return nil
+	}
+	return timestamppb.New(t)
+}
+
+// protoAppointment fills in the generated AppointmentSlot message from a.
+// Custom field answers came in as JSON, so anything that won't fit in a
+// google.protobuf.Struct is an error.
+func protoAppointment(a *AppointmentSlot) (*queuepb.AppointmentSlot, error) {
+	var customFields *structpb.Struct
+	if a.CustomFields != nil {
+		var err error
+		customFields, err = structpb.NewStruct(a.CustomFields)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return &queuepb.AppointmentSlot{
+		Id:               a.ID.String(),
+		Queue:            a.Queue.String(),
+		StaffEmail:       a.StaffEmail,
+		StudentEmail:     a.StudentEmail,
+		ScheduledTime:    protoTimestamp(a.ScheduledTime),
+		Timeslot:         int32(a.Timeslot),
+		Duration:         int32(a.Duration),
+		Name:             a.Name,
+		Location:         a.Location,
+		Description:      a.Description,
+		MapX:             a.MapX,
+		MapY:             a.MapY,
+		UpdatedAt:        protoTimestamp(a.UpdatedAt),
+		MeetingLink:      a.MeetingLink,
+		AnonymousToPeers: a.AnonymousToPeers,
+		Partners:         a.Partners,
+		Labels:           a.Labels,
+		Day:              int32(a.ScheduledTime.In(time.Local).Weekday()),
+		SlotSpan:         int32(a.SlotSpan),
+		Resolved:         a.Resolved,
+		CustomFields:     customFields,
+	}, nil
+}
+
+// marshalAppointmentsProto encodes one appointment as an AppointmentSlot,
+// or a list of them as an AppointmentSlotList. ok is false for anything
+// else, which gets sent as JSON instead.
+func marshalAppointmentsProto(data interface{}) (b []byte, ok bool, err error) {
+	var m proto.Message
+	switch d := data.(type) {
+	case *AppointmentSlot:
+		m, err = protoAppointment(d)
+		if err != nil {
+			return nil, true, err
+		}
+	case []*AppointmentSlot:
+		list := &queuepb.AppointmentSlotList{
+			Appointments: make([]*queuepb.AppointmentSlot, 0, len(d)),
+		}
+		for _, a := range d {
+			pa, err := protoAppointment(a)
+			if err != nil {
+				return nil, true, err
+			}
+			list.Appointments = append(list.Appointments, pa)
+		}
+		m = list
+	default:
+		return nil, false, nil
+	}
+
+	b, err = proto.Marshal(m)
+	return b, true, err
+}
+
+func (s *Server) sendProtobufResponse(code int, body []byte, w http.ResponseWriter, r *http.Request) error {
+	w.Header().Add("Content-Type", protobufMediaType)
+	w.WriteHeader(code)
+	_, err := w.Write(body)
+	if err != nil {
+		s.logger.Warnw("failed to write response to client",
+			RequestIDContextKey, r.Context().Value(RequestIDContextKey),
+			"err", err,
+		)
+	}
+	return err
+}
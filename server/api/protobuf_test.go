@@ -0,0 +1,160 @@
+package api
+
+import (
+	"testing"
+	"time"
+
+	"github.com/CarsonHoffman/office-hours-queue/server/api/queuepb"
+	"github.com/segmentio/ksuid"
+	"google.golang.org/protobuf/proto"
+)
+
+func testAppointment() *AppointmentSlot {
+	staff, student := "staff@example.com", "student@example.com"
+	name, location, description := "Student", "Here", "Help"
+	link := "https://meet.example.com/abc"
+	x, y := float32(0.25), float32(0.75)
+	resolved := true
+	return &AppointmentSlot{
+		ID:               ksuid.New(),
+		Queue:            ksuid.New(),
+		StaffEmail:       &staff,
+		StudentEmail:     &student,
+		ScheduledTime:    time.Date(2022, time.March, 2, 14, 30, 0, 0, time.Local),
+		Timeslot:         29,
+		Duration:         30,
+		Name:             &name,
+		Location:         &location,
+		Description:      &description,
+		MapX:             &x,
+		MapY:             &y,
+		UpdatedAt:        time.Date(2022, time.March, 1, 9, 0, 0, 500, time.Local),
+		MeetingLink:      &link,
+		AnonymousToPeers: true,
+		Partners:         []string{"partner@example.com"},
+		Labels:           []string{"debugging", "exam"},
+		SlotSpan:         2,
+		Resolved:         &resolved,
+		CustomFields:     map[string]interface{}{"section": "A", "attempts": float64(3)},
+	}
+}
+
+func checkProtoAppointment(t *testing.T, got *queuepb.AppointmentSlot, want *AppointmentSlot) {
+	t.Helper()
+	if got.Id != want.ID.String() || got.Queue != want.Queue.String() {
+		t.Errorf("got IDs %s/%s, want %s/%s", got.Id, got.Queue, want.ID, want.Queue)
+	}
+	if got.GetStaffEmail() != *want.StaffEmail || got.GetStudentEmail() != *want.StudentEmail {
+		t.Errorf("got emails %s/%s, want %s/%s", got.GetStaffEmail(), got.GetStudentEmail(), *want.StaffEmail, *want.StudentEmail)
+	}
+	if !got.ScheduledTime.AsTime().Equal(want.ScheduledTime) || !got.UpdatedAt.AsTime().Equal(want.UpdatedAt) {
+		t.Errorf("got times %v/%v, want %v/%v", got.ScheduledTime.AsTime(), got.UpdatedAt.AsTime(), want.ScheduledTime, want.UpdatedAt)
+	}
+	if int(got.Timeslot) != want.Timeslot || int(got.Duration) != want.Duration {
+		t.Errorf("got timeslot %d and duration %d, want %d and %d", got.Timeslot, got.Duration, want.Timeslot, want.Duration)
+	}
+	if got.GetName() != *want.Name || got.GetLocation() != *want.Location || got.GetDescription() != *want.Description {
+		t.Errorf("got name/location/description %s/%s/%s", got.GetName(), got.GetLocation(), got.GetDescription())
+	}
+	if got.GetMapX() != *want.MapX || got.GetMapY() != *want.MapY {
+		t.Errorf("got map pin (%f, %f), want (%f, %f)", got.GetMapX(), got.GetMapY(), *want.MapX, *want.MapY)
+	}
+	if got.GetMeetingLink() != *want.MeetingLink || got.AnonymousToPeers != want.AnonymousToPeers {
+		t.Errorf("got meeting link %s and anonymous %t", got.GetMeetingLink(), got.AnonymousToPeers)
+	}
+	if len(got.Partners) != len(want.Partners) || len(got.Labels) != len(want.Labels) {
+		t.Errorf("got partners %v and labels %v, want %v and %v", got.Partners, got.Labels, want.Partners, want.Labels)
+	}
+	if got.Day != int32(want.ScheduledTime.Weekday()) {
+		t.Errorf("got day %d, want %d", got.Day, want.ScheduledTime.Weekday())
+	}
+	if int(got.SlotSpan) != want.SlotSpan || got.GetResolved() != *want.Resolved {
+		t.Errorf("got slot span %d and resolved %t, want %d and %t", got.SlotSpan, got.GetResolved(), want.SlotSpan, *want.Resolved)
+	}
+	fields := got.GetCustomFields().AsMap()
+	if len(fields) != len(want.CustomFields) {
+		t.Errorf("got custom fields %v, want %v", fields, want.CustomFields)
+	}
+	for k, v := range want.CustomFields {
+		if fields[k] != v {
+			t.Errorf("got custom field %s = %v, want %v", k, fields[k], v)
+		}
+	}
+}
+
+func TestProtoAppointmentRoundTrip(t *testing.T) {
+	a := testAppointment()
+	b, ok, err := marshalAppointmentsProto(a)
+	if !ok || err != nil {
+		t.Fatalf("failed to encode appointment: ok %t, err %v", ok, err)
+	}
+
+	var decoded queuepb.AppointmentSlot
+	err = proto.Unmarshal(b, &decoded)
+	if err != nil {
+		t.Fatalf("failed to decode appointment: %v", err)
+	}
+	if len(decoded.ProtoReflect().GetUnknown()) > 0 {
+		t.Error("encoded appointment has fields the descriptor doesn't know about")
+	}
+	checkProtoAppointment(t, &decoded, a)
+}
+
+func TestProtoAppointmentListRoundTrip(t *testing.T) {
+	appointments := []*AppointmentSlot{testAppointment(), testAppointment()}
+	b, ok, err := marshalAppointmentsProto(appointments)
+	if !ok || err != nil {
+		t.Fatalf("failed to encode appointments: ok %t, err %v", ok, err)
+	}
+
+	var decoded queuepb.AppointmentSlotList
+	err = proto.Unmarshal(b, &decoded)
+	if err != nil {
+		t.Fatalf("failed to decode appointments: %v", err)
+	}
+	if len(decoded.Appointments) != len(appointments) {
+		t.Fatalf("got %d appointments, want %d", len(decoded.Appointments), len(appointments))
+	}
+	for i, a := range appointments {
+		checkProtoAppointment(t, decoded.Appointments[i], a)
+	}
+}
+
+func TestProtoOptionalFieldsLeftOut(t *testing.T) {
+	a := &AppointmentSlot{ID: ksuid.New(), Queue: ksuid.New()}
+	b, _, err := marshalAppointmentsProto(a)
+	if err != nil {
+		t.Fatalf("failed to encode appointment: %v", err)
+	}
+
+	var decoded queuepb.AppointmentSlot
+	err = proto.Unmarshal(b, &decoded)
+	if err != nil {
+		t.Fatalf("failed to decode appointment: %v", err)
+	}
+	if decoded.StaffEmail != nil || decoded.StudentEmail != nil || decoded.MeetingLink != nil {
+		t.Error("got optional fields that weren't set")
+	}
+	if decoded.ScheduledTime != nil || decoded.UpdatedAt != nil {
+		t.Error("got zero times")
+	}
+	if decoded.Resolved != nil || decoded.CustomFields != nil {
+		t.Error("got resolved or custom fields that weren't set")
+	}
+}
+
+func TestProtoCustomFieldsNotInStruct(t *testing.T) {
+	a := testAppointment()
+	a.CustomFields = map[string]interface{}{"when": time.Now()}
+	_, ok, err := marshalAppointmentsProto(a)
+	if !ok || err == nil {
+		t.Errorf("got ok %t and err %v for custom field that won't fit in a Struct", ok, err)
+	}
+}
+
+func TestMarshalOtherDataAsJSON(t *testing.T) {
+	_, ok, err := marshalAppointmentsProto(map[string]int{"count": 1})
+	if ok || err != nil {
+		t.Errorf("got ok %t and err %v for non-appointment data", ok, err)
+	}
+}
@@ -3,10 +3,14 @@ package api
 import (
 	"context"
 	"database/sql"
+	"encoding/csv"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
 	"net/http"
+	"net/mail"
+	"strings"
 	"time"
 
 	"github.com/go-chi/chi"
@@ -15,6 +19,7 @@ import (
 	"github.com/olivere/elastic/v7"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/segmentio/ksuid"
+	"go.uber.org/zap"
 )
 
 func init() {
@@ -114,13 +119,19 @@ type getQueueDetails interface {
 
 func (s *Server) GetQueue(gd getQueueDetails) E {
 	return func(w http.ResponseWriter, r *http.Request) error {
-		q := r.Context().Value(queueContextKey).(*Queue)
+		q, ok := r.Context().Value(queueContextKey).(*Queue)
+		if !ok {
+			return s.missingContextValue(r, queueContextKey)
+		}
 		l := s.logger.With(
 			RequestIDContextKey, r.Context().Value(RequestIDContextKey),
 			"queue_id", q.ID,
 		)
 
-		admin := r.Context().Value(courseAdminContextKey).(bool)
+		admin, ok := r.Context().Value(courseAdminContextKey).(bool)
+		if !ok {
+			return s.missingContextValue(r, courseAdminContextKey)
+		}
 		// This is a bit of a hack, but we're okay with the zero value
 		// of string if the assertion fails, but we don't want it to panic,
 		// so we need to do the two-value assertion
@@ -247,10 +258,16 @@ func (s *Server) QueueWebsocket() E {
 	return func(w http.ResponseWriter, r *http.Request) error {
 		var topics []string
 
-		q := r.Context().Value(queueContextKey).(*Queue)
+		q, ok := r.Context().Value(queueContextKey).(*Queue)
+		if !ok {
+			return s.missingContextValue(r, queueContextKey)
+		}
 		topics = append(topics, QueueTopicGeneric(q.ID))
 
-		admin := r.Context().Value(courseAdminContextKey).(bool)
+		admin, ok := r.Context().Value(courseAdminContextKey).(bool)
+		if !ok {
+			return s.missingContextValue(r, courseAdminContextKey)
+		}
 		if admin {
 			topics = append(topics, QueueTopicAdmin(q.ID))
 		} else {
@@ -410,8 +427,14 @@ type updateQueue interface {
 
 func (s *Server) UpdateQueue(uq updateQueue) E {
 	return func(w http.ResponseWriter, r *http.Request) error {
-		q := r.Context().Value(queueContextKey).(*Queue)
-		email := r.Context().Value(emailContextKey).(string)
+		q, ok := r.Context().Value(queueContextKey).(*Queue)
+		if !ok {
+			return s.missingContextValue(r, queueContextKey)
+		}
+		email, ok := r.Context().Value(emailContextKey).(string)
+		if !ok {
+			return s.missingContextValue(r, emailContextKey)
+		}
 		l := s.logger.With(
 			RequestIDContextKey, r.Context().Value(RequestIDContextKey),
 			"queue_id", q.ID,
@@ -453,8 +476,14 @@ type removeQueue interface {
 
 func (s *Server) RemoveQueue(rq removeQueue) E {
 	return func(w http.ResponseWriter, r *http.Request) error {
-		q := r.Context().Value(queueContextKey).(*Queue)
-		email := r.Context().Value(emailContextKey).(string)
+		q, ok := r.Context().Value(queueContextKey).(*Queue)
+		if !ok {
+			return s.missingContextValue(r, queueContextKey)
+		}
+		email, ok := r.Context().Value(emailContextKey).(string)
+		if !ok {
+			return s.missingContextValue(r, emailContextKey)
+		}
 		l := s.logger.With(
 			RequestIDContextKey, r.Context().Value(RequestIDContextKey),
 			"queue_id", q.ID,
@@ -474,8 +503,14 @@ func (s *Server) RemoveQueue(rq removeQueue) E {
 
 func (s *Server) GetQueueStack(gs getQueueStack) E {
 	return func(w http.ResponseWriter, r *http.Request) error {
-		q := r.Context().Value(queueContextKey).(*Queue)
-		email := r.Context().Value(emailContextKey).(string)
+		q, ok := r.Context().Value(queueContextKey).(*Queue)
+		if !ok {
+			return s.missingContextValue(r, queueContextKey)
+		}
+		email, ok := r.Context().Value(emailContextKey).(string)
+		if !ok {
+			return s.missingContextValue(r, emailContextKey)
+		}
 
 		stack, err := gs.GetQueueStack(r.Context(), q.ID, 10000)
 		if err != nil {
@@ -511,9 +546,18 @@ type addQueueEntry interface {
 
 func (s *Server) AddQueueEntry(ae addQueueEntry) E {
 	return func(w http.ResponseWriter, r *http.Request) error {
-		q := r.Context().Value(queueContextKey).(*Queue)
-		email := r.Context().Value(emailContextKey).(string)
-		name := r.Context().Value(nameContextKey).(string)
+		q, ok := r.Context().Value(queueContextKey).(*Queue)
+		if !ok {
+			return s.missingContextValue(r, queueContextKey)
+		}
+		email, ok := r.Context().Value(emailContextKey).(string)
+		if !ok {
+			return s.missingContextValue(r, emailContextKey)
+		}
+		name, ok := r.Context().Value(nameContextKey).(string)
+		if !ok {
+			return s.missingContextValue(r, nameContextKey)
+		}
 		l := s.logger.With(
 			RequestIDContextKey, r.Context().Value(RequestIDContextKey),
 			"queue_id", q.ID,
@@ -610,10 +654,19 @@ type updateQueueEntry interface {
 
 func (s *Server) UpdateQueueEntry(ue updateQueueEntry) E {
 	return func(w http.ResponseWriter, r *http.Request) error {
-		q := r.Context().Value(queueContextKey).(*Queue)
+		q, ok := r.Context().Value(queueContextKey).(*Queue)
+		if !ok {
+			return s.missingContextValue(r, queueContextKey)
+		}
 		id := chi.URLParam(r, "entry_id")
-		email := r.Context().Value(emailContextKey).(string)
-		name := r.Context().Value(nameContextKey).(string)
+		email, ok := r.Context().Value(emailContextKey).(string)
+		if !ok {
+			return s.missingContextValue(r, emailContextKey)
+		}
+		name, ok := r.Context().Value(nameContextKey).(string)
+		if !ok {
+			return s.missingContextValue(r, nameContextKey)
+		}
 		l := s.logger.With(
 			RequestIDContextKey, r.Context().Value(RequestIDContextKey),
 			"entry_id", id,
@@ -698,9 +751,15 @@ type removeQueueEntry interface {
 
 func (s *Server) RemoveQueueEntry(re removeQueueEntry) E {
 	return func(w http.ResponseWriter, r *http.Request) error {
-		q := r.Context().Value(queueContextKey).(*Queue)
+		q, ok := r.Context().Value(queueContextKey).(*Queue)
+		if !ok {
+			return s.missingContextValue(r, queueContextKey)
+		}
 		id := chi.URLParam(r, "entry_id")
-		email := r.Context().Value(emailContextKey).(string)
+		email, ok := r.Context().Value(emailContextKey).(string)
+		if !ok {
+			return s.missingContextValue(r, emailContextKey)
+		}
 		l := s.logger.With(
 			RequestIDContextKey, r.Context().Value(RequestIDContextKey),
 			"entry_id", id,
@@ -759,9 +818,15 @@ type pinQueueEntry interface {
 
 func (s *Server) PinQueueEntry(pb pinQueueEntry) E {
 	return func(w http.ResponseWriter, r *http.Request) error {
-		q := r.Context().Value(queueContextKey).(*Queue)
+		q, ok := r.Context().Value(queueContextKey).(*Queue)
+		if !ok {
+			return s.missingContextValue(r, queueContextKey)
+		}
 		id := chi.URLParam(r, "entry_id")
-		email := r.Context().Value(emailContextKey).(string)
+		email, ok := r.Context().Value(emailContextKey).(string)
+		if !ok {
+			return s.missingContextValue(r, emailContextKey)
+		}
 		l := s.logger.With(
 			RequestIDContextKey, r.Context().Value(RequestIDContextKey),
 			"entry_id", id,
@@ -832,9 +897,15 @@ type setQueueEntryHelping interface {
 
 func (s *Server) SetQueueEntryHelping(eh setQueueEntryHelping) E {
 	return func(w http.ResponseWriter, r *http.Request) error {
-		q := r.Context().Value(queueContextKey).(*Queue)
+		q, ok := r.Context().Value(queueContextKey).(*Queue)
+		if !ok {
+			return s.missingContextValue(r, queueContextKey)
+		}
 		id := chi.URLParam(r, "entry_id")
-		email := r.Context().Value(emailContextKey).(string)
+		email, ok := r.Context().Value(emailContextKey).(string)
+		if !ok {
+			return s.missingContextValue(r, emailContextKey)
+		}
 		l := s.logger.With(
 			RequestIDContextKey, r.Context().Value(RequestIDContextKey),
 			"entry_id", id,
@@ -903,8 +974,14 @@ type randomizeQueueEntries interface {
 
 func (s *Server) RandomizeQueueEntries(re randomizeQueueEntries) E {
 	return func(w http.ResponseWriter, r *http.Request) error {
-		q := r.Context().Value(queueContextKey).(*Queue)
-		email := r.Context().Value(emailContextKey).(string)
+		q, ok := r.Context().Value(queueContextKey).(*Queue)
+		if !ok {
+			return s.missingContextValue(r, queueContextKey)
+		}
+		email, ok := r.Context().Value(emailContextKey).(string)
+		if !ok {
+			return s.missingContextValue(r, emailContextKey)
+		}
 		err := re.RandomizeQueueEntries(r.Context(), q.ID)
 		if err != nil {
 			s.logger.Errorw("failed to randomize queue",
@@ -949,8 +1026,14 @@ type clearQueueEntries interface {
 
 func (s *Server) ClearQueueEntries(ce clearQueueEntries) E {
 	return func(w http.ResponseWriter, r *http.Request) error {
-		q := r.Context().Value(queueContextKey).(*Queue)
-		email := r.Context().Value(emailContextKey).(string)
+		q, ok := r.Context().Value(queueContextKey).(*Queue)
+		if !ok {
+			return s.missingContextValue(r, queueContextKey)
+		}
+		email, ok := r.Context().Value(emailContextKey).(string)
+		if !ok {
+			return s.missingContextValue(r, emailContextKey)
+		}
 		err := ce.ClearQueueEntries(r.Context(), q.ID, email)
 		if err != nil {
 			s.logger.Errorw("failed to clear queue",
@@ -981,8 +1064,14 @@ type addQueueAnnouncement interface {
 
 func (s *Server) AddQueueAnnouncement(aa addQueueAnnouncement) E {
 	return func(w http.ResponseWriter, r *http.Request) error {
-		q := r.Context().Value(queueContextKey).(*Queue)
-		email := r.Context().Value(emailContextKey).(string)
+		q, ok := r.Context().Value(queueContextKey).(*Queue)
+		if !ok {
+			return s.missingContextValue(r, queueContextKey)
+		}
+		email, ok := r.Context().Value(emailContextKey).(string)
+		if !ok {
+			return s.missingContextValue(r, emailContextKey)
+		}
 
 		var announcement Announcement
 		err := json.NewDecoder(r.Body).Decode(&announcement)
@@ -1040,7 +1129,10 @@ type removeQueueAnnouncement interface {
 
 func (s *Server) RemoveQueueAnnouncement(ra removeQueueAnnouncement) E {
 	return func(w http.ResponseWriter, r *http.Request) error {
-		q := r.Context().Value(queueContextKey).(*Queue)
+		q, ok := r.Context().Value(queueContextKey).(*Queue)
+		if !ok {
+			return s.missingContextValue(r, queueContextKey)
+		}
 
 		id := chi.URLParam(r, "announcement_id")
 		announcement, err := ksuid.Parse(id)
@@ -1084,7 +1176,10 @@ type getQueueSchedule interface {
 
 func (s *Server) GetQueueSchedule(gs getQueueSchedule) E {
 	return func(w http.ResponseWriter, r *http.Request) error {
-		q := r.Context().Value(queueContextKey).(*Queue)
+		q, ok := r.Context().Value(queueContextKey).(*Queue)
+		if !ok {
+			return s.missingContextValue(r, queueContextKey)
+		}
 		schedules, err := gs.GetQueueSchedule(r.Context(), q.ID)
 		if err != nil {
 			s.logger.Errorw("failed to get queue schedule",
@@ -1105,7 +1200,10 @@ type updateQueueSchedule interface {
 
 func (s *Server) UpdateQueueSchedule(us updateQueueSchedule) E {
 	return func(w http.ResponseWriter, r *http.Request) error {
-		q := r.Context().Value(queueContextKey).(*Queue)
+		q, ok := r.Context().Value(queueContextKey).(*Queue)
+		if !ok {
+			return s.missingContextValue(r, queueContextKey)
+		}
 
 		var schedules []string
 		err := json.NewDecoder(r.Body).Decode(&schedules)
@@ -1164,7 +1262,10 @@ type getQueueConfiguration interface {
 
 func (s *Server) GetQueueConfiguration(gc getQueueConfiguration) E {
 	return func(w http.ResponseWriter, r *http.Request) error {
-		q := r.Context().Value(queueContextKey).(*Queue)
+		q, ok := r.Context().Value(queueContextKey).(*Queue)
+		if !ok {
+			return s.missingContextValue(r, queueContextKey)
+		}
 
 		config, err := gc.GetQueueConfiguration(r.Context(), q.ID)
 		if err != nil {
@@ -1180,31 +1281,177 @@ func (s *Server) GetQueueConfiguration(gc getQueueConfiguration) E {
 	}
 }
 
-type updateQueueConfiguration interface {
-	UpdateQueueConfiguration(ctx context.Context, queue ksuid.KSUID, configuration *QueueConfiguration) error
-}
+// validateAppointmentSettings checks appointment settings from a request,
+// filling in defaults for any that were left empty.
+func (s *Server) validateAppointmentSettings(r *http.Request, q *Queue, settings *AppointmentSettings) error {
+	switch settings.FutureAppointmentScope {
+	case "":
+		settings.FutureAppointmentScope = FutureAppointmentScopeQueue
+	case FutureAppointmentScopeQueue, FutureAppointmentScopeDay:
+	default:
+		s.logger.Warnw("got unknown future appointment scope",
+			RequestIDContextKey, r.Context().Value(RequestIDContextKey),
+			"queue_id", q.ID,
+			"future_appointment_scope", settings.FutureAppointmentScope,
+		)
+		return StatusError{
+			http.StatusBadRequest,
+			"The future appointment scope needs to be either `queue` or `day`.",
+		}
+	}
 
-func (s *Server) UpdateQueueConfiguration(uc updateQueueConfiguration) E {
-	return func(w http.ResponseWriter, r *http.Request) error {
-		q := r.Context().Value(queueContextKey).(*Queue)
+	switch settings.AppointmentLocationType {
+	case "":
+		settings.AppointmentLocationType = AppointmentLocationInPerson
+	case AppointmentLocationInPerson, AppointmentLocationRemote:
+	default:
+		s.logger.Warnw("got unknown appointment location type",
+			RequestIDContextKey, r.Context().Value(RequestIDContextKey),
+			"queue_id", q.ID,
+			"appointment_location_type", settings.AppointmentLocationType,
+		)
+		return StatusError{
+			http.StatusBadRequest,
+			"The appointment location type needs to be either `in_person` or `remote`.",
+		}
+	}
 
-		var config QueueConfiguration
-		err := json.NewDecoder(r.Body).Decode(&config)
-		if err != nil {
-			s.logger.Warnw("failed to decode configuration",
+	switch settings.AvailabilityDisplay {
+	case "":
+		settings.AvailabilityDisplay = AvailabilityDisplayExact
+	case AvailabilityDisplayExact, AvailabilityDisplayCoarse:
+	default:
+		s.logger.Warnw("got unknown availability display",
+			RequestIDContextKey, r.Context().Value(RequestIDContextKey),
+			"queue_id", q.ID,
+			"availability_display", settings.AvailabilityDisplay,
+		)
+		return StatusError{
+			http.StatusBadRequest,
+			"The availability display needs to be either `exact` or `coarse`.",
+		}
+	}
+
+	if settings.MaxConcurrentAppointments < 0 {
+		s.logger.Warnw("got negative max concurrent appointments",
+			RequestIDContextKey, r.Context().Value(RequestIDContextKey),
+			"queue_id", q.ID,
+			"max_concurrent_appointments", settings.MaxConcurrentAppointments,
+		)
+		return StatusError{
+			http.StatusBadRequest,
+			"The maximum number of concurrent appointments can't be negative. Use 0 for no limit.",
+		}
+	}
+
+	if settings.MaxAppointmentFieldLength < 0 {
+		s.logger.Warnw("got negative max appointment field length",
+			RequestIDContextKey, r.Context().Value(RequestIDContextKey),
+			"queue_id", q.ID,
+			"max_appointment_field_length", settings.MaxAppointmentFieldLength,
+		)
+		return StatusError{
+			http.StatusBadRequest,
+			"The maximum appointment field length can't be negative. Use 0 for no limit.",
+		}
+	}
+
+	if settings.MinAppointmentDescriptionLength < 0 {
+		s.logger.Warnw("got negative min appointment description length",
+			RequestIDContextKey, r.Context().Value(RequestIDContextKey),
+			"queue_id", q.ID,
+			"min_appointment_description_length", settings.MinAppointmentDescriptionLength,
+		)
+		return StatusError{
+			http.StatusBadRequest,
+			"The minimum appointment description length can't be negative. Use 0 for no minimum.",
+		}
+	}
+
+	if settings.MaxAppointmentFieldLength > 0 && settings.MinAppointmentDescriptionLength > settings.MaxAppointmentFieldLength {
+		s.logger.Warnw("got min appointment description length over max field length",
+			RequestIDContextKey, r.Context().Value(RequestIDContextKey),
+			"queue_id", q.ID,
+			"min_appointment_description_length", settings.MinAppointmentDescriptionLength,
+			"max_appointment_field_length", settings.MaxAppointmentFieldLength,
+		)
+		return StatusError{
+			http.StatusBadRequest,
+			"The minimum appointment description length can't be more than the maximum field length.",
+		}
+	}
+
+	if settings.ClaimGraceMinutes < 0 {
+		s.logger.Warnw("got negative claim grace period",
+			RequestIDContextKey, r.Context().Value(RequestIDContextKey),
+			"queue_id", q.ID,
+			"claim_grace_minutes", settings.ClaimGraceMinutes,
+		)
+		return StatusError{
+			http.StatusBadRequest,
+			"The claim grace period can't be negative. Use 0 to only allow claiming timeslots that haven't started.",
+		}
+	}
+
+	if settings.RequireDescriptionTemplate && len(templateSections(settings.DescriptionTemplate)) == 0 {
+		s.logger.Warnw("got required description template without sections",
+			RequestIDContextKey, r.Context().Value(RequestIDContextKey),
+			"queue_id", q.ID,
+			"description_template", settings.DescriptionTemplate,
+		)
+		return StatusError{
+			http.StatusBadRequest,
+			"To require the description template, it needs at least one section heading: a line ending with a colon.",
+		}
+	}
+
+	// Settings that are fine on their own can still leave students with no
+	// way to sign up at all when put together.
+	if settings.RequireDescriptionTemplate && settings.MaxAppointmentFieldLength > 0 {
+		if n := shortestTemplateFill(templateSections(settings.DescriptionTemplate)); n > settings.MaxAppointmentFieldLength {
+			s.logger.Warnw("got required description template longer than max field length",
 				RequestIDContextKey, r.Context().Value(RequestIDContextKey),
 				"queue_id", q.ID,
-				"err", err,
+				"shortest_template_fill", n,
+				"max_appointment_field_length", settings.MaxAppointmentFieldLength,
 			)
 			return StatusError{
 				http.StatusBadRequest,
-				"We couldn't read the configuration from the request body.",
+				fmt.Sprintf("Filling in every section of the required description template takes at least %d characters, which is over the maximum field length.", n),
 			}
 		}
+	}
+
+	if flag, needs := unmetFlagRequirement(settings.Flags()); flag != "" {
+		s.logger.Warnw("got appointment flag without one it needs",
+			RequestIDContextKey, r.Context().Value(RequestIDContextKey),
+			"queue_id", q.ID,
+			"flag", flag,
+			"needs", needs,
+		)
+		return StatusError{
+			http.StatusBadRequest,
+			fmt.Sprintf("Turning on `%s` needs `%s` turned on too.", flag, needs),
+		}
+	}
+
+	return nil
+}
+
+type getAppointmentSettings interface {
+	getQueueConfiguration
+}
+
+func (s *Server) GetAppointmentSettings(gs getAppointmentSettings) E {
+	return func(w http.ResponseWriter, r *http.Request) error {
+		q, ok := r.Context().Value(queueContextKey).(*Queue)
+		if !ok {
+			return s.missingContextValue(r, queueContextKey)
+		}
 
-		err = uc.UpdateQueueConfiguration(r.Context(), q.ID, &config)
+		config, err := gs.GetQueueConfiguration(r.Context(), q.ID)
 		if err != nil {
-			s.logger.Errorw("failed to update queue configuration",
+			s.logger.Errorw("failed to get queue configuration",
 				RequestIDContextKey, r.Context().Value(RequestIDContextKey),
 				"queue_id", q.ID,
 				"err", err,
@@ -1212,13 +1459,184 @@ func (s *Server) UpdateQueueConfiguration(uc updateQueueConfiguration) E {
 			return err
 		}
 
-		s.logger.Infow("updated queue configuration", RequestIDContextKey, r.Context().Value(RequestIDContextKey),
+		return s.sendResponse(http.StatusOK, config.AppointmentSettings, w, r)
+	}
+}
+
+type pendingApprovalRemovals interface {
+	GetPendingApprovalRemoval(ctx context.Context, queue ksuid.KSUID) (*PendingApprovalRemoval, error)
+	SetPendingApprovalRemoval(ctx context.Context, queue ksuid.KSUID, email string) error
+	RemovePendingApprovalRemoval(ctx context.Context, queue ksuid.KSUID) error
+}
+
+// holdApprovalRemoval keeps one admin from turning off schedule approval
+// on their own, since that would let them change schedules unchecked. If
+// settings would turn it off, it stays on, and the removal is proposed
+// for another admin to approve instead. It returns the proposal, if
+// there is one.
+func (s *Server) holdApprovalRemoval(ctx context.Context, l *zap.SugaredLogger, pr pendingApprovalRemovals, q *Queue, email string, current, settings *AppointmentSettings) (*PendingApprovalRemoval, error) {
+	if !current.RequireScheduleApproval || settings.RequireScheduleApproval {
+		return nil, nil
+	}
+	settings.RequireScheduleApproval = true
+
+	err := pr.SetPendingApprovalRemoval(ctx, q.ID, email)
+	if err != nil {
+		l.Errorw("failed to set pending approval removal", "err", err)
+		return nil, err
+	}
+
+	pending, err := pr.GetPendingApprovalRemoval(ctx, q.ID)
+	if err != nil {
+		l.Errorw("failed to get pending approval removal", "err", err)
+		return nil, err
+	}
+
+	l.Infow("proposed removing schedule approval")
+	return pending, nil
+}
+
+type updateAppointmentSettings interface {
+	getQueueConfiguration
+	pendingApprovalRemovals
+	updateQueueConfiguration
+}
+
+// UpdateAppointmentSettings replaces just the appointment settings of a
+// queue's configuration, leaving the rest of it alone.
+func (s *Server) UpdateAppointmentSettings(us updateAppointmentSettings) E {
+	return func(w http.ResponseWriter, r *http.Request) error {
+		q, ok := r.Context().Value(queueContextKey).(*Queue)
+		if !ok {
+			return s.missingContextValue(r, queueContextKey)
+		}
+		email, ok := r.Context().Value(emailContextKey).(string)
+		if !ok {
+			return s.missingContextValue(r, emailContextKey)
+		}
+		l := s.logger.With(
+			RequestIDContextKey, r.Context().Value(RequestIDContextKey),
 			"queue_id", q.ID,
-			"configuration", config,
+			"email", email,
 		)
 
+		var settings AppointmentSettings
+		err := s.decodeLimitedBody(w, r, &settings)
+		if errors.Is(err, errBodyTooLarge) {
+			l.Warnw("appointment settings request body too large")
+			return err
+		}
+		if err != nil {
+			l.Warnw("failed to decode appointment settings", "err", err)
+			return StatusError{
+				http.StatusBadRequest,
+				"We couldn't read the appointment settings from the request body.",
+			}
+		}
+
+		err = s.validateAppointmentSettings(r, q, &settings)
+		if err != nil {
+			return err
+		}
+
+		config, err := us.GetQueueConfiguration(r.Context(), q.ID)
+		if err != nil {
+			l.Errorw("failed to get queue configuration", "err", err)
+			return err
+		}
+
+		pending, err := s.holdApprovalRemoval(r.Context(), l, us, q, email, &config.AppointmentSettings, &settings)
+		if err != nil {
+			return err
+		}
+
+		config.AppointmentSettings = settings
+		err = us.UpdateQueueConfiguration(r.Context(), q.ID, config)
+		if err != nil {
+			l.Errorw("failed to update queue configuration", "err", err)
+			return err
+		}
+
+		l.Infow("updated appointment settings", "settings", settings)
+
+		s.ps.Pub(WS("REFRESH", nil), QueueTopicGeneric(q.ID))
+
+		if pending != nil {
+			s.ps.Pub(WS("APPROVAL_REMOVAL_PENDING", pending), QueueTopicAdmin(q.ID))
+			return s.sendResponse(http.StatusAccepted, pending, w, r)
+		}
+
+		return s.sendResponse(http.StatusOK, settings, w, r)
+	}
+}
+
+type updateQueueConfiguration interface {
+	UpdateQueueConfiguration(ctx context.Context, queue ksuid.KSUID, configuration *QueueConfiguration) error
+}
+
+type replaceQueueConfiguration interface {
+	getQueueConfiguration
+	pendingApprovalRemovals
+	updateQueueConfiguration
+}
+
+func (s *Server) UpdateQueueConfiguration(rc replaceQueueConfiguration) E {
+	return func(w http.ResponseWriter, r *http.Request) error {
+		q, ok := r.Context().Value(queueContextKey).(*Queue)
+		if !ok {
+			return s.missingContextValue(r, queueContextKey)
+		}
+		email, ok := r.Context().Value(emailContextKey).(string)
+		if !ok {
+			return s.missingContextValue(r, emailContextKey)
+		}
+		l := s.logger.With(
+			RequestIDContextKey, r.Context().Value(RequestIDContextKey),
+			"queue_id", q.ID,
+			"email", email,
+		)
+
+		var config QueueConfiguration
+		err := json.NewDecoder(r.Body).Decode(&config)
+		if err != nil {
+			l.Warnw("failed to decode configuration", "err", err)
+			return StatusError{
+				http.StatusBadRequest,
+				"We couldn't read the configuration from the request body.",
+			}
+		}
+
+		err = s.validateAppointmentSettings(r, q, &config.AppointmentSettings)
+		if err != nil {
+			return err
+		}
+
+		current, err := rc.GetQueueConfiguration(r.Context(), q.ID)
+		if err != nil {
+			l.Errorw("failed to get queue configuration", "err", err)
+			return err
+		}
+
+		pending, err := s.holdApprovalRemoval(r.Context(), l, rc, q, email, &current.AppointmentSettings, &config.AppointmentSettings)
+		if err != nil {
+			return err
+		}
+
+		err = rc.UpdateQueueConfiguration(r.Context(), q.ID, &config)
+		if err != nil {
+			l.Errorw("failed to update queue configuration", "err", err)
+			return err
+		}
+
+		l.Infow("updated queue configuration", "configuration", config)
+
 		s.ps.Pub(WS("REFRESH", nil), QueueTopicGeneric(q.ID))
 
+		if pending != nil {
+			s.ps.Pub(WS("APPROVAL_REMOVAL_PENDING", pending), QueueTopicAdmin(q.ID))
+			return s.sendResponse(http.StatusAccepted, pending, w, r)
+		}
+
 		return s.sendResponse(http.StatusNoContent, nil, w, r)
 	}
 }
@@ -1229,7 +1647,10 @@ type updateQueueOpenStatus interface {
 
 func (s *Server) UpdateQueueOpenStatus(uo updateQueueOpenStatus) E {
 	return func(w http.ResponseWriter, r *http.Request) error {
-		q := r.Context().Value(queueContextKey).(*Queue)
+		q, ok := r.Context().Value(queueContextKey).(*Queue)
+		if !ok {
+			return s.missingContextValue(r, queueContextKey)
+		}
 
 		var open bool
 		switch r.URL.Query().Get("open") {
@@ -1276,8 +1697,14 @@ type sendMessage interface {
 
 func (s *Server) SendMessage(sm sendMessage) E {
 	return func(w http.ResponseWriter, r *http.Request) error {
-		q := r.Context().Value(queueContextKey).(*Queue)
-		email := r.Context().Value(emailContextKey).(string)
+		q, ok := r.Context().Value(queueContextKey).(*Queue)
+		if !ok {
+			return s.missingContextValue(r, queueContextKey)
+		}
+		email, ok := r.Context().Value(emailContextKey).(string)
+		if !ok {
+			return s.missingContextValue(r, emailContextKey)
+		}
 		l := s.logger.With(
 			RequestIDContextKey, r.Context().Value(RequestIDContextKey),
 			"queue_id", q.ID,
@@ -1326,7 +1753,10 @@ type getQueueRoster interface {
 
 func (s *Server) GetQueueRoster(gr getQueueRoster) E {
 	return func(w http.ResponseWriter, r *http.Request) error {
-		q := r.Context().Value(queueContextKey).(*Queue)
+		q, ok := r.Context().Value(queueContextKey).(*Queue)
+		if !ok {
+			return s.missingContextValue(r, queueContextKey)
+		}
 
 		roster, err := gr.GetQueueRoster(r.Context(), q.ID)
 		if err != nil {
@@ -1348,7 +1778,10 @@ type getQueueGroups interface {
 
 func (s *Server) GetQueueGroups(gg getQueueGroups) E {
 	return func(w http.ResponseWriter, r *http.Request) error {
-		q := r.Context().Value(queueContextKey).(*Queue)
+		q, ok := r.Context().Value(queueContextKey).(*Queue)
+		if !ok {
+			return s.missingContextValue(r, queueContextKey)
+		}
 
 		groups, err := gg.GetQueueGroups(r.Context(), q.ID)
 		if err != nil {
@@ -1371,7 +1804,10 @@ type updateQueueGroups interface {
 
 func (s *Server) UpdateQueueGroups(ug updateQueueGroups) E {
 	return func(w http.ResponseWriter, r *http.Request) error {
-		q := r.Context().Value(queueContextKey).(*Queue)
+		q, ok := r.Context().Value(queueContextKey).(*Queue)
+		if !ok {
+			return s.missingContextValue(r, queueContextKey)
+		}
 
 		var groups [][]string
 		err := json.NewDecoder(r.Body).Decode(&groups)
@@ -1423,10 +1859,150 @@ func (s *Server) UpdateQueueGroups(ug updateQueueGroups) E {
 	}
 }
 
+// Rosters are a lot bigger than anything else people send us, so they get
+// their own limit.
+const maxRosterCSVSize = 4 << 20
+
+type importRosterCSV interface {
+	UpdateQueueRoster(ctx context.Context, queue ksuid.KSUID, students []string) error
+}
+
+// ImportRosterCSV replaces the queue's roster with the emails in an
+// uploaded CSV, either as the request body or as the "file" field of a
+// form. The first column is the email; anything after it (like a name) is
+// allowed so exports can be uploaded as-is, but isn't kept. Rows that
+// don't hold a valid email are reported back and skipped rather than
+// failing the whole import.
+func (s *Server) ImportRosterCSV(ir importRosterCSV) E {
+	return func(w http.ResponseWriter, r *http.Request) error {
+		q, ok := r.Context().Value(queueContextKey).(*Queue)
+		if !ok {
+			return s.missingContextValue(r, queueContextKey)
+		}
+		l := s.logger.With(
+			RequestIDContextKey, r.Context().Value(RequestIDContextKey),
+			"queue_id", q.ID,
+			"email", r.Context().Value(emailContextKey),
+		)
+
+		r.Body = http.MaxBytesReader(w, r.Body, maxRosterCSVSize)
+		var body io.Reader = r.Body
+		if strings.HasPrefix(r.Header.Get("Content-Type"), "multipart/form-data") {
+			file, _, err := r.FormFile("file")
+			if err != nil {
+				l.Warnw("failed to get roster file from form", "err", err)
+				return StatusError{
+					http.StatusBadRequest,
+					"I couldn't find a roster in that upload. Make sure the file is in the \"file\" field.",
+				}
+			}
+			defer file.Close()
+			body = file
+		}
+
+		reader := csv.NewReader(body)
+		reader.FieldsPerRecord = -1
+		reader.TrimLeadingSpace = true
+
+		var result RosterImport
+		seen := make(map[string]bool)
+		for row := 1; ; row++ {
+			record, err := reader.Read()
+			if err == io.EOF {
+				break
+			}
+
+			var tooLarge *http.MaxBytesError
+			if errors.As(err, &tooLarge) {
+				l.Warnw("roster upload too large")
+				return errBodyTooLarge
+			}
+
+			var parseErr *csv.ParseError
+			if errors.As(err, &parseErr) {
+				result.Errors = append(result.Errors, &RosterImportError{
+					Row:   row,
+					Error: "This row isn't valid CSV.",
+				})
+				continue
+			} else if err != nil {
+				l.Warnw("failed to read roster upload", "err", err)
+				return StatusError{
+					http.StatusBadRequest,
+					fmt.Sprintf("I couldn't read the roster you uploaded. This error might help: %v", err),
+				}
+			}
+
+			email := strings.TrimSpace(record[0])
+			if row == 1 && strings.EqualFold(email, "email") {
+				// Header row.
+				continue
+			}
+
+			if email == "" {
+				result.Errors = append(result.Errors, &RosterImportError{
+					Row:   row,
+					Error: "This row doesn't have an email.",
+				})
+				continue
+			}
+
+			addr, err := mail.ParseAddress(email)
+			if err != nil || addr.Address != email {
+				result.Errors = append(result.Errors, &RosterImportError{
+					Row:   row,
+					Value: email,
+					Error: "This doesn't look like an email.",
+				})
+				continue
+			}
+
+			if seen[email] {
+				result.Errors = append(result.Errors, &RosterImportError{
+					Row:   row,
+					Value: email,
+					Error: "This email is already in the roster further up.",
+				})
+				continue
+			}
+			seen[email] = true
+			result.Students = append(result.Students, email)
+		}
+
+		// An upload that's all errors is much more likely to be the wrong
+		// file than a request to empty the roster.
+		if len(result.Students) == 0 {
+			l.Warnw("got roster upload without any valid emails", "errors", len(result.Errors))
+			return StatusError{
+				http.StatusBadRequest,
+				"I couldn't find any emails in the roster you uploaded.",
+			}
+		}
+
+		err := ir.UpdateQueueRoster(r.Context(), q.ID, result.Students)
+		if err != nil {
+			l.Errorw("failed to update roster", "err", err)
+			return err
+		}
+
+		l.Infow("imported roster",
+			"students", len(result.Students),
+			"errors", len(result.Errors),
+		)
+		return s.sendResponse(http.StatusOK, &result, w, r)
+	}
+}
+
 func (s *Server) GetQueueLogs() E {
 	return func(w http.ResponseWriter, r *http.Request) error {
-		q := r.Context().Value(queueContextKey).(*Queue)
-		email := r.Context().Value(emailContextKey).(string)
+		q, ok := r.Context().Value(queueContextKey).(*Queue)
+		if !ok {
+			return s.missingContextValue(r, queueContextKey)
+		}
+		email, ok := r.Context().Value(emailContextKey).(string)
+		if !ok {
+			return s.missingContextValue(r, emailContextKey)
+		}
 		l := s.logger.With(
 			RequestIDContextKey, r.Context().Value(RequestIDContextKey),
 			"queue_id", q.ID,
@@ -1468,9 +2044,15 @@ type setNotHelped interface {
 
 func (s *Server) SetNotHelped(sh setNotHelped) E {
 	return func(w http.ResponseWriter, r *http.Request) error {
-		q := r.Context().Value(queueContextKey).(*Queue)
+		q, ok := r.Context().Value(queueContextKey).(*Queue)
+		if !ok {
+			return s.missingContextValue(r, queueContextKey)
+		}
 		id := chi.URLParam(r, "entry_id")
-		email := r.Context().Value(emailContextKey).(string)
+		email, ok := r.Context().Value(emailContextKey).(string)
+		if !ok {
+			return s.missingContextValue(r, emailContextKey)
+		}
 		l := s.logger.With(
 			RequestIDContextKey, r.Context().Value(RequestIDContextKey),
 			"entry_id", id,
@@ -1523,9 +2105,15 @@ type setAway interface {
 // SetAway updates the status of a student in the queue
 func (s *Server) SetAway(sh setAway) E {
 	return func(w http.ResponseWriter, r *http.Request) error {
-		q := r.Context().Value(queueContextKey).(*Queue)
+		q, ok := r.Context().Value(queueContextKey).(*Queue)
+		if !ok {
+			return s.missingContextValue(r, queueContextKey)
+		}
 		id := chi.URLParam(r, "entry_id")
-		email := r.Context().Value(emailContextKey).(string)
+		email, ok := r.Context().Value(emailContextKey).(string)
+		if !ok {
+			return s.missingContextValue(r, emailContextKey)
+		}
 		l := s.logger.With(
 			RequestIDContextKey, r.Context().Value(RequestIDContextKey),
 			"entry_id", id,
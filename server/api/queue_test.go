@@ -0,0 +1,308 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// adminRequest builds a request from a queue admin of q.
+func adminRequest(method string, body interface{}, q *Queue, email string) *http.Request {
+	encoded, _ := json.Marshal(body)
+	r, _ := newTestRequest(method, "/", strings.NewReader(string(encoded)), map[string]interface{}{
+		queueContextKey:       q,
+		emailContextKey:       email,
+		courseAdminContextKey: true,
+	})
+	return r
+}
+
+// proposeApprovalRemoval has proposer turn off schedule approval through
+// the appointment settings.
+func proposeApprovalRemoval(t *testing.T, s *Server, store *fakeStore, q *Queue, proposer string) {
+	t.Helper()
+	w := httptest.NewRecorder()
+	s.UpdateAppointmentSettings(store).ServeHTTP(w, adminRequest(http.MethodPut, AppointmentSettings{}, q, proposer))
+	if w.Code != http.StatusAccepted {
+		t.Fatalf("got status %d, want %d: %s", w.Code, http.StatusAccepted, w.Body.String())
+	}
+}
+
+func TestTurningOffApprovalNeedsApproval(t *testing.T) {
+	s := newTestServer()
+	store := newFakeStore()
+	q := store.addQueue(&QueueConfiguration{AppointmentSettings: AppointmentSettings{RequireScheduleApproval: true}})
+
+	proposeApprovalRemoval(t, s, store, q, "first@example.com")
+	if !store.configs[q.ID].RequireScheduleApproval {
+		t.Error("schedule approval turned off without a second admin")
+	}
+	pending, ok := store.approvalRemovals[q.ID]
+	if !ok {
+		t.Fatal("no pending approval removal")
+	}
+	if pending.ProposedBy != "first@example.com" {
+		t.Errorf("got proposer %s, want first@example.com", pending.ProposedBy)
+	}
+
+	// The full configuration endpoint can't get around it either.
+	delete(store.approvalRemovals, q.ID)
+	w := httptest.NewRecorder()
+	s.UpdateQueueConfiguration(store).ServeHTTP(w, adminRequest(http.MethodPut, QueueConfiguration{}, q, "first@example.com"))
+	if w.Code != http.StatusAccepted {
+		t.Fatalf("got status %d, want %d: %s", w.Code, http.StatusAccepted, w.Body.String())
+	}
+	if !store.configs[q.ID].RequireScheduleApproval {
+		t.Error("schedule approval turned off through queue configuration")
+	}
+	if _, ok := store.approvalRemovals[q.ID]; !ok {
+		t.Error("no pending approval removal from queue configuration")
+	}
+}
+
+func TestApproveApprovalRemoval(t *testing.T) {
+	s := newTestServer()
+	store := newFakeStore()
+	q := store.addQueue(&QueueConfiguration{AppointmentSettings: AppointmentSettings{RequireScheduleApproval: true}})
+	proposeApprovalRemoval(t, s, store, q, "first@example.com")
+
+	w := httptest.NewRecorder()
+	s.ApproveApprovalRemoval(store).ServeHTTP(w, adminRequest(http.MethodPost, nil, q, "second@example.com"))
+	if w.Code != http.StatusNoContent {
+		t.Fatalf("got status %d, want %d: %s", w.Code, http.StatusNoContent, w.Body.String())
+	}
+	if store.configs[q.ID].RequireScheduleApproval {
+		t.Error("schedule approval still on after approval")
+	}
+	if _, ok := store.approvalRemovals[q.ID]; ok {
+		t.Error("pending approval removal left after approval")
+	}
+}
+
+func TestApproveOwnApprovalRemoval(t *testing.T) {
+	s := newTestServer()
+	store := newFakeStore()
+	q := store.addQueue(&QueueConfiguration{AppointmentSettings: AppointmentSettings{RequireScheduleApproval: true}})
+	proposeApprovalRemoval(t, s, store, q, "first@example.com")
+
+	w := httptest.NewRecorder()
+	s.ApproveApprovalRemoval(store).ServeHTTP(w, adminRequest(http.MethodPost, nil, q, "first@example.com"))
+	if w.Code != http.StatusForbidden {
+		t.Errorf("got status %d, want %d", w.Code, http.StatusForbidden)
+	}
+	if !store.configs[q.ID].RequireScheduleApproval {
+		t.Error("schedule approval turned off by its proposer")
+	}
+	if _, ok := store.approvalRemovals[q.ID]; !ok {
+		t.Error("pending approval removal dropped after self-approval")
+	}
+}
+
+func rosterImportRequest(s *Server, store *fakeStore, q *Queue, csv string) *httptest.ResponseRecorder {
+	r, _ := newTestRequest(http.MethodPut, "/", strings.NewReader(csv), map[string]interface{}{
+		queueContextKey:       q,
+		emailContextKey:       "admin@example.com",
+		courseAdminContextKey: true,
+	})
+	r.Header.Set("Content-Type", "text/csv")
+	w := httptest.NewRecorder()
+	s.ImportRosterCSV(store).ServeHTTP(w, r)
+	return w
+}
+
+func TestImportRosterCSV(t *testing.T) {
+	s := newTestServer()
+	store := newFakeStore()
+	q := store.addQueue(&QueueConfiguration{})
+
+	w := rosterImportRequest(s, store, q, "Email,Name\nfirst@example.com,First Student\n second@example.com , Second Student\nthird@example.com\n")
+	if w.Code != http.StatusOK {
+		t.Fatalf("got status %d, want %d: %s", w.Code, http.StatusOK, w.Body.String())
+	}
+	var result RosterImport
+	err := json.NewDecoder(w.Body).Decode(&result)
+	if err != nil {
+		t.Fatalf("failed to decode response: %v", err)
+	}
+	if len(result.Errors) != 0 {
+		t.Errorf("got errors %+v, want none", result.Errors)
+	}
+
+	want := map[string]bool{"first@example.com": true, "second@example.com": true, "third@example.com": true}
+	if !reflect.DeepEqual(store.rosters[q.ID], want) {
+		t.Errorf("got roster %v, want %v", store.rosters[q.ID], want)
+	}
+}
+
+func TestImportRosterCSVMalformedRows(t *testing.T) {
+	s := newTestServer()
+	store := newFakeStore()
+	q := store.addQueue(&QueueConfiguration{})
+
+	csv := strings.Join([]string{
+		"first@example.com,First",
+		"not an email,Someone",
+		",No Email",
+		`bad"quote@example.com,Quoted`,
+		"first@example.com,First Again",
+		"Second <second@example.com>",
+		"third@example.com",
+	}, "\n")
+	w := rosterImportRequest(s, store, q, csv)
+	if w.Code != http.StatusOK {
+		t.Fatalf("got status %d, want %d: %s", w.Code, http.StatusOK, w.Body.String())
+	}
+	var result RosterImport
+	err := json.NewDecoder(w.Body).Decode(&result)
+	if err != nil {
+		t.Fatalf("failed to decode response: %v", err)
+	}
+
+	// Bad rows are reported and skipped; the rest still go in.
+	if want := []string{"first@example.com", "third@example.com"}; !reflect.DeepEqual(result.Students, want) {
+		t.Errorf("got students %v, want %v", result.Students, want)
+	}
+	var rows []int
+	for _, e := range result.Errors {
+		rows = append(rows, e.Row)
+		if e.Error == "" {
+			t.Errorf("got no reason for row %d", e.Row)
+		}
+	}
+	if want := []int{2, 3, 4, 5, 6}; !reflect.DeepEqual(rows, want) {
+		t.Errorf("got errors on rows %v, want %v", rows, want)
+	}
+	if want := map[string]bool{"first@example.com": true, "third@example.com": true}; !reflect.DeepEqual(store.rosters[q.ID], want) {
+		t.Errorf("got roster %v, want %v", store.rosters[q.ID], want)
+	}
+
+	// Nothing usable at all looks like the wrong file, so the roster
+	// stays as it was.
+	w = rosterImportRequest(s, store, q, "not an email\nstill not\n")
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("got status %d for roster without emails, want %d", w.Code, http.StatusBadRequest)
+	}
+	if len(store.rosters[q.ID]) != 2 {
+		t.Errorf("got roster %v after failed import, want it unchanged", store.rosters[q.ID])
+	}
+}
+
+func TestAppointmentSettingsRoundTrip(t *testing.T) {
+	s := newTestServer()
+	store := newFakeStore()
+	q := store.addQueue(&QueueConfiguration{Cooldown: 15, Virtual: true})
+
+	settings := AppointmentSettings{
+		FutureAppointmentScope:          FutureAppointmentScopeDay,
+		AvailabilityDisplay:             AvailabilityDisplayCoarse,
+		MaxConcurrentAppointments:       3,
+		ShowTimeslotMembers:             true,
+		MaxAppointmentFieldLength:       200,
+		MinAppointmentDescriptionLength: 10,
+		ClaimGraceMinutes:               5,
+		DescriptionTemplate:             "What have you tried:\n",
+		RequireDescriptionTemplate:      true,
+	}
+	w := httptest.NewRecorder()
+	s.UpdateAppointmentSettings(store).ServeHTTP(w, adminRequest(http.MethodPut, settings, q, "admin@example.com"))
+	if w.Code != http.StatusOK {
+		t.Fatalf("got status %d, want %d: %s", w.Code, http.StatusOK, w.Body.String())
+	}
+
+	// Anything left out gets its default.
+	settings.AppointmentLocationType = AppointmentLocationInPerson
+	w = httptest.NewRecorder()
+	s.GetAppointmentSettings(store).ServeHTTP(w, adminRequest(http.MethodGet, nil, q, "admin@example.com"))
+	if w.Code != http.StatusOK {
+		t.Fatalf("got status %d, want %d: %s", w.Code, http.StatusOK, w.Body.String())
+	}
+	var got AppointmentSettings
+	err := json.NewDecoder(w.Body).Decode(&got)
+	if err != nil {
+		t.Fatalf("failed to decode response: %v", err)
+	}
+	if !reflect.DeepEqual(got, settings) {
+		t.Errorf("got settings %+v, want %+v", got, settings)
+	}
+
+	// The rest of the configuration is left alone.
+	if config := store.configs[q.ID]; config.Cooldown != 15 || !config.Virtual {
+		t.Errorf("got cooldown %d and virtual %t, want 15 and true", config.Cooldown, config.Virtual)
+	}
+}
+
+func TestAppointmentSettingsValidation(t *testing.T) {
+	tests := []struct {
+		name     string
+		settings AppointmentSettings
+	}{
+		{"unknown scope", AppointmentSettings{FutureAppointmentScope: "week"}},
+		{"unknown location type", AppointmentSettings{AppointmentLocationType: "moon"}},
+		{"unknown availability display", AppointmentSettings{AvailabilityDisplay: "vague"}},
+		{"negative max concurrent", AppointmentSettings{MaxConcurrentAppointments: -1}},
+		{"negative max field length", AppointmentSettings{MaxAppointmentFieldLength: -1}},
+		{"negative min description length", AppointmentSettings{MinAppointmentDescriptionLength: -1}},
+		{"min over max", AppointmentSettings{MinAppointmentDescriptionLength: 20, MaxAppointmentFieldLength: 10}},
+		{"negative claim grace", AppointmentSettings{ClaimGraceMinutes: -5}},
+		{"required template without sections", AppointmentSettings{DescriptionTemplate: "Be nice", RequireDescriptionTemplate: true}},
+	}
+	for _, test := range tests {
+		s := newTestServer()
+		store := newFakeStore()
+		q := store.addQueue(&QueueConfiguration{AppointmentSettings: AppointmentSettings{MaxConcurrentAppointments: 2}})
+
+		w := httptest.NewRecorder()
+		s.UpdateAppointmentSettings(store).ServeHTTP(w, adminRequest(http.MethodPut, test.settings, q, "admin@example.com"))
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("%s: got status %d, want %d", test.name, w.Code, http.StatusBadRequest)
+		}
+		if store.configs[q.ID].MaxConcurrentAppointments != 2 {
+			t.Errorf("%s: settings changed despite being invalid", test.name)
+		}
+	}
+}
+
+func TestAppointmentSettingsTemplateFitsMaxLength(t *testing.T) {
+	s := newTestServer()
+	store := newFakeStore()
+	q := store.addQueue(&QueueConfiguration{})
+
+	// The shortest description filling in both sections is "What:x\nWhy:y".
+	settings := AppointmentSettings{
+		DescriptionTemplate:        "What:\nWhy:\n",
+		RequireDescriptionTemplate: true,
+		MaxAppointmentFieldLength:  11,
+	}
+	w := httptest.NewRecorder()
+	s.UpdateAppointmentSettings(store).ServeHTTP(w, adminRequest(http.MethodPut, settings, q, "admin@example.com"))
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("got status %d with no way to fill in the template, want %d", w.Code, http.StatusBadRequest)
+	}
+
+	// Either one on its own is fine.
+	for _, settings := range []AppointmentSettings{
+		{DescriptionTemplate: "What:\nWhy:\n", RequireDescriptionTemplate: true},
+		{DescriptionTemplate: "What:\nWhy:\n", MaxAppointmentFieldLength: 11},
+		{DescriptionTemplate: "What:\nWhy:\n", RequireDescriptionTemplate: true, MaxAppointmentFieldLength: 12},
+	} {
+		w = httptest.NewRecorder()
+		s.UpdateAppointmentSettings(store).ServeHTTP(w, adminRequest(http.MethodPut, settings, q, "admin@example.com"))
+		if w.Code != http.StatusOK {
+			t.Errorf("got status %d for %+v, want %d: %s", w.Code, settings, http.StatusOK, w.Body.String())
+		}
+	}
+
+	// And with just enough room, students really can sign up.
+	tomorrow := int(time.Now().Local().Add(24 * time.Hour).Weekday())
+	w = signupBodyRequest(s, store, q, tomorrow, "student@example.com", 10, map[string]interface{}{
+		"location":    "Here",
+		"description": "What:x\nWhy:y",
+	})
+	if w.Code != http.StatusCreated {
+		t.Errorf("got status %d signing up, want %d: %s", w.Code, http.StatusCreated, w.Body.String())
+	}
+}
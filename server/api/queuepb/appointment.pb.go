@@ -0,0 +1,445 @@
+// Protobuf encoding of appointments, for clients that send
+// Accept: application/x-protobuf. This mirrors AppointmentSlot's JSON.
+// The Go types in queuepb are generated from this file (go generate in
+// the api package); protobuf.go fills them in from AppointmentSlot.
+
+// Code generated by protoc-gen-go. DO NOT EDIT.
+// versions:
+// 	protoc-gen-go v1.27.1
+// 	protoc        (unknown)
+// source: appointment.proto
+
+package queuepb
+
+import (
+	protoreflect "google.golang.org/protobuf/reflect/protoreflect"
+	protoimpl "google.golang.org/protobuf/runtime/protoimpl"
+	structpb "google.golang.org/protobuf/types/known/structpb"
+	timestamppb "google.golang.org/protobuf/types/known/timestamppb"
+	reflect "reflect"
+	sync "sync"
+)
+
+const (
+	// Verify that this generated code is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(20 - protoimpl.MinVersion)
+	// Verify that runtime/protoimpl is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(protoimpl.MaxVersion - 20)
+)
+
+type AppointmentSlot struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	Id               string                 `protobuf:"bytes,1,opt,name=id,proto3" json:"id,omitempty"`
+	Queue            string                 `protobuf:"bytes,2,opt,name=queue,proto3" json:"queue,omitempty"`
+	StaffEmail       *string                `protobuf:"bytes,3,opt,name=staff_email,json=staffEmail,proto3,oneof" json:"staff_email,omitempty"`
+	StudentEmail     *string                `protobuf:"bytes,4,opt,name=student_email,json=studentEmail,proto3,oneof" json:"student_email,omitempty"`
+	ScheduledTime    *timestamppb.Timestamp `protobuf:"bytes,5,opt,name=scheduled_time,json=scheduledTime,proto3" json:"scheduled_time,omitempty"`
+	Timeslot         int32                  `protobuf:"varint,6,opt,name=timeslot,proto3" json:"timeslot,omitempty"`
+	Duration         int32                  `protobuf:"varint,7,opt,name=duration,proto3" json:"duration,omitempty"`
+	Name             *string                `protobuf:"bytes,8,opt,name=name,proto3,oneof" json:"name,omitempty"`
+	Location         *string                `protobuf:"bytes,9,opt,name=location,proto3,oneof" json:"location,omitempty"`
+	Description      *string                `protobuf:"bytes,10,opt,name=description,proto3,oneof" json:"description,omitempty"`
+	MapX             *float32               `protobuf:"fixed32,11,opt,name=map_x,json=mapX,proto3,oneof" json:"map_x,omitempty"`
+	MapY             *float32               `protobuf:"fixed32,12,opt,name=map_y,json=mapY,proto3,oneof" json:"map_y,omitempty"`
+	UpdatedAt        *timestamppb.Timestamp `protobuf:"bytes,13,opt,name=updated_at,json=updatedAt,proto3" json:"updated_at,omitempty"`
+	MeetingLink      *string                `protobuf:"bytes,14,opt,name=meeting_link,json=meetingLink,proto3,oneof" json:"meeting_link,omitempty"`
+	AnonymousToPeers bool                   `protobuf:"varint,15,opt,name=anonymous_to_peers,json=anonymousToPeers,proto3" json:"anonymous_to_peers,omitempty"`
+	Partners         []string               `protobuf:"bytes,16,rep,name=partners,proto3" json:"partners,omitempty"`
+	Labels           []string               `protobuf:"bytes,17,rep,name=labels,proto3" json:"labels,omitempty"`
+	// Day of the week (0 is Sunday) in the server's time zone.
+	Day int32 `protobuf:"varint,18,opt,name=day,proto3" json:"day,omitempty"`
+	// Number of consecutive timeslots the appointment takes up.
+	SlotSpan int32 `protobuf:"varint,19,opt,name=slot_span,json=slotSpan,proto3" json:"slot_span,omitempty"`
+	// Only set for staff; students don't see whether they were resolved.
+	Resolved *bool `protobuf:"varint,20,opt,name=resolved,proto3,oneof" json:"resolved,omitempty"`
+	// Answers to the queue's custom signup fields, keyed by field.
+	CustomFields *structpb.Struct `protobuf:"bytes,21,opt,name=custom_fields,json=customFields,proto3" json:"custom_fields,omitempty"`
+}
+
+func (x *AppointmentSlot) Reset() {
+	*x = AppointmentSlot{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_appointment_proto_msgTypes[0]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *AppointmentSlot) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*AppointmentSlot) ProtoMessage() {}
+
+func (x *AppointmentSlot) ProtoReflect() protoreflect.Message {
+	mi := &file_appointment_proto_msgTypes[0]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use AppointmentSlot.ProtoReflect.Descriptor instead.
+func (*AppointmentSlot) Descriptor() ([]byte, []int) {
+	return file_appointment_proto_rawDescGZIP(), []int{0}
+}
+
+func (x *AppointmentSlot) GetId() string {
+	if x != nil {
+		return x.Id
+	}
+	return ""
+}
+
+func (x *AppointmentSlot) GetQueue() string {
+	if x != nil {
+		return x.Queue
+	}
+	return ""
+}
+
+func (x *AppointmentSlot) GetStaffEmail() string {
+	if x != nil && x.StaffEmail != nil {
+		return *x.StaffEmail
+	}
+	return ""
+}
+
+func (x *AppointmentSlot) GetStudentEmail() string {
+	if x != nil && x.StudentEmail != nil {
+		return *x.StudentEmail
+	}
+	return ""
+}
+
+func (x *AppointmentSlot) GetScheduledTime() *timestamppb.Timestamp {
+	if x != nil {
+		return x.ScheduledTime
+	}
+	return nil
+}
+
+func (x *AppointmentSlot) GetTimeslot() int32 {
+	if x != nil {
+		return x.Timeslot
+	}
+	return 0
+}
+
+func (x *AppointmentSlot) GetDuration() int32 {
+	if x != nil {
+		return x.Duration
+	}
+	return 0
+}
+
+func (x *AppointmentSlot) GetName() string {
+	if x != nil && x.Name != nil {
+		return *x.Name
+	}
+	return ""
+}
+
+func (x *AppointmentSlot) GetLocation() string {
+	if x != nil && x.Location != nil {
+		return *x.Location
+	}
+	return ""
+}
+
+func (x *AppointmentSlot) GetDescription() string {
+	if x != nil && x.Description != nil {
+		return *x.Description
+	}
+	return ""
+}
+
+func (x *AppointmentSlot) GetMapX() float32 {
+	if x != nil && x.MapX != nil {
+		return *x.MapX
+	}
+	return 0
+}
+
+func (x *AppointmentSlot) GetMapY() float32 {
+	if x != nil && x.MapY != nil {
+		return *x.MapY
+	}
+	return 0
+}
+
+func (x *AppointmentSlot) GetUpdatedAt() *timestamppb.Timestamp {
+	if x != nil {
+		return x.UpdatedAt
+	}
+	return nil
+}
+
+func (x *AppointmentSlot) GetMeetingLink() string {
+	if x != nil && x.MeetingLink != nil {
+		return *x.MeetingLink
+	}
+	return ""
+}
+
+func (x *AppointmentSlot) GetAnonymousToPeers() bool {
+	if x != nil {
+		return x.AnonymousToPeers
+	}
+	return false
+}
+
+func (x *AppointmentSlot) GetPartners() []string {
+	if x != nil {
+		return x.Partners
+	}
+	return nil
+}
+
+func (x *AppointmentSlot) GetLabels() []string {
+	if x != nil {
+		return x.Labels
+	}
+	return nil
+}
+
+func (x *AppointmentSlot) GetDay() int32 {
+	if x != nil {
+		return x.Day
+	}
+	return 0
+}
+
+func (x *AppointmentSlot) GetSlotSpan() int32 {
+	if x != nil {
+		return x.SlotSpan
+	}
+	return 0
+}
+
+func (x *AppointmentSlot) GetResolved() bool {
+	if x != nil && x.Resolved != nil {
+		return *x.Resolved
+	}
+	return false
+}
+
+func (x *AppointmentSlot) GetCustomFields() *structpb.Struct {
+	if x != nil {
+		return x.CustomFields
+	}
+	return nil
+}
+
+// Sent for endpoints that return more than one appointment.
+type AppointmentSlotList struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	Appointments []*AppointmentSlot `protobuf:"bytes,1,rep,name=appointments,proto3" json:"appointments,omitempty"`
+}
+
+func (x *AppointmentSlotList) Reset() {
+	*x = AppointmentSlotList{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_appointment_proto_msgTypes[1]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *AppointmentSlotList) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*AppointmentSlotList) ProtoMessage() {}
+
+func (x *AppointmentSlotList) ProtoReflect() protoreflect.Message {
+	mi := &file_appointment_proto_msgTypes[1]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use AppointmentSlotList.ProtoReflect.Descriptor instead.
+func (*AppointmentSlotList) Descriptor() ([]byte, []int) {
+	return file_appointment_proto_rawDescGZIP(), []int{1}
+}
+
+func (x *AppointmentSlotList) GetAppointments() []*AppointmentSlot {
+	if x != nil {
+		return x.Appointments
+	}
+	return nil
+}
+
+var File_appointment_proto protoreflect.FileDescriptor
+
+var file_appointment_proto_rawDesc = []byte{
+	0x0a, 0x11, 0x61, 0x70, 0x70, 0x6f, 0x69, 0x6e, 0x74, 0x6d, 0x65, 0x6e, 0x74, 0x2e, 0x70, 0x72,
+	0x6f, 0x74, 0x6f, 0x12, 0x05, 0x71, 0x75, 0x65, 0x75, 0x65, 0x1a, 0x1c, 0x67, 0x6f, 0x6f, 0x67,
+	0x6c, 0x65, 0x2f, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x62, 0x75, 0x66, 0x2f, 0x73, 0x74, 0x72, 0x75,
+	0x63, 0x74, 0x2e, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x1a, 0x1f, 0x67, 0x6f, 0x6f, 0x67, 0x6c, 0x65,
+	0x2f, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x62, 0x75, 0x66, 0x2f, 0x74, 0x69, 0x6d, 0x65, 0x73, 0x74,
+	0x61, 0x6d, 0x70, 0x2e, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x22, 0xe4, 0x06, 0x0a, 0x0f, 0x41, 0x70,
+	0x70, 0x6f, 0x69, 0x6e, 0x74, 0x6d, 0x65, 0x6e, 0x74, 0x53, 0x6c, 0x6f, 0x74, 0x12, 0x0e, 0x0a,
+	0x02, 0x69, 0x64, 0x18, 0x01, 0x20, 0x01, 0x28, 0x09, 0x52, 0x02, 0x69, 0x64, 0x12, 0x14, 0x0a,
+	0x05, 0x71, 0x75, 0x65, 0x75, 0x65, 0x18, 0x02, 0x20, 0x01, 0x28, 0x09, 0x52, 0x05, 0x71, 0x75,
+	0x65, 0x75, 0x65, 0x12, 0x24, 0x0a, 0x0b, 0x73, 0x74, 0x61, 0x66, 0x66, 0x5f, 0x65, 0x6d, 0x61,
+	0x69, 0x6c, 0x18, 0x03, 0x20, 0x01, 0x28, 0x09, 0x48, 0x00, 0x52, 0x0a, 0x73, 0x74, 0x61, 0x66,
+	0x66, 0x45, 0x6d, 0x61, 0x69, 0x6c, 0x88, 0x01, 0x01, 0x12, 0x28, 0x0a, 0x0d, 0x73, 0x74, 0x75,
+	0x64, 0x65, 0x6e, 0x74, 0x5f, 0x65, 0x6d, 0x61, 0x69, 0x6c, 0x18, 0x04, 0x20, 0x01, 0x28, 0x09,
+	0x48, 0x01, 0x52, 0x0c, 0x73, 0x74, 0x75, 0x64, 0x65, 0x6e, 0x74, 0x45, 0x6d, 0x61, 0x69, 0x6c,
+	0x88, 0x01, 0x01, 0x12, 0x41, 0x0a, 0x0e, 0x73, 0x63, 0x68, 0x65, 0x64, 0x75, 0x6c, 0x65, 0x64,
+	0x5f, 0x74, 0x69, 0x6d, 0x65, 0x18, 0x05, 0x20, 0x01, 0x28, 0x0b, 0x32, 0x1a, 0x2e, 0x67, 0x6f,
+	0x6f, 0x67, 0x6c, 0x65, 0x2e, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x62, 0x75, 0x66, 0x2e, 0x54, 0x69,
+	0x6d, 0x65, 0x73, 0x74, 0x61, 0x6d, 0x70, 0x52, 0x0d, 0x73, 0x63, 0x68, 0x65, 0x64, 0x75, 0x6c,
+	0x65, 0x64, 0x54, 0x69, 0x6d, 0x65, 0x12, 0x1a, 0x0a, 0x08, 0x74, 0x69, 0x6d, 0x65, 0x73, 0x6c,
+	0x6f, 0x74, 0x18, 0x06, 0x20, 0x01, 0x28, 0x05, 0x52, 0x08, 0x74, 0x69, 0x6d, 0x65, 0x73, 0x6c,
+	0x6f, 0x74, 0x12, 0x1a, 0x0a, 0x08, 0x64, 0x75, 0x72, 0x61, 0x74, 0x69, 0x6f, 0x6e, 0x18, 0x07,
+	0x20, 0x01, 0x28, 0x05, 0x52, 0x08, 0x64, 0x75, 0x72, 0x61, 0x74, 0x69, 0x6f, 0x6e, 0x12, 0x17,
+	0x0a, 0x04, 0x6e, 0x61, 0x6d, 0x65, 0x18, 0x08, 0x20, 0x01, 0x28, 0x09, 0x48, 0x02, 0x52, 0x04,
+	0x6e, 0x61, 0x6d, 0x65, 0x88, 0x01, 0x01, 0x12, 0x1f, 0x0a, 0x08, 0x6c, 0x6f, 0x63, 0x61, 0x74,
+	0x69, 0x6f, 0x6e, 0x18, 0x09, 0x20, 0x01, 0x28, 0x09, 0x48, 0x03, 0x52, 0x08, 0x6c, 0x6f, 0x63,
+	0x61, 0x74, 0x69, 0x6f, 0x6e, 0x88, 0x01, 0x01, 0x12, 0x25, 0x0a, 0x0b, 0x64, 0x65, 0x73, 0x63,
+	0x72, 0x69, 0x70, 0x74, 0x69, 0x6f, 0x6e, 0x18, 0x0a, 0x20, 0x01, 0x28, 0x09, 0x48, 0x04, 0x52,
+	0x0b, 0x64, 0x65, 0x73, 0x63, 0x72, 0x69, 0x70, 0x74, 0x69, 0x6f, 0x6e, 0x88, 0x01, 0x01, 0x12,
+	0x18, 0x0a, 0x05, 0x6d, 0x61, 0x70, 0x5f, 0x78, 0x18, 0x0b, 0x20, 0x01, 0x28, 0x02, 0x48, 0x05,
+	0x52, 0x04, 0x6d, 0x61, 0x70, 0x58, 0x88, 0x01, 0x01, 0x12, 0x18, 0x0a, 0x05, 0x6d, 0x61, 0x70,
+	0x5f, 0x79, 0x18, 0x0c, 0x20, 0x01, 0x28, 0x02, 0x48, 0x06, 0x52, 0x04, 0x6d, 0x61, 0x70, 0x59,
+	0x88, 0x01, 0x01, 0x12, 0x39, 0x0a, 0x0a, 0x75, 0x70, 0x64, 0x61, 0x74, 0x65, 0x64, 0x5f, 0x61,
+	0x74, 0x18, 0x0d, 0x20, 0x01, 0x28, 0x0b, 0x32, 0x1a, 0x2e, 0x67, 0x6f, 0x6f, 0x67, 0x6c, 0x65,
+	0x2e, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x62, 0x75, 0x66, 0x2e, 0x54, 0x69, 0x6d, 0x65, 0x73, 0x74,
+	0x61, 0x6d, 0x70, 0x52, 0x09, 0x75, 0x70, 0x64, 0x61, 0x74, 0x65, 0x64, 0x41, 0x74, 0x12, 0x26,
+	0x0a, 0x0c, 0x6d, 0x65, 0x65, 0x74, 0x69, 0x6e, 0x67, 0x5f, 0x6c, 0x69, 0x6e, 0x6b, 0x18, 0x0e,
+	0x20, 0x01, 0x28, 0x09, 0x48, 0x07, 0x52, 0x0b, 0x6d, 0x65, 0x65, 0x74, 0x69, 0x6e, 0x67, 0x4c,
+	0x69, 0x6e, 0x6b, 0x88, 0x01, 0x01, 0x12, 0x2c, 0x0a, 0x12, 0x61, 0x6e, 0x6f, 0x6e, 0x79, 0x6d,
+	0x6f, 0x75, 0x73, 0x5f, 0x74, 0x6f, 0x5f, 0x70, 0x65, 0x65, 0x72, 0x73, 0x18, 0x0f, 0x20, 0x01,
+	0x28, 0x08, 0x52, 0x10, 0x61, 0x6e, 0x6f, 0x6e, 0x79, 0x6d, 0x6f, 0x75, 0x73, 0x54, 0x6f, 0x50,
+	0x65, 0x65, 0x72, 0x73, 0x12, 0x1a, 0x0a, 0x08, 0x70, 0x61, 0x72, 0x74, 0x6e, 0x65, 0x72, 0x73,
+	0x18, 0x10, 0x20, 0x03, 0x28, 0x09, 0x52, 0x08, 0x70, 0x61, 0x72, 0x74, 0x6e, 0x65, 0x72, 0x73,
+	0x12, 0x16, 0x0a, 0x06, 0x6c, 0x61, 0x62, 0x65, 0x6c, 0x73, 0x18, 0x11, 0x20, 0x03, 0x28, 0x09,
+	0x52, 0x06, 0x6c, 0x61, 0x62, 0x65, 0x6c, 0x73, 0x12, 0x10, 0x0a, 0x03, 0x64, 0x61, 0x79, 0x18,
+	0x12, 0x20, 0x01, 0x28, 0x05, 0x52, 0x03, 0x64, 0x61, 0x79, 0x12, 0x1b, 0x0a, 0x09, 0x73, 0x6c,
+	0x6f, 0x74, 0x5f, 0x73, 0x70, 0x61, 0x6e, 0x18, 0x13, 0x20, 0x01, 0x28, 0x05, 0x52, 0x08, 0x73,
+	0x6c, 0x6f, 0x74, 0x53, 0x70, 0x61, 0x6e, 0x12, 0x1f, 0x0a, 0x08, 0x72, 0x65, 0x73, 0x6f, 0x6c,
+	0x76, 0x65, 0x64, 0x18, 0x14, 0x20, 0x01, 0x28, 0x08, 0x48, 0x08, 0x52, 0x08, 0x72, 0x65, 0x73,
+	0x6f, 0x6c, 0x76, 0x65, 0x64, 0x88, 0x01, 0x01, 0x12, 0x3c, 0x0a, 0x0d, 0x63, 0x75, 0x73, 0x74,
+	0x6f, 0x6d, 0x5f, 0x66, 0x69, 0x65, 0x6c, 0x64, 0x73, 0x18, 0x15, 0x20, 0x01, 0x28, 0x0b, 0x32,
+	0x17, 0x2e, 0x67, 0x6f, 0x6f, 0x67, 0x6c, 0x65, 0x2e, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x62, 0x75,
+	0x66, 0x2e, 0x53, 0x74, 0x72, 0x75, 0x63, 0x74, 0x52, 0x0c, 0x63, 0x75, 0x73, 0x74, 0x6f, 0x6d,
+	0x46, 0x69, 0x65, 0x6c, 0x64, 0x73, 0x42, 0x0e, 0x0a, 0x0c, 0x5f, 0x73, 0x74, 0x61, 0x66, 0x66,
+	0x5f, 0x65, 0x6d, 0x61, 0x69, 0x6c, 0x42, 0x10, 0x0a, 0x0e, 0x5f, 0x73, 0x74, 0x75, 0x64, 0x65,
+	0x6e, 0x74, 0x5f, 0x65, 0x6d, 0x61, 0x69, 0x6c, 0x42, 0x07, 0x0a, 0x05, 0x5f, 0x6e, 0x61, 0x6d,
+	0x65, 0x42, 0x0b, 0x0a, 0x09, 0x5f, 0x6c, 0x6f, 0x63, 0x61, 0x74, 0x69, 0x6f, 0x6e, 0x42, 0x0e,
+	0x0a, 0x0c, 0x5f, 0x64, 0x65, 0x73, 0x63, 0x72, 0x69, 0x70, 0x74, 0x69, 0x6f, 0x6e, 0x42, 0x08,
+	0x0a, 0x06, 0x5f, 0x6d, 0x61, 0x70, 0x5f, 0x78, 0x42, 0x08, 0x0a, 0x06, 0x5f, 0x6d, 0x61, 0x70,
+	0x5f, 0x79, 0x42, 0x0f, 0x0a, 0x0d, 0x5f, 0x6d, 0x65, 0x65, 0x74, 0x69, 0x6e, 0x67, 0x5f, 0x6c,
+	0x69, 0x6e, 0x6b, 0x42, 0x0b, 0x0a, 0x09, 0x5f, 0x72, 0x65, 0x73, 0x6f, 0x6c, 0x76, 0x65, 0x64,
+	0x22, 0x51, 0x0a, 0x13, 0x41, 0x70, 0x70, 0x6f, 0x69, 0x6e, 0x74, 0x6d, 0x65, 0x6e, 0x74, 0x53,
+	0x6c, 0x6f, 0x74, 0x4c, 0x69, 0x73, 0x74, 0x12, 0x3a, 0x0a, 0x0c, 0x61, 0x70, 0x70, 0x6f, 0x69,
+	0x6e, 0x74, 0x6d, 0x65, 0x6e, 0x74, 0x73, 0x18, 0x01, 0x20, 0x03, 0x28, 0x0b, 0x32, 0x16, 0x2e,
+	0x71, 0x75, 0x65, 0x75, 0x65, 0x2e, 0x41, 0x70, 0x70, 0x6f, 0x69, 0x6e, 0x74, 0x6d, 0x65, 0x6e,
+	0x74, 0x53, 0x6c, 0x6f, 0x74, 0x52, 0x0c, 0x61, 0x70, 0x70, 0x6f, 0x69, 0x6e, 0x74, 0x6d, 0x65,
+	0x6e, 0x74, 0x73, 0x42, 0x40, 0x5a, 0x3e, 0x67, 0x69, 0x74, 0x68, 0x75, 0x62, 0x2e, 0x63, 0x6f,
+	0x6d, 0x2f, 0x43, 0x61, 0x72, 0x73, 0x6f, 0x6e, 0x48, 0x6f, 0x66, 0x66, 0x6d, 0x61, 0x6e, 0x2f,
+	0x6f, 0x66, 0x66, 0x69, 0x63, 0x65, 0x2d, 0x68, 0x6f, 0x75, 0x72, 0x73, 0x2d, 0x71, 0x75, 0x65,
+	0x75, 0x65, 0x2f, 0x73, 0x65, 0x72, 0x76, 0x65, 0x72, 0x2f, 0x61, 0x70, 0x69, 0x2f, 0x71, 0x75,
+	0x65, 0x75, 0x65, 0x70, 0x62, 0x62, 0x06, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x33,
+}
+
+var (
+	file_appointment_proto_rawDescOnce sync.Once
+	file_appointment_proto_rawDescData = file_appointment_proto_rawDesc
+)
+
+func file_appointment_proto_rawDescGZIP() []byte {
+	file_appointment_proto_rawDescOnce.Do(func() {
+		file_appointment_proto_rawDescData = protoimpl.X.CompressGZIP(file_appointment_proto_rawDescData)
+	})
+	return file_appointment_proto_rawDescData
+}
+
+var file_appointment_proto_msgTypes = make([]protoimpl.MessageInfo, 2)
+var file_appointment_proto_goTypes = []interface{}{
+	(*AppointmentSlot)(nil),       // 0: queue.AppointmentSlot
+	(*AppointmentSlotList)(nil),   // 1: queue.AppointmentSlotList
+	(*timestamppb.Timestamp)(nil), // 2: google.protobuf.Timestamp
+	(*structpb.Struct)(nil),       // 3: google.protobuf.Struct
+}
+var file_appointment_proto_depIdxs = []int32{
+	2, // 0: queue.AppointmentSlot.scheduled_time:type_name -> google.protobuf.Timestamp
+	2, // 1: queue.AppointmentSlot.updated_at:type_name -> google.protobuf.Timestamp
+	3, // 2: queue.AppointmentSlot.custom_fields:type_name -> google.protobuf.Struct
+	0, // 3: queue.AppointmentSlotList.appointments:type_name -> queue.AppointmentSlot
+	4, // [4:4] is the sub-list for method output_type
+	4, // [4:4] is the sub-list for method input_type
+	4, // [4:4] is the sub-list for extension type_name
+	4, // [4:4] is the sub-list for extension extendee
+	0, // [0:4] is the sub-list for field type_name
+}
+
+func init() { file_appointment_proto_init() }
+func file_appointment_proto_init() {
+	if File_appointment_proto != nil {
+		return
+	}
+	if !protoimpl.UnsafeEnabled {
+		file_appointment_proto_msgTypes[0].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*AppointmentSlot); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_appointment_proto_msgTypes[1].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*AppointmentSlotList); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+	}
+	file_appointment_proto_msgTypes[0].OneofWrappers = []interface{}{}
+	type x struct{}
+	out := protoimpl.TypeBuilder{
+		File: protoimpl.DescBuilder{
+			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
+			RawDescriptor: file_appointment_proto_rawDesc,
+			NumEnums:      0,
+			NumMessages:   2,
+			NumExtensions: 0,
+			NumServices:   0,
+		},
+		GoTypes:           file_appointment_proto_goTypes,
+		DependencyIndexes: file_appointment_proto_depIdxs,
+		MessageInfos:      file_appointment_proto_msgTypes,
+	}.Build()
+	File_appointment_proto = out.File
+	file_appointment_proto_rawDesc = nil
+	file_appointment_proto_goTypes = nil
+	file_appointment_proto_depIdxs = nil
+}
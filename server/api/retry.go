@@ -0,0 +1,77 @@
+package api
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"net"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// retryPolicy says how hard to try again when the database has a blip.
+// Each retry waits twice as long as the one before it.
+type retryPolicy struct {
+	attempts int
+	backoff  time.Duration
+}
+
+var defaultRetryPolicy = retryPolicy{
+	attempts: 3,
+	backoff:  50 * time.Millisecond,
+}
+
+// isTransientDBError reports whether err looks like the connection to the
+// database went away or couldn't be made, rather than anything being wrong
+// with what we asked for.
+func isTransientDBError(err error) bool {
+	if errors.Is(err, driver.ErrBadConn) {
+		return true
+	}
+
+	var netErr net.Error
+	if errors.As(err, &netErr) {
+		return true
+	}
+
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) {
+		switch pqErr.Code.Class() {
+		// connection_exception
+		case "08":
+			return true
+		}
+
+		switch pqErr.Code.Name() {
+		case "too_many_connections", "cannot_connect_now", "admin_shutdown":
+			return true
+		}
+	}
+
+	return false
+}
+
+// do calls fn until it succeeds, fails with an error that isn't
+// transient, or runs out of attempts. Only use it for things that are
+// safe to repeat.
+func (p retryPolicy) do(ctx context.Context, fn func() error) error {
+	backoff := p.backoff
+	var err error
+	for i := 0; i < p.attempts; i++ {
+		if i > 0 {
+			select {
+			case <-ctx.Done():
+				return err
+			case <-time.After(backoff):
+			}
+			backoff *= 2
+		}
+
+		err = fn()
+		if err == nil || !isTransientDBError(err) {
+			return err
+		}
+	}
+	return err
+}
@@ -0,0 +1,101 @@
+package api
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+var testRetryPolicy = retryPolicy{attempts: 3, backoff: time.Millisecond}
+
+func TestRetryFailsThenSucceeds(t *testing.T) {
+	var calls int
+	err := testRetryPolicy.do(context.Background(), func() error {
+		calls++
+		if calls < 3 {
+			return fmt.Errorf("failed to begin transaction: %w", driver.ErrBadConn)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Errorf("got error %v, want nil", err)
+	}
+	if calls != 3 {
+		t.Errorf("got %d calls, want 3", calls)
+	}
+}
+
+func TestRetryGivesUp(t *testing.T) {
+	var calls int
+	err := testRetryPolicy.do(context.Background(), func() error {
+		calls++
+		return driver.ErrBadConn
+	})
+	if !errors.Is(err, driver.ErrBadConn) {
+		t.Errorf("got error %v, want %v", err, driver.ErrBadConn)
+	}
+	if calls != testRetryPolicy.attempts {
+		t.Errorf("got %d calls, want %d", calls, testRetryPolicy.attempts)
+	}
+}
+
+func TestRetryLeavesLogicalErrorsAlone(t *testing.T) {
+	for _, want := range []error{
+		sql.ErrNoRows,
+		&pq.Error{Code: "23505"}, // unique_violation
+	} {
+		var calls int
+		err := testRetryPolicy.do(context.Background(), func() error {
+			calls++
+			return want
+		})
+		if err != want {
+			t.Errorf("got error %v, want %v", err, want)
+		}
+		if calls != 1 {
+			t.Errorf("got %d calls for %v, want 1", calls, want)
+		}
+	}
+}
+
+func TestRetryStopsWhenContextDone(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	var calls int
+	err := retryPolicy{attempts: 3, backoff: time.Hour}.do(ctx, func() error {
+		calls++
+		cancel()
+		return driver.ErrBadConn
+	})
+	if !errors.Is(err, driver.ErrBadConn) {
+		t.Errorf("got error %v, want %v", err, driver.ErrBadConn)
+	}
+	if calls != 1 {
+		t.Errorf("got %d calls, want 1", calls)
+	}
+}
+
+func TestIsTransientDBError(t *testing.T) {
+	tests := []struct {
+		err  error
+		want bool
+	}{
+		{driver.ErrBadConn, true},
+		{&pq.Error{Code: "08006"}, true}, // connection_failure
+		{&pq.Error{Code: "53300"}, true}, // too_many_connections
+		{&pq.Error{Code: "57P03"}, true}, // cannot_connect_now
+		{&pq.Error{Code: "23505"}, false},
+		{sql.ErrNoRows, false},
+		{errors.New("something else"), false},
+	}
+	for _, test := range tests {
+		if got := isTransientDBError(test.err); got != test.want {
+			t.Errorf("isTransientDBError(%v) = %t, want %t", test.err, got, test.want)
+		}
+	}
+}
@@ -1,11 +1,16 @@
 package api
 
 import (
+	"crypto/hmac"
+	"crypto/sha256"
 	"database/sql"
 	"io/ioutil"
 	"net/http"
 	"os"
+	"strconv"
+	"strings"
 	"sync"
+	"time"
 
 	"github.com/antonlindstrom/pgstore"
 	"github.com/cskr/pubsub"
@@ -26,6 +31,36 @@ type Server struct {
 	baseURL         string
 	metricsPassword string
 
+	// Where appointment events go for systems outside the queue.
+	events EventPublisher
+
+	// Sets up meeting links for appointments on remote queues.
+	meetings MeetingProvider
+
+	// Puts appointments on the Google Calendars of students who ask.
+	calendar CalendarClient
+
+	// The most some handlers will read from a request body.
+	maxBodySize int64
+
+	// How to retry starting a request's transaction if the database
+	// connection drops.
+	dbRetries retryPolicy
+
+	// The key used to sign tokens for links that work without a login
+	// (see token.go). Derived from the sessions key.
+	tokenKey []byte
+
+	// Work waiting on request transactions to commit (see afterCommit).
+	afterCommitRunning sync.WaitGroup
+
+	// Where that work (and other background tasks) runs.
+	background *WorkerPool
+
+	// The current time, for the checks on when signups open and close;
+	// tests swap it out to pin those down.
+	now func() time.Time
+
 	// The number of WebSockets connected to each queue.
 	websocketCount        map[ksuid.KSUID]int
 	websocketCountByEmail map[ksuid.KSUID]map[string]int
@@ -74,6 +109,7 @@ type queueStore interface {
 	updateQueueSchedule
 	getQueueConfiguration
 	updateQueueConfiguration
+	pendingApprovalRemovals
 	updateQueueOpenStatus
 	sendMessage
 	viewMessage
@@ -86,23 +122,73 @@ type queueStore interface {
 
 	getAppointment
 	getAppointments
+	getAppointmentsSince
 	getAppointmentsForUser
+	getAppointmentPartners
+	leaveAppointment
+	getUpcomingAppointmentsForUser
 	getAppointmentsByTimeslot
+	getTimeslotDetail
+	getWeeklyDemand
 	getAppointmentSchedule
 	getAppointmentScheduleForDay
 	updateAppointmentSchedule
+	cloneAppointmentSchedules
+	getScheduleAuditLog
+	transferStudentAppointments
+	getActiveAppointmentCount
+	setAppointmentResolved
+	getUnresolvedAppointments
+	setTimeslotNote
+	setTimeslotLocation
+	updateCustomFieldDefinitions
+	updateMapRegions
+	updateAppointmentSettings
+	getSignupEligibility
+	previewScheduleChange
+	assignTimeslot
+	approveScheduleChange
 	claimTimeslot
+	claimTimeslotsByPattern
 	unclaimAppointment
+	getClaimEvents
 	signupForAppointment
+	setAppointmentMeetingLink
+	moveAppointment
 	updateAppointment
 	removeAppointmentSignup
+	joinWaitlist
+	leaveWaitlist
+	getWaitlist
+	cancelAppointment
+	cancelAppointmentByToken
+	getAppointmentsMissingLink
+	retryProvisionLink
+	pushAppointmentToGoogleCalendar
+	setGoogleCalendarToken
 }
 
-func New(q queueStore, logger *zap.SugaredLogger, sessionsStore *sql.DB, oauthConfig oauth2.Config) *Server {
+func New(q queueStore, logger *zap.SugaredLogger, sessionsStore *sql.DB, oauthConfig oauth2.Config, events EventPublisher, meetings MeetingProvider, background *WorkerPool) *Server {
 	var s Server
 	s.websocketCount = make(map[ksuid.KSUID]int)
 	s.websocketCountByEmail = make(map[ksuid.KSUID]map[string]int)
 	s.logger = logger
+	s.now = time.Now
+
+	s.events = events
+	if s.events == nil {
+		s.events = NoopEventPublisher{}
+	}
+
+	s.meetings = meetings
+	if s.meetings == nil {
+		s.meetings = NoopMeetingProvider{}
+	}
+
+	s.background = background
+	if s.background == nil {
+		s.background = NewWorkerPool(DefaultBackgroundWorkers)
+	}
 
 	key, err := ioutil.ReadFile(os.Getenv("QUEUE_SESSIONS_KEY_FILE"))
 	if err != nil {
@@ -113,6 +199,10 @@ func New(q queueStore, logger *zap.SugaredLogger, sessionsStore *sql.DB, oauthCo
 	if err != nil {
 		logger.Fatalw("couldn't set up session store", "err", err)
 	}
+	tokenKey := hmac.New(sha256.New, key)
+	tokenKey.Write([]byte("signed tokens"))
+	s.tokenKey = tokenKey.Sum(nil)
+
 	s.sessions.Options = &sessions.Options{
 		HttpOnly: true,
 		Secure:   os.Getenv("USE_SECURE_COOKIES") == "true",
@@ -135,11 +225,25 @@ func New(q queueStore, logger *zap.SugaredLogger, sessionsStore *sql.DB, oauthCo
 	s.ps = pubsub.New(5)
 
 	s.oauthConfig = oauthConfig
+	s.calendar = GoogleCalendarClient{Config: oauthConfig}
 
 	s.baseURL = os.Getenv("QUEUE_BASE_URL")
 
+	s.maxBodySize = defaultMaxBodySize
+	if size, err := strconv.ParseInt(os.Getenv("QUEUE_MAX_BODY_BYTES"), 10, 64); err == nil && size > 0 {
+		s.maxBodySize = size
+	}
+
+	s.dbRetries = defaultRetryPolicy
+	if attempts, err := strconv.Atoi(os.Getenv("QUEUE_DB_RETRY_ATTEMPTS")); err == nil && attempts > 0 {
+		s.dbRetries.attempts = attempts
+	}
+	if backoff, err := time.ParseDuration(os.Getenv("QUEUE_DB_RETRY_BACKOFF")); err == nil && backoff > 0 {
+		s.dbRetries.backoff = backoff
+	}
+
 	s.Router = chi.NewRouter()
-	s.Router.Use(instrumenter, ksuidInserter, s.recoverMiddleware, s.transaction(q), s.sessionRetriever)
+	s.Router.Use(instrumenter, tracingMiddleware, ksuidInserter, s.recoverMiddleware, s.transaction(q), s.sessionRetriever)
 
 	// Course endpoints
 	s.Route("/courses", func(r chi.Router) {
@@ -275,6 +379,9 @@ func New(q queueStore, logger *zap.SugaredLogger, sessionsStore *sql.DB, oauthCo
 		// Get queue roster (queue admin)
 		r.With(s.ValidLoginMiddleware, s.EnsureCourseAdmin).Method("GET", "/roster", s.GetQueueRoster(q))
 
+		// Replace queue roster from CSV upload (queue admin)
+		r.With(s.ValidLoginMiddleware, s.EnsureCourseAdmin).Method("POST", "/roster/import", s.ImportRosterCSV(q))
+
 		// Queue groups endpoints
 		r.Route("/groups", func(r chi.Router) {
 			r.Use(s.ValidLoginMiddleware, s.EnsureCourseAdmin)
@@ -288,6 +395,77 @@ func New(q queueStore, logger *zap.SugaredLogger, sessionsStore *sql.DB, oauthCo
 
 		// Appointments endpoints
 		r.Route("/appointments", func(r chi.Router) {
+			// Get appointment changes since timestamp (more information with queue admin)
+			r.Method("GET", "/changes", s.GetAppointmentsSince(q))
+
+			// Export current user's claimed timeslots this week as a calendar file (queue admin)
+			r.With(s.ValidLoginMiddleware, s.EnsureCourseAdmin).Method("GET", "/claims.ics", s.ExportTAScheduleICS(q))
+
+			// Get today's schedule, availability, and coverage gaps (queue admin)
+			r.With(s.ValidLoginMiddleware, s.EnsureCourseAdmin).Method("GET", "/dashboard", s.GetAppointmentDashboard(q))
+
+			// Check whether a student could sign up right now (queue admin)
+			r.With(s.ValidLoginMiddleware, s.EnsureCourseAdmin).Method("GET", "/eligibility", s.GetSignupEligibility(q))
+
+			// Total time a student has spent in appointments (queue admin)
+			r.With(s.ValidLoginMiddleware, s.EnsureCourseAdmin).Method("GET", "/total-time", s.GetStudentTotalTime(q))
+
+			// Count appointments going on now and still to come (queue admin)
+			r.With(s.ValidLoginMiddleware, s.EnsureCourseAdmin).Method("GET", "/active-count", s.GetActiveAppointmentCount(q))
+
+			// Move a student's upcoming appointments to another queue (queue admin)
+			r.With(s.ValidLoginMiddleware, s.EnsureCourseAdmin).Method("POST", "/transfer", s.TransferStudentAppointments(q))
+
+			// Appointments marked as not resolved, for follow-up (queue admin)
+			r.With(s.ValidLoginMiddleware, s.EnsureCourseAdmin).Method("GET", "/unresolved", s.GetUnresolvedAppointments(q))
+
+			// Upcoming appointments missing a meeting link (queue admin)
+			r.With(s.ValidLoginMiddleware, s.EnsureCourseAdmin).Method("GET", "/missing-links", s.GetAppointmentsMissingLink(q))
+
+			// Appointment settings, on their own (queue admin)
+			r.Route("/settings", func(r chi.Router) {
+				r.Use(s.ValidLoginMiddleware, s.EnsureCourseAdmin)
+
+				// Get appointment settings (queue admin)
+				r.Method("GET", "/", s.GetAppointmentSettings(q))
+
+				// Update appointment settings (queue admin)
+				r.Method("PUT", "/", s.UpdateAppointmentSettings(q))
+
+				// Turning off schedule approval, waiting on a second admin (queue admin)
+				r.Route("/approval-removal", func(r chi.Router) {
+					// Get pending approval removal (queue admin)
+					r.Method("GET", "/", s.GetPendingApprovalRemoval(q))
+
+					// Approve and turn off schedule approval (queue admin, not proposer)
+					r.Method("POST", "/approve", s.ApproveApprovalRemoval(q))
+
+					// Reject or withdraw pending approval removal (queue admin)
+					r.Method("DELETE", "/", s.RemovePendingApprovalRemoval(q))
+				})
+			})
+
+			// Custom fields asked for on appointments
+			r.Route("/custom-fields", func(r chi.Router) {
+				// Get custom field definitions
+				r.Method("GET", "/", s.GetCustomFieldDefinitions(q))
+
+				// Replace custom field definitions (queue admin)
+				r.With(s.ValidLoginMiddleware, s.EnsureCourseAdmin).Method("PUT", "/", s.UpdateCustomFieldDefinitions(q))
+			})
+
+			// Areas of the room map that students can drop their pin in
+			r.Route("/map-regions", func(r chi.Router) {
+				// Get map regions
+				r.Method("GET", "/", s.GetMapRegions(q))
+
+				// Replace map regions (queue admin)
+				r.With(s.ValidLoginMiddleware, s.EnsureCourseAdmin).Method("PUT", "/", s.UpdateMapRegions(q))
+			})
+
+			// Get signups against capacity for every timeslot this week (queue admin)
+			r.With(s.ValidLoginMiddleware, s.EnsureCourseAdmin).Method("GET", "/demand", s.GetWeeklyDemand(q))
+
 			// Specific day endpoints
 			r.Route(`/{day:\d+}`, func(r chi.Router) {
 				r.Use(s.AppointmentDayMiddleware)
@@ -295,12 +473,50 @@ func New(q queueStore, logger *zap.SugaredLogger, sessionsStore *sql.DB, oauthCo
 				// Get endpoints on day (more information with queue admin)
 				r.Method("GET", "/", s.GetAppointments(q))
 
+				// Get schedule, appointments, and availability on day (more information with queue admin)
+				r.Method("GET", "/overview", s.GetAppointmentDay(q))
+
 				// Get appointments for current user on day
 				r.With(s.ValidLoginMiddleware).Method("GET", "/@me", s.GetAppointmentsForCurrentUser(q))
 
+				// Get availability and claims at timeslot on day (appointments with queue admin)
+				r.With(s.AppointmentTimeslotMiddleware).Method("GET", `/{timeslot:\d+}`, s.GetTimeslotDetail(q))
+
 				// Create appointment on day at timeslot
 				r.With(s.ValidLoginMiddleware, s.AppointmentTimeslotMiddleware).Method("POST", `/{timeslot:\d+}`, s.SignupForAppointment(q))
 
+				// Waitlist for a full timeslot on day
+				r.Route(`/{timeslot:\d+}/waitlist`, func(r chi.Router) {
+					r.Use(s.ValidLoginMiddleware, s.AppointmentTimeslotMiddleware)
+
+					// Get students waiting on timeslot (queue admin)
+					r.With(s.EnsureCourseAdmin).Method("GET", "/", s.GetWaitlist(q))
+
+					// Join waitlist for timeslot
+					r.Method("POST", "/", s.JoinWaitlist(q))
+
+					// Leave waitlist for timeslot
+					r.Method("DELETE", "/", s.LeaveWaitlist(q))
+				})
+
+				// Create appointment on day at first open timeslot of several
+				r.With(s.ValidLoginMiddleware, s.FlexibleTimeslotMiddleware).Method("POST", "/flexible", s.SignupForAppointment(q))
+
+				// Move appointments on day to another day (queue admin)
+				r.With(s.ValidLoginMiddleware, s.EnsureCourseAdmin).Method("POST", "/shift", s.ShiftDayAppointments(q))
+
+				// Get claim and unclaim history on day (queue admin)
+				r.With(s.ValidLoginMiddleware, s.EnsureCourseAdmin).Method("GET", "/claims/history", s.GetClaimHistory(q))
+
+				// Get timeslots on day with no staff claims (queue admin)
+				r.With(s.ValidLoginMiddleware, s.EnsureCourseAdmin).Method("GET", "/coverage", s.GetCoverageGaps(q))
+
+				// Message all students with appointments on day (queue admin)
+				r.With(s.ValidLoginMiddleware, s.EnsureCourseAdmin).Method("POST", "/notify", s.NotifyAppointmentStudentsForDay(q))
+
+				// Claim every so many timeslots on day (queue admin)
+				r.With(s.ValidLoginMiddleware, s.EnsureCourseAdmin).Method("POST", "/claims/pattern", s.ClaimTimeslotsByPattern(q))
+
 				// Appointment claiming (queue admin)
 				r.Route(`/claims/{timeslot:\d+}`, func(r chi.Router) {
 					r.Use(s.ValidLoginMiddleware, s.EnsureCourseAdmin, s.AppointmentTimeslotMiddleware)
@@ -322,11 +538,35 @@ func New(q queueStore, logger *zap.SugaredLogger, sessionsStore *sql.DB, oauthCo
 			r.Route(`/{appointment_id:[a-zA-Z0-9]{27}}`, func(r chi.Router) {
 				r.Use(s.ValidLoginMiddleware, s.AppointmentIDMiddleware(q))
 
+				// Get appointment (valid login, same user as creator or partner, or queue admin)
+				r.Method("GET", "/", s.GetAppointmentByID(q))
+
 				// Update appointment (valid login, same user as creator)
 				r.Method("PUT", "/", s.UpdateAppointment(q))
 
 				// Cancel appointment (valid login, same user as creator)
 				r.Method("DELETE", "/", s.RemoveAppointmentSignup(q))
+
+				// Leave appointment current user was added to as a partner (valid login, partner)
+				r.Method("DELETE", "/partners/@me", s.LeaveAppointment(q))
+
+				// Set appointment labels (queue admin)
+				r.With(s.EnsureCourseAdmin).Method("PUT", "/labels", s.SetAppointmentLabels(q))
+
+				// Mark whether appointment resolved the student's question (queue admin)
+				r.With(s.EnsureCourseAdmin).Method("PUT", "/resolved", s.SetAppointmentResolved(q))
+
+				// Try setting up a meeting link again (queue admin)
+				r.With(s.EnsureCourseAdmin).Method("POST", "/meeting-link", s.RetryProvisionLink(q))
+
+				// Create read-only share link (valid login, same user as creator)
+				r.Method("POST", "/share", s.CreateAppointmentShareLink())
+
+				// Create cancel link for emails (valid login, same user as creator)
+				r.Method("POST", "/cancel-link", s.CreateAppointmentCancelLink())
+
+				// Add appointment to Google Calendar (valid login, same user as creator)
+				r.Method("POST", "/google-calendar", s.PushAppointmentToGoogleCalendar(q))
 			})
 
 			// Appointment schedule endpoints
@@ -334,6 +574,18 @@ func New(q queueStore, logger *zap.SugaredLogger, sessionsStore *sql.DB, oauthCo
 				// Get appointment schedule for all days
 				r.Method("GET", "/", s.GetAppointmentSchedule(q))
 
+				// Get earliest start and latest end across the week's schedules
+				r.Method("GET", "/bounds", s.GetWeeklyTimeBounds(q))
+
+				// Update appointment schedules for several days at once (queue admin)
+				r.With(s.ValidLoginMiddleware, s.EnsureCourseAdmin).Method("PUT", "/", s.UpdateAppointmentSchedulesBatch(q))
+
+				// Get history of changes to appointment schedules (queue admin)
+				r.With(s.ValidLoginMiddleware, s.EnsureCourseAdmin).Method("GET", "/audit", s.GetScheduleAuditLog(q))
+
+				// Replace appointment schedule for all days with another queue's (queue admin on both)
+				r.With(s.ValidLoginMiddleware, s.EnsureCourseAdmin).Method("POST", "/clone", s.CloneAppointmentSchedules(q))
+
 				// Per-day schedules
 				r.Route(`/{day:\d+}`, func(r chi.Router) {
 					r.Use(s.AppointmentDayMiddleware)
@@ -343,6 +595,38 @@ func New(q queueStore, logger *zap.SugaredLogger, sessionsStore *sql.DB, oauthCo
 
 					// Update appointment schedule for day (queue admin)
 					r.With(s.ValidLoginMiddleware, s.EnsureCourseAdmin).Method("PUT", "/", s.UpdateAppointmentSchedule(q))
+
+					// Check and spell out a schedule for day without saving it (queue admin)
+					r.With(s.ValidLoginMiddleware, s.EnsureCourseAdmin).Method("POST", "/normalize", s.NormalizeAppointmentSchedule())
+
+					// Preview replacing schedule for day without applying it (queue admin)
+					r.With(s.ValidLoginMiddleware, s.EnsureCourseAdmin).Method("POST", "/preview", s.PreviewScheduleChange(q))
+
+					// Set note students see when booking timeslot on day (queue admin)
+					r.With(s.ValidLoginMiddleware, s.EnsureCourseAdmin, s.AppointmentTimeslotMiddleware).Method("PUT", `/notes/{timeslot:\d+}`, s.SetTimeslotNote(q))
+
+					// Set default location for a timeslot (queue admin)
+					r.With(s.ValidLoginMiddleware, s.EnsureCourseAdmin, s.AppointmentTimeslotMiddleware).Method("PUT", `/locations/{timeslot:\d+}`, s.SetTimeslotLocation(q))
+
+					// Get staff members assigned to timeslots on day (queue admin)
+					r.With(s.ValidLoginMiddleware, s.EnsureCourseAdmin).Method("GET", "/assignments", s.GetTimeslotAssignments(q))
+
+					// Assign staff member to timeslot on day (queue admin)
+					r.With(s.ValidLoginMiddleware, s.EnsureCourseAdmin, s.AppointmentTimeslotMiddleware).Method("PUT", `/assignments/{timeslot:\d+}`, s.AssignTAToTimeslot(q))
+
+					// Schedule changes waiting on a second admin (queue admin)
+					r.Route("/pending", func(r chi.Router) {
+						r.Use(s.ValidLoginMiddleware, s.EnsureCourseAdmin)
+
+						// Get pending schedule change for day (queue admin)
+						r.Method("GET", "/", s.GetPendingScheduleChange(q))
+
+						// Approve and apply pending schedule change (queue admin, not proposer)
+						r.Method("POST", "/approve", s.ApproveScheduleChange(q))
+
+						// Reject or withdraw pending schedule change (queue admin)
+						r.Method("DELETE", "/", s.RemovePendingScheduleChange(q))
+					})
 				})
 			})
 		})
@@ -359,13 +643,93 @@ func New(q queueStore, logger *zap.SugaredLogger, sessionsStore *sql.DB, oauthCo
 
 	s.With(s.ValidLoginMiddleware).Method("GET", "/users/@me", s.GetCurrentUserInfo(q))
 
+	// Get current user's appointments for the week, grouped by day (valid login)
+	s.With(s.ValidLoginMiddleware).Method("GET", "/users/@me/agenda", s.GetStudentAgenda(q))
+
+	// Create subscribable calendar link for current user's appointments (valid login)
+	s.With(s.ValidLoginMiddleware).Method("POST", "/users/@me/calendar", s.CreateCalendarFeedLink())
+
+	// Let the queue put appointments on current user's Google Calendar (valid login)
+	s.With(s.ValidLoginMiddleware).Method("GET", "/users/@me/google-calendar/connect", s.ConnectGoogleCalendar())
+
+	s.With(s.ValidLoginMiddleware).Method("GET", "/oauth2callback/google-calendar", s.GoogleCalendarCallback(q))
+
+	// Get shared appointment by signed token (no login)
+	s.Method("GET", "/appointments/shared/{token}", s.GetSharedAppointment(q))
+
+	// Cancel appointment by signed token (no login)
+	s.Method("POST", "/appointments/cancel/{token}", s.CancelAppointmentByToken(q))
+
+	// Get calendar feed of a user's appointments by signed token (no login)
+	s.Method("GET", "/appointments.ics", s.GetCalendarFeed(q))
+
 	s.Method("GET", "/metrics", s.MetricsHandler())
 
 	s.NotFound(func(w http.ResponseWriter, r *http.Request) {
 		w.WriteHeader(http.StatusNotFound)
 	})
 
+	s.MethodNotAllowed(s.methodNotAllowed)
+
 	s.RegisterQueueStats(q)
+	s.RegisterBackgroundStats()
 
 	return &s
 }
+
+// methodNotAllowed answers requests for a route that exists, but not for
+// the request's method. chi would just send a bare 405; this lets clients
+// know which methods they can use.
+func (s *Server) methodNotAllowed(w http.ResponseWriter, r *http.Request) {
+	var allowed []string
+	for _, m := range []string{"GET", "POST", "PUT", "PATCH", "DELETE"} {
+		if routeAllows(s.Router, m, r.URL.Path) {
+			allowed = append(allowed, m)
+		}
+	}
+
+	w.Header().Set("Allow", strings.Join(allowed, ", "))
+	s.errorMessage(
+		http.StatusMethodNotAllowed,
+		"You can't do that here.",
+		w, r,
+	)
+}
+
+// routeAllows reports whether routes has a handler for method at path.
+// chi's Match alone isn't enough: right at the path a subrouter is
+// mounted on, it matches every method without asking the subrouter.
+func routeAllows(routes chi.Routes, method, path string) bool {
+	rctx := chi.NewRouteContext()
+	if !routes.Match(rctx, method, path) {
+		return false
+	}
+
+	// Match leaves one pattern per router it went through. All but the
+	// last are mounts it followed into a subrouter.
+	for i, pattern := range rctx.RoutePatterns {
+		if i < len(rctx.RoutePatterns)-1 {
+			routes = subroutes(routes, pattern)
+			continue
+		}
+
+		mount := strings.TrimSuffix(pattern, "/") + "/*"
+		if sub := subroutes(routes, mount); sub != nil {
+			return routeAllows(sub, method, "/")
+		}
+	}
+	return true
+}
+
+// subroutes finds the subrouter mounted at pattern (ending in /*), if any.
+func subroutes(routes chi.Routes, pattern string) chi.Routes {
+	if routes == nil {
+		return nil
+	}
+	for _, route := range routes.Routes() {
+		if route.Pattern == pattern {
+			return route.SubRoutes
+		}
+	}
+	return nil
+}
@@ -0,0 +1,62 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/go-chi/chi"
+)
+
+func TestMethodNotAllowed(t *testing.T) {
+	s := newTestServer()
+	s.Router = chi.NewRouter()
+	ok := func(w http.ResponseWriter, r *http.Request) {}
+	s.Route("/queues/{id}/appointments", func(r chi.Router) {
+		r.Get("/", ok)
+		r.Route(`/{appointment_id:[a-z]+}`, func(r chi.Router) {
+			r.Get("/", ok)
+			r.Put("/", ok)
+			r.Delete("/", ok)
+		})
+	})
+	s.MethodNotAllowed(s.methodNotAllowed)
+
+	tests := []struct {
+		method, target, allow string
+	}{
+		{http.MethodPatch, "/queues/q/appointments/a", "GET, PUT, DELETE"},
+		{http.MethodPost, "/queues/q/appointments/a/", "GET, PUT, DELETE"},
+		{http.MethodDelete, "/queues/q/appointments", "GET"},
+	}
+	for _, test := range tests {
+		r, _ := newTestRequest(test.method, test.target, nil, nil)
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, r)
+
+		if w.Code != http.StatusMethodNotAllowed {
+			t.Errorf("%s %s: got status %d, want %d", test.method, test.target, w.Code, http.StatusMethodNotAllowed)
+		}
+		if allow := w.Header().Get("Allow"); allow != test.allow {
+			t.Errorf("%s %s: got Allow %q, want %q", test.method, test.target, allow, test.allow)
+		}
+
+		var body ErrorMessage
+		err := json.NewDecoder(w.Body).Decode(&body)
+		if err != nil {
+			t.Fatalf("failed to decode error response: %v", err)
+		}
+		if body.Message == "" {
+			t.Error("got empty error message")
+		}
+	}
+
+	// Methods the route does take still go through.
+	r, _ := newTestRequest(http.MethodPut, "/queues/q/appointments/a", nil, nil)
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, r)
+	if w.Code != http.StatusOK {
+		t.Errorf("got status %d for an allowed method, want %d", w.Code, http.StatusOK)
+	}
+}
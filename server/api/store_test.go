@@ -0,0 +1,907 @@
+package api
+
+import (
+	"context"
+	"database/sql"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/segmentio/ksuid"
+	"golang.org/x/oauth2"
+)
+
+// fakeStore keeps just enough in memory to run handlers against. The
+// embedded queueStore is nil, so calling anything that isn't implemented
+// below panics, which makes it obvious when a test needs more of it.
+type fakeStore struct {
+	queueStore
+
+	mu             sync.Mutex
+	queues         map[ksuid.KSUID]*Queue
+	admins         map[string]bool
+	configs        map[ksuid.KSUID]*QueueConfiguration
+	schedules      map[ksuid.KSUID]map[int]*AppointmentSchedule
+	appointments   []*AppointmentSlot
+	partners       map[ksuid.KSUID][]string
+	labels         map[ksuid.KSUID][]string
+	customFields   map[ksuid.KSUID]map[string]interface{}
+	calendarEvents map[ksuid.KSUID][]*CalendarEventLink
+	calendarTokens map[string]*oauth2.Token
+	assignments    map[ksuid.KSUID]map[int]map[int]string
+	messages       []*Message
+	rosters        map[ksuid.KSUID]map[string]bool
+	claimEvents    []*ClaimEvent
+	tombstones     []*AppointmentTombstone
+
+	approvalRemovals map[ksuid.KSUID]*PendingApprovalRemoval
+	fieldDefinitions map[ksuid.KSUID][]*CustomFieldDefinition
+	mapRegions       map[ksuid.KSUID][]*MapRegion
+	pendingChanges   map[ksuid.KSUID]map[int]*PendingScheduleChange
+	auditLog         []*ScheduleAuditEntry
+	waitlist         []*WaitlistEntry
+}
+
+func newFakeStore() *fakeStore {
+	return &fakeStore{
+		queues:         make(map[ksuid.KSUID]*Queue),
+		admins:         make(map[string]bool),
+		configs:        make(map[ksuid.KSUID]*QueueConfiguration),
+		schedules:      make(map[ksuid.KSUID]map[int]*AppointmentSchedule),
+		partners:       make(map[ksuid.KSUID][]string),
+		labels:         make(map[ksuid.KSUID][]string),
+		customFields:   make(map[ksuid.KSUID]map[string]interface{}),
+		calendarEvents: make(map[ksuid.KSUID][]*CalendarEventLink),
+		calendarTokens: make(map[string]*oauth2.Token),
+		assignments:    make(map[ksuid.KSUID]map[int]map[int]string),
+		rosters:        make(map[ksuid.KSUID]map[string]bool),
+
+		approvalRemovals: make(map[ksuid.KSUID]*PendingApprovalRemoval),
+		fieldDefinitions: make(map[ksuid.KSUID][]*CustomFieldDefinition),
+		mapRegions:       make(map[ksuid.KSUID][]*MapRegion),
+		pendingChanges:   make(map[ksuid.KSUID]map[int]*PendingScheduleChange),
+	}
+}
+
+// addQueue sets up a queue with config and an open schedule (one slot in
+// every hour-long timeslot) on each day.
+func (f *fakeStore) addQueue(config *QueueConfiguration) *Queue {
+	q := &Queue{ID: ksuid.New(), Course: ksuid.New(), Type: Appointments}
+	f.queues[q.ID] = q
+	f.configs[q.ID] = config
+	f.schedules[q.ID] = make(map[int]*AppointmentSchedule)
+	for day := 0; day < 7; day++ {
+		f.schedules[q.ID][day] = &AppointmentSchedule{
+			Queue:    q.ID,
+			Day:      time.Weekday(day),
+			Duration: 60,
+			Schedule: "111111111111111111111111",
+		}
+	}
+	return q
+}
+
+// book signs email up for the timeslot on the given day of the week.
+func (f *fakeStore) book(q *Queue, day, timeslot int, email string) *AppointmentSlot {
+	schedule := f.schedules[q.ID][day]
+	start, _ := WeekdayBounds(day)
+	name, location, description := "Student", "Here", "Help"
+	var zero float32
+	a := &AppointmentSlot{
+		ID:            ksuid.New(),
+		Queue:         q.ID,
+		StudentEmail:  &email,
+		ScheduledTime: SlotStart(start, timeslot, schedule),
+		Timeslot:      timeslot,
+		Duration:      schedule.Duration,
+		SlotSpan:      1,
+		Name:          &name,
+		Location:      &location,
+		Description:   &description,
+		MapX:          &zero,
+		MapY:          &zero,
+	}
+	f.appointments = append(f.appointments, a)
+	return a
+}
+
+// claim has staff claim the timeslot on the given day of the week, with
+// no student in it.
+func (f *fakeStore) claim(q *Queue, day, timeslot int, staff string) *AppointmentSlot {
+	a := f.book(q, day, timeslot, "")
+	a.StudentEmail = nil
+	a.Name, a.Location, a.Description, a.MapX, a.MapY = nil, nil, nil, nil, nil
+	a.StaffEmail = &staff
+	return a
+}
+
+func (f *fakeStore) appointment(id ksuid.KSUID) *AppointmentSlot {
+	for _, a := range f.appointments {
+		if a.ID == id {
+			return a
+		}
+	}
+	return nil
+}
+
+func (f *fakeStore) GetQueue(ctx context.Context, queue ksuid.KSUID) (*Queue, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	q, ok := f.queues[queue]
+	if !ok {
+		return nil, sql.ErrNoRows
+	}
+	c := *q
+	return &c, nil
+}
+
+// CourseAdmin treats everyone in admins as an admin of every course.
+func (f *fakeStore) CourseAdmin(ctx context.Context, course ksuid.KSUID, email string) (bool, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.admins[email], nil
+}
+
+func (f *fakeStore) GetQueueConfiguration(ctx context.Context, queue ksuid.KSUID) (*QueueConfiguration, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	config, ok := f.configs[queue]
+	if !ok {
+		return nil, sql.ErrNoRows
+	}
+	c := *config
+	return &c, nil
+}
+
+func (f *fakeStore) UpdateQueueConfiguration(ctx context.Context, queue ksuid.KSUID, configuration *QueueConfiguration) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	c := *configuration
+	f.configs[queue] = &c
+	return nil
+}
+
+func (f *fakeStore) GetPendingApprovalRemoval(ctx context.Context, queue ksuid.KSUID) (*PendingApprovalRemoval, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	pending, ok := f.approvalRemovals[queue]
+	if !ok {
+		return nil, sql.ErrNoRows
+	}
+	p := *pending
+	return &p, nil
+}
+
+func (f *fakeStore) SetPendingApprovalRemoval(ctx context.Context, queue ksuid.KSUID, email string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.approvalRemovals[queue] = &PendingApprovalRemoval{
+		Queue:      queue,
+		ProposedBy: email,
+		CreatedAt:  time.Now(),
+	}
+	return nil
+}
+
+func (f *fakeStore) RemovePendingApprovalRemoval(ctx context.Context, queue ksuid.KSUID) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if _, ok := f.approvalRemovals[queue]; !ok {
+		return sql.ErrNoRows
+	}
+	delete(f.approvalRemovals, queue)
+	return nil
+}
+
+func (f *fakeStore) GetCustomFieldDefinitions(ctx context.Context, queue ksuid.KSUID) ([]*CustomFieldDefinition, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.fieldDefinitions[queue], nil
+}
+
+func (f *fakeStore) GetMapRegions(ctx context.Context, queue ksuid.KSUID) ([]*MapRegion, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.mapRegions[queue], nil
+}
+
+func (f *fakeStore) SetMapRegions(ctx context.Context, queue ksuid.KSUID, regions []*MapRegion) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.mapRegions[queue] = regions
+	return nil
+}
+
+func (f *fakeStore) GetAppointmentScheduleForDay(ctx context.Context, queue ksuid.KSUID, day int) (*AppointmentSchedule, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	schedule, ok := f.schedules[queue][day]
+	if !ok {
+		return nil, sql.ErrNoRows
+	}
+	s := *schedule
+	return &s, nil
+}
+
+func (f *fakeStore) GetAppointmentSchedule(ctx context.Context, queue ksuid.KSUID) ([]*AppointmentSchedule, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	schedules := make([]*AppointmentSchedule, 0, 7)
+	for day := 0; day < 7; day++ {
+		if schedule, ok := f.schedules[queue][day]; ok {
+			s := *schedule
+			schedules = append(schedules, &s)
+		}
+	}
+	return schedules, nil
+}
+
+func (f *fakeStore) UpdateAppointmentSchedule(ctx context.Context, queue ksuid.KSUID, day int, schedule *AppointmentSchedule) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	s := *schedule
+	s.Queue = queue
+	s.Day = time.Weekday(day)
+	f.schedules[queue][day] = &s
+	return nil
+}
+
+// SetTimeslotNote replaces the day's notes rather than changing them in
+// place, since schedules handed out earlier share the map.
+func (f *fakeStore) SetTimeslotNote(ctx context.Context, queue ksuid.KSUID, day, timeslot int, note string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	schedule := f.schedules[queue][day]
+	notes := make(map[int]string, len(schedule.Notes)+1)
+	for t, n := range schedule.Notes {
+		notes[t] = n
+	}
+	if note == "" {
+		delete(notes, timeslot)
+	} else {
+		notes[timeslot] = note
+	}
+	schedule.Notes = notes
+	return nil
+}
+
+// SetTimeslotLocation replaces the day's locations the same way
+// SetTimeslotNote does its notes.
+func (f *fakeStore) SetTimeslotLocation(ctx context.Context, queue ksuid.KSUID, day, timeslot int, location string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	schedule := f.schedules[queue][day]
+	locations := make(map[int]string, len(schedule.Locations)+1)
+	for t, l := range schedule.Locations {
+		locations[t] = l
+	}
+	if location == "" {
+		delete(locations, timeslot)
+	} else {
+		locations[timeslot] = location
+	}
+	schedule.Locations = locations
+	return nil
+}
+
+func (f *fakeStore) GetPendingScheduleChange(ctx context.Context, queue ksuid.KSUID, day int) (*PendingScheduleChange, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	change, ok := f.pendingChanges[queue][day]
+	if !ok {
+		return nil, sql.ErrNoRows
+	}
+	c := *change
+	return &c, nil
+}
+
+func (f *fakeStore) SetPendingScheduleChange(ctx context.Context, queue ksuid.KSUID, day int, schedule *AppointmentSchedule, email string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.pendingChanges[queue] == nil {
+		f.pendingChanges[queue] = make(map[int]*PendingScheduleChange)
+	}
+	s := *schedule
+	s.Queue = queue
+	s.Day = time.Weekday(day)
+	f.pendingChanges[queue][day] = &PendingScheduleChange{
+		AppointmentSchedule: s,
+		ProposedBy:          email,
+		CreatedAt:           time.Now(),
+	}
+	return nil
+}
+
+func (f *fakeStore) RemovePendingScheduleChange(ctx context.Context, queue ksuid.KSUID, day int) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if _, ok := f.pendingChanges[queue][day]; !ok {
+		return sql.ErrNoRows
+	}
+	delete(f.pendingChanges[queue], day)
+	return nil
+}
+
+func (f *fakeStore) AddScheduleAuditEntry(ctx context.Context, entry *ScheduleAuditEntry) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	e := *entry
+	e.ID = ksuid.New()
+	e.CreatedAt = time.Now()
+	f.auditLog = append(f.auditLog, &e)
+	return nil
+}
+
+func (f *fakeStore) GetScheduleAuditLog(ctx context.Context, queue ksuid.KSUID) ([]*ScheduleAuditEntry, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var entries []*ScheduleAuditEntry
+	for _, e := range f.auditLog {
+		if e.Queue == queue {
+			c := *e
+			entries = append(entries, &c)
+		}
+	}
+	return entries, nil
+}
+
+func (f *fakeStore) GetAppointment(ctx context.Context, appointment ksuid.KSUID) (*AppointmentSlot, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	a := f.appointment(appointment)
+	if a == nil {
+		return nil, sql.ErrNoRows
+	}
+	c := *a
+	return &c, nil
+}
+
+// sortAppointments puts appointments in the order the database's queries
+// return them: by scheduled time, then ID.
+func sortAppointments(appointments []*AppointmentSlot) {
+	sort.Slice(appointments, func(i, j int) bool {
+		a, b := appointments[i], appointments[j]
+		if !a.ScheduledTime.Equal(b.ScheduledTime) {
+			return a.ScheduledTime.Before(b.ScheduledTime)
+		}
+		return ksuid.Compare(a.ID, b.ID) < 0
+	})
+}
+
+func (f *fakeStore) GetAppointments(ctx context.Context, queue ksuid.KSUID, from, to time.Time) ([]*AppointmentSlot, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var appointments []*AppointmentSlot
+	for _, a := range f.appointments {
+		if a.Queue == queue && !a.ScheduledTime.Before(from) && a.ScheduledTime.Before(to) {
+			c := *a
+			appointments = append(appointments, &c)
+		}
+	}
+	sortAppointments(appointments)
+	return appointments, nil
+}
+
+func (f *fakeStore) GetAppointmentsWithStudent(ctx context.Context, queue ksuid.KSUID, from, to time.Time) ([]*AppointmentSlot, error) {
+	appointments, err := f.GetAppointments(ctx, queue, from, to)
+	if err != nil {
+		return nil, err
+	}
+	var filtered []*AppointmentSlot
+	for _, a := range appointments {
+		if a.StudentEmail != nil {
+			filtered = append(filtered, a)
+		}
+	}
+	return filtered, nil
+}
+
+func (f *fakeStore) GetAppointmentsByTimeslot(ctx context.Context, queue ksuid.KSUID, from, to time.Time, timeslot int) ([]*AppointmentSlot, error) {
+	appointments, err := f.GetAppointments(ctx, queue, from, to)
+	if err != nil {
+		return nil, err
+	}
+	var filtered []*AppointmentSlot
+	for _, a := range appointments {
+		if a.Timeslot <= timeslot && a.Timeslot+a.Span() > timeslot {
+			filtered = append(filtered, a)
+		}
+	}
+	return filtered, nil
+}
+
+func (f *fakeStore) SignupForAppointment(ctx context.Context, queue ksuid.KSUID, appointment *AppointmentSlot) (*AppointmentSlot, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	a := *appointment
+	a.ID = ksuid.New()
+	a.Queue = queue
+	a.UpdatedAt = time.Now()
+	f.appointments = append(f.appointments, &a)
+	c := a
+	return &c, nil
+}
+
+func (f *fakeStore) UpdateAppointment(ctx context.Context, appointment ksuid.KSUID, newAppointment *AppointmentSlot) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	a := f.appointment(appointment)
+	if a == nil {
+		return sql.ErrNoRows
+	}
+	a.Name = newAppointment.Name
+	a.Location = newAppointment.Location
+	a.Description = newAppointment.Description
+	a.MapX = newAppointment.MapX
+	a.MapY = newAppointment.MapY
+	a.AnonymousToPeers = newAppointment.AnonymousToPeers
+	a.UpdatedAt = time.Now()
+	return nil
+}
+
+func (f *fakeStore) MoveAppointment(ctx context.Context, appointment ksuid.KSUID, scheduledTime time.Time, timeslot, duration int) (*AppointmentSlot, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	a := f.appointment(appointment)
+	if a == nil {
+		return nil, sql.ErrNoRows
+	}
+	a.ScheduledTime = scheduledTime
+	a.Timeslot = timeslot
+	a.Duration = duration
+	a.UpdatedAt = time.Now()
+	c := *a
+	return &c, nil
+}
+
+func (f *fakeStore) RemoveAppointmentSignup(ctx context.Context, appointment ksuid.KSUID) (bool, *AppointmentSlot, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	a := f.appointment(appointment)
+	if a == nil {
+		return false, nil, sql.ErrNoRows
+	}
+	delete(f.partners, appointment)
+	delete(f.labels, appointment)
+	delete(f.customFields, appointment)
+	delete(f.calendarEvents, appointment)
+
+	if a.StaffEmail != nil {
+		a.StudentEmail = nil
+		a.UpdatedAt = time.Now()
+		c := *a
+		return false, &c, nil
+	}
+
+	f.deleteAppointment(appointment)
+	return true, nil, nil
+}
+
+// deleteAppointment removes an appointment, leaving a tombstone like the
+// database does. f.mu needs to be held.
+func (f *fakeStore) deleteAppointment(appointment ksuid.KSUID) bool {
+	for i, a := range f.appointments {
+		if a.ID == appointment {
+			f.appointments = append(f.appointments[:i], f.appointments[i+1:]...)
+			f.tombstones = append(f.tombstones, &AppointmentTombstone{
+				ID:            a.ID,
+				Queue:         a.Queue,
+				ScheduledTime: a.ScheduledTime,
+				RemovedAt:     time.Now(),
+			})
+			return true
+		}
+	}
+	return false
+}
+
+func (f *fakeStore) GetAppointmentsSince(ctx context.Context, queue ksuid.KSUID, since time.Time) ([]*AppointmentSlot, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var appointments []*AppointmentSlot
+	for _, a := range f.appointments {
+		if a.Queue == queue && a.UpdatedAt.After(since) {
+			c := *a
+			appointments = append(appointments, &c)
+		}
+	}
+	return appointments, nil
+}
+
+func (f *fakeStore) GetAppointmentTombstonesSince(ctx context.Context, queue ksuid.KSUID, since time.Time) ([]*AppointmentTombstone, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var tombstones []*AppointmentTombstone
+	for _, t := range f.tombstones {
+		if t.Queue == queue && t.RemovedAt.After(since) {
+			c := *t
+			tombstones = append(tombstones, &c)
+		}
+	}
+	return tombstones, nil
+}
+
+func (f *fakeStore) GetAppointmentPartners(ctx context.Context, appointment ksuid.KSUID) ([]string, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]string(nil), f.partners[appointment]...), nil
+}
+
+func (f *fakeStore) AddAppointmentPartners(ctx context.Context, appointment ksuid.KSUID, partners []string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.partners[appointment] = append(f.partners[appointment], partners...)
+	return nil
+}
+
+func (f *fakeStore) GetAppointmentLabels(ctx context.Context, appointment ksuid.KSUID) ([]string, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]string(nil), f.labels[appointment]...), nil
+}
+
+func (f *fakeStore) SetAppointmentLabels(ctx context.Context, appointment ksuid.KSUID, labels []string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.labels[appointment] = append([]string(nil), labels...)
+	return nil
+}
+
+func (f *fakeStore) GetAppointmentLabelsInTimeFrame(ctx context.Context, queue ksuid.KSUID, from, to time.Time) (map[ksuid.KSUID][]string, error) {
+	appointments, err := f.GetAppointments(ctx, queue, from, to)
+	if err != nil {
+		return nil, err
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	labels := make(map[ksuid.KSUID][]string)
+	for _, a := range appointments {
+		if l, ok := f.labels[a.ID]; ok {
+			labels[a.ID] = append([]string(nil), l...)
+		}
+	}
+	return labels, nil
+}
+
+func (f *fakeStore) GetAppointmentCustomFieldsInTimeFrame(ctx context.Context, queue ksuid.KSUID, from, to time.Time) (map[ksuid.KSUID]map[string]interface{}, error) {
+	appointments, err := f.GetAppointments(ctx, queue, from, to)
+	if err != nil {
+		return nil, err
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	fields := make(map[ksuid.KSUID]map[string]interface{})
+	for _, a := range appointments {
+		if c, ok := f.customFields[a.ID]; ok {
+			fields[a.ID] = c
+		}
+	}
+	return fields, nil
+}
+
+func (f *fakeStore) SetAppointmentCustomFields(ctx context.Context, appointment ksuid.KSUID, fields map[string]interface{}) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.customFields[appointment] = fields
+	return nil
+}
+
+func (f *fakeStore) GetCalendarEvents(ctx context.Context, appointment ksuid.KSUID) ([]*CalendarEventLink, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]*CalendarEventLink(nil), f.calendarEvents[appointment]...), nil
+}
+
+func (f *fakeStore) SetCalendarEvent(ctx context.Context, appointment ksuid.KSUID, email, eventID string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.calendarEvents[appointment] = append(f.calendarEvents[appointment], &CalendarEventLink{
+		Appointment: appointment,
+		Email:       email,
+		EventID:     eventID,
+	})
+	return nil
+}
+
+func (f *fakeStore) RemoveCalendarEvents(ctx context.Context, appointment ksuid.KSUID) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	delete(f.calendarEvents, appointment)
+	return nil
+}
+
+func (f *fakeStore) GetGoogleCalendarToken(ctx context.Context, email string) (*oauth2.Token, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	token, ok := f.calendarTokens[email]
+	if !ok {
+		return nil, sql.ErrNoRows
+	}
+	return token, nil
+}
+
+func (f *fakeStore) GetAppointmentsForUser(ctx context.Context, queue ksuid.KSUID, from, to time.Time, email string) ([]*AppointmentSlot, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var appointments []*AppointmentSlot
+	for _, a := range f.appointments {
+		if a.Queue != queue || a.ScheduledTime.Before(from) || a.ScheduledTime.After(to) {
+			continue
+		}
+
+		mine := a.StudentEmail != nil && *a.StudentEmail == email
+		for _, p := range f.partners[a.ID] {
+			mine = mine || p == email
+		}
+		if mine {
+			c := *a
+			appointments = append(appointments, &c)
+		}
+	}
+	sortAppointments(appointments)
+	return appointments, nil
+}
+
+func (f *fakeStore) GetUpcomingAppointmentsForUser(ctx context.Context, email string, from time.Time) ([]*AppointmentSlot, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var appointments []*AppointmentSlot
+	for _, a := range f.appointments {
+		if a.ScheduledTime.Before(from) {
+			continue
+		}
+
+		mine := a.StudentEmail != nil && *a.StudentEmail == email
+		for _, p := range f.partners[a.ID] {
+			mine = mine || p == email
+		}
+		if mine {
+			c := *a
+			appointments = append(appointments, &c)
+		}
+	}
+	sort.Slice(appointments, func(i, j int) bool {
+		return appointments[i].ScheduledTime.Before(appointments[j].ScheduledTime)
+	})
+	return appointments, nil
+}
+
+// GetActiveAppointmentCount counts like the database's query does: booked
+// appointments that haven't ended, split on whether they've started.
+func (f *fakeStore) GetActiveAppointmentCount(ctx context.Context, queue ksuid.KSUID, now time.Time) (*ActiveAppointmentCount, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var count ActiveAppointmentCount
+	for _, a := range f.appointments {
+		end := a.ScheduledTime.Add(time.Duration(a.Duration) * time.Minute)
+		if a.Queue != queue || a.StudentEmail == nil || !end.After(now) {
+			continue
+		}
+		if a.ScheduledTime.After(now) {
+			count.Upcoming++
+		} else {
+			count.Current++
+		}
+	}
+	return &count, nil
+}
+
+// UserInQueueRoster lets everyone into queues without a roster in
+// rosters.
+func (f *fakeStore) UserInQueueRoster(ctx context.Context, queue ksuid.KSUID, email string) (bool, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	roster, ok := f.rosters[queue]
+	if !ok {
+		return true, nil
+	}
+	return roster[email], nil
+}
+
+func (f *fakeStore) UpdateQueueRoster(ctx context.Context, queue ksuid.KSUID, students []string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	roster := make(map[string]bool, len(students))
+	for _, s := range students {
+		roster[s] = true
+	}
+	f.rosters[queue] = roster
+	return nil
+}
+
+func (f *fakeStore) TeammateHasAppointment(ctx context.Context, queue ksuid.KSUID, from, to time.Time, email string) (bool, error) {
+	return false, nil
+}
+
+func (f *fakeStore) LockAppointmentSignups(ctx context.Context, queue ksuid.KSUID, email string) error {
+	return nil
+}
+
+// UnclaimAppointment deletes claims without a student, and takes the
+// staff member off ones with a student.
+func (f *fakeStore) UnclaimAppointment(ctx context.Context, appointment ksuid.KSUID) (bool, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	a := f.appointment(appointment)
+	if a == nil {
+		return false, sql.ErrNoRows
+	}
+	if a.StudentEmail != nil {
+		a.StaffEmail = nil
+		a.UpdatedAt = time.Now()
+		return false, nil
+	}
+	return f.deleteAppointment(appointment), nil
+}
+
+func (f *fakeStore) AddClaimEvent(ctx context.Context, event *ClaimEvent) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	c := *event
+	c.ID = ksuid.New()
+	c.CreatedAt = time.Now()
+	f.claimEvents = append(f.claimEvents, &c)
+	return nil
+}
+
+func (f *fakeStore) GetClaimEvents(ctx context.Context, queue ksuid.KSUID, from, to time.Time) ([]*ClaimEvent, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var events []*ClaimEvent
+	for _, e := range f.claimEvents {
+		if e.Queue == queue && !e.ScheduledTime.Before(from) && e.ScheduledTime.Before(to) {
+			events = append(events, e)
+		}
+	}
+	return events, nil
+}
+
+// ClaimTimeslot claims the first appointment at the timeslot with a
+// student and no staff member, or else makes a new claim.
+func (f *fakeStore) ClaimTimeslot(ctx context.Context, queue ksuid.KSUID, day, timeslot int, email string) (*AppointmentSlot, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	start, end := WeekdayBounds(day)
+	for _, a := range f.appointments {
+		if a.Queue == queue && a.Timeslot == timeslot && !a.ScheduledTime.Before(start) && a.ScheduledTime.Before(end) &&
+			a.StudentEmail != nil && a.StaffEmail == nil {
+			a.StaffEmail = &email
+			a.UpdatedAt = time.Now()
+			c := *a
+			return &c, nil
+		}
+	}
+	c := *f.claim(f.queues[queue], day, timeslot, email)
+	return &c, nil
+}
+
+func (f *fakeStore) SetAppointmentMeetingLink(ctx context.Context, appointment ksuid.KSUID, link *string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	a := f.appointment(appointment)
+	if a == nil {
+		return sql.ErrNoRows
+	}
+	a.MeetingLink = link
+	return nil
+}
+
+func (f *fakeStore) SetAppointmentResolved(ctx context.Context, appointment ksuid.KSUID, resolved *bool) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	a := f.appointment(appointment)
+	if a == nil {
+		return sql.ErrNoRows
+	}
+	a.Resolved = resolved
+	a.UpdatedAt = time.Now()
+	return nil
+}
+
+func (f *fakeStore) GetUnresolvedAppointments(ctx context.Context, queue ksuid.KSUID) ([]*AppointmentSlot, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var appointments []*AppointmentSlot
+	for _, a := range f.appointments {
+		if a.Queue == queue && a.StudentEmail != nil && a.Resolved != nil && !*a.Resolved {
+			c := *a
+			appointments = append(appointments, &c)
+		}
+	}
+	sort.Slice(appointments, func(i, j int) bool {
+		return appointments[i].ScheduledTime.Before(appointments[j].ScheduledTime)
+	})
+	return appointments, nil
+}
+
+func (f *fakeStore) AttachMeetingLink(ctx context.Context, appointment ksuid.KSUID, student, link string) (bool, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	a := f.appointment(appointment)
+	if a == nil || a.StudentEmail == nil || *a.StudentEmail != student || a.MeetingLink != nil {
+		return false, nil
+	}
+	a.MeetingLink = &link
+	a.UpdatedAt = time.Now()
+	return true, nil
+}
+
+func (f *fakeStore) GetTimeslotAssignments(ctx context.Context, queue ksuid.KSUID, day int) (map[int]string, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	assignments := make(map[int]string)
+	for timeslot, email := range f.assignments[queue][day] {
+		assignments[timeslot] = email
+	}
+	return assignments, nil
+}
+
+func (f *fakeStore) SendMessage(ctx context.Context, queue ksuid.KSUID, content, from, to string) (*Message, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	m := &Message{
+		ID:       ksuid.New(),
+		Queue:    queue,
+		Content:  content,
+		Sender:   from,
+		Receiver: to,
+	}
+	f.messages = append(f.messages, m)
+	return m, nil
+}
+
+func (f *fakeStore) AddToWaitlist(ctx context.Context, queue ksuid.KSUID, scheduledTime time.Time, timeslot int, email string) (bool, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, e := range f.waitlist {
+		if e.Queue == queue && e.ScheduledTime.Equal(scheduledTime) && e.Email == email {
+			return false, nil
+		}
+	}
+	f.waitlist = append(f.waitlist, &WaitlistEntry{
+		ID:            ksuid.New(),
+		Queue:         queue,
+		ScheduledTime: scheduledTime,
+		Timeslot:      timeslot,
+		Email:         email,
+	})
+	return true, nil
+}
+
+func (f *fakeStore) RemoveFromWaitlist(ctx context.Context, queue ksuid.KSUID, scheduledTime time.Time, email string) (bool, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for i, e := range f.waitlist {
+		if e.Queue == queue && e.ScheduledTime.Equal(scheduledTime) && e.Email == email {
+			f.waitlist = append(f.waitlist[:i], f.waitlist[i+1:]...)
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// GetWaitlist returns entries in the order they were added, which is the
+// order the IDs would put them in if they weren't made in the same second.
+func (f *fakeStore) GetWaitlist(ctx context.Context, queue ksuid.KSUID, scheduledTime time.Time) ([]*WaitlistEntry, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	entries := make([]*WaitlistEntry, 0)
+	for _, e := range f.waitlist {
+		if e.Queue == queue && e.ScheduledTime.Equal(scheduledTime) {
+			entries = append(entries, e)
+		}
+	}
+	return entries, nil
+}
+
+func (f *fakeStore) PopWaitlist(ctx context.Context, queue ksuid.KSUID, scheduledTime time.Time) (*WaitlistEntry, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for i, e := range f.waitlist {
+		if e.Queue == queue && e.ScheduledTime.Equal(scheduledTime) {
+			f.waitlist = append(f.waitlist[:i], f.waitlist[i+1:]...)
+			return e, nil
+		}
+	}
+	return nil, sql.ErrNoRows
+}
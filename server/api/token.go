@@ -0,0 +1,83 @@
+package api
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	errTokenMalformed = errors.New("malformed token")
+	errTokenInvalid   = errors.New("token signature is invalid")
+	errTokenExpired   = errors.New("token has expired")
+)
+
+// A signedToken lets us hand out links that act on a single resource
+// without requiring a login. The subject (usually an ID) and the expiry
+// are readable by anyone holding the token; everything else that's bound
+// into the signature (the purpose, and anything the caller passes as
+// bound) stays on our end, so we can check that it still matches when
+// the token comes back.
+type signedToken struct {
+	Subject string
+	Expires time.Time
+	mac     []byte
+}
+
+func (s *Server) tokenMAC(purpose, subject string, expires time.Time, bound string) []byte {
+	m := hmac.New(sha256.New, s.tokenKey)
+	m.Write([]byte(purpose + separator + subject + separator + strconv.FormatInt(expires.Unix(), 10) + separator + bound))
+	return m.Sum(nil)
+}
+
+// newSignedToken creates a URL-safe token for subject, valid for the
+// given purpose until expires.
+func (s *Server) newSignedToken(purpose, subject string, expires time.Time, bound string) string {
+	mac := s.tokenMAC(purpose, subject, expires, bound)
+	return subject + "." + strconv.FormatInt(expires.Unix(), 10) + "." + base64.RawURLEncoding.EncodeToString(mac)
+}
+
+// parseSignedToken reads a token without verifying it; the caller
+// is expected to look up whatever the subject refers to and then
+// call verifySignedToken.
+func parseSignedToken(token string) (*signedToken, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, errTokenMalformed
+	}
+
+	expires, err := strconv.ParseInt(parts[1], 10, 64)
+	if err != nil {
+		return nil, errTokenMalformed
+	}
+
+	mac, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, errTokenMalformed
+	}
+
+	return &signedToken{
+		Subject: parts[0],
+		Expires: time.Unix(expires, 0),
+		mac:     mac,
+	}, nil
+}
+
+// verifySignedToken checks the signature before the expiry so that
+// someone bumping the expiry on an old token gets told it's invalid
+// rather than expired.
+func (s *Server) verifySignedToken(t *signedToken, purpose, bound string) error {
+	if !hmac.Equal(t.mac, s.tokenMAC(purpose, t.Subject, t.Expires, bound)) {
+		return errTokenInvalid
+	}
+
+	if time.Now().After(t.Expires) {
+		return errTokenExpired
+	}
+
+	return nil
+}
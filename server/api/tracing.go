@@ -0,0 +1,65 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"net/http"
+
+	"github.com/go-chi/chi"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// tracer comes from the global OpenTelemetry tracer provider, which does
+// nothing until something (like main) registers a real one.
+var tracer = otel.Tracer("github.com/CarsonHoffman/office-hours-queue/server/api")
+
+// tracingMiddleware starts a span for each request, continuing the
+// caller's trace if the request came with one.
+func tracingMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := otel.GetTextMapPropagator().Extract(r.Context(), propagation.HeaderCarrier(r.Header))
+		ctx, span := tracer.Start(ctx, r.Method, trace.WithSpanKind(trace.SpanKindServer))
+		defer span.End()
+
+		recorder := &StatusRecorder{
+			ResponseWriter: w,
+			Status:         200,
+		}
+		next.ServeHTTP(recorder, r.WithContext(ctx))
+
+		// The route is only known once chi has matched it.
+		route := chi.RouteContext(r.Context()).RoutePattern()
+		span.SetName(r.Method + " " + route)
+		span.SetAttributes(
+			attribute.String("http.method", r.Method),
+			attribute.String("http.route", route),
+			attribute.Int("http.status_code", recorder.Status),
+		)
+		if recorder.Status >= 500 {
+			span.SetStatus(codes.Error, http.StatusText(recorder.Status))
+		}
+	})
+}
+
+// startSpan starts a span for part of handling a request.
+func startSpan(ctx context.Context, name string) (context.Context, trace.Span) {
+	return tracer.Start(ctx, name)
+}
+
+// endSpan ends a span, noting err on it if there was one. StatusErrors
+// are the user's problem rather than ours, so they don't mark the span
+// as failed.
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		var se StatusError
+		if !errors.As(err, &se) || se.status >= 500 {
+			span.SetStatus(codes.Error, err.Error())
+		}
+	}
+	span.End()
+}
@@ -0,0 +1,176 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// recordedSpan keeps track of what happened to a span. Everything it
+// doesn't record goes to a span that does nothing.
+type recordedSpan struct {
+	trace.Span
+	name   string
+	parent *recordedSpan
+	remote trace.SpanContext
+	status codes.Code
+	errs   []error
+	ended  bool
+}
+
+func (s *recordedSpan) SetName(name string)                           { s.name = name }
+func (s *recordedSpan) SetStatus(code codes.Code, description string) { s.status = code }
+func (s *recordedSpan) End(options ...trace.SpanEndOption)            { s.ended = true }
+
+func (s *recordedSpan) RecordError(err error, options ...trace.EventOption) {
+	s.errs = append(s.errs, err)
+}
+
+// spanRecorder is a tracer provider that keeps every span started with
+// it, like an in-memory exporter would.
+type spanRecorder struct {
+	mu    sync.Mutex
+	spans []*recordedSpan
+}
+
+func (r *spanRecorder) Tracer(name string, options ...trace.TracerOption) trace.Tracer {
+	return r
+}
+
+func (r *spanRecorder) Start(ctx context.Context, name string, options ...trace.SpanStartOption) (context.Context, trace.Span) {
+	span := &recordedSpan{
+		Span:   trace.SpanFromContext(context.Background()),
+		name:   name,
+		remote: trace.SpanContextFromContext(ctx),
+	}
+	if parent, ok := trace.SpanFromContext(ctx).(*recordedSpan); ok {
+		span.parent = parent
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.spans = append(r.spans, span)
+	return trace.ContextWithSpan(ctx, span), span
+}
+
+func (r *spanRecorder) span(name string) *recordedSpan {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, span := range r.spans {
+		if span.name == name {
+			return span
+		}
+	}
+	return nil
+}
+
+var (
+	recorderOnce sync.Once
+	recorder     = &spanRecorder{}
+)
+
+// recordSpans starts recording spans from the package's tracer. The
+// global tracer provider only hands off to the first one registered, so
+// every test shares one recorder, emptied each time.
+func recordSpans() *spanRecorder {
+	recorderOnce.Do(func() {
+		otel.SetTracerProvider(recorder)
+		otel.SetTextMapPropagator(propagation.TraceContext{})
+	})
+	recorder.mu.Lock()
+	defer recorder.mu.Unlock()
+	recorder.spans = nil
+	return recorder
+}
+
+func TestSignupSpans(t *testing.T) {
+	spans := recordSpans()
+	s := newTestServer()
+	store := newFakeStore()
+	q := store.addQueue(&QueueConfiguration{})
+	tomorrow := int(time.Now().Local().Add(24 * time.Hour).Weekday())
+
+	w := signupRequest(s, store, q, tomorrow, "student@example.com", 10, 1)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("got status %d, want %d: %s", w.Code, http.StatusCreated, w.Body.String())
+	}
+	for _, name := range []string{"checkSignupCapacity", "SignupForAppointment"} {
+		span := spans.span(name)
+		if span == nil {
+			t.Errorf("no %s span", name)
+			continue
+		}
+		if !span.ended {
+			t.Errorf("%s span never ended", name)
+		}
+		if span.status == codes.Error || len(span.errs) != 0 {
+			t.Errorf("got %s span with status %v and errors %v, want neither", name, span.status, span.errs)
+		}
+	}
+
+	// A full timeslot is the student's problem, not ours, so the span
+	// notes it without failing.
+	spans = recordSpans()
+	w = signupRequest(s, store, q, tomorrow, "other@example.com", 10, 1)
+	if w.Code != http.StatusConflict {
+		t.Fatalf("got status %d, want %d", w.Code, http.StatusConflict)
+	}
+	span := spans.span("checkSignupCapacity")
+	if span == nil {
+		t.Fatal("no checkSignupCapacity span")
+	}
+	if span.status == codes.Error || len(span.errs) != 1 {
+		t.Errorf("got status %v and errors %v, want one error and no failure", span.status, span.errs)
+	}
+	if spans.span("SignupForAppointment") != nil {
+		t.Error("got SignupForAppointment span for a signup that never reached the database")
+	}
+}
+
+func TestTracingMiddleware(t *testing.T) {
+	spans := recordSpans()
+	router := chi.NewRouter()
+	router.Use(tracingMiddleware)
+	router.Get("/queues/{id}", func(w http.ResponseWriter, r *http.Request) {
+		_, span := startSpan(r.Context(), "inner")
+		endSpan(span, errors.New("database is down"))
+		w.WriteHeader(http.StatusInternalServerError)
+	})
+
+	traceID := "4bf92f3577b34da6a3ce929d0e0e4736"
+	r := httptest.NewRequest(http.MethodGet, "/queues/abc", nil)
+	r.Header.Set("traceparent", "00-"+traceID+"-00f067aa0ba902b7-01")
+	router.ServeHTTP(httptest.NewRecorder(), r)
+
+	server := spans.span("GET /queues/{id}")
+	if server == nil {
+		t.Fatal("no span named after the route")
+	}
+	if got := server.remote.TraceID().String(); got != traceID {
+		t.Errorf("got trace ID %s, want the caller's %s", got, traceID)
+	}
+	if !server.ended || server.status != codes.Error {
+		t.Errorf("got server span ended %t with status %v, want ended with an error", server.ended, server.status)
+	}
+
+	inner := spans.span("inner")
+	if inner == nil {
+		t.Fatal("no span from the handler")
+	}
+	if inner.parent != server {
+		t.Error("handler's span isn't under the request's")
+	}
+	if inner.status != codes.Error {
+		t.Errorf("got status %v for an unexpected error, want %v", inner.status, codes.Error)
+	}
+}
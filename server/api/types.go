@@ -32,6 +32,38 @@ type Queue struct {
 	Active   bool        `json:"active" db:"active"`
 }
 
+// AppointmentLocationType determines where an appointments queue's
+// appointments happen.
+type AppointmentLocationType string
+
+const (
+	AppointmentLocationInPerson AppointmentLocationType = "in_person"
+	// Remote appointments get a meeting link set up when they're booked.
+	AppointmentLocationRemote AppointmentLocationType = "remote"
+)
+
+// FutureAppointmentScope determines which of a student's existing
+// appointments stop them from signing up for another one.
+type FutureAppointmentScope string
+
+const (
+	// Any upcoming appointment on the queue blocks a new one.
+	FutureAppointmentScopeQueue FutureAppointmentScope = "queue"
+	// Only an upcoming appointment on the same day blocks a new one.
+	FutureAppointmentScopeDay FutureAppointmentScope = "day"
+)
+
+// AvailabilityDisplay determines how much students get told about how
+// many appointment slots are left.
+type AvailabilityDisplay string
+
+const (
+	AvailabilityDisplayExact AvailabilityDisplay = "exact"
+	// Students only see whether a timeslot is open, limited, or full, so
+	// they can't watch the exact counts and time their signups around them.
+	AvailabilityDisplayCoarse AvailabilityDisplay = "coarse"
+)
+
 type QueueConfiguration struct {
 	ID                  ksuid.KSUID `json:"id" db:"id"`
 	EnableLocationField bool        `json:"enable_location_field" db:"enable_location_field"`
@@ -43,6 +75,89 @@ type QueueConfiguration struct {
 	Virtual             bool        `json:"virtual" db:"virtual"`
 	Scheduled           bool        `json:"scheduled" db:"scheduled"`
 	ManualOpen          bool        `json:"manual_open" db:"manual_open"`
+
+	AppointmentSettings
+}
+
+// AppointmentSettings are the parts of a queue's configuration that only
+// matter for appointments queues. They're flattened into the rest of the
+// configuration, but can also be read and written on their own.
+type AppointmentSettings struct {
+	FutureAppointmentScope          FutureAppointmentScope  `json:"future_appointment_scope" db:"future_appointment_scope"`
+	AppointmentLocationType         AppointmentLocationType `json:"appointment_location_type" db:"appointment_location_type"`
+	AvailabilityDisplay             AvailabilityDisplay     `json:"availability_display" db:"availability_display"`
+	MaxConcurrentAppointments       int                     `json:"max_concurrent_appointments" db:"max_concurrent_appointments"`
+	ShowTimeslotMembers             bool                    `json:"show_timeslot_members" db:"show_timeslot_members"`
+	DisallowSameDayReschedule       bool                    `json:"disallow_same_day_reschedule" db:"disallow_same_day_reschedule"`
+	OptionalAppointmentDescription  bool                    `json:"optional_appointment_description" db:"optional_appointment_description"`
+	OptionalAppointmentLocation     bool                    `json:"optional_appointment_location" db:"optional_appointment_location"`
+	RequireScheduleApproval         bool                    `json:"require_schedule_approval" db:"require_schedule_approval"`
+	MaxAppointmentFieldLength       int                     `json:"max_appointment_field_length" db:"max_appointment_field_length"`
+	MinAppointmentDescriptionLength int                     `json:"min_appointment_description_length" db:"min_appointment_description_length"`
+	ClaimGraceMinutes               int                     `json:"claim_grace_minutes" db:"claim_grace_minutes"`
+	DescriptionTemplate             string                  `json:"description_template" db:"description_template"`
+	RequireDescriptionTemplate      bool                    `json:"require_description_template" db:"require_description_template"`
+	SignupsFrozen                   bool                    `json:"signups_frozen" db:"signups_frozen"`
+	RequireSignupTimeConfirmation   bool                    `json:"require_signup_time_confirmation" db:"require_signup_time_confirmation"`
+	FullyBookedMessage              string                  `json:"fully_booked_message" db:"fully_booked_message"`
+	Waitlist                        bool                    `json:"waitlist" db:"waitlist"`
+	AutoWaitlist                    bool                    `json:"auto_waitlist" db:"auto_waitlist"`
+}
+
+// An AppointmentFlag is one of the settings that turns an appointment
+// behaviour on or off. Some only make sense with others on too, so
+// they're checked together rather than one setting at a time.
+type AppointmentFlag string
+
+const (
+	// Students can wait for a spot to open up in a full timeslot.
+	AppointmentFlagWaitlist AppointmentFlag = "waitlist"
+	// Signing up for a full timeslot joins its waitlist instead.
+	AppointmentFlagAutoWaitlist AppointmentFlag = "auto_waitlist"
+	// Schedule changes wait for another admin to approve them.
+	AppointmentFlagScheduleApproval AppointmentFlag = "require_schedule_approval"
+	// Students can't sign up, reschedule, or join waitlists.
+	AppointmentFlagSignupsFrozen AppointmentFlag = "signups_frozen"
+)
+
+// Flags reports which of the behaviours are on.
+func (a *AppointmentSettings) Flags() map[AppointmentFlag]bool {
+	return map[AppointmentFlag]bool{
+		AppointmentFlagWaitlist:         a.Waitlist,
+		AppointmentFlagAutoWaitlist:     a.AutoWaitlist,
+		AppointmentFlagScheduleApproval: a.RequireScheduleApproval,
+		AppointmentFlagSignupsFrozen:    a.SignupsFrozen,
+	}
+}
+
+// appointmentFlagRequirements lists the flags that need another one on
+// to do anything.
+var appointmentFlagRequirements = []struct {
+	flag, needs AppointmentFlag
+}{
+	{AppointmentFlagAutoWaitlist, AppointmentFlagWaitlist},
+}
+
+// unmetFlagRequirement returns the first flag that's on without a flag it
+// needs, along with the one it needs, or empty strings if there isn't one.
+func unmetFlagRequirement(flags map[AppointmentFlag]bool) (flag, needs AppointmentFlag) {
+	for _, r := range appointmentFlagRequirements {
+		if flags[r.flag] && !flags[r.needs] {
+			return r.flag, r.needs
+		}
+	}
+	return "", ""
+}
+
+// A WaitlistEntry is a student waiting for a spot to open up in a full
+// timeslot. Entries are kept by scheduled time, so a waitlist goes with
+// that one occurrence of the timeslot rather than every week's.
+type WaitlistEntry struct {
+	ID            ksuid.KSUID `json:"id" db:"id"`
+	Queue         ksuid.KSUID `json:"queue" db:"queue"`
+	ScheduledTime time.Time   `json:"scheduled_time" db:"scheduled_time"`
+	Timeslot      int         `json:"timeslot" db:"timeslot"`
+	Email         string      `json:"email" db:"email"`
 }
 
 type Announcement struct {
@@ -168,6 +283,82 @@ type AppointmentSchedule struct {
 	Duration int          `json:"duration" db:"duration"`
 	Padding  int          `json:"padding" db:"padding"`
 	Schedule string       `json:"schedule" db:"schedule"`
+
+	// Minutes after the day's first open timeslot starts that signups for
+	// the day close, so there are no walk-ups late in the day. 0 means
+	// signups stay open.
+	SignupCutoff int `json:"signup_cutoff" db:"signup_cutoff"`
+
+	// Minutes before the start of the day (midnight) that signups for the
+	// day open; 720 opens them at noon the day before. 0 means signups
+	// are always open. It's relative rather than a timestamp since the
+	// schedule repeats every week, and a fixed time would only be right
+	// for one of them.
+	SignupsOpen int `json:"signups_open" db:"signups_open"`
+
+	// Instructor notes for students booking particular timeslots (like
+	// "bring your laptop"), by timeslot. Kept in their own table.
+	Notes map[int]string `json:"notes,omitempty" db:"-"`
+
+	// Where appointments at particular timeslots happen if the student
+	// doesn't say, by timeslot. Also kept in their own table.
+	Locations map[int]string `json:"locations,omitempty" db:"-"`
+}
+
+// DecodedTimeslot is one character of a schedule string spelled out, for
+// admins who'd rather not count characters.
+type DecodedTimeslot struct {
+	Timeslot      int       `json:"timeslot"`
+	ScheduledTime time.Time `json:"scheduled_time"`
+	Capacity      int       `json:"capacity"`
+}
+
+// FirstOpenTimeslot returns the first timeslot on the schedule that has
+// any room, or -1 if there isn't one.
+func (s *AppointmentSchedule) FirstOpenTimeslot() int {
+	for i, n := range s.Schedule {
+		if n > '0' {
+			return i
+		}
+	}
+	return -1
+}
+
+// LastOpenTimeslot returns the last timeslot on the schedule that has any
+// room, or -1 if there isn't one.
+func (s *AppointmentSchedule) LastOpenTimeslot() int {
+	for i := len(s.Schedule) - 1; i >= 0; i-- {
+		if s.Schedule[i] > '0' {
+			return i
+		}
+	}
+	return -1
+}
+
+// WeeklyTimeBounds is the span of the day that a queue's appointments can
+// fall in on any day of the week, in minutes after midnight. Both are nil
+// if no day has an open timeslot.
+type WeeklyTimeBounds struct {
+	Start *int `json:"start"`
+	End   *int `json:"end"`
+}
+
+// A PendingScheduleChange is an appointment schedule update waiting on
+// approval from an admin other than the one who proposed it. There's at
+// most one per day; proposing another replaces it.
+type PendingScheduleChange struct {
+	AppointmentSchedule
+	ProposedBy string    `json:"proposed_by" db:"proposed_by"`
+	CreatedAt  time.Time `json:"created_at" db:"created_at"`
+}
+
+// A PendingApprovalRemoval is a request to stop requiring approval for
+// schedule changes. Like a schedule change, it needs approval from an
+// admin other than the one who asked; until then, approval stays on.
+type PendingApprovalRemoval struct {
+	Queue      ksuid.KSUID `json:"queue" db:"queue"`
+	ProposedBy string      `json:"proposed_by" db:"proposed_by"`
+	CreatedAt  time.Time   `json:"created_at" db:"created_at"`
 }
 
 type AppointmentSlot struct {
@@ -183,6 +374,41 @@ type AppointmentSlot struct {
 	Description   *string     `json:"description,omitempty" db:"description"`
 	MapX          *float32    `json:"map_x,omitempty" db:"map_x"`
 	MapY          *float32    `json:"map_y,omitempty" db:"map_y"`
+	UpdatedAt     time.Time   `json:"updated_at" db:"updated_at"`
+	MeetingLink   *string     `json:"meeting_link,omitempty" db:"meeting_link"`
+	// Hides the student's name from other students in the same timeslot.
+	// Staff still see it.
+	AnonymousToPeers bool `json:"anonymous_to_peers" db:"anonymous_to_peers"`
+	// How many consecutive timeslots the appointment takes up, starting
+	// at Timeslot. Duration covers all of them.
+	SlotSpan int `json:"slot_span" db:"slot_span"`
+	// Whether staff got the student's question sorted out during the
+	// appointment; nil until someone marks it.
+	Resolved *bool `json:"resolved,omitempty" db:"resolved"`
+	// Answers to the queue's custom fields, keyed by field name; kept in
+	// their own table.
+	CustomFields map[string]interface{} `json:"custom_fields,omitempty" db:"-"`
+
+	// Other students sharing the appointment; kept in their own table.
+	Partners []string `json:"partners,omitempty" db:"-"`
+	// Staff-only categories for the appointment; also in their own table.
+	Labels []string `json:"labels,omitempty" db:"-"`
+	// Who else is booked at the same time, for students looking at their
+	// own appointments.
+	Group *TimeslotGroup `json:"group,omitempty" db:"-"`
+	// How far off the appointment is, from the server's clock. Only filled
+	// in when the client asks with ?relative=true, since they go stale.
+	Relative        string `json:"relative,omitempty" db:"-"`
+	StartsInSeconds *int64 `json:"starts_in_seconds,omitempty" db:"-"`
+}
+
+// TimeslotGroup describes the students sharing a timeslot. Position is
+// 1-based. Members (the other students' names) is only filled in if the
+// queue shows them.
+type TimeslotGroup struct {
+	Size     int      `json:"size"`
+	Position int      `json:"position"`
+	Members  []string `json:"members,omitempty"`
 }
 
 func (a *AppointmentSlot) MarshalJSON() ([]byte, error) {
@@ -190,9 +416,13 @@ func (a *AppointmentSlot) MarshalJSON() ([]byte, error) {
 	a.ScheduledTime = a.ScheduledTime.In(time.Local)
 	return json.Marshal(struct {
 		IDTimestamp string `json:"id_timestamp"`
+		// Saves clients working out which day's schedule the
+		// timeslot refers to.
+		Day time.Weekday `json:"day"`
 		*AppointmentSlotWithTimestamp
 	}{
 		IDTimestamp:                  a.ID.Time().Format(time.RFC3339),
+		Day:                          a.ScheduledTime.Weekday(),
 		AppointmentSlotWithTimestamp: (*AppointmentSlotWithTimestamp)(a),
 	})
 }
@@ -204,11 +434,292 @@ func (a *AppointmentSlot) Anonymized() *AppointmentSlot {
 		ScheduledTime: a.ScheduledTime,
 		Timeslot:      a.Timeslot,
 		Duration:      a.Duration,
+		UpdatedAt:     a.UpdatedAt,
+		SlotSpan:      a.SlotSpan,
 	}
 }
 
+// Span is how many timeslots the appointment takes up, counting
+// appointments from before spans existed as one.
+func (a *AppointmentSlot) Span() int {
+	if a.SlotSpan < 1 {
+		return 1
+	}
+	return a.SlotSpan
+}
+
+// NoStaffEmail is the appointment as its student sees it, without who's
+// seeing them or whether staff thought it resolved their question.
 func (a *AppointmentSlot) NoStaffEmail() *AppointmentSlot {
 	newAppointment := *a
 	newAppointment.StaffEmail = nil
+	newAppointment.Resolved = nil
 	return &newAppointment
 }
+
+// TimeslotAvailability describes how much room is left at one timeslot
+// of a day's appointment schedule.
+// The counts are left out when the queue only shows coarse availability
+// to students.
+type TimeslotAvailability struct {
+	Timeslot      int                `json:"timeslot"`
+	ScheduledTime time.Time          `json:"scheduled_time"`
+	Status        AvailabilityStatus `json:"status"`
+	Capacity      *int               `json:"capacity,omitempty"`
+	Taken         *int               `json:"taken,omitempty"`
+	Open          *int               `json:"open,omitempty"`
+	Note          string             `json:"note,omitempty"`
+}
+
+type AvailabilityStatus string
+
+const (
+	AvailabilityOpen    AvailabilityStatus = "open"
+	AvailabilityLimited AvailabilityStatus = "limited"
+	AvailabilityFull    AvailabilityStatus = "full"
+)
+
+func (t *TimeslotAvailability) Coarse() *TimeslotAvailability {
+	return &TimeslotAvailability{
+		Timeslot:      t.Timeslot,
+		ScheduledTime: t.ScheduledTime,
+		Status:        t.Status,
+		Note:          t.Note,
+	}
+}
+
+type ClaimAction string
+
+const (
+	ClaimActionClaim   ClaimAction = "claim"
+	ClaimActionUnclaim ClaimAction = "unclaim"
+)
+
+// A ClaimEvent records a staff member claiming or unclaiming an
+// appointment. Email is whoever did it, and StaffEmail is whose claim it
+// was (for claims, they're the same). Events outlive the appointments
+// they refer to.
+type ClaimEvent struct {
+	ID            ksuid.KSUID `json:"id" db:"id"`
+	Queue         ksuid.KSUID `json:"queue" db:"queue"`
+	Appointment   ksuid.KSUID `json:"appointment" db:"appointment"`
+	ScheduledTime time.Time   `json:"scheduled_time" db:"scheduled_time"`
+	Timeslot      int         `json:"timeslot" db:"timeslot"`
+	Action        ClaimAction `json:"action" db:"action"`
+	Email         string      `json:"email" db:"email"`
+	StaffEmail    string      `json:"staff_email" db:"staff_email"`
+	CreatedAt     time.Time   `json:"created_at" db:"created_at"`
+}
+
+// Reasons a timeslot matched by a claim pattern was left alone.
+const (
+	PatternClaimSkippedBreak  = "break"
+	PatternClaimSkippedPast   = "started"
+	PatternClaimSkippedMine   = "already_claimed"
+	PatternClaimSkippedNoRoom = "no_room"
+)
+
+// PatternClaimResult is what happened to one of the timeslots matched by
+// a claim pattern: either it was claimed, or why it wasn't.
+type PatternClaimResult struct {
+	Timeslot    int              `json:"timeslot"`
+	Claimed     bool             `json:"claimed"`
+	Appointment *AppointmentSlot `json:"appointment,omitempty"`
+	Reason      string           `json:"reason,omitempty"`
+}
+
+// ScheduleAuditEntry records one change to a day's appointment schedule:
+// who made it (or approved it, for queues that need approval), and the
+// schedule before and after.
+type ScheduleAuditEntry struct {
+	ID        ksuid.KSUID          `json:"id" db:"id"`
+	Queue     ksuid.KSUID          `json:"queue" db:"queue"`
+	Day       int                  `json:"day" db:"day"`
+	Email     string               `json:"email" db:"email"`
+	Before    *AppointmentSchedule `json:"before" db:"-"`
+	After     *AppointmentSchedule `json:"after" db:"-"`
+	CreatedAt time.Time            `json:"created_at" db:"created_at"`
+}
+
+// ScheduleDiff is what would change about a day if its schedule were
+// replaced. Timeslots only lists the timeslots that would change (every
+// one of them, if the duration would); CanApply is false if any of them
+// conflict with existing appointments.
+type ScheduleDiff struct {
+	CurrentDuration  int               `json:"current_duration"`
+	ProposedDuration int               `json:"proposed_duration"`
+	Timeslots        []*TimeslotChange `json:"timeslots"`
+	CanApply         bool              `json:"can_apply"`
+}
+
+// TimeslotChange is how one timeslot would change with a new schedule.
+// ScheduledTime is when the timeslot would start under the new schedule,
+// and is left out if the timeslot would be cut off the end of the day.
+type TimeslotChange struct {
+	Timeslot         int        `json:"timeslot"`
+	ScheduledTime    *time.Time `json:"scheduled_time,omitempty"`
+	CurrentCapacity  int        `json:"current_capacity"`
+	ProposedCapacity int        `json:"proposed_capacity"`
+	Appointments     int        `json:"appointments"`
+	Conflict         bool       `json:"conflict"`
+}
+
+// CoverageGap is a timeslot with room for appointments but no staff
+// member covering it.
+type CoverageGap struct {
+	Timeslot      int       `json:"timeslot"`
+	ScheduledTime time.Time `json:"scheduled_time"`
+	Capacity      int       `json:"capacity"`
+	AssignedTo    string    `json:"assigned_to,omitempty"`
+}
+
+// AppointmentDashboard is today's appointments at a glance, for staff.
+type AppointmentDashboard struct {
+	Day          int                     `json:"day"`
+	Schedule     *AppointmentSchedule    `json:"schedule"`
+	Availability []*TimeslotAvailability `json:"availability"`
+	CoverageGaps []*CoverageGap          `json:"coverage_gaps"`
+}
+
+// AppointmentDay is everything needed to render a single day of an
+// appointments queue.
+type AppointmentDay struct {
+	Schedule     *AppointmentSchedule    `json:"schedule"`
+	Appointments []*AppointmentSlot      `json:"appointments"`
+	Availability []*TimeslotAvailability `json:"availability"`
+
+	// Set when every timeslot still to come on the day is taken, along
+	// with the queue's message for that and the soonest other day this
+	// week with room, if there is one.
+	FullyBooked        bool          `json:"fully_booked,omitempty"`
+	FullyBookedMessage string        `json:"fully_booked_message,omitempty"`
+	NextAvailableDay   *time.Weekday `json:"next_available_day,omitempty"`
+}
+
+// TimeslotDetail is everything about a single timeslot of a day. Claims
+// is how many staff members have claimed a slot at the time; Appointments
+// is only filled in for admins.
+type TimeslotDetail struct {
+	*TimeslotAvailability
+	Claims       int                `json:"claims"`
+	Appointments []*AppointmentSlot `json:"appointments,omitempty"`
+}
+
+// DayDemand is how booked up each timeslot of a day is, for planning
+// staffing across a week.
+type DayDemand struct {
+	Day       time.Weekday            `json:"day"`
+	Timeslots []*TimeslotAvailability `json:"timeslots"`
+}
+
+// AppointmentConflict is sent along with the error when a student can't
+// sign up because of an appointment they already have, so the frontend
+// can point them to it.
+type AppointmentConflict struct {
+	AppointmentID ksuid.KSUID `json:"appointment_id"`
+	ScheduledTime time.Time   `json:"scheduled_time"`
+}
+
+// Reasons a student can't sign up for an appointment right now.
+const (
+	SignupBlockedNotInRoster       = "not_in_roster"
+	SignupBlockedTeammate          = "teammate_has_appointment"
+	SignupBlockedFutureAppointment = "future_appointment"
+	SignupBlockedFrozen            = "signups_frozen"
+)
+
+// SignupEligibility is whether a student could sign up for an appointment
+// on a queue right now and, if not, why. It only covers what's about the
+// student; a particular timeslot can still be full.
+type SignupEligibility struct {
+	Email    string               `json:"email"`
+	Eligible bool                 `json:"eligible"`
+	Reason   string               `json:"reason,omitempty"`
+	Conflict *AppointmentConflict `json:"conflict,omitempty"`
+}
+
+// StudentTotalTime is how much time a student has spent in appointments
+// on a queue, counting only ones that are over.
+type StudentTotalTime struct {
+	Email        string `json:"email"`
+	Appointments int    `json:"appointments"`
+	TotalMinutes int    `json:"total_minutes"`
+}
+
+// ActiveAppointmentCount is how many booked appointments a queue has going
+// on right now, and how many more are still to come.
+type ActiveAppointmentCount struct {
+	Current  int `json:"current" db:"current"`
+	Upcoming int `json:"upcoming" db:"upcoming"`
+}
+
+// AppointmentTombstone records that an appointment slot was deleted, so
+// clients keeping a local copy of the appointments know to drop it.
+type AppointmentTombstone struct {
+	ID            ksuid.KSUID `json:"id" db:"id"`
+	Queue         ksuid.KSUID `json:"queue" db:"queue"`
+	ScheduledTime time.Time   `json:"scheduled_time" db:"scheduled_time"`
+	RemovedAt     time.Time   `json:"removed_at" db:"removed_at"`
+}
+
+// SharedAppointment is the subset of an appointment that's safe to show
+// to anyone holding a share link for it.
+type SharedAppointment struct {
+	Queue         ksuid.KSUID `json:"queue"`
+	ScheduledTime time.Time   `json:"scheduled_time"`
+	Duration      int         `json:"duration"`
+	Location      string      `json:"location"`
+}
+
+// CalendarEventLink records the event that was put on someone's Google
+// Calendar for an appointment, so it can follow the appointment around.
+type CalendarEventLink struct {
+	Appointment ksuid.KSUID `json:"appointment" db:"appointment"`
+	Email       string      `json:"email" db:"email"`
+	EventID     string      `json:"event_id" db:"event_id"`
+}
+
+// RosterImport is the outcome of uploading a roster: who made it in, and
+// which rows didn't and why.
+type RosterImport struct {
+	Students []string             `json:"students"`
+	Errors   []*RosterImportError `json:"errors"`
+}
+
+// RosterImportError is a row of an uploaded roster that was skipped. Rows
+// are numbered from 1, counting the header if there is one.
+type RosterImportError struct {
+	Row   int    `json:"row"`
+	Value string `json:"value,omitempty"`
+	Error string `json:"error"`
+}
+
+type CustomFieldType string
+
+const (
+	CustomFieldText    CustomFieldType = "text"
+	CustomFieldNumber  CustomFieldType = "number"
+	CustomFieldBoolean CustomFieldType = "boolean"
+)
+
+// MapPoint is a spot on a queue's room map, in the same coordinates as
+// the map pins students drop on their appointments.
+type MapPoint struct {
+	X float32 `json:"x"`
+	Y float32 `json:"y"`
+}
+
+// MapRegion is an area of a queue's room map that students can put their
+// pin in, outlined by the polygon through Points.
+type MapRegion struct {
+	Name   string      `json:"name" db:"name"`
+	Points []*MapPoint `json:"points"`
+}
+
+// CustomFieldDefinition is an extra question a queue asks students when
+// they book an appointment.
+type CustomFieldDefinition struct {
+	Name     string          `json:"name" db:"name"`
+	Type     CustomFieldType `json:"type" db:"type"`
+	Required bool            `json:"required" db:"required"`
+}
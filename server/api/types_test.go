@@ -0,0 +1,41 @@
+package api
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+func TestAppointmentDayMatchesScheduledTime(t *testing.T) {
+	store := newFakeStore()
+	q := store.addQueue(&QueueConfiguration{})
+
+	for day := 0; day < 7; day++ {
+		a := store.book(q, day, 13, "student@example.com")
+		// The day is worked out in local time, whatever zone the
+		// scheduled time came back from the database in.
+		a.ScheduledTime = a.ScheduledTime.UTC()
+
+		b, err := json.Marshal(a)
+		if err != nil {
+			t.Fatalf("failed to marshal appointment: %v", err)
+		}
+		var fields struct {
+			Day           time.Weekday `json:"day"`
+			Timeslot      int          `json:"timeslot"`
+			ScheduledTime time.Time    `json:"scheduled_time"`
+		}
+		err = json.Unmarshal(b, &fields)
+		if err != nil {
+			t.Fatalf("failed to unmarshal appointment: %v", err)
+		}
+
+		local := fields.ScheduledTime.In(time.Local)
+		if fields.Day != time.Weekday(day) || fields.Day != local.Weekday() {
+			t.Errorf("got day %d for an appointment on %s, want %d", fields.Day, local.Weekday(), day)
+		}
+		if fields.Timeslot != 13 || local.Hour() != 13 {
+			t.Errorf("got timeslot %d at %s, want 13 at 1 PM", fields.Timeslot, local.Format(time.Kitchen))
+		}
+	}
+}
@@ -0,0 +1,326 @@
+package api
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/segmentio/ksuid"
+	"go.uber.org/zap"
+)
+
+// A WaitlistPosition is where a student is in line for a full timeslot.
+type WaitlistPosition struct {
+	Timeslot      int       `json:"timeslot"`
+	ScheduledTime time.Time `json:"scheduled_time"`
+	Position      int       `json:"position"`
+}
+
+type appointmentWaitlist interface {
+	AddToWaitlist(ctx context.Context, queue ksuid.KSUID, scheduledTime time.Time, timeslot int, email string) (bool, error)
+	RemoveFromWaitlist(ctx context.Context, queue ksuid.KSUID, scheduledTime time.Time, email string) (bool, error)
+	GetWaitlist(ctx context.Context, queue ksuid.KSUID, scheduledTime time.Time) ([]*WaitlistEntry, error)
+}
+
+var errNoWaitlist = StatusError{
+	http.StatusNotFound,
+	"This queue doesn't have waitlists for full timeslots.",
+}
+
+// waitlistTime works out when timeslot on day starts, for looking up its
+// waitlist, making sure the timeslot exists along the way.
+func (s *Server) waitlistTime(ctx context.Context, l *zap.SugaredLogger, gs getAppointmentScheduleForDay, q *Queue, day, timeslot int) (time.Time, *AppointmentSchedule, error) {
+	schedule, err := gs.GetAppointmentScheduleForDay(ctx, q.ID, day)
+	if err != nil {
+		l.Errorw("failed to get appointment schedule", "err", err)
+		return time.Time{}, nil, err
+	}
+
+	if timeslot >= len(schedule.Schedule) {
+		l.Warnw("attempted to use waitlist for non-existent timeslot", "num_slots", len(schedule.Schedule))
+		return time.Time{}, nil, StatusError{
+			http.StatusNotFound,
+			"I don't think that timeslot exists, as much as I'd like it to.",
+		}
+	}
+
+	start, _ := WeekdayBounds(day)
+	return SlotStart(start, timeslot, schedule), schedule, nil
+}
+
+// joinWaitlist puts email at the back of the waitlist for the timeslot
+// starting at scheduled.
+func (s *Server) joinWaitlist(ctx context.Context, l *zap.SugaredLogger, aw appointmentWaitlist, q *Queue, scheduled time.Time, timeslot int, email string) (*WaitlistPosition, error) {
+	added, err := aw.AddToWaitlist(ctx, q.ID, scheduled, timeslot, email)
+	if err != nil {
+		l.Errorw("failed to add student to waitlist", "err", err)
+		return nil, err
+	}
+
+	if !added {
+		l.Warnw("student already on waitlist")
+		return nil, StatusError{
+			http.StatusConflict,
+			"You're already on the waitlist for that timeslot.",
+		}
+	}
+
+	entries, err := aw.GetWaitlist(ctx, q.ID, scheduled)
+	if err != nil {
+		l.Errorw("failed to get waitlist", "err", err)
+		return nil, err
+	}
+
+	position := &WaitlistPosition{Timeslot: timeslot, ScheduledTime: scheduled.In(time.Local)}
+	for i, e := range entries {
+		if e.Email == email {
+			position.Position = i + 1
+		}
+	}
+
+	l.Infow("added student to waitlist", "position", position.Position)
+	return position, nil
+}
+
+type joinWaitlist interface {
+	getQueueConfiguration
+	getAppointmentScheduleForDay
+	checkSignupCapacity
+	appointmentWaitlist
+}
+
+// JoinWaitlist puts the student in line for a spot in a full timeslot.
+// When one opens up, the first student in line gets a message saying so
+// (and comes off the waitlist); it's still up to them to sign up.
+func (s *Server) JoinWaitlist(jw joinWaitlist) E {
+	return func(w http.ResponseWriter, r *http.Request) error {
+		q, ok := r.Context().Value(queueContextKey).(*Queue)
+		if !ok {
+			return s.missingContextValue(r, queueContextKey)
+		}
+		day, ok := r.Context().Value(appointmentDayContextKey).(int)
+		if !ok {
+			return s.missingContextValue(r, appointmentDayContextKey)
+		}
+		timeslot, ok := r.Context().Value(appointmentTimeslotContextKey).(int)
+		if !ok {
+			return s.missingContextValue(r, appointmentTimeslotContextKey)
+		}
+		email, ok := r.Context().Value(emailContextKey).(string)
+		if !ok {
+			return s.missingContextValue(r, emailContextKey)
+		}
+		l := s.logger.With(
+			RequestIDContextKey, r.Context().Value(RequestIDContextKey),
+			"queue_id", q.ID,
+			"day", day,
+			"timeslot", timeslot,
+			"email", email,
+		)
+
+		config, err := jw.GetQueueConfiguration(r.Context(), q.ID)
+		if err != nil {
+			l.Errorw("failed to get queue configuration", "err", err)
+			return err
+		}
+
+		if !config.Waitlist {
+			l.Warnw("attempted to join waitlist on queue without waitlists")
+			return errNoWaitlist
+		}
+
+		if config.SignupsFrozen {
+			l.Warnw("attempted to join waitlist while signups are frozen")
+			return errSignupsFrozen
+		}
+
+		scheduled, schedule, err := s.waitlistTime(r.Context(), l, jw, q, day, timeslot)
+		if err != nil {
+			return err
+		}
+
+		if s.now().After(scheduled) {
+			l.Warnw("attempted to join waitlist for timeslot in the past", "scheduled_time", scheduled)
+			return StatusError{
+				http.StatusBadRequest,
+				"That timeslot already started, so there's nothing left to wait for.",
+			}
+		}
+
+		if schedule.Schedule[timeslot] == '0' {
+			l.Warnw("attempted to join waitlist for break in schedule")
+			return DetailedError{errTimeslotBreak, timeslotBreak, nil}
+		}
+
+		// Only full timeslots have waitlists; anyone who could sign up
+		// right now should just do that.
+		err = s.checkSignupCapacity(r.Context(), l, jw, q, config, schedule, day, timeslot, 1)
+		var se StatusError
+		if err == nil {
+			l.Warnw("attempted to join waitlist for timeslot with room")
+			return StatusError{
+				http.StatusConflict,
+				"There's still room in that timeslot, so sign up for it instead!",
+			}
+		} else if !errors.As(err, &se) {
+			return err
+		}
+
+		position, err := s.joinWaitlist(r.Context(), l, jw, q, scheduled, timeslot, email)
+		if err != nil {
+			return err
+		}
+
+		return s.sendResponse(http.StatusCreated, position, w, r)
+	}
+}
+
+type leaveWaitlist interface {
+	getAppointmentScheduleForDay
+	appointmentWaitlist
+}
+
+func (s *Server) LeaveWaitlist(lw leaveWaitlist) E {
+	return func(w http.ResponseWriter, r *http.Request) error {
+		q, ok := r.Context().Value(queueContextKey).(*Queue)
+		if !ok {
+			return s.missingContextValue(r, queueContextKey)
+		}
+		day, ok := r.Context().Value(appointmentDayContextKey).(int)
+		if !ok {
+			return s.missingContextValue(r, appointmentDayContextKey)
+		}
+		timeslot, ok := r.Context().Value(appointmentTimeslotContextKey).(int)
+		if !ok {
+			return s.missingContextValue(r, appointmentTimeslotContextKey)
+		}
+		email, ok := r.Context().Value(emailContextKey).(string)
+		if !ok {
+			return s.missingContextValue(r, emailContextKey)
+		}
+		l := s.logger.With(
+			RequestIDContextKey, r.Context().Value(RequestIDContextKey),
+			"queue_id", q.ID,
+			"day", day,
+			"timeslot", timeslot,
+			"email", email,
+		)
+
+		scheduled, _, err := s.waitlistTime(r.Context(), l, lw, q, day, timeslot)
+		if err != nil {
+			return err
+		}
+
+		removed, err := lw.RemoveFromWaitlist(r.Context(), q.ID, scheduled, email)
+		if err != nil {
+			l.Errorw("failed to remove student from waitlist", "err", err)
+			return err
+		}
+
+		if !removed {
+			l.Warnw("attempted to leave waitlist student isn't on")
+			return StatusError{
+				http.StatusNotFound,
+				"You aren't on the waitlist for that timeslot.",
+			}
+		}
+
+		l.Infow("removed student from waitlist")
+		return s.sendResponse(http.StatusNoContent, nil, w, r)
+	}
+}
+
+type getWaitlist interface {
+	getAppointmentScheduleForDay
+	appointmentWaitlist
+}
+
+// GetWaitlist lists who's waiting on a timeslot, first in line first.
+func (s *Server) GetWaitlist(gw getWaitlist) E {
+	return func(w http.ResponseWriter, r *http.Request) error {
+		q, ok := r.Context().Value(queueContextKey).(*Queue)
+		if !ok {
+			return s.missingContextValue(r, queueContextKey)
+		}
+		day, ok := r.Context().Value(appointmentDayContextKey).(int)
+		if !ok {
+			return s.missingContextValue(r, appointmentDayContextKey)
+		}
+		timeslot, ok := r.Context().Value(appointmentTimeslotContextKey).(int)
+		if !ok {
+			return s.missingContextValue(r, appointmentTimeslotContextKey)
+		}
+		l := s.logger.With(
+			RequestIDContextKey, r.Context().Value(RequestIDContextKey),
+			"queue_id", q.ID,
+			"day", day,
+			"timeslot", timeslot,
+		)
+
+		scheduled, _, err := s.waitlistTime(r.Context(), l, gw, q, day, timeslot)
+		if err != nil {
+			return err
+		}
+
+		entries, err := gw.GetWaitlist(r.Context(), q.ID, scheduled)
+		if err != nil {
+			l.Errorw("failed to get waitlist", "err", err)
+			return err
+		}
+
+		return s.sendResponse(http.StatusOK, entries, w, r)
+	}
+}
+
+type waitlistNotifier interface {
+	getQueueConfiguration
+	sendMessage
+	PopWaitlist(ctx context.Context, queue ksuid.KSUID, scheduledTime time.Time) (*WaitlistEntry, error)
+}
+
+// notifyWaitlist tells whoever's first in line for each of the timeslots
+// a took up that a spot has opened, now that a's student has left it.
+// Like notifyAssignee, the messages are saved along with the rest of the
+// request, and only go out once that commits.
+func (s *Server) notifyWaitlist(ctx context.Context, l *zap.SugaredLogger, wn waitlistNotifier, q *Queue, a *AppointmentSlot) error {
+	config, err := wn.GetQueueConfiguration(ctx, q.ID)
+	if err != nil {
+		l.Errorw("failed to get queue configuration", "err", err)
+		return err
+	}
+
+	if !config.Waitlist {
+		return nil
+	}
+
+	slotDuration := time.Duration(a.Duration/a.Span()) * time.Minute
+	for i := 0; i < a.Span(); i++ {
+		scheduled := a.ScheduledTime.Add(time.Duration(i) * slotDuration)
+		entry, err := wn.PopWaitlist(ctx, q.ID, scheduled)
+		if errors.Is(err, sql.ErrNoRows) {
+			continue
+		} else if err != nil {
+			l.Errorw("failed to get next student on waitlist", "scheduled_time", scheduled, "err", err)
+			return err
+		}
+
+		content := fmt.Sprintf("A spot opened up at %s, which you were on the waitlist for. Sign up soon, before someone else takes it!",
+			scheduled.In(time.Local).Format("Monday 3:04 PM"),
+		)
+		message, err := wn.SendMessage(ctx, q.ID, content, systemSender, entry.Email)
+		if err != nil {
+			l.Errorw("failed to send message to student on waitlist", "waitlisted_email", entry.Email, "err", err)
+			return err
+		}
+		l.Infow("told student on waitlist about open spot", "waitlisted_email", entry.Email, "scheduled_time", scheduled)
+
+		afterCommit(ctx, func() {
+			s.ps.Pub(WS("MESSAGE_CREATE", message), QueueTopicEmail(q.ID, message.Receiver))
+		})
+	}
+
+	return nil
+}
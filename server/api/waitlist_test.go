@@ -0,0 +1,223 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// waitlistRequest runs handler for email on timeslot's waitlist.
+func waitlistRequest(s *Server, handler E, q *Queue, day, timeslot int, email string) *httptest.ResponseRecorder {
+	r, _ := newTestRequest(http.MethodPost, "/", nil, map[string]interface{}{
+		queueContextKey:               q,
+		appointmentDayContextKey:      day,
+		appointmentTimeslotContextKey: timeslot,
+		emailContextKey:               email,
+	})
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+	return w
+}
+
+func waitlistPosition(t *testing.T, w *httptest.ResponseRecorder) int {
+	t.Helper()
+	var position WaitlistPosition
+	err := json.NewDecoder(w.Body).Decode(&position)
+	if err != nil {
+		t.Fatalf("failed to decode waitlist position: %v", err)
+	}
+	return position.Position
+}
+
+func TestAppointmentFlagRequirements(t *testing.T) {
+	s := newTestServer()
+	store := newFakeStore()
+	q := store.addQueue(&QueueConfiguration{})
+
+	w := httptest.NewRecorder()
+	settings := AppointmentSettings{AutoWaitlist: true}
+	s.UpdateAppointmentSettings(store).ServeHTTP(w, adminRequest(http.MethodPut, settings, q, "admin@example.com"))
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("got status %d for auto_waitlist without waitlist, want %d", w.Code, http.StatusBadRequest)
+	}
+	if !strings.Contains(w.Body.String(), "`auto_waitlist` needs `waitlist`") {
+		t.Errorf("got %s, want it to name both flags", w.Body.String())
+	}
+	if store.configs[q.ID].AutoWaitlist {
+		t.Error("invalid combination was saved")
+	}
+
+	// The same goes for the whole configuration.
+	w = httptest.NewRecorder()
+	config := QueueConfiguration{AppointmentSettings: settings}
+	s.UpdateQueueConfiguration(store).ServeHTTP(w, adminRequest(http.MethodPut, config, q, "admin@example.com"))
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("got status %d updating the configuration, want %d", w.Code, http.StatusBadRequest)
+	}
+
+	for _, settings := range []AppointmentSettings{
+		{Waitlist: true},
+		{Waitlist: true, AutoWaitlist: true},
+		{Waitlist: true, AutoWaitlist: true, SignupsFrozen: true},
+	} {
+		w = httptest.NewRecorder()
+		s.UpdateAppointmentSettings(store).ServeHTTP(w, adminRequest(http.MethodPut, settings, q, "admin@example.com"))
+		if w.Code != http.StatusOK {
+			t.Errorf("got status %d for %+v, want %d: %s", w.Code, settings.Flags(), http.StatusOK, w.Body.String())
+		}
+	}
+}
+
+func TestJoinWaitlist(t *testing.T) {
+	s := newTestServer()
+	store := newFakeStore()
+	q := store.addQueue(&QueueConfiguration{})
+	tomorrow := int(time.Now().Local().Add(24 * time.Hour).Weekday())
+	join := s.JoinWaitlist(store)
+
+	w := waitlistRequest(s, join, q, tomorrow, 10, "waiting@example.com")
+	if w.Code != http.StatusNotFound {
+		t.Errorf("got status %d without waitlists, want %d", w.Code, http.StatusNotFound)
+	}
+
+	store.configs[q.ID].Waitlist = true
+	w = waitlistRequest(s, join, q, tomorrow, 10, "waiting@example.com")
+	if w.Code != http.StatusConflict {
+		t.Errorf("got status %d for a timeslot with room, want %d", w.Code, http.StatusConflict)
+	}
+
+	store.book(q, tomorrow, 10, "student@example.com")
+	for i, email := range []string{"first@example.com", "second@example.com"} {
+		w = waitlistRequest(s, join, q, tomorrow, 10, email)
+		if w.Code != http.StatusCreated {
+			t.Fatalf("got status %d, want %d: %s", w.Code, http.StatusCreated, w.Body.String())
+		}
+		if position := waitlistPosition(t, w); position != i+1 {
+			t.Errorf("got %s at position %d, want %d", email, position, i+1)
+		}
+	}
+
+	w = waitlistRequest(s, join, q, tomorrow, 10, "first@example.com")
+	if w.Code != http.StatusConflict {
+		t.Errorf("got status %d joining twice, want %d", w.Code, http.StatusConflict)
+	}
+
+	store.configs[q.ID].SignupsFrozen = true
+	w = waitlistRequest(s, join, q, tomorrow, 10, "third@example.com")
+	if w.Code != http.StatusForbidden {
+		t.Errorf("got status %d while frozen, want %d", w.Code, http.StatusForbidden)
+	}
+	store.configs[q.ID].SignupsFrozen = false
+
+	// Leaving lets the next student move up.
+	w = waitlistRequest(s, s.LeaveWaitlist(store), q, tomorrow, 10, "first@example.com")
+	if w.Code != http.StatusNoContent {
+		t.Fatalf("got status %d leaving, want %d", w.Code, http.StatusNoContent)
+	}
+	w = waitlistRequest(s, s.LeaveWaitlist(store), q, tomorrow, 10, "first@example.com")
+	if w.Code != http.StatusNotFound {
+		t.Errorf("got status %d leaving twice, want %d", w.Code, http.StatusNotFound)
+	}
+	w = waitlistRequest(s, join, q, tomorrow, 10, "first@example.com")
+	if position := waitlistPosition(t, w); position != 2 {
+		t.Errorf("got position %d rejoining, want 2", position)
+	}
+}
+
+func TestAutoWaitlist(t *testing.T) {
+	s := newTestServer()
+	store := newFakeStore()
+	q := store.addQueue(&QueueConfiguration{AppointmentSettings: AppointmentSettings{Waitlist: true, AutoWaitlist: true}})
+	tomorrow := int(time.Now().Local().Add(24 * time.Hour).Weekday())
+	store.book(q, tomorrow, 10, "student@example.com")
+
+	w := signupRequest(s, store, q, tomorrow, "waiting@example.com", 10, 1)
+	if w.Code != http.StatusAccepted {
+		t.Fatalf("got status %d, want %d: %s", w.Code, http.StatusAccepted, w.Body.String())
+	}
+	if position := waitlistPosition(t, w); position != 1 {
+		t.Errorf("got position %d, want 1", position)
+	}
+	if len(store.appointments) != 1 {
+		t.Errorf("got %d stored appointments, want just the one already there", len(store.appointments))
+	}
+
+	// Timeslots with room are signed up for as usual.
+	w = signupRequest(s, store, q, tomorrow, "waiting@example.com", 11, 1)
+	if w.Code != http.StatusCreated {
+		t.Errorf("got status %d, want %d: %s", w.Code, http.StatusCreated, w.Body.String())
+	}
+
+	// Without it, a full timeslot is just full.
+	store.configs[q.ID].AutoWaitlist = false
+	w = signupRequest(s, store, q, tomorrow, "other@example.com", 10, 1)
+	if w.Code != http.StatusConflict {
+		t.Errorf("got status %d without auto_waitlist, want %d", w.Code, http.StatusConflict)
+	}
+}
+
+func TestCancelNotifiesWaitlist(t *testing.T) {
+	s := newTestServer()
+	store := newFakeStore()
+	q := store.addQueue(&QueueConfiguration{AppointmentSettings: AppointmentSettings{Waitlist: true}})
+	tomorrow := int(time.Now().Local().Add(24 * time.Hour).Weekday())
+	a := store.book(q, tomorrow, 10, "student@example.com")
+	for _, email := range []string{"first@example.com", "second@example.com"} {
+		w := waitlistRequest(s, s.JoinWaitlist(store), q, tomorrow, 10, email)
+		if w.Code != http.StatusCreated {
+			t.Fatalf("got status %d, want %d: %s", w.Code, http.StatusCreated, w.Body.String())
+		}
+	}
+	messages := s.ps.Sub(QueueTopicEmail(q.ID, "first@example.com"))
+	defer s.ps.Unsub(messages)
+
+	var hooks []func()
+	r, _ := newTestRequest(http.MethodDelete, "/", nil, map[string]interface{}{
+		queueContextKey:       q,
+		appointmentContextKey: a,
+		emailContextKey:       "student@example.com",
+		afterCommitContextKey: &hooks,
+	})
+	w := httptest.NewRecorder()
+	s.RemoveAppointmentSignup(store).ServeHTTP(w, r)
+	if w.Code != http.StatusNoContent {
+		t.Fatalf("got status %d canceling, want %d: %s", w.Code, http.StatusNoContent, w.Body.String())
+	}
+
+	// Only the first student in line hears about it, and stops waiting.
+	if len(store.messages) != 1 || store.messages[0].Receiver != "first@example.com" || store.messages[0].Sender != systemSender {
+		t.Fatalf("got messages %+v, want one from the system to first@example.com", store.messages)
+	}
+	if len(store.waitlist) != 1 || store.waitlist[0].Email != "second@example.com" {
+		t.Errorf("got waitlist %+v, want just second@example.com", store.waitlist)
+	}
+
+	select {
+	case <-messages:
+		t.Fatal("got a message before the cancellation committed")
+	case <-time.After(10 * time.Millisecond):
+	}
+	for _, f := range hooks {
+		f()
+	}
+	select {
+	case got := <-messages:
+		if ws, ok := got.(*WSMessage); !ok || ws.Event != "MESSAGE_CREATE" {
+			t.Errorf("got %+v, want a MESSAGE_CREATE", got)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("got no message after the cancellation committed")
+	}
+
+	// Signing up takes the second student off the waitlist too.
+	w = signupRequest(s, store, q, tomorrow, "second@example.com", 10, 1)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("got status %d, want %d: %s", w.Code, http.StatusCreated, w.Body.String())
+	}
+	if len(store.waitlist) != 0 {
+		t.Errorf("got waitlist %+v after signing up, want it empty", store.waitlist)
+	}
+}
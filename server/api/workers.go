@@ -0,0 +1,119 @@
+package api
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// DefaultBackgroundWorkers is how many background tasks run at once
+// unless QUEUE_BACKGROUND_WORKERS says otherwise.
+const DefaultBackgroundWorkers = 8
+
+// backgroundQueueSize is how many background tasks can be waiting for a
+// worker before whoever's adding one has to wait too.
+const backgroundQueueSize = 1024
+
+// WorkerPool runs background tasks (the work waiting on request
+// transactions, and jobs like pruning old appointments) on a fixed number
+// of goroutines, so a burst of them can't swamp the database or the
+// services they call. Tasks wait their turn in the order they came in.
+type WorkerPool struct {
+	// Kept for the metrics. First so that they're aligned for atomic
+	// access on 32-bit platforms.
+	queued  int64
+	running int64
+
+	tasks   chan func()
+	workers sync.WaitGroup
+}
+
+// NewWorkerPool starts a pool running at most concurrency tasks at once.
+func NewWorkerPool(concurrency int) *WorkerPool {
+	if concurrency < 1 {
+		concurrency = 1
+	}
+
+	p := &WorkerPool{tasks: make(chan func(), backgroundQueueSize)}
+	p.workers.Add(concurrency)
+	for i := 0; i < concurrency; i++ {
+		go func() {
+			defer p.workers.Done()
+			for f := range p.tasks {
+				atomic.AddInt64(&p.queued, -1)
+				atomic.AddInt64(&p.running, 1)
+				f()
+				atomic.AddInt64(&p.running, -1)
+			}
+		}()
+	}
+	return p
+}
+
+// Submit queues f to run once a worker is free. It only waits if the
+// queue is full.
+func (p *WorkerPool) Submit(f func()) {
+	atomic.AddInt64(&p.queued, 1)
+	p.tasks <- f
+}
+
+// Do runs f on the pool and waits for it to finish. If ctx is cancelled
+// first, Do gives up waiting, and f doesn't run if it hadn't started.
+func (p *WorkerPool) Do(ctx context.Context, f func()) error {
+	done := make(chan struct{})
+	p.Submit(func() {
+		defer close(done)
+		if ctx.Err() == nil {
+			f()
+		}
+	})
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Depth is how many tasks are waiting for a worker.
+func (p *WorkerPool) Depth() int {
+	return int(atomic.LoadInt64(&p.queued))
+}
+
+// Running is how many tasks are running right now.
+func (p *WorkerPool) Running() int {
+	return int(atomic.LoadInt64(&p.running))
+}
+
+// Close waits for the tasks already submitted to finish, then stops the
+// workers. Nothing can be submitted after.
+func (p *WorkerPool) Close() {
+	close(p.tasks)
+	p.workers.Wait()
+}
+
+var (
+	backgroundQueueDepthDesc = prometheus.NewDesc(
+		"background_queue_depth",
+		"The number of background tasks waiting for a worker.",
+		nil, nil,
+	)
+	backgroundRunningDesc = prometheus.NewDesc(
+		"background_tasks_running",
+		"The number of background tasks running.",
+		nil, nil,
+	)
+)
+
+func (p *WorkerPool) Describe(c chan<- *prometheus.Desc) {
+	c <- backgroundQueueDepthDesc
+	c <- backgroundRunningDesc
+}
+
+func (p *WorkerPool) Collect(c chan<- prometheus.Metric) {
+	c <- prometheus.MustNewConstMetric(backgroundQueueDepthDesc, prometheus.GaugeValue, float64(p.Depth()))
+	c <- prometheus.MustNewConstMetric(backgroundRunningDesc, prometheus.GaugeValue, float64(p.Running()))
+}
@@ -0,0 +1,96 @@
+package api
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestWorkerPoolConcurrencyLimit(t *testing.T) {
+	p := NewWorkerPool(2)
+
+	var running, most int64
+	started := make(chan struct{}, 6)
+	release := make(chan struct{})
+	for i := 0; i < 6; i++ {
+		p.Submit(func() {
+			n := atomic.AddInt64(&running, 1)
+			for {
+				m := atomic.LoadInt64(&most)
+				if n <= m || atomic.CompareAndSwapInt64(&most, m, n) {
+					break
+				}
+			}
+			started <- struct{}{}
+			<-release
+			atomic.AddInt64(&running, -1)
+		})
+	}
+
+	// Two tasks take both workers, and the other four wait their turn.
+	for i := 0; i < 2; i++ {
+		select {
+		case <-started:
+		case <-time.After(5 * time.Second):
+			t.Fatalf("got %d tasks started, want 2", i)
+		}
+	}
+	select {
+	case <-started:
+		t.Fatal("a third task started while two were running")
+	case <-time.After(50 * time.Millisecond):
+	}
+	if p.Depth() != 4 || p.Running() != 2 {
+		t.Errorf("got %d queued and %d running, want 4 and 2", p.Depth(), p.Running())
+	}
+
+	close(release)
+	p.Close()
+	if most != 2 {
+		t.Errorf("got up to %d tasks running at once, want 2", most)
+	}
+	if p.Depth() != 0 || p.Running() != 0 {
+		t.Errorf("got %d queued and %d running after closing, want none", p.Depth(), p.Running())
+	}
+}
+
+func TestWorkerPoolDo(t *testing.T) {
+	p := NewWorkerPool(1)
+	defer p.Close()
+
+	ran := false
+	err := p.Do(context.Background(), func() { ran = true })
+	if err != nil || !ran {
+		t.Fatalf("got error %v and ran %t, want the task run", err, ran)
+	}
+
+	// With the only worker busy, a cancelled Do stops waiting, and its
+	// task is skipped once the worker gets to it.
+	release := make(chan struct{})
+	p.Submit(func() { <-release })
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	var skipped int32 = 1
+	go func() {
+		done <- p.Do(ctx, func() { atomic.StoreInt32(&skipped, 0) })
+	}()
+	cancel()
+	select {
+	case err := <-done:
+		if err != context.Canceled {
+			t.Errorf("got error %v, want %v", err, context.Canceled)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Do kept waiting after being cancelled")
+	}
+
+	close(release)
+	err = p.Do(context.Background(), func() {})
+	if err != nil {
+		t.Fatalf("failed to run task: %v", err)
+	}
+	if atomic.LoadInt32(&skipped) != 1 {
+		t.Error("cancelled task ran anyway")
+	}
+}
@@ -2,6 +2,7 @@ package db
 
 import (
 	"context"
+	"database/sql"
 	"fmt"
 	"time"
 
@@ -13,7 +14,7 @@ func (s *Server) GetAppointment(ctx context.Context, appointment ksuid.KSUID) (*
 	tx := getTransaction(ctx)
 	var a api.AppointmentSlot
 	err := tx.GetContext(ctx, &a,
-		"SELECT id, queue, staff_email, student_email, scheduled_time, timeslot, duration, name, location, description, map_x, map_y FROM appointment_slots WHERE id=$1",
+		"SELECT id, queue, staff_email, student_email, scheduled_time, timeslot, duration, name, location, description, map_x, map_y, meeting_link, updated_at, anonymous_to_peers, slot_span, resolved FROM appointment_slots WHERE id=$1",
 		appointment,
 	)
 	return &a, err
@@ -23,7 +24,7 @@ func (s *Server) GetAppointments(ctx context.Context, queue ksuid.KSUID, from, t
 	tx := getTransaction(ctx)
 	appointments := make([]*api.AppointmentSlot, 0)
 	err := tx.SelectContext(ctx, &appointments,
-		"SELECT id, queue, staff_email, student_email, scheduled_time, timeslot, duration, name, location, description, map_x, map_y FROM appointment_slots WHERE queue=$1 AND scheduled_time >= $2 AND scheduled_time <= $3 ORDER BY id",
+		"SELECT id, queue, staff_email, student_email, scheduled_time, timeslot, duration, name, location, description, map_x, map_y, meeting_link, updated_at, anonymous_to_peers, slot_span, resolved FROM appointment_slots WHERE queue=$1 AND scheduled_time >= $2 AND scheduled_time <= $3 ORDER BY scheduled_time, id",
 		queue, from, to,
 	)
 	return appointments, err
@@ -33,7 +34,7 @@ func (s *Server) GetAppointmentsWithStudent(ctx context.Context, queue ksuid.KSU
 	tx := getTransaction(ctx)
 	appointments := make([]*api.AppointmentSlot, 0)
 	err := tx.SelectContext(ctx, &appointments,
-		"SELECT id, queue, timeslot, scheduled_time, duration FROM appointment_slots WHERE queue=$1 AND scheduled_time >= $2 AND scheduled_time <= $3 AND student_email IS NOT NULL ORDER BY id",
+		"SELECT id, queue, timeslot, scheduled_time, duration, updated_at, slot_span FROM appointment_slots WHERE queue=$1 AND scheduled_time >= $2 AND scheduled_time <= $3 AND student_email IS NOT NULL ORDER BY scheduled_time, id",
 		queue, from, to,
 	)
 	return appointments, err
@@ -43,12 +44,49 @@ func (s *Server) GetAppointmentsForUser(ctx context.Context, queue ksuid.KSUID,
 	tx := getTransaction(ctx)
 	appointments := make([]*api.AppointmentSlot, 0)
 	err := tx.SelectContext(ctx, &appointments,
-		"SELECT id, queue, student_email, scheduled_time, timeslot, duration, name, location, description, map_x, map_y FROM appointment_slots WHERE queue=$1 AND student_email=$2 AND scheduled_time >= $3 AND scheduled_time <= $4 ORDER BY id",
+		"SELECT id, queue, student_email, scheduled_time, timeslot, duration, name, location, description, map_x, map_y, meeting_link, updated_at, anonymous_to_peers, slot_span FROM appointment_slots WHERE queue=$1 AND (student_email=$2 OR id IN (SELECT appointment FROM appointment_partners WHERE email=$2)) AND scheduled_time >= $3 AND scheduled_time <= $4 ORDER BY scheduled_time, id",
 		queue, email, from, to,
 	)
 	return appointments, err
 }
 
+// GetActiveAppointmentCount counts a queue's booked appointments that are
+// under way at now and that start after it, in one pass.
+func (s *Server) GetActiveAppointmentCount(ctx context.Context, queue ksuid.KSUID, now time.Time) (*api.ActiveAppointmentCount, error) {
+	tx := getTransaction(ctx)
+	var count api.ActiveAppointmentCount
+	err := tx.GetContext(ctx, &count,
+		"SELECT COUNT(*) FILTER (WHERE scheduled_time <= $2) AS current, COUNT(*) FILTER (WHERE scheduled_time > $2) AS upcoming FROM appointment_slots WHERE queue=$1 AND student_email IS NOT NULL AND scheduled_time + duration * INTERVAL '1 minute' > $2",
+		queue, now,
+	)
+	return &count, err
+}
+
+// GetUpcomingAppointmentsForUser gets a user's appointments in every queue
+// from the given time on, including ones they're a partner on.
+func (s *Server) GetUpcomingAppointmentsForUser(ctx context.Context, email string, from time.Time) ([]*api.AppointmentSlot, error) {
+	tx := getTransaction(ctx)
+	appointments := make([]*api.AppointmentSlot, 0)
+	err := tx.SelectContext(ctx, &appointments,
+		"SELECT id, queue, student_email, scheduled_time, timeslot, duration, name, location, description, map_x, map_y, meeting_link, updated_at, anonymous_to_peers, slot_span FROM appointment_slots WHERE (student_email=$1 OR id IN (SELECT appointment FROM appointment_partners WHERE email=$1)) AND scheduled_time >= $2 ORDER BY scheduled_time, id",
+		email, from,
+	)
+	return appointments, err
+}
+
+// LockAppointmentSignups holds off other sign-ups by the same user in the
+// same queue until the current transaction ends, so that two requests
+// racing each other can't both get past the check for an existing
+// appointment.
+func (s *Server) LockAppointmentSignups(ctx context.Context, queue ksuid.KSUID, email string) error {
+	tx := getTransaction(ctx)
+	_, err := tx.ExecContext(ctx,
+		"SELECT pg_advisory_xact_lock(hashtext($1), hashtext($2))",
+		queue, email,
+	)
+	return err
+}
+
 func (s *Server) TeammateHasAppointment(ctx context.Context, queue ksuid.KSUID, from, to time.Time, email string) (bool, error) {
 	tx := getTransaction(ctx)
 	var n int
@@ -62,22 +100,174 @@ func (s *Server) TeammateHasAppointment(ctx context.Context, queue ksuid.KSUID,
 func (s *Server) GetAppointmentSchedule(ctx context.Context, queue ksuid.KSUID) ([]*api.AppointmentSchedule, error) {
 	tx := getTransaction(ctx)
 	schedules := make([]*api.AppointmentSchedule, 0)
-	err := tx.SelectContext(ctx, &schedules, "SELECT queue, day, duration, padding, schedule FROM appointment_schedules WHERE queue=$1 ORDER BY day", queue)
-	return schedules, err
+	err := tx.SelectContext(ctx, &schedules, "SELECT queue, day, duration, padding, signup_cutoff, signups_open, schedule FROM appointment_schedules WHERE queue=$1 ORDER BY day", queue)
+	if err != nil {
+		return nil, err
+	}
+
+	var notes []timeslotNote
+	err = tx.SelectContext(ctx, &notes, "SELECT day, timeslot, note FROM appointment_timeslot_notes WHERE queue=$1", queue)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get timeslot notes: %w", err)
+	}
+
+	var locations []timeslotLocation
+	err = tx.SelectContext(ctx, &locations, "SELECT day, timeslot, location FROM appointment_timeslot_locations WHERE queue=$1", queue)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get timeslot locations: %w", err)
+	}
+
+	for _, schedule := range schedules {
+		for _, n := range notes {
+			if n.Day != int(schedule.Day) {
+				continue
+			}
+			if schedule.Notes == nil {
+				schedule.Notes = make(map[int]string)
+			}
+			schedule.Notes[n.Timeslot] = n.Note
+		}
+		for _, loc := range locations {
+			if loc.Day != int(schedule.Day) {
+				continue
+			}
+			if schedule.Locations == nil {
+				schedule.Locations = make(map[int]string)
+			}
+			schedule.Locations[loc.Timeslot] = loc.Location
+		}
+	}
+	return schedules, nil
 }
 
 func (s *Server) GetAppointmentScheduleForDay(ctx context.Context, queue ksuid.KSUID, day int) (*api.AppointmentSchedule, error) {
 	tx := getTransaction(ctx)
 	var schedule api.AppointmentSchedule
-	err := tx.GetContext(ctx, &schedule, "SELECT queue, day, duration, padding, schedule FROM appointment_schedules WHERE queue=$1 AND day=$2", queue, day)
-	return &schedule, err
+	err := tx.GetContext(ctx, &schedule, "SELECT queue, day, duration, padding, signup_cutoff, signups_open, schedule FROM appointment_schedules WHERE queue=$1 AND day=$2", queue, day)
+	if err != nil {
+		return &schedule, err
+	}
+
+	var notes []timeslotNote
+	err = tx.SelectContext(ctx, &notes, "SELECT day, timeslot, note FROM appointment_timeslot_notes WHERE queue=$1 AND day=$2", queue, day)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get timeslot notes: %w", err)
+	}
+
+	for _, n := range notes {
+		if schedule.Notes == nil {
+			schedule.Notes = make(map[int]string)
+		}
+		schedule.Notes[n.Timeslot] = n.Note
+	}
+
+	var locations []timeslotLocation
+	err = tx.SelectContext(ctx, &locations, "SELECT day, timeslot, location FROM appointment_timeslot_locations WHERE queue=$1 AND day=$2", queue, day)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get timeslot locations: %w", err)
+	}
+
+	for _, loc := range locations {
+		if schedule.Locations == nil {
+			schedule.Locations = make(map[int]string)
+		}
+		schedule.Locations[loc.Timeslot] = loc.Location
+	}
+	return &schedule, nil
+}
+
+type timeslotNote struct {
+	Day      int    `db:"day"`
+	Timeslot int    `db:"timeslot"`
+	Note     string `db:"note"`
+}
+
+// SetTimeslotNote sets the note on a timeslot; an empty note removes it.
+func (s *Server) SetTimeslotNote(ctx context.Context, queue ksuid.KSUID, day, timeslot int, note string) error {
+	tx := getTransaction(ctx)
+	if note == "" {
+		_, err := tx.ExecContext(ctx,
+			"DELETE FROM appointment_timeslot_notes WHERE queue=$1 AND day=$2 AND timeslot=$3",
+			queue, day, timeslot,
+		)
+		return err
+	}
+
+	_, err := tx.ExecContext(ctx,
+		"INSERT INTO appointment_timeslot_notes (queue, day, timeslot, note) VALUES ($1, $2, $3, $4) ON CONFLICT (queue, day, timeslot) DO UPDATE SET note=EXCLUDED.note",
+		queue, day, timeslot, note,
+	)
+	return err
+}
+
+type timeslotLocation struct {
+	Day      int    `db:"day"`
+	Timeslot int    `db:"timeslot"`
+	Location string `db:"location"`
+}
+
+// SetTimeslotLocation sets the default location for a timeslot; an empty
+// location removes it.
+func (s *Server) SetTimeslotLocation(ctx context.Context, queue ksuid.KSUID, day, timeslot int, location string) error {
+	tx := getTransaction(ctx)
+	if location == "" {
+		_, err := tx.ExecContext(ctx,
+			"DELETE FROM appointment_timeslot_locations WHERE queue=$1 AND day=$2 AND timeslot=$3",
+			queue, day, timeslot,
+		)
+		return err
+	}
+
+	_, err := tx.ExecContext(ctx,
+		"INSERT INTO appointment_timeslot_locations (queue, day, timeslot, location) VALUES ($1, $2, $3, $4) ON CONFLICT (queue, day, timeslot) DO UPDATE SET location=EXCLUDED.location",
+		queue, day, timeslot, location,
+	)
+	return err
+}
+
+func (s *Server) GetTimeslotAssignments(ctx context.Context, queue ksuid.KSUID, day int) (map[int]string, error) {
+	tx := getTransaction(ctx)
+	var rows []struct {
+		Timeslot int    `db:"timeslot"`
+		Email    string `db:"email"`
+	}
+	err := tx.SelectContext(ctx, &rows,
+		"SELECT timeslot, email FROM appointment_timeslot_assignments WHERE queue=$1 AND day=$2",
+		queue, day,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	assignments := make(map[int]string)
+	for _, r := range rows {
+		assignments[r.Timeslot] = r.Email
+	}
+	return assignments, nil
+}
+
+func (s *Server) SetTimeslotAssignment(ctx context.Context, queue ksuid.KSUID, day, timeslot int, email string) error {
+	tx := getTransaction(ctx)
+	if email == "" {
+		_, err := tx.ExecContext(ctx,
+			"DELETE FROM appointment_timeslot_assignments WHERE queue=$1 AND day=$2 AND timeslot=$3",
+			queue, day, timeslot,
+		)
+		return err
+	}
+
+	_, err := tx.ExecContext(ctx,
+		"INSERT INTO appointment_timeslot_assignments (queue, day, timeslot, email) VALUES ($1, $2, $3, $4) ON CONFLICT (queue, day, timeslot) DO UPDATE SET email=EXCLUDED.email",
+		queue, day, timeslot, email,
+	)
+	return err
 }
 
 func (s *Server) AddAppointmentSchedule(ctx context.Context, queue ksuid.KSUID, day int, schedule *api.AppointmentSchedule) error {
 	tx := getTransaction(ctx)
 	_, err := tx.ExecContext(ctx,
-		"INSERT INTO appointment_schedules (queue, day, duration, padding, schedule) VALUES ($1, $2, $3, $4, $5)",
-		queue, day, schedule.Duration, schedule.Padding, schedule.Schedule,
+		"INSERT INTO appointment_schedules (queue, day, duration, padding, signup_cutoff, signups_open, schedule) VALUES ($1, $2, $3, $4, $5, $6, $7)",
+		queue, day, schedule.Duration, schedule.Padding, schedule.SignupCutoff, schedule.SignupsOpen, schedule.Schedule,
 	)
 	return err
 }
@@ -85,17 +275,95 @@ func (s *Server) AddAppointmentSchedule(ctx context.Context, queue ksuid.KSUID,
 func (s *Server) UpdateAppointmentSchedule(ctx context.Context, queue ksuid.KSUID, day int, schedule *api.AppointmentSchedule) error {
 	tx := getTransaction(ctx)
 	_, err := tx.ExecContext(ctx,
-		"UPDATE appointment_schedules SET duration=$1, padding=$2, schedule=$3 WHERE queue=$4 AND day=$5",
-		schedule.Duration, schedule.Padding, schedule.Schedule, queue, day,
+		"UPDATE appointment_schedules SET duration=$1, padding=$2, signup_cutoff=$3, signups_open=$4, schedule=$5 WHERE queue=$6 AND day=$7",
+		schedule.Duration, schedule.Padding, schedule.SignupCutoff, schedule.SignupsOpen, schedule.Schedule, queue, day,
+	)
+	return err
+}
+
+func (s *Server) GetPendingScheduleChange(ctx context.Context, queue ksuid.KSUID, day int) (*api.PendingScheduleChange, error) {
+	tx := getTransaction(ctx)
+	var change api.PendingScheduleChange
+	err := tx.GetContext(ctx, &change,
+		"SELECT queue, day, duration, padding, signup_cutoff, signups_open, schedule, proposed_by, created_at FROM pending_schedule_changes WHERE queue=$1 AND day=$2",
+		queue, day,
+	)
+	return &change, err
+}
+
+func (s *Server) SetPendingScheduleChange(ctx context.Context, queue ksuid.KSUID, day int, schedule *api.AppointmentSchedule, email string) error {
+	tx := getTransaction(ctx)
+	_, err := tx.ExecContext(ctx,
+		"INSERT INTO pending_schedule_changes (queue, day, duration, padding, signup_cutoff, signups_open, schedule, proposed_by) VALUES ($1, $2, $3, $4, $5, $6, $7, $8) ON CONFLICT (queue, day) DO UPDATE SET duration=EXCLUDED.duration, padding=EXCLUDED.padding, signup_cutoff=EXCLUDED.signup_cutoff, signups_open=EXCLUDED.signups_open, schedule=EXCLUDED.schedule, proposed_by=EXCLUDED.proposed_by, created_at=now()",
+		queue, day, schedule.Duration, schedule.Padding, schedule.SignupCutoff, schedule.SignupsOpen, schedule.Schedule, email,
+	)
+	return err
+}
+
+func (s *Server) RemovePendingScheduleChange(ctx context.Context, queue ksuid.KSUID, day int) error {
+	tx := getTransaction(ctx)
+	res, err := tx.ExecContext(ctx,
+		"DELETE FROM pending_schedule_changes WHERE queue=$1 AND day=$2",
+		queue, day,
+	)
+	if err != nil {
+		return err
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to get rows affected by delete: %w", err)
+	}
+	if n == 0 {
+		return fmt.Errorf("no pending schedule change: %w", sql.ErrNoRows)
+	}
+	return nil
+}
+
+func (s *Server) GetPendingApprovalRemoval(ctx context.Context, queue ksuid.KSUID) (*api.PendingApprovalRemoval, error) {
+	tx := getTransaction(ctx)
+	var removal api.PendingApprovalRemoval
+	err := tx.GetContext(ctx, &removal,
+		"SELECT queue, proposed_by, created_at FROM pending_approval_removals WHERE queue=$1",
+		queue,
+	)
+	return &removal, err
+}
+
+func (s *Server) SetPendingApprovalRemoval(ctx context.Context, queue ksuid.KSUID, email string) error {
+	tx := getTransaction(ctx)
+	_, err := tx.ExecContext(ctx,
+		"INSERT INTO pending_approval_removals (queue, proposed_by) VALUES ($1, $2) ON CONFLICT (queue) DO UPDATE SET proposed_by=EXCLUDED.proposed_by, created_at=now()",
+		queue, email,
 	)
 	return err
 }
 
+func (s *Server) RemovePendingApprovalRemoval(ctx context.Context, queue ksuid.KSUID) error {
+	tx := getTransaction(ctx)
+	res, err := tx.ExecContext(ctx,
+		"DELETE FROM pending_approval_removals WHERE queue=$1",
+		queue,
+	)
+	if err != nil {
+		return err
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to get rows affected by delete: %w", err)
+	}
+	if n == 0 {
+		return fmt.Errorf("no pending approval removal: %w", sql.ErrNoRows)
+	}
+	return nil
+}
+
 func (s *Server) GetAppointmentsByTimeslot(ctx context.Context, queue ksuid.KSUID, from, to time.Time, timeslot int) ([]*api.AppointmentSlot, error) {
 	tx := getTransaction(ctx)
 	appointments := make([]*api.AppointmentSlot, 0)
 	err := tx.SelectContext(ctx, &appointments,
-		"SELECT id, queue, staff_email, student_email, scheduled_time, timeslot, duration, name, location, description, map_x, map_y FROM appointment_slots WHERE queue=$1 AND timeslot=$2 AND scheduled_time >= $3 AND scheduled_time <= $4 ORDER BY id",
+		"SELECT id, queue, staff_email, student_email, scheduled_time, timeslot, duration, name, location, description, map_x, map_y, meeting_link, updated_at, anonymous_to_peers, slot_span, resolved FROM appointment_slots WHERE queue=$1 AND timeslot <= $2 AND timeslot + slot_span > $2 AND scheduled_time >= $3 AND scheduled_time <= $4 ORDER BY scheduled_time, id",
 		queue, timeslot, from, to,
 	)
 	return appointments, err
@@ -124,7 +392,7 @@ func (s *Server) ClaimTimeslot(ctx context.Context, queue ksuid.KSUID, day, time
 		if slot.StaffEmail == nil {
 			var a api.AppointmentSlot
 			err := tx.GetContext(ctx, &a,
-				"UPDATE appointment_slots SET staff_email=$1 WHERE id=$2 RETURNING *",
+				"UPDATE appointment_slots SET staff_email=$1, updated_at=NOW() WHERE id=$2 RETURNING *",
 				email, slot.ID,
 			)
 			return &a, err
@@ -141,7 +409,7 @@ func (s *Server) ClaimTimeslot(ctx context.Context, queue ksuid.KSUID, day, time
 	// There's room for another appointment at the current timeslot.
 	// Let's claim it.
 	id := ksuid.New()
-	appointmentTime := api.TimeslotToTime(day, timeslot, schedule.Duration)
+	appointmentTime := api.SlotStart(from, timeslot, schedule)
 	var a api.AppointmentSlot
 	err = tx.GetContext(ctx, &a,
 		"INSERT INTO appointment_slots (id, queue, staff_email, scheduled_time, timeslot, duration) VALUES ($1, $2, $3, $4, $5, $6) RETURNING *",
@@ -150,6 +418,25 @@ func (s *Server) ClaimTimeslot(ctx context.Context, queue ksuid.KSUID, day, time
 	return &a, err
 }
 
+func (s *Server) AddClaimEvent(ctx context.Context, event *api.ClaimEvent) error {
+	tx := getTransaction(ctx)
+	_, err := tx.ExecContext(ctx,
+		"INSERT INTO appointment_claim_events (id, queue, appointment, scheduled_time, timeslot, action, email, staff_email) VALUES ($1, $2, $3, $4, $5, $6, $7, $8)",
+		ksuid.New(), event.Queue, event.Appointment, event.ScheduledTime, event.Timeslot, event.Action, event.Email, event.StaffEmail,
+	)
+	return err
+}
+
+func (s *Server) GetClaimEvents(ctx context.Context, queue ksuid.KSUID, from, to time.Time) ([]*api.ClaimEvent, error) {
+	tx := getTransaction(ctx)
+	events := make([]*api.ClaimEvent, 0)
+	err := tx.SelectContext(ctx, &events,
+		"SELECT id, queue, appointment, scheduled_time, timeslot, action, email, staff_email, created_at FROM appointment_claim_events WHERE queue=$1 AND scheduled_time >= $2 AND scheduled_time <= $3 ORDER BY id",
+		queue, from, to,
+	)
+	return events, err
+}
+
 func (s *Server) UnclaimAppointment(ctx context.Context, appointment ksuid.KSUID) (deleted bool, err error) {
 	tx := getTransaction(ctx)
 	a, err := s.GetAppointment(ctx, appointment)
@@ -160,16 +447,12 @@ func (s *Server) UnclaimAppointment(ctx context.Context, appointment ksuid.KSUID
 	// If there's no student associated with this appointment, there's
 	// no point in keeping it around
 	if a.StudentEmail == nil {
-		_, err = tx.ExecContext(ctx,
-			"DELETE FROM appointment_slots WHERE id=$1",
-			appointment,
-		)
-		return true, err
+		return true, s.deleteAppointment(ctx, a)
 	}
 
 	// If there is a student associated with it, just remove the staff email
 	_, err = tx.ExecContext(ctx,
-		"UPDATE appointment_slots SET staff_email=NULL WHERE id=$1",
+		"UPDATE appointment_slots SET staff_email=NULL, updated_at=NOW() WHERE id=$1",
 		appointment,
 	)
 	return false, err
@@ -184,12 +467,14 @@ func (s *Server) SignupForAppointment(ctx context.Context, queue ksuid.KSUID, ap
 		return nil, fmt.Errorf("failed to get appointments for timeslot: %w", err)
 	}
 
-	// Check if an appointment without a student already exists
+	// Check if an appointment without a student already exists. Claimed
+	// slots only cover one timeslot, so longer appointments always get a
+	// new one.
 	for _, a := range appointments {
-		if a.StudentEmail == nil {
+		if a.StudentEmail == nil && a.SlotSpan <= 1 && appointment.SlotSpan <= 1 {
 			err = tx.GetContext(ctx, &newAppointment,
-				"UPDATE appointment_slots SET student_email=$1, name=$2, location=$3, description=$4, map_x=$5, map_y=$6 WHERE id=$7 RETURNING id, queue, student_email, staff_email, scheduled_time, timeslot, duration, name, location, description, map_x, map_y",
-				*appointment.StudentEmail, *appointment.Name, *appointment.Location, *appointment.Description, *appointment.MapX, *appointment.MapY, a.ID,
+				"UPDATE appointment_slots SET student_email=$1, name=$2, location=$3, description=$4, map_x=$5, map_y=$6, meeting_link=$7, anonymous_to_peers=$8, updated_at=NOW() WHERE id=$9 RETURNING id, queue, student_email, staff_email, scheduled_time, timeslot, duration, name, location, description, map_x, map_y, meeting_link, updated_at, anonymous_to_peers, slot_span, resolved",
+				*appointment.StudentEmail, *appointment.Name, *appointment.Location, *appointment.Description, *appointment.MapX, *appointment.MapY, appointment.MeetingLink, appointment.AnonymousToPeers, a.ID,
 			)
 			return &newAppointment, err
 		}
@@ -198,21 +483,56 @@ func (s *Server) SignupForAppointment(ctx context.Context, queue ksuid.KSUID, ap
 	// If not, insert a new appointment
 	id := ksuid.New()
 	err = tx.GetContext(ctx, &newAppointment,
-		"INSERT INTO appointment_slots (id, queue, student_email, scheduled_time, timeslot, duration, name, location, description, map_x, map_y) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11) RETURNING id, queue, student_email, scheduled_time, timeslot, duration, name, location, description, map_x, map_y",
-		id, appointment.Queue, appointment.StudentEmail, appointment.ScheduledTime, appointment.Timeslot, appointment.Duration, appointment.Name, appointment.Location, appointment.Description, appointment.MapX, appointment.MapY,
+		"INSERT INTO appointment_slots (id, queue, student_email, scheduled_time, timeslot, duration, name, location, description, map_x, map_y, meeting_link, anonymous_to_peers, slot_span) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14) RETURNING id, queue, student_email, scheduled_time, timeslot, duration, name, location, description, map_x, map_y, meeting_link, updated_at, anonymous_to_peers, slot_span, resolved",
+		id, appointment.Queue, appointment.StudentEmail, appointment.ScheduledTime, appointment.Timeslot, appointment.Duration, appointment.Name, appointment.Location, appointment.Description, appointment.MapX, appointment.MapY, appointment.MeetingLink, appointment.AnonymousToPeers, appointment.SlotSpan,
 	)
 	return &newAppointment, err
 }
 
+func (s *Server) MoveAppointment(ctx context.Context, appointment ksuid.KSUID, scheduledTime time.Time, timeslot, duration int) (*api.AppointmentSlot, error) {
+	tx := getTransaction(ctx)
+	var a api.AppointmentSlot
+	err := tx.GetContext(ctx, &a,
+		"UPDATE appointment_slots SET scheduled_time=$1, timeslot=$2, duration=$3, updated_at=NOW() WHERE id=$4 RETURNING id, queue, staff_email, student_email, scheduled_time, timeslot, duration, name, location, description, map_x, map_y, meeting_link, updated_at, anonymous_to_peers, slot_span, resolved",
+		scheduledTime, timeslot, duration, appointment,
+	)
+	return &a, err
+}
+
 func (s *Server) UpdateAppointment(ctx context.Context, appointment ksuid.KSUID, newAppointment *api.AppointmentSlot) error {
 	tx := getTransaction(ctx)
 	_, err := tx.ExecContext(ctx,
-		"UPDATE appointment_slots SET name=$1, location=$2, description=$3, map_x=$4, map_y=$5 WHERE id=$6",
-		newAppointment.Name, newAppointment.Location, newAppointment.Description, newAppointment.MapX, newAppointment.MapY, appointment,
+		"UPDATE appointment_slots SET name=$1, location=$2, description=$3, map_x=$4, map_y=$5, anonymous_to_peers=$6, updated_at=NOW() WHERE id=$7",
+		newAppointment.Name, newAppointment.Location, newAppointment.Description, newAppointment.MapX, newAppointment.MapY, newAppointment.AnonymousToPeers, appointment,
+	)
+	return err
+}
+
+func (s *Server) SetAppointmentMeetingLink(ctx context.Context, appointment ksuid.KSUID, link *string) error {
+	tx := getTransaction(ctx)
+	_, err := tx.ExecContext(ctx,
+		"UPDATE appointment_slots SET meeting_link=$1, updated_at=NOW() WHERE id=$2",
+		link, appointment,
 	)
 	return err
 }
 
+// AttachMeetingLink sets an appointment's meeting link once its signup
+// has committed, as long as student still has it and it doesn't have a
+// link yet. It runs outside of any request, so it doesn't use one's
+// transaction.
+func (s *Server) AttachMeetingLink(ctx context.Context, appointment ksuid.KSUID, student, link string) (bool, error) {
+	res, err := s.DB.ExecContext(ctx,
+		"UPDATE appointment_slots SET meeting_link=$1, updated_at=NOW() WHERE id=$2 AND student_email=$3 AND meeting_link IS NULL",
+		link, appointment, student,
+	)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n == 1, err
+}
+
 func (s *Server) RemoveAppointmentSignup(ctx context.Context, appointment ksuid.KSUID) (deleted bool, newAppointment *api.AppointmentSlot, err error) {
 	tx := getTransaction(ctx)
 	a, err := s.GetAppointment(ctx, appointment)
@@ -222,19 +542,237 @@ func (s *Server) RemoveAppointmentSignup(ctx context.Context, appointment ksuid.
 
 	// If there's no staff member associated with this appointment, just drop it
 	if a.StaffEmail == nil {
-		_, err = tx.ExecContext(ctx,
-			"DELETE FROM appointment_slots WHERE id=$1",
-			appointment,
-		)
-		return true, nil, err
+		return true, nil, s.deleteAppointment(ctx, a)
 	}
 
 	// If a staff member has a claim on this appointment, don't delete it,
 	// just set the student fields to null
+	_, err = tx.ExecContext(ctx,
+		"DELETE FROM appointment_partners WHERE appointment=$1",
+		appointment,
+	)
+	if err != nil {
+		return false, nil, fmt.Errorf("failed to remove appointment partners: %w", err)
+	}
+
+	_, err = tx.ExecContext(ctx,
+		"DELETE FROM appointment_labels WHERE appointment=$1",
+		appointment,
+	)
+	if err != nil {
+		return false, nil, fmt.Errorf("failed to remove appointment labels: %w", err)
+	}
+
+	_, err = tx.ExecContext(ctx,
+		"DELETE FROM appointment_custom_fields WHERE appointment=$1",
+		appointment,
+	)
+	if err != nil {
+		return false, nil, fmt.Errorf("failed to remove appointment custom fields: %w", err)
+	}
+
 	var newAppt api.AppointmentSlot
 	err = tx.GetContext(ctx, &newAppt,
-		"UPDATE appointment_slots SET student_email=NULL, name=NULL, location=NULL, description=NULL, map_x=NULL, map_y=NULL WHERE id=$1 RETURNING *",
+		"UPDATE appointment_slots SET student_email=NULL, name=NULL, location=NULL, description=NULL, map_x=NULL, map_y=NULL, meeting_link=NULL, anonymous_to_peers=FALSE, duration=duration/slot_span, slot_span=1, resolved=NULL, updated_at=NOW() WHERE id=$1 RETURNING *",
 		appointment,
 	)
 	return false, &newAppt, err
 }
+
+func (s *Server) GetAppointmentPartners(ctx context.Context, appointment ksuid.KSUID) ([]string, error) {
+	tx := getTransaction(ctx)
+	partners := make([]string, 0)
+	err := tx.SelectContext(ctx, &partners,
+		"SELECT email FROM appointment_partners WHERE appointment=$1 ORDER BY email",
+		appointment,
+	)
+	return partners, err
+}
+
+func (s *Server) AddAppointmentPartners(ctx context.Context, appointment ksuid.KSUID, partners []string) error {
+	tx := getTransaction(ctx)
+	for _, p := range partners {
+		_, err := tx.ExecContext(ctx,
+			"INSERT INTO appointment_partners (appointment, email) VALUES ($1, $2)",
+			appointment, p,
+		)
+		if err != nil {
+			return fmt.Errorf("failed to add partner %s: %w", p, err)
+		}
+	}
+	return nil
+}
+
+// RemoveAppointmentPartner takes email off of an appointment's partners,
+// returning false if they weren't on it.
+func (s *Server) RemoveAppointmentPartner(ctx context.Context, appointment ksuid.KSUID, email string) (bool, error) {
+	tx := getTransaction(ctx)
+	result, err := tx.ExecContext(ctx,
+		"DELETE FROM appointment_partners WHERE appointment=$1 AND email=$2",
+		appointment, email,
+	)
+	if err != nil {
+		return false, err
+	}
+
+	n, err := result.RowsAffected()
+	return n > 0, err
+}
+
+func (s *Server) GetAppointmentLabels(ctx context.Context, appointment ksuid.KSUID) ([]string, error) {
+	tx := getTransaction(ctx)
+	labels := make([]string, 0)
+	err := tx.SelectContext(ctx, &labels,
+		"SELECT label FROM appointment_labels WHERE appointment=$1 ORDER BY label",
+		appointment,
+	)
+	return labels, err
+}
+
+func (s *Server) GetAppointmentLabelsInTimeFrame(ctx context.Context, queue ksuid.KSUID, from, to time.Time) (map[ksuid.KSUID][]string, error) {
+	tx := getTransaction(ctx)
+	var rows []struct {
+		Appointment ksuid.KSUID `db:"appointment"`
+		Label       string      `db:"label"`
+	}
+	err := tx.SelectContext(ctx, &rows,
+		"SELECT l.appointment, l.label FROM appointment_labels l JOIN appointment_slots a ON l.appointment=a.id WHERE a.queue=$1 AND a.scheduled_time >= $2 AND a.scheduled_time <= $3 ORDER BY l.label",
+		queue, from, to,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	labels := make(map[ksuid.KSUID][]string)
+	for _, r := range rows {
+		labels[r.Appointment] = append(labels[r.Appointment], r.Label)
+	}
+	return labels, nil
+}
+
+func (s *Server) SetAppointmentLabels(ctx context.Context, appointment ksuid.KSUID, labels []string) error {
+	tx := getTransaction(ctx)
+	_, err := tx.ExecContext(ctx,
+		"DELETE FROM appointment_labels WHERE appointment=$1",
+		appointment,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to remove old labels: %w", err)
+	}
+
+	for _, l := range labels {
+		_, err = tx.ExecContext(ctx,
+			"INSERT INTO appointment_labels (appointment, label) VALUES ($1, $2)",
+			appointment, l,
+		)
+		if err != nil {
+			return fmt.Errorf("failed to add label %s: %w", l, err)
+		}
+	}
+	return nil
+}
+
+func (s *Server) SetAppointmentResolved(ctx context.Context, appointment ksuid.KSUID, resolved *bool) error {
+	tx := getTransaction(ctx)
+	_, err := tx.ExecContext(ctx,
+		"UPDATE appointment_slots SET resolved=$1, updated_at=NOW() WHERE id=$2",
+		resolved, appointment,
+	)
+	return err
+}
+
+// GetUnresolvedAppointments gets the appointments in a queue that staff
+// marked as not resolved, oldest first.
+func (s *Server) GetUnresolvedAppointments(ctx context.Context, queue ksuid.KSUID) ([]*api.AppointmentSlot, error) {
+	tx := getTransaction(ctx)
+	appointments := make([]*api.AppointmentSlot, 0)
+	err := tx.SelectContext(ctx, &appointments,
+		"SELECT id, queue, staff_email, student_email, scheduled_time, timeslot, duration, name, location, description, map_x, map_y, meeting_link, updated_at, anonymous_to_peers, slot_span, resolved FROM appointment_slots WHERE queue=$1 AND resolved=FALSE AND student_email IS NOT NULL ORDER BY scheduled_time",
+		queue,
+	)
+	return appointments, err
+}
+
+// deleteAppointment removes an appointment slot, leaving a tombstone
+// behind so that clients syncing incrementally find out it's gone.
+func (s *Server) deleteAppointment(ctx context.Context, a *api.AppointmentSlot) error {
+	tx := getTransaction(ctx)
+	res, err := tx.ExecContext(ctx,
+		"DELETE FROM appointment_slots WHERE id=$1",
+		a.ID,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to delete appointment: %w", err)
+	}
+
+	// Someone else got to it first; there's nothing to leave a tombstone for.
+	n, err := res.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to get rows affected by delete: %w", err)
+	}
+	if n == 0 {
+		return fmt.Errorf("appointment already deleted: %w", sql.ErrNoRows)
+	}
+
+	_, err = tx.ExecContext(ctx,
+		"INSERT INTO appointment_tombstones (id, queue, scheduled_time, removed_at) VALUES ($1, $2, $3, NOW())",
+		a.ID, a.Queue, a.ScheduledTime,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to insert appointment tombstone: %w", err)
+	}
+
+	return nil
+}
+
+// PruneAppointments deletes up to limit appointments scheduled before a
+// cutoff, along with up to limit tombstones left before it. It runs
+// outside of any request, so it has its own transaction; keeping each
+// one small means it doesn't hold locks on a big table for long.
+func (s *Server) PruneAppointments(ctx context.Context, before time.Time, limit int) (appointments, tombstones int64, err error) {
+	tx, err := s.DB.BeginTxx(ctx, nil)
+	if err != nil {
+		return 0, 0, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	res, err := tx.ExecContext(ctx,
+		"DELETE FROM appointment_slots WHERE id IN (SELECT id FROM appointment_slots WHERE scheduled_time < $1 LIMIT $2)",
+		before, limit,
+	)
+	if err != nil {
+		return 0, 0, fmt.Errorf("failed to delete old appointments: %w", err)
+	}
+	appointments, _ = res.RowsAffected()
+
+	res, err = tx.ExecContext(ctx,
+		"DELETE FROM appointment_tombstones WHERE id IN (SELECT id FROM appointment_tombstones WHERE removed_at < $1 LIMIT $2)",
+		before, limit,
+	)
+	if err != nil {
+		return 0, 0, fmt.Errorf("failed to delete old tombstones: %w", err)
+	}
+	tombstones, _ = res.RowsAffected()
+
+	return appointments, tombstones, tx.Commit()
+}
+
+func (s *Server) GetAppointmentsSince(ctx context.Context, queue ksuid.KSUID, since time.Time) ([]*api.AppointmentSlot, error) {
+	tx := getTransaction(ctx)
+	appointments := make([]*api.AppointmentSlot, 0)
+	err := tx.SelectContext(ctx, &appointments,
+		"SELECT id, queue, staff_email, student_email, scheduled_time, timeslot, duration, name, location, description, map_x, map_y, meeting_link, updated_at, anonymous_to_peers, slot_span, resolved FROM appointment_slots WHERE queue=$1 AND updated_at > $2 ORDER BY updated_at, id",
+		queue, since,
+	)
+	return appointments, err
+}
+
+func (s *Server) GetAppointmentTombstonesSince(ctx context.Context, queue ksuid.KSUID, since time.Time) ([]*api.AppointmentTombstone, error) {
+	tx := getTransaction(ctx)
+	tombstones := make([]*api.AppointmentTombstone, 0)
+	err := tx.SelectContext(ctx, &tombstones,
+		"SELECT id, queue, scheduled_time, removed_at FROM appointment_tombstones WHERE queue=$1 AND removed_at > $2 ORDER BY removed_at, id",
+		queue, since,
+	)
+	return tombstones, err
+}
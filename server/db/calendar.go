@@ -0,0 +1,73 @@
+package db
+
+import (
+	"context"
+	"time"
+
+	"github.com/CarsonHoffman/office-hours-queue/server/api"
+	"github.com/segmentio/ksuid"
+	"golang.org/x/oauth2"
+)
+
+func (s *Server) GetGoogleCalendarToken(ctx context.Context, email string) (*oauth2.Token, error) {
+	tx := getTransaction(ctx)
+	var t struct {
+		AccessToken  string    `db:"access_token"`
+		RefreshToken string    `db:"refresh_token"`
+		TokenType    string    `db:"token_type"`
+		Expiry       time.Time `db:"expiry"`
+	}
+	err := tx.GetContext(ctx, &t,
+		"SELECT access_token, refresh_token, token_type, expiry FROM google_calendar_tokens WHERE email=$1",
+		email,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return &oauth2.Token{
+		AccessToken:  t.AccessToken,
+		RefreshToken: t.RefreshToken,
+		TokenType:    t.TokenType,
+		Expiry:       t.Expiry,
+	}, nil
+}
+
+func (s *Server) SetGoogleCalendarToken(ctx context.Context, email string, token *oauth2.Token) error {
+	tx := getTransaction(ctx)
+	// Google only hands out a refresh token the first time someone
+	// consents, so don't throw away the one we have for an empty one.
+	_, err := tx.ExecContext(ctx,
+		"INSERT INTO google_calendar_tokens (email, access_token, refresh_token, token_type, expiry) VALUES ($1, $2, $3, $4, $5) ON CONFLICT (email) DO UPDATE SET access_token=EXCLUDED.access_token, refresh_token=COALESCE(NULLIF(EXCLUDED.refresh_token, ''), google_calendar_tokens.refresh_token), token_type=EXCLUDED.token_type, expiry=EXCLUDED.expiry",
+		email, token.AccessToken, token.RefreshToken, token.TokenType, token.Expiry,
+	)
+	return err
+}
+
+func (s *Server) GetCalendarEvents(ctx context.Context, appointment ksuid.KSUID) ([]*api.CalendarEventLink, error) {
+	tx := getTransaction(ctx)
+	events := make([]*api.CalendarEventLink, 0)
+	err := tx.SelectContext(ctx, &events,
+		"SELECT appointment, email, event_id FROM appointment_calendar_events WHERE appointment=$1 ORDER BY email",
+		appointment,
+	)
+	return events, err
+}
+
+func (s *Server) SetCalendarEvent(ctx context.Context, appointment ksuid.KSUID, email, eventID string) error {
+	tx := getTransaction(ctx)
+	_, err := tx.ExecContext(ctx,
+		"INSERT INTO appointment_calendar_events (appointment, email, event_id) VALUES ($1, $2, $3) ON CONFLICT (appointment, email) DO UPDATE SET event_id=EXCLUDED.event_id",
+		appointment, email, eventID,
+	)
+	return err
+}
+
+func (s *Server) RemoveCalendarEvents(ctx context.Context, appointment ksuid.KSUID) error {
+	tx := getTransaction(ctx)
+	_, err := tx.ExecContext(ctx,
+		"DELETE FROM appointment_calendar_events WHERE appointment=$1",
+		appointment,
+	)
+	return err
+}
@@ -0,0 +1,108 @@
+package db
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/CarsonHoffman/office-hours-queue/server/api"
+	"github.com/segmentio/ksuid"
+)
+
+func (s *Server) GetCustomFieldDefinitions(ctx context.Context, queue ksuid.KSUID) ([]*api.CustomFieldDefinition, error) {
+	tx := getTransaction(ctx)
+	definitions := make([]*api.CustomFieldDefinition, 0)
+	err := tx.SelectContext(ctx, &definitions,
+		"SELECT name, type, required FROM appointment_custom_field_definitions WHERE queue=$1 ORDER BY position",
+		queue,
+	)
+	return definitions, err
+}
+
+func (s *Server) SetCustomFieldDefinitions(ctx context.Context, queue ksuid.KSUID, definitions []*api.CustomFieldDefinition) error {
+	tx := getTransaction(ctx)
+	_, err := tx.ExecContext(ctx,
+		"DELETE FROM appointment_custom_field_definitions WHERE queue=$1",
+		queue,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to delete existing custom field definitions: %w", err)
+	}
+
+	for i, d := range definitions {
+		_, err = tx.ExecContext(ctx,
+			"INSERT INTO appointment_custom_field_definitions (queue, name, type, required, position) VALUES ($1, $2, $3, $4, $5)",
+			queue, d.Name, d.Type, d.Required, i,
+		)
+		if err != nil {
+			return fmt.Errorf("failed to insert custom field definition %s: %w", d.Name, err)
+		}
+	}
+
+	return nil
+}
+
+// Custom field values are stored as JSON, since each field can hold a
+// different type.
+
+type customFieldRow struct {
+	Appointment ksuid.KSUID `db:"appointment"`
+	Name        string      `db:"name"`
+	Value       string      `db:"value"`
+}
+
+func (s *Server) GetAppointmentCustomFieldsInTimeFrame(ctx context.Context, queue ksuid.KSUID, from, to time.Time) (map[ksuid.KSUID]map[string]interface{}, error) {
+	tx := getTransaction(ctx)
+	var rows []customFieldRow
+	err := tx.SelectContext(ctx, &rows,
+		"SELECT f.appointment, f.name, f.value FROM appointment_custom_fields f JOIN appointment_slots a ON f.appointment=a.id WHERE a.queue=$1 AND a.scheduled_time >= $2 AND a.scheduled_time <= $3",
+		queue, from, to,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	fields := make(map[ksuid.KSUID]map[string]interface{})
+	for _, r := range rows {
+		var v interface{}
+		err = json.Unmarshal([]byte(r.Value), &v)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode custom field %s on appointment %s: %w", r.Name, r.Appointment, err)
+		}
+
+		if fields[r.Appointment] == nil {
+			fields[r.Appointment] = make(map[string]interface{})
+		}
+		fields[r.Appointment][r.Name] = v
+	}
+	return fields, nil
+}
+
+func (s *Server) SetAppointmentCustomFields(ctx context.Context, appointment ksuid.KSUID, fields map[string]interface{}) error {
+	tx := getTransaction(ctx)
+	_, err := tx.ExecContext(ctx,
+		"DELETE FROM appointment_custom_fields WHERE appointment=$1",
+		appointment,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to delete existing custom fields: %w", err)
+	}
+
+	for name, v := range fields {
+		value, err := json.Marshal(v)
+		if err != nil {
+			return fmt.Errorf("failed to encode custom field %s: %w", name, err)
+		}
+
+		_, err = tx.ExecContext(ctx,
+			"INSERT INTO appointment_custom_fields (appointment, name, value) VALUES ($1, $2, $3)",
+			appointment, name, string(value),
+		)
+		if err != nil {
+			return fmt.Errorf("failed to insert custom field %s: %w", name, err)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,70 @@
+package db
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/CarsonHoffman/office-hours-queue/server/api"
+	"github.com/segmentio/ksuid"
+)
+
+// A region's outline is stored as JSON, since it's only ever read and
+// written as a whole.
+
+type mapRegionRow struct {
+	Name   string `db:"name"`
+	Points string `db:"points"`
+}
+
+func (s *Server) GetMapRegions(ctx context.Context, queue ksuid.KSUID) ([]*api.MapRegion, error) {
+	tx := getTransaction(ctx)
+	var rows []mapRegionRow
+	err := tx.SelectContext(ctx, &rows,
+		"SELECT name, points FROM appointment_map_regions WHERE queue=$1 ORDER BY position",
+		queue,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	regions := make([]*api.MapRegion, 0, len(rows))
+	for _, row := range rows {
+		region := api.MapRegion{Name: row.Name}
+		err = json.Unmarshal([]byte(row.Points), &region.Points)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode points of map region %s: %w", row.Name, err)
+		}
+		regions = append(regions, &region)
+	}
+
+	return regions, nil
+}
+
+func (s *Server) SetMapRegions(ctx context.Context, queue ksuid.KSUID, regions []*api.MapRegion) error {
+	tx := getTransaction(ctx)
+	_, err := tx.ExecContext(ctx,
+		"DELETE FROM appointment_map_regions WHERE queue=$1",
+		queue,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to delete existing map regions: %w", err)
+	}
+
+	for i, r := range regions {
+		points, err := json.Marshal(r.Points)
+		if err != nil {
+			return fmt.Errorf("failed to encode points of map region %s: %w", r.Name, err)
+		}
+
+		_, err = tx.ExecContext(ctx,
+			"INSERT INTO appointment_map_regions (queue, name, points, position) VALUES ($1, $2, $3, $4)",
+			queue, r.Name, string(points), i,
+		)
+		if err != nil {
+			return fmt.Errorf("failed to insert map region %s: %w", r.Name, err)
+		}
+	}
+
+	return nil
+}
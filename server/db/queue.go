@@ -86,7 +86,7 @@ func (s *Server) GetQueueConfiguration(ctx context.Context, queue ksuid.KSUID) (
 	tx := getTransaction(ctx)
 	var config api.QueueConfiguration
 	err := tx.GetContext(ctx, &config,
-		"SELECT id, enable_location_field, prevent_unregistered, prevent_groups, prevent_groups_boost, prioritize_new, cooldown, virtual, scheduled, manual_open FROM queues WHERE id=$1",
+		"SELECT id, enable_location_field, prevent_unregistered, prevent_groups, prevent_groups_boost, prioritize_new, cooldown, virtual, scheduled, manual_open, future_appointment_scope, appointment_location_type, availability_display, max_concurrent_appointments, show_timeslot_members, disallow_same_day_reschedule, optional_appointment_description, optional_appointment_location, require_schedule_approval, max_appointment_field_length, min_appointment_description_length, claim_grace_minutes, description_template, require_description_template, signups_frozen, require_signup_time_confirmation, fully_booked_message, waitlist, auto_waitlist FROM queues WHERE id=$1",
 		queue,
 	)
 	return &config, err
@@ -95,8 +95,8 @@ func (s *Server) GetQueueConfiguration(ctx context.Context, queue ksuid.KSUID) (
 func (s *Server) UpdateQueueConfiguration(ctx context.Context, queue ksuid.KSUID, config *api.QueueConfiguration) error {
 	tx := getTransaction(ctx)
 	_, err := tx.ExecContext(ctx,
-		"UPDATE queues SET enable_location_field=$1, prevent_unregistered=$2, prevent_groups=$3, prevent_groups_boost=$4, prioritize_new=$5, cooldown=$6, virtual=$7, scheduled=$8 WHERE id=$9",
-		config.EnableLocationField, config.PreventUnregistered, config.PreventGroups, config.PreventGroupsBoost, config.PrioritizeNew, config.Cooldown, config.Virtual, config.Scheduled, queue,
+		"UPDATE queues SET enable_location_field=$1, prevent_unregistered=$2, prevent_groups=$3, prevent_groups_boost=$4, prioritize_new=$5, cooldown=$6, virtual=$7, scheduled=$8, future_appointment_scope=$9, appointment_location_type=$10, availability_display=$11, max_concurrent_appointments=$12, show_timeslot_members=$13, disallow_same_day_reschedule=$14, optional_appointment_description=$15, optional_appointment_location=$16, require_schedule_approval=$17, max_appointment_field_length=$18, min_appointment_description_length=$19, claim_grace_minutes=$20, description_template=$21, require_description_template=$22, signups_frozen=$23, require_signup_time_confirmation=$24, fully_booked_message=$25, waitlist=$26, auto_waitlist=$27 WHERE id=$28",
+		config.EnableLocationField, config.PreventUnregistered, config.PreventGroups, config.PreventGroupsBoost, config.PrioritizeNew, config.Cooldown, config.Virtual, config.Scheduled, config.FutureAppointmentScope, config.AppointmentLocationType, config.AvailabilityDisplay, config.MaxConcurrentAppointments, config.ShowTimeslotMembers, config.DisallowSameDayReschedule, config.OptionalAppointmentDescription, config.OptionalAppointmentLocation, config.RequireScheduleApproval, config.MaxAppointmentFieldLength, config.MinAppointmentDescriptionLength, config.ClaimGraceMinutes, config.DescriptionTemplate, config.RequireDescriptionTemplate, config.SignupsFrozen, config.RequireSignupTimeConfirmation, config.FullyBookedMessage, config.Waitlist, config.AutoWaitlist, queue,
 	)
 	return err
 }
@@ -0,0 +1,67 @@
+package db
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/CarsonHoffman/office-hours-queue/server/api"
+	"github.com/segmentio/ksuid"
+)
+
+// Schedules are stored in the audit log as JSON, so entries keep showing
+// what a schedule looked like even as the schedule tables change.
+
+type scheduleAuditRow struct {
+	api.ScheduleAuditEntry
+	Before string `db:"before"`
+	After  string `db:"after"`
+}
+
+func (s *Server) AddScheduleAuditEntry(ctx context.Context, entry *api.ScheduleAuditEntry) error {
+	tx := getTransaction(ctx)
+	before, err := json.Marshal(entry.Before)
+	if err != nil {
+		return fmt.Errorf("failed to encode schedule before change: %w", err)
+	}
+
+	after, err := json.Marshal(entry.After)
+	if err != nil {
+		return fmt.Errorf("failed to encode schedule after change: %w", err)
+	}
+
+	_, err = tx.ExecContext(ctx,
+		"INSERT INTO appointment_schedule_audit (id, queue, day, email, before, after) VALUES ($1, $2, $3, $4, $5, $6)",
+		ksuid.New(), entry.Queue, entry.Day, entry.Email, string(before), string(after),
+	)
+	return err
+}
+
+func (s *Server) GetScheduleAuditLog(ctx context.Context, queue ksuid.KSUID) ([]*api.ScheduleAuditEntry, error) {
+	tx := getTransaction(ctx)
+	var rows []scheduleAuditRow
+	err := tx.SelectContext(ctx, &rows,
+		"SELECT id, queue, day, email, before, after, created_at FROM appointment_schedule_audit WHERE queue=$1 ORDER BY id",
+		queue,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	entries := make([]*api.ScheduleAuditEntry, 0, len(rows))
+	for i := range rows {
+		entry := rows[i].ScheduleAuditEntry
+		err = json.Unmarshal([]byte(rows[i].Before), &entry.Before)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode schedule before change in audit entry %s: %w", entry.ID, err)
+		}
+
+		err = json.Unmarshal([]byte(rows[i].After), &entry.After)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode schedule after change in audit entry %s: %w", entry.ID, err)
+		}
+		entries = append(entries, &entry)
+	}
+
+	return entries, nil
+}
@@ -42,6 +42,10 @@ func New(url, database, username, password string) (*Server, error) {
 	return &s, nil
 }
 
+func (s *Server) Close() error {
+	return s.DB.Close()
+}
+
 func (s *Server) SiteAdmin(ctx context.Context, email string) (bool, error) {
 	var n int
 	err := s.DB.GetContext(ctx, &n,
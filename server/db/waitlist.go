@@ -0,0 +1,58 @@
+package db
+
+import (
+	"context"
+	"time"
+
+	"github.com/CarsonHoffman/office-hours-queue/server/api"
+	"github.com/segmentio/ksuid"
+)
+
+func (s *Server) AddToWaitlist(ctx context.Context, queue ksuid.KSUID, scheduledTime time.Time, timeslot int, email string) (bool, error) {
+	tx := getTransaction(ctx)
+	res, err := tx.ExecContext(ctx,
+		"INSERT INTO appointment_waitlist (id, queue, scheduled_time, timeslot, email) VALUES ($1, $2, $3, $4, $5) ON CONFLICT DO NOTHING",
+		ksuid.New(), queue, scheduledTime, timeslot, email,
+	)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n == 1, err
+}
+
+func (s *Server) RemoveFromWaitlist(ctx context.Context, queue ksuid.KSUID, scheduledTime time.Time, email string) (bool, error) {
+	tx := getTransaction(ctx)
+	res, err := tx.ExecContext(ctx,
+		"DELETE FROM appointment_waitlist WHERE queue=$1 AND scheduled_time=$2 AND email=$3",
+		queue, scheduledTime, email,
+	)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n == 1, err
+}
+
+func (s *Server) GetWaitlist(ctx context.Context, queue ksuid.KSUID, scheduledTime time.Time) ([]*api.WaitlistEntry, error) {
+	tx := getTransaction(ctx)
+	entries := make([]*api.WaitlistEntry, 0)
+	err := tx.SelectContext(ctx, &entries,
+		"SELECT id, queue, scheduled_time, timeslot, email FROM appointment_waitlist WHERE queue=$1 AND scheduled_time=$2 ORDER BY id",
+		queue, scheduledTime,
+	)
+	return entries, err
+}
+
+// PopWaitlist takes the first student off the waitlist for the timeslot
+// starting at scheduledTime. Another request popping the same waitlist
+// at the same time skips over them, so nobody gets told twice.
+func (s *Server) PopWaitlist(ctx context.Context, queue ksuid.KSUID, scheduledTime time.Time) (*api.WaitlistEntry, error) {
+	tx := getTransaction(ctx)
+	var entry api.WaitlistEntry
+	err := tx.GetContext(ctx, &entry,
+		"DELETE FROM appointment_waitlist WHERE id=(SELECT id FROM appointment_waitlist WHERE queue=$1 AND scheduled_time=$2 ORDER BY id LIMIT 1 FOR UPDATE SKIP LOCKED) RETURNING id, queue, scheduled_time, timeslot, email",
+		queue, scheduledTime,
+	)
+	return &entry, err
+}
@@ -0,0 +1,70 @@
+package events
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/CarsonHoffman/office-hours-queue/server/api"
+	"github.com/nats-io/nats.go"
+)
+
+// NATSPublisher publishes appointment events to a JetStream stream, which
+// gives downstream consumers durability and replay. Events are published
+// on <subject>.<event type>, so the stream should be set up to capture
+// <subject>.>.
+type NATSPublisher struct {
+	conn    *nats.Conn
+	js      nats.JetStreamContext
+	subject string
+}
+
+// How long to wait for JetStream to acknowledge an event when the caller
+// hasn't set a deadline of their own. Without one, a publish with NATS
+// down waits forever.
+const publishTimeout = 5 * time.Second
+
+func NewNATSPublisher(url, subject string) (*NATSPublisher, error) {
+	conn, err := nats.Connect(url,
+		nats.Name("office-hours-queue"),
+		nats.MaxReconnects(-1),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to NATS: %w", err)
+	}
+
+	js, err := conn.JetStream()
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("failed to set up JetStream context: %w", err)
+	}
+
+	return &NATSPublisher{conn: conn, js: js, subject: subject}, nil
+}
+
+func (p *NATSPublisher) Publish(ctx context.Context, event *api.AppointmentEvent) error {
+	data, err := json.Marshal(event)
+	if err != nil {
+		return fmt.Errorf("failed to marshal event: %w", err)
+	}
+
+	msg := nats.NewMsg(p.subject + "." + string(event.Type))
+	msg.Data = data
+
+	// Lets JetStream drop duplicates if we end up retrying a publish.
+	msg.Header.Set(nats.MsgIdHdr, event.ID.String())
+
+	if _, ok := ctx.Deadline(); !ok {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, publishTimeout)
+		defer cancel()
+	}
+
+	_, err = p.js.PublishMsg(msg, nats.Context(ctx))
+	return err
+}
+
+func (p *NATSPublisher) Close() {
+	p.conn.Close()
+}
@@ -1,19 +1,134 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"io/ioutil"
+	"net"
 	"net/http"
 	"net/http/pprof"
 	"os"
+	"os/signal"
+	"strconv"
+	"sync"
+	"syscall"
+	"time"
 
 	"github.com/CarsonHoffman/office-hours-queue/server/api"
 	"github.com/CarsonHoffman/office-hours-queue/server/db"
+	"github.com/CarsonHoffman/office-hours-queue/server/events"
 	"github.com/go-chi/chi/v5"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
 	"go.uber.org/zap"
 	"golang.org/x/oauth2"
 	"golang.org/x/oauth2/google"
 )
 
+// How long to wait for in-flight requests when shutting down. Docker
+// gives containers ten seconds after SIGTERM before killing them, so
+// this needs to stay under that.
+const shutdownTimeout = 8 * time.Second
+
+// How often to look for appointments old enough to delete.
+const pruneInterval = time.Hour
+
+// How many appointments (and tombstones) get deleted per transaction
+// when pruning, unless QUEUE_APPOINTMENT_PRUNE_BATCH_SIZE says otherwise.
+const defaultPruneBatchSize = 1000
+
+// How long to wait between batches, so a big backlog (like the first run
+// after retention is turned on) doesn't crowd out requests.
+const pruneBatchPause = time.Second
+
+type appointmentPruner interface {
+	PruneAppointments(ctx context.Context, before time.Time, limit int) (appointments, tombstones int64, err error)
+}
+
+// pruneAppointments deletes appointments more than retention before now
+// every pruneInterval, until ctx is cancelled. Each batch waits its turn
+// on pool with the rest of the background work.
+func pruneAppointments(ctx context.Context, l *zap.SugaredLogger, d appointmentPruner, pool *api.WorkerPool, now func() time.Time, retention time.Duration, batchSize int) {
+	ticker := time.NewTicker(pruneInterval)
+	defer ticker.Stop()
+
+	for {
+		before := now().Add(-retention)
+		var totalAppointments, totalTombstones int64
+		for {
+			var appointments, tombstones int64
+			var err error
+			poolErr := pool.Do(ctx, func() {
+				appointments, tombstones, err = d.PruneAppointments(ctx, before, batchSize)
+			})
+			if poolErr != nil {
+				return
+			}
+			if err != nil {
+				l.Errorw("failed to prune old appointments", "err", err)
+				break
+			}
+			totalAppointments += appointments
+			totalTombstones += tombstones
+
+			// A short batch means there's nothing left.
+			if appointments < int64(batchSize) && tombstones < int64(batchSize) {
+				break
+			}
+
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(pruneBatchPause):
+			}
+		}
+
+		if totalAppointments > 0 || totalTombstones > 0 {
+			l.Infow("pruned old appointments",
+				"before", before,
+				"appointments", totalAppointments,
+				"tombstones", totalTombstones,
+			)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// serve runs server on ln until a signal comes in on stop. Then it stops
+// taking new requests and lets the ones in flight (and their
+// transactions) finish, giving up on them after timeout. WebSockets are
+// hijacked, so they don't hold this up; they just get cut off when the
+// process exits.
+func serve(l *zap.SugaredLogger, server *http.Server, ln net.Listener, stop <-chan os.Signal, timeout time.Duration) error {
+	failed := make(chan error, 1)
+	go func() {
+		err := server.Serve(ln)
+		if !errors.Is(err, http.ErrServerClosed) {
+			failed <- err
+		}
+	}()
+
+	select {
+	case err := <-failed:
+		return err
+	case sig := <-stop:
+		l.Infow("shutting down", "signal", sig.String())
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	err := server.Shutdown(ctx)
+	if err != nil {
+		l.Errorw("failed to shut down http server cleanly", "err", err)
+	}
+	return nil
+}
+
 func main() {
 	z, _ := zap.NewProduction()
 	l := z.Sugar().With("name", "queue")
@@ -41,7 +156,51 @@ func main() {
 		l.Fatalw("failed to set up database", "err", err)
 	}
 
-	s := api.New(db, l, db.DB.DB, config)
+	var publisher api.EventPublisher
+	if url := os.Getenv("QUEUE_NATS_URL"); url != "" {
+		p, err := events.NewNATSPublisher(url, os.Getenv("QUEUE_NATS_SUBJECT"))
+		if err != nil {
+			l.Fatalw("failed to set up NATS event publisher", "err", err)
+		}
+		// Closed below once the HTTP server has drained, so events
+		// from the last requests still get out.
+		publisher = p
+	}
+
+	var meetings api.MeetingProvider
+	if url := os.Getenv("QUEUE_JITSI_URL"); url != "" {
+		meetings = api.JitsiMeetingProvider{BaseURL: url}
+	}
+
+	// Spans pick up the trace context of whoever called us. They go
+	// nowhere until a tracer provider is registered with otel; without
+	// one, tracing is a no-op.
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+
+	workers := api.DefaultBackgroundWorkers
+	if n, err := strconv.Atoi(os.Getenv("QUEUE_BACKGROUND_WORKERS")); err == nil && n > 0 {
+		workers = n
+	}
+	pool := api.NewWorkerPool(workers)
+
+	s := api.New(db, l, db.DB.DB, config, publisher, meetings, pool)
+
+	// Background jobs get stopped (and waited for) before the database
+	// is closed on shutdown.
+	var jobsRunning sync.WaitGroup
+	jobs, stopJobs := context.WithCancel(context.Background())
+	if days, err := strconv.Atoi(os.Getenv("QUEUE_APPOINTMENT_RETENTION_DAYS")); err == nil && days > 0 {
+		batchSize := defaultPruneBatchSize
+		if size, err := strconv.Atoi(os.Getenv("QUEUE_APPOINTMENT_PRUNE_BATCH_SIZE")); err == nil && size > 0 {
+			batchSize = size
+		}
+
+		jobsRunning.Add(1)
+		go func() {
+			defer jobsRunning.Done()
+			pruneAppointments(jobs, l, db, pool, time.Now, time.Duration(days)*24*time.Hour, batchSize)
+		}()
+	}
 
 	r := chi.NewRouter()
 	r.Mount("/", s)
@@ -58,5 +217,31 @@ func main() {
 		l.Fatalw("pprof server failed", "err", http.ListenAndServe(":6060", d))
 	}()
 
-	l.Fatalw("http server failed", "err", http.ListenAndServe(":8080", r))
+	ln, err := net.Listen("tcp", ":8080")
+	if err != nil {
+		l.Fatalw("failed to listen for http server", "err", err)
+	}
+
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, syscall.SIGINT, syscall.SIGTERM)
+	err = serve(l, &http.Server{Handler: r}, ln, stop, shutdownTimeout)
+	if err != nil {
+		l.Fatalw("http server failed", "err", err)
+	}
+	stopJobs()
+
+	// Events (and anything else) waiting on the last requests'
+	// transactions go out before the publisher is closed.
+	s.WaitForAfterCommit()
+
+	if p, ok := publisher.(*events.NATSPublisher); ok {
+		p.Close()
+	}
+
+	jobsRunning.Wait()
+	pool.Close()
+	err = db.Close()
+	if err != nil {
+		l.Errorw("failed to close database", "err", err)
+	}
 }
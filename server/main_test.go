@@ -0,0 +1,184 @@
+package main
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"os"
+	"reflect"
+	"sort"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/CarsonHoffman/office-hours-queue/server/api"
+	"go.uber.org/zap"
+)
+
+func TestServeDrainsInFlightRequests(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+
+	// Stands in for a signup: it's partway through when the shutdown
+	// comes in.
+	started := make(chan struct{})
+	finish := make(chan struct{})
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		<-finish
+		w.WriteHeader(http.StatusCreated)
+	})
+
+	stop := make(chan os.Signal, 1)
+	served := make(chan error, 1)
+	go func() {
+		served <- serve(zap.NewNop().Sugar(), &http.Server{Handler: handler}, ln, stop, 5*time.Second)
+	}()
+
+	responses := make(chan *http.Response, 1)
+	errs := make(chan error, 1)
+	go func() {
+		resp, err := http.Post("http://"+ln.Addr().String()+"/", "application/json", nil)
+		if err != nil {
+			errs <- err
+			return
+		}
+		resp.Body.Close()
+		responses <- resp
+	}()
+
+	<-started
+	stop <- syscall.SIGTERM
+
+	select {
+	case err := <-served:
+		t.Fatalf("serve returned (%v) with a request in flight", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	close(finish)
+	select {
+	case resp := <-responses:
+		if resp.StatusCode != http.StatusCreated {
+			t.Errorf("got status %d, want %d", resp.StatusCode, http.StatusCreated)
+		}
+	case err := <-errs:
+		t.Fatalf("in-flight request failed: %v", err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("in-flight request never finished")
+	}
+
+	select {
+	case err := <-served:
+		if err != nil {
+			t.Errorf("got error %v from serve, want nil", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("serve never returned")
+	}
+
+	_, err = net.Dial("tcp", ln.Addr().String())
+	if err == nil {
+		t.Error("still taking connections after shutting down")
+	}
+}
+
+// fakePruner holds appointment times, deleting the ones before the
+// cutoff like the database does.
+type fakePruner struct {
+	appointments []time.Time
+	pruned       chan time.Time
+	batches      []int64
+}
+
+func (p *fakePruner) PruneAppointments(ctx context.Context, before time.Time, limit int) (int64, int64, error) {
+	var kept []time.Time
+	var n int64
+	for _, t := range p.appointments {
+		if t.Before(before) && n < int64(limit) {
+			n++
+			continue
+		}
+		kept = append(kept, t)
+	}
+	p.appointments = kept
+	p.batches = append(p.batches, n)
+	p.pruned <- before
+	return n, 0, nil
+}
+
+func TestPruneAppointmentsKeepsRecent(t *testing.T) {
+	now := time.Date(2021, time.March, 15, 12, 0, 0, 0, time.UTC)
+	day := 24 * time.Hour
+	old := []time.Time{now.Add(-90 * day), now.Add(-31 * day)}
+	recent := []time.Time{now.Add(-29 * day), now.Add(-time.Hour), now.Add(day)}
+
+	p := &fakePruner{
+		appointments: append(append([]time.Time(nil), old...), recent...),
+		pruned:       make(chan time.Time, 1),
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		pruneAppointments(ctx, zap.NewNop().Sugar(), p, api.NewWorkerPool(1), func() time.Time { return now }, 30*day, 10)
+		close(done)
+	}()
+
+	select {
+	case before := <-p.pruned:
+		if want := now.Add(-30 * day); !before.Equal(want) {
+			t.Errorf("pruned before %s, want %s", before, want)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("never pruned")
+	}
+	cancel()
+	<-done
+
+	sort.Slice(p.appointments, func(i, j int) bool { return p.appointments[i].Before(p.appointments[j]) })
+	if len(p.appointments) != len(recent) {
+		t.Fatalf("kept %v, want %v", p.appointments, recent)
+	}
+	for i := range recent {
+		if !p.appointments[i].Equal(recent[i]) {
+			t.Errorf("kept %v, want %v", p.appointments, recent)
+			break
+		}
+	}
+}
+
+func TestPruneAppointmentsInBatches(t *testing.T) {
+	now := time.Date(2021, time.March, 15, 12, 0, 0, 0, time.UTC)
+	day := 24 * time.Hour
+	p := &fakePruner{
+		appointments: []time.Time{now.Add(-90 * day), now.Add(-60 * day), now.Add(-31 * day), now.Add(day)},
+		pruned:       make(chan time.Time, 1),
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		pruneAppointments(ctx, zap.NewNop().Sugar(), p, api.NewWorkerPool(1), func() time.Time { return now }, 30*day, 2)
+		close(done)
+	}()
+
+	// A full batch means there might be more, so it goes back for
+	// another after a pause; the short one after it ends the run.
+	for i := 0; i < 2; i++ {
+		select {
+		case <-p.pruned:
+		case <-time.After(5 * time.Second):
+			t.Fatalf("got %d batches, want 2", i)
+		}
+	}
+	cancel()
+	<-done
+
+	if want := []int64{2, 1}; !reflect.DeepEqual(p.batches, want) {
+		t.Errorf("pruned batches of %v, want %v", p.batches, want)
+	}
+	if len(p.appointments) != 1 || !p.appointments[0].Equal(now.Add(day)) {
+		t.Errorf("kept %v, want just the upcoming appointment", p.appointments)
+	}
+}